@@ -2,7 +2,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/nickheyer/distroface/pkg/api"
@@ -13,7 +12,7 @@ var Version = "dev"
 
 func main() {
 	if err := api.NewRootCmd(Version).Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		api.ReportError(err)
+		os.Exit(api.ExitCode(err))
 	}
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/migrations"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry/packed"
+	"github.com/nickheyer/distroface/pkg/config"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+func migrateUsage() {
+	fmt.Fprintf(os.Stderr, `usage: distroface migrate <command> [flags]
+
+commands:
+  status        list versioned migrations and whether each is applied
+  up            sync the baseline schema and apply pending migrations
+  down          roll back the newest applied migration
+  pack-links    move registry link files into the database (registry.pack_links)
+  unpack-links  write packed registry links back to files
+
+Stop the server before packing or unpacking links, and flip
+registry.pack_links to match before starting it again.
+
+Before starting an older release on this database, roll back to the newest
+migration that release knows: 'distroface migrate down --to <id>'.
+`)
+}
+
+// Schema management against the configured database, the server stays down
+func runMigrate(args []string) error {
+	if len(args) < 1 {
+		migrateUsage()
+		os.Exit(2)
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("migrate "+cmd, flag.ExitOnError)
+	to := fs.String("to", "", "migration id: up applies through it, down keeps it and reverts everything newer")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(".")
+	if err != nil {
+		return fmt.Errorf("loading configuration: %w", err)
+	}
+	store, err := stores.OpenSQLiteStore(cfg.Database.Path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	log := logger.New().Module("migrations")
+
+	switch cmd {
+	case "status":
+		return printMigrationStatus(store)
+	case "up":
+		if err := store.SyncSchema(); err != nil {
+			return err
+		}
+		return migrations.Up(store.DB(), log, *to)
+	case "down":
+		if *to != "" {
+			return migrations.RollbackTo(store.DB(), log, *to)
+		}
+		return migrations.RollbackLast(store.DB(), log)
+	case "pack-links", "unpack-links":
+		return moveRegistryLinks(cmd == "pack-links", cfg.Registry.StoragePath, store)
+	case "-h", "--help", "help":
+		migrateUsage()
+		return nil
+	default:
+		migrateUsage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func printMigrationStatus(store *stores.Store) error {
+	states, unknown, err := migrations.Status(store.DB())
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tKIND\tDOWN\tSTATE\tAPPLIED AT")
+	pending := 0
+	for _, s := range states {
+		state, at := "pending", "-"
+		if s.Applied {
+			state = "applied"
+			if !s.AppliedAt.IsZero() {
+				at = s.AppliedAt.Local().Format(time.DateTime)
+			}
+		} else {
+			pending++
+		}
+		down := "no"
+		if s.Reversible {
+			down = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Name, s.Kind, down, state, at)
+	}
+	for _, id := range unknown {
+		fmt.Fprintf(w, "%s\t?\t?\t?\tunknown\t-\n", id)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d applied, %d pending\n", len(states)-pending, pending)
+	if len(unknown) > 0 {
+		fmt.Printf("%d applied by a newer release, this release will not start until they are rolled back\n", len(unknown))
+	}
+	return nil
+}
+
+// Moves registry link files between disk and the database
+func moveRegistryLinks(pack bool, storagePath string, store *stores.Store) error {
+	if !store.DB().Migrator().HasTable(&db.PackedLink{}) {
+		return fmt.Errorf("the database lacks the packed link table, run 'distroface migrate up' first")
+	}
+	ctx := context.Background()
+	progress := func(n int) {
+		fmt.Printf("\r%d links", n)
+	}
+	start := time.Now()
+	var n int
+	var err error
+	if pack {
+		n, err = packed.Pack(ctx, storagePath, store, progress)
+	} else {
+		n, err = packed.Unpack(ctx, storagePath, store, progress)
+	}
+	if n > 0 {
+		fmt.Println()
+	}
+	if err != nil {
+		return fmt.Errorf("after %d links: %w", n, err)
+	}
+	verb, setting := "Packed", "true"
+	if !pack {
+		verb, setting = "Unpacked", "false"
+	}
+	fmt.Printf("%s %d links in %s, start the server with registry.pack_links: %s\n", verb, n, time.Since(start).Round(time.Millisecond), setting)
+	return nil
+}
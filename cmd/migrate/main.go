@@ -18,6 +18,7 @@ import (
 //	images    - push-replay images into v2 via the registry API (webhooks suppressed)
 //	artifacts - import v1 artifact repos into v2 (blobs, rows, properties)
 //	verify    - digest/tag/artifact parity report v1 vs v2
+//	status    - per layer progress of the image replay from its state file
 //	all       - users + orgs + images + artifacts + verify
 
 func bindFlags(fs *flag.FlagSet) *config.MigrateConfig {
@@ -33,6 +34,8 @@ func bindFlags(fs *flag.FlagSet) *config.MigrateConfig {
 	fs.StringVar(&cfg.LegacyNS, "legacy-ns", "legacy", "org namespace for flat v1 image names")
 	fs.BoolVar(&cfg.DryRun, "dry-run", false, "print planned actions without writing anything")
 	fs.IntVar(&cfg.Jobs, "jobs", 1, "concurrent repository pushes")
+	fs.IntVar(&cfg.Retries, "retries", 5, "retries per registry call on 429, 5xx or dropped connections")
+	fs.StringVar(&cfg.State, "state", "migrate-state.json", "per layer image replay record, resumed on rerun (empty keeps none)")
 	fs.BoolVar(&cfg.Verbose, "v", false, "verbose logging")
 	return cfg
 }
@@ -47,6 +50,7 @@ commands:
   images     push-replay v1 images into the v2 registry
   artifacts  import v1 artifact repos into v2 (blobs, rows, properties)
   verify     tag/digest/artifact parity report v1 vs v2
+  status     per layer progress of the image replay (-state)
   all        users + orgs + images + artifacts + verify
 
 run 'migrate <command> -h' for flags
@@ -86,6 +90,8 @@ func main() {
 		err = migrate.CmdArtifacts(ctx, cfg)
 	case "verify":
 		err = migrate.CmdVerify(ctx, cfg)
+	case "status":
+		err = migrate.CmdStatus(cfg.State)
 	case "all":
 		err = cmdAll(ctx, cfg)
 	case "-h", "--help", "help":
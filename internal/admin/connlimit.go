@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"net"
+	"sync"
+)
+
+// Caps concurrent connections per remote ip, trusted proxies exempt
+type connLimitListener struct {
+	net.Listener
+	max int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// Wraps ln so each ip holds at most max open conns, zero passes through
+func LimitConnsPerIP(ln net.Listener, max int) net.Listener {
+	if max <= 0 {
+		return ln
+	}
+	return &connLimitListener{Listener: ln, max: max, active: make(map[string]int)}
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := c.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		if isTrustedProxy(ip) {
+			return c, nil
+		}
+
+		l.mu.Lock()
+		if l.active[ip] >= l.max {
+			l.mu.Unlock()
+			// Refused at accept, the client sees a reset and retries
+			c.Close()
+			continue
+		}
+		l.active[ip]++
+		l.mu.Unlock()
+		return &limitedConn{Conn: c, release: func() { l.release(ip) }}, nil
+	}
+}
+
+func (l *connLimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip] <= 1 {
+		delete(l.active, ip)
+		return
+	}
+	l.active[ip]--
+}
+
+// Frees its slot exactly once however many times it is closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// Reports whether the peer is a configured trusted proxy
+func IsTrustedPeer(remoteAddr string) bool {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	return isTrustedProxy(ip)
+}
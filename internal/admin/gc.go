@@ -12,7 +12,6 @@ import (
 	"github.com/distribution/distribution/v3"
 	regstorage "github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
-	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
 	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
 )
@@ -37,13 +36,15 @@ type Collector struct {
 	storagePath string
 	log         *logger.Logger
 
-	mu      sync.Mutex
-	running bool
-	last    *Run
-	lastDue time.Time
+	mu       sync.Mutex
+	running  bool
+	last     *Run
+	lastDue  time.Time
+	afterRun func()
 }
 
-func NewCollector(storagePath string, log *logger.Logger) (*Collector, error) {
+// d must be the driver the registry app stores through
+func NewCollector(storagePath string, d driver.StorageDriver, log *logger.Logger) (*Collector, error) {
 	// Fresh installs lack the layout mark and sweep walks
 	base := filepath.Join(storagePath, "docker", "registry", "v2")
 	for _, dir := range []string{"repositories", filepath.Join("blobs", "sha256")} {
@@ -52,10 +53,6 @@ func NewCollector(storagePath string, log *logger.Logger) (*Collector, error) {
 		}
 	}
 
-	d := filesystem.New(filesystem.DriverParameters{
-		RootDirectory: storagePath,
-		MaxThreads:    100,
-	})
 	reg, err := regstorage.NewRegistry(context.Background(), d)
 	if err != nil {
 		return nil, fmt.Errorf("creating registry namespace: %w", err)
@@ -63,6 +60,11 @@ func NewCollector(storagePath string, log *logger.Logger) (*Collector, error) {
 	return &Collector{driver: d, registry: reg, storagePath: storagePath, log: log}, nil
 }
 
+// Called after every non dry run, set before the first run starts
+func (c *Collector) AfterRun(fn func()) {
+	c.afterRun = fn
+}
+
 // Start begins a background run rejecting overlap
 func (c *Collector) Start(dryRun, removeUntagged bool) error {
 	c.mu.Lock()
@@ -148,6 +150,10 @@ func (c *Collector) collect(dryRun, removeUntagged bool) {
 			run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond), run.BlobsDeleted, run.BytesFreed)
 	}
 
+	if !dryRun && c.afterRun != nil {
+		c.afterRun()
+	}
+
 	c.mu.Lock()
 	c.running = false
 	c.last = run
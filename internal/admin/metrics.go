@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// One series read at scrape time
+type gauge struct {
+	name   string
+	help   string
+	kind   string // gauge or counter
+	labels string
+	value  func() float64
+}
+
+// Live gauges over in memory state, served in the prometheus text format
+// so leaks show up as lines that only climb
+type Metrics struct {
+	mu     sync.Mutex
+	gauges []gauge
+}
+
+// Starts with go runtime gauges
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	m.Gauge("go_goroutines", "Number of goroutines that currently exist", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	m.Gauge("go_memstats_heap_inuse_bytes", "Heap bytes in use", func() float64 {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return float64(ms.HeapInuse)
+	})
+	return m
+}
+
+// Registers a gauge, labels are key value pairs
+func (m *Metrics) Gauge(name, help string, value func() float64, labels ...string) {
+	m.add("gauge", name, help, value, labels)
+}
+
+// Registers a counter, value must only ever grow
+func (m *Metrics) Counter(name, help string, value func() float64, labels ...string) {
+	m.add("counter", name, help, value, labels)
+}
+
+func (m *Metrics) add(kind, name, help string, value func() float64, labels []string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(labels[i+1])))
+	}
+	g := gauge{name: name, help: help, kind: kind, value: value}
+	if len(pairs) > 0 {
+		g.labels = "{" + strings.Join(pairs, ",") + "}"
+	}
+	m.mu.Lock()
+	m.gauges = append(m.gauges, g)
+	m.mu.Unlock()
+}
+
+// Scrapes are limited to trusted peers, the same set trusted as proxies
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !IsTrustedPeer(r.RemoteAddr) {
+		http.NotFound(w, r)
+		return
+	}
+	m.mu.Lock()
+	gauges := append([]gauge(nil), m.gauges...)
+	m.mu.Unlock()
+	sort.SliceStable(gauges, func(i, j int) bool { return gauges[i].name < gauges[j].name })
+
+	var b strings.Builder
+	for i, g := range gauges {
+		if i == 0 || gauges[i-1].name != g.name {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", g.name, g.help, g.name, g.kind)
+		}
+		fmt.Fprintf(&b, "%s%s %s\n", g.name, g.labels, strconv.FormatFloat(g.value(), 'g', -1, 64))
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte(b.String()))
+}
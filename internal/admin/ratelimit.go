@@ -14,6 +14,7 @@ type Limiter struct {
 	mu     sync.Mutex
 	limits func() (int, time.Duration)
 	events map[string][]time.Time
+	record func()
 
 	lastPrune time.Time
 }
@@ -61,8 +62,14 @@ func (l *Limiter) Take(key string) (allowed bool, remaining int, resetAt time.Ti
 	return true, limit - len(kept), kept[0].Add(window)
 }
 
+// Calls fn on every Record, also while limiting is off. Set before use
+func (l *Limiter) OnRecord(fn func()) { l.record = fn }
+
 // Count failure without checking quota
 func (l *Limiter) Record(key string) {
+	if l.record != nil {
+		l.record()
+	}
 	now := time.Now()
 	limit, window := l.limits()
 	if limit <= 0 {
@@ -95,6 +102,24 @@ func (l *Limiter) Blocked(key string) bool {
 	return len(kept) >= limit
 }
 
+// Events counted for key and when the oldest leaves the window, without counting one
+func (l *Limiter) Peek(key string) (used int, resetAt time.Time) {
+	now := time.Now()
+	limit, window := l.limits()
+	if limit <= 0 {
+		return 0, time.Time{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kept := l.trimKeyLocked(key, now, window)
+	if len(kept) == 0 {
+		return 0, time.Time{}
+	}
+	return len(kept), kept[0].Add(window)
+}
+
 // Wipe events for key after good login
 func (l *Limiter) Reset(key string) {
 	l.mu.Lock()
@@ -102,6 +127,13 @@ func (l *Limiter) Reset(key string) {
 	delete(l.events, key)
 }
 
+// Keys holding events, pruning keeps this bounded by the window
+func (l *Limiter) Keys() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.events)
+}
+
 // Drop old events for one key
 func (l *Limiter) trimKeyLocked(key string, now time.Time, window time.Duration) []time.Time {
 	cutoff := now.Add(-window)
@@ -0,0 +1,216 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry/packed"
+)
+
+// Storage problem kinds shared by registry and artifact verification
+const (
+	ProblemCorrupt    = "corrupt"
+	ProblemMissing    = "missing"
+	ProblemUnreadable = "unreadable"
+)
+
+// One blob that failed verification
+type BlobProblem struct {
+	Digest string
+	Kind   string
+	Detail string
+}
+
+func registryBase(storagePath string) string {
+	return filepath.Join(storagePath, "docker", "registry", "v2")
+}
+
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// Removes registry upload sessions idle past maxAge, dry runs only count
+func PruneRegistryUploads(storagePath string, maxAge time.Duration, dryRun bool) (int, int64, error) {
+	root := filepath.Join(registryBase(storagePath), "repositories")
+	cutoff := time.Now().Add(-maxAge)
+	sessions := 0
+	var freed int64
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case "_layers", "_manifests":
+			return fs.SkipDir
+		case "_uploads":
+		default:
+			return nil
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil || !info.ModTime().Before(cutoff) {
+				continue
+			}
+			// Appends touch data, not the session dir
+			session := filepath.Join(path, e.Name())
+			if data, err := os.Stat(filepath.Join(session, "data")); err == nil && !data.ModTime().Before(cutoff) {
+				continue
+			}
+			size := dirSize(session)
+			if !dryRun {
+				if err := os.RemoveAll(session); err != nil {
+					continue
+				}
+			}
+			sessions++
+			freed += size
+		}
+		return fs.SkipDir
+	})
+	return sessions, freed, err
+}
+
+// Hex digests and sizes of every registry blob on disk
+func registryBlobs(storagePath string) (map[string]int64, error) {
+	blobDir := filepath.Join(registryBase(storagePath), "blobs", "sha256")
+	blobs := map[string]int64{}
+	shards, err := os.ReadDir(blobDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return blobs, nil
+		}
+		return nil, err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		digests, err := os.ReadDir(filepath.Join(blobDir, shard.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range digests {
+			if info, err := os.Stat(filepath.Join(blobDir, shard.Name(), d.Name(), "data")); err == nil {
+				blobs[d.Name()] = info.Size()
+			}
+		}
+	}
+	return blobs, nil
+}
+
+// Rehashes every registry blob, then flags layer links whose blob is gone.
+// progress gets running totals, problem each bad blob as it is found.
+// Layer links packed into packedLinks are checked too when it is set
+func VerifyRegistry(ctx context.Context, storagePath string, packedLinks *stores.Store, progress func(checked, total, bytes int64), problem func(BlobProblem)) error {
+	blobs, err := registryBlobs(storagePath)
+	if err != nil {
+		return err
+	}
+	blobDir := filepath.Join(registryBase(storagePath), "blobs", "sha256")
+	total := int64(len(blobs))
+	var checked, bytes int64
+
+	for hexDigest, size := range blobs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		digest := "sha256:" + hexDigest
+		sum, err := hashFile(filepath.Join(blobDir, hexDigest[:2], hexDigest, "data"))
+		switch {
+		case err != nil:
+			problem(BlobProblem{Digest: digest, Kind: ProblemUnreadable, Detail: err.Error()})
+		case sum != hexDigest:
+			problem(BlobProblem{Digest: digest, Kind: ProblemCorrupt, Detail: "content hashes to sha256:" + sum})
+		}
+		checked++
+		bytes += size
+		progress(checked, total, bytes)
+	}
+
+	// Dangling layer links break pulls of every image using them
+	if packedLinks != nil {
+		links, err := packed.RepoLinks(ctx, packedLinks)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if _, found := blobs[l.Hex]; !found && !l.Manifest {
+				problem(BlobProblem{Digest: "sha256:" + l.Hex, Kind: ProblemMissing, Detail: l.Repo})
+			}
+		}
+	}
+	root := filepath.Join(registryBase(storagePath), "repositories")
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "_uploads" || d.Name() == "_manifests" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "link" || !strings.Contains(path, string(filepath.Separator)+"_layers"+string(filepath.Separator)) {
+			return nil
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		hexDigest, ok := strings.CutPrefix(strings.TrimSpace(string(raw)), "sha256:")
+		if !ok {
+			return nil
+		}
+		if _, found := blobs[hexDigest]; !found {
+			rel, _ := filepath.Rel(root, path)
+			repo, _, _ := strings.Cut(filepath.ToSlash(rel), "/_layers/")
+			problem(BlobProblem{Digest: "sha256:" + hexDigest, Kind: ProblemMissing, Detail: repo})
+		}
+		return nil
+	})
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,226 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+const (
+	storageProbeInterval = 15 * time.Second
+	storageProbeTimeout  = 10 * time.Second
+	// Calls slower than this count as failures, a stalled mount answers late or never
+	storageSlowCall = 30 * time.Second
+	// Consecutive failed calls or probes that open the breaker
+	storageFailureThreshold = 3
+)
+
+// Uploads refused while the breaker of their backend is open. Maps to 503
+// or Unavailable
+var ErrStorageUnavailable = errors.New("storage backend is degraded, retry later")
+
+// Round trip of a small write, read and delete against a backend
+type StorageProbe func(ctx context.Context) error
+
+// Counters of one class of backend calls
+type StorageOpStats struct {
+	Calls   uint64
+	Errors  uint64
+	Seconds float64 // Summed latency
+}
+
+// Point in time view for metrics and the health check
+type StorageStatus struct {
+	Backend      string
+	Open         bool // Breaker open, uploads fail fast
+	LastError    string
+	LastProbe    time.Time
+	ProbeLatency time.Duration
+	Reads        StorageOpStats
+	Writes       StorageOpStats
+}
+
+// Health of one storage backend fed by its live calls and a periodic
+// probe. Consecutive failures or calls slower than storageSlowCall open a
+// breaker that fails uploads fast instead of leaving clients hanging on a
+// stuck backend, only a passing probe closes it again
+type StorageHealth struct {
+	backend string
+	log     *logger.Logger
+
+	mu       sync.Mutex
+	probe    StorageProbe
+	probing  bool // A probe still running, a hung one is not stacked on
+	failures int
+	status   StorageStatus
+}
+
+func NewStorageHealth(backend string, probe StorageProbe, log *logger.Logger) *StorageHealth {
+	return &StorageHealth{backend: backend, probe: probe, log: log, status: StorageStatus{Backend: backend}}
+}
+
+// Sets the probe once the backend exists, like a registry driver built by the app
+func (h *StorageHealth) UseProbe(probe StorageProbe) {
+	h.mu.Lock()
+	h.probe = probe
+	h.mu.Unlock()
+}
+
+func (h *StorageHealth) Backend() string { return h.backend }
+
+// Next probe, when a refused client may try again
+func (h *StorageHealth) RetryAfter() time.Duration { return storageProbeInterval }
+
+// Nil while the breaker is closed
+func (h *StorageHealth) Available() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.status.Open {
+		return ErrStorageUnavailable
+	}
+	return nil
+}
+
+// Records one backend call, not found and cancelled calls are the caller's
+// business and passed in as a nil err
+func (h *StorageHealth) Observe(write bool, took time.Duration, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	op := &h.status.Reads
+	if write {
+		op = &h.status.Writes
+	}
+	op.Calls++
+	op.Seconds += took.Seconds()
+	if err == nil && took > storageSlowCall {
+		err = errors.New("call took " + took.Round(time.Second).String())
+	}
+	if err != nil {
+		op.Errors++
+		h.failLocked(err)
+		return
+	}
+	// Live successes never close an open breaker, the probe checks writes
+	if !h.status.Open {
+		h.failures = 0
+	}
+}
+
+func (h *StorageHealth) failLocked(err error) {
+	h.failures++
+	h.status.LastError = err.Error()
+	if !h.status.Open && h.failures >= storageFailureThreshold {
+		h.status.Open = true
+		h.log.Error("storage %s degraded after %d failures, failing uploads fast: %v", h.backend, h.failures, err)
+	}
+}
+
+// Runs the probe now, returns its error
+func (h *StorageHealth) Probe(ctx context.Context) error {
+	h.mu.Lock()
+	probe := h.probe
+	if probe == nil {
+		h.mu.Unlock()
+		return nil
+	}
+	if h.probing {
+		err := errors.New("previous probe still running")
+		h.failLocked(err)
+		h.mu.Unlock()
+		return err
+	}
+	h.probing = true
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, storageProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		err := probe(ctx)
+		h.mu.Lock()
+		h.probing = false
+		h.mu.Unlock()
+		done <- err
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.New("probe timed out after " + storageProbeTimeout.String())
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.LastProbe = time.Now()
+	h.status.ProbeLatency = time.Since(start)
+	if err != nil {
+		h.failLocked(err)
+		return err
+	}
+	h.failures = 0
+	if h.status.Open {
+		h.status.Open = false
+		h.log.Info("storage %s recovered, probe took %s", h.backend, h.status.ProbeLatency.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// Probes every storageProbeInterval until ctx ends
+func (h *StorageHealth) Schedule(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(storageProbeInterval)
+		defer ticker.Stop()
+		for {
+			h.Probe(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (h *StorageHealth) Status() StorageStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// Up, breaker, probe latency and call counters of each backend
+func (m *Metrics) StorageHealth(h *StorageHealth) {
+	b := h.Backend()
+	m.Gauge("distroface_storage_up", "One while the storage backend's breaker is closed", func() float64 {
+		if h.Status().Open {
+			return 0
+		}
+		return 1
+	}, "backend", b)
+	m.Gauge("distroface_storage_probe_seconds", "Latency of the last storage health probe", func() float64 {
+		return h.Status().ProbeLatency.Seconds()
+	}, "backend", b)
+	for op, pick := range map[string]func(StorageStatus) StorageOpStats{
+		"read":  func(s StorageStatus) StorageOpStats { return s.Reads },
+		"write": func(s StorageStatus) StorageOpStats { return s.Writes },
+	} {
+		m.Counter("distroface_storage_calls_total", "Storage backend calls", func() float64 {
+			return float64(pick(h.Status()).Calls)
+		}, "backend", b, "op", op)
+		m.Counter("distroface_storage_errors_total", "Storage backend calls that failed or ran slow", func() float64 {
+			return float64(pick(h.Status()).Errors)
+		}, "backend", b, "op", op)
+		m.Counter("distroface_storage_call_seconds_total", "Summed latency of storage backend calls", func() float64 {
+			return pick(h.Status()).Seconds
+		}, "backend", b, "op", op)
+	}
+}
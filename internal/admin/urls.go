@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Scheme, host and path prefix the client used to reach us. Forwarded
+// headers only count when the peer is a trusted proxy.
+func RequestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host, prefix := r.Host, ""
+	if IsTrustedPeer(r.RemoteAddr) {
+		if v := strings.ToLower(firstForwarded(r.Header.Get("X-Forwarded-Proto"))); v == "http" || v == "https" {
+			scheme = v
+		}
+		if v := firstForwarded(r.Header.Get("X-Forwarded-Host")); v != "" {
+			host = v
+		}
+		if v := firstForwarded(r.Header.Get("X-Forwarded-Prefix")); strings.HasPrefix(v, "/") {
+			prefix = strings.TrimRight(v, "/")
+		}
+	}
+	return scheme + "://" + host + prefix
+}
+
+// Proxies chaining headers append, the leftmost value is the client facing one
+func firstForwarded(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
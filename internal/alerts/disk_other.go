@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package alerts
+
+import "errors"
+
+func diskUsage(string) (uint64, uint64, error) {
+	return 0, 0, errors.New("volume usage is not supported on this platform")
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package alerts
+
+import "syscall"
+
+// Used and usable bytes of the volume holding path, reserved blocks
+// count as neither so the percentage matches df
+func diskUsage(path string) (used, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(st.Bsize)
+	used = (st.Blocks - st.Bfree) * bsize
+	return used, used + st.Bavail*bsize, nil
+}
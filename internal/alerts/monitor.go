@@ -0,0 +1,289 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/notify/channel"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/webhook"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Rule names, also what alerts are reported and notified under
+const (
+	RuleStorage        = "storage"
+	RuleFailedLogins   = "failed_logins"
+	RuleUploadFailures = "upload_failures"
+	RuleEgress         = "egress"
+)
+
+// How often the monitor checks whether an evaluation is due
+const tick = 10 * time.Second
+
+// One enabled rule as of the last evaluation
+type Alert struct {
+	Rule        string
+	Firing      bool
+	Value       float64
+	Threshold   float64
+	Message     string
+	Since       time.Time // When the rule entered its current state
+	EvaluatedAt time.Time
+}
+
+// Measurement of one rule, skipped rules keep their last state
+type reading struct {
+	rule      string
+	value     float64
+	threshold float64
+	firing    bool
+	message   string
+}
+
+// Evaluates the alert rules on the configured interval and notifies the
+// alert webhook and mail recipients when a rule starts or stops firing
+type Monitor struct {
+	res     *settings.Resolver
+	signals *Signals
+	hooks   *webhook.Dispatcher // Nil sends no webhooks
+	hub     *channel.Hub        // Nil routes alerts to no notification channels
+	paths   []string
+	log     *logger.Logger
+
+	usage func(path string) (used, total uint64, err error)
+	mail  func(cfg *v1.AlertEmailSettings, subject, body string) error
+
+	mu     sync.Mutex
+	alerts map[string]*Alert
+	last   counts
+	lastAt time.Time
+}
+
+// Paths are the data directories whose volumes the storage rule watches
+func NewMonitor(res *settings.Resolver, signals *Signals, hooks *webhook.Dispatcher, paths []string, log *logger.Logger) *Monitor {
+	return &Monitor{
+		res:     res,
+		signals: signals,
+		hooks:   hooks,
+		paths:   paths,
+		log:     log,
+		usage:   diskUsage,
+		mail:    sendMail,
+		alerts:  map[string]*Alert{},
+	}
+}
+
+// Also routes firing and resolved alerts to the notification channels
+func (m *Monitor) UseChannels(hub *channel.Hub) {
+	m.hub = hub
+}
+
+// Runs evaluations in the background until ctx is done
+func (m *Monitor) Schedule(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cfg := m.res.System(ctx).GetAlerts()
+				if !cfg.GetEnabled() {
+					m.reset()
+					continue
+				}
+				m.mu.Lock()
+				due := time.Since(m.lastAt) >= interval(cfg)
+				m.mu.Unlock()
+				if due {
+					m.evaluate(ctx, time.Now())
+				}
+			}
+		}
+	}()
+}
+
+func interval(cfg *v1.AlertSettings) time.Duration {
+	if d := time.Duration(cfg.GetIntervalSeconds()) * time.Second; d > 0 {
+		return d
+	}
+	return time.Minute
+}
+
+// Forgets all state so re-enabling starts from a clean baseline
+func (m *Monitor) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alerts = map[string]*Alert{}
+	m.lastAt = time.Time{}
+}
+
+// Every enabled rule, firing ones first
+func (m *Monitor) Alerts() []Alert {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	out := make([]Alert, 0, len(m.alerts))
+	for _, a := range m.alerts {
+		out = append(out, *a)
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Firing != out[j].Firing {
+			return out[i].Firing
+		}
+		return out[i].Rule < out[j].Rule
+	})
+	return out
+}
+
+// Rate rules compare against the previous evaluation, so the first pass
+// after start or enable only records a baseline for them
+func (m *Monitor) evaluate(ctx context.Context, now time.Time) {
+	sys := m.res.System(ctx)
+	cfg := sys.GetAlerts()
+	cur := m.signals.snapshot()
+
+	m.mu.Lock()
+	prev, prevAt := m.last, m.lastAt
+	m.last, m.lastAt = cur, now
+	m.mu.Unlock()
+
+	enabled := map[string]bool{
+		RuleStorage:        cfg.GetStoragePercent() > 0,
+		RuleFailedLogins:   cfg.GetFailedLoginsPerMinute() > 0,
+		RuleUploadFailures: cfg.GetUploadFailurePercent() > 0,
+		RuleEgress:         cfg.GetEgressBytesPerSecond() > 0,
+	}
+	var readings []reading
+	if enabled[RuleStorage] {
+		if r, ok := m.storage(cfg); ok {
+			readings = append(readings, r)
+		}
+	}
+	if !prevAt.IsZero() {
+		readings = append(readings, rates(cfg, enabled, prev, cur, now.Sub(prevAt))...)
+	}
+
+	var changed []Alert
+	m.mu.Lock()
+	for rule, on := range enabled {
+		if !on {
+			delete(m.alerts, rule)
+		}
+	}
+	for _, r := range readings {
+		a, seen := m.alerts[r.rule]
+		if !seen {
+			a = &Alert{Rule: r.rule, Since: now}
+			m.alerts[r.rule] = a
+		}
+		flipped := a.Firing != r.firing
+		if flipped {
+			a.Since = now
+		}
+		a.Firing = r.firing
+		a.Value = r.value
+		a.Threshold = r.threshold
+		a.Message = r.message
+		a.EvaluatedAt = now
+		// A rule first seen healthy has nothing to resolve
+		if flipped && (seen || r.firing) {
+			changed = append(changed, *a)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, a := range changed {
+		if a.Firing {
+			m.log.Warn("Alert %s firing: %s", a.Rule, a.Message)
+		} else {
+			m.log.Info("Alert %s resolved: %s", a.Rule, a.Message)
+		}
+		m.notify(cfg, sys.GetServer().GetPublicHostname(), a)
+	}
+}
+
+// Fullest volume among the data paths
+func (m *Monitor) storage(cfg *v1.AlertSettings) (reading, bool) {
+	worst, worstPath := -1.0, ""
+	for _, p := range m.paths {
+		used, total, err := m.usage(p)
+		if err != nil {
+			m.log.Warn("Alert storage check of %s failed: %v", p, err)
+			continue
+		}
+		if total == 0 {
+			continue
+		}
+		if pct := float64(used) / float64(total) * 100; pct > worst {
+			worst, worstPath = pct, p
+		}
+	}
+	if worstPath == "" {
+		return reading{}, false
+	}
+	threshold := float64(cfg.GetStoragePercent())
+	return reading{
+		rule:      RuleStorage,
+		value:     worst,
+		threshold: threshold,
+		firing:    worst >= threshold,
+		message:   fmt.Sprintf("volume holding %s is %.1f%% full", worstPath, worst),
+	}, true
+}
+
+// Counter rules averaged over the time since the previous evaluation
+func rates(cfg *v1.AlertSettings, enabled map[string]bool, prev, cur counts, elapsed time.Duration) []reading {
+	if elapsed <= 0 {
+		return nil
+	}
+	var out []reading
+	if enabled[RuleFailedLogins] {
+		perMinute := float64(cur.loginFailures-prev.loginFailures) / elapsed.Minutes()
+		threshold := float64(cfg.GetFailedLoginsPerMinute())
+		out = append(out, reading{
+			rule:      RuleFailedLogins,
+			value:     perMinute,
+			threshold: threshold,
+			firing:    perMinute >= threshold,
+			message:   fmt.Sprintf("%.1f failed logins per minute", perMinute),
+		})
+	}
+	if enabled[RuleUploadFailures] {
+		uploads := cur.uploads - prev.uploads
+		failed := cur.uploadFailures - prev.uploadFailures
+		var pct float64
+		if uploads > 0 {
+			pct = float64(failed) / float64(uploads) * 100
+		}
+		threshold := float64(cfg.GetUploadFailurePercent())
+		out = append(out, reading{
+			rule:      RuleUploadFailures,
+			value:     pct,
+			threshold: threshold,
+			// Too few uploads to call a rate, one failure out of two is noise
+			firing:  uploads > 0 && uploads >= int64(cfg.GetUploadMinSamples()) && pct >= threshold,
+			message: fmt.Sprintf("%d of %d uploads failed (%.1f%%)", failed, uploads, pct),
+		})
+	}
+	if enabled[RuleEgress] {
+		perSecond := float64(cur.egressBytes-prev.egressBytes) / elapsed.Seconds()
+		threshold := float64(cfg.GetEgressBytesPerSecond())
+		out = append(out, reading{
+			rule:      RuleEgress,
+			value:     perSecond,
+			threshold: threshold,
+			firing:    perSecond >= threshold,
+			message:   fmt.Sprintf("%.2f MB/s sent on average", perSecond/1e6),
+		})
+	}
+	return out
+}
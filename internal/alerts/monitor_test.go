@@ -0,0 +1,170 @@
+package alerts
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+type sentMail struct {
+	mu       sync.Mutex
+	subjects []string
+}
+
+func (s *sentMail) send(_ *v1.AlertEmailSettings, subject, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subjects = append(s.subjects, subject)
+	return nil
+}
+
+func (s *sentMail) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subjects)
+}
+
+func newTestMonitor(t *testing.T, cfg *v1.AlertSettings) (*Monitor, *Signals, *sentMail) {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	res := settings.NewResolver(store, nil)
+	if err := res.SeedSystem(context.Background(), &v1.Settings{Alerts: cfg}); err != nil {
+		t.Fatalf("SeedSystem: %v", err)
+	}
+	signals := NewSignals()
+	m := NewMonitor(res, signals, nil, []string{"/data"}, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	mail := &sentMail{}
+	m.mail = mail.send
+	return m, signals, mail
+}
+
+func waitMail(t *testing.T, mail *sentMail, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for mail.count() < want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := mail.count(); got != want {
+		t.Fatalf("sent %d mails, want %d", got, want)
+	}
+}
+
+func alertFor(m *Monitor, rule string) (Alert, bool) {
+	for _, a := range m.Alerts() {
+		if a.Rule == rule {
+			return a, true
+		}
+	}
+	return Alert{}, false
+}
+
+// Storage fires at the threshold and resolves once space frees up, with
+// one mail per transition rather than one per evaluation
+func TestMonitorStorageTransitions(t *testing.T) {
+	m, _, mail := newTestMonitor(t, &v1.AlertSettings{
+		Enabled:        proto.Bool(true),
+		StoragePercent: proto.Int32(90),
+		Email: &v1.AlertEmailSettings{
+			SmtpAddr: proto.String("mail.example.com:587"),
+			To:       []string{"ops@example.com"},
+		},
+	})
+	used := uint64(50)
+	m.usage = func(string) (uint64, uint64, error) { return used, 100, nil }
+	ctx := context.Background()
+	now := time.Now()
+
+	m.evaluate(ctx, now)
+	if a, ok := alertFor(m, RuleStorage); !ok || a.Firing {
+		t.Fatalf("storage at 50%% = %+v, want a healthy rule", a)
+	}
+
+	used = 95
+	m.evaluate(ctx, now.Add(time.Minute))
+	m.evaluate(ctx, now.Add(2*time.Minute))
+	a, _ := alertFor(m, RuleStorage)
+	if !a.Firing || a.Value != 95 || !a.Since.Equal(now.Add(time.Minute)) {
+		t.Fatalf("storage at 95%% = %+v, want firing since the first breach", a)
+	}
+	waitMail(t, mail, 1)
+
+	used = 40
+	m.evaluate(ctx, now.Add(3*time.Minute))
+	if a, _ := alertFor(m, RuleStorage); a.Firing {
+		t.Fatalf("storage at 40%% still firing")
+	}
+	waitMail(t, mail, 2)
+}
+
+// Rate rules average the counters over the time between evaluations
+func TestMonitorRates(t *testing.T) {
+	m, signals, _ := newTestMonitor(t, &v1.AlertSettings{
+		Enabled:               proto.Bool(true),
+		FailedLoginsPerMinute: proto.Int32(10),
+		UploadFailurePercent:  proto.Int32(20),
+		UploadMinSamples:      proto.Int32(5),
+		EgressBytesPerSecond:  proto.Int64(1000),
+	})
+	ctx := context.Background()
+	now := time.Now()
+
+	// First pass only records the baseline
+	m.evaluate(ctx, now)
+	if got := m.Alerts(); len(got) != 0 {
+		t.Fatalf("baseline pass reported %+v", got)
+	}
+
+	for range 30 {
+		signals.LoginFailed()
+	}
+	signals.Upload(true)
+	signals.Upload(false)
+	signals.Sent(60 * 500)
+	m.evaluate(ctx, now.Add(2*time.Minute))
+
+	if a, _ := alertFor(m, RuleFailedLogins); !a.Firing || a.Value != 15 {
+		t.Errorf("30 failures over 2m = %+v, want firing at 15/min", a)
+	}
+	// Half failed, but two uploads are below the sample floor
+	if a, _ := alertFor(m, RuleUploadFailures); a.Firing || a.Value != 50 {
+		t.Errorf("1 of 2 uploads failed = %+v, want 50%% without firing", a)
+	}
+	if a, _ := alertFor(m, RuleEgress); a.Firing || a.Value != 250 {
+		t.Errorf("30000 bytes over 2m = %+v, want 250 B/s below threshold", a)
+	}
+
+	for range 5 {
+		signals.Upload(true)
+	}
+	m.evaluate(ctx, now.Add(3*time.Minute))
+	if a, _ := alertFor(m, RuleUploadFailures); !a.Firing {
+		t.Errorf("5 of 5 uploads failed = %+v, want firing", a)
+	}
+	if a, _ := alertFor(m, RuleFailedLogins); a.Firing {
+		t.Errorf("quiet minute still firing failed logins")
+	}
+}
+
+// Nil signals are how subsystems run without a monitor
+func TestNilSignals(t *testing.T) {
+	var s *Signals
+	s.LoginFailed()
+	s.Upload(true)
+	s.Sent(10)
+	if s.snapshot() != (counts{}) {
+		t.Fatal("nil signals counted")
+	}
+}
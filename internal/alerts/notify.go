@@ -0,0 +1,85 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/mail"
+	"github.com/nickheyer/distroface/internal/notify/channel"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+const (
+	EventFiring   = "alert.firing"
+	EventResolved = "alert.resolved"
+)
+
+// Body posted to the alert webhook
+type Event struct {
+	Event     string  `json:"event"`
+	Timestamp string  `json:"timestamp"`
+	Instance  string  `json:"instance"`
+	Rule      string  `json:"rule"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Message   string  `json:"message"`
+}
+
+func (m *Monitor) notify(cfg *v1.AlertSettings, instance string, a Alert) {
+	ev := Event{
+		Event:     EventResolved,
+		Timestamp: a.Since.UTC().Format(time.RFC3339),
+		Instance:  instance,
+		Rule:      a.Rule,
+		Value:     a.Value,
+		Threshold: a.Threshold,
+		Message:   a.Message,
+	}
+	if a.Firing {
+		ev.Event = EventFiring
+	}
+	if m.hooks != nil {
+		m.hooks.Notify(cfg.GetWebhookUrl(), cfg.GetWebhookSecret(), ev.Event, ev)
+	}
+
+	state := "resolved"
+	if a.Firing {
+		state = "FIRING"
+	}
+	subject := fmt.Sprintf("[distroface %s] %s alert %s", instance, a.Rule, state)
+	m.hub.Publish(context.Background(), channel.Message{
+		Event:     ev.Event,
+		Timestamp: a.Since.UTC(),
+		Subject:   subject,
+		Text:      a.Message,
+		Fields: []channel.Field{
+			{Name: "Rule", Value: a.Rule},
+			{Name: "Value", Value: fmt.Sprintf("%.2f", a.Value)},
+			{Name: "Threshold", Value: fmt.Sprintf("%.2f", a.Threshold)},
+			{Name: "Since", Value: ev.Timestamp},
+		},
+		Data: ev,
+	})
+
+	email := cfg.GetEmail()
+	if email.GetSmtpAddr() == "" || len(email.GetTo()) == 0 {
+		return
+	}
+	body := fmt.Sprintf("%s\r\n\r\nRule: %s\r\nValue: %.2f\r\nThreshold: %.2f\r\nSince: %s\r\n",
+		a.Message, a.Rule, a.Value, a.Threshold, ev.Timestamp)
+	go func() {
+		if err := m.mail(email, subject, body); err != nil {
+			m.log.Error("Alert mail for %s failed: %v", a.Rule, err)
+		}
+	}()
+}
+
+func sendMail(cfg *v1.AlertEmailSettings, subject, body string) error {
+	return mail.Send(mail.Transport{
+		Addr:     cfg.GetSmtpAddr(),
+		Username: cfg.GetUsername(),
+		Password: cfg.GetPassword(),
+		From:     cfg.GetFrom(),
+	}, cfg.GetTo(), subject, body)
+}
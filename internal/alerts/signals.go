@@ -0,0 +1,98 @@
+package alerts
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Running totals fed from request paths, the monitor reads them as deltas
+// between evaluations. Nil signals drop every event
+type Signals struct {
+	loginFailures  atomic.Int64
+	uploads        atomic.Int64
+	uploadFailures atomic.Int64
+	egressBytes    atomic.Int64
+}
+
+func NewSignals() *Signals {
+	return &Signals{}
+}
+
+// One rejected credential
+func (s *Signals) LoginFailed() {
+	if s == nil {
+		return
+	}
+	s.loginFailures.Add(1)
+}
+
+// One finished image or artifact upload
+func (s *Signals) Upload(failed bool) {
+	if s == nil {
+		return
+	}
+	s.uploads.Add(1)
+	if failed {
+		s.uploadFailures.Add(1)
+	}
+}
+
+// Bytes written back to clients
+func (s *Signals) Sent(n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.egressBytes.Add(int64(n))
+}
+
+type counts struct {
+	loginFailures  int64
+	uploads        int64
+	uploadFailures int64
+	egressBytes    int64
+}
+
+func (s *Signals) snapshot() counts {
+	if s == nil {
+		return counts{}
+	}
+	return counts{
+		loginFailures:  s.loginFailures.Load(),
+		uploads:        s.uploads.Load(),
+		uploadFailures: s.uploadFailures.Load(),
+		egressBytes:    s.egressBytes.Load(),
+	}
+}
+
+// Wraps ln so every byte written to an accepted conn counts as egress,
+// below tls so the figure is what actually crosses the wire
+func (s *Signals) CountEgress(ln net.Listener) net.Listener {
+	if s == nil {
+		return ln
+	}
+	return &egressListener{Listener: ln, signals: s}
+}
+
+type egressListener struct {
+	net.Listener
+	signals *Signals
+}
+
+func (l *egressListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &egressConn{Conn: c, signals: l.signals}, nil
+}
+
+type egressConn struct {
+	net.Conn
+	signals *Signals
+}
+
+func (c *egressConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.signals.Sent(n)
+	return n, err
+}
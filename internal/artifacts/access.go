@@ -8,19 +8,21 @@ import (
 	"github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
 )
 
 // Shared artifact repo access rules for the v1 facade and RPC service
 type Access struct {
 	store    *stores.Store
 	enforcer *rbac.Enforcer
+	res      *settings.Resolver
 }
 
-func NewAccess(store *stores.Store, enforcer *rbac.Enforcer) *Access {
-	return &Access{store: store, enforcer: enforcer}
+func NewAccess(store *stores.Store, enforcer *rbac.Enforcer, res *settings.Resolver) *Access {
+	return &Access{store: store, enforcer: enforcer, res: res}
 }
 
-// Owner, manage permission, org membership, or scoped grant
+// Owner, manage permission, org membership, scoped grant, or repo ACL
 func (a *Access) HasRepoAccess(ctx context.Context, user *auth.AuthenticatedUser, repo *db.ArtifactRepository, action string) bool {
 	if user == nil {
 		return false
@@ -42,12 +44,30 @@ func (a *Access) HasRepoAccess(ctx context.Context, user *auth.AuthenticatedUser
 			return role == db.OrgRoleOwner || role == db.OrgRoleAdmin
 		}
 	}
-	return slices.Contains(a.enforcer.GetGrantedObjects(user.Roles, rbac.ResourceArtifacts, action), repo.Namespace+"/"+repo.Name)
+	if slices.Contains(a.enforcer.GetGrantedObjects(user.Roles, rbac.ResourceArtifacts, action), repo.Namespace+"/"+repo.Name) {
+		return true
+	}
+	levels, _ := a.store.RepositoryPermissionLevels(ctx, repo.Namespace, repo.Name, user.ID, user.Roles)
+	return rbac.LevelsAllow(levels, action)
 }
 
-// Public repos or any read grant
+// Casbin grant for action on the repo, the role check the v1 facade runs
+// before it looks at visibility
+func (a *Access) Allowed(user *auth.AuthenticatedUser, repo *db.ArtifactRepository, action string) bool {
+	if user == nil {
+		return false
+	}
+	allowed, _ := a.enforcer.Enforce(user.Roles, rbac.ResourceArtifacts, action, repo.Namespace+"/"+repo.Name)
+	return allowed
+}
+
+// Public repos or any read grant, anonymous callers only where the org
+// and repo tiers leave anonymous access on
 func (a *Access) CanSee(ctx context.Context, user *auth.AuthenticatedUser, repo *db.ArtifactRepository) bool {
-	return !repo.IsPrivate || a.HasRepoAccess(ctx, user, repo, rbac.ActionRead)
+	if repo.IsPrivate {
+		return a.HasRepoAccess(ctx, user, repo, rbac.ActionRead)
+	}
+	return !auth.IsAnonymous(user) || a.res == nil || a.res.AnonymousReadable(ctx, repo.Namespace, repo.Name)
 }
 
 // Owner username, org membership, or manage into an existing namespace
@@ -71,14 +91,26 @@ func (a *Access) CanCreateInNamespace(ctx context.Context, user *auth.Authentica
 	return org != nil
 }
 
+// Reserved namespaces take new repos only when an artifacts manager asks
+// to override, anything else gets the settings.ErrReservedName
+func (a *Access) CheckNewNamespace(ctx context.Context, user *auth.AuthenticatedUser, namespace string, override bool) error {
+	err := a.res.CheckNamespace(ctx, namespace)
+	if err != nil && override && user != nil && a.enforcer.HasPermission(user.Roles, rbac.ResourceArtifacts, rbac.ActionManage) {
+		return nil
+	}
+	return err
+}
+
 // Repo list options honoring viewer visibility
-func (a *Access) ListOptions(user *auth.AuthenticatedUser, namespace string) stores.ArtifactRepoListOptions {
+func (a *Access) ListOptions(ctx context.Context, user *auth.AuthenticatedUser, namespace string) stores.ArtifactRepoListOptions {
 	opts := stores.ArtifactRepoListOptions{Namespace: namespace}
 	if user != nil {
 		opts.ViewerID = user.ID
 		opts.IncludePrivate = a.enforcer.HasPermission(user.Roles, rbac.ResourceArtifacts, rbac.ActionManage)
 		if !opts.IncludePrivate {
 			opts.GrantedRepos = a.enforcer.GetGrantedObjects(user.Roles, rbac.ResourceArtifacts, rbac.ActionRead)
+			permitted, _ := a.store.ListPermittedRepositories(ctx, user.ID, user.Roles)
+			opts.GrantedRepos = append(opts.GrantedRepos, permitted...)
 		}
 	}
 	return opts
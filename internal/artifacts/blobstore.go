@@ -1,8 +1,12 @@
 package artifacts
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,14 +14,25 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// Blobs live at blobs/sha256/<xx>/<hex> with _uploads staging
+// Blobs live at blobs/sha256/<xx>/<hex> with _uploads staging. An optional
+// cold tier mirrors that layout for blobs nobody has downloaded in a while
 type BlobStore struct {
 	root string
+	cold string
+
+	// Digests being copied back from the cold tier
+	promoting sync.Map
+	usage     atomic.Pointer[TierUsage]
+
+	// Upload id to the *sync.Mutex serializing its chunks
+	writing sync.Map
 }
 
 var uploadIDPattern = regexp.MustCompile(`^[a-zA-Z0-9-]{1,64}$`)
@@ -31,27 +46,160 @@ func NewBlobStore(root string) (*BlobStore, error) {
 	return &BlobStore{root: root}, nil
 }
 
+// Chunks past an upload's reserved size, maps to 413
+var ErrReservationExceeded = errors.New("upload exceeds its reserved size")
+
+// Chunks that do not start where the session ends, maps to 416
+var ErrUploadOffset = errors.New("chunk does not start at the upload offset")
+
+// Completed uploads whose sha256 differs from the one the client sent.
+// Maps to 400 or InvalidArgument
+var ErrChecksumMismatch = errors.New("upload does not match its checksum")
+
+// Sidecar kept next to a session file. Chunks feed the running sha256 as
+// they land so completion skips rereading the whole upload
+type uploadState struct {
+	Reserved int64  `json:"reserved,omitempty"` // Declared size, zero when none was given
+	Size     int64  `json:"size"`               // Bytes the hash state covers
+	Hash     []byte `json:"hash"`
+}
+
 // Creates an empty upload session
 func (b *BlobStore) InitiateUpload() (string, error) {
+	return b.InitiateReservedUpload(0)
+}
+
+// Creates an upload session holding reserve bytes, chunks that would take
+// it past them are refused. Zero reserves nothing and caps nothing
+func (b *BlobStore) InitiateReservedUpload(reserve int64) (string, error) {
 	id := uuid.New().String()
 	f, err := os.OpenFile(b.uploadPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
 		return "", err
 	}
-	return id, f.Close()
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	hash, _ := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err := b.writeState(id, &uploadState{Reserved: reserve, Hash: hash}); err != nil {
+		os.Remove(b.uploadPath(id))
+		return "", err
+	}
+	return id, nil
 }
 
 // Appends bytes, creates missing session file like v1
 func (b *BlobStore) AppendChunk(uploadID string, r io.Reader) (int64, error) {
+	return b.AppendChunkAt(uploadID, -1, r)
+}
+
+// Appends bytes that must start at offset start, ErrUploadOffset when the
+// session holds a different amount. A negative start appends anywhere
+func (b *BlobStore) AppendChunkAt(uploadID string, start int64, r io.Reader) (int64, error) {
 	if !uploadIDPattern.MatchString(uploadID) {
 		return 0, fmt.Errorf("invalid upload id")
 	}
+	mu, _ := b.writing.LoadOrStore(uploadID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
 	f, err := os.OpenFile(b.uploadPath(uploadID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		return 0, err
 	}
 	defer f.Close()
-	return io.Copy(f, r)
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offset := info.Size()
+	if start >= 0 && start != offset {
+		return 0, fmt.Errorf("%w: chunk at %d, upload holds %d bytes", ErrUploadOffset, start, offset)
+	}
+
+	// A session without a usable hash state still takes chunks, completion
+	// hashes it from scratch
+	state := b.readState(uploadID)
+	hasher := sha256.New()
+	if state == nil || state.Size != offset || hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.Hash) != nil {
+		hasher = nil
+	}
+	w := io.Writer(f)
+	if hasher != nil {
+		w = io.MultiWriter(f, hasher)
+	}
+	if state != nil && state.Reserved > 0 {
+		r = io.LimitReader(r, state.Reserved-offset+1)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return n, err
+	}
+	if state != nil && state.Reserved > 0 && offset+n > state.Reserved {
+		// The whole chunk goes, the session stays usable for a retry
+		if err := f.Truncate(offset); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w of %d bytes", ErrReservationExceeded, state.Reserved)
+	}
+	if hasher != nil {
+		state.Size = offset + n
+		state.Hash, _ = hasher.(encoding.BinaryMarshaler).MarshalBinary()
+		if err := b.writeState(uploadID, state); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (b *BlobStore) readState(uploadID string) *uploadState {
+	raw, err := os.ReadFile(b.statePath(uploadID))
+	if err != nil {
+		return nil
+	}
+	var state uploadState
+	if json.Unmarshal(raw, &state) != nil {
+		return nil
+	}
+	return &state
+}
+
+// Replaces the sidecar whole so a crash leaves the old state or the new
+func (b *BlobStore) writeState(uploadID string, state *uploadState) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := b.statePath(uploadID) + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.statePath(uploadID))
+}
+
+// Bytes open sessions have reserved but not yet written
+func (b *BlobStore) ReservedBytes() int64 {
+	entries, err := os.ReadDir(filepath.Join(b.root, "_uploads"))
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), stateSuffix)
+		if !ok {
+			continue
+		}
+		if state := b.readState(id); state != nil && state.Reserved > state.Size {
+			total += state.Reserved - state.Size
+		}
+	}
+	return total
+}
+
+// Bytes left for uploads on the volume holding hot blobs
+func (b *BlobStore) FreeBytes() (int64, bool) {
+	free, ok := freeBytes(b.root)
+	return int64(free), ok
 }
 
 func (b *BlobStore) UploadSize(uploadID string) (int64, error) {
@@ -67,9 +215,17 @@ func (b *BlobStore) UploadSize(uploadID string) (int64, error) {
 
 // Hashes staged upload into blob storage with dedup
 func (b *BlobStore) CompleteUpload(uploadID string) (digest string, size int64, mimeType string, err error) {
+	return b.CompleteUploadVerified(uploadID, "")
+}
+
+// CompleteUpload that first checks the content against an expected sha256
+// hex. A mismatch drops the session with ErrChecksumMismatch, empty checks
+// nothing
+func (b *BlobStore) CompleteUploadVerified(uploadID, sha256Hex string) (digest string, size int64, mimeType string, err error) {
 	if !uploadIDPattern.MatchString(uploadID) {
 		return "", 0, "", fmt.Errorf("invalid upload id")
 	}
+	defer b.writing.Delete(uploadID)
 	src := b.uploadPath(uploadID)
 
 	f, err := os.Open(src)
@@ -81,22 +237,40 @@ func (b *BlobStore) CompleteUpload(uploadID string) (digest string, size int64,
 	n, _ := io.ReadFull(f, head)
 	mimeType = http.DetectContentType(head[:n])
 
-	if _, err = f.Seek(0, io.SeekStart); err != nil {
-		f.Close()
-		return "", 0, "", err
-	}
+	// Chunks kept the hash current, only a session they missed is reread
 	hasher := sha256.New()
-	size, err = io.Copy(hasher, f)
-	f.Close()
+	info, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return "", 0, "", err
 	}
+	state := b.readState(uploadID)
+	if state != nil && state.Size == info.Size() && hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.Hash) == nil {
+		size = state.Size
+	} else {
+		hasher.Reset()
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return "", 0, "", err
+		}
+		if size, err = io.Copy(hasher, f); err != nil {
+			f.Close()
+			return "", 0, "", err
+		}
+	}
+	f.Close()
 	hexDigest := hex.EncodeToString(hasher.Sum(nil))
 	digest = "sha256:" + hexDigest
+	defer os.Remove(b.statePath(uploadID))
+	if sha256Hex != "" && sha256Hex != hexDigest {
+		os.Remove(src)
+		return "", 0, "", fmt.Errorf("%w: got sha256:%s", ErrChecksumMismatch, hexDigest)
+	}
 
 	dest := b.blobPathHex(hexDigest)
 	if _, statErr := os.Stat(dest); statErr == nil {
-		// Identical blob already stored
+		// Identical blob already stored, a fresh upload counts as access
+		touch(dest)
 		return digest, size, mimeType, os.Remove(src)
 	}
 	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
@@ -105,6 +279,10 @@ func (b *BlobStore) CompleteUpload(uploadID string) (digest string, size int64,
 	if err := os.Rename(src, dest); err != nil {
 		return "", 0, "", err
 	}
+	// The new copy is hot, a cold duplicate would only waste space
+	if b.cold != "" {
+		_ = os.Remove(b.coldPathHex(hexDigest))
+	}
 	return digest, size, mimeType, nil
 }
 
@@ -112,6 +290,8 @@ func (b *BlobStore) CancelUpload(uploadID string) error {
 	if !uploadIDPattern.MatchString(uploadID) {
 		return fmt.Errorf("invalid upload id")
 	}
+	b.writing.Delete(uploadID)
+	os.Remove(b.statePath(uploadID))
 	err := os.Remove(b.uploadPath(uploadID))
 	if os.IsNotExist(err) {
 		return nil
@@ -119,12 +299,26 @@ func (b *BlobStore) CancelUpload(uploadID string) error {
 	return err
 }
 
+// Opens a blob for a download. Hot blobs get their idle clock reset, cold
+// ones are served in place while a copy moves back to the hot tier
 func (b *BlobStore) OpenBlob(digest string) (*os.File, os.FileInfo, error) {
+	return b.openBlob(digest, true)
+}
+
+func (b *BlobStore) openBlob(digest string, access bool) (*os.File, os.FileInfo, error) {
 	path, err := b.blobPath(digest)
 	if err != nil {
 		return nil, nil, err
 	}
 	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && b.cold != "" {
+		hexDigest := strings.TrimPrefix(digest, "sha256:")
+		if f, err = os.Open(b.coldPathHex(hexDigest)); err == nil && access {
+			go b.promote(hexDigest)
+		}
+	} else if err == nil && access {
+		touch(path)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -145,35 +339,112 @@ func (b *BlobStore) DeleteBlob(digest string) error {
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if b.cold != "" {
+		if err := os.Remove(b.coldPathHex(strings.TrimPrefix(digest, "sha256:"))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return nil
 }
 
 // Removes upload sessions older than maxAge
 func (b *BlobStore) CleanStaleUploads(maxAge time.Duration) (int, error) {
+	removed, _, err := b.PruneUploads(maxAge, false)
+	return removed, err
+}
+
+// Open upload sessions, completed and cancelled ones leave no file behind
+func (b *BlobStore) PendingUploads() (int, error) {
 	entries, err := os.ReadDir(filepath.Join(b.root, "_uploads"))
 	if err != nil {
 		return 0, err
 	}
+	n := 0
+	for _, e := range entries {
+		if uploadIDPattern.MatchString(e.Name()) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Stale session count and bytes, dry runs leave the files in place
+func (b *BlobStore) PruneUploads(maxAge time.Duration, dryRun bool) (int, int64, error) {
+	entries, err := os.ReadDir(filepath.Join(b.root, "_uploads"))
+	if err != nil {
+		return 0, 0, err
+	}
 	cutoff := time.Now().Add(-maxAge)
 	removed := 0
+	var freed int64
 	for _, e := range entries {
 		info, err := e.Info()
-		if err != nil {
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		// Sidecars go with their session and are not counted as one
+		session := uploadIDPattern.MatchString(e.Name())
+		if !dryRun && os.Remove(filepath.Join(b.root, "_uploads", e.Name())) != nil {
 			continue
 		}
-		if info.ModTime().Before(cutoff) {
-			if os.Remove(filepath.Join(b.root, "_uploads", e.Name())) == nil {
-				removed++
-			}
+		if session {
+			b.writing.Delete(e.Name())
+			removed++
+			freed += info.Size()
 		}
 	}
-	return removed, nil
+	return removed, freed, nil
+}
+
+var ErrBlobCorrupt = errors.New("blob content does not match its digest")
+
+// Rehashes a stored blob on either tier, fs.ErrNotExist when it is gone
+func (b *BlobStore) VerifyBlob(digest string) (int64, error) {
+	f, info, err := b.openBlob(digest, false)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return info.Size(), err
+	}
+	if "sha256:"+hex.EncodeToString(hasher.Sum(nil)) != digest {
+		return info.Size(), ErrBlobCorrupt
+	}
+	return info.Size(), nil
+}
+
+// Writes, reads back and removes a small file in the staging dir, what an
+// upload needs of the disk
+func (b *BlobStore) Probe(ctx context.Context) error {
+	path := filepath.Join(b.root, "_uploads", ".probe-"+uuid.NewString())
+	want := []byte(path)
+	if err := os.WriteFile(path, want, 0644); err != nil {
+		return fmt.Errorf("writing probe: %w", err)
+	}
+	defer os.Remove(path)
+	got, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading probe: %w", err)
+	}
+	if string(got) != string(want) {
+		return errors.New("probe read back different content")
+	}
+	return ctx.Err()
 }
 
 func (b *BlobStore) uploadPath(id string) string {
 	return filepath.Join(b.root, "_uploads", id)
 }
 
+// Dotted so no upload id can collide with it
+const stateSuffix = ".state"
+
+func (b *BlobStore) statePath(id string) string {
+	return filepath.Join(b.root, "_uploads", id+stateSuffix)
+}
+
 var hexPattern = regexp.MustCompile(`^[a-f0-9]{64}$`)
 
 func (b *BlobStore) blobPath(digest string) (string, error) {
@@ -0,0 +1,192 @@
+package artifacts
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobStorePruneAndVerify(t *testing.T) {
+	root := t.TempDir()
+	blobs, err := NewBlobStore(root)
+	if err != nil {
+		t.Fatalf("NewBlobStore: %v", err)
+	}
+
+	stale, _ := blobs.InitiateUpload()
+	if _, err := blobs.AppendChunk(stale, strings.NewReader("abandoned")); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(blobs.uploadPath(stale), old, old)
+	fresh, _ := blobs.InitiateUpload()
+
+	n, freed, err := blobs.PruneUploads(24*time.Hour, true)
+	if err != nil || n != 1 || freed != int64(len("abandoned")) {
+		t.Fatalf("dry run = %d, %d, %v; want 1, 9", n, freed, err)
+	}
+	if _, err := os.Stat(blobs.uploadPath(stale)); err != nil {
+		t.Fatalf("dry run removed the session: %v", err)
+	}
+	if n, _, _ := blobs.PruneUploads(24*time.Hour, false); n != 1 {
+		t.Fatalf("prune removed %d, want 1", n)
+	}
+	if _, err := os.Stat(blobs.uploadPath(fresh)); err != nil {
+		t.Fatalf("fresh session pruned: %v", err)
+	}
+
+	id, _ := blobs.InitiateUpload()
+	blobs.AppendChunk(id, strings.NewReader("payload"))
+	digest, _, _, err := blobs.CompleteUpload(id)
+	if err != nil {
+		t.Fatalf("CompleteUpload: %v", err)
+	}
+	if _, err := blobs.VerifyBlob(digest); err != nil {
+		t.Fatalf("VerifyBlob clean: %v", err)
+	}
+
+	path, _ := blobs.blobPath(digest)
+	os.WriteFile(path, []byte("tampered"), 0644)
+	if _, err := blobs.VerifyBlob(digest); !errors.Is(err, ErrBlobCorrupt) {
+		t.Fatalf("VerifyBlob tampered = %v, want ErrBlobCorrupt", err)
+	}
+
+	os.Remove(path)
+	if _, err := blobs.VerifyBlob(digest); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("VerifyBlob missing = %v, want not exist", err)
+	}
+}
+
+func TestBlobStoreTiering(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBlobStore: %v", err)
+	}
+	if err := blobs.SetColdTier(t.TempDir()); err != nil {
+		t.Fatalf("SetColdTier: %v", err)
+	}
+	store := func(content string) string {
+		id, _ := blobs.InitiateUpload()
+		blobs.AppendChunk(id, strings.NewReader(content))
+		digest, _, _, err := blobs.CompleteUpload(id)
+		if err != nil {
+			t.Fatalf("CompleteUpload: %v", err)
+		}
+		return digest
+	}
+	idle, busy := store("idle payload"), store("busy payload")
+	idlePath, _ := blobs.blobPath(idle)
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	os.Chtimes(idlePath, old, old)
+
+	moved, bytes, err := blobs.Demote(90 * 24 * time.Hour)
+	if err != nil || moved != 1 || bytes != int64(len("idle payload")) {
+		t.Fatalf("Demote = %d, %d, %v; want 1, 12", moved, bytes, err)
+	}
+	if _, err := os.Stat(idlePath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("demoted blob still hot: %v", err)
+	}
+	if _, err := os.Stat(blobs.coldPathHex(strings.TrimPrefix(idle, "sha256:"))); err != nil {
+		t.Fatalf("demoted blob not cold: %v", err)
+	}
+	if u, _ := blobs.RefreshUsage(); u.HotBlobs != 1 || u.ColdBlobs != 1 || u.ColdBytes != int64(len("idle payload")) {
+		t.Fatalf("usage = %+v", u)
+	}
+
+	// Verification reads the cold copy without moving it
+	if _, err := blobs.VerifyBlob(idle); err != nil {
+		t.Fatalf("VerifyBlob cold: %v", err)
+	}
+	if _, err := os.Stat(idlePath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("verification promoted the blob")
+	}
+
+	f, _, err := blobs.OpenBlob(idle)
+	if err != nil {
+		t.Fatalf("OpenBlob cold: %v", err)
+	}
+	got, _ := io.ReadAll(f)
+	f.Close()
+	if string(got) != "idle payload" {
+		t.Fatalf("cold read = %q", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(idlePath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cold blob was not promoted after a read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(blobs.coldPathHex(strings.TrimPrefix(idle, "sha256:"))); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("promoted blob left a cold copy: %v", err)
+	}
+
+	if err := blobs.DeleteBlob(busy); err != nil {
+		t.Fatalf("DeleteBlob: %v", err)
+	}
+	if _, _, err := blobs.OpenBlob(busy); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("deleted blob opened: %v", err)
+	}
+}
+
+// Chunks keep the hash current and may not grow past the reservation
+func TestBlobStoreReservedUpload(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBlobStore: %v", err)
+	}
+	id, err := blobs.InitiateReservedUpload(10)
+	if err != nil {
+		t.Fatalf("InitiateReservedUpload: %v", err)
+	}
+	if got := blobs.ReservedBytes(); got != 10 {
+		t.Fatalf("reserved = %d, want 10", got)
+	}
+	blobs.AppendChunk(id, strings.NewReader("hello "))
+	if _, err := blobs.AppendChunk(id, strings.NewReader("world!")); !errors.Is(err, ErrReservationExceeded) {
+		t.Fatalf("overflowing chunk = %v, want ErrReservationExceeded", err)
+	}
+	if size, _ := blobs.UploadSize(id); size != 6 {
+		t.Fatalf("refused chunk left %d bytes, want 6", size)
+	}
+	if _, err := blobs.AppendChunk(id, strings.NewReader("moon")); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	if got := blobs.ReservedBytes(); got != 0 {
+		t.Fatalf("reserved after filling = %d, want 0", got)
+	}
+	if n, _ := blobs.PendingUploads(); n != 1 {
+		t.Fatalf("pending = %d, want 1", n)
+	}
+
+	// The running hash and a full reread agree
+	digest, size, _, err := blobs.CompleteUpload(id)
+	if err != nil || size != 10 {
+		t.Fatalf("CompleteUpload = %d, %v", size, err)
+	}
+	if _, err := blobs.VerifyBlob(digest); err != nil {
+		t.Fatalf("VerifyBlob: %v", err)
+	}
+	if _, err := os.Stat(blobs.statePath(id)); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("state left behind: %v", err)
+	}
+
+	// A session the chunks lost track of is hashed from scratch
+	lost, _ := blobs.InitiateUpload()
+	blobs.AppendChunk(lost, strings.NewReader("hello "))
+	os.Remove(blobs.statePath(lost))
+	blobs.AppendChunk(lost, strings.NewReader("moon"))
+	if again, _, _, err := blobs.CompleteUpload(lost); err != nil || again != digest {
+		t.Fatalf("rehashed digest = %s, %v; want %s", again, err, digest)
+	}
+	if n, _ := blobs.PendingUploads(); n != 0 {
+		t.Fatalf("pending = %d, want 0", n)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package artifacts
+
+import "syscall"
+
+// Bytes an unprivileged writer can still use on the volume holding dir
+func freeBytes(dir string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return st.Bavail * uint64(st.Bsize), true
+}
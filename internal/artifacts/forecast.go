@@ -0,0 +1,104 @@
+package artifacts
+
+import (
+	"context"
+	"time"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+)
+
+// Expected state of a repo once retention ran on a future day
+type ForecastPoint struct {
+	Days      int
+	Artifacts int
+	Bytes     int64
+	Uploaded  int64 // Bytes the projected uploads add by then
+	Pruned    int64 // Bytes retention and expiries remove by then
+}
+
+// Retention simulation and storage forecast of one repo
+type RetentionForecast struct {
+	Policy           RetentionPolicy
+	Artifacts        int
+	Bytes            int64
+	NextRunArtifacts int // Deleted by a retention run now
+	NextRunBytes     int64
+	WindowUploads    int // Uploads within the rate window
+	WindowBytes      int64
+	Points           []ForecastPoint // One per horizon, in the order asked
+}
+
+// Simulates retention over the repo now and at each horizon in days. The
+// uploads of the last window repeat on their paths and property sets until
+// the horizon, so version caps and keep rules treat them like the real ones
+func (m *Manager) ForecastRetention(ctx context.Context, repo *storage.ArtifactRepository, window time.Duration, horizons []int, now time.Time) (*RetentionForecast, error) {
+	all, _, err := m.store.ListArtifacts(ctx, repo.ID, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return forecastRetention(all, m.RepoRetention(ctx, repo), window, horizons, now), nil
+}
+
+func forecastRetention(all []*storage.Artifact, p RetentionPolicy, window time.Duration, horizons []int, now time.Time) *RetentionForecast {
+	f := &RetentionForecast{Policy: p, Artifacts: len(all)}
+	from := now.Add(-window)
+	var recent []*storage.Artifact
+	for _, a := range all {
+		f.Bytes += a.Size
+		if window > 0 && a.CreatedAt.After(from) {
+			recent = append(recent, a)
+			f.WindowUploads++
+			f.WindowBytes += a.Size
+		}
+	}
+	for _, d := range planRetention(all, p, now) {
+		f.NextRunArtifacts++
+		f.NextRunBytes += d.a.Size
+	}
+
+	for _, days := range horizons {
+		at := now.AddDate(0, 0, days)
+		pt := ForecastPoint{Days: days}
+		set := make([]*storage.Artifact, 0, len(all))
+		for _, a := range all {
+			if expiredBy(a, at) {
+				pt.Pruned += a.Size
+				continue
+			}
+			set = append(set, a)
+		}
+		// Each replay shifts the window's uploads one window later
+		for shift := window; len(recent) > 0 && from.Add(shift).Before(at); shift += window {
+			for _, a := range recent {
+				created := a.CreatedAt.Add(shift)
+				if created.After(at) {
+					continue
+				}
+				c := *a
+				c.ID, c.CreatedAt = "", created
+				if a.ExpiresAt != nil {
+					exp := a.ExpiresAt.Add(shift)
+					c.ExpiresAt = &exp
+				}
+				pt.Uploaded += c.Size
+				if expiredBy(&c, at) {
+					pt.Pruned += c.Size
+					continue
+				}
+				set = append(set, &c)
+			}
+		}
+		deletes := planRetention(set, p, at)
+		for _, d := range deletes {
+			pt.Pruned += d.a.Size
+		}
+		pt.Artifacts = len(set) - len(deletes)
+		pt.Bytes = f.Bytes + pt.Uploaded - pt.Pruned
+		f.Points = append(f.Points, pt)
+	}
+	return f
+}
+
+func expiredBy(a *storage.Artifact, at time.Time) bool {
+	return a.ExpiresAt != nil && !a.ExpiresAt.After(at)
+}
@@ -0,0 +1,102 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/jobs"
+	"github.com/nickheyer/distroface/internal/scan"
+)
+
+// Job kinds for work a completed upload leaves behind
+const (
+	JobScan         = "artifact.scan"      // Subject is the artifact id
+	JobRetention    = "artifact.retention" // Subject is namespace/name
+	JobPackageIndex = "artifact.index"     // Subject is namespace/name
+)
+
+// Hands scans and retention to the queue so uploads return once the blob
+// and row land, and the work retries and survives restarts
+func (m *Manager) SetJobs(q *jobs.Queue) {
+	m.jobs = q
+	q.Handle(JobScan, func(ctx context.Context, id string, _ json.RawMessage) error {
+		a, err := m.store.GetArtifact(ctx, id)
+		if err != nil {
+			return err
+		}
+		// Deleted since the upload, nothing left to scan
+		if a == nil {
+			return nil
+		}
+		if verdict := m.scanArtifact(ctx, a.ID, a.Digest, a.Size); verdict.Status == scan.StatusError {
+			return errors.New(verdict.Result)
+		}
+		return nil
+	})
+	q.Handle(JobRetention, func(ctx context.Context, subject string, _ json.RawMessage) error {
+		repo, err := m.jobRepo(ctx, subject)
+		if err != nil || repo == nil {
+			return err
+		}
+		return m.ApplyRetention(ctx, repo)
+	})
+}
+
+// Rebuilds a package repo's index after uploads so the first apt or dnf
+// fetch finds it ready
+func (h *PackageRepos) SetJobs(q *jobs.Queue) {
+	q.Handle(JobPackageIndex, func(ctx context.Context, subject string, _ json.RawMessage) error {
+		repo, err := h.manager.jobRepo(ctx, subject)
+		if err != nil || repo == nil || !IsPackageRepo(repo) {
+			return err
+		}
+		_, err = h.index(ctx, repo)
+		return err
+	})
+}
+
+// Repo a job names, nil once it is gone
+func (m *Manager) jobRepo(ctx context.Context, subject string) (*storage.ArtifactRepository, error) {
+	namespace, name, ok := strings.Cut(subject, "/")
+	if !ok {
+		return nil, jobs.Permanent(errors.New("subject is not namespace/name"))
+	}
+	return m.store.GetArtifactRepository(ctx, namespace, name)
+}
+
+func (m *Manager) queueScan(ctx context.Context, a *storage.Artifact) {
+	if m.jobs == nil {
+		go m.scanArtifact(context.Background(), a.ID, a.Digest, a.Size)
+		return
+	}
+	if _, err := m.jobs.Enqueue(ctx, JobScan, a.ID, nil); err != nil {
+		m.log.Error("queueing scan of artifact %s: %v", a.ID, err)
+	}
+}
+
+// Retention when the repo has it on and, for package repos, the index
+// rebuild. Uploads in a burst share one pending job per repo
+func (m *Manager) queueRepoWork(ctx context.Context, repo *storage.ArtifactRepository) {
+	if m.jobs == nil {
+		if err := m.ApplyRetention(ctx, repo); err != nil {
+			m.log.Error("artifact retention for repo %d: %v", repo.ID, err)
+		}
+		return
+	}
+	subject := repo.Namespace + "/" + repo.Name
+	var kinds []string
+	if m.RepoRetention(ctx, repo).Enabled {
+		kinds = append(kinds, JobRetention)
+	}
+	if IsPackageRepo(repo) {
+		kinds = append(kinds, JobPackageIndex)
+	}
+	for _, kind := range kinds {
+		if _, err := m.jobs.Enqueue(ctx, kind, subject, nil); err != nil {
+			m.log.Error("queueing %s for repo %d: %v", kind, repo.ID, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package artifacts
+
+import (
+	"context"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+)
+
+// Version segment of the stable download route, never a real version
+const LatestVersion = "_latest"
+
+// Filter an upload moves its path's latest pointer under, false when the
+// repo has pointers off or the properties do not match
+func (m *Manager) latestFilter(ctx context.Context, repo *storage.ArtifactRepository, properties map[string]string) (map[string]string, bool) {
+	l := m.repoSettings(ctx, repo).GetLatest()
+	if !l.GetEnabled() || !LatestMatches(l.GetProperties(), properties) {
+		return nil, false
+	}
+	return l.GetProperties(), true
+}
+
+// Every filter pair present in properties, an empty value matches any value
+func LatestMatches(filter, properties map[string]string) bool {
+	for k, want := range filter {
+		got, ok := properties[k]
+		if !ok || (want != "" && got != want) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package artifacts
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,10 +10,18 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/alerts"
+	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/jobs"
+	"github.com/nickheyer/distroface/internal/journal"
+	"github.com/nickheyer/distroface/internal/policy"
+	"github.com/nickheyer/distroface/internal/scan"
 	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
@@ -25,6 +34,24 @@ type RetentionPolicy struct {
 	MaxAgeDays    int
 	MaxTotalSize  int64
 	ExcludeLatest bool
+	KeepRules     []KeepRule
+}
+
+// Property match that exempts an artifact, empty value matches any
+type KeepRule struct {
+	Key   string
+	Value string
+}
+
+// Reports whether any keep rule matches the property set
+func (p RetentionPolicy) Keeps(props map[string]string) bool {
+	for _, r := range p.KeepRules {
+		v, ok := props[r.Key]
+		if ok && (r.Value == "" || r.Value == v) {
+			return true
+		}
+	}
+	return false
 }
 
 // Caller errors that map to 400 or InvalidArgument
@@ -32,18 +59,63 @@ var ErrInvalid = errors.New("invalid argument")
 
 var ErrUploadNotFound = errors.New("upload session not found")
 
+// Write conflicts that map to 409 or AlreadyExists
+var ErrExists = errors.New("artifact already exists")
+
+// Uploads that would not fit next to the space open sessions reserved.
+// Maps to 507 or ResourceExhausted
+var ErrNoSpace = errors.New("insufficient storage")
+
+// Uploads that would take a repo or its owner past their artifact quota.
+// Maps to 413 or ResourceExhausted
+var ErrQuotaExceeded = errors.New("artifact quota exceeded")
+
+// Downloads refused for infected content, or unscanned content when
+// block_unscanned is set. Maps to 403 or PermissionDenied
+var ErrQuarantined = errors.New("artifact is quarantined")
+
 // Artifact business logic shared by rpc service and v1 facade
 type Manager struct {
 	store *stores.Store
 	blobs *BlobStore
 	res   *settings.Resolver
 	log   *logger.Logger
+
+	// Pre-receive hook, nil accepts every upload
+	policy *policy.Hook
+	// Intent log for blob writes and deletes, nil skips journaling
+	journal *journal.Journal
+	// Upload outcome counters, nil counts nothing
+	signals *alerts.Signals
+	// Malware scanner, nil leaves uploads unscanned
+	scanner *scan.Scanner
+	// Post-upload work queue, nil runs scans detached and retention inline
+	jobs *jobs.Queue
+	// Group visibility policy for new and updated repos, nil imposes none
+	visibility *policy.Visibility
+	// Blob store breaker, nil never refuses uploads
+	health *admin.StorageHealth
+	// Serializes the free space check and the reservation it grants
+	reserving sync.Mutex
+}
+
+// Journal kind for blobs that must be refcount checked after a crash
+const opBlobGC = "artifact.blob_gc"
+
+type blobGCOp struct {
+	Digests []string `json:"digests"`
 }
 
 func NewManager(store *stores.Store, blobs *BlobStore, res *settings.Resolver, log *logger.Logger) *Manager {
 	return &Manager{store: store, blobs: blobs, res: res, log: log}
 }
 
+// Breaker refusing uploads while the blob store is degraded
+func (m *Manager) SetStorageHealth(h *admin.StorageHealth) { m.health = h }
+
+// Nil unless the blob store breaker is open
+func (m *Manager) StorageAvailable() error { return m.health.Available() }
+
 // Effective artifact settings for an org namespace or the system
 func (m *Manager) artifactSettings(ctx context.Context, namespace string) *v1.ArtifactSettings {
 	if namespace != "" {
@@ -54,8 +126,58 @@ func (m *Manager) artifactSettings(ctx context.Context, namespace string) *v1.Ar
 	return m.res.System(ctx).GetArtifacts()
 }
 
+// Effective artifact settings for one repo, its repo tier over the namespace
+func (m *Manager) repoSettings(ctx context.Context, repo *storage.ArtifactRepository) *v1.ArtifactSettings {
+	return m.res.Repo(ctx, repo.Namespace, repo.Name).GetArtifacts()
+}
+
 func (m *Manager) Blobs() *BlobStore { return m.blobs }
 
+// Routes completed uploads through the push policy hook before they land
+func (m *Manager) SetPushPolicy(h *policy.Hook) { m.policy = h }
+
+// Holds repo creates and visibility changes to the group visibility policy
+func (m *Manager) SetVisibility(v *policy.Visibility) { m.visibility = v }
+
+func (m *Manager) Visibility() *policy.Visibility { return m.visibility }
+
+// Feeds upload outcomes to the alert monitor
+func (m *Manager) SetAlertSignals(s *alerts.Signals) { m.signals = s }
+
+// Scans completed uploads in the background and gates downloads on the verdict
+func (m *Manager) SetScanner(s *scan.Scanner) { m.scanner = s }
+
+// Journals uploads and deletes. Replay refcount checks every blob the
+// interrupted operation touched, so orphans go and referenced blobs stay
+func (m *Manager) SetJournal(j *journal.Journal) {
+	m.journal = j
+	j.Handle(opBlobGC, func(ctx context.Context, payload json.RawMessage) error {
+		var op blobGCOp
+		if err := json.Unmarshal(payload, &op); err != nil {
+			return err
+		}
+		for _, d := range op.Digests {
+			m.gcBlob(ctx, d)
+		}
+		return nil
+	})
+}
+
+// Journals the blobs an operation may leave unreferenced
+func (m *Manager) beginBlobGC(digests ...string) (string, error) {
+	return m.journal.Begin(opBlobGC, blobGCOp{Digests: digests})
+}
+
+// Turns Windows separators into slashes and drops a leading "./", so a
+// path written on any client is stored and found under one name
+func NormalizePath(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	for strings.HasPrefix(p, "./") {
+		p = p[2:]
+	}
+	return p
+}
+
 // Rejects traversal, absolute, and oversized paths
 func ValidatePath(p string) error {
 	if p == "" {
@@ -85,6 +207,9 @@ func ValidateVersion(v string) error {
 	if strings.ContainsAny(v, "/\\") {
 		return fmt.Errorf("%w: version must not contain slashes", ErrInvalid)
 	}
+	if v == LatestVersion {
+		return fmt.Errorf("%w: version %q is reserved for latest downloads", ErrInvalid, v)
+	}
 	return nil
 }
 
@@ -107,43 +232,181 @@ func SanitizePath(name string) string {
 	return s
 }
 
+// How a completed upload is checked and treats artifacts already at its
+// version and path
+type WriteOptions struct {
+	IfNotExists bool          // Keep matching content, ErrExists on different content
+	Overwrite   bool          // Replace every property variant, not just the same one
+	Checksum    string        // Expected sha256, bare or sha256: prefixed hex
+	ExpiresIn   time.Duration // Reaper deletes the artifact this long after upload, zero keeps it
+}
+
+// Lowercase hex of a client checksum, empty stays empty
+func normalizeChecksum(sum string) (string, error) {
+	if sum == "" {
+		return "", nil
+	}
+	hexSum := strings.ToLower(strings.TrimPrefix(sum, "sha256:"))
+	if len(hexSum) != sha256.Size*2 || strings.Trim(hexSum, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%w: checksum must be a sha256 hex digest", ErrInvalid)
+	}
+	return hexSum, nil
+}
+
+// Opens an upload session to a repo. A declared size is checked against the
+// repo's limit and the free space other sessions have not claimed, then held
+// for this session, which may not grow past it. Zero declares nothing
+func (m *Manager) InitiateUpload(ctx context.Context, repo *storage.ArtifactRepository, size int64) (string, error) {
+	if size < 0 {
+		return "", fmt.Errorf("%w: size must not be negative", ErrInvalid)
+	}
+	if maxBytes := m.RepoMaxFileSizeBytes(ctx, repo); maxBytes > 0 && size > maxBytes {
+		return "", fmt.Errorf("%w: artifact exceeds maximum size of %dMB", ErrInvalid, maxBytes/(1024*1024))
+	}
+	if err := m.health.Available(); err != nil {
+		return "", err
+	}
+	if err := m.checkQuota(ctx, repo, size); err != nil {
+		return "", err
+	}
+	if size > 0 {
+		m.reserving.Lock()
+		defer m.reserving.Unlock()
+		if free, ok := m.blobs.FreeBytes(); ok {
+			if avail := free - m.blobs.ReservedBytes(); size > avail {
+				return "", fmt.Errorf("%w: upload needs %d bytes, %d available", ErrNoSpace, size, max(avail, 0))
+			}
+		}
+	}
+	start := time.Now()
+	uploadID, err := m.blobs.InitiateReservedUpload(size)
+	m.health.Observe(true, time.Since(start), err)
+	return uploadID, err
+}
+
 // Finalizes upload, replaces existing same version path properties
 func (m *Manager) CompleteUpload(ctx context.Context, repo *storage.ArtifactRepository, uploadID, version, artifactPath, metadata string, properties map[string]string) (*storage.Artifact, error) {
+	artifact, _, err := m.CompleteUploadWith(ctx, repo, uploadID, version, artifactPath, metadata, properties, WriteOptions{})
+	return artifact, err
+}
+
+// CompleteUpload with explicit write options, skipped reports that
+// IfNotExists kept an existing artifact with the same checksum
+func (m *Manager) CompleteUploadWith(ctx context.Context, repo *storage.ArtifactRepository, uploadID, version, artifactPath, metadata string, properties map[string]string, opts WriteOptions) (artifact *storage.Artifact, skipped bool, err error) {
+	defer func() { m.signals.Upload(uploadFailed(err)) }()
+	if err := m.health.Available(); err != nil {
+		return nil, false, err
+	}
 	if err := ValidateVersion(version); err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	if artifactPath == "" {
+	// Package repos derive the path from the package itself
+	pkgRepo := IsPackageRepo(repo)
+	artifactPath = NormalizePath(artifactPath)
+	if artifactPath == "" && !pkgRepo {
 		artifactPath = SanitizePath(repo.Name)
 	}
-	if err := ValidatePath(artifactPath); err != nil {
-		return nil, err
+	if artifactPath != "" || !pkgRepo {
+		if err := ValidatePath(artifactPath); err != nil {
+			return nil, false, err
+		}
 	}
 	if metadata == "" {
 		metadata = "{}"
 	} else if !json.Valid([]byte(metadata)) {
-		return nil, fmt.Errorf("%w: metadata must be valid JSON", ErrInvalid)
+		return nil, false, fmt.Errorf("%w: metadata must be valid JSON", ErrInvalid)
+	}
+	if err := m.ValidateProperties(ctx, repo, properties); err != nil {
+		return nil, false, err
+	}
+	checksum, err := normalizeChecksum(opts.Checksum)
+	if err != nil {
+		return nil, false, err
+	}
+	if opts.ExpiresIn < 0 {
+		return nil, false, fmt.Errorf("%w: expiry must not be negative", ErrInvalid)
 	}
 
-	if maxBytes := m.EffectiveMaxFileSizeBytes(ctx, repo.Namespace); maxBytes > 0 {
-		size, err := m.blobs.UploadSize(uploadID)
-		if err != nil {
-			return nil, ErrUploadNotFound
+	uploadSize, err := m.blobs.UploadSize(uploadID)
+	if err != nil {
+		return nil, false, ErrUploadNotFound
+	}
+	if maxBytes := m.RepoMaxFileSizeBytes(ctx, repo); maxBytes > 0 && uploadSize > maxBytes {
+		m.blobs.CancelUpload(uploadID)
+		return nil, false, fmt.Errorf("%w: artifact exceeds maximum size of %dMB", ErrInvalid, maxBytes/(1024*1024))
+	}
+	if err := m.checkQuota(ctx, repo, uploadSize); err != nil {
+		m.blobs.CancelUpload(uploadID)
+		return nil, false, err
+	}
+
+	digest, size, mimeType, err := m.blobs.CompleteUploadVerified(uploadID, checksum)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, ErrUploadNotFound
 		}
-		if size > maxBytes {
-			m.blobs.CancelUpload(uploadID)
-			return nil, fmt.Errorf("%w: artifact exceeds maximum size of %dMB", ErrInvalid, maxBytes/(1024*1024))
+		return nil, false, err
+	}
+
+	var pkg *storage.ArtifactPackage
+	if pkgRepo {
+		artifactPath, properties, pkg, err = m.inspectPackage(repo, digest, version, artifactPath, properties)
+		if err != nil {
+			m.gcBlob(ctx, digest)
+			return nil, false, err
 		}
 	}
 
-	digest, size, mimeType, err := m.blobs.CompleteUpload(uploadID)
+	existing, err := m.store.ListArtifactsAtPath(ctx, repo.ID, version, artifactPath)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, ErrUploadNotFound
+		m.gcBlob(ctx, digest)
+		return nil, false, err
+	}
+
+	// A crash before the row lands leaves the blob orphaned, an overwrite
+	// cut short leaves the replaced blobs, replay collects either
+	touched := []string{digest}
+	for _, e := range existing {
+		touched = append(touched, e.Digest)
+	}
+	opID, err := m.beginBlobGC(touched...)
+	if err != nil {
+		m.gcBlob(ctx, digest)
+		return nil, false, err
+	}
+	defer m.journal.Done(opID)
+	if opts.IfNotExists && len(existing) > 0 {
+		for _, e := range existing {
+			if e.Digest == digest {
+				return e, true, nil
+			}
+		}
+		if !opts.Overwrite {
+			m.gcBlob(ctx, digest)
+			return nil, false, fmt.Errorf("%w: %s %s has different content", ErrExists, version, artifactPath)
 		}
-		return nil, err
 	}
 
-	artifact := &storage.Artifact{
+	push := policy.Push{
+		Event:      policy.EventArtifactPush,
+		Namespace:  repo.Namespace,
+		Repo:       repo.Name,
+		Digest:     digest,
+		MediaType:  mimeType,
+		Size:       size,
+		Version:    version,
+		Path:       artifactPath,
+		Properties: properties,
+	}
+	if user := auth.UserFromContext(ctx); user != nil {
+		push.User = user.Username
+	}
+	if err := m.policy.Check(ctx, push); err != nil {
+		m.gcBlob(ctx, digest)
+		return nil, false, err
+	}
+
+	artifact = &storage.Artifact{
 		RepoID:   repo.ID,
 		Name:     path.Base(artifactPath),
 		Path:     artifactPath,
@@ -153,26 +416,60 @@ func (m *Manager) CompleteUpload(ctx context.Context, repo *storage.ArtifactRepo
 		Size:     size,
 		MimeType: mimeType,
 		Metadata: metadata,
+		Package:  pkg,
+	}
+	if m.scanner.Enabled(ctx) {
+		artifact.ScanStatus = scan.StatusPending
+	}
+	if opts.ExpiresIn > 0 {
+		at := time.Now().UTC().Add(opts.ExpiresIn)
+		artifact.ExpiresAt = &at
 	}
 
-	replacedDigest, err := m.store.CreateArtifact(ctx, artifact, properties)
+	var replacedDigest string
+	if filter, ok := m.latestFilter(ctx, repo, properties); ok {
+		replacedDigest, err = m.store.CreateArtifactAsLatest(ctx, artifact, properties, filter)
+	} else {
+		replacedDigest, err = m.store.CreateArtifact(ctx, artifact, properties)
+	}
 	if err != nil {
 		m.gcBlob(ctx, digest)
-		return nil, err
+		return nil, false, err
 	}
 	if replacedDigest != "" && replacedDigest != digest {
 		m.gcBlob(ctx, replacedDigest)
 	}
+	if opts.Overwrite {
+		for _, e := range existing {
+			if e.ID == artifact.ID || e.PropsHash == artifact.PropsHash {
+				continue
+			}
+			if err := m.DeleteArtifact(ctx, e); err != nil {
+				m.log.Error("overwrite of %s %s in repo %d: %v", version, artifactPath, repo.ID, err)
+			}
+		}
+	}
 
-	if err := m.ApplyRetention(ctx, repo); err != nil {
-		m.log.Error("artifact retention for repo %d: %v", repo.ID, err)
+	if artifact.ScanStatus == scan.StatusPending {
+		m.queueScan(ctx, artifact)
 	}
+	m.queueRepoWork(ctx, repo)
+
+	return artifact, false, nil
+}
 
-	return artifact, nil
+// Refused input and policy denials are the client's doing, not failures
+func uploadFailed(err error) bool {
+	return err != nil && !errors.Is(err, ErrInvalid) && !errors.Is(err, ErrExists) && !errors.Is(err, policy.ErrDenied)
 }
 
 // Deletes row then GCs blob when unreferenced
 func (m *Manager) DeleteArtifact(ctx context.Context, artifact *storage.Artifact) error {
+	opID, err := m.beginBlobGC(artifact.Digest)
+	if err != nil {
+		return err
+	}
+	defer m.journal.Done(opID)
 	if err := m.store.DeleteArtifact(ctx, artifact.ID); err != nil {
 		return err
 	}
@@ -180,8 +477,58 @@ func (m *Manager) DeleteArtifact(ctx context.Context, artifact *storage.Artifact
 	return nil
 }
 
+// Reaper deletes the artifact expiresIn from now, zero clears the expiry
+func (m *Manager) SetExpiry(ctx context.Context, artifact *storage.Artifact, expiresIn time.Duration) error {
+	if expiresIn < 0 {
+		return fmt.Errorf("%w: expiry must not be negative", ErrInvalid)
+	}
+	var at *time.Time
+	if expiresIn > 0 {
+		t := time.Now().UTC().Add(expiresIn)
+		at = &t
+	}
+	if err := m.store.SetArtifactExpiry(ctx, artifact.ID, at); err != nil {
+		return err
+	}
+	artifact.ExpiresAt = at
+	return nil
+}
+
+// Expired artifacts deleted per store query
+const expiryBatch = 500
+
+// Deletes every artifact whose expiry passed, returning how many went
+func (m *Manager) DeleteExpired(ctx context.Context) (int, error) {
+	deleted := 0
+	for {
+		expired, err := m.store.ListExpiredArtifacts(ctx, time.Now().UTC(), expiryBatch)
+		if err != nil {
+			return deleted, err
+		}
+		for _, a := range expired {
+			if err := m.DeleteArtifact(ctx, a); err != nil {
+				return deleted, fmt.Errorf("deleting expired artifact %s: %w", a.ID, err)
+			}
+			m.log.Info("Deleted expired artifact %s %s from repo %d", a.Version, a.Path, a.RepoID)
+			deleted++
+		}
+		if len(expired) < expiryBatch {
+			return deleted, nil
+		}
+	}
+}
+
 // Cascades repo delete then GCs unreferenced blobs
 func (m *Manager) DeleteRepository(ctx context.Context, repo *storage.ArtifactRepository) error {
+	held, err := m.store.ListArtifactDigestsByRepo(ctx, repo.ID)
+	if err != nil {
+		return err
+	}
+	opID, err := m.beginBlobGC(held...)
+	if err != nil {
+		return err
+	}
+	defer m.journal.Done(opID)
 	digests, err := m.store.DeleteArtifactRepository(ctx, repo.ID)
 	if err != nil {
 		return err
@@ -189,28 +536,178 @@ func (m *Manager) DeleteRepository(ctx context.Context, repo *storage.ArtifactRe
 	for _, d := range digests {
 		m.gcBlob(ctx, d)
 	}
+	// The repo tier outlives the repo while an image repo shares the path
+	if img, err := m.store.GetRepository(ctx, repo.Namespace, repo.Name); err == nil && img == nil {
+		if err := m.res.DeleteScope(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, repo.Namespace+"/"+repo.Name); err != nil {
+			m.log.Error("dropping repo settings of %s/%s: %v", repo.Namespace, repo.Name, err)
+		}
+	}
 	return nil
 }
 
 // Resolves the effective retention policy for a namespace
 func (m *Manager) EffectiveRetention(ctx context.Context, namespace string) RetentionPolicy {
-	r := m.artifactSettings(ctx, namespace).GetRetention()
-	return RetentionPolicy{
+	return retentionPolicy(m.artifactSettings(ctx, namespace).GetRetention())
+}
+
+func retentionPolicy(r *v1.ArtifactRetentionSettings) RetentionPolicy {
+	p := RetentionPolicy{
 		Enabled:       r.GetEnabled(),
 		MaxVersions:   int(r.GetMaxVersions()),
 		MaxAgeDays:    int(r.GetMaxAgeDays()),
 		MaxTotalSize:  r.GetMaxTotalSizeBytes(),
 		ExcludeLatest: r.GetExcludeLatest(),
 	}
+	for _, k := range r.GetKeepRules() {
+		p.KeepRules = append(p.KeepRules, KeepRule{Key: k.Key, Value: k.Value})
+	}
+	return p
 }
 
-// Effective max upload size in bytes zero means unlimited
-func (m *Manager) EffectiveMaxFileSizeBytes(ctx context.Context, namespace string) int64 {
-	mb := m.artifactSettings(ctx, namespace).GetMaxFileSizeMb()
-	if mb <= 0 {
-		return 0
+// Repo tier policy, the repo's own settings win over its org and system
+func (m *Manager) RepoRetention(ctx context.Context, repo *storage.ArtifactRepository) RetentionPolicy {
+	return retentionPolicy(m.repoSettings(ctx, repo).GetRetention())
+}
+
+// Rejects retention settings a sweep could not honor
+func ValidateRetention(r *v1.ArtifactRetentionSettings) error {
+	if r.GetMaxVersions() < 0 || r.GetMaxAgeDays() < 0 || r.GetMaxTotalSizeBytes() < 0 {
+		return fmt.Errorf("%w: retention limits cannot be negative", ErrInvalid)
+	}
+	for _, k := range r.GetKeepRules() {
+		if strings.TrimSpace(k.Key) == "" {
+			return fmt.Errorf("%w: keep rule key is required", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// Retention and query defaults stored on the repo's own settings tier
+func (m *Manager) RepoOverrides(ctx context.Context, repo *storage.ArtifactRepository) (*v1.ArtifactRetentionSettings, *v1.ArtifactQuerySettings, error) {
+	row, err := m.res.Stored(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, repo.Namespace+"/"+repo.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return row.GetArtifacts().GetRetention(), row.GetArtifacts().GetQuery(), nil
+}
+
+// Replaces the repo tier retention and query defaults that are non nil,
+// an empty message clears its subtree
+func (m *Manager) SetRepoOverrides(ctx context.Context, repo *storage.ArtifactRepository, retention *v1.ArtifactRetentionSettings, query *v1.ArtifactQuerySettings) error {
+	patch := &v1.ArtifactSettings{Retention: retention, Query: query}
+	var paths []string
+	if retention != nil {
+		if err := ValidateRetention(retention); err != nil {
+			return err
+		}
+		paths = append(paths, "artifacts.retention")
+	}
+	if query != nil {
+		if err := ValidateQueryDefaults(query); err != nil {
+			return err
+		}
+		paths = append(paths, "artifacts.query")
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	_, err := m.res.Update(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, repo.Namespace+"/"+repo.Name, &v1.Settings{Artifacts: patch}, paths)
+	return err
+}
+
+// Resolved v1 query download defaults for a single repo
+type QueryDefaults struct {
+	Num   int
+	Sort  string
+	Order string // ASC or DESC
+	Flat  bool
+}
+
+// Repo tier query defaults, the repo's own settings win over its org and system
+func (m *Manager) RepoQueryDefaults(ctx context.Context, repo *storage.ArtifactRepository) QueryDefaults {
+	q := m.repoSettings(ctx, repo).GetQuery()
+	d := QueryDefaults{Num: int(q.GetNum()), Sort: q.GetSort(), Order: strings.ToUpper(q.GetOrder()), Flat: q.GetFlat()}
+	if d.Num < 1 {
+		d.Num = 1
+	}
+	if !stores.ArtifactSortColumns[d.Sort] {
+		d.Sort = "created_at"
+	}
+	if d.Order != "ASC" {
+		d.Order = "DESC"
+	}
+	return d
+}
+
+// Rejects set fields a query download could not honor
+func ValidateQueryDefaults(q *v1.ArtifactQuerySettings) error {
+	if q.Num != nil && *q.Num < 1 {
+		return fmt.Errorf("%w: query num must be at least 1", ErrInvalid)
 	}
-	return mb * 1024 * 1024
+	if q.Sort != nil && !stores.ArtifactSortColumns[*q.Sort] {
+		return fmt.Errorf("%w: invalid query sort field %q", ErrInvalid, *q.Sort)
+	}
+	if q.Order != nil && !strings.EqualFold(*q.Order, "asc") && !strings.EqualFold(*q.Order, "desc") {
+		return fmt.Errorf("%w: query order must be asc or desc", ErrInvalid)
+	}
+	return nil
+}
+
+// Max upload size in bytes for one repo, zero means unlimited
+func (m *Manager) RepoMaxFileSizeBytes(ctx context.Context, repo *storage.ArtifactRepository) int64 {
+	return m.res.MaxFileSizeBytes(ctx, repo.Namespace, repo.Name)
+}
+
+// Effective repo quota in bytes, zero when unlimited
+func (m *Manager) RepoQuotaBytes(ctx context.Context, repo *storage.ArtifactRepository) int64 {
+	return m.res.RepoQuotaBytes(ctx, repo.Namespace, repo.Name)
+}
+
+// Owner quota in bytes, zero when unlimited
+func (m *Manager) OwnerQuotaBytes(ctx context.Context, owner *storage.User) int64 {
+	return OwnerQuotaBytes(owner, m.res.System(ctx).GetArtifacts())
+}
+
+// The owner's own quota over the system artifacts.quota.owner_mb
+func OwnerQuotaBytes(owner *storage.User, system *v1.ArtifactSettings) int64 {
+	if owner != nil && owner.ArtifactQuotaMB != nil {
+		return max(*owner.ArtifactQuotaMB, 0) << 20
+	}
+	return max(system.GetQuota().GetOwnerMb(), 0) << 20
+}
+
+// Refuses size more bytes that would take the repo or its owner past quota
+func (m *Manager) checkQuota(ctx context.Context, repo *storage.ArtifactRepository, size int64) error {
+	if limit := m.RepoQuotaBytes(ctx, repo); limit > 0 {
+		stats, err := m.store.GetArtifactRepoStats(ctx, []int64{repo.ID})
+		if err != nil {
+			return err
+		}
+		if used := stats[repo.ID].Size; used+size > limit {
+			return fmt.Errorf("%w: %s/%s holds %d of its %d bytes, the upload needs %d more", ErrQuotaExceeded, repo.Namespace, repo.Name, used, limit, size)
+		}
+	}
+	if repo.OwnerID == "" {
+		return nil
+	}
+	owner, err := m.store.GetUserByID(ctx, repo.OwnerID)
+	if err != nil {
+		return err
+	}
+	if limit := m.OwnerQuotaBytes(ctx, owner); limit > 0 {
+		used, err := m.store.OwnerArtifactBytes(ctx, repo.OwnerID)
+		if err != nil {
+			return err
+		}
+		if used+size > limit {
+			name := repo.OwnerID
+			if owner != nil {
+				name = owner.Username
+			}
+			return fmt.Errorf("%w: owner %s holds %d of their %d bytes, the upload needs %d more", ErrQuotaExceeded, name, used, limit, size)
+		}
+	}
+	return nil
 }
 
 // Effective private-by-default for new repos in a namespace
@@ -224,14 +721,24 @@ func (m *Manager) StaleUploadAge(ctx context.Context) time.Duration {
 	return time.Duration(hours) * time.Hour
 }
 
+// Idle time before a blob moves to the cold tier, zero when tiering is off
+// or no cold tier is configured
+func (m *Manager) ColdAfter(ctx context.Context) time.Duration {
+	tiering := m.res.System(ctx).GetArtifacts().GetTiering()
+	if !tiering.GetEnabled() || tiering.GetColdAfterDays() <= 0 || m.blobs.ColdTier() == "" {
+		return 0
+	}
+	return time.Duration(tiering.GetColdAfterDays()) * 24 * time.Hour
+}
+
 // Resolves the effective policy then prunes the repo
 func (m *Manager) ApplyRetention(ctx context.Context, repo *storage.ArtifactRepository) error {
-	return m.ApplyRetentionPolicy(ctx, repo.ID, m.EffectiveRetention(ctx, repo.Namespace))
+	return m.ApplyRetentionPolicy(ctx, repo.ID, m.RepoRetention(ctx, repo))
 }
 
 // Prunes per path plus property set group then caps total size
 func (m *Manager) ApplyRetentionPolicy(ctx context.Context, repoID int64, p RetentionPolicy) error {
-	if !p.Enabled || (p.MaxVersions <= 0 && p.MaxAgeDays <= 0 && p.MaxTotalSize <= 0) {
+	if !p.Active() {
 		return nil
 	}
 
@@ -239,6 +746,35 @@ func (m *Manager) ApplyRetentionPolicy(ctx context.Context, repoID int64, p Rete
 	if err != nil {
 		return err
 	}
+	for _, d := range planRetention(all, p, time.Now().UTC()) {
+		if err := m.DeleteArtifact(ctx, d.a); err != nil {
+			return err
+		}
+		if d.capped {
+			m.log.Info("retention size-capped artifact %s (%s@%s) from repo %d", d.a.ID, d.a.Path, d.a.Version, repoID)
+		} else {
+			m.log.Info("retention pruned artifact %s (%s@%s) from repo %d", d.a.ID, d.a.Path, d.a.Version, repoID)
+		}
+	}
+	return nil
+}
+
+// True when the policy can prune anything
+func (p RetentionPolicy) Active() bool {
+	return p.Enabled && (p.MaxVersions > 0 || p.MaxAgeDays > 0 || p.MaxTotalSize > 0)
+}
+
+// One artifact a retention run deletes
+type retentionDelete struct {
+	a      *storage.Artifact
+	capped bool // Removed by the total size cap rather than count or age
+}
+
+// Deletions a retention run at now makes, in the order it makes them
+func planRetention(all []*storage.Artifact, p RetentionPolicy, now time.Time) []retentionDelete {
+	if !p.Active() {
+		return nil
+	}
 
 	byGroup := make(map[string][]*storage.Artifact)
 	for _, a := range all {
@@ -248,7 +784,7 @@ func (m *Manager) ApplyRetentionPolicy(ctx context.Context, repoID int64, p Rete
 
 	var cutoff time.Time
 	if p.MaxAgeDays > 0 {
-		cutoff = time.Now().UTC().AddDate(0, 0, -p.MaxAgeDays)
+		cutoff = now.AddDate(0, 0, -p.MaxAgeDays)
 	}
 
 	// Phase 1 prunes by version count and age, tracks survivors
@@ -257,15 +793,22 @@ func (m *Manager) ApplyRetentionPolicy(ctx context.Context, repoID int64, p Rete
 		protected bool
 	}
 	var survivors []survivor
+	var deletes []retentionDelete
 	for _, group := range byGroup {
 		sort.Slice(group, func(i, j int) bool {
 			return group[i].CreatedAt.After(group[j].CreatedAt)
 		})
+		slot := 0 // Kept artifacts take no version slot
 		for i, artifact := range group {
+			if p.Keeps(artifact.Properties) {
+				survivors = append(survivors, survivor{a: artifact, protected: true})
+				continue
+			}
 			prune := false
-			if p.MaxVersions > 0 && i >= p.MaxVersions {
+			if p.MaxVersions > 0 && slot >= p.MaxVersions {
 				prune = true
 			}
+			slot++
 			if !cutoff.IsZero() && artifact.CreatedAt.Before(cutoff) && !(p.ExcludeLatest && i == 0) {
 				prune = true
 			}
@@ -273,10 +816,7 @@ func (m *Manager) ApplyRetentionPolicy(ctx context.Context, repoID int64, p Rete
 				survivors = append(survivors, survivor{a: artifact, protected: p.ExcludeLatest && i == 0})
 				continue
 			}
-			if err := m.DeleteArtifact(ctx, artifact); err != nil {
-				return err
-			}
-			m.log.Info("retention pruned artifact %s (%s@%s) from repo %d", artifact.ID, artifact.Path, artifact.Version, repoID)
+			deletes = append(deletes, retentionDelete{a: artifact})
 		}
 	}
 
@@ -297,15 +837,12 @@ func (m *Manager) ApplyRetentionPolicy(ctx context.Context, repoID int64, p Rete
 				if s.protected {
 					continue
 				}
-				if err := m.DeleteArtifact(ctx, s.a); err != nil {
-					return err
-				}
+				deletes = append(deletes, retentionDelete{a: s.a, capped: true})
 				total -= s.a.Size
-				m.log.Info("retention size-capped artifact %s (%s@%s) from repo %d", s.a.ID, s.a.Path, s.a.Version, repoID)
 			}
 		}
 	}
-	return nil
+	return deletes
 }
 
 // Deletes blob once digest has no references
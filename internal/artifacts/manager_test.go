@@ -0,0 +1,453 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/jobs"
+	"github.com/nickheyer/distroface/internal/journal"
+	"github.com/nickheyer/distroface/internal/policy"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/pages"
+	v1proto "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// If-not-exists keeps matching content and refuses different content,
+// overwrite clears every property variant at the version and path
+func TestCompleteUploadWriteOptions(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "pipe"})
+	e.uploadArtifact(token, "pipe", "1.0", "app.bin", "build-a", map[string]string{"run": "1"})
+
+	ctx := context.Background()
+	repo := e.repoByName("pipe")
+	complete := func(content string, props map[string]string, opts WriteOptions) (bool, error) {
+		t.Helper()
+		id, err := e.blobs.InitiateUpload()
+		if err != nil {
+			t.Fatalf("InitiateUpload: %v", err)
+		}
+		if _, err := e.blobs.AppendChunk(id, strings.NewReader(content)); err != nil {
+			t.Fatalf("AppendChunk: %v", err)
+		}
+		_, skipped, err := e.manager.CompleteUploadWith(ctx, repo, id, "1.0", "app.bin", "", props, opts)
+		return skipped, err
+	}
+	count := func() int {
+		t.Helper()
+		list, err := e.store.ListArtifactsAtPath(ctx, repo.ID, "1.0", "app.bin")
+		if err != nil {
+			t.Fatalf("ListArtifactsAtPath: %v", err)
+		}
+		return len(list)
+	}
+
+	// Rerun with a new run property but the same bytes is a no-op
+	skipped, err := complete("build-a", map[string]string{"run": "2"}, WriteOptions{IfNotExists: true})
+	if err != nil || !skipped || count() != 1 {
+		t.Fatalf("same content: skipped=%v err=%v rows=%d", skipped, err, count())
+	}
+
+	skipped, err = complete("build-b", map[string]string{"run": "2"}, WriteOptions{IfNotExists: true})
+	if !errors.Is(err, ErrExists) || skipped || count() != 1 {
+		t.Fatalf("different content: skipped=%v err=%v rows=%d", skipped, err, count())
+	}
+	if len(e.blobFiles()) != 1 {
+		t.Fatalf("refused upload left its blob behind: %d blobs", len(e.blobFiles()))
+	}
+
+	// Plain writes still add property variants
+	if _, err := complete("build-c", map[string]string{"run": "3"}, WriteOptions{}); err != nil || count() != 2 {
+		t.Fatalf("variant write: err=%v rows=%d", err, count())
+	}
+
+	if _, err := complete("build-d", map[string]string{"run": "4"}, WriteOptions{IfNotExists: true, Overwrite: true}); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	list, _ := e.store.ListArtifactsAtPath(ctx, repo.ID, "1.0", "app.bin")
+	if len(list) != 1 || list[0].Properties["run"] != "4" {
+		t.Fatalf("overwrite left %d rows: %+v", len(list), list)
+	}
+	if len(e.blobFiles()) != 1 {
+		t.Fatalf("overwritten blobs not GC'd: %d blobs", len(e.blobFiles()))
+	}
+}
+
+// Paths written with Windows separators or a leading "./" land on the
+// same artifact as the slash path
+func TestNormalizedUploadPaths(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "win"})
+
+	ctx := context.Background()
+	repo := e.repoByName("win")
+	for _, p := range []string{`bin\win64\app.exe`, "./bin/win64/app.exe"} {
+		id, err := e.blobs.InitiateUpload()
+		if err != nil {
+			t.Fatalf("InitiateUpload: %v", err)
+		}
+		if _, err := e.blobs.AppendChunk(id, strings.NewReader("exe")); err != nil {
+			t.Fatalf("AppendChunk: %v", err)
+		}
+		a, _, err := e.manager.CompleteUploadWith(ctx, repo, id, "1.0", p, "", nil, WriteOptions{IfNotExists: true})
+		if err != nil {
+			t.Fatalf("upload %q: %v", p, err)
+		}
+		if a.Path != "bin/win64/app.exe" {
+			t.Fatalf("upload %q stored as %q", p, a.Path)
+		}
+	}
+	list, _ := e.store.ListArtifactsAtPath(ctx, repo.ID, "1.0", "bin/win64/app.exe")
+	if len(list) != 1 {
+		t.Fatalf("got %d rows at the normalized path, want 1", len(list))
+	}
+	if _, _, err := e.manager.CompleteUploadWith(ctx, repo, "x", "1.0", `..\escape`, "", nil, WriteOptions{}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("traversal with backslashes: %v", err)
+	}
+}
+
+// Uploads and property edits are held to the namespace property limits
+func TestPropertyLimits(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "pipe"})
+	e.uploadArtifact(token, "pipe", "1.0", "app.bin", "build-a", map[string]string{"run": "1"})
+
+	ctx := context.Background()
+	patch := &v1proto.Settings{Artifacts: &v1proto.ArtifactSettings{
+		Properties: &v1proto.ArtifactPropertyLimits{MaxProperties: proto.Int32(2), MaxValueLength: proto.Int32(8)},
+	}}
+	paths := []string{"artifacts.properties.max_properties", "artifacts.properties.max_value_length"}
+	if _, err := e.res.Update(ctx, v1proto.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", patch, paths); err != nil {
+		t.Fatalf("settings update: %v", err)
+	}
+
+	repo := e.repoByName("pipe")
+	for name, props := range map[string]map[string]string{
+		"too many":     {"a": "1", "b": "2", "c": "3"},
+		"long value":   {"run": "123456789"},
+		"bad key":      {"run id": "1"},
+		"empty key":    {"": "1"},
+		"control char": {"run": "1\n2"},
+	} {
+		id, err := e.blobs.InitiateUpload()
+		if err != nil {
+			t.Fatalf("InitiateUpload: %v", err)
+		}
+		if _, _, err := e.manager.CompleteUploadWith(ctx, repo, id, "2.0", "app.bin", "", props, WriteOptions{}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: upload err = %v, want ErrInvalid", name, err)
+		}
+		if err := e.manager.ValidateProperties(ctx, repo, props); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: validate err = %v, want ErrInvalid", name, err)
+		}
+	}
+
+	id := e.artifactID("pipe", "1.0", "app.bin")
+	w := e.doJSON("PUT", "/api/v1/artifacts/pipe/"+id+"/properties", token, map[string]string{"run": "123456789"})
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "exceeds 8 characters") {
+		t.Fatalf("oversized property edit: %d %s", w.Code, w.Body.String())
+	}
+	if err := e.manager.ValidateProperties(ctx, repo, map[string]string{"os": "linux", "build.arch": "amd64"}); err != nil {
+		t.Fatalf("valid set rejected: %v", err)
+	}
+}
+
+// Repo tier overrides apply to their repo only and can withdraw anonymous reads
+func TestRepoTierSettings(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	for _, name := range []string{"pipe", "other"} {
+		e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": name})
+	}
+	ctx := context.Background()
+	repoScope := v1proto.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO
+
+	patch := &v1proto.Settings{Artifacts: &v1proto.ArtifactSettings{
+		MaxFileSizeMb: proto.Int64(1),
+		Retention:     &v1proto.ArtifactRetentionSettings{Enabled: proto.Bool(true), MaxVersions: proto.Int32(3)},
+	}}
+	paths := []string{"artifacts.max_file_size_mb", "artifacts.retention.enabled", "artifacts.retention.max_versions"}
+	if _, err := e.res.Update(ctx, repoScope, "alice/pipe", patch, paths); err != nil {
+		t.Fatalf("repo settings update: %v", err)
+	}
+	pipe, other := e.repoByName("pipe"), e.repoByName("other")
+	if got := e.manager.RepoMaxFileSizeBytes(ctx, pipe); got != 1<<20 {
+		t.Fatalf("repo max size = %d", got)
+	}
+	if got := e.manager.RepoMaxFileSizeBytes(ctx, other); got != 10<<20 {
+		t.Fatalf("sibling repo max size = %d, want the system value", got)
+	}
+	// Owners write their repo tier, it never lifts the system cap
+	lift := &v1proto.Settings{Artifacts: &v1proto.ArtifactSettings{MaxFileSizeMb: proto.Int64(100)}}
+	if _, err := e.res.Update(ctx, repoScope, "alice/other", lift, []string{"artifacts.max_file_size_mb"}); err != nil {
+		t.Fatalf("repo settings update: %v", err)
+	}
+	if got := e.manager.RepoMaxFileSizeBytes(ctx, other); got != 10<<20 {
+		t.Fatalf("repo tier raised max size to %d", got)
+	}
+	if p := e.manager.RepoRetention(ctx, pipe); !p.Enabled || p.MaxVersions != 3 {
+		t.Fatalf("repo retention = %+v", p)
+	}
+	if p := e.manager.RepoRetention(ctx, other); p.Enabled {
+		t.Fatalf("sibling repo picked up the override: %+v", p)
+	}
+
+	sys := &v1proto.Settings{Auth: &v1proto.AuthSettings{AnonymousAccess: proto.Bool(true), LocalEnabled: proto.Bool(true)}}
+	if _, err := e.res.Update(ctx, v1proto.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", sys, []string{"auth.anonymous_access", "auth.local_enabled"}); err != nil {
+		t.Fatalf("system settings update: %v", err)
+	}
+	off := &v1proto.Settings{Auth: &v1proto.AuthSettings{AnonymousAccess: proto.Bool(false)}}
+	if _, err := e.res.Update(ctx, repoScope, "alice/pipe", off, []string{"auth.anonymous_access"}); err != nil {
+		t.Fatalf("repo anonymous update: %v", err)
+	}
+	access := NewAccess(e.store, nil, e.res)
+	if access.CanSee(ctx, nil, pipe) || !access.CanSee(ctx, nil, other) {
+		t.Fatal("anonymous withdrawal not scoped to the repo")
+	}
+	if !access.CanSee(ctx, &auth.AuthenticatedUser{ID: "u1", Username: "bob", Provider: "local"}, pipe) {
+		t.Fatal("signed in users lost access to a public repo")
+	}
+
+	// Deleting the repo drops its tier so a later repo starts clean
+	if err := e.manager.DeleteRepository(ctx, pipe); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	if stored, _ := e.res.Stored(ctx, repoScope, "alice/pipe"); proto.Size(stored) != 0 {
+		t.Fatalf("repo tier survived the repo: %v", stored)
+	}
+}
+
+// Uploads the push policy refuses are not stored and leave no blob behind
+func TestPushPolicyDeniesUpload(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "pipe"})
+
+	var got policy.Push
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		http.Error(w, "release builds only", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	patch := &v1proto.Settings{PushPolicy: &v1proto.PushPolicySettings{Enabled: proto.Bool(true), Url: proto.String(srv.URL)}}
+	if _, err := e.res.Update(ctx, v1proto.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", patch, []string{"push_policy.enabled", "push_policy.url"}); err != nil {
+		t.Fatalf("settings update: %v", err)
+	}
+	e.manager.SetPushPolicy(policy.NewHook(e.res, e.manager.log))
+
+	id, err := e.blobs.InitiateUpload()
+	if err != nil {
+		t.Fatalf("InitiateUpload: %v", err)
+	}
+	if _, err := e.blobs.AppendChunk(id, strings.NewReader("build-a")); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	user := &auth.AuthenticatedUser{Username: "alice"}
+	_, _, err = e.manager.CompleteUploadWith(auth.WithUser(ctx, user), e.repoByName("pipe"), id, "1.0", "app.bin", "", map[string]string{"os": "linux"}, WriteOptions{})
+	if !errors.Is(err, policy.ErrDenied) || !strings.Contains(err.Error(), "release builds only") {
+		t.Fatalf("upload err = %v, want a policy denial", err)
+	}
+	if got.Event != policy.EventArtifactPush || got.User != "alice" || got.Version != "1.0" || got.Properties["os"] != "linux" || got.Size != 7 {
+		t.Fatalf("hook got %+v", got)
+	}
+	if n := len(e.blobFiles()); n != 0 {
+		t.Fatalf("denied upload left %d blobs", n)
+	}
+}
+
+// Replay after a crash drops blobs no row claims and keeps the rest
+func TestJournalReplayCollectsOrphans(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "pipe"})
+
+	dir := t.TempDir()
+	j, err := journal.Open(dir, e.manager.log)
+	if err != nil {
+		t.Fatalf("journal.Open: %v", err)
+	}
+	e.manager.SetJournal(j)
+	e.uploadArtifact(token, "pipe", "1.0", "app.bin", "build-a", nil)
+	if pending, _ := j.Pending(); len(pending) != 0 {
+		t.Fatalf("finished upload left %d journal entries", len(pending))
+	}
+	kept := e.blobFiles()
+
+	// A blob committed right before the process died, no row points at it
+	id, err := e.blobs.InitiateUpload()
+	if err != nil {
+		t.Fatalf("InitiateUpload: %v", err)
+	}
+	if _, err := e.blobs.AppendChunk(id, strings.NewReader("build-b")); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	orphan, _, _, err := e.blobs.CompleteUpload(id)
+	if err != nil {
+		t.Fatalf("CompleteUpload: %v", err)
+	}
+	artifact, err := e.store.GetArtifactByPathVersion(context.Background(), e.repoByName("pipe").ID, "1.0", "app.bin")
+	if err != nil || artifact == nil {
+		t.Fatalf("GetArtifactByPathVersion: %v", err)
+	}
+	if _, err := e.manager.beginBlobGC(orphan, artifact.Digest); err != nil {
+		t.Fatalf("beginBlobGC: %v", err)
+	}
+
+	restarted, err := journal.Open(dir, e.manager.log)
+	if err != nil {
+		t.Fatalf("journal.Open: %v", err)
+	}
+	e.manager.SetJournal(restarted)
+	if replayed, failed, err := restarted.Replay(context.Background()); err != nil || replayed != 1 || failed != 0 {
+		t.Fatalf("Replay = %d, %d, %v", replayed, failed, err)
+	}
+	if got := e.blobFiles(); len(got) != len(kept) || got[0] != kept[0] {
+		t.Fatalf("blobs after replay = %v, want %v", got, kept)
+	}
+}
+
+// With a queue, retention leaves the upload path and runs once per burst
+func TestQueuedRetention(t *testing.T) {
+	e := newTestEnv(t, &v1proto.ArtifactRetentionSettings{
+		Enabled:           proto.Bool(true),
+		MaxTotalSizeBytes: proto.Int64(10),
+		ExcludeLatest:     proto.Bool(true),
+	})
+	q := jobs.New(e.store, logger.NewWithConfig(&logger.Config{Enabled: false}), 1)
+	e.manager.SetJobs(q)
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "cap"})
+	for i := 1; i <= 4; i++ {
+		e.uploadArtifact(token, "cap", fmt.Sprintf("%d.0", i), "app.bin", fmt.Sprintf("dat%d", i), nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := e.repoByName("cap")
+	count := func() int {
+		list, _, err := e.store.ListArtifacts(ctx, repo.ID, "", 0, 0)
+		if err != nil {
+			t.Fatalf("ListArtifacts: %v", err)
+		}
+		return len(list)
+	}
+	if n := count(); n != 4 {
+		t.Fatalf("retention ran inline, %d artifacts left", n)
+	}
+	queued, total, err := e.store.ListJobs(ctx, pages.Query{}, 10, 0)
+	if err != nil || total != 1 || queued[0].Kind != JobRetention || queued[0].Subject != "alice/cap" {
+		t.Fatalf("queued jobs = %d, %v", total, err)
+	}
+
+	if err := q.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for count() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued retention left %d artifacts, want 2", count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Declared sizes are checked on initiate and hold the session to them
+func TestV1UploadReservation(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "res"})
+
+	if rec := e.do(http.MethodPost, "/api/v1/artifacts/res/upload?size=11534336", token, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("over the 10MB limit: got %d", rec.Code)
+	}
+	rec := e.do(http.MethodPost, "/api/v1/artifacts/res/upload?size=4", token, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("initiate: got %d body %q", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	if rec = e.do(http.MethodPatch, location, token, strings.NewReader("12345")); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("chunk past the reservation: got %d", rec.Code)
+	}
+	if rec = e.do(http.MethodPatch, location, token, strings.NewReader("1234")); rec.Code != http.StatusAccepted {
+		t.Fatalf("chunk within the reservation: got %d", rec.Code)
+	}
+}
+
+// Repo quotas bind their repo, owner quotas every repo the owner holds,
+// and a refused upload leaves nothing behind
+func TestArtifactQuota(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	for _, name := range []string{"pipe", "other"} {
+		e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": name})
+	}
+	ctx := context.Background()
+	patch := &v1proto.Settings{Artifacts: &v1proto.ArtifactSettings{Quota: &v1proto.ArtifactQuotaSettings{RepoMb: proto.Int64(1)}}}
+	if _, err := e.res.Update(ctx, v1proto.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, "alice/pipe", patch, []string{"artifacts.quota.repo_mb"}); err != nil {
+		t.Fatalf("repo settings update: %v", err)
+	}
+
+	const chunk = 700 << 10
+	e.uploadArtifact(token, "pipe", "1.0", "app.bin", strings.Repeat("a", chunk), nil)
+	if rec := e.do(http.MethodPost, fmt.Sprintf("/api/v1/artifacts/pipe/upload?size=%d", chunk), token, nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("declared upload past the repo quota: got %d", rec.Code)
+	}
+	complete := func(repo, content string) error {
+		t.Helper()
+		id, err := e.blobs.InitiateUpload()
+		if err != nil {
+			t.Fatalf("InitiateUpload: %v", err)
+		}
+		if _, err := e.blobs.AppendChunk(id, strings.NewReader(content)); err != nil {
+			t.Fatalf("AppendChunk: %v", err)
+		}
+		_, _, err = e.manager.CompleteUploadWith(ctx, e.repoByName(repo), id, "2.0", "app.bin", "", nil, WriteOptions{})
+		return err
+	}
+	if err := complete("pipe", strings.Repeat("b", chunk)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("upload past the repo quota: %v", err)
+	}
+	if len(e.blobFiles()) != 1 {
+		t.Fatalf("refused upload left its blob behind: %d blobs", len(e.blobFiles()))
+	}
+	if err := complete("other", strings.Repeat("b", chunk)); err != nil {
+		t.Fatalf("sibling repo held to the override: %v", err)
+	}
+
+	alice, err := e.store.GetUserByUsername(ctx, "alice")
+	if err != nil || alice == nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	alice.ArtifactQuotaMB = proto.Int64(2)
+	if err := e.store.UpdateUser(ctx, alice); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if err := complete("other", strings.Repeat("c", chunk)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("upload past the owner quota: %v", err)
+	}
+	// Refused uploads never count
+	if used, err := e.store.OwnerArtifactBytes(ctx, alice.ID); err != nil || used != 2*chunk {
+		t.Fatalf("owner bytes = %d, %v", used, err)
+	}
+
+	alice.ArtifactQuotaMB = nil
+	if err := e.store.UpdateUser(ctx, alice); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if err := complete("other", strings.Repeat("c", chunk)); err != nil {
+		t.Fatalf("upload once the override was cleared: %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/nickheyer/distroface/internal/auth"
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Middle segment of bridged names, image repos never have three segments
+const OCIBridgeSegment = "artifacts"
+
+// Artifact type stamped on bridged manifests
+const OCIArtifactType = "application/vnd.distroface.artifact.v1"
+
+// Bridged names are namespace/artifacts/repo, the rest is the distribution route
+var bridgeRoutePattern = regexp.MustCompile(`^/v2/([^/]+)/` + OCIBridgeSegment + `/([^/]+)/(manifests|blobs|tags)/(.+)$`)
+
+var ociTagPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// Versions scanned when a manifest is fetched by digest
+const bridgeDigestScanLimit = 500
+
+// Checks a registry bearer token
+type TokenVerifier interface {
+	VerifyToken(raw string) (*auth.ClaimSet, error)
+}
+
+// Serves artifact versions of opted in repositories read only under /v2 as
+// OCI artifact manifests, one layer per file. Blobs come straight from the
+// artifact blob store, nothing is copied into the registry
+type OCIBridge struct {
+	store    *stores.Store
+	manager  *Manager
+	access   *Access
+	verifier TokenVerifier
+	log      *logger.Logger
+}
+
+func NewOCIBridge(store *stores.Store, manager *Manager, enforcer *rbac.Enforcer, verifier TokenVerifier, log *logger.Logger) *OCIBridge {
+	return &OCIBridge{store: store, manager: manager, access: NewAccess(store, enforcer, manager.res), verifier: verifier, log: log}
+}
+
+// OCI name of a bridged repository
+func OCIBridgeName(namespace, name string) string {
+	return namespace + "/" + OCIBridgeSegment + "/" + name
+}
+
+// Registry names are lowercase and stricter than artifact repo names
+func ValidateOCIBridgeName(namespace, name string) error {
+	if _, err := reference.WithName(OCIBridgeName(namespace, name)); err != nil {
+		return fmt.Errorf("%s/%s cannot be exported to OCI, registry names must be lowercase", namespace, name)
+	}
+	return nil
+}
+
+// Exported repo behind a bridged name, nil when the name is not bridged
+func (b *OCIBridge) lookup(ctx context.Context, name string) (*storage.ArtifactRepository, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[1] != OCIBridgeSegment {
+		return nil, nil
+	}
+	repo, err := b.store.GetArtifactRepository(ctx, parts[0], parts[2])
+	if err != nil || repo == nil || !repo.OCIExport {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// Token handler hook, handled is false for names the bridge does not serve
+func (b *OCIBridge) BridgedPull(ctx context.Context, user *auth.AuthenticatedUser, name string) (handled, allowed bool) {
+	repo, err := b.lookup(ctx, name)
+	if err != nil {
+		b.log.Error("oci bridge: looking up %s: %v", name, err)
+		return true, false
+	}
+	if repo == nil {
+		return false, false
+	}
+	return true, b.pullable(ctx, user, repo)
+}
+
+// Bridged names of the exported repos user may see, for the registry catalog
+func (b *OCIBridge) BridgedNames(ctx context.Context, user *auth.AuthenticatedUser) ([]string, error) {
+	repos, err := b.store.ListOCIExportedRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, repo := range repos {
+		if ValidateOCIBridgeName(repo.Namespace, repo.Name) == nil && b.pullable(ctx, user, repo) {
+			names = append(names, OCIBridgeName(repo.Namespace, repo.Name))
+		}
+	}
+	return names, nil
+}
+
+// Same rules as a v1 download, role grant and repo visibility
+func (b *OCIBridge) pullable(ctx context.Context, user *auth.AuthenticatedUser, repo *storage.ArtifactRepository) bool {
+	return b.access.Allowed(user, repo, rbac.ActionPull) && b.access.CanSee(ctx, user, repo)
+}
+
+// Wrap answers bridged names and hands everything else to next
+func (b *OCIBridge) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := bridgeRoutePattern.FindStringSubmatch(r.URL.Path)
+		if m == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name := OCIBridgeName(m[1], m[2])
+		repo, err := b.lookup(r.Context(), name)
+		if err != nil {
+			ociError(w, http.StatusInternalServerError, "UNKNOWN", "repository lookup failed")
+			return
+		}
+		if repo == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			ociError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "bridged artifact repositories are read only, publish through the artifact api")
+			return
+		}
+		if !b.authorized(r, name) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="/auth/token",service="distroface-registry",scope="repository:%s:pull"`, name))
+			ociError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+			return
+		}
+
+		switch m[3] {
+		case "manifests":
+			b.serveManifest(w, r, repo, m[4])
+		case "blobs":
+			b.serveBlob(w, r, repo, m[4])
+		case "tags":
+			if m[4] != "list" {
+				ociError(w, http.StatusNotFound, "NAME_UNKNOWN", "unknown route")
+				return
+			}
+			b.serveTags(w, r, repo, name)
+		}
+	})
+}
+
+// Bearer token granting pull on exactly this name
+func (b *OCIBridge) authorized(r *http.Request, name string) bool {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || b.verifier == nil {
+		return false
+	}
+	claims, err := b.verifier.VerifyToken(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	for _, ra := range claims.Access {
+		if ra.Type == "repository" && ra.Name == name && slices.Contains(ra.Actions, "pull") {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the manifest for one version, newest artifact wins per path
+func (b *OCIBridge) buildManifest(ctx context.Context, repo *storage.ArtifactRepository, version string) ([]byte, error) {
+	arts, err := b.store.ListArtifactsByVersions(ctx, repo.ID, []string{version})
+	if err != nil || len(arts) == 0 {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var layers []ocispec.Descriptor
+	created := arts[0].CreatedAt
+	for _, a := range arts {
+		if seen[a.Path] {
+			continue
+		}
+		seen[a.Path] = true
+		if a.CreatedAt.After(created) {
+			created = a.CreatedAt
+		}
+		mediaType := a.MimeType
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		layers = append(layers, ocispec.Descriptor{
+			MediaType:   mediaType,
+			Digest:      digest.Digest(a.Digest),
+			Size:        a.Size,
+			Annotations: map[string]string{ocispec.AnnotationTitle: a.Path},
+		})
+	}
+	// Stable order so the manifest digest only moves when content does
+	slices.SortFunc(layers, func(x, y ocispec.Descriptor) int {
+		return strings.Compare(x.Annotations[ocispec.AnnotationTitle], y.Annotations[ocispec.AnnotationTitle])
+	})
+
+	manifest := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: OCIArtifactType,
+		Config:       ocispec.DescriptorEmptyJSON,
+		Layers:       layers,
+		Annotations: map[string]string{
+			ocispec.AnnotationVersion: version,
+			ocispec.AnnotationCreated: created.UTC().Format(time.RFC3339),
+		},
+	}
+	manifest.SchemaVersion = 2
+	return json.Marshal(manifest)
+}
+
+func (b *OCIBridge) serveManifest(w http.ResponseWriter, r *http.Request, repo *storage.ArtifactRepository, ref string) {
+	var body []byte
+	var err error
+	if dgst, parseErr := digest.Parse(ref); parseErr == nil {
+		body, err = b.manifestByDigest(r.Context(), repo, dgst)
+	} else if ociTagPattern.MatchString(ref) {
+		body, err = b.buildManifest(r.Context(), repo, ref)
+	}
+	if err != nil {
+		b.log.Error("oci bridge: manifest %s/%s:%s: %v", repo.Namespace, repo.Name, ref, err)
+		ociError(w, http.StatusInternalServerError, "UNKNOWN", "building manifest failed")
+		return
+	}
+	if body == nil {
+		ociError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+	w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(body).String())
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(body)
+	}
+}
+
+// Digests are not stored, rebuild recent versions until one matches
+func (b *OCIBridge) manifestByDigest(ctx context.Context, repo *storage.ArtifactRepository, want digest.Digest) ([]byte, error) {
+	versions, _, err := b.store.ListArtifactVersionPage(ctx, repo.ID, false, true, bridgeDigestScanLimit, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		body, err := b.buildManifest(ctx, repo, v)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil && digest.FromBytes(body) == want {
+			return body, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *OCIBridge) serveBlob(w http.ResponseWriter, r *http.Request, repo *storage.ArtifactRepository, ref string) {
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		ociError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest")
+		return
+	}
+	if dgst == ocispec.DescriptorEmptyJSON.Digest {
+		w.Header().Set("Content-Type", ocispec.MediaTypeEmptyJSON)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(ocispec.DescriptorEmptyJSON.Data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(ocispec.DescriptorEmptyJSON.Data)
+		}
+		return
+	}
+
+	// Only blobs this repository references, the store is shared
+	owned, err := b.store.ArtifactDigestInRepo(r.Context(), repo.ID, dgst.String())
+	if err != nil {
+		ociError(w, http.StatusInternalServerError, "UNKNOWN", "blob lookup failed")
+		return
+	}
+	if !owned {
+		ociError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	if err := b.manager.CheckDigestDownload(r.Context(), dgst.String()); err != nil {
+		if errors.Is(err, ErrQuarantined) {
+			ociError(w, http.StatusForbidden, "DENIED", err.Error())
+		} else {
+			ociError(w, http.StatusInternalServerError, "UNKNOWN", "blob lookup failed")
+		}
+		return
+	}
+	f, info, err := b.manager.Blobs().OpenBlob(dgst.String())
+	if err != nil {
+		ociError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("ETag", `"`+dgst.String()+`"`)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+func (b *OCIBridge) serveTags(w http.ResponseWriter, r *http.Request, repo *storage.ArtifactRepository, name string) {
+	// Natural version order, same as the artifact api
+	versions, _, err := b.store.ListArtifactVersionPage(r.Context(), repo.ID, true, false, 0, 0)
+	if err != nil {
+		ociError(w, http.StatusInternalServerError, "UNKNOWN", "listing versions failed")
+		return
+	}
+	tags := make([]string, 0, len(versions))
+	for _, v := range versions {
+		if ociTagPattern.MatchString(v) {
+			tags = append(tags, v)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"name": name, "tags": tags})
+}
+
+func ociError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
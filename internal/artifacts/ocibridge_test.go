@@ -0,0 +1,174 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Accepts "pull:<name>" as a token granting pull on name
+type fakeVerifier struct{}
+
+func (fakeVerifier) VerifyToken(raw string) (*auth.ClaimSet, error) {
+	name, ok := strings.CutPrefix(raw, "pull:")
+	if !ok {
+		return nil, errors.New("bad token")
+	}
+	return &auth.ClaimSet{Access: []*auth.ResourceActions{{Type: "repository", Name: name, Actions: []string{"pull"}}}}, nil
+}
+
+func TestOCIBridge(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	for _, name := range []string{"tools", "other"} {
+		if rec := e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": name}); rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: got %d", name, rec.Code)
+		}
+	}
+	e.uploadArtifact(token, "tools", "1.0", "bin/tool", "tool binary", nil)
+	e.uploadArtifact(token, "tools", "1.0", "README", "read me", nil)
+	e.uploadArtifact(token, "other", "1.0", "secret", "other content", nil)
+
+	repo := e.repoByName("tools")
+	repo.OCIExport = true
+	if err := e.store.UpdateArtifactRepository(context.Background(), repo); err != nil {
+		t.Fatalf("enable export: %v", err)
+	}
+
+	fallthroughHit := false
+	handler := NewOCIBridge(e.store, e.manager, e.enforcer, fakeVerifier{}, e.manager.log).Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fallthroughHit = true
+			w.WriteHeader(http.StatusTeapot)
+		}))
+	name := OCIBridgeName("alice", "tools")
+	get := func(method, path, tok string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	pull := "pull:" + name
+
+	rec := get(http.MethodGet, "/v2/"+name+"/manifests/1.0", "")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("WWW-Authenticate"), `scope="repository:`+name+`:pull"`) {
+		t.Fatalf("anonymous manifest: got %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec = get(http.MethodGet, "/v2/"+name+"/manifests/1.0", "pull:alice/artifacts/other"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("token for another name: got %d", rec.Code)
+	}
+
+	rec = get(http.MethodGet, "/v2/"+name+"/manifests/1.0", pull)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("manifest: got %d %s", rec.Code, rec.Body.String())
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(rec.Body.Bytes(), &manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if manifest.ArtifactType != OCIArtifactType || manifest.Config.Digest != ocispec.DescriptorEmptyJSON.Digest || len(manifest.Layers) != 2 {
+		t.Fatalf("unexpected manifest: %s", rec.Body.String())
+	}
+	if manifest.Layers[0].Annotations[ocispec.AnnotationTitle] != "README" || manifest.Layers[1].Annotations[ocispec.AnnotationTitle] != "bin/tool" {
+		t.Fatalf("layers not sorted by path: %+v", manifest.Layers)
+	}
+	manifestDigest := rec.Header().Get("Docker-Content-Digest")
+	if manifestDigest != digest.FromBytes(rec.Body.Bytes()).String() {
+		t.Fatalf("digest header %s does not match body", manifestDigest)
+	}
+
+	if rec = get(http.MethodGet, "/v2/"+name+"/manifests/"+manifestDigest, pull); rec.Code != http.StatusOK {
+		t.Fatalf("manifest by digest: got %d", rec.Code)
+	}
+	if rec = get(http.MethodGet, "/v2/"+name+"/manifests/2.0", pull); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown version: got %d", rec.Code)
+	}
+
+	layer := manifest.Layers[1]
+	rec = get(http.MethodGet, "/v2/"+name+"/blobs/"+layer.Digest.String(), pull)
+	if rec.Code != http.StatusOK || rec.Body.String() != "tool binary" {
+		t.Fatalf("blob: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec = get(http.MethodGet, "/v2/"+name+"/blobs/"+ocispec.DescriptorEmptyJSON.Digest.String(), pull); rec.Body.String() != "{}" {
+		t.Fatalf("empty config blob: got %q", rec.Body.String())
+	}
+	foreign := digest.FromString("other content").String()
+	if rec = get(http.MethodGet, "/v2/"+name+"/blobs/"+foreign, pull); rec.Code != http.StatusNotFound {
+		t.Fatalf("blob from another repo: got %d", rec.Code)
+	}
+
+	rec = get(http.MethodGet, "/v2/"+name+"/tags/list", pull)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"tags":["1.0"]`) {
+		t.Fatalf("tags: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec = get(http.MethodPut, "/v2/"+name+"/manifests/2.0", pull); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("push: got %d", rec.Code)
+	}
+
+	// Repos without export belong to the registry
+	if rec = get(http.MethodGet, "/v2/alice/artifacts/other/manifests/1.0", "pull:alice/artifacts/other"); rec.Code != http.StatusTeapot || !fallthroughHit {
+		t.Fatalf("unexported repo: got %d", rec.Code)
+	}
+}
+
+func TestOCIBridgeName(t *testing.T) {
+	if err := ValidateOCIBridgeName("alice", "tools"); err != nil {
+		t.Fatalf("lowercase name rejected: %v", err)
+	}
+	if err := ValidateOCIBridgeName("alice", "Tools"); err == nil {
+		t.Fatal("uppercase name accepted")
+	}
+}
+
+// Bridged pulls answer to the same artifact role grant as v1 downloads
+func TestOCIBridgePullRequiresArtifactGrant(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	if rec := e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": "tools"}); rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d", rec.Code)
+	}
+	repo := e.repoByName("tools")
+	repo.OCIExport = true
+	if err := e.store.UpdateArtifactRepository(context.Background(), repo); err != nil {
+		t.Fatalf("enable export: %v", err)
+	}
+	if err := e.enforcer.SetPermissionsForRole("images-only", []rbac.Permission{
+		{Resource: rbac.ResourceRepositories, Action: rbac.ActionPull, ObjectID: "*"},
+	}); err != nil {
+		t.Fatalf("SetPermissionsForRole: %v", err)
+	}
+
+	bridge := NewOCIBridge(e.store, e.manager, e.enforcer, fakeVerifier{}, e.manager.log)
+	name := OCIBridgeName("alice", "tools")
+	for _, tc := range []struct {
+		user *auth.AuthenticatedUser
+		want bool
+	}{
+		{&auth.AuthenticatedUser{Username: "bob", Roles: []string{"user"}}, true},
+		{&auth.AuthenticatedUser{Username: "carol", Roles: []string{"images-only"}}, false},
+		{&auth.AuthenticatedUser{Username: "dave"}, false},
+		{nil, false},
+	} {
+		handled, allowed := bridge.BridgedPull(context.Background(), tc.user, name)
+		if !handled || allowed != tc.want {
+			t.Errorf("BridgedPull(%v) = %v, %v, want true, %v", tc.user, handled, allowed, tc.want)
+		}
+	}
+	names, err := bridge.BridgedNames(context.Background(), &auth.AuthenticatedUser{Username: "carol", Roles: []string{"images-only"}})
+	if err != nil || len(names) != 0 {
+		t.Fatalf("catalog without an artifact grant = %v, %v", names, err)
+	}
+}
@@ -0,0 +1,145 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/nickheyer/distroface/internal/artifacts/pkgrepo"
+	storage "github.com/nickheyer/distroface/internal/db"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Package kinds recorded on ArtifactPackage rows
+const (
+	PackageKindDeb = "deb"
+	PackageKindRPM = "rpm"
+)
+
+// Where debian uploads land when they name no distribution or component
+const (
+	DefaultDebDistribution = "stable"
+	DefaultDebComponent    = "main"
+)
+
+// Upload properties that pick the apt distribution and component
+const (
+	PropDebDistribution = "deb.distribution"
+	PropDebComponent    = "deb.component"
+)
+
+var debSuitePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// Debian and rpm repos validate every upload and serve package indexes
+func IsPackageRepo(repo *storage.ArtifactRepository) bool {
+	return repo.Type == v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN || repo.Type == v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_RPM
+}
+
+// Parses an uploaded package, checks the version and path the client gave
+// against it and returns the canonical path, the properties with the
+// package facts set, and the row its index entry is built from
+func (m *Manager) inspectPackage(repo *storage.ArtifactRepository, digest, version, artifactPath string, properties map[string]string) (string, map[string]string, *storage.ArtifactPackage, error) {
+	f, _, err := m.blobs.OpenBlob(digest)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer f.Close()
+
+	props := maps.Clone(properties)
+	if props == nil {
+		props = map[string]string{}
+	}
+	var canonical string
+	var pkg *storage.ArtifactPackage
+	switch repo.Type {
+	case v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN:
+		p, err := pkgrepo.ParseDeb(f)
+		if err != nil {
+			return "", nil, nil, packageErr(err)
+		}
+		if version != p.Version {
+			return "", nil, nil, fmt.Errorf("%w: version %q does not match package version %q", ErrInvalid, version, p.Version)
+		}
+		dist, comp := props[PropDebDistribution], props[PropDebComponent]
+		if dist == "" {
+			dist = DefaultDebDistribution
+		}
+		if comp == "" {
+			comp = DefaultDebComponent
+		}
+		if !debSuitePattern.MatchString(dist) || !debSuitePattern.MatchString(comp) {
+			return "", nil, nil, fmt.Errorf("%w: distribution and component must be lowercase letters, digits, '.', '_' or '-'", ErrInvalid)
+		}
+		canonical = p.PoolPath(comp)
+		props["deb.package"] = p.Package
+		props["deb.version"] = p.Version
+		props["deb.architecture"] = p.Architecture
+		props[PropDebDistribution] = dist
+		props[PropDebComponent] = comp
+		pkg = &storage.ArtifactPackage{
+			Kind:         PackageKindDeb,
+			Name:         p.Package,
+			Version:      p.Version,
+			Arch:         p.Architecture,
+			Distribution: dist,
+			Component:    comp,
+			Index:        p.Control,
+		}
+
+	case v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_RPM:
+		p, err := pkgrepo.ParseRPM(f)
+		if err != nil {
+			return "", nil, nil, packageErr(err)
+		}
+		if version != p.EVR() {
+			return "", nil, nil, fmt.Errorf("%w: version %q does not match package version %q", ErrInvalid, version, p.EVR())
+		}
+		index, err := json.Marshal(p)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		canonical = p.PackagePath()
+		props["rpm.name"] = p.Name
+		props["rpm.version"] = p.Version
+		props["rpm.release"] = p.Release
+		props["rpm.arch"] = p.Arch
+		if p.Epoch != "" {
+			props["rpm.epoch"] = p.Epoch
+		}
+		pkg = &storage.ArtifactPackage{
+			Kind:    PackageKindRPM,
+			Name:    p.Name,
+			Version: p.EVR(),
+			Arch:    p.Arch,
+			Index:   string(index),
+		}
+	}
+
+	// A bare file name is just what the client had on disk, a directory
+	// path is a layout the package must match
+	if strings.Contains(artifactPath, "/") && artifactPath != canonical {
+		return "", nil, nil, fmt.Errorf("%w: package must be uploaded as %s", ErrInvalid, canonical)
+	}
+	if err := ValidatePath(canonical); err != nil {
+		return "", nil, nil, err
+	}
+	return canonical, props, pkg, nil
+}
+
+func packageErr(err error) error {
+	if errors.Is(err, pkgrepo.ErrInvalidPackage) {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return err
+}
+
+// Package artifacts keep the path and version their index entry names
+func CheckPackageRename(repo *storage.ArtifactRepository) error {
+	if IsPackageRepo(repo) {
+		return fmt.Errorf("%w: packages cannot be renamed, upload the package again instead", ErrInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package artifacts
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1proto "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Stands in for the openpgp signer, signatures are recognizable markers
+type stubIndexSigner struct{}
+
+func (stubIndexSigner) PackageKey(ctx context.Context, namespace, name string) (string, error) {
+	return "KEY " + namespace + "/" + name, nil
+}
+
+func (stubIndexSigner) SignIndex(ctx context.Context, namespace, name string, content []byte) ([]byte, []byte, error) {
+	return append([]byte("SIGNED\n"), content...), []byte("DETACHED"), nil
+}
+
+func testDeb(t *testing.T, control string) string {
+	t.Helper()
+	var ctl bytes.Buffer
+	gz := gzip.NewWriter(&ctl)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "./control", Mode: 0644, Size: int64(len(control)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(control))
+	tw.Close()
+	gz.Close()
+
+	var b bytes.Buffer
+	b.WriteString("!<arch>\n")
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{{"debian-binary", []byte("2.0\n")}, {"control.tar.gz", ctl.Bytes()}} {
+		fmt.Fprintf(&b, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", m.name, "0", "0", "0", "100644", len(m.data))
+		b.Write(m.data)
+		if len(m.data)%2 == 1 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// Debian repos take valid packages at their own version, file them in the
+// pool and serve them through a signed apt tree
+func TestDebianRepo(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	ctx := context.Background()
+	alice, err := e.store.GetUserByUsername(ctx, "alice")
+	if err != nil || alice == nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	repo := &storage.ArtifactRepository{Namespace: "alice", Name: "debs", OwnerID: alice.ID, Type: v1proto.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN}
+	if err := e.store.CreateArtifactRepository(ctx, repo); err != nil {
+		t.Fatalf("CreateArtifactRepository: %v", err)
+	}
+	NewPackageRepos(e.store, e.manager, e.authMgr, e.enforcer, stubIndexSigner{}, logger.New()).Register(e.mux)
+
+	deb := testDeb(t, "Package: hello\nVersion: 2.10-1\nArchitecture: amd64\nDescription: Says hello\n")
+	complete := func(content, version, path string, props map[string]string) (*storage.Artifact, error) {
+		t.Helper()
+		id, err := e.blobs.InitiateUpload()
+		if err != nil {
+			t.Fatalf("InitiateUpload: %v", err)
+		}
+		if _, err := e.blobs.AppendChunk(id, strings.NewReader(content)); err != nil {
+			t.Fatalf("AppendChunk: %v", err)
+		}
+		return e.manager.CompleteUpload(ctx, repo, id, version, path, "", props)
+	}
+
+	for name, tc := range map[string]struct{ content, version, path string }{
+		"not a package":    {"plain text", "2.10-1", ""},
+		"version mismatch": {deb, "2.10", ""},
+		"foreign layout":   {deb, "2.10-1", "debs/hello.deb"},
+	} {
+		if _, err := complete(tc.content, tc.version, tc.path, nil); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err = %v, want ErrInvalid", name, err)
+		}
+	}
+	if _, err := complete(deb, "2.10-1", "", map[string]string{PropDebDistribution: "Bad/Dist"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("bad distribution: err = %v, want ErrInvalid", err)
+	}
+
+	artifact, err := complete(deb, "2.10-1", "hello.deb", map[string]string{PropDebDistribution: "bookworm"})
+	if err != nil {
+		t.Fatalf("CompleteUpload: %v", err)
+	}
+	if want := "pool/main/h/hello/hello_2.10-1_amd64.deb"; artifact.Path != want {
+		t.Errorf("path = %q, want %q", artifact.Path, want)
+	}
+	if artifact.Properties["deb.package"] != "hello" || artifact.Properties[PropDebComponent] != "main" {
+		t.Errorf("properties = %v", artifact.Properties)
+	}
+	if err := CheckPackageRename(repo); !errors.Is(err, ErrInvalid) {
+		t.Errorf("CheckPackageRename = %v, want ErrInvalid", err)
+	}
+
+	rec := e.do(http.MethodGet, "/apt/alice/debs/dists/bookworm/InRelease", "", nil)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("anonymous InRelease: got %d", rec.Code)
+	}
+	rec = e.do(http.MethodGet, "/apt/alice/debs/dists/bookworm/InRelease", token, nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "SIGNED\n") || !strings.Contains(rec.Body.String(), "Suite: bookworm\n") {
+		t.Fatalf("InRelease: got %d body %q", rec.Code, rec.Body.String())
+	}
+	rec = e.do(http.MethodGet, "/apt/alice/debs/dists/bookworm/main/binary-amd64/Packages", token, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Filename: "+artifact.Path+"\n") {
+		t.Fatalf("Packages: got %d body %q", rec.Code, rec.Body.String())
+	}
+	rec = e.do(http.MethodGet, "/apt/alice/debs/"+artifact.Path, token, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != deb {
+		t.Fatalf("pool download: got %d", rec.Code)
+	}
+	rec = e.do(http.MethodGet, "/apt/alice/debs/key.asc", token, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "KEY alice/debs" {
+		t.Fatalf("key: got %d body %q", rec.Code, rec.Body.String())
+	}
+
+	// Basic auth with an api token as the password, the way apt sends it
+	raw, _, err := e.authMgr.GenerateAPIToken(ctx, alice.ID, "apt", nil, nil)
+	if err != nil {
+		t.Fatalf("GenerateAPIToken: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/apt/alice/debs/dists/bookworm/Release", nil)
+	req.SetBasicAuth("alice", raw)
+	rec = httptest.NewRecorder()
+	e.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("basic auth Release: got %d", rec.Code)
+	}
+
+	// Wrong tree for the repo type
+	if rec = e.do(http.MethodGet, "/yum/alice/debs/repodata/repomd.xml", token, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("yum path of a debian repo: got %d", rec.Code)
+	}
+}
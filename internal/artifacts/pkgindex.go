@@ -0,0 +1,331 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/artifacts/pkgrepo"
+	"github.com/nickheyer/distroface/internal/auth"
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/portal"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Signs package indexes with a per repo openpgp key
+type IndexSigner interface {
+	PackageKey(ctx context.Context, namespace, name string) (string, error)
+	SignIndex(ctx context.Context, namespace, name string, content []byte) (clearSigned, detached []byte, err error)
+}
+
+// Serves debian repos as apt sources under /apt and rpm repos as yum
+// repositories under /yum. Indexes are built from the stored package
+// headers and rebuilt only after a package lands or goes
+type PackageRepos struct {
+	store   *stores.Store
+	manager *Manager
+	authMgr *auth.Manager
+	access  *Access
+	signer  IndexSigner
+	log     *logger.Logger
+
+	mu      sync.Mutex
+	indexes map[int64]*packageIndex
+}
+
+// Built index files of one repo keyed by their path under the repo root
+type packageIndex struct {
+	stamp string
+	built time.Time
+	files map[string][]byte
+}
+
+// Repo layout per type, the key file and where packages are fetched from
+type packageLayout struct {
+	repoType v1.ArtifactRepoType
+	keyFile  string
+	pool     string
+}
+
+var (
+	aptLayout = packageLayout{repoType: v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN, keyFile: "key.asc", pool: "pool/"}
+	yumLayout = packageLayout{repoType: v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_RPM, keyFile: "repodata/repomd.xml.key", pool: "Packages/"}
+)
+
+func NewPackageRepos(store *stores.Store, manager *Manager, authMgr *auth.Manager, enforcer *rbac.Enforcer, signer IndexSigner, log *logger.Logger) *PackageRepos {
+	return &PackageRepos{
+		store:   store,
+		manager: manager,
+		authMgr: authMgr,
+		access:  NewAccess(store, enforcer, manager.res),
+		signer:  signer,
+		log:     log,
+		indexes: make(map[int64]*packageIndex),
+	}
+}
+
+// Mounts the apt and yum trees
+func (h *PackageRepos) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /apt/{namespace}/{repo}/{file...}", func(w http.ResponseWriter, r *http.Request) { h.serve(w, r, aptLayout) })
+	mux.HandleFunc("GET /yum/{namespace}/{repo}/{file...}", func(w http.ResponseWriter, r *http.Request) { h.serve(w, r, yumLayout) })
+}
+
+func (h *PackageRepos) serve(w http.ResponseWriter, r *http.Request, layout packageLayout) {
+	ctx := r.Context()
+	namespace, name, file := r.PathValue("namespace"), r.PathValue("repo"), r.PathValue("file")
+	repo, ok := h.authorize(w, r, namespace, name)
+	if !ok {
+		return
+	}
+	if repo.Type != layout.repoType {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case file == layout.keyFile:
+		key, err := h.signer.PackageKey(ctx, repo.Namespace, repo.Name)
+		if err != nil {
+			h.log.Error("package key for repo %d: %v", repo.ID, err)
+			http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pgp-keys")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(key))
+
+	case strings.HasPrefix(file, layout.pool):
+		h.servePackage(w, r, repo, file)
+
+	default:
+		idx, err := h.index(ctx, repo)
+		if err != nil {
+			h.log.Error("package index for repo %d: %v", repo.ID, err)
+			http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+			return
+		}
+		content, ok := idx.files[file]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, file, idx.built, bytes.NewReader(content))
+	}
+}
+
+// Bearer tokens, or basic auth with an api token as the password since
+// apt and dnf only speak basic. Anonymous pulls where the repo allows them
+func (h *PackageRepos) authorize(w http.ResponseWriter, r *http.Request, namespace, name string) (*storage.ArtifactRepository, bool) {
+	ctx := r.Context()
+	var user *auth.AuthenticatedUser
+	token := auth.ExtractToken(r.Header)
+	if token == "" {
+		_, token, _ = r.BasicAuth()
+	}
+	// Certificates stand in for credentials only when none were sent
+	var certUser *auth.AuthenticatedUser
+	var certErr error
+	if token == "" {
+		certUser, certErr = h.authMgr.ClientCertUser(ctx)
+	}
+	switch {
+	case !h.authMgr.IsAnyAuthEnabled():
+		user = &auth.AuthenticatedUser{ID: "admin", Username: "admin", Roles: []string{"admin"}, Provider: "none"}
+	case token != "":
+		u, err := h.authMgr.ValidateToken(ctx, token)
+		if err != nil {
+			unauthorized(w)
+			return nil, false
+		}
+		user = u
+	case certErr != nil:
+		unauthorized(w)
+		return nil, false
+	case certUser != nil:
+		user = certUser
+	case h.authMgr.IsAnonymousAccessEnabled():
+		user = h.authMgr.AnonymousUser()
+	default:
+		unauthorized(w)
+		return nil, false
+	}
+
+	if portal.ForeignRef(ctx, namespace) {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return nil, false
+	}
+	allowed, err := h.access.enforcer.Enforce(user.Roles, rbac.ResourceArtifacts, rbac.ActionPull, namespace+"/"+name)
+	if err != nil {
+		h.log.Error("package repos: rbac enforce: %v", err)
+	}
+	repo, lookupErr := h.store.GetArtifactRepository(ctx, namespace, name)
+	if lookupErr != nil {
+		http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+		return nil, false
+	}
+	if allowed && repo != nil && user.Allows(rbac.ResourceArtifacts, rbac.ActionPull) && h.access.CanSee(ctx, user, repo) {
+		return repo, true
+	}
+	// Anonymous callers get a chance to log in, and nobody learns what exists
+	if auth.IsAnonymous(user) {
+		unauthorized(w)
+	} else {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+	}
+	return nil, false
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="distroface"`)
+	http.Error(w, "UNAUTHORIZED", http.StatusUnauthorized)
+}
+
+func (h *PackageRepos) servePackage(w http.ResponseWriter, r *http.Request, repo *storage.ArtifactRepository, file string) {
+	if err := ValidatePath(file); err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	artifact, err := h.store.GetPackageArtifactByPath(r.Context(), repo.ID, file)
+	if err != nil {
+		http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+		return
+	}
+	if artifact == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := h.manager.CheckDownload(r.Context(), artifact); err != nil {
+		if errors.Is(err, ErrQuarantined) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else {
+			http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+		}
+		return
+	}
+	f, info, err := h.manager.Blobs().OpenBlob(artifact.Digest)
+	if err != nil {
+		h.log.Error("package repos: blob missing for artifact %s (%s)", artifact.ID, artifact.Digest)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	setDigestHeaders(w.Header(), artifact.Digest)
+	http.ServeContent(w, r, artifact.Name, info.ModTime(), f)
+}
+
+// Cached index of a repo, rebuilt and re-signed when its packages changed
+func (h *PackageRepos) index(ctx context.Context, repo *storage.ArtifactRepository) (*packageIndex, error) {
+	stamp, err := h.store.ArtifactPackagesStamp(ctx, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	idx := h.indexes[repo.ID]
+	h.mu.Unlock()
+	if idx != nil && idx.stamp == stamp {
+		return idx, nil
+	}
+
+	entries, err := h.store.ListArtifactPackages(ctx, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	idx = &packageIndex{stamp: stamp, built: time.Now().UTC().Truncate(time.Second), files: make(map[string][]byte)}
+	if repo.Type == v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN {
+		err = h.buildApt(ctx, repo, newestPackages(entries), idx)
+	} else {
+		err = h.buildYum(ctx, repo, newestPackages(entries), idx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	h.indexes[repo.ID] = idx
+	h.mu.Unlock()
+	return idx, nil
+}
+
+// One entry per distribution and path. Property variants of a package in
+// the same distribution would otherwise list it twice
+func newestPackages(entries []*stores.PackageEntry) []*stores.PackageEntry {
+	newest := make(map[string]int)
+	var out []*stores.PackageEntry
+	for _, e := range entries {
+		key := e.Distribution + "\x00" + e.Path
+		if i, ok := newest[key]; ok {
+			if e.UploadedAt.After(out[i].UploadedAt) {
+				out[i] = e
+			}
+			continue
+		}
+		newest[key] = len(out)
+		out = append(out, e)
+	}
+	return out
+}
+
+func (h *PackageRepos) buildApt(ctx context.Context, repo *storage.ArtifactRepository, entries []*stores.PackageEntry, idx *packageIndex) error {
+	debs := make([]pkgrepo.DebEntry, 0, len(entries))
+	for _, e := range entries {
+		debs = append(debs, pkgrepo.DebEntry{
+			Package:      &pkgrepo.DebPackage{Package: e.Name, Version: e.Version, Architecture: e.Arch, Control: e.Index},
+			Distribution: e.Distribution,
+			Component:    e.Component,
+			Filename:     e.Path,
+			Size:         e.Size,
+			SHA256:       strings.TrimPrefix(e.Digest, "sha256:"),
+		})
+	}
+	dists := pkgrepo.BuildApt(debs, pkgrepo.AptRelease{Origin: "DistroFace", Label: repo.Namespace + "/" + repo.Name, Date: idx.built})
+	for dist, d := range dists {
+		inline, detached, err := h.signer.SignIndex(ctx, repo.Namespace, repo.Name, d.Release)
+		if err != nil {
+			return err
+		}
+		prefix := "dists/" + dist + "/"
+		idx.files[prefix+"Release"] = d.Release
+		idx.files[prefix+"InRelease"] = inline
+		idx.files[prefix+"Release.gpg"] = detached
+		for name, content := range d.Files {
+			idx.files[prefix+name] = content
+		}
+	}
+	return nil
+}
+
+func (h *PackageRepos) buildYum(ctx context.Context, repo *storage.ArtifactRepository, entries []*stores.PackageEntry, idx *packageIndex) error {
+	rpms := make([]pkgrepo.RPMEntry, 0, len(entries))
+	for _, e := range entries {
+		var p pkgrepo.RPMPackage
+		if err := json.Unmarshal([]byte(e.Index), &p); err != nil {
+			h.log.Error("package repos: rpm header of artifact %s: %v", e.ArtifactID, err)
+			continue
+		}
+		rpms = append(rpms, pkgrepo.RPMEntry{
+			Package:  &p,
+			Location: e.Path,
+			Size:     e.Size,
+			SHA256:   strings.TrimPrefix(e.Digest, "sha256:"),
+			Time:     e.UploadedAt,
+		})
+	}
+	yum := pkgrepo.BuildYum(rpms, idx.built)
+	_, detached, err := h.signer.SignIndex(ctx, repo.Namespace, repo.Name, yum.Files["repomd.xml"])
+	if err != nil {
+		return err
+	}
+	for name, content := range yum.Files {
+		idx.files["repodata/"+name] = content
+	}
+	idx.files["repodata/repomd.xml.asc"] = detached
+	return nil
+}
@@ -0,0 +1,114 @@
+package pkgrepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Architectures listed when a distribution only holds arch all packages
+var defaultDebArchitectures = []string{"amd64", "arm64"}
+
+// One package as it appears in a distribution
+type DebEntry struct {
+	Package      *DebPackage
+	Distribution string
+	Component    string
+	Filename     string // Pool path relative to the repository root
+	Size         int64
+	SHA256       string
+}
+
+// Release metadata shared by every distribution of a repository
+type AptRelease struct {
+	Origin string
+	Label  string
+	Date   time.Time
+}
+
+// Index files of one distribution, keyed by their path under dists/<dist>/.
+// Release is unsigned, InRelease and Release.gpg are for the caller to add
+type AptDist struct {
+	Release []byte
+	Files   map[string][]byte
+}
+
+// Builds Packages indexes and the Release file of every distribution the
+// entries name. Arch all packages are listed under each architecture
+func BuildApt(entries []DebEntry, meta AptRelease) map[string]*AptDist {
+	byDist := make(map[string][]DebEntry)
+	for _, e := range entries {
+		byDist[e.Distribution] = append(byDist[e.Distribution], e)
+	}
+	out := make(map[string]*AptDist, len(byDist))
+	for dist, list := range byDist {
+		out[dist] = buildAptDist(dist, list, meta)
+	}
+	return out
+}
+
+func buildAptDist(dist string, entries []DebEntry, meta AptRelease) *AptDist {
+	var archs, components []string
+	for _, e := range entries {
+		if e.Package.Architecture != "all" && !slices.Contains(archs, e.Package.Architecture) {
+			archs = append(archs, e.Package.Architecture)
+		}
+		if !slices.Contains(components, e.Component) {
+			components = append(components, e.Component)
+		}
+	}
+	if len(archs) == 0 {
+		archs = slices.Clone(defaultDebArchitectures)
+	}
+	slices.Sort(archs)
+	slices.Sort(components)
+	// Stable order keeps the index bytes and their hashes stable
+	slices.SortFunc(entries, func(a, b DebEntry) int {
+		return strings.Compare(a.Filename, b.Filename)
+	})
+
+	files := make(map[string][]byte)
+	var names []string
+	for _, comp := range components {
+		for _, arch := range archs {
+			var b bytes.Buffer
+			for _, e := range entries {
+				if e.Component != comp || (e.Package.Architecture != arch && e.Package.Architecture != "all") {
+					continue
+				}
+				if b.Len() > 0 {
+					b.WriteByte('\n')
+				}
+				b.WriteString(e.Package.Stanza(e.Filename, e.Size, e.SHA256))
+			}
+			name := comp + "/binary-" + arch + "/Packages"
+			files[name] = b.Bytes()
+			files[name+".gz"] = gzipBytes(b.Bytes())
+			names = append(names, name, name+".gz")
+		}
+	}
+
+	var rel bytes.Buffer
+	fmt.Fprintf(&rel, "Origin: %s\nLabel: %s\nSuite: %s\nCodename: %s\n", meta.Origin, meta.Label, dist, dist)
+	fmt.Fprintf(&rel, "Date: %s\n", meta.Date.UTC().Format("Mon, 02 Jan 2006 15:04:05 UTC"))
+	fmt.Fprintf(&rel, "Architectures: %s\nComponents: %s\n", strings.Join(archs, " "), strings.Join(components, " "))
+	rel.WriteString("SHA256:\n")
+	for _, name := range names {
+		sum := sha256.Sum256(files[name])
+		fmt.Fprintf(&rel, " %s %d %s\n", hex.EncodeToString(sum[:]), len(files[name]), name)
+	}
+	return &AptDist{Release: rel.Bytes(), Files: files}
+}
+
+func gzipBytes(b []byte) []byte {
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	gz.Write(b)
+	gz.Close()
+	return out.Bytes()
+}
@@ -0,0 +1,193 @@
+// Package pkgrepo reads debian and rpm packages and builds the apt and yum
+// indexes that let package managers install straight from a repository
+package pkgrepo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Packages that do not parse or break naming rules
+var ErrInvalidPackage = errors.New("invalid package")
+
+// Control files larger than this are not a package anyone should install
+const maxControlSize = 1 << 20
+
+var (
+	debNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+	debArchPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+// Binary package read from a .deb, Control is its control paragraph as shipped
+type DebPackage struct {
+	Package      string
+	Version      string
+	Architecture string
+	Control      string
+}
+
+// Reads the control paragraph of a .deb, an ar archive holding
+// debian-binary, control.tar.* and data.tar.*
+func ParseDeb(r io.Reader) (*DebPackage, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != "!<arch>\n" {
+		return nil, fmt.Errorf("%w: not a debian package", ErrInvalidPackage)
+	}
+	for {
+		var hdr [60]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("%w: no control archive", ErrInvalidPackage)
+			}
+			return nil, fmt.Errorf("%w: truncated archive", ErrInvalidPackage)
+		}
+		if string(hdr[58:60]) != "`\n" {
+			return nil, fmt.Errorf("%w: corrupt archive member", ErrInvalidPackage)
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(hdr[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%w: corrupt archive member size", ErrInvalidPackage)
+		}
+		member := io.LimitReader(br, size)
+		if strings.HasPrefix(name, "control.tar") {
+			control, err := readControl(name, member)
+			if err != nil {
+				return nil, err
+			}
+			return newDebPackage(control)
+		}
+		// Members are padded to an even length
+		if _, err := io.CopyN(io.Discard, br, size+size%2); err != nil {
+			return nil, fmt.Errorf("%w: truncated archive", ErrInvalidPackage)
+		}
+	}
+}
+
+func readControl(member string, r io.Reader) (string, error) {
+	var plain io.Reader
+	switch path.Ext(member) {
+	case ".tar":
+		plain = r
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrInvalidPackage, member, err)
+		}
+		defer gz.Close()
+		plain = gz
+	case ".xz":
+		x, err := xz.NewReader(r)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrInvalidPackage, member, err)
+		}
+		plain = x
+	case ".zst":
+		z, err := zstd.NewReader(r)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrInvalidPackage, member, err)
+		}
+		defer z.Close()
+		plain = z
+	default:
+		return "", fmt.Errorf("%w: unsupported control compression %s", ErrInvalidPackage, member)
+	}
+
+	tr := tar.NewReader(plain)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			return "", fmt.Errorf("%w: control file missing", ErrInvalidPackage)
+		}
+		if path.Clean(h.Name) != "control" || h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if h.Size > maxControlSize {
+			return "", fmt.Errorf("%w: control file too large", ErrInvalidPackage)
+		}
+		b, err := io.ReadAll(io.LimitReader(tr, maxControlSize))
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+		}
+		return string(b), nil
+	}
+}
+
+func newDebPackage(control string) (*DebPackage, error) {
+	control = strings.TrimSpace(strings.ReplaceAll(control, "\r\n", "\n"))
+	fields := ParseControl(control)
+	p := &DebPackage{
+		Package:      fields["Package"],
+		Version:      fields["Version"],
+		Architecture: fields["Architecture"],
+		Control:      control,
+	}
+	switch {
+	case !debNamePattern.MatchString(p.Package):
+		return nil, fmt.Errorf("%w: bad or missing Package field %q", ErrInvalidPackage, p.Package)
+	case p.Version == "" || strings.ContainsAny(p.Version, " /\\"):
+		return nil, fmt.Errorf("%w: bad or missing Version field %q", ErrInvalidPackage, p.Version)
+	case !debArchPattern.MatchString(p.Architecture):
+		return nil, fmt.Errorf("%w: bad or missing Architecture field %q", ErrInvalidPackage, p.Architecture)
+	}
+	return p, nil
+}
+
+// Fields of one control paragraph, continuation lines folded into their field
+func ParseControl(paragraph string) map[string]string {
+	fields := make(map[string]string)
+	last := ""
+	for _, line := range strings.Split(paragraph, "\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if last != "" {
+				fields[last] += "\n" + line
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		last = strings.TrimSpace(key)
+		fields[last] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+// Pool path apt fetches the package from, pool/main/h/hello/hello_1.0-1_amd64.deb.
+// The epoch is not part of file names
+func (p *DebPackage) PoolPath(component string) string {
+	prefix := p.Package[:1]
+	if strings.HasPrefix(p.Package, "lib") && len(p.Package) > 3 {
+		prefix = p.Package[:4]
+	}
+	version := p.Version
+	if _, rest, ok := strings.Cut(version, ":"); ok {
+		version = rest
+	}
+	return "pool/" + component + "/" + prefix + "/" + p.Package + "/" + p.Package + "_" + version + "_" + p.Architecture + ".deb"
+}
+
+// Control paragraph with the fields apt needs to fetch and verify the file
+func (p *DebPackage) Stanza(filename string, size int64, sha256 string) string {
+	var b bytes.Buffer
+	b.WriteString(p.Control)
+	fmt.Fprintf(&b, "\nFilename: %s\nSize: %d\nSHA256: %s\n", filename, size, sha256)
+	return b.String()
+}
@@ -0,0 +1,292 @@
+package pkgrepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Minimal .deb, an ar archive with a gzipped control.tar
+func buildDeb(t *testing.T, control string) []byte {
+	t.Helper()
+	var ctl bytes.Buffer
+	gz := gzip.NewWriter(&ctl)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "./control", Mode: 0644, Size: int64(len(control)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(control))
+	tw.Close()
+	gz.Close()
+
+	var b bytes.Buffer
+	b.WriteString("!<arch>\n")
+	member := func(name string, data []byte) {
+		fmt.Fprintf(&b, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", name, "0", "0", "0", "100644", len(data))
+		b.Write(data)
+		if len(data)%2 == 1 {
+			b.WriteByte('\n')
+		}
+	}
+	member("debian-binary", []byte("2.0\n"))
+	member("control.tar.gz", ctl.Bytes())
+	member("data.tar.xz", nil)
+	return b.Bytes()
+}
+
+type rpmTag struct {
+	tag int32
+	typ int32
+	val any // string, []string or []int32
+}
+
+// Header structure holding the given entries
+func rpmHeaderBytes(tags []rpmTag) []byte {
+	var index, store bytes.Buffer
+	for _, e := range tags {
+		count := 1
+		switch v := e.val.(type) {
+		case string:
+			store.WriteString(v)
+			store.WriteByte(0)
+		case []string:
+			count = len(v)
+			for _, s := range v {
+				store.WriteString(s)
+				store.WriteByte(0)
+			}
+		case []int32:
+			for store.Len()%4 != 0 {
+				store.WriteByte(0)
+			}
+			count = len(v)
+		}
+		offset := store.Len()
+		if ints, ok := e.val.([]int32); ok {
+			for _, n := range ints {
+				binary.Write(&store, binary.BigEndian, n)
+			}
+		} else {
+			offset -= len(strings.Join(asStrings(e.val), "\x00")) + 1
+		}
+		binary.Write(&index, binary.BigEndian, []int32{e.tag, e.typ, int32(offset), int32(count)})
+	}
+	var b bytes.Buffer
+	b.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&b, binary.BigEndian, []uint32{uint32(len(tags)), uint32(store.Len())})
+	b.Write(index.Bytes())
+	b.Write(store.Bytes())
+	return b.Bytes()
+}
+
+func asStrings(v any) []string {
+	if s, ok := v.(string); ok {
+		return []string{s}
+	}
+	return v.([]string)
+}
+
+// Lead, an empty signature header and a main header, no payload
+func buildRPM(tags []rpmTag) []byte {
+	var b bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	b.Write(lead)
+	sig := rpmHeaderBytes([]rpmTag{{tag: 1000, typ: typeInt32, val: []int32{0}}})
+	b.Write(sig)
+	for b.Len()%8 != 0 {
+		b.WriteByte(0)
+	}
+	b.Write(rpmHeaderBytes(tags))
+	return b.Bytes()
+}
+
+var helloRPM = []rpmTag{
+	{tagName, typeString, "hello"},
+	{tagVersion, typeString, "1.2"},
+	{tagRelease, typeString, "3.el9"},
+	{tagEpoch, typeInt32, []int32{2}},
+	{tagSummary, typeI18NString, "Says hello"},
+	{tagArch, typeString, "x86_64"},
+	{tagSourceRPM, typeString, "hello-1.2-3.el9.src.rpm"},
+	{tagProvideName, typeStringArray, []string{"hello", "hello(x86-64)"}},
+	{tagProvideFlags, typeInt32, []int32{senseEqual, senseEqual}},
+	{tagProvideVersion, typeStringArray, []string{"2:1.2-3.el9", "2:1.2-3.el9"}},
+	{tagRequireName, typeStringArray, []string{"libc.so.6", "rpmlib(CompressedFileNames)", "bash"}},
+	{tagRequireFlags, typeInt32, []int32{0, senseLess | senseEqual | 1<<24, senseGreater | senseEqual | sensePrereq}},
+	{tagRequireVersion, typeStringArray, []string{"", "3.0.4-1", "5.0"}},
+	{tagDirNames, typeStringArray, []string{"/usr/bin/", "/usr/share/doc/hello/"}},
+	{tagBaseNames, typeStringArray, []string{"hello", "README"}},
+	{tagDirIndexes, typeInt32, []int32{0, 1}},
+	{tagChangelogTime, typeInt32, []int32{1700000000}},
+	{tagChangelogName, typeStringArray, []string{"Dev <dev@example.com> - 1.2-3"}},
+	{tagChangelogText, typeStringArray, []string{"- First release"}},
+}
+
+const helloControl = `Package: hello
+Version: 1:2.10-3
+Architecture: amd64
+Maintainer: Dev <dev@example.com>
+Description: Says hello
+ A longer description
+ over two lines.
+`
+
+func TestParseDeb(t *testing.T) {
+	p, err := ParseDeb(bytes.NewReader(buildDeb(t, helloControl)))
+	if err != nil {
+		t.Fatalf("ParseDeb: %v", err)
+	}
+	if p.Package != "hello" || p.Version != "1:2.10-3" || p.Architecture != "amd64" {
+		t.Fatalf("parsed %+v", p)
+	}
+	if got, want := p.PoolPath("main"), "pool/main/h/hello/hello_2.10-3_amd64.deb"; got != want {
+		t.Errorf("PoolPath = %q, want %q", got, want)
+	}
+	if got := ParseControl(p.Control)["Description"]; !strings.HasSuffix(got, "over two lines.") {
+		t.Errorf("continuation lines not folded: %q", got)
+	}
+
+	lib := &DebPackage{Package: "libfoo1", Version: "1.0", Architecture: "all"}
+	if got, want := lib.PoolPath("contrib"), "pool/contrib/libf/libfoo1/libfoo1_1.0_all.deb"; got != want {
+		t.Errorf("PoolPath = %q, want %q", got, want)
+	}
+
+	for name, data := range map[string][]byte{
+		"not ar":       []byte("hello world"),
+		"no package":   buildDeb(t, "Version: 1.0\nArchitecture: amd64\n"),
+		"bad arch":     buildDeb(t, "Package: hello\nVersion: 1.0\nArchitecture: AMD 64\n"),
+		"bad version":  buildDeb(t, "Package: hello\nVersion: 1.0/2\nArchitecture: amd64\n"),
+		"truncated ar": buildDeb(t, helloControl)[:80],
+	} {
+		if _, err := ParseDeb(bytes.NewReader(data)); !errors.Is(err, ErrInvalidPackage) {
+			t.Errorf("%s: err = %v, want ErrInvalidPackage", name, err)
+		}
+	}
+}
+
+func TestParseRPM(t *testing.T) {
+	p, err := ParseRPM(bytes.NewReader(buildRPM(helloRPM)))
+	if err != nil {
+		t.Fatalf("ParseRPM: %v", err)
+	}
+	if p.Name != "hello" || p.EVR() != "2:1.2-3.el9" || p.Arch != "x86_64" || p.Summary != "Says hello" {
+		t.Fatalf("parsed %+v", p)
+	}
+	if got, want := p.PackagePath(), "Packages/h/hello-1.2-3.el9.x86_64.rpm"; got != want {
+		t.Errorf("PackagePath = %q, want %q", got, want)
+	}
+	if len(p.Files) != 2 || p.Files[0].Path != "/usr/bin/hello" {
+		t.Errorf("files = %+v", p.Files)
+	}
+	if len(p.Requires) != 3 || p.Requires[2].Flags != "GE" || !p.Requires[2].Pre || p.Requires[2].Version != "5.0" {
+		t.Errorf("requires = %+v", p.Requires)
+	}
+	if p.HeaderStart <= 96 || p.HeaderEnd <= p.HeaderStart {
+		t.Errorf("header range %d-%d", p.HeaderStart, p.HeaderEnd)
+	}
+
+	// No source rpm tag marks a source package
+	src := append([]rpmTag(nil), helloRPM[:6]...)
+	src[5] = rpmTag{tagArch, typeString, "x86_64"}
+	s, err := ParseRPM(bytes.NewReader(buildRPM(src)))
+	if err != nil || s.Arch != "src" {
+		t.Errorf("source rpm arch = %v, %v", s, err)
+	}
+
+	if _, err := ParseRPM(strings.NewReader("not an rpm at all")); !errors.Is(err, ErrInvalidPackage) {
+		t.Errorf("err = %v, want ErrInvalidPackage", err)
+	}
+	noName := append([]rpmTag(nil), helloRPM[1:]...)
+	if _, err := ParseRPM(bytes.NewReader(buildRPM(noName))); !errors.Is(err, ErrInvalidPackage) {
+		t.Errorf("nameless rpm: err = %v, want ErrInvalidPackage", err)
+	}
+}
+
+func TestBuildApt(t *testing.T) {
+	hello, err := ParseDeb(bytes.NewReader(buildDeb(t, helloControl)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := &DebPackage{Package: "hello-doc", Version: "1.0", Architecture: "all", Control: "Package: hello-doc\nVersion: 1.0\nArchitecture: all"}
+	dists := BuildApt([]DebEntry{
+		{Package: hello, Distribution: "stable", Component: "main", Filename: hello.PoolPath("main"), Size: 10, SHA256: "aa"},
+		{Package: docs, Distribution: "stable", Component: "main", Filename: docs.PoolPath("main"), Size: 5, SHA256: "bb"},
+		{Package: docs, Distribution: "testing", Component: "extra", Filename: docs.PoolPath("extra"), Size: 5, SHA256: "bb"},
+	}, AptRelease{Origin: "DistroFace", Label: "acme/debs", Date: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)})
+
+	if len(dists) != 2 {
+		t.Fatalf("dists = %d, want 2", len(dists))
+	}
+	stable := dists["stable"]
+	packages := string(stable.Files["main/binary-amd64/Packages"])
+	if !strings.Contains(packages, "Package: hello\n") || !strings.Contains(packages, "Package: hello-doc\n") {
+		t.Errorf("amd64 Packages misses an entry:\n%s", packages)
+	}
+	if !strings.Contains(packages, "Filename: pool/main/h/hello/hello_2.10-3_amd64.deb\nSize: 10\nSHA256: aa\n") {
+		t.Errorf("amd64 Packages lacks fetch fields:\n%s", packages)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(stable.Files["main/binary-amd64/Packages.gz"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, _ := io.ReadAll(gz); string(plain) != packages {
+		t.Error("Packages.gz does not match Packages")
+	}
+
+	release := string(stable.Release)
+	for _, want := range []string{"Suite: stable\n", "Architectures: amd64\n", "Components: main\n", "Date: Fri, 02 Jan 2026 03:04:05 UTC\n", " main/binary-amd64/Packages.gz\n"} {
+		if !strings.Contains(release, want) {
+			t.Errorf("Release misses %q:\n%s", want, release)
+		}
+	}
+	// Only arch all packages, the default architectures are listed
+	if rel := string(dists["testing"].Release); !strings.Contains(rel, "Architectures: amd64 arm64\n") {
+		t.Errorf("testing Release:\n%s", rel)
+	}
+}
+
+func TestBuildYum(t *testing.T) {
+	p, err := ParseRPM(bytes.NewReader(buildRPM(helloRPM)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := BuildYum([]RPMEntry{{Package: p, Location: p.PackagePath(), Size: 42, SHA256: "cc", Time: time.Unix(1700000000, 0)}}, time.Unix(1700000100, 0))
+
+	repomd := string(repo.Files["repomd.xml"])
+	for _, want := range []string{`<data type="primary">`, `<location href="repodata/primary.xml.gz">`, "<revision>1700000100</revision>"} {
+		if !strings.Contains(repomd, want) {
+			t.Errorf("repomd.xml misses %q:\n%s", want, repomd)
+		}
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(repo.Files["primary.xml.gz"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := io.ReadAll(gz)
+	primary := string(plain)
+	if !strings.Contains(repomd, sha256Hex(plain)) || !strings.Contains(repomd, sha256Hex(repo.Files["primary.xml.gz"])) {
+		t.Error("repomd.xml checksums do not match primary.xml.gz")
+	}
+	for _, want := range []string{
+		`<version epoch="2" ver="1.2" rel="3.el9">`,
+		`<checksum type="sha256" pkgid="YES">cc</checksum>`,
+		`<location href="Packages/h/hello-1.2-3.el9.x86_64.rpm">`,
+		`<rpm:entry name="bash" flags="GE" epoch="0" ver="5.0" pre="1">`,
+		`<file>/usr/bin/hello</file>`,
+	} {
+		if !strings.Contains(primary, want) {
+			t.Errorf("primary.xml misses %q:\n%s", want, primary)
+		}
+	}
+	if strings.Contains(primary, "rpmlib(") || strings.Contains(primary, "README") {
+		t.Errorf("primary.xml lists rpmlib requires or non primary files:\n%s", primary)
+	}
+}
@@ -0,0 +1,384 @@
+package pkgrepo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Header tags read from an rpm, see rpmtag.h
+const (
+	tagName            = 1000
+	tagVersion         = 1001
+	tagRelease         = 1002
+	tagEpoch           = 1003
+	tagSummary         = 1004
+	tagDescription     = 1005
+	tagBuildTime       = 1006
+	tagBuildHost       = 1007
+	tagSize            = 1009
+	tagVendor          = 1011
+	tagLicense         = 1014
+	tagPackager        = 1015
+	tagGroup           = 1016
+	tagURL             = 1020
+	tagArch            = 1022
+	tagFileModes       = 1030
+	tagFileFlags       = 1037
+	tagSourceRPM       = 1044
+	tagArchiveSize     = 1046
+	tagProvideName     = 1047
+	tagRequireFlags    = 1048
+	tagRequireName     = 1049
+	tagRequireVersion  = 1050
+	tagConflictFlags   = 1053
+	tagConflictName    = 1054
+	tagConflictVersion = 1055
+	tagChangelogTime   = 1080
+	tagChangelogName   = 1081
+	tagChangelogText   = 1082
+	tagObsoleteName    = 1090
+	tagProvideFlags    = 1112
+	tagProvideVersion  = 1113
+	tagObsoleteFlags   = 1114
+	tagObsoleteVersion = 1115
+	tagDirIndexes      = 1116
+	tagBaseNames       = 1117
+	tagDirNames        = 1118
+)
+
+// Header entry types
+const (
+	typeInt16       = 3
+	typeInt32       = 4
+	typeInt64       = 5
+	typeString      = 6
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+// Dependency sense bits
+const (
+	senseLess       = 1 << 1
+	senseGreater    = 1 << 2
+	senseEqual      = 1 << 3
+	sensePrereq     = 1 << 6
+	senseScriptPre  = 1 << 9
+	senseScriptPost = 1 << 10
+)
+
+const (
+	fileFlagGhost = 1 << 6
+	fileModeDir   = 0o040000
+	fileModeMask  = 0o170000
+)
+
+// Headers larger than this are refused instead of buffered
+const maxHeaderSize = 64 << 20
+
+var rpmNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._+-]*$`)
+
+// Package read from an rpm header, what the yum indexes describe
+type RPMPackage struct {
+	Name          string         `json:"name"`
+	Epoch         string         `json:"epoch,omitempty"`
+	Version       string         `json:"version"`
+	Release       string         `json:"release"`
+	Arch          string         `json:"arch"`
+	Summary       string         `json:"summary,omitempty"`
+	Description   string         `json:"description,omitempty"`
+	Packager      string         `json:"packager,omitempty"`
+	URL           string         `json:"url,omitempty"`
+	License       string         `json:"license,omitempty"`
+	Vendor        string         `json:"vendor,omitempty"`
+	Group         string         `json:"group,omitempty"`
+	BuildHost     string         `json:"build_host,omitempty"`
+	SourceRPM     string         `json:"source_rpm,omitempty"`
+	BuildTime     int64          `json:"build_time,omitempty"`
+	InstalledSize int64          `json:"installed_size,omitempty"`
+	ArchiveSize   int64          `json:"archive_size,omitempty"`
+	HeaderStart   int64          `json:"header_start"`
+	HeaderEnd     int64          `json:"header_end"`
+	Provides      []RPMDep       `json:"provides,omitempty"`
+	Requires      []RPMDep       `json:"requires,omitempty"`
+	Conflicts     []RPMDep       `json:"conflicts,omitempty"`
+	Obsoletes     []RPMDep       `json:"obsoletes,omitempty"`
+	Files         []RPMFile      `json:"files,omitempty"`
+	Changelogs    []RPMChangelog `json:"changelogs,omitempty"`
+}
+
+type RPMDep struct {
+	Name    string `json:"name"`
+	Flags   string `json:"flags,omitempty"` // LT, GT, EQ, LE or GE, empty when unversioned
+	Epoch   string `json:"epoch,omitempty"`
+	Version string `json:"version,omitempty"`
+	Release string `json:"release,omitempty"`
+	Pre     bool   `json:"pre,omitempty"`
+}
+
+type RPMFile struct {
+	Path  string `json:"path"`
+	Dir   bool   `json:"dir,omitempty"`
+	Ghost bool   `json:"ghost,omitempty"`
+}
+
+type RPMChangelog struct {
+	Author string `json:"author"`
+	Date   int64  `json:"date"`
+	Text   string `json:"text"`
+}
+
+// [epoch:]version-release, how the package names its own version
+func (p *RPMPackage) EVR() string {
+	evr := p.Version + "-" + p.Release
+	if p.Epoch != "" && p.Epoch != "0" {
+		evr = p.Epoch + ":" + evr
+	}
+	return evr
+}
+
+// Location yum fetches the package from, Packages/h/hello-1.0-1.x86_64.rpm
+func (p *RPMPackage) PackagePath() string {
+	return "Packages/" + strings.ToLower(p.Name[:1]) + "/" + p.Name + "-" + p.Version + "-" + p.Release + "." + p.Arch + ".rpm"
+}
+
+type rpmHeader struct {
+	entries map[int32]headerEntry
+	store   []byte
+}
+
+type headerEntry struct {
+	typ, offset, count int32
+}
+
+// Reads the lead, signature and main header of an rpm, the payload is left unread
+func ParseRPM(r io.Reader) (*RPMPackage, error) {
+	br := bufio.NewReader(r)
+	lead := make([]byte, 96)
+	if _, err := io.ReadFull(br, lead); err != nil || !bytes.Equal(lead[:4], []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return nil, fmt.Errorf("%w: not an rpm package", ErrInvalidPackage)
+	}
+	offset := int64(len(lead))
+
+	// The signature header is padded to eight bytes
+	_, n, err := readRPMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	offset += n
+	if pad := (8 - n%8) % 8; pad > 0 {
+		if _, err := io.CopyN(io.Discard, br, pad); err != nil {
+			return nil, fmt.Errorf("%w: truncated signature", ErrInvalidPackage)
+		}
+		offset += pad
+	}
+
+	h, n, err := readRPMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	p := &RPMPackage{
+		Name:          h.str(tagName),
+		Version:       h.str(tagVersion),
+		Release:       h.str(tagRelease),
+		Arch:          h.str(tagArch),
+		Summary:       h.str(tagSummary),
+		Description:   h.str(tagDescription),
+		Packager:      h.str(tagPackager),
+		URL:           h.str(tagURL),
+		License:       h.str(tagLicense),
+		Vendor:        h.str(tagVendor),
+		Group:         h.str(tagGroup),
+		BuildHost:     h.str(tagBuildHost),
+		SourceRPM:     h.str(tagSourceRPM),
+		BuildTime:     h.int(tagBuildTime),
+		InstalledSize: h.int(tagSize),
+		ArchiveSize:   h.int(tagArchiveSize),
+		HeaderStart:   offset,
+		HeaderEnd:     offset + n,
+	}
+	if epochs := h.ints(tagEpoch); len(epochs) > 0 {
+		p.Epoch = strconv.FormatInt(epochs[0], 10)
+	}
+	// Source packages carry no source rpm of their own
+	if p.SourceRPM == "" {
+		p.Arch = "src"
+	}
+	switch {
+	case !rpmNamePattern.MatchString(p.Name):
+		return nil, fmt.Errorf("%w: bad or missing name %q", ErrInvalidPackage, p.Name)
+	case p.Version == "" || strings.ContainsAny(p.Version, "-/ "):
+		return nil, fmt.Errorf("%w: bad or missing version %q", ErrInvalidPackage, p.Version)
+	case p.Release == "" || strings.ContainsAny(p.Release, "-/ "):
+		return nil, fmt.Errorf("%w: bad or missing release %q", ErrInvalidPackage, p.Release)
+	case !rpmNamePattern.MatchString(p.Arch):
+		return nil, fmt.Errorf("%w: bad or missing arch %q", ErrInvalidPackage, p.Arch)
+	}
+
+	p.Provides = h.deps(tagProvideName, tagProvideFlags, tagProvideVersion)
+	p.Requires = h.deps(tagRequireName, tagRequireFlags, tagRequireVersion)
+	p.Conflicts = h.deps(tagConflictName, tagConflictFlags, tagConflictVersion)
+	p.Obsoletes = h.deps(tagObsoleteName, tagObsoleteFlags, tagObsoleteVersion)
+
+	dirs, bases, indexes := h.strs(tagDirNames), h.strs(tagBaseNames), h.ints(tagDirIndexes)
+	modes, flags := h.ints(tagFileModes), h.ints(tagFileFlags)
+	for i, base := range bases {
+		if i >= len(indexes) || int(indexes[i]) >= len(dirs) {
+			break
+		}
+		f := RPMFile{Path: dirs[indexes[i]] + base}
+		if i < len(modes) {
+			f.Dir = modes[i]&fileModeMask == fileModeDir
+		}
+		if i < len(flags) {
+			f.Ghost = flags[i]&fileFlagGhost != 0
+		}
+		p.Files = append(p.Files, f)
+	}
+
+	times, names, texts := h.ints(tagChangelogTime), h.strs(tagChangelogName), h.strs(tagChangelogText)
+	for i := range times {
+		if i >= len(names) || i >= len(texts) {
+			break
+		}
+		p.Changelogs = append(p.Changelogs, RPMChangelog{Author: names[i], Date: times[i], Text: texts[i]})
+	}
+	return p, nil
+}
+
+// Reads one header structure, returns it with its length in bytes
+func readRPMHeader(r io.Reader) (*rpmHeader, int64, error) {
+	var intro [16]byte
+	if _, err := io.ReadFull(r, intro[:]); err != nil || !bytes.Equal(intro[:3], []byte{0x8e, 0xad, 0xe8}) {
+		return nil, 0, fmt.Errorf("%w: bad header magic", ErrInvalidPackage)
+	}
+	count := int64(binary.BigEndian.Uint32(intro[8:12]))
+	size := int64(binary.BigEndian.Uint32(intro[12:16]))
+	if count*16+size > maxHeaderSize {
+		return nil, 0, fmt.Errorf("%w: header too large", ErrInvalidPackage)
+	}
+	index := make([]byte, count*16)
+	if _, err := io.ReadFull(r, index); err != nil {
+		return nil, 0, fmt.Errorf("%w: truncated header", ErrInvalidPackage)
+	}
+	h := &rpmHeader{entries: make(map[int32]headerEntry, count), store: make([]byte, size)}
+	if _, err := io.ReadFull(r, h.store); err != nil {
+		return nil, 0, fmt.Errorf("%w: truncated header", ErrInvalidPackage)
+	}
+	for i := int64(0); i < count; i++ {
+		e := index[i*16 : i*16+16]
+		h.entries[int32(binary.BigEndian.Uint32(e[0:4]))] = headerEntry{
+			typ:    int32(binary.BigEndian.Uint32(e[4:8])),
+			offset: int32(binary.BigEndian.Uint32(e[8:12])),
+			count:  int32(binary.BigEndian.Uint32(e[12:16])),
+		}
+	}
+	return h, 16 + count*16 + size, nil
+}
+
+// Strings of a string, string array or i18n entry, nil when absent or corrupt
+func (h *rpmHeader) strs(tag int32) []string {
+	e, ok := h.entries[tag]
+	if !ok || (e.typ != typeString && e.typ != typeStringArray && e.typ != typeI18NString) {
+		return nil
+	}
+	if e.offset < 0 || int(e.offset) > len(h.store) {
+		return nil
+	}
+	rest := h.store[e.offset:]
+	var out []string
+	for i := int32(0); i < e.count; i++ {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return out
+		}
+		out = append(out, string(rest[:end]))
+		rest = rest[end+1:]
+	}
+	return out
+}
+
+// First string of an entry, i18n entries give their default locale
+func (h *rpmHeader) str(tag int32) string {
+	if s := h.strs(tag); len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+func (h *rpmHeader) ints(tag int32) []int64 {
+	e, ok := h.entries[tag]
+	if !ok || e.offset < 0 || e.count < 0 {
+		return nil
+	}
+	width := map[int32]int{typeInt16: 2, typeInt32: 4, typeInt64: 8}[e.typ]
+	if width == 0 || int(e.offset)+width*int(e.count) > len(h.store) {
+		return nil
+	}
+	out := make([]int64, e.count)
+	for i := range out {
+		b := h.store[int(e.offset)+i*width:]
+		switch width {
+		case 2:
+			out[i] = int64(binary.BigEndian.Uint16(b))
+		case 4:
+			out[i] = int64(binary.BigEndian.Uint32(b))
+		default:
+			out[i] = int64(binary.BigEndian.Uint64(b))
+		}
+	}
+	return out
+}
+
+func (h *rpmHeader) int(tag int32) int64 {
+	if v := h.ints(tag); len(v) > 0 {
+		return v[0]
+	}
+	return 0
+}
+
+func (h *rpmHeader) deps(nameTag, flagTag, versionTag int32) []RPMDep {
+	names, flags, versions := h.strs(nameTag), h.ints(flagTag), h.strs(versionTag)
+	var out []RPMDep
+	for i, name := range names {
+		d := RPMDep{Name: name}
+		var f int64
+		if i < len(flags) {
+			f = flags[i]
+		}
+		d.Pre = f&(sensePrereq|senseScriptPre|senseScriptPost) != 0
+		switch f & (senseLess | senseGreater | senseEqual) {
+		case senseLess:
+			d.Flags = "LT"
+		case senseGreater:
+			d.Flags = "GT"
+		case senseEqual:
+			d.Flags = "EQ"
+		case senseLess | senseEqual:
+			d.Flags = "LE"
+		case senseGreater | senseEqual:
+			d.Flags = "GE"
+		}
+		if i < len(versions) && versions[i] != "" {
+			d.Epoch, d.Version, d.Release = splitEVR(versions[i])
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// Splits [epoch:]version[-release]
+func splitEVR(evr string) (epoch, version, release string) {
+	if e, rest, ok := strings.Cut(evr, ":"); ok {
+		epoch, evr = e, rest
+	}
+	if i := strings.LastIndexByte(evr, '-'); i >= 0 {
+		return epoch, evr[:i], evr[i+1:]
+	}
+	return epoch, evr, ""
+}
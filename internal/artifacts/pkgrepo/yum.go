@@ -0,0 +1,320 @@
+package pkgrepo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"slices"
+	"strings"
+	"time"
+)
+
+// One package as yum sees it
+type RPMEntry struct {
+	Package  *RPMPackage
+	Location string // Path relative to the repository root
+	Size     int64
+	SHA256   string
+	Time     time.Time // Upload time, what createrepo takes from the file mtime
+}
+
+// Repodata files keyed by their path under repodata/, repomd.xml included
+// unsigned
+type YumRepo struct {
+	Files map[string][]byte
+}
+
+// Builds primary, filelists and other metadata and the repomd.xml naming them
+func BuildYum(entries []RPMEntry, revision time.Time) *YumRepo {
+	slices.SortFunc(entries, func(a, b RPMEntry) int {
+		return strings.Compare(a.Location, b.Location)
+	})
+
+	primary := xmlPrimary{Xmlns: "http://linux.duke.edu/metadata/common", XmlnsRPM: "http://linux.duke.edu/metadata/rpm", Count: len(entries)}
+	filelists := xmlFilelists{Xmlns: "http://linux.duke.edu/metadata/filelists", Count: len(entries)}
+	other := xmlOther{Xmlns: "http://linux.duke.edu/metadata/other", Count: len(entries)}
+	for _, e := range entries {
+		primary.Packages = append(primary.Packages, primaryPackage(e))
+		p := e.Package
+		version := xmlVersion{Epoch: epochOrZero(p.Epoch), Ver: p.Version, Rel: p.Release}
+
+		fl := xmlFilelistPackage{PkgID: e.SHA256, Name: p.Name, Arch: p.Arch, Version: version}
+		for _, f := range p.Files {
+			fl.Files = append(fl.Files, xmlFile(f))
+		}
+		filelists.Packages = append(filelists.Packages, fl)
+
+		op := xmlOtherPackage{PkgID: e.SHA256, Name: p.Name, Arch: p.Arch, Version: version}
+		for _, c := range p.Changelogs {
+			op.Changelogs = append(op.Changelogs, xmlChangelog{Author: c.Author, Date: c.Date, Text: c.Text})
+		}
+		other.Packages = append(other.Packages, op)
+	}
+
+	ts := revision.Unix()
+	repomd := xmlRepomd{Xmlns: "http://linux.duke.edu/metadata/repo", XmlnsRPM: "http://linux.duke.edu/metadata/rpm", Revision: ts}
+	files := make(map[string][]byte)
+	for _, part := range []struct {
+		kind string
+		doc  any
+	}{{"primary", primary}, {"filelists", filelists}, {"other", other}} {
+		raw := marshalXML(part.doc)
+		gz := gzipBytes(raw)
+		name := part.kind + ".xml.gz"
+		files[name] = gz
+		repomd.Data = append(repomd.Data, xmlRepomdData{
+			Type:         part.kind,
+			Checksum:     xmlChecksum{Type: "sha256", Value: sha256Hex(gz)},
+			OpenChecksum: xmlChecksum{Type: "sha256", Value: sha256Hex(raw)},
+			Location:     xmlLocation{Href: "repodata/" + name},
+			Timestamp:    ts,
+			Size:         len(gz),
+			OpenSize:     len(raw),
+		})
+	}
+	files["repomd.xml"] = marshalXML(repomd)
+	return &YumRepo{Files: files}
+}
+
+func primaryPackage(e RPMEntry) xmlPackage {
+	p := e.Package
+	pkg := xmlPackage{
+		Type:        "rpm",
+		Name:        p.Name,
+		Arch:        p.Arch,
+		Version:     xmlVersion{Epoch: epochOrZero(p.Epoch), Ver: p.Version, Rel: p.Release},
+		Checksum:    xmlPkgChecksum{Type: "sha256", PkgID: "YES", Value: e.SHA256},
+		Summary:     p.Summary,
+		Description: p.Description,
+		Packager:    p.Packager,
+		URL:         p.URL,
+		Time:        xmlTime{File: e.Time.Unix(), Build: p.BuildTime},
+		Size:        xmlSize{Package: e.Size, Installed: p.InstalledSize, Archive: p.ArchiveSize},
+		Location:    xmlLocation{Href: e.Location},
+		Format: xmlFormat{
+			License:     p.License,
+			Vendor:      p.Vendor,
+			Group:       p.Group,
+			BuildHost:   p.BuildHost,
+			SourceRPM:   p.SourceRPM,
+			HeaderRange: xmlHeaderRange{Start: p.HeaderStart, End: p.HeaderEnd},
+			Provides:    xmlDeps(p.Provides, false),
+			Requires:    xmlDeps(p.Requires, true),
+			Conflicts:   xmlDeps(p.Conflicts, false),
+			Obsoletes:   xmlDeps(p.Obsoletes, false),
+		},
+	}
+	// Like createrepo, primary lists only the files dependencies usually name
+	for _, f := range p.Files {
+		if strings.HasPrefix(f.Path, "/etc/") || strings.Contains(f.Path, "bin/") || f.Path == "/usr/lib/sendmail" {
+			pkg.Format.Files = append(pkg.Format.Files, xmlFile(f))
+		}
+	}
+	return pkg
+}
+
+func xmlDeps(deps []RPMDep, requires bool) *xmlEntries {
+	var out []xmlEntry
+	for _, d := range deps {
+		// rpmlib capabilities are satisfied by rpm itself
+		if requires && strings.HasPrefix(d.Name, "rpmlib(") {
+			continue
+		}
+		e := xmlEntry{Name: d.Name, Flags: d.Flags, Ver: d.Version, Rel: d.Release}
+		if d.Flags != "" {
+			e.Epoch = epochOrZero(d.Epoch)
+		}
+		if requires && d.Pre {
+			e.Pre = "1"
+		}
+		out = append(out, e)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return &xmlEntries{Entries: out}
+}
+
+func xmlFile(f RPMFile) xmlFileEntry {
+	e := xmlFileEntry{Path: f.Path}
+	switch {
+	case f.Ghost:
+		e.Type = "ghost"
+	case f.Dir:
+		e.Type = "dir"
+	}
+	return e
+}
+
+func epochOrZero(epoch string) string {
+	if epoch == "" {
+		return "0"
+	}
+	return epoch
+}
+
+func marshalXML(v any) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	// Every field is a string or number, encoding cannot fail
+	_ = enc.Encode(v)
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ── Repodata documents ───────────────────────────────────────────────────
+
+type xmlPrimary struct {
+	XMLName  xml.Name     `xml:"metadata"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	XmlnsRPM string       `xml:"xmlns:rpm,attr"`
+	Count    int          `xml:"packages,attr"`
+	Packages []xmlPackage `xml:"package"`
+}
+
+type xmlPackage struct {
+	Type        string         `xml:"type,attr"`
+	Name        string         `xml:"name"`
+	Arch        string         `xml:"arch"`
+	Version     xmlVersion     `xml:"version"`
+	Checksum    xmlPkgChecksum `xml:"checksum"`
+	Summary     string         `xml:"summary"`
+	Description string         `xml:"description"`
+	Packager    string         `xml:"packager"`
+	URL         string         `xml:"url"`
+	Time        xmlTime        `xml:"time"`
+	Size        xmlSize        `xml:"size"`
+	Location    xmlLocation    `xml:"location"`
+	Format      xmlFormat      `xml:"format"`
+}
+
+type xmlVersion struct {
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+type xmlPkgChecksum struct {
+	Type  string `xml:"type,attr"`
+	PkgID string `xml:"pkgid,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlTime struct {
+	File  int64 `xml:"file,attr"`
+	Build int64 `xml:"build,attr"`
+}
+
+type xmlSize struct {
+	Package   int64 `xml:"package,attr"`
+	Installed int64 `xml:"installed,attr"`
+	Archive   int64 `xml:"archive,attr"`
+}
+
+type xmlLocation struct {
+	Href string `xml:"href,attr"`
+}
+
+type xmlFormat struct {
+	License     string         `xml:"rpm:license"`
+	Vendor      string         `xml:"rpm:vendor"`
+	Group       string         `xml:"rpm:group"`
+	BuildHost   string         `xml:"rpm:buildhost"`
+	SourceRPM   string         `xml:"rpm:sourcerpm"`
+	HeaderRange xmlHeaderRange `xml:"rpm:header-range"`
+	Provides    *xmlEntries    `xml:"rpm:provides,omitempty"`
+	Requires    *xmlEntries    `xml:"rpm:requires,omitempty"`
+	Conflicts   *xmlEntries    `xml:"rpm:conflicts,omitempty"`
+	Obsoletes   *xmlEntries    `xml:"rpm:obsoletes,omitempty"`
+	Files       []xmlFileEntry `xml:"file"`
+}
+
+type xmlHeaderRange struct {
+	Start int64 `xml:"start,attr"`
+	End   int64 `xml:"end,attr"`
+}
+
+type xmlEntries struct {
+	Entries []xmlEntry `xml:"rpm:entry"`
+}
+
+type xmlEntry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr,omitempty"`
+	Epoch string `xml:"epoch,attr,omitempty"`
+	Ver   string `xml:"ver,attr,omitempty"`
+	Rel   string `xml:"rel,attr,omitempty"`
+	Pre   string `xml:"pre,attr,omitempty"`
+}
+
+type xmlFileEntry struct {
+	Type string `xml:"type,attr,omitempty"`
+	Path string `xml:",chardata"`
+}
+
+type xmlFilelists struct {
+	XMLName  xml.Name             `xml:"filelists"`
+	Xmlns    string               `xml:"xmlns,attr"`
+	Count    int                  `xml:"packages,attr"`
+	Packages []xmlFilelistPackage `xml:"package"`
+}
+
+type xmlFilelistPackage struct {
+	PkgID   string         `xml:"pkgid,attr"`
+	Name    string         `xml:"name,attr"`
+	Arch    string         `xml:"arch,attr"`
+	Version xmlVersion     `xml:"version"`
+	Files   []xmlFileEntry `xml:"file"`
+}
+
+type xmlOther struct {
+	XMLName  xml.Name          `xml:"otherdata"`
+	Xmlns    string            `xml:"xmlns,attr"`
+	Count    int               `xml:"packages,attr"`
+	Packages []xmlOtherPackage `xml:"package"`
+}
+
+type xmlOtherPackage struct {
+	PkgID      string         `xml:"pkgid,attr"`
+	Name       string         `xml:"name,attr"`
+	Arch       string         `xml:"arch,attr"`
+	Version    xmlVersion     `xml:"version"`
+	Changelogs []xmlChangelog `xml:"changelog"`
+}
+
+type xmlChangelog struct {
+	Author string `xml:"author,attr"`
+	Date   int64  `xml:"date,attr"`
+	Text   string `xml:",chardata"`
+}
+
+type xmlRepomd struct {
+	XMLName  xml.Name        `xml:"repomd"`
+	Xmlns    string          `xml:"xmlns,attr"`
+	XmlnsRPM string          `xml:"xmlns:rpm,attr"`
+	Revision int64           `xml:"revision"`
+	Data     []xmlRepomdData `xml:"data"`
+}
+
+type xmlRepomdData struct {
+	Type         string      `xml:"type,attr"`
+	Checksum     xmlChecksum `xml:"checksum"`
+	OpenChecksum xmlChecksum `xml:"open-checksum"`
+	Location     xmlLocation `xml:"location"`
+	Timestamp    int64       `xml:"timestamp"`
+	Size         int         `xml:"size"`
+	OpenSize     int         `xml:"open-size"`
+}
+
+type xmlChecksum struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
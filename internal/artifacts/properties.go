@@ -0,0 +1,105 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Compiled key patterns by source, settings rarely change
+var keyPatterns sync.Map
+
+func keyPattern(src string) (*regexp.Regexp, error) {
+	if re, ok := keyPatterns.Load(src); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	keyPatterns.Store(src, re)
+	return re, nil
+}
+
+// Rejects negative bounds and key patterns that do not compile
+func ValidatePropertyLimits(l *v1.ArtifactPropertyLimits) error {
+	for name, v := range map[string]*int32{
+		"max properties":   l.MaxProperties,
+		"max key length":   l.MaxKeyLength,
+		"max value length": l.MaxValueLength,
+	} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%w: property %s cannot be negative", ErrInvalid, name)
+		}
+	}
+	if l.KeyPattern != nil && *l.KeyPattern != "" {
+		if _, err := keyPattern(*l.KeyPattern); err != nil {
+			return fmt.Errorf("%w: invalid property key pattern: %v", ErrInvalid, err)
+		}
+	}
+	return nil
+}
+
+// Checks a full property set against the limits. Keys must be non empty,
+// keys and values valid UTF-8 without control characters
+func CheckProperties(l *v1.ArtifactPropertyLimits, props map[string]string) error {
+	if max := int(l.GetMaxProperties()); max > 0 && len(props) > max {
+		return fmt.Errorf("%w: %d properties exceed the limit of %d per artifact", ErrInvalid, len(props), max)
+	}
+	var re *regexp.Regexp
+	if src := l.GetKeyPattern(); src != "" {
+		var err error
+		if re, err = keyPattern(src); err != nil {
+			return fmt.Errorf("property key pattern is invalid: %w", err)
+		}
+	}
+
+	// Sorted so the same set always reports the same key
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := props[k]
+		switch {
+		case k == "":
+			return fmt.Errorf("%w: property key is empty", ErrInvalid)
+		case !printable(k):
+			return fmt.Errorf("%w: property key %q contains invalid characters", ErrInvalid, k)
+		case l.GetMaxKeyLength() > 0 && utf8.RuneCountInString(k) > int(l.GetMaxKeyLength()):
+			return fmt.Errorf("%w: property key %.32q... exceeds %d characters", ErrInvalid, k, l.GetMaxKeyLength())
+		case re != nil && !re.MatchString(k):
+			return fmt.Errorf("%w: property key %q does not match %s", ErrInvalid, k, re)
+		case !printable(v):
+			return fmt.Errorf("%w: value of property %q contains invalid characters", ErrInvalid, k)
+		case l.GetMaxValueLength() > 0 && utf8.RuneCountInString(v) > int(l.GetMaxValueLength()):
+			return fmt.Errorf("%w: value of property %q exceeds %d characters", ErrInvalid, k, l.GetMaxValueLength())
+		}
+	}
+	return nil
+}
+
+func printable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks a property set against the effective limits of the repo
+func (m *Manager) ValidateProperties(ctx context.Context, repo *storage.ArtifactRepository, props map[string]string) error {
+	return CheckProperties(m.repoSettings(ctx, repo).GetProperties(), props)
+}
@@ -21,6 +21,8 @@ type ReapRun struct {
 	FinishedAt          time.Time
 	ReposScanned        int64
 	StaleUploadsRemoved int
+	BlobsDemoted        int
+	BytesDemoted        int64
 	Err                 string
 }
 
@@ -54,7 +56,15 @@ func (r *Reaper) Start() error {
 	return nil
 }
 
-// Schedule sweeps when live settings say a run is due
+// Running reports a sweep in progress
+func (r *Reaper) Running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// Schedule deletes expired artifacts every minute and sweeps when live
+// settings say a run is due
 func (r *Reaper) Schedule(ctx context.Context) {
 	go func() {
 		ticker := time.NewTicker(time.Minute)
@@ -64,6 +74,12 @@ func (r *Reaper) Schedule(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
+				// Expiries were asked for per artifact, they fire even with the reaper off
+				if n, err := r.mgr.DeleteExpired(ctx); err != nil {
+					r.log.Error("Artifact expiry: %v", err)
+				} else if n > 0 {
+					r.log.Info("Artifact expiry deleted %d artifacts", n)
+				}
 				cfg := r.mgr.res.System(ctx).GetArtifacts().GetReaper()
 				if !cfg.GetEnabled() {
 					continue
@@ -94,7 +110,6 @@ func (r *Reaper) sweep() {
 	ctx := context.Background()
 	r.log.Info("Artifact reaper started")
 
-	byNamespace := make(map[string]RetentionPolicy)
 	offset := 0
 	for {
 		repos, _, err := r.store.ListArtifactRepositories(ctx, stores.ArtifactRepoListOptions{
@@ -111,12 +126,7 @@ func (r *Reaper) sweep() {
 			break
 		}
 		for _, repo := range repos {
-			policy, ok := byNamespace[repo.Namespace]
-			if !ok {
-				policy = r.mgr.EffectiveRetention(ctx, repo.Namespace)
-				byNamespace[repo.Namespace] = policy
-			}
-			if err := r.mgr.ApplyRetentionPolicy(ctx, repo.ID, policy); err != nil {
+			if err := r.mgr.ApplyRetentionPolicy(ctx, repo.ID, r.mgr.RepoRetention(ctx, repo)); err != nil {
 				r.log.Error("Artifact reaper retention for repo %d: %v", repo.ID, err)
 			}
 			run.ReposScanned++
@@ -135,10 +145,21 @@ func (r *Reaper) sweep() {
 		}
 	}
 
+	if idle := r.mgr.ColdAfter(ctx); idle > 0 {
+		moved, bytes, err := r.mgr.Blobs().Demote(idle)
+		if err != nil {
+			r.log.Error("Artifact reaper tiering: %v", err)
+		}
+		run.BlobsDemoted, run.BytesDemoted = moved, bytes
+	}
+	if _, err := r.mgr.Blobs().RefreshUsage(); err != nil {
+		r.log.Error("Artifact reaper tier usage: %v", err)
+	}
+
 	run.FinishedAt = time.Now().UTC()
 	if run.Err == "" {
-		r.log.Info("Artifact reaper finished in %s: %d repos scanned, %d stale uploads removed",
-			run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond), run.ReposScanned, run.StaleUploadsRemoved)
+		r.log.Info("Artifact reaper finished in %s: %d repos scanned, %d stale uploads removed, %d blobs (%d bytes) moved to cold storage",
+			run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond), run.ReposScanned, run.StaleUploadsRemoved, run.BlobsDemoted, run.BytesDemoted)
 	}
 
 	r.mu.Lock()
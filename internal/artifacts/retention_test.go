@@ -2,8 +2,11 @@ package artifacts
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	storage "github.com/nickheyer/distroface/internal/db"
 	v1proto "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
@@ -86,3 +89,194 @@ func TestEffectiveRetentionOrgOverride(t *testing.T) {
 		t.Fatalf("org override not applied: enabled=%v max_versions=%d", p.Enabled, p.MaxVersions)
 	}
 }
+
+// Keep rules shield matching artifacts from count pruning
+func TestRetentionKeepRules(t *testing.T) {
+	e := newTestEnv(t, &v1proto.ArtifactRetentionSettings{
+		Enabled:     proto.Bool(true),
+		MaxVersions: proto.Int32(1),
+		KeepRules:   []*v1proto.ArtifactKeepRule{{Key: "release", Value: "true"}},
+	})
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "keep"})
+
+	release := map[string]string{"release": "true"}
+	for i := 1; i <= 3; i++ {
+		e.uploadArtifact(token, "keep", fmt.Sprintf("%d.0", i), "app.bin", fmt.Sprintf("rel%d", i), release)
+		e.uploadArtifact(token, "keep", fmt.Sprintf("%d.0", i), "nightly.bin", fmt.Sprintf("dev%d", i), nil)
+	}
+
+	ctx := context.Background()
+	repo := e.repoByName("keep")
+	list, _, err := e.store.ListArtifacts(ctx, repo.ID, "", 0, 0)
+	if err != nil {
+		t.Fatalf("ListArtifacts: %v", err)
+	}
+
+	counts := map[string]int{}
+	for _, a := range list {
+		counts[a.Path]++
+	}
+	if counts["app.bin"] != 3 {
+		t.Fatalf("expected all 3 release artifacts kept, got %d", counts["app.bin"])
+	}
+	if counts["nightly.bin"] != 1 {
+		t.Fatalf("expected nightly pruned to 1, got %d", counts["nightly.bin"])
+	}
+}
+
+// Repo override fields win over the namespace policy
+func TestRepoRetentionOverride(t *testing.T) {
+	e := newTestEnv(t, &v1proto.ArtifactRetentionSettings{
+		Enabled:     proto.Bool(true),
+		MaxVersions: proto.Int32(5),
+		KeepRules:   []*v1proto.ArtifactKeepRule{{Key: "release"}},
+	})
+	ctx := context.Background()
+
+	repo := &storage.ArtifactRepository{Namespace: "alice", Name: "app"}
+	err := e.manager.SetRepoOverrides(ctx, repo, &v1proto.ArtifactRetentionSettings{
+		MaxVersions: proto.Int32(2),
+		KeepRules:   []*v1proto.ArtifactKeepRule{{Key: "pinned", Value: "yes"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("SetRepoOverrides: %v", err)
+	}
+	p := e.manager.RepoRetention(ctx, repo)
+	if !p.Enabled || p.MaxVersions != 2 {
+		t.Fatalf("override not applied: enabled=%v max_versions=%d", p.Enabled, p.MaxVersions)
+	}
+	if p.Keeps(map[string]string{"release": "1"}) {
+		t.Fatal("override keep rules should replace the namespace rules")
+	}
+	if !p.Keeps(map[string]string{"pinned": "yes"}) {
+		t.Fatal("override keep rule should match")
+	}
+
+	if err := e.manager.SetRepoOverrides(ctx, repo, &v1proto.ArtifactRetentionSettings{
+		KeepRules: []*v1proto.ArtifactKeepRule{{Value: "x"}},
+	}, nil); err == nil {
+		t.Fatal("keep rule without key should be rejected")
+	}
+
+	// An empty message clears the repo's own policy
+	if err := e.manager.SetRepoOverrides(ctx, repo, &v1proto.ArtifactRetentionSettings{}, nil); err != nil {
+		t.Fatalf("clearing: %v", err)
+	}
+	if p := e.manager.RepoRetention(ctx, repo); p.MaxVersions != 5 || !p.Keeps(map[string]string{"release": "1"}) {
+		t.Fatalf("cleared override still applied: %+v", p)
+	}
+}
+
+// Expiries set at upload can be extended or cleared, and expired
+// artifacts go on the next sweep whatever the retention policy says
+func TestArtifactExpiry(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON("POST", "/api/v1/artifacts/repos", token, map[string]any{"name": "previews"})
+
+	ctx := context.Background()
+	repo := e.repoByName("previews")
+	upload := func(version, content string, ttl time.Duration) *storage.Artifact {
+		t.Helper()
+		id, err := e.blobs.InitiateUpload()
+		if err != nil {
+			t.Fatalf("InitiateUpload: %v", err)
+		}
+		if _, err := e.blobs.AppendChunk(id, strings.NewReader(content)); err != nil {
+			t.Fatalf("AppendChunk: %v", err)
+		}
+		a, _, err := e.manager.CompleteUploadWith(ctx, repo, id, version, "app.bin", "", nil, WriteOptions{ExpiresIn: ttl})
+		if err != nil {
+			t.Fatalf("CompleteUploadWith %s: %v", version, err)
+		}
+		return a
+	}
+
+	kept := upload("1.0", "keep", 0)
+	gone := upload("pr-1", "gone", time.Hour)
+	cleared := upload("pr-2", "cler", time.Hour)
+	if kept.ExpiresAt != nil || gone.ExpiresAt == nil {
+		t.Fatalf("expiry at upload: kept=%v gone=%v", kept.ExpiresAt, gone.ExpiresAt)
+	}
+	if err := e.manager.SetExpiry(ctx, cleared, 0); err != nil || cleared.ExpiresAt != nil {
+		t.Fatalf("clear: err=%v expires=%v", err, cleared.ExpiresAt)
+	}
+	if err := e.manager.SetExpiry(ctx, kept, -time.Hour); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("negative expiry: %v", err)
+	}
+
+	if n, err := e.manager.DeleteExpired(ctx); err != nil || n != 0 {
+		t.Fatalf("nothing due: n=%d err=%v", n, err)
+	}
+	past := time.Now().UTC().Add(-time.Minute)
+	if err := e.store.SetArtifactExpiry(ctx, gone.ID, &past); err != nil {
+		t.Fatalf("SetArtifactExpiry: %v", err)
+	}
+	if n, err := e.manager.DeleteExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("expired sweep: n=%d err=%v", n, err)
+	}
+
+	list, _, err := e.store.ListArtifacts(ctx, repo.ID, "", 0, 0)
+	if err != nil {
+		t.Fatalf("ListArtifacts: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 artifacts left, got %d", len(list))
+	}
+	for _, a := range list {
+		if a.ID == gone.ID {
+			t.Fatal("expired artifact survived the sweep")
+		}
+	}
+	if len(e.blobFiles()) != 2 {
+		t.Fatalf("expired blob not GC'd: %d blobs", len(e.blobFiles()))
+	}
+}
+
+// Forecasts replay the window's uploads and prune them like real ones
+func TestForecastRetention(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	expires := now.Add(5 * day)
+	all := []*storage.Artifact{
+		// Old enough for the age limit, goes on the next run
+		{ID: "old", Path: "app.bin", Size: 100, CreatedAt: now.Add(-60 * day)},
+		// One upload every ten days within the window
+		{ID: "a", Path: "app.bin", Size: 10, CreatedAt: now.Add(-24 * day)},
+		{ID: "b", Path: "app.bin", Size: 10, CreatedAt: now.Add(-14 * day)},
+		{ID: "c", Path: "app.bin", Size: 10, CreatedAt: now.Add(-4 * day)},
+		// Expires before the first horizon
+		{ID: "tmp", Path: "tmp.bin", Size: 7, CreatedAt: now.Add(-35 * day), ExpiresAt: &expires},
+	}
+	p := RetentionPolicy{Enabled: true, MaxAgeDays: 40}
+
+	f := forecastRetention(all, p, 30*day, []int{30, 90}, now)
+	if f.Artifacts != 5 || f.Bytes != 137 {
+		t.Fatalf("current = %d artifacts %d bytes, want 5 and 137", f.Artifacts, f.Bytes)
+	}
+	if f.NextRunArtifacts != 1 || f.NextRunBytes != 100 {
+		t.Fatalf("next run = %d artifacts %d bytes, want 1 and 100", f.NextRunArtifacts, f.NextRunBytes)
+	}
+	if f.WindowUploads != 3 || f.WindowBytes != 30 {
+		t.Fatalf("window = %d uploads %d bytes, want 3 and 30", f.WindowUploads, f.WindowBytes)
+	}
+	if len(f.Points) != 2 {
+		t.Fatalf("got %d forecast points, want 2", len(f.Points))
+	}
+	// Steady state under a 40 day limit is the last 40 days of uploads
+	for _, pt := range f.Points {
+		if pt.Artifacts != 4 || pt.Bytes != 40 {
+			t.Errorf("day %d = %d artifacts %d bytes, want 4 and 40", pt.Days, pt.Artifacts, pt.Bytes)
+		}
+	}
+	if pt := f.Points[1]; pt.Uploaded != 90 || pt.Pruned != 187 {
+		t.Errorf("day 90 uploaded %d pruned %d, want 90 and 187", pt.Uploaded, pt.Pruned)
+	}
+
+	// Without retention storage grows with the upload rate
+	f = forecastRetention(all, RetentionPolicy{}, 30*day, []int{90}, now)
+	if pt := f.Points[0]; pt.Bytes != 137-7+90 || f.NextRunArtifacts != 0 {
+		t.Errorf("no retention day 90 = %d bytes, next run %d, want %d and 0", pt.Bytes, f.NextRunArtifacts, 137-7+90)
+	}
+}
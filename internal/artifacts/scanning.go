@@ -0,0 +1,76 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/scan"
+)
+
+// Scans one artifact's blob and records the verdict, runs detached from
+// the upload request
+func (m *Manager) scanArtifact(ctx context.Context, id, digest string, size int64) scan.Verdict {
+	var verdict scan.Verdict
+	f, _, err := m.blobs.OpenBlob(digest)
+	if err != nil {
+		verdict = scan.Verdict{Status: scan.StatusError, Result: "blob unreadable: " + err.Error()}
+	} else {
+		verdict = m.scanner.Scan(ctx, f, size)
+		f.Close()
+	}
+
+	now := time.Now()
+	if err := m.store.SetArtifactScan(ctx, id, verdict.Status, verdict.Engine, verdict.Result, &now); err != nil {
+		m.log.Error("recording scan of artifact %s: %v", id, err)
+		return verdict
+	}
+	switch verdict.Status {
+	case scan.StatusInfected:
+		m.log.Warn("artifact %s (%s) quarantined, %s found %s", id, digest, verdict.Engine, verdict.Result)
+	case scan.StatusError:
+		m.log.Error("scan of artifact %s failed: %s", id, verdict.Result)
+	case scan.StatusSkipped:
+		m.log.Info("scan of artifact %s skipped: %s", id, verdict.Result)
+	}
+	return verdict
+}
+
+// Scans artifacts a restart left pending. Returns how many were queued
+func (m *Manager) ResumeScans(ctx context.Context) (int, error) {
+	if !m.scanner.Enabled(ctx) {
+		return 0, nil
+	}
+	pending, err := m.store.ListArtifactsByScanStatus(ctx, scan.StatusPending)
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range pending {
+		m.queueScan(ctx, a)
+	}
+	return len(pending), nil
+}
+
+// ErrQuarantined when the artifact's scan state forbids serving it
+func (m *Manager) CheckDownload(ctx context.Context, a *storage.Artifact) error {
+	if m.scanner.Blocks(ctx, a.ScanStatus) {
+		return fmt.Errorf("%w: %s %s scan is %s", ErrQuarantined, a.Version, a.Path, a.ScanStatus)
+	}
+	return nil
+}
+
+// CheckDownload for content addressed fetches, any blocked row sharing
+// the blob blocks it
+func (m *Manager) CheckDigestDownload(ctx context.Context, digest string) error {
+	statuses, err := m.store.ArtifactDigestScanStatuses(ctx, digest)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if m.scanner.Blocks(ctx, s) {
+			return fmt.Errorf("%w: content scan is %s", ErrQuarantined, s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package artifacts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Blob mtimes move at most this often on download, so hot reads do not
+// turn into a metadata write each
+const touchGranularity = time.Hour
+
+// Blob counts and bytes per tier
+type TierUsage struct {
+	HotBlobs  int64
+	HotBytes  int64
+	ColdBlobs int64
+	ColdBytes int64
+}
+
+// Enables the cold tier rooted at dir, copies between tiers land under
+// the target tier's _staging before they are renamed into place
+func (b *BlobStore) SetColdTier(dir string) error {
+	for _, d := range []string{filepath.Join(b.root, "_staging"), filepath.Join(dir, "_staging"), filepath.Join(dir, "blobs", "sha256")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("creating artifact cold storage: %w", err)
+		}
+	}
+	b.cold = dir
+	return nil
+}
+
+// Cold tier root, empty when tiering is not configured
+func (b *BlobStore) ColdTier() string { return b.cold }
+
+func (b *BlobStore) coldPathHex(hexDigest string) string {
+	return filepath.Join(b.cold, "blobs", "sha256", hexDigest[:2], hexDigest)
+}
+
+// Moves blobs not read or uploaded within idle to the cold tier
+func (b *BlobStore) Demote(idle time.Duration) (int, int64, error) {
+	if b.cold == "" {
+		return 0, 0, fmt.Errorf("no cold tier configured")
+	}
+	cutoff := time.Now().Add(-idle)
+	moved := 0
+	var bytes int64
+	err := filepath.WalkDir(filepath.Join(b.root, "blobs", "sha256"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(cutoff) || !hexPattern.MatchString(d.Name()) {
+			return nil
+		}
+		if err := moveFile(p, b.coldPathHex(d.Name()), filepath.Join(b.cold, "_staging")); err != nil {
+			return fmt.Errorf("demoting %s: %w", d.Name(), err)
+		}
+		moved++
+		bytes += info.Size()
+		return nil
+	})
+	return moved, bytes, err
+}
+
+// Copies a cold blob back to the hot tier, one copy per digest at a time
+func (b *BlobStore) promote(hexDigest string) {
+	if _, busy := b.promoting.LoadOrStore(hexDigest, true); busy {
+		return
+	}
+	defer b.promoting.Delete(hexDigest)
+	dest := b.blobPathHex(hexDigest)
+	if _, err := os.Stat(dest); err == nil {
+		return
+	}
+	_ = moveFile(b.coldPathHex(hexDigest), dest, filepath.Join(b.root, "_staging"))
+}
+
+// Walks both tiers and caches the result for Usage
+func (b *BlobStore) RefreshUsage() (TierUsage, error) {
+	var u TierUsage
+	var err error
+	if u.HotBlobs, u.HotBytes, err = dirUsage(filepath.Join(b.root, "blobs", "sha256")); err != nil {
+		return u, err
+	}
+	if b.cold != "" {
+		if u.ColdBlobs, u.ColdBytes, err = dirUsage(filepath.Join(b.cold, "blobs", "sha256")); err != nil {
+			return u, err
+		}
+	}
+	b.usage.Store(&u)
+	return u, nil
+}
+
+// Tier usage as of the last refresh, walked once on first use
+func (b *BlobStore) Usage() TierUsage {
+	if u := b.usage.Load(); u != nil {
+		return *u
+	}
+	u, _ := b.RefreshUsage()
+	return u
+}
+
+func dirUsage(dir string) (int64, int64, error) {
+	var count, bytes int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if info, err := d.Info(); err == nil {
+			count++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return count, bytes, err
+}
+
+// Resets the idle clock of a hot blob
+func touch(path string) {
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) < touchGranularity {
+		return
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// Renames when both paths share a filesystem, otherwise copies through
+// staging so dst never shows a partial file. Moved blobs count as fresh
+func moveFile(src, dst, staging string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(dst, now, now)
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(staging, "tier-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Remove(src)
+}
@@ -1,13 +1,18 @@
 package artifacts
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -17,10 +22,12 @@ import (
 	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/policy"
 	"github.com/nickheyer/distroface/internal/portal"
 	"github.com/nickheyer/distroface/internal/rbac"
 	"github.com/nickheyer/distroface/pkg/logger"
 	"github.com/nickheyer/distroface/pkg/pages"
+	"github.com/nickheyer/distroface/pkg/utils"
 )
 
 // Drop in v1 rest facade for old dfcli and ci
@@ -36,15 +43,13 @@ type V1API struct {
 	routes   []v1Route
 }
 
-var v1RepoNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,127}$`)
-
 func NewV1API(store *stores.Store, manager *Manager, authMgr *auth.Manager, enforcer *rbac.Enforcer, limiter *admin.Limiter, recorder *audit.Recorder, log *logger.Logger) *V1API {
 	a := &V1API{
 		store:    store,
 		manager:  manager,
 		authMgr:  authMgr,
 		enforcer: enforcer,
-		access:   NewAccess(store, enforcer),
+		access:   NewAccess(store, enforcer, manager.res),
 		limiter:  limiter,
 		recorder: recorder,
 		log:      log,
@@ -86,11 +91,20 @@ func (a *V1API) buildRoutes() {
 	add(http.MethodDelete, `^/api/v1/artifacts/repos/([^/]+)$`, []string{"repo"}, "V1Artifacts/DeleteRepo", a.handleDeleteRepo)
 	add(http.MethodPost, `^/api/v1/artifacts/([^/]+)/upload$`, []string{"repo"}, "", a.handleInitiateUpload)
 	add(http.MethodPatch, `^/api/v1/artifacts/([^/]+)/upload/([^/]+)$`, []string{"repo", "uuid"}, "", a.handleUploadChunk)
+	add(http.MethodHead, `^/api/v1/artifacts/([^/]+)/upload/([^/]+)$`, []string{"repo", "uuid"}, "", a.handleUploadStatus)
 	add(http.MethodPut, `^/api/v1/artifacts/([^/]+)/upload/([^/]+)$`, []string{"repo", "uuid"}, "V1Artifacts/CompleteUpload", a.handleCompleteUpload)
+	// Downloads answer HEAD too, range clients size the file before fetching
+	add(http.MethodGet, `^/api/v1/artifacts/content/sha256/([a-f0-9]{64})$`, []string{"hex"}, "", a.handleContentByDigest)
+	add(http.MethodHead, `^/api/v1/artifacts/content/sha256/([a-f0-9]{64})$`, []string{"hex"}, "", a.handleContentByDigest)
+	add(http.MethodGet, `^/api/v1/artifacts/([^/]+)/_latest/(.*)$`, []string{"repo", "path"}, "", a.handleLatestDownload)
+	add(http.MethodHead, `^/api/v1/artifacts/([^/]+)/_latest/(.*)$`, []string{"repo", "path"}, "", a.handleLatestDownload)
 	add(http.MethodGet, `^/api/v1/artifacts/([^/]+)/([^/]+)/(.*)$`, []string{"repo", "version", "path"}, "", a.handleDownload)
+	add(http.MethodHead, `^/api/v1/artifacts/([^/]+)/([^/]+)/(.*)$`, []string{"repo", "version", "path"}, "", a.handleDownload)
 	add(http.MethodGet, `^/api/v1/artifacts/([^/]+)/query$`, []string{"repo"}, "", a.handleQuery)
 	add(http.MethodDelete, `^/api/v1/artifacts/([^/]+)/([^/]+)/(.*)$`, []string{"repo", "version", "path"}, "V1Artifacts/DeleteArtifact", a.handleDeleteArtifact)
 	add(http.MethodGet, `^/api/v1/artifacts/([^/]+)/versions$`, []string{"repo"}, "", a.handleListVersions)
+	add(http.MethodGet, `^/api/v1/artifacts/([^/]+)/index$`, []string{"repo"}, "", a.handleIndex)
+	add(http.MethodGet, `^/api/v1/artifacts/([^/]+)/changelog$`, []string{"repo"}, "", a.handleChangelog)
 	add(http.MethodPut, `^/api/v1/artifacts/([^/]+)/([^/]+)/metadata$`, []string{"repo", "id"}, "V1Artifacts/UpdateMetadata", a.handleUpdateMetadata)
 	add(http.MethodPut, `^/api/v1/artifacts/([^/]+)/([^/]+)/properties$`, []string{"repo", "id"}, "V1Artifacts/UpdateProperties", a.handleUpdateProperties)
 	add(http.MethodGet, `^/api/v1/artifacts/search$`, nil, "", a.handleSearch)
@@ -133,8 +147,12 @@ func (a *V1API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		user, ok := a.resolveUser(w, r)
+		if ok && !user.Allows(rbac.ResourceArtifacts, v1ScopeAction(r.Method)) {
+			http.Error(w, "TOKEN SCOPE FORBIDS", http.StatusForbidden)
+			ok = false
+		}
 		if ok {
-			route.handler(w, r, user, vars)
+			route.handler(w, r.WithContext(auth.WithUser(r.Context(), user)), user, vars)
 		}
 		if rec != nil {
 			a.auditRoute(r, route.audit, user, vars, rec.status)
@@ -227,6 +245,14 @@ func (a *V1API) resolveUser(w http.ResponseWriter, r *http.Request) (*auth.Authe
 
 	token := auth.ExtractToken(r.Header)
 	if token == "" {
+		certUser, err := a.authMgr.ClientCertUser(r.Context())
+		if err != nil {
+			http.Error(w, "INVALID CERTIFICATE", http.StatusUnauthorized)
+			return nil, false
+		}
+		if certUser != nil {
+			return certUser, true
+		}
 		if a.authMgr.IsAnonymousAccessEnabled() {
 			return a.authMgr.AnonymousUser(), true
 		}
@@ -242,6 +268,14 @@ func (a *V1API) resolveUser(w http.ResponseWriter, r *http.Request) (*auth.Authe
 	return user, true
 }
 
+// Reads need artifact:read on a scoped token, everything else artifact:write
+func v1ScopeAction(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return rbac.ActionRead
+	}
+	return rbac.ActionUpdate
+}
+
 type v1AuthResponse struct {
 	Token     string    `json:"token,omitempty"`
 	ExpiresIn int       `json:"expires_in,omitempty"`
@@ -303,6 +337,11 @@ func (a *V1API) handleRefresh(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "INVALID REFRESH TOKEN", http.StatusUnauthorized)
 		return
 	}
+	// A session would shed the token's scopes
+	if user.Scoped() {
+		http.Error(w, "SCOPED TOKENS CANNOT REFRESH", http.StatusForbidden)
+		return
+	}
 
 	token, expiresAt, err := a.authMgr.IssueSession(r.Context(), user.ID)
 	if err != nil {
@@ -350,10 +389,12 @@ func (a *V1API) handleCreateRepo(w http.ResponseWriter, r *http.Request, user *a
 		return
 	}
 	req.Namespace, req.Name = portal.ScopeRepoRef(r.Context(), req.Namespace, req.Name)
-	if !v1RepoNamePattern.MatchString(req.Name) {
-		http.Error(w, "INVALID REPOSITORY NAME", http.StatusBadRequest)
+	name, err := utils.NormalizeRepoName(utils.ArtifactRepo, req.Name)
+	if err != nil {
+		http.Error(w, "INVALID REPOSITORY NAME: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	req.Name = name
 
 	ns := req.Namespace
 	if ns == "" {
@@ -367,6 +408,10 @@ func (a *V1API) handleCreateRepo(w http.ResponseWriter, r *http.Request, user *a
 		http.Error(w, "FORBIDDEN", http.StatusForbidden)
 		return
 	}
+	if err := a.access.CheckNewNamespace(r.Context(), user, ns, false); err != nil {
+		http.Error(w, "RESERVED NAMESPACE", http.StatusBadRequest)
+		return
+	}
 
 	existing, err := a.store.GetArtifactRepository(r.Context(), ns, req.Name)
 	if err != nil {
@@ -382,6 +427,7 @@ func (a *V1API) handleCreateRepo(w http.ResponseWriter, r *http.Request, user *a
 	if !isPrivate && ns != user.Username {
 		isPrivate = a.manager.EffectivePrivateByDefault(r.Context(), ns)
 	}
+	isPrivate, _ = a.manager.Visibility().ForCreate(r.Context(), user.Roles, isPrivate, false)
 	repo := &storage.ArtifactRepository{
 		Namespace:   ns,
 		Name:        req.Name,
@@ -445,9 +491,19 @@ func (a *V1API) handleInitiateUpload(w http.ResponseWriter, r *http.Request, use
 		return
 	}
 
-	uploadID, err := a.manager.Blobs().InitiateUpload()
+	// Optional declared size, reserved for the session
+	var size int64
+	if raw := r.URL.Query().Get("size"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	uploadID, err := a.manager.InitiateUpload(r.Context(), repo, size)
 	if err != nil {
-		http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+		a.writeManagerErr(w, err)
 		return
 	}
 
@@ -461,15 +517,80 @@ func (a *V1API) handleInitiateUpload(w http.ResponseWriter, r *http.Request, use
 	w.WriteHeader(http.StatusAccepted)
 }
 
-// No permission gate per chunk, v1 quirk kept
+// No permission gate per chunk, v1 quirk kept. An optional Content-Range
+// pins the chunk to the session offset like docker blob uploads, a
+// mismatch answers 416 with the range the session holds
 func (a *V1API) handleUploadChunk(w http.ResponseWriter, r *http.Request, _ *auth.AuthenticatedUser, vars map[string]string) {
-	if _, err := a.manager.Blobs().AppendChunk(vars["uuid"], r.Body); err != nil {
-		http.Error(w, "UPLOAD FAILED", http.StatusInternalServerError)
+	start, body := int64(-1), io.Reader(r.Body)
+	if raw := r.Header.Get("Content-Range"); raw != "" {
+		first, last, ok := parseContentRange(raw)
+		if !ok {
+			http.Error(w, "Invalid Content-Range", http.StatusBadRequest)
+			return
+		}
+		start, body = first, io.LimitReader(r.Body, last-first+1)
+	}
+	if err := a.manager.StorageAvailable(); err != nil {
+		a.writeManagerErr(w, err)
+		return
+	}
+	blobs := a.manager.Blobs()
+	if _, err := blobs.AppendChunkAt(vars["uuid"], start, body); err != nil {
+		switch {
+		case errors.Is(err, ErrUploadOffset):
+			if size, err := blobs.UploadSize(vars["uuid"]); err == nil {
+				setUploadRange(w, size)
+			}
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		case errors.Is(err, ErrReservationExceeded):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(w, "UPLOAD FAILED", http.StatusInternalServerError)
+		}
 		return
 	}
+	if size, err := blobs.UploadSize(vars["uuid"]); err == nil {
+		setUploadRange(w, size)
+	}
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// Offset a client resumes from, gated like chunks
+func (a *V1API) handleUploadStatus(w http.ResponseWriter, r *http.Request, _ *auth.AuthenticatedUser, vars map[string]string) {
+	size, err := a.manager.Blobs().UploadSize(vars["uuid"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	setUploadRange(w, size)
+	w.Header().Set("Upload-ID", vars["uuid"])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Range in the docker form, which reads 0-0 for an empty session, and
+// Upload-Offset which does not
+func setUploadRange(w http.ResponseWriter, size int64) {
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.Header().Set("Upload-Offset", strconv.FormatInt(size, 10))
+}
+
+// First and last byte of a "first-last" chunk range, with or without the
+// "bytes " unit and "/total" suffix of the http form
+func parseContentRange(v string) (int64, int64, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "bytes ")
+	v, _, _ = strings.Cut(v, "/")
+	a, b, ok := strings.Cut(v, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	first, err1 := strconv.ParseInt(a, 10, 64)
+	last, err2 := strconv.ParseInt(b, 10, 64)
+	if err1 != nil || err2 != nil || first < 0 || last < first {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
 func (a *V1API) handleCompleteUpload(w http.ResponseWriter, r *http.Request, user *auth.AuthenticatedUser, vars map[string]string) {
 	query := r.URL.Query()
 	version := query.Get("version")
@@ -499,7 +620,17 @@ func (a *V1API) handleCompleteUpload(w http.ResponseWriter, r *http.Request, use
 		a.log.Debug("v1 facade: bad properties body: %v", err)
 	}
 
-	artifact, err := a.manager.CompleteUpload(r.Context(), repo, vars["uuid"], version, artifactPath, "", properties)
+	// Optional, the stored content must hash to it
+	opts := WriteOptions{Checksum: r.Header.Get("X-Checksum-Sha256")}
+	if raw := query.Get("expires"); raw != "" {
+		ttl, err := utils.ParseTTL(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.ExpiresIn = ttl
+	}
+	artifact, _, err := a.manager.CompleteUploadWith(auth.WithUser(r.Context(), user), repo, vars["uuid"], version, artifactPath, "", properties, opts)
 	if err != nil {
 		a.writeManagerErr(w, err)
 		return
@@ -520,6 +651,7 @@ func (a *V1API) handleDownload(w http.ResponseWriter, r *http.Request, user *aut
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
+	vars["path"] = NormalizePath(vars["path"])
 	if err := ValidatePath(vars["path"]); err != nil {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
@@ -534,6 +666,54 @@ func (a *V1API) handleDownload(w http.ResponseWriter, r *http.Request, user *aut
 		http.Error(w, "Artifact not found", http.StatusNotFound)
 		return
 	}
+	if err := a.manager.CheckDownload(r.Context(), artifact); err != nil {
+		a.writeManagerErr(w, err)
+		return
+	}
+
+	f, info, err := a.manager.Blobs().OpenBlob(artifact.Digest)
+	if err != nil {
+		a.log.Error("v1 facade: blob missing for artifact %s (%s)", artifact.ID, artifact.Digest)
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	// Clients holding the digest revalidate to a 304 and keep their copy
+	setDigestHeaders(w.Header(), artifact.Digest)
+	http.ServeContent(w, r, artifact.Name, info.ModTime(), f)
+}
+
+// Stable address of the newest matching upload of a path. The pointer and
+// its row resolve in one query and blobs never change under a digest, so a
+// download is wholly the old or wholly the new artifact
+func (a *V1API) handleLatestDownload(w http.ResponseWriter, r *http.Request, user *auth.AuthenticatedUser, vars map[string]string) {
+	repo, ok := a.getRepo(w, r, user, a.repoNS(user, vars), vars["repo"], rbac.ActionPull)
+	if !ok {
+		return
+	}
+	if !a.access.CanSee(r.Context(), user, repo) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	vars["path"] = NormalizePath(vars["path"])
+	if err := ValidatePath(vars["path"]); err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	artifact, err := a.store.GetLatestArtifact(r.Context(), repo.ID, vars["path"])
+	if err != nil {
+		http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+		return
+	}
+	if artifact == nil {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	if err := a.manager.CheckDownload(r.Context(), artifact); err != nil {
+		a.writeManagerErr(w, err)
+		return
+	}
 
 	f, info, err := a.manager.Blobs().OpenBlob(artifact.Digest)
 	if err != nil {
@@ -542,9 +722,51 @@ func (a *V1API) handleDownload(w http.ResponseWriter, r *http.Request, user *aut
 		return
 	}
 	defer f.Close()
+	// The pointer moves, caches revalidate against the digest every time
+	w.Header().Set("X-Artifact-Version", artifact.Version)
+	setDigestHeaders(w.Header(), artifact.Digest)
+	w.Header().Set("Cache-Control", "no-cache")
 	http.ServeContent(w, r, artifact.Name, info.ModTime(), f)
 }
 
+// Content addressed fetch for pinned scripts. Any repository holding the
+// digest that the caller may pull from unlocks it, the rest answer 404 so
+// private content does not leak through its checksum
+func (a *V1API) handleContentByDigest(w http.ResponseWriter, r *http.Request, user *auth.AuthenticatedUser, vars map[string]string) {
+	digest := "sha256:" + vars["hex"]
+	repos, err := a.store.ListArtifactReposByDigest(r.Context(), digest)
+	if err != nil {
+		http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+		return
+	}
+	visible := slices.ContainsFunc(repos, func(repo *storage.ArtifactRepository) bool {
+		return !portal.ForeignRef(r.Context(), repo.Namespace) &&
+			a.can(user, rbac.ActionPull, repo.Namespace+"/"+repo.Name) &&
+			a.access.CanSee(r.Context(), user, repo)
+	})
+	if !visible {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	if err := a.manager.CheckDigestDownload(r.Context(), digest); err != nil {
+		a.writeManagerErr(w, err)
+		return
+	}
+
+	f, info, err := a.manager.Blobs().OpenBlob(digest)
+	if err != nil {
+		a.log.Error("v1 facade: blob missing for digest %s", digest)
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	// The address is the content, caches may keep it as long as they like
+	w.Header().Set("Content-Type", "application/octet-stream")
+	setDigestHeaders(w.Header(), digest)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
 // V1 name version path params as contains filters
 func v1SearchQuery(query url.Values) pages.Query {
 	var q pages.Query
@@ -578,13 +800,23 @@ func (a *V1API) handleQuery(w http.ResponseWriter, r *http.Request, user *auth.A
 		return
 	}
 
+	// Unset params fall back to the repo's defaults, stock config keeps
+	// the v1 behavior of the latest match only
 	query := r.URL.Query()
+	defaults := a.manager.RepoQueryDefaults(r.Context(), repo)
+	sortField, order := query.Get("sort"), query.Get("order")
+	if sortField == "" {
+		sortField = defaults.Sort
+	}
+	if order == "" {
+		order = defaults.Order
+	}
 	criteria := stores.ArtifactSearchCriteria{
 		RepoID:     &repo.ID,
 		Query:      v1SearchQuery(query),
 		Properties: map[string]string{},
-		OrderBy:    v1OrderBy(query.Get("sort"), query.Get("order")),
-		Limit:      1, // V1 default latest match only
+		OrderBy:    v1OrderBy(sortField, order),
+		Limit:      defaults.Num,
 	}
 	if n, err := strconv.Atoi(query.Get("num")); err == nil && n > 0 {
 		criteria.Limit = n
@@ -607,9 +839,19 @@ func (a *V1API) handleQuery(w http.ResponseWriter, r *http.Request, user *auth.A
 		http.Error(w, "No matching artifacts found", http.StatusNotFound)
 		return
 	}
+	// One quarantined match fails the archive rather than silently shrinking it
+	for _, artifact := range artifacts {
+		if err := a.manager.CheckDownload(r.Context(), artifact); err != nil {
+			a.writeManagerErr(w, err)
+			return
+		}
+	}
 
 	format := NormalizeFormat(query.Get("format"))
-	flat := query.Get("flat") == "1"
+	flat := defaults.Flat
+	if v := query.Get("flat"); v != "" {
+		flat = v == "1"
+	}
 
 	contentType := "application/zip"
 	if format == FormatTarGz {
@@ -617,6 +859,8 @@ func (a *V1API) handleQuery(w http.ResponseWriter, r *http.Request, user *auth.A
 	}
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", repo.Name+"-artifacts."+format))
+	// Built while streaming, a retry starts over
+	w.Header().Set("Accept-Ranges", "none")
 
 	if err := a.manager.WriteArchive(w, artifacts, format, flat); err != nil {
 		a.log.Error("v1 facade: archive stream for %s: %v", repo.Name, err)
@@ -646,6 +890,184 @@ func (a *V1API) handleListVersions(w http.ResponseWriter, r *http.Request, user
 	writeJSON(w, http.StatusOK, grouped)
 }
 
+// ── Checksum index ───────────────────────────────────────────────────────
+
+// One downloadable file of a version as build caches key it
+type v1IndexEntry struct {
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type v1Index struct {
+	Repo    string         `json:"repo"`
+	Version string         `json:"version"`
+	Files   []v1IndexEntry `json:"files"`
+}
+
+// Path, checksum and size of every file a version download serves, sorted
+// by path. Property variants collapse to the newest like downloads do and
+// quarantined files are left out. The ETag covers the whole listing so
+// caches revalidate with If-None-Match
+func (a *V1API) handleIndex(w http.ResponseWriter, r *http.Request, user *auth.AuthenticatedUser, vars map[string]string) {
+	repo, ok := a.getRepo(w, r, user, a.repoNS(user, vars), vars["repo"], rbac.ActionPull)
+	if !ok {
+		return
+	}
+	if !a.access.CanSee(r.Context(), user, repo) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+
+	artifacts, _, err := a.store.ListArtifacts(r.Context(), repo.ID, version, 0, 0)
+	if err != nil {
+		http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+		return
+	}
+	if len(artifacts) == 0 {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	newest := map[string]*storage.Artifact{}
+	for _, artifact := range artifacts {
+		if cur := newest[artifact.Path]; cur == nil || artifact.CreatedAt.After(cur.CreatedAt) ||
+			(artifact.CreatedAt.Equal(cur.CreatedAt) && artifact.ID > cur.ID) {
+			newest[artifact.Path] = artifact
+		}
+	}
+	index := v1Index{Repo: repo.Namespace + "/" + repo.Name, Version: version, Files: []v1IndexEntry{}}
+	for _, artifact := range newest {
+		if a.manager.CheckDownload(r.Context(), artifact) != nil {
+			continue
+		}
+		index.Files = append(index.Files, v1IndexEntry{
+			Path:   artifact.Path,
+			Sha256: strings.TrimPrefix(artifact.Digest, "sha256:"),
+			Size:   artifact.Size,
+		})
+	}
+	slices.SortFunc(index.Files, func(x, y v1IndexEntry) int { return strings.Compare(x.Path, y.Path) })
+
+	body, err := json.Marshal(index)
+	if err != nil {
+		http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"sha256:` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// ── Changelog ────────────────────────────────────────────────────────────
+
+// Rows fetched per query while streaming
+const changelogBatch = 500
+
+// One NDJSON line, resume an export by passing the last cursor as after
+type v1Change struct {
+	Cursor     int64                    `json:"cursor"`
+	Time       time.Time                `json:"time"`
+	Repo       string                   `json:"repo"`
+	Kind       string                   `json:"kind"`
+	Actor      string                   `json:"actor,omitempty"`
+	ArtifactID string                   `json:"artifact_id"`
+	Artifact   *stores.ArtifactSnapshot `json:"artifact"`
+	Previous   *stores.ArtifactSnapshot `json:"previous,omitempty"`
+}
+
+// Streams the repo's change history after the cursor as NDJSON, oldest
+// first. limit caps the lines, zero streams everything
+func (a *V1API) handleChangelog(w http.ResponseWriter, r *http.Request, user *auth.AuthenticatedUser, vars map[string]string) {
+	repo, ok := a.getRepo(w, r, user, a.repoNS(user, vars), vars["repo"], rbac.ActionRead)
+	if !ok {
+		return
+	}
+	if !a.access.CanSee(r.Context(), user, repo) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	var after int64
+	if raw := query.Get("after"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+	limit := 0
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	name := repo.Namespace + "/" + repo.Name
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	sent := 0
+	for limit == 0 || sent < limit {
+		batch := changelogBatch
+		if limit > 0 && limit-sent < batch {
+			batch = limit - sent
+		}
+		changes, err := a.store.ListArtifactChanges(r.Context(), repo.ID, after, batch)
+		if err != nil {
+			// Mid stream the status is gone, clients resume from the last line
+			a.log.Error("v1 facade: changelog of %s after %d: %v", name, after, err)
+			if sent == 0 {
+				http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
+			}
+			return
+		}
+		for _, c := range changes {
+			var data stores.ArtifactChangeData
+			if err := json.Unmarshal([]byte(c.Data), &data); err != nil {
+				a.log.Error("v1 facade: changelog entry %d of %s unreadable: %v", c.ID, name, err)
+			}
+			line := v1Change{
+				Cursor:     c.ID,
+				Time:       c.CreatedAt.UTC(),
+				Repo:       name,
+				Kind:       c.Kind,
+				Actor:      c.Actor,
+				ArtifactID: c.ArtifactID,
+				Artifact:   data.Artifact,
+				Previous:   data.Previous,
+			}
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+			after = c.ID
+		}
+		sent += len(changes)
+		if flusher != nil && len(changes) > 0 {
+			flusher.Flush()
+		}
+		if len(changes) < batch {
+			break
+		}
+	}
+}
+
 // ── Search ───────────────────────────────────────────────────────────────
 
 type v1SearchResponse struct {
@@ -846,6 +1268,10 @@ func (a *V1API) handleUpdateProperties(w http.ResponseWriter, r *http.Request, u
 		return
 	}
 
+	if err := a.manager.ValidateProperties(r.Context(), repo, properties); err != nil {
+		a.writeManagerErr(w, err)
+		return
+	}
 	if err := a.store.SetArtifactProperties(r.Context(), artifact.ID, properties); err != nil {
 		if errors.Is(err, stores.ErrDuplicateIdentity) {
 			http.Error(w, "Artifact with this version, path, and property set exists", http.StatusConflict)
@@ -867,6 +1293,11 @@ func (a *V1API) handleRename(w http.ResponseWriter, r *http.Request, user *auth.
 		return
 	}
 
+	if err := CheckPackageRename(repo); err != nil {
+		a.writeManagerErr(w, err)
+		return
+	}
+
 	var req struct {
 		Name    string `json:"name"`
 		Path    string `json:"path"`
@@ -890,6 +1321,7 @@ func (a *V1API) handleRename(w http.ResponseWriter, r *http.Request, user *auth.
 			newPath = req.Name
 		}
 	}
+	newPath = NormalizePath(newPath)
 	if err := ValidatePath(newPath); err != nil {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
@@ -945,7 +1377,7 @@ func (a *V1API) repoNS(user *auth.AuthenticatedUser, vars map[string]string) str
 		return ns
 	}
 	if user != nil {
-		return user.Username
+		return user.HomeNamespace()
 	}
 	return ""
 }
@@ -953,7 +1385,7 @@ func (a *V1API) repoNS(user *auth.AuthenticatedUser, vars map[string]string) str
 // Route permission check plus repo fetch
 func (a *V1API) getRepo(w http.ResponseWriter, r *http.Request, user *auth.AuthenticatedUser, namespace, name, action string) (*storage.ArtifactRepository, bool) {
 	if namespace == "" && user != nil {
-		namespace = user.Username
+		namespace = user.HomeNamespace()
 	}
 	if portal.ForeignRef(r.Context(), namespace) {
 		http.Error(w, "Repository not found", http.StatusNotFound)
@@ -990,7 +1422,7 @@ func (a *V1API) getRepoArtifact(w http.ResponseWriter, r *http.Request, repo *st
 
 // Public repos plus own plus org plus scoped grants
 func (a *V1API) listVisibleRepos(r *http.Request, user *auth.AuthenticatedUser, namespace string) ([]*storage.ArtifactRepository, error) {
-	repos, _, err := a.store.ListArtifactRepositories(r.Context(), a.access.ListOptions(user, namespace))
+	repos, _, err := a.store.ListArtifactRepositories(r.Context(), a.access.ListOptions(r.Context(), user, namespace))
 	if err != nil {
 		return nil, err
 	}
@@ -1010,16 +1442,24 @@ type v1Artifact struct {
 	MimeType   string            `json:"mime_type"`
 	Metadata   string            `json:"metadata"`
 	Properties map[string]string `json:"properties"`
+	Scan       *v1Scan           `json:"scan,omitempty"`
 	CreatedAt  time.Time         `json:"created_at"`
 	UpdatedAt  time.Time         `json:"updated_at"`
 }
 
+type v1Scan struct {
+	Status    string     `json:"status"`
+	Engine    string     `json:"engine,omitempty"`
+	Result    string     `json:"result,omitempty"`
+	ScannedAt *time.Time `json:"scanned_at,omitempty"`
+}
+
 func artifactToV1(a *storage.Artifact) v1Artifact {
 	props := a.Properties
 	if props == nil {
 		props = map[string]string{}
 	}
-	return v1Artifact{
+	out := v1Artifact{
 		ID:         a.ID,
 		RepoID:     a.RepoID,
 		Name:       a.Name,
@@ -1033,6 +1473,10 @@ func artifactToV1(a *storage.Artifact) v1Artifact {
 		CreatedAt:  a.CreatedAt,
 		UpdatedAt:  a.UpdatedAt,
 	}
+	if a.ScanStatus != "" {
+		out.Scan = &v1Scan{Status: a.ScanStatus, Engine: a.ScanEngine, Result: a.ScanResult, ScannedAt: a.ScannedAt}
+	}
+	return out
 }
 
 func (a *V1API) repoToV1(r *http.Request, repo *storage.ArtifactRepository, ownerCache map[string]string) v1Repo {
@@ -1060,13 +1504,36 @@ func (a *V1API) writeManagerErr(w http.ResponseWriter, err error) {
 	switch {
 	case errors.Is(err, ErrUploadNotFound):
 		http.Error(w, "Upload not found", http.StatusNotFound)
-	case errors.Is(err, ErrInvalid):
+	case errors.Is(err, ErrInvalid), errors.Is(err, ErrChecksumMismatch):
 		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrNoSpace):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case errors.Is(err, ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, admin.ErrStorageUnavailable):
+		w.Header().Set("Retry-After", strconv.Itoa(int(a.manager.health.RetryAfter().Seconds())))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, policy.ErrDenied), errors.Is(err, ErrQuarantined):
+		http.Error(w, err.Error(), http.StatusForbidden)
 	default:
 		http.Error(w, "SERVER ERROR", http.StatusInternalServerError)
 	}
 }
 
+// Strong ETag plus the checksum of the whole file, so a client assembling
+// ranges can verify the result and pin If-Range to this content
+func setDigestHeaders(h http.Header, digest string) {
+	h.Set("ETag", `"`+digest+`"`)
+	sumHex, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok {
+		return
+	}
+	h.Set("X-Checksum-Sha256", sumHex)
+	if sum, err := hex.DecodeString(sumHex); err == nil {
+		h.Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -3,26 +3,36 @@ package artifacts
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/scan"
 	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
 	v1proto "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/opencontainers/go-digest"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -206,7 +216,7 @@ func TestV1LoginAndRefresh(t *testing.T) {
 	// Refresh with a df_ PAT
 	ctx := context.Background()
 	user, _ := e.store.GetUserByIdentifier(ctx, "alice")
-	pat, _, err := e.authMgr.GenerateAPIToken(ctx, user.ID, "ci", nil)
+	pat, _, err := e.authMgr.GenerateAPIToken(ctx, user.ID, "ci", nil, nil)
 	if err != nil {
 		t.Fatalf("GenerateAPIToken: %v", err)
 	}
@@ -215,6 +225,16 @@ func TestV1LoginAndRefresh(t *testing.T) {
 		t.Fatalf("PAT refresh: got %d body %q", rec.Code, rec.Body.String())
 	}
 
+	// Scoped tokens never trade up for an unscoped session
+	scoped, _, err := e.authMgr.GenerateAPIToken(ctx, user.ID, "ci-read", nil, []string{"artifact:read"})
+	if err != nil {
+		t.Fatalf("GenerateAPIToken: %v", err)
+	}
+	rec = e.doJSON(http.MethodPost, "/api/v1/auth/refresh", "", map[string]string{"refresh_token": scoped})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("scoped PAT refresh: got %d body %q", rec.Code, rec.Body.String())
+	}
+
 	rec = e.doJSON(http.MethodPost, "/api/v1/auth/refresh", "", map[string]string{"refresh_token": "garbage"})
 	if rec.Code != http.StatusUnauthorized || strings.TrimSpace(rec.Body.String()) != "INVALID REFRESH TOKEN" {
 		t.Fatalf("bad refresh: got %d body %q", rec.Code, rec.Body.String())
@@ -235,6 +255,21 @@ func TestV1RepoLifecycle(t *testing.T) {
 		t.Fatalf("duplicate repo: got %d", rec.Code)
 	}
 
+	// Names differing only in case are the same repo
+	rec = e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": " MyRepo "})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("case variant of existing repo: got %d", rec.Code)
+	}
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/MYREPO/versions", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("case insensitive lookup: got %d", rec.Code)
+	}
+	for _, bad := range []string{"search", "..", "a/b", ".hidden", strings.Repeat("x", 129)} {
+		rec = e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": bad})
+		if rec.Code != http.StatusBadRequest || !strings.HasPrefix(rec.Body.String(), "INVALID REPOSITORY NAME") {
+			t.Fatalf("bad name %q: got %d %q", bad, rec.Code, rec.Body.String())
+		}
+	}
+
 	rec = e.do(http.MethodGet, "/api/v1/artifacts/repos", token, nil)
 	if rec.Code != http.StatusOK {
 		t.Fatalf("list repos: got %d", rec.Code)
@@ -273,6 +308,20 @@ func TestV1UploadDownloadFlow(t *testing.T) {
 		t.Fatalf("raw download: got %d body %q", rec.Code, rec.Body.String())
 	}
 
+	// Holders of the digest revalidate instead of downloading again
+	etag := rec.Header().Get("ETag")
+	if etag != `"`+digest.FromString(content).String()+`"` {
+		t.Fatalf("download etag: %q", etag)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/myrepo/1.0.0/some/file.txt", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	e.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("revalidation: got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+
 	rec = e.do(http.MethodGet, "/api/v1/artifacts/myrepo/9.9.9/some/file.txt", token, nil)
 	if rec.Code != http.StatusNotFound {
 		t.Fatalf("missing version download: got %d", rec.Code)
@@ -476,6 +525,85 @@ func TestV1PropertiesMetadataRename(t *testing.T) {
 	}
 }
 
+// Every mutation lands in the changelog in order, the cursor resumes it
+func TestV1Changelog(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": "myrepo", "private": true})
+	e.uploadArtifact(token, "myrepo", "1.0.0", "dir/app.txt", "content", map[string]string{"build": "1"})
+	id := e.artifactID("myrepo", "1.0.0", "dir/app.txt")
+	e.doJSON(http.MethodPut, "/api/v1/artifacts/myrepo/"+id+"/properties", token, map[string]string{"build": "2"})
+	e.doJSON(http.MethodPut, "/api/v1/artifacts/myrepo/"+id+"/properties", token, map[string]string{"build": "2"})
+	e.doJSON(http.MethodPut, "/api/v1/artifacts/myrepo/"+id+"/metadata", token, map[string]any{"job": "nightly"})
+	e.doJSON(http.MethodPut, "/api/v1/artifacts/myrepo/"+id+"/rename", token, map[string]string{"name": "renamed.txt"})
+	e.uploadArtifact(token, "myrepo", "1.0.0", "dir/renamed.txt", "replacement", map[string]string{"build": "2"})
+	if rec := e.do(http.MethodDelete, "/api/v1/artifacts/myrepo/1.0.0/dir/renamed.txt", token, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d body %q", rec.Code, rec.Body.String())
+	}
+
+	read := func(query string) []v1Change {
+		t.Helper()
+		rec := e.do(http.MethodGet, "/api/v1/artifacts/myrepo/changelog"+query, token, nil)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("changelog%s: got %d %s body %q", query, rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+		}
+		var out []v1Change
+		sc := bufio.NewScanner(rec.Body)
+		for sc.Scan() {
+			var c v1Change
+			if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+				t.Fatalf("line %q: %v", sc.Text(), err)
+			}
+			out = append(out, c)
+		}
+		return out
+	}
+
+	all := read("")
+	var kinds []string
+	for _, c := range all {
+		kinds = append(kinds, c.Kind)
+		if c.Repo != "alice/myrepo" || c.Actor != "alice" {
+			t.Fatalf("change %d credited to %s in %s", c.Cursor, c.Actor, c.Repo)
+		}
+	}
+	want := "upload properties metadata rename upload delete"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Fatalf("kinds = %s, want %s", got, want)
+	}
+	if p := all[1]; p.Previous.Properties["build"] != "1" || p.Artifact.Properties["build"] != "2" {
+		t.Fatalf("properties change = %+v -> %+v", p.Previous, p.Artifact)
+	}
+	if r := all[3]; r.Previous.Path != "dir/app.txt" || r.Artifact.Path != "dir/renamed.txt" || string(r.Artifact.Metadata) != `{"job":"nightly"}` {
+		t.Fatalf("rename = %+v -> %+v", r.Previous, r.Artifact)
+	}
+	if u := all[4]; u.Previous == nil || u.Previous.Digest == u.Artifact.Digest {
+		t.Fatalf("replacing upload did not record what it replaced: %+v", u)
+	}
+	if d := all[5]; d.Artifact.Path != "dir/renamed.txt" || d.ArtifactID != all[4].ArtifactID {
+		t.Fatalf("delete = %+v", d)
+	}
+
+	page := read("?limit=2")
+	if len(page) != 2 || page[1].Cursor != all[1].Cursor {
+		t.Fatalf("limited page = %d lines", len(page))
+	}
+	rest := read(fmt.Sprintf("?after=%d", page[1].Cursor))
+	if len(rest) != 4 || rest[0].Cursor != all[2].Cursor {
+		t.Fatalf("resumed page = %d lines", len(rest))
+	}
+	if len(read(fmt.Sprintf("?after=%d", all[5].Cursor))) != 0 {
+		t.Fatal("caught up export returned lines")
+	}
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/myrepo/changelog?after=x", token, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad cursor: got %d", rec.Code)
+	}
+	other := e.newUser("bob", "user")
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/_ns/alice/myrepo/changelog", other, nil); rec.Code == http.StatusOK {
+		t.Fatal("changelog of a private repo readable by another user")
+	}
+}
+
 // Regression for the v1 orphaned rows leak
 func TestV1DeleteAndCascade(t *testing.T) {
 	e := newTestEnv(t, nil)
@@ -561,6 +689,61 @@ func TestV1ReplaceAndRetention(t *testing.T) {
 	}
 }
 
+func TestV1QueryRepoDefaults(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": "myrepo"})
+	e.uploadArtifact(token, "myrepo", "1.0.0", "a/one.txt", "one", nil)
+	e.uploadArtifact(token, "myrepo", "2.0.0", "b/two.txt", "two", nil)
+	e.uploadArtifact(token, "myrepo", "3.0.0", "c/three.txt", "three", nil)
+
+	zipNames := func(rec *httptest.ResponseRecorder) []string {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("query: got %d body %q", rec.Code, rec.Body.String())
+		}
+		zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatalf("zip parse: %v", err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	// Stock defaults keep v1 behavior, one match
+	if names := zipNames(e.do(http.MethodGet, "/api/v1/artifacts/myrepo/query?sort=version", token, nil)); len(names) != 1 || names[0] != "3.0.0/c/three.txt" {
+		t.Fatalf("stock defaults: %v", names)
+	}
+
+	repo, err := e.store.GetArtifactRepository(context.Background(), "alice", "myrepo")
+	if err != nil || repo == nil {
+		t.Fatalf("GetArtifactRepository: %v", err)
+	}
+	if err := e.manager.SetRepoOverrides(context.Background(), repo, nil, &v1proto.ArtifactQuerySettings{Sort: proto.String("bogus")}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("bad sort accepted: %v", err)
+	}
+	err = e.manager.SetRepoOverrides(context.Background(), repo, nil, &v1proto.ArtifactQuerySettings{
+		Num: proto.Int32(2), Sort: proto.String("version"), Order: proto.String("asc"), Flat: proto.Bool(true),
+	})
+	if err != nil {
+		t.Fatalf("SetRepoOverrides: %v", err)
+	}
+
+	names := zipNames(e.do(http.MethodGet, "/api/v1/artifacts/myrepo/query", token, nil))
+	if len(names) != 2 || names[0] != "one.txt" || names[1] != "two.txt" {
+		t.Fatalf("repo defaults: %v", names)
+	}
+
+	// Explicit params still win
+	names = zipNames(e.do(http.MethodGet, "/api/v1/artifacts/myrepo/query?num=1&order=desc&flat=0", token, nil))
+	if len(names) != 1 || names[0] != "3.0.0/c/three.txt" {
+		t.Fatalf("explicit params: %v", names)
+	}
+}
+
 func TestV1PropertyVariantIdentity(t *testing.T) {
 	e := newTestEnv(t, nil)
 	token := e.newUser("alice", "user")
@@ -666,6 +849,313 @@ func TestV1AccessControl(t *testing.T) {
 	}
 }
 
+func TestV1TokenScopes(t *testing.T) {
+	e := newTestEnv(t, nil)
+	owner := e.newUser("alice", "user")
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", owner, map[string]any{"name": "builds"})
+	e.uploadArtifact(owner, "builds", "1.0.0", "a.txt", "hello", nil)
+
+	ctx := context.Background()
+	user, _ := e.store.GetUserByIdentifier(ctx, "alice")
+	pat := func(scopes ...string) string {
+		raw, _, err := e.authMgr.GenerateAPIToken(ctx, user.ID, "scoped", nil, scopes)
+		if err != nil {
+			t.Fatalf("GenerateAPIToken(%v): %v", scopes, err)
+		}
+		return raw
+	}
+	read, write, images := pat("artifact:read"), pat("artifact:write"), pat("repo:write")
+
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/builds/1.0.0/a.txt", read, nil); rec.Code != http.StatusOK {
+		t.Fatalf("artifact:read download: got %d", rec.Code)
+	}
+	if rec := e.do(http.MethodPost, "/api/v1/artifacts/builds/upload", read, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("artifact:read upload: got %d", rec.Code)
+	}
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/builds/versions", images, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("repo:write listing artifacts: got %d", rec.Code)
+	}
+	// Write includes read
+	e.uploadArtifact(write, "builds", "1.0.1", "a.txt", "again", nil)
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/builds/1.0.1/a.txt", write, nil); rec.Code != http.StatusOK {
+		t.Fatalf("artifact:write download: got %d", rec.Code)
+	}
+
+	if _, _, err := e.authMgr.GenerateAPIToken(ctx, user.ID, "bad", nil, []string{"admin"}); err == nil {
+		t.Fatalf("unknown scope accepted")
+	}
+}
+
+func TestV1ContentByDigest(t *testing.T) {
+	e := newTestEnv(t, nil)
+	owner := e.newUser("alice", "user")
+	other := e.newUser("bob", "user")
+
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", owner, map[string]any{"name": "secret", "private": true})
+	e.uploadArtifact(owner, "secret", "1.0.0", "s.txt", "sssh", nil)
+	target := "/api/v1/artifacts/content/" + strings.Replace(digest.FromString("sssh").String(), ":", "/", 1)
+
+	rec := e.do(http.MethodGet, target, owner, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "sssh" {
+		t.Fatalf("owner fetch by digest: got %d %q", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"`+digest.FromString("sssh").String()+`"` {
+		t.Fatalf("etag: got %s", etag)
+	}
+
+	// Private content stays hidden, indistinguishable from unknown digests
+	if rec := e.do(http.MethodGet, target, other, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("private fetch by non-owner: got %d", rec.Code)
+	}
+
+	// The same bytes in a public repo unlock it for everyone
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", owner, map[string]any{"name": "open"})
+	e.uploadArtifact(owner, "open", "2.0.0", "copy.txt", "sssh", nil)
+	if rec := e.do(http.MethodGet, target, other, nil); rec.Code != http.StatusOK {
+		t.Fatalf("public fetch by digest: got %d", rec.Code)
+	}
+
+	unknown := "/api/v1/artifacts/content/" + strings.Replace(digest.FromString("nope").String(), ":", "/", 1)
+	if rec := e.do(http.MethodGet, unknown, owner, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown digest: got %d", rec.Code)
+	}
+}
+
+// Single file downloads answer HEAD and byte ranges with the whole file's
+// checksum, If-Range on a stale ETag gets the full file, archives refuse ranges
+func TestV1RangeDownload(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": "myrepo"})
+	content := "0123456789abcdefghij"
+	e.uploadArtifact(token, "myrepo", "1.0.0", "pkg.bin", content, nil)
+	dgst := digest.FromString(content)
+	sum, _ := hex.DecodeString(dgst.Encoded())
+
+	get := func(method, target string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.mux.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, target := range []string{
+		"/api/v1/artifacts/myrepo/1.0.0/pkg.bin",
+		"/api/v1/artifacts/myrepo/_latest/pkg.bin",
+		"/api/v1/artifacts/content/sha256/" + dgst.Encoded(),
+	} {
+		rec := get(http.MethodHead, target, nil)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "20" || rec.Header().Get("Accept-Ranges") != "bytes" {
+			t.Fatalf("HEAD %s: got %d length %q ranges %q", target, rec.Code, rec.Header().Get("Content-Length"), rec.Header().Get("Accept-Ranges"))
+		}
+		if rec.Header().Get("X-Checksum-Sha256") != dgst.Encoded() ||
+			rec.Header().Get("Repr-Digest") != "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":" {
+			t.Fatalf("HEAD %s checksums: %q %q", target, rec.Header().Get("X-Checksum-Sha256"), rec.Header().Get("Repr-Digest"))
+		}
+
+		rec = get(http.MethodGet, target, map[string]string{"Range": "bytes=5-9", "If-Range": `"` + dgst.String() + `"`})
+		if rec.Code != http.StatusPartialContent || rec.Body.String() != "56789" || rec.Header().Get("Content-Range") != "bytes 5-9/20" {
+			t.Fatalf("range %s: got %d %q %q", target, rec.Code, rec.Body.String(), rec.Header().Get("Content-Range"))
+		}
+		if rec.Header().Get("X-Checksum-Sha256") != dgst.Encoded() {
+			t.Fatalf("range %s lacks the file checksum", target)
+		}
+
+		rec = get(http.MethodGet, target, map[string]string{"Range": "bytes=5-9", "If-Range": `"sha256:stale"`})
+		if rec.Code != http.StatusOK || rec.Body.String() != content {
+			t.Fatalf("stale If-Range %s: got %d %q", target, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := get(http.MethodGet, "/api/v1/artifacts/myrepo/query?name=pkg.bin", map[string]string{"Range": "bytes=0-3"})
+	if rec.Code != http.StatusOK || rec.Header().Get("Accept-Ranges") != "none" {
+		t.Fatalf("archive range: got %d ranges %q", rec.Code, rec.Header().Get("Accept-Ranges"))
+	}
+}
+
+// The index lists one entry per path at the version, the newest property
+// variant's checksum, and revalidates by ETag
+func TestV1ChecksumIndex(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": "cache"})
+	e.uploadArtifact(token, "cache", "1.0", "obj/main.o", "old", map[string]string{"os": "linux"})
+	e.uploadArtifact(token, "cache", "1.0", "obj/main.o", "newer", map[string]string{"os": "darwin"})
+	e.uploadArtifact(token, "cache", "1.0", "lib.a", "archive", nil)
+	e.uploadArtifact(token, "cache", "2.0", "other.o", "elsewhere", nil)
+
+	rec := e.do(http.MethodGet, "/api/v1/artifacts/cache/index?version=1.0", token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("index: %d %s", rec.Code, rec.Body.String())
+	}
+	var index v1Index
+	if err := json.Unmarshal(rec.Body.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	want := []v1IndexEntry{
+		{Path: "lib.a", Sha256: digest.FromString("archive").Encoded(), Size: 7},
+		{Path: "obj/main.o", Sha256: digest.FromString("newer").Encoded(), Size: 5},
+	}
+	if index.Repo != "alice/cache" || index.Version != "1.0" || !slices.Equal(index.Files, want) {
+		t.Fatalf("index = %+v, want files %+v", index, want)
+	}
+
+	etag := rec.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/cache/index?version=1.0", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	e.mux.ServeHTTP(rec, req)
+	if etag == "" || rec.Code != http.StatusNotModified {
+		t.Fatalf("revalidate with %q: got %d", etag, rec.Code)
+	}
+
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/cache/index", token, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing version: got %d", rec.Code)
+	}
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/cache/index?version=9.9", token, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown version: got %d", rec.Code)
+	}
+}
+
+// Ranged chunks resume from the offset HEAD reports, completion checks
+// the client checksum
+func TestV1ResumableUpload(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": "big"})
+
+	start := func() string {
+		rec := e.do(http.MethodPost, "/api/v1/artifacts/big/upload", token, nil)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("initiate upload: got %d", rec.Code)
+		}
+		return rec.Header().Get("Location")
+	}
+	chunk := func(location, contentRange, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, location, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Range", contentRange)
+		rec := httptest.NewRecorder()
+		e.mux.ServeHTTP(rec, req)
+		return rec
+	}
+	complete := func(location, checksum string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, location+"?version=1.0.0&path=big.bin", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Checksum-Sha256", checksum)
+		rec := httptest.NewRecorder()
+		e.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	location := start()
+	if rec := chunk(location, "0-4", "hello"); rec.Code != http.StatusAccepted || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("first chunk: got %d offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	// A retried chunk the server already holds is refused with its range
+	rec := chunk(location, "0-4", "hello")
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Header().Get("Range") != "0-4" {
+		t.Fatalf("repeated chunk: got %d range %q", rec.Code, rec.Header().Get("Range"))
+	}
+	rec = e.do(http.MethodHead, location, token, nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("status: got %d offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	if rec := chunk(location, "bytes 5-10/11", " world"); rec.Code != http.StatusAccepted {
+		t.Fatalf("second chunk: got %d", rec.Code)
+	}
+	if rec := chunk(location, "9-x", "!"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad range: got %d", rec.Code)
+	}
+
+	// A wrong checksum stores nothing and drops the session
+	if rec := complete(location, digest.FromString("hello moon").Encoded()); rec.Code != http.StatusBadRequest {
+		t.Fatalf("mismatched checksum: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := e.do(http.MethodHead, location, token, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("session after mismatch: got %d", rec.Code)
+	}
+	if a, _ := e.store.GetArtifactByPathVersion(context.Background(), e.repoByName("big").ID, "1.0.0", "big.bin"); a != nil {
+		t.Fatalf("mismatched upload stored as %s", a.ID)
+	}
+
+	location = start()
+	chunk(location, "0-10", "hello world")
+	if rec := complete(location, digest.FromString("hello world").String()); rec.Code != http.StatusCreated {
+		t.Fatalf("matching checksum: got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = e.do(http.MethodGet, "/api/v1/artifacts/big/1.0.0/big.bin", token, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Fatalf("download: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+// The latest pointer follows matching uploads only and falls back on delete
+func TestV1LatestPointer(t *testing.T) {
+	e := newTestEnv(t, nil)
+	token := e.newUser("alice", "user")
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": "app"})
+
+	patch := &v1proto.Settings{Artifacts: &v1proto.ArtifactSettings{
+		Latest: &v1proto.ArtifactLatestSettings{Properties: map[string]string{"branch": "main"}},
+	}}
+	if _, err := e.res.Update(context.Background(), v1proto.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, "alice/app", patch, []string{"artifacts.latest.properties"}); err != nil {
+		t.Fatalf("repo settings update: %v", err)
+	}
+
+	latest := func(wantBody, wantVersion string) {
+		t.Helper()
+		rec := e.do(http.MethodGet, "/api/v1/artifacts/app/_latest/app.tar", token, nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != wantBody {
+			t.Fatalf("latest: got %d %q, want %q", rec.Code, rec.Body.String(), wantBody)
+		}
+		if v := rec.Header().Get("X-Artifact-Version"); v != wantVersion {
+			t.Fatalf("latest version header = %q, want %q", v, wantVersion)
+		}
+	}
+
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/app/_latest/app.tar", token, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("latest before any upload: got %d", rec.Code)
+	}
+	e.uploadArtifact(token, "app", "1.0", "app.tar", "one", map[string]string{"branch": "main"})
+	latest("one", "1.0")
+	e.uploadArtifact(token, "app", "1.1", "app.tar", "two", map[string]string{"branch": "dev"})
+	latest("one", "1.0")
+	e.uploadArtifact(token, "app", "1.2", "app.tar", "three", map[string]string{"branch": "main"})
+	latest("three", "1.2")
+
+	// Consumers polling with the digest get 304 until the pointer moves
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/app/_latest/app.tar", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-None-Match", `"`+digest.FromString("three").String()+`"`)
+	rec := httptest.NewRecorder()
+	e.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("conditional latest: got %d", rec.Code)
+	}
+
+	// Deleting the target falls back to the newest match, never the dev build
+	if rec := e.do(http.MethodDelete, "/api/v1/artifacts/app/1.2/app.tar", token, nil); rec.Code != http.StatusOK && rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d %q", rec.Code, rec.Body.String())
+	}
+	latest("one", "1.0")
+	if rec := e.do(http.MethodDelete, "/api/v1/artifacts/app/1.0/app.tar", token, nil); rec.Code != http.StatusOK && rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/app/_latest/app.tar", token, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("latest with no match left: got %d", rec.Code)
+	}
+
+	if err := ValidateVersion(LatestVersion); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("reserved version accepted: %v", err)
+	}
+}
+
 // ── Test helpers ─────────────────────────────────────────────────────────
 
 // Finds a repo by bare name across namespaces for tests
@@ -767,3 +1257,128 @@ func TestV1OrgNamespaceAccess(t *testing.T) {
 		t.Fatalf("owner repo delete: got %d body %q", rec.Code, rec.Body.String())
 	}
 }
+
+// Minimal clamd for the scan tests, flags content mentioning EICAR
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			r.ReadString(0)
+			var body bytes.Buffer
+			for {
+				var size uint32
+				if binary.Read(r, binary.BigEndian, &size) != nil || size == 0 {
+					break
+				}
+				io.CopyN(&body, r, int64(size))
+			}
+			if bytes.Contains(body.Bytes(), []byte("EICAR")) {
+				conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// Infected uploads stay listed with their verdict but every download path
+// refuses them, block_unscanned also holds back anything not yet cleared
+func TestV1ScanQuarantine(t *testing.T) {
+	e := newTestEnv(t, nil)
+	ctx := context.Background()
+	token := e.newUser("alice", "user")
+	e.doJSON(http.MethodPost, "/api/v1/artifacts/repos", token, map[string]any{"name": "app"})
+
+	patch := &v1proto.Settings{Scan: &v1proto.ScanSettings{Enabled: proto.Bool(true), Address: proto.String(fakeClamd(t))}}
+	if _, err := e.res.Update(ctx, v1proto.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", patch, []string{"scan.enabled", "scan.address"}); err != nil {
+		t.Fatalf("scan settings update: %v", err)
+	}
+	e.manager.SetScanner(scan.NewScanner(e.res, logger.New()))
+	repo, err := e.store.GetArtifactRepository(ctx, "alice", "app")
+	if err != nil || repo == nil {
+		t.Fatalf("repo: %v", err)
+	}
+
+	// Scans run after the upload returns
+	scanned := func(version string) *storage.Artifact {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			a, err := e.store.GetArtifactByPathVersion(ctx, repo.ID, version, "app.bin")
+			if err != nil || a == nil {
+				t.Fatalf("artifact %s: %v", version, err)
+			}
+			if a.ScanStatus != scan.StatusPending {
+				return a
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("artifact %s still pending", version)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	e.uploadArtifact(token, "app", "1.0.0", "app.bin", "all good here", nil)
+	e.uploadArtifact(token, "app", "2.0.0", "app.bin", "X5O!P%@AP EICAR test", nil)
+	if a := scanned("1.0.0"); a.ScanStatus != scan.StatusClean || a.ScanEngine != scan.EngineClamAV || a.ScannedAt == nil {
+		t.Fatalf("clean upload = %+v", a)
+	}
+	if a := scanned("2.0.0"); a.ScanStatus != scan.StatusInfected || a.ScanResult != "Eicar-Test-Signature" {
+		t.Fatalf("infected upload = %+v", a)
+	}
+
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/app/1.0.0/app.bin", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("clean download: got %d", rec.Code)
+	}
+	infected := digest.FromString("X5O!P%@AP EICAR test").String()
+	for _, target := range []string{
+		"/api/v1/artifacts/app/2.0.0/app.bin",
+		"/api/v1/artifacts/app/_latest/app.bin",
+		"/api/v1/artifacts/app/query?version=2.0.0",
+		"/api/v1/artifacts/content/" + strings.Replace(infected, ":", "/", 1),
+	} {
+		if rec := e.do(http.MethodGet, target, token, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("GET %s: got %d, want 403", target, rec.Code)
+		}
+	}
+
+	var search v1SearchResponse
+	rec := e.do(http.MethodGet, "/api/v1/artifacts/search?version=2.0.0", token, nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &search); err != nil || len(search.Results) != 1 {
+		t.Fatalf("search: %v %s", err, rec.Body.String())
+	}
+	if s := search.Results[0].Scan; s == nil || s.Status != scan.StatusInfected || s.Result != "Eicar-Test-Signature" {
+		t.Fatalf("search scan = %+v", s)
+	}
+
+	// Quarantine outlives the scanner, pending content only waits while asked to
+	if err := e.store.SetArtifactScan(ctx, scanned("1.0.0").ID, scan.StatusPending, "", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/app/1.0.0/app.bin", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("pending download without block_unscanned: got %d", rec.Code)
+	}
+	patch = &v1proto.Settings{Scan: &v1proto.ScanSettings{BlockUnscanned: proto.Bool(true)}}
+	if _, err := e.res.Update(ctx, v1proto.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", patch, []string{"scan.block_unscanned"}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/app/1.0.0/app.bin", token, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("pending download with block_unscanned: got %d", rec.Code)
+	}
+	e.manager.SetScanner(nil)
+	if rec := e.do(http.MethodGet, "/api/v1/artifacts/app/2.0.0/app.bin", token, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("infected download without a scanner: got %d", rec.Code)
+	}
+}
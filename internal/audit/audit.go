@@ -15,6 +15,7 @@ const (
 	OutcomeSuccess = "success"
 	OutcomeDenied  = "denied"
 	OutcomeError   = "error"
+	OutcomePending = "pending" // Awaiting a second approver
 )
 
 type Event struct {
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"connectrpc.com/connect"
+	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/pkg/logger"
 	"github.com/nickheyer/distroface/pkg/pages"
@@ -36,17 +37,21 @@ func (s *Service) ListAuditEvents(ctx context.Context, req *connect.Request[v1.L
 	}
 	resp := &v1.ListAuditEventsResponse{Page: pages.Info(offset, limit, total)}
 	for _, ev := range events {
-		resp.Events = append(resp.Events, &v1.AuditEvent{
-			Id:        ev.ID,
-			Actor:     ev.Actor,
-			ActorId:   ev.ActorID,
-			SourceIp:  ev.SourceIP,
-			Action:    ev.Action,
-			Resource:  ev.Resource,
-			Outcome:   ev.Outcome,
-			Detail:    ev.Detail,
-			CreatedAt: timestamppb.New(ev.CreatedAt),
-		})
+		resp.Events = append(resp.Events, EventToProto(ev))
 	}
 	return connect.NewResponse(resp), nil
 }
+
+func EventToProto(ev *storage.AuditEvent) *v1.AuditEvent {
+	return &v1.AuditEvent{
+		Id:        ev.ID,
+		Actor:     ev.Actor,
+		ActorId:   ev.ActorID,
+		SourceIp:  ev.SourceIP,
+		Action:    ev.Action,
+		Resource:  ev.Resource,
+		Outcome:   ev.Outcome,
+		Detail:    ev.Detail,
+		CreatedAt: timestamppb.New(ev.CreatedAt),
+	}
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/pkg/pages"
+)
+
+const catalogPath = "/v2/_catalog"
+
+// Page size without n and the largest n accepted, as distribution caps it
+const maxCatalogEntries = 1000
+
+type catalogResponse struct {
+	Repositories []string `json:"repositories"`
+}
+
+// Serves /v2/_catalog scoped to the token subject, public plus whatever it
+// can pull including exported artifact repos, or only what it can push with
+// access=push. Pages hold up to n names, maxCatalogEntries without it, and
+// link the next page. Requests without a catalog claim fall through so the
+// registry issues the bearer challenge.
+func (h *TokenHandler) ScopedCatalog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != catalogPath || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		raw, isBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !isBearer {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, err := h.tokenService.VerifyToken(strings.TrimSpace(raw))
+		if err != nil || !hasCatalogClaim(claims.Access) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		q := r.URL.Query()
+		push := false
+		switch q.Get("access") {
+		case "", "pull":
+		case "push":
+			push = true
+		default:
+			writeCatalogError(w, http.StatusBadRequest, "UNSUPPORTED", "access must be pull or push")
+			return
+		}
+		limit := maxCatalogEntries
+		if v := q.Get("n"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > maxCatalogEntries {
+				writeCatalogError(w, http.StatusBadRequest, "PAGINATION_NUMBER_INVALID", "invalid number of results requested")
+				return
+			}
+			limit = n
+		}
+
+		names, err := h.catalogRepos(r, claims.Subject, push)
+		if err != nil {
+			h.log.Error("catalog: listing repos for %q: %v", claims.Subject, err)
+			writeCatalogError(w, http.StatusInternalServerError, "UNKNOWN", "listing repositories failed")
+			return
+		}
+
+		// Lexical order, last is exclusive per the distribution spec
+		if last := q.Get("last"); last != "" {
+			i, _ := slices.BinarySearch(names, last)
+			for i < len(names) && names[i] <= last {
+				i++
+			}
+			names = names[i:]
+		}
+		if len(names) > limit {
+			names = names[:limit]
+			if limit > 0 {
+				link := url.Values{"n": {strconv.Itoa(limit)}, "last": {names[len(names)-1]}}
+				if push {
+					link.Set("access", "push")
+				}
+				w.Header().Set("Link", `<`+catalogPath+`?`+link.Encode()+`>; rel="next"`)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(catalogResponse{Repositories: names})
+	})
+}
+
+func hasCatalogClaim(access []*ResourceActions) bool {
+	for _, a := range access {
+		if a.Type == "registry" && a.Name == "catalog" && slices.Contains(a.Actions, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// Sorted repo names the subject may see, empty subject is anonymous
+func (h *TokenHandler) catalogRepos(r *http.Request, subject string, push bool) ([]string, error) {
+	ctx := r.Context()
+	var user *AuthenticatedUser
+	if subject != "" {
+		u, err := h.store.GetUserByUsername(ctx, subject)
+		if err != nil {
+			return nil, err
+		}
+		// Deactivated after the token was issued sees nothing private
+		if u != nil && u.IsActive {
+			roles, err := h.store.GetUserRoleNames(ctx, u.ID)
+			if err != nil {
+				return nil, err
+			}
+			user = &AuthenticatedUser{ID: u.ID, Username: u.Username, Roles: roles, Provider: u.AuthProvider}
+		}
+	}
+	if push && (user == nil || (h.policy != nil && !h.policy.AllowPush(r))) {
+		return []string{}, nil
+	}
+
+	repos, _, err := h.store.ListRepositories(ctx, stores.RepoFilter{}, pages.Query{}, "namespace ASC, name ASC", "", true, nil, -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	pushable := map[string]bool{}
+	for _, repo := range repos {
+		name := repo.Namespace + "/" + repo.Name
+		if h.policy != nil && !h.policy.AllowRepo(r, name) {
+			continue
+		}
+		if push {
+			allowed, seen := pushable[repo.Namespace]
+			if !seen {
+				allowed = h.canPushNamespace(r, user, repo.Namespace)
+				pushable[repo.Namespace] = allowed
+			}
+			if !allowed && !h.aclAllows(r, user, repo.Namespace, repo.Name, rbac.ActionPush) {
+				continue
+			}
+		} else if !h.canPull(r, user, repo.Namespace, repo) {
+			continue
+		}
+		names = append(names, name)
+	}
+	if !push && h.bridge != nil && user.Allows(rbac.ResourceArtifacts, rbac.ActionPull) {
+		bridged, err := h.bridge.BridgedNames(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range bridged {
+			if h.policy == nil || h.policy.AllowRepo(r, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func writeCatalogError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+func TestScopedCatalog(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	ts, err := NewTokenService(dir, "distroface", []string{RegistryService}, settings.NewResolver(store, &v1.Settings{}))
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	h := NewTokenHandler(ts, store, nil, nil, nil, nil, nil, logger.New())
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := store.CreateUser(ctx, &db.User{ID: uuid.New().String(), Username: name, AuthProvider: "local", IsActive: true}); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	for _, r := range []struct {
+		ns, name string
+		private  bool
+	}{{"alice", "app", true}, {"bob", "secret", true}, {"bob", "pub", false}, {"alice", "lib", false}} {
+		if err := store.CreateRepository(ctx, &db.Repository{ID: uuid.New().String(), Namespace: r.ns, Name: r.name, IsPrivate: r.private}); err != nil {
+			t.Fatalf("CreateRepository: %v", err)
+		}
+	}
+
+	passed := false
+	handler := h.ScopedCatalog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = true
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	list := func(subject string, access []*ResourceActions, query string) ([]string, http.Header) {
+		t.Helper()
+		passed = false
+		tok, err := ts.SignToken(subject, access)
+		if err != nil {
+			t.Fatalf("SignToken: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, catalogPath+query, nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if passed {
+			return nil, rec.Header()
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("catalog %s%s: status %d: %s", subject, query, rec.Code, rec.Body.String())
+		}
+		var body catalogResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding catalog: %v", err)
+		}
+		return body.Repositories, rec.Header()
+	}
+	catalog := []*ResourceActions{{Type: "registry", Name: "catalog", Actions: []string{"*"}}}
+
+	if got, _ := list("alice", nil, ""); got != nil || !passed {
+		t.Fatalf("token without catalog claim served %v", got)
+	}
+	if got, _ := list("alice", catalog, ""); !slices.Equal(got, []string{"alice/app", "alice/lib", "bob/pub"}) {
+		t.Fatalf("alice pull catalog = %v", got)
+	}
+	if got, _ := list("", catalog, ""); !slices.Equal(got, []string{"alice/lib", "bob/pub"}) {
+		t.Fatalf("anonymous catalog = %v", got)
+	}
+	if got, _ := list("alice", catalog, "?access=push"); !slices.Equal(got, []string{"alice/app", "alice/lib"}) {
+		t.Fatalf("alice push catalog = %v", got)
+	}
+	if got, _ := list("", catalog, "?access=push"); len(got) != 0 {
+		t.Fatalf("anonymous push catalog = %v", got)
+	}
+
+	got, hdr := list("alice", catalog, "?n=2")
+	if !slices.Equal(got, []string{"alice/app", "alice/lib"}) || hdr.Get("Link") == "" {
+		t.Fatalf("first page = %v link %q", got, hdr.Get("Link"))
+	}
+	got, hdr = list("alice", catalog, "?n=2&last=alice/lib")
+	if !slices.Equal(got, []string{"bob/pub"}) || hdr.Get("Link") != "" {
+		t.Fatalf("second page = %v link %q", got, hdr.Get("Link"))
+	}
+
+	// Larger pages than distribution allows are refused the same way
+	tok, _ := ts.SignToken("alice", catalog)
+	req := httptest.NewRequest(http.MethodGet, catalogPath+"?n=1001", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("n=1001 answered %d", rec.Code)
+	}
+
+	// Exported artifact repos list with pulls, never with pushes
+	h.SetBridge(bridgeStub{"bob/artifacts/tools"})
+	if got, _ := list("alice", catalog, ""); !slices.Equal(got, []string{"alice/app", "alice/lib", "bob/artifacts/tools", "bob/pub"}) {
+		t.Fatalf("alice catalog with bridged repos = %v", got)
+	}
+	if got, _ := list("alice", catalog, "?access=push"); !slices.Equal(got, []string{"alice/app", "alice/lib"}) {
+		t.Fatalf("alice push catalog with bridged repos = %v", got)
+	}
+}
+
+type bridgeStub []string
+
+func (b bridgeStub) BridgedPull(context.Context, *AuthenticatedUser, string) (bool, bool) {
+	return false, false
+}
+
+func (b bridgeStub) BridgedNames(context.Context, *AuthenticatedUser) ([]string, error) {
+	return b, nil
+}
+
+func newTestStore(t *testing.T) *stores.Store {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRepositoryPermissionGrants(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	h := NewTokenHandler(nil, store, nil, nil, nil, nil, nil, logger.New())
+
+	users := map[string]*AuthenticatedUser{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		u := &db.User{ID: uuid.New().String(), Username: name, AuthProvider: "local", IsActive: true}
+		if err := store.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		users[name] = &AuthenticatedUser{ID: u.ID, Username: name}
+	}
+	users["carol"].Roles = []string{"qa"}
+	for _, name := range []string{"secret", "pub"} {
+		if err := store.CreateRepository(ctx, &db.Repository{ID: uuid.New().String(), Namespace: "bob", Name: name, IsPrivate: name == "secret"}); err != nil {
+			t.Fatalf("CreateRepository: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v2/token", nil)
+	actions := func(user string, repo string) []string {
+		return h.filterActions(r, users[user], repo, []string{"pull", "push"})
+	}
+	if got := actions("alice", "bob/secret"); len(got) != 0 {
+		t.Fatalf("alice without a grant got %v on bob/secret", got)
+	}
+
+	grant := func(userID, role, repo, level string) {
+		t.Helper()
+		if err := store.SetRepositoryPermission(ctx, &db.RepositoryPermission{Namespace: "bob", Name: repo, UserID: userID, RoleName: role, Level: level}); err != nil {
+			t.Fatalf("SetRepositoryPermission: %v", err)
+		}
+	}
+	grant(users["alice"].ID, "", "secret", "read")
+	if got := actions("alice", "bob/secret"); !slices.Equal(got, []string{"pull"}) {
+		t.Fatalf("alice read grant = %v", got)
+	}
+	grant(users["alice"].ID, "", "secret", "write")
+	if got := actions("alice", "bob/secret"); !slices.Equal(got, []string{"pull", "push"}) {
+		t.Fatalf("alice write grant = %v", got)
+	}
+	grant("", "qa", "pub", "write")
+	if got := actions("carol", "bob/pub"); !slices.Equal(got, []string{"pull", "push"}) {
+		t.Fatalf("carol role grant = %v", got)
+	}
+	if got := actions("carol", "bob/secret"); len(got) != 0 {
+		t.Fatalf("carol got %v on bob/secret through a grant on bob/pub", got)
+	}
+
+	paths, err := store.ListPermittedRepositories(ctx, users["alice"].ID, nil)
+	if err != nil || !slices.Equal(paths, []string{"bob/secret"}) {
+		t.Fatalf("alice permitted repos = %v, %v", paths, err)
+	}
+
+	// Grants go with the last repo at their path
+	if err := store.DeleteRepository(ctx, "bob", "secret"); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	if rows, _ := store.ListRepositoryPermissions(ctx, "bob", "secret"); len(rows) != 0 {
+		t.Fatalf("grants left after delete: %d", len(rows))
+	}
+	if removed, err := store.DeleteRepositoryPermission(ctx, "bob", "pub", "", "qa"); err != nil || !removed {
+		t.Fatalf("DeleteRepositoryPermission = %v, %v", removed, err)
+	}
+	if got := actions("carol", "bob/pub"); !slices.Equal(got, []string{"pull"}) {
+		t.Fatalf("carol after revoke = %v", got)
+	}
+}
+
+// Registry deletes need delete_blob on the repo on top of push rights,
+// the default user role can push its own repos but not delete over /v2
+func TestDeleteScopeGrants(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	enforcer, err := rbac.NewEnforcer(store.DB())
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+	if err := enforcer.SeedDefaultPolicies(false); err != nil {
+		t.Fatalf("SeedDefaultPolicies: %v", err)
+	}
+	if err := enforcer.SetPermissionsForRole("janitor", []rbac.Permission{
+		{Resource: rbac.ResourceRepositories, Action: rbac.ActionDeleteBlob, ObjectID: "bob/app"},
+	}); err != nil {
+		t.Fatalf("SetPermissionsForRole: %v", err)
+	}
+	h := NewTokenHandler(nil, store, nil, enforcer, nil, nil, nil, logger.New())
+
+	users := map[string]*AuthenticatedUser{}
+	for _, name := range []string{"bob", "alice"} {
+		u := &db.User{ID: uuid.New().String(), Username: name, AuthProvider: "local", IsActive: true}
+		if err := store.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		users[name] = &AuthenticatedUser{ID: u.ID, Username: name, Roles: []string{"user"}}
+	}
+	for _, name := range []string{"app", "base"} {
+		if err := store.CreateRepository(ctx, &db.Repository{ID: uuid.New().String(), Namespace: "bob", Name: name}); err != nil {
+			t.Fatalf("CreateRepository: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v2/token", nil)
+	actions := func(user *AuthenticatedUser, repo string) []string {
+		return h.filterActions(r, user, repo, []string{"pull", "push", "delete"})
+	}
+	if got := actions(users["bob"], "bob/app"); !slices.Equal(got, []string{"pull", "push"}) {
+		t.Fatalf("bob with the user role got %v", got)
+	}
+	users["bob"].Roles = append(users["bob"].Roles, "janitor")
+	if got := actions(users["bob"], "bob/app"); !slices.Equal(got, []string{"pull", "push", "delete"}) {
+		t.Fatalf("bob with delete_blob got %v", got)
+	}
+	if got := actions(users["bob"], "bob/base"); !slices.Equal(got, []string{"pull", "push"}) {
+		t.Fatalf("delete_blob on bob/app reached bob/base: %v", got)
+	}
+	// The grant does not stand in for push rights
+	users["alice"].Roles = []string{"user", "janitor"}
+	if got := actions(users["alice"], "bob/app"); !slices.Equal(got, []string{"pull"}) {
+		t.Fatalf("alice without push rights got %v", got)
+	}
+	if ok, _ := enforcer.Enforce([]string{"admin"}, rbac.ResourceRepositories, rbac.ActionDeleteBlob, "bob/app"); !ok {
+		t.Fatal("admin lacks delete_blob")
+	}
+}
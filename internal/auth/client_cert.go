@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nickheyer/distroface/internal/db"
+)
+
+// Names on the request's verified client certificate, common name first,
+// and the org whose signing ca issued it, empty for the instance root.
+// Supplied by the tls layer, which sits above auth
+type ClientNamesFunc func(ctx context.Context) (names []string, orgID string)
+
+// Wires certificate sign-in, nil leaves it off whatever the settings say
+func (m *Manager) SetClientNames(fn ClientNamesFunc) {
+	m.clientNames = fn
+}
+
+// Whether a verified client certificate may stand in for credentials
+func (m *Manager) IsClientCertAuthEnabled() bool {
+	return m.clientNames != nil && m.auth(context.Background()).GetClientCert().GetEnabled()
+}
+
+// Signs in the account a verified client certificate maps to. The first
+// name with an explicit mapping wins, then with match_usernames the first
+// naming an existing user. Nil without an error when nothing maps.
+// Certificates from an org ca only sign in members of that org, its
+// admins hold the ca key and could name anyone
+func (m *Manager) ClientCertUser(ctx context.Context) (*AuthenticatedUser, error) {
+	if !m.IsClientCertAuthEnabled() {
+		return nil, nil
+	}
+	names, orgID := m.clientNames(ctx)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	cfg := m.auth(ctx).GetClientCert()
+
+	var user *db.User
+	for _, name := range names {
+		if mapped, ok := cfg.GetIdentities()[name]; ok && mapped != "" {
+			u, err := m.store.GetUserByUsername(ctx, mapped)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up certificate user: %w", err)
+			}
+			// A mapping to a removed account refuses rather than falling through
+			if u == nil {
+				return nil, ErrInvalidCredentials
+			}
+			user = u
+			break
+		}
+	}
+	if user == nil && cfg.GetMatchUsernames() {
+		for _, name := range names {
+			u, err := m.store.GetUserByUsername(ctx, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up certificate user: %w", err)
+			}
+			if u != nil {
+				user = u
+				break
+			}
+		}
+	}
+	if user == nil {
+		return nil, nil
+	}
+	if orgID != "" {
+		member, err := m.orgMember(ctx, orgID, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check certificate org: %w", err)
+		}
+		if !member {
+			return nil, ErrInvalidCredentials
+		}
+	}
+	if !user.IsActive {
+		if user.PendingApproval {
+			return nil, ErrApprovalPending
+		}
+		return nil, ErrUserNotActive
+	}
+	roleNames, err := m.store.GetUserRoleNames(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+
+	authUser := &AuthenticatedUser{
+		ID:                 user.ID,
+		Username:           user.Username,
+		Roles:              roleNames,
+		Provider:           user.AuthProvider,
+		MustChangePassword: user.MustChangePassword,
+		DefaultNamespace:   user.DefaultNamespace,
+	}
+	if user.Email != nil {
+		authUser.Email = *user.Email
+	}
+	return authUser, nil
+}
+
+// Whether userID belongs to the org with id orgID, false once the org is gone
+func (m *Manager) orgMember(ctx context.Context, orgID, userID string) (bool, error) {
+	org, err := m.store.GetOrganizationByID(ctx, orgID)
+	if err != nil || org == nil {
+		return false, err
+	}
+	member, _, err := m.store.IsOrgMember(ctx, org.Name, userID)
+	return member, err
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestClientCertUser(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	ids := map[string]string{}
+	for _, name := range []string{"ci-bot", "alice", "mallory", "admin"} {
+		u := &db.User{ID: uuid.New().String(), Username: name, AuthProvider: "local", IsActive: true}
+		if err := store.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		ids[name] = u.ID
+		if name == "mallory" {
+			u.IsActive = false
+			if err := store.UpdateUser(ctx, u); err != nil {
+				t.Fatalf("UpdateUser: %v", err)
+			}
+		}
+	}
+	res := settings.NewResolver(store, &v1.Settings{
+		Auth: &v1.AuthSettings{
+			ClientCert: &v1.ClientCertAuthSettings{
+				Enabled:        proto.Bool(true),
+				MatchUsernames: proto.Bool(true),
+				Identities: map[string]string{
+					"runner.build.internal": "ci-bot",
+					"gone.build.internal":   "nobody",
+				},
+			},
+		},
+	})
+	m, err := NewManager(store, nil, "0123456789abcdef0123456789abcdef", res)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	// Without the tls layer wired the settings alone enable nothing
+	if u, err := m.ClientCertUser(ctx); u != nil || err != nil {
+		t.Fatalf("unwired: %v, %v", u, err)
+	}
+	// Org cas sign in their own members only
+	org := &db.Organization{ID: uuid.New().String(), Name: "acme", CreatedBy: "test"}
+	if err := store.CreateOrganization(ctx, org); err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	if err := store.AddOrgMember(ctx, &db.OrgMember{ID: uuid.New().String(), OrgID: org.ID, UserID: ids["alice"], Role: db.OrgRoleMember}); err != nil {
+		t.Fatalf("AddOrgMember: %v", err)
+	}
+
+	var names []string
+	var orgID string
+	m.SetClientNames(func(context.Context) ([]string, string) { return names, orgID })
+
+	cases := []struct {
+		names []string
+		org   string
+		want  string
+		err   error
+	}{
+		{names: nil},
+		{names: []string{"unknown.example.com"}},
+		{names: []string{"alice", "runner.build.internal"}, want: "ci-bot"}, // Mappings beat username matches
+		{names: []string{"workstation", "alice"}, want: "alice"},
+		{names: []string{"gone.build.internal"}, err: ErrInvalidCredentials},
+		{names: []string{"mallory"}, err: ErrUserNotActive},
+		{names: []string{"alice"}, org: org.ID, want: "alice"},
+		{names: []string{"admin"}, org: org.ID, err: ErrInvalidCredentials},
+		{names: []string{"runner.build.internal"}, org: org.ID, err: ErrInvalidCredentials},
+		{names: []string{"alice"}, org: "deleted-org", err: ErrInvalidCredentials},
+	}
+	for _, tc := range cases {
+		names, orgID = tc.names, tc.org
+		u, err := m.ClientCertUser(ctx)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%v: err = %v, want %v", tc.names, err, tc.err)
+		}
+		got := ""
+		if u != nil {
+			got = u.Username
+		}
+		if got != tc.want {
+			t.Fatalf("%v: signed in as %q, want %q", tc.names, got, tc.want)
+		}
+	}
+}
+
+// Registry clients presenting a mapped certificate get tokens without a password
+func TestTokenHandlerClientCert(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, &db.User{ID: uuid.New().String(), Username: "ci-bot", AuthProvider: "local", IsActive: true}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	res := settings.NewResolver(store, &v1.Settings{
+		Auth: &v1.AuthSettings{
+			LocalEnabled:    proto.Bool(true),
+			AnonymousAccess: proto.Bool(false),
+			ClientCert: &v1.ClientCertAuthSettings{
+				Enabled:    proto.Bool(true),
+				Identities: map[string]string{"runner.build.internal": "ci-bot"},
+			},
+		},
+	})
+	m, err := NewManager(store, nil, "0123456789abcdef0123456789abcdef", res)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	var names []string
+	m.SetClientNames(func(context.Context) ([]string, string) { return names, "" })
+	ts, err := NewTokenService(t.TempDir(), "distroface", []string{RegistryService}, res)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	h := NewTokenHandler(ts, store, m, nil, nil, nil, nil, logger.New())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/token?service="+RegistryService, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("no certificate: status %d, want 401", rec.Code)
+	}
+
+	names = []string{"runner.build.internal"}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/token?service="+RegistryService, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("mapped certificate: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp tokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding token: %v", err)
+	}
+	subject, err := ts.VerifyTokenSubject(resp.Token)
+	if err != nil || subject != "ci-bot" {
+		t.Fatalf("token subject = %q, %v", subject, err)
+	}
+}
+
+// dfcli trades its session token for registry tokens, bad tokens get nothing
+func TestTokenHandlerBearer(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	res := settings.NewResolver(store, &v1.Settings{
+		Auth: &v1.AuthSettings{LocalEnabled: proto.Bool(true), AnonymousAccess: proto.Bool(false)},
+	})
+	m, err := NewManager(store, nil, "0123456789abcdef0123456789abcdef", res)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.CreateLocalUser(ctx, "alice", "", "hunter22"); err != nil {
+		t.Fatalf("CreateLocalUser: %v", err)
+	}
+	_, _, session, _, err := m.Login(ctx, "alice", "hunter22")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	ts, err := NewTokenService(t.TempDir(), "distroface", []string{RegistryService}, res)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	h := NewTokenHandler(ts, store, m, nil, nil, nil, nil, logger.New())
+
+	request := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/token?service="+RegistryService, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := request("not-a-session"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("bad bearer: status %d, want 401", rec.Code)
+	}
+	rec := request(session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("session bearer: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp tokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding token: %v", err)
+	}
+	if subject, err := ts.VerifyTokenSubject(resp.Token); err != nil || subject != "alice" {
+		t.Fatalf("token subject = %q, %v", subject, err)
+	}
+}
+
+// A good bearer clears earlier failures like a password login does
+func TestTokenHandlerBearerResetsLimiter(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	res := settings.NewResolver(store, &v1.Settings{
+		Auth: &v1.AuthSettings{LocalEnabled: proto.Bool(true), AnonymousAccess: proto.Bool(false)},
+	})
+	m, err := NewManager(store, nil, "0123456789abcdef0123456789abcdef", res)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.CreateLocalUser(ctx, "alice", "", "hunter22"); err != nil {
+		t.Fatalf("CreateLocalUser: %v", err)
+	}
+	_, _, session, _, err := m.Login(ctx, "alice", "hunter22")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	ts, err := NewTokenService(t.TempDir(), "distroface", []string{RegistryService}, res)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	limiter := admin.NewLimiter(3, time.Minute)
+	h := NewTokenHandler(ts, store, m, nil, nil, limiter, nil, logger.New())
+
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/auth/token?service="+RegistryService, nil)
+		req.RemoteAddr = "192.0.2.7:4000"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for i := 0; i < 2; i++ {
+		if code := request("not-a-session"); code != http.StatusUnauthorized {
+			t.Fatalf("bad bearer %d: status %d, want 401", i, code)
+		}
+	}
+	if code := request(session); code != http.StatusOK {
+		t.Fatalf("session bearer: status %d, want 200", code)
+	}
+	// Without the reset the third failure would lock the client out
+	for i := 0; i < 2; i++ {
+		if code := request("not-a-session"); code != http.StatusUnauthorized {
+			t.Fatalf("bad bearer after success %d: status %d, want 401", i, code)
+		}
+	}
+	if code := request(session); code != http.StatusOK {
+		t.Fatalf("session bearer after two failures: status %d, want 200", code)
+	}
+}
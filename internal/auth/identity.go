@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+// Looks up the user a registry token was minted for, see
+// Manager.ResolveIdentity
+type IdentityResolver interface {
+	ResolveIdentity(ctx context.Context, userID, tokenID string) (*AuthenticatedUser, error)
+}
+
+// Verifies a registry bearer once and puts its user, as the store has them
+// now, in the request context so registry handlers and push policies read
+// roles from there. Tokens of deactivated users or revoked api tokens and
+// sessions are refused before the registry honors their access claims.
+// Other requests pass untouched, the registry refuses bad tokens itself
+func (ts *TokenService) WithIdentity(next http.Handler, users IdentityResolver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, err := ts.VerifyToken(strings.TrimSpace(raw))
+		if err != nil || claims.Identity == nil || claims.Subject == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := users.ResolveIdentity(r.Context(), claims.Identity.UserID, claims.Identity.TokenID)
+		switch {
+		case err == nil && user.Username != claims.Subject:
+			// Renamed since, the token's repository grants name the old namespace
+			_ = errcode.ServeJSON(w, errcode.ErrorCodeUnauthorized.WithMessage("account changed, sign in again"))
+			return
+		case isRevoked(err):
+			_ = errcode.ServeJSON(w, errcode.ErrorCodeUnauthorized.WithMessage(err.Error()))
+			return
+		case err != nil:
+			_ = errcode.ServeJSON(w, errcode.ErrorCodeUnknown)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+	})
+}
+
+func isRevoked(err error) bool {
+	for _, target := range []error{ErrInvalidToken, ErrUserNotActive, ErrSessionExpired, ErrAPITokenExpired} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -18,12 +18,12 @@ import (
 	"github.com/nickheyer/distroface/internal/rbac"
 	"github.com/nickheyer/distroface/internal/settings"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrInvalidCredentials   = errors.New("invalid credentials")
 	ErrUserNotActive        = errors.New("user is not active")
+	ErrApprovalPending      = errors.New("account is awaiting admin approval")
 	ErrInvalidToken         = errors.New("invalid token")
 	ErrSessionExpired       = errors.New("session expired")
 	ErrLocalAuthDisabled    = errors.New("local authentication is disabled")
@@ -37,6 +37,8 @@ type Manager struct {
 	enforcer  *rbac.Enforcer
 	res       *settings.Resolver
 	jwtSecret []byte
+
+	clientNames ClientNamesFunc // Nil disables certificate sign-in
 }
 
 const jwtSecretSettingKey = "jwt_secret"
@@ -98,13 +100,17 @@ func (m *Manager) Login(ctx context.Context, username, password string) (*db.Use
 		}
 	}
 
-	if !checkPassword(user.PasswordHash, password) {
+	if !VerifyPassword(user.PasswordHash, password) {
 		return nil, nil, "", time.Time{}, ErrInvalidCredentials
 	}
 
 	if !user.IsActive {
+		if user.PendingApproval {
+			return nil, nil, "", time.Time{}, ErrApprovalPending
+		}
 		return nil, nil, "", time.Time{}, ErrUserNotActive
 	}
+	m.RehashPassword(ctx, user, password)
 
 	roleNames, err := m.store.GetUserRoleNames(ctx, user.ID)
 	if err != nil {
@@ -174,6 +180,8 @@ func (m *Manager) ValidateSession(ctx context.Context, token string) (*Authentic
 		Roles:              roleNames,
 		Provider:           user.AuthProvider,
 		MustChangePassword: user.MustChangePassword,
+		DefaultNamespace:   user.DefaultNamespace,
+		TokenID:            session.ID,
 	}
 	if user.Email != nil {
 		authUser.Email = *user.Email
@@ -220,7 +228,7 @@ func (m *Manager) Logout(ctx context.Context, token string) error {
 }
 
 func (m *Manager) CreateLocalUser(ctx context.Context, username, email, password string) (*db.User, error) {
-	hashedPassword, err := hashPassword(password)
+	hashedPassword, err := m.HashPassword(ctx, password)
 	if err != nil {
 		return nil, err
 	}
@@ -248,7 +256,7 @@ func (m *Manager) CreateLocalUser(ctx context.Context, username, email, password
 
 // Admin provisioned account, optionally forced to rotate the password
 func (m *Manager) AdminCreateLocalUser(ctx context.Context, username, email, displayName, password string, mustChangePassword bool) (*db.User, error) {
-	hashedPassword, err := hashPassword(password)
+	hashedPassword, err := m.HashPassword(ctx, password)
 	if err != nil {
 		return nil, err
 	}
@@ -289,11 +297,11 @@ func (m *Manager) ChangePassword(ctx context.Context, userID, oldPassword, newPa
 		return errors.New("password change only available for local auth users")
 	}
 
-	if !checkPassword(user.PasswordHash, oldPassword) {
+	if !VerifyPassword(user.PasswordHash, oldPassword) {
 		return ErrInvalidCredentials
 	}
 
-	hashedPassword, err := hashPassword(newPassword)
+	hashedPassword, err := m.HashPassword(ctx, newPassword)
 	if err != nil {
 		return err
 	}
@@ -316,9 +324,14 @@ func (m *Manager) IsAnonymousAccessEnabled() bool {
 	return m.auth(context.Background()).GetAnonymousAccess()
 }
 
+// Whether anonymous callers may pull a repo after org and repo overrides
+func (m *Manager) AnonymousReadable(ctx context.Context, namespace, name string) bool {
+	return m.res.AnonymousReadable(ctx, namespace, name)
+}
+
 func (m *Manager) IsAnyAuthEnabled() bool {
 	a := m.auth(context.Background())
-	return a.GetLocalEnabled() || a.GetOidc().GetEnabled()
+	return a.GetLocalEnabled() || a.GetOidc().GetEnabled() || m.IsClientCertAuthEnabled()
 }
 
 func (m *Manager) IsLocalAuthEnabled() bool {
@@ -339,7 +352,13 @@ func (m *Manager) GetStore() *stores.Store {
 }
 
 // GenerateAPIToken creates a new API token for a user. Plaintext is returned, SHA-256 hash is stored.
-func (m *Manager) GenerateAPIToken(ctx context.Context, userID, name string, expiresInDays *int32) (string, *db.APIToken, error) {
+// Scopes narrow the token below its owner's roles, none leaves it unrestricted
+func (m *Manager) GenerateAPIToken(ctx context.Context, userID, name string, expiresInDays *int32, scopes []string) (string, *db.APIToken, error) {
+	scopes, err := NormalizeScopes(scopes)
+	if err != nil {
+		return "", nil, err
+	}
+
 	raw := make([]byte, 32)
 	if _, err := rand.Read(raw); err != nil {
 		return "", nil, fmt.Errorf("failed to generate token: %w", err)
@@ -361,6 +380,7 @@ func (m *Manager) GenerateAPIToken(ctx context.Context, userID, name string, exp
 		UserID:    userID,
 		Name:      name,
 		TokenHash: hashHex,
+		Scopes:    JoinScopes(scopes),
 		ExpiresAt: expiresAt,
 	}
 
@@ -420,6 +440,9 @@ func (m *Manager) ValidateAPIToken(ctx context.Context, rawToken string) (*Authe
 		Roles:              roleNames,
 		Provider:           user.AuthProvider,
 		MustChangePassword: user.MustChangePassword,
+		DefaultNamespace:   user.DefaultNamespace,
+		Scopes:             SplitScopes(apiToken.Scopes),
+		TokenID:            apiToken.ID,
 	}
 	if user.Email != nil {
 		authUser.Email = *user.Email
@@ -428,6 +451,69 @@ func (m *Manager) ValidateAPIToken(ctx context.Context, rawToken string) (*Authe
 	return authUser, nil
 }
 
+// Signs a registry token's user in again from the store, so role changes,
+// deactivation and revoked api tokens or sessions apply on the next request
+// rather than when the token expires. tokenID is the api token or session
+// the registry token was minted for, empty for password logins
+func (m *Manager) ResolveIdentity(ctx context.Context, userID, tokenID string) (*AuthenticatedUser, error) {
+	user, err := m.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
+	if !user.IsActive {
+		return nil, ErrUserNotActive
+	}
+
+	var scopes []string
+	if tokenID != "" {
+		apiToken, err := m.store.GetAPITokenByID(ctx, tokenID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get api token: %w", err)
+		}
+		switch {
+		case apiToken != nil && apiToken.UserID != user.ID:
+			return nil, ErrInvalidToken
+		case apiToken != nil && apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(time.Now()):
+			return nil, ErrAPITokenExpired
+		case apiToken != nil:
+			scopes = SplitScopes(apiToken.Scopes)
+		default:
+			session, err := m.store.GetSessionByID(ctx, tokenID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get session: %w", err)
+			}
+			if session == nil || session.UserID != user.ID {
+				return nil, ErrSessionExpired
+			}
+		}
+	}
+
+	roleNames, err := m.store.GetUserRoleNames(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	if roleNames == nil {
+		roleNames = []string{}
+	}
+	authUser := &AuthenticatedUser{
+		ID:                 user.ID,
+		Username:           user.Username,
+		Roles:              roleNames,
+		Provider:           user.AuthProvider,
+		MustChangePassword: user.MustChangePassword,
+		DefaultNamespace:   user.DefaultNamespace,
+		Scopes:             scopes,
+		TokenID:            tokenID,
+	}
+	if user.Email != nil {
+		authUser.Email = *user.Email
+	}
+	return authUser, nil
+}
+
 func (m *Manager) generateJWT(userID, username string, roles []string, expiresAt time.Time) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id":  userID,
@@ -466,12 +552,28 @@ func (m *Manager) validateJWT(tokenString string) (jwt.MapClaims, error) {
 	return nil, ErrInvalidToken
 }
 
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+// Hasher for the active password policy
+func (m *Manager) passwordHasher(ctx context.Context) PasswordHasher {
+	return NewPasswordHasher(m.auth(ctx).GetPasswordHash())
 }
 
-func checkPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+// Hashes a new local password under the active policy
+func (m *Manager) HashPassword(ctx context.Context, password string) (string, error) {
+	return m.passwordHasher(ctx).Hash(password)
+}
+
+// Moves a just verified password onto the active policy. A failure keeps
+// the old hash, which still verifies
+func (m *Manager) RehashPassword(ctx context.Context, user *db.User, password string) {
+	h := m.passwordHasher(ctx)
+	if h.Current(user.PasswordHash) {
+		return
+	}
+	hash, err := h.Hash(password)
+	if err != nil {
+		return
+	}
+	if err := m.store.ReplacePasswordHash(ctx, user.ID, user.PasswordHash, hash); err == nil {
+		user.PasswordHash = hash
+	}
 }
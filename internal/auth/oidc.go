@@ -21,8 +21,8 @@ import (
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
-	"github.com/nickheyer/distroface/pkg/utils"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/utils"
 	"golang.org/x/oauth2"
 )
 
@@ -390,22 +390,16 @@ func (h *OIDCHandler) findOrCreateOIDCUser(ctx context.Context, sub, username, e
 	return user, nil
 }
 
-// Counter suffix until no user or org owns it
+// Counter suffix until no user or org owns it and no reserved name covers it
 func (h *OIDCHandler) uniqueUsername(ctx context.Context, base string) (string, error) {
 	name := base
 	for i := 2; i < 100; i++ {
-		user, err := h.store.GetUserByUsername(ctx, name)
+		free, err := h.usernameFree(ctx, name)
 		if err != nil {
 			return "", err
 		}
-		if user == nil {
-			org, err := h.store.GetOrganization(ctx, name)
-			if err != nil {
-				return "", err
-			}
-			if org == nil {
-				return name, nil
-			}
+		if free {
+			return name, nil
 		}
 		suffix := strconv.Itoa(i)
 		name = base
@@ -417,6 +411,18 @@ func (h *OIDCHandler) uniqueUsername(ctx context.Context, base string) (string,
 	return "", fmt.Errorf("no available username for %q", base)
 }
 
+func (h *OIDCHandler) usernameFree(ctx context.Context, name string) (bool, error) {
+	if h.res.CheckNamespace(ctx, name) != nil {
+		return false, nil
+	}
+	user, err := h.store.GetUserByUsername(ctx, name)
+	if err != nil || user != nil {
+		return false, err
+	}
+	org, err := h.store.GetOrganization(ctx, name)
+	return org == nil, err
+}
+
 // Claim value as strings accepting array or json string
 func claimStrings(v any) []string {
 	switch val := v.(type) {
@@ -152,6 +152,28 @@ func TestFindOrCreateOIDCUserSuffixesCollisions(t *testing.T) {
 	}
 }
 
+// Provisioned accounts never land on a reserved namespace
+func TestFindOrCreateOIDCUserSkipsReservedNames(t *testing.T) {
+	h, _ := newOIDCTestHandler(t, "https://idp.example.com")
+	ctx := context.Background()
+	if _, err := h.res.Update(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", &v1.Settings{
+		Namespaces: &v1.NamespaceSettings{ReservedNames: []string{"api", "svc*"}},
+	}, []string{"namespaces.reserved_names"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	user, err := h.findOrCreateOIDCUser(ctx, "sub-a", "api", "")
+	if err != nil {
+		t.Fatalf("findOrCreateOIDCUser: %v", err)
+	}
+	if user.Username != "api2" {
+		t.Fatalf("got username %q, want api2", user.Username)
+	}
+	if _, err := h.findOrCreateOIDCUser(ctx, "sub-b", "svc-build", ""); err == nil {
+		t.Fatal("provisioned a user under a reserved prefix")
+	}
+}
+
 func TestFindOrCreateOIDCUserRejectsInactive(t *testing.T) {
 	h, store := newOIDCTestHandler(t, "https://idp.example.com")
 	ctx := context.Background()
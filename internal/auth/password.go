@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	SchemeBcrypt   = "bcrypt"
+	SchemeArgon2id = "argon2id"
+)
+
+// Hashes local passwords under one scheme and its parameters
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Whether a stored hash already uses this scheme and parameters
+	Current(encoded string) bool
+}
+
+// Hasher for the configured policy, unset fields take the defaults
+func NewPasswordHasher(cfg *v1.PasswordHashSettings) PasswordHasher {
+	if cfg.GetScheme() == SchemeArgon2id {
+		h := argon2Params{memory: 19456, iterations: 2, parallelism: 1}
+		if cfg.GetArgon2MemoryKib() > 0 {
+			h.memory = uint32(cfg.GetArgon2MemoryKib())
+		}
+		if cfg.GetArgon2Iterations() > 0 {
+			h.iterations = uint32(cfg.GetArgon2Iterations())
+		}
+		if cfg.GetArgon2Parallelism() > 0 {
+			h.parallelism = uint8(cfg.GetArgon2Parallelism())
+		}
+		return h
+	}
+	cost := bcrypt.DefaultCost
+	if cfg.GetBcryptCost() > 0 {
+		cost = int(cfg.GetBcryptCost())
+	}
+	return bcryptHasher{cost: cost}
+}
+
+// Rejects policies that cannot hash or would stall every sign-in
+func ValidatePasswordHashSettings(cfg *v1.PasswordHashSettings) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Scheme != nil && *cfg.Scheme != SchemeBcrypt && *cfg.Scheme != SchemeArgon2id {
+		return fmt.Errorf("password hash scheme must be %s or %s", SchemeBcrypt, SchemeArgon2id)
+	}
+	if c := cfg.BcryptCost; c != nil && (int(*c) < bcrypt.DefaultCost || *c > 16) {
+		return fmt.Errorf("bcrypt cost must be between %d and 16", bcrypt.DefaultCost)
+	}
+	if m := cfg.Argon2MemoryKib; m != nil && (*m < 8192 || *m > 1<<20) {
+		return fmt.Errorf("argon2 memory must be between 8192 and 1048576 KiB")
+	}
+	if t := cfg.Argon2Iterations; t != nil && (*t < 1 || *t > 10) {
+		return fmt.Errorf("argon2 iterations must be between 1 and 10")
+	}
+	if p := cfg.Argon2Parallelism; p != nil && (*p < 1 || *p > 16) {
+		return fmt.Errorf("argon2 parallelism must be between 1 and 16")
+	}
+	return nil
+}
+
+// Checks a password against a stored hash of any supported scheme
+func VerifyPassword(encoded, password string) bool {
+	if strings.HasPrefix(encoded, "$"+SchemeArgon2id+"$") {
+		p, salt, key, err := parseArgon2(encoded)
+		if err != nil {
+			return false
+		}
+		got := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(got, key) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(b), err
+}
+
+func (h bcryptHasher) Current(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err == nil && cost == h.cost
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+type argon2Params struct {
+	memory      uint32 // KiB
+	iterations  uint32
+	parallelism uint8
+}
+
+// PHC string format, the one other argon2id implementations read
+func (h argon2Params) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, argon2KeyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", SchemeArgon2id, argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h argon2Params) Current(encoded string) bool {
+	p, _, _, err := parseArgon2(encoded)
+	return err == nil && p == h
+}
+
+func parseArgon2(encoded string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != SchemeArgon2id {
+		return p, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("argon2 parameters: %w", err)
+	}
+	// argon2 panics on these rather than failing
+	if p.iterations < 1 || p.parallelism < 1 {
+		return p, nil, nil, fmt.Errorf("argon2 parameters out of range")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, fmt.Errorf("argon2 key: %v", err)
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nickheyer/distroface/internal/settings"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	argon := &v1.PasswordHashSettings{Scheme: proto.String(SchemeArgon2id), Argon2MemoryKib: proto.Int32(8192), Argon2Iterations: proto.Int32(1)}
+	cases := []struct {
+		name   string
+		cfg    *v1.PasswordHashSettings
+		prefix string
+	}{
+		{"unset", nil, "$2a$10$"},
+		{"bcrypt", &v1.PasswordHashSettings{Scheme: proto.String(SchemeBcrypt), BcryptCost: proto.Int32(11)}, "$2a$11$"},
+		{"argon2id", argon, "$argon2id$v=19$m=8192,t=1,p=1$"},
+	}
+	for _, c := range cases {
+		h := NewPasswordHasher(c.cfg)
+		encoded, err := h.Hash("hunter22")
+		if err != nil {
+			t.Fatalf("%s: Hash: %v", c.name, err)
+		}
+		if !strings.HasPrefix(encoded, c.prefix) {
+			t.Fatalf("%s: hash %q, want prefix %q", c.name, encoded, c.prefix)
+		}
+		if !VerifyPassword(encoded, "hunter22") || VerifyPassword(encoded, "hunter23") {
+			t.Fatalf("%s: verify mismatch", c.name)
+		}
+		if !h.Current(encoded) {
+			t.Fatalf("%s: own hash not current", c.name)
+		}
+	}
+
+	old, _ := NewPasswordHasher(nil).Hash("hunter22")
+	if NewPasswordHasher(argon).Current(old) {
+		t.Fatal("bcrypt hash current under argon2id")
+	}
+	stronger := &v1.PasswordHashSettings{Scheme: proto.String(SchemeArgon2id), Argon2MemoryKib: proto.Int32(8192), Argon2Iterations: proto.Int32(2)}
+	weak, _ := NewPasswordHasher(argon).Hash("hunter22")
+	if NewPasswordHasher(stronger).Current(weak) {
+		t.Fatal("hash with fewer iterations counted as current")
+	}
+
+	for _, bad := range []string{"", "$argon2id$v=19$m=8192,t=0,p=1$c2FsdA$a2V5", "$argon2id$v=18$m=8192,t=1,p=1$c2FsdA$a2V5", "plaintext"} {
+		if VerifyPassword(bad, "") {
+			t.Fatalf("malformed hash %q verified", bad)
+		}
+	}
+}
+
+func TestValidatePasswordHashSettings(t *testing.T) {
+	bad := []*v1.PasswordHashSettings{
+		{Scheme: proto.String("md5")},
+		{BcryptCost: proto.Int32(4)},
+		{Argon2MemoryKib: proto.Int32(64)},
+		{Argon2Iterations: proto.Int32(0)},
+		{Argon2Parallelism: proto.Int32(64)},
+	}
+	for _, cfg := range bad {
+		if ValidatePasswordHashSettings(cfg) == nil {
+			t.Errorf("accepted %v", cfg)
+		}
+	}
+	if err := ValidatePasswordHashSettings(&v1.PasswordHashSettings{Scheme: proto.String(SchemeArgon2id), Argon2MemoryKib: proto.Int32(65536)}); err != nil {
+		t.Fatalf("rejected a sane policy: %v", err)
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	manager := func(cfg *v1.PasswordHashSettings) *Manager {
+		res := settings.NewResolver(store, &v1.Settings{Auth: &v1.AuthSettings{PasswordHash: cfg}})
+		m, err := NewManager(store, nil, "0123456789abcdef0123456789abcdef", res)
+		if err != nil {
+			t.Fatalf("NewManager: %v", err)
+		}
+		return m
+	}
+
+	user, err := manager(nil).CreateLocalUser(ctx, "alice", "", "hunter22")
+	if err != nil {
+		t.Fatalf("CreateLocalUser: %v", err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$2a$") {
+		t.Fatalf("default hash %q, want bcrypt", user.PasswordHash)
+	}
+
+	argon := manager(&v1.PasswordHashSettings{Scheme: proto.String(SchemeArgon2id), Argon2MemoryKib: proto.Int32(8192), Argon2Iterations: proto.Int32(1)})
+	if _, _, _, _, err := argon.Login(ctx, "alice", "wrong"); err != ErrInvalidCredentials {
+		t.Fatalf("wrong password: %v", err)
+	}
+	if stored, _ := store.GetUserByID(ctx, user.ID); stored.PasswordHash != user.PasswordHash {
+		t.Fatal("failed sign-in rehashed the password")
+	}
+	if _, _, _, _, err := argon.Login(ctx, "alice", "hunter22"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	stored, _ := store.GetUserByID(ctx, user.ID)
+	if !strings.HasPrefix(stored.PasswordHash, "$argon2id$") {
+		t.Fatalf("hash after sign-in %q, want argon2id", stored.PasswordHash)
+	}
+	if _, _, _, _, err := argon.Login(ctx, "alice", "hunter22"); err != nil {
+		t.Fatalf("Login after rehash: %v", err)
+	}
+	if again, _ := store.GetUserByID(ctx, user.ID); again.PasswordHash != stored.PasswordHash {
+		t.Fatal("current hash rehashed again")
+	}
+}
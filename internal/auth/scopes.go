@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nickheyer/distroface/internal/rbac"
+)
+
+// Api token scopes, write includes read
+const (
+	ScopeRepoRead      = "repo:read"
+	ScopeRepoWrite     = "repo:write"
+	ScopeArtifactRead  = "artifact:read"
+	ScopeArtifactWrite = "artifact:write"
+)
+
+var TokenScopes = []string{ScopeRepoRead, ScopeRepoWrite, ScopeArtifactRead, ScopeArtifactWrite}
+
+// Scope areas by the rbac resource they cover
+var scopeAreas = map[string]string{
+	rbac.ResourceRepositories: "repo",
+	rbac.ResourceArtifacts:    "artifact",
+}
+
+// Validates scope names, sorted and deduplicated for storage
+func NormalizeScopes(scopes []string) ([]string, error) {
+	out := make([]string, 0, len(scopes))
+	for _, sc := range scopes {
+		sc = strings.ToLower(strings.TrimSpace(sc))
+		if !slices.Contains(TokenScopes, sc) {
+			return nil, fmt.Errorf("unknown token scope %q, want one of %s", sc, strings.Join(TokenScopes, ", "))
+		}
+		out = append(out, sc)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// Stored form, empty for an unscoped token
+func JoinScopes(scopes []string) string {
+	return strings.Join(scopes, ",")
+}
+
+// Inverse of JoinScopes, nil for an unscoped token
+func SplitScopes(stored string) []string {
+	if stored == "" {
+		return nil
+	}
+	return strings.Split(stored, ",")
+}
+
+// Reports whether the credential's scopes cover an action on a resource.
+// Roles still decide on top, scopes only ever narrow them. Reads and pulls
+// need the read or write scope of the area, anything else the write scope
+func (u *AuthenticatedUser) Allows(resource, action string) bool {
+	if u == nil || u.Scopes == nil {
+		return true
+	}
+	area, ok := scopeAreas[resource]
+	if !ok {
+		return false
+	}
+	if slices.Contains(u.Scopes, area+":write") {
+		return true
+	}
+	return (action == rbac.ActionRead || action == rbac.ActionPull) && slices.Contains(u.Scopes, area+":read")
+}
+
+// Reports whether the credential is a scoped api token
+func (u *AuthenticatedUser) Scoped() bool {
+	return u != nil && u.Scopes != nil
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nickheyer/distroface/internal/rbac"
+)
+
+func TestTokenScopes(t *testing.T) {
+	got, err := NormalizeScopes([]string{"repo:write", " Artifact:Read", "repo:write"})
+	if err != nil || !slices.Equal(got, []string{"artifact:read", "repo:write"}) {
+		t.Fatalf("NormalizeScopes = %v, %v", got, err)
+	}
+	if _, err := NormalizeScopes([]string{"tokens:write"}); err == nil {
+		t.Fatal("unknown scope accepted")
+	}
+	if got := SplitScopes(JoinScopes(nil)); got != nil {
+		t.Fatalf("unscoped round trip = %#v", got)
+	}
+
+	unscoped := &AuthenticatedUser{Username: "alice"}
+	scoped := &AuthenticatedUser{Username: "alice", Scopes: []string{"artifact:read", "repo:write"}}
+	cases := []struct {
+		user             *AuthenticatedUser
+		resource, action string
+		want             bool
+	}{
+		{unscoped, rbac.ResourceSettings, rbac.ActionUpdate, true},
+		{scoped, rbac.ResourceArtifacts, rbac.ActionRead, true},
+		{scoped, rbac.ResourceArtifacts, rbac.ActionPull, true},
+		{scoped, rbac.ResourceArtifacts, rbac.ActionPush, false},
+		{scoped, rbac.ResourceRepositories, rbac.ActionPull, true},
+		{scoped, rbac.ResourceRepositories, rbac.ActionDelete, true},
+		{scoped, rbac.ResourceTokens, rbac.ActionCreate, false},
+		{scoped, rbac.ResourceUsers, rbac.ActionRead, false},
+	}
+	for _, tc := range cases {
+		if got := tc.user.Allows(tc.resource, tc.action); got != tc.want {
+			t.Errorf("%v Allows(%s, %s) = %v, want %v", tc.user.Scopes, tc.resource, tc.action, got, tc.want)
+		}
+	}
+}
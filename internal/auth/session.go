@@ -3,6 +3,8 @@ package auth
 import (
 	"context"
 	"strings"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
 )
 
 type contextKey string
@@ -15,12 +17,32 @@ type AuthenticatedUser struct {
 	Username           string
 	Email              string
 	Roles              []string
-	Provider           string // "local", "oidc", "anonymous"
-	MustChangePassword bool   // rpc access pending pw rotation
+	Provider           string   // "local", "oidc", "anonymous"
+	MustChangePassword bool     // rpc access pending pw rotation
+	DefaultNamespace   string   // Chosen home for bare repo names, empty for their own
+	Scopes             []string // Set for scoped api tokens, nil leaves roles alone in charge
+	TokenID            string   // Api token or session the caller signed in with, empty otherwise
+}
+
+// Namespace unqualified repo names resolve to for this user
+func (u *AuthenticatedUser) HomeNamespace() string {
+	if u.DefaultNamespace != "" {
+		return u.DefaultNamespace
+	}
+	return u.Username
 }
 
-// WithUser attaches an authenticated user to the context.
+// Reports whether the caller carries no identity of its own
+func IsAnonymous(user *AuthenticatedUser) bool {
+	return user == nil || user.Provider == "anonymous"
+}
+
+// WithUser attaches an authenticated user to the context. Store writes
+// under it credit the user in the artifact changelog
 func WithUser(ctx context.Context, user *AuthenticatedUser) context.Context {
+	if user != nil {
+		ctx = stores.WithActor(ctx, user.Username)
+	}
 	return context.WithValue(ctx, userContextKey, user)
 }
 
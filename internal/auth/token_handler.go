@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,7 +14,7 @@ import (
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/rbac"
 	"github.com/nickheyer/distroface/pkg/logger"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/nickheyer/distroface/pkg/utils"
 )
 
 // Applies portal rules at the token endpoint, portals resolve by hostnames/ports
@@ -24,6 +26,14 @@ type RegistryAccessPolicy interface {
 	IsPortalHost(host string) bool                // Check if host is an enabled portal hostname
 }
 
+// Grants pull on names served outside the image registry, handled is
+// false for names it does not own
+type BridgedRepos interface {
+	BridgedPull(ctx context.Context, user *AuthenticatedUser, name string) (handled, allowed bool)
+	// Names the catalog lists, pull only so push listings skip them
+	BridgedNames(ctx context.Context, user *AuthenticatedUser) ([]string, error)
+}
+
 // TokenHandler implements the Docker Token Authentication Specification.
 type TokenHandler struct {
 	tokenService *TokenService
@@ -33,6 +43,7 @@ type TokenHandler struct {
 	policy       RegistryAccessPolicy
 	authLimiter  *admin.Limiter // Failed-credential lockout per client IP, nil disables
 	recorder     *audit.Recorder
+	bridge       BridgedRepos // Nil serves image repositories only
 	log          *logger.Logger
 }
 
@@ -58,18 +69,32 @@ func NewTokenHandler(ts *TokenService, store *stores.Store, manager *Manager, en
 }
 
 func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	service, scopeStr, account := r.FormValue("service"), r.FormValue("scope"), r.FormValue("account")
+	service, scopeStr := r.FormValue("service"), r.FormValue("scope")
+
+	// Tokens only go to audiences this server answers for
+	audience, ok := h.tokenService.Audience(service)
+	if !ok {
+		http.Error(w, "unknown service", http.StatusBadRequest)
+		return
+	}
 
 	username, password, hasCreds := r.BasicAuth()
 	if !hasCreds {
 		username, password = r.FormValue("username"), r.FormValue("password")
 		hasCreds = username != ""
 	}
+	// dfcli holds a session or api token rather than a password
+	bearer := ""
+	if !hasCreds {
+		if prefix, tok, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(prefix, "Bearer") {
+			bearer = strings.TrimSpace(tok)
+		}
+	}
 
 	clientIP := admin.ClientIP(r.RemoteAddr, r.Header)
 
 	// Brute-force lockout: too many failed credential attempts from this IP
-	if hasCreds && h.authLimiter != nil && h.authLimiter.Blocked(clientIP) {
+	if (hasCreds || bearer != "") && h.authLimiter != nil && h.authLimiter.Blocked(clientIP) {
 		w.Header().Set("Retry-After", "60")
 		http.Error(w, "too many failed authentication attempts", http.StatusTooManyRequests)
 		return
@@ -96,31 +121,32 @@ func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if u == nil || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+			if u == nil || !VerifyPassword(u.PasswordHash, password) {
 				h.recordAuthFailure(clientIP)
 				h.auditLogin(r, nil, username, clientIP, audit.OutcomeDenied)
 				w.Header().Set("WWW-Authenticate", `Basic realm="`+service+`"`)
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
+			if h.authManager != nil {
+				h.authManager.RehashPassword(r.Context(), u, password)
+			}
 			// Resolve roles for the user
 			roleNames, err := h.store.GetUserRoleNames(r.Context(), u.ID)
 			if err != nil {
 				roleNames = []string{}
 			}
 			authUser = &AuthenticatedUser{
-				ID:       u.ID,
-				Username: u.Username,
-				Roles:    roleNames,
-				Provider: u.AuthProvider,
+				ID:               u.ID,
+				Username:         u.Username,
+				Roles:            roleNames,
+				Provider:         u.AuthProvider,
+				DefaultNamespace: u.DefaultNamespace,
 			}
 			if u.Email != nil {
 				authUser.Email = *u.Email
 			}
 		}
-		if account == "" && authUser != nil {
-			account = authUser.Username
-		}
 		if h.authLimiter != nil {
 			h.authLimiter.Reset(clientIP)
 		}
@@ -130,6 +156,39 @@ func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if bearer != "" {
+		user, err := h.authManager.ValidateToken(r.Context(), bearer)
+		if err != nil {
+			h.recordAuthFailure(clientIP)
+			h.auditLogin(r, nil, "", clientIP, audit.OutcomeDenied)
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+service+`"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		authUser = user
+		if h.authLimiter != nil {
+			h.authLimiter.Reset(clientIP)
+		}
+	}
+
+	// A verified client certificate signs in clients that sent no credentials
+	if !hasCreds && bearer == "" {
+		user, err := h.authManager.ClientCertUser(r.Context())
+		if err != nil {
+			h.log.Warn("token auth: client certificate refused: %v", err)
+			h.auditLogin(r, nil, "", clientIP, audit.OutcomeDenied)
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+service+`"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if user != nil {
+			authUser = user
+			if scopeStr == "" {
+				h.auditLogin(r, authUser, authUser.Username, clientIP, audit.OutcomeSuccess)
+			}
+		}
+	}
+
 	// Refuse anon token when anon access turned off
 	if authUser == nil && h.authManager.IsAnyAuthEnabled() && !h.authManager.IsAnonymousAccessEnabled() {
 		w.Header().Set("WWW-Authenticate", `Basic realm="`+service+`"`)
@@ -149,7 +208,10 @@ func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		access = h.resolveAccess(r, authUser, scopeStr)
 	}
 
-	tokenStr, err := h.tokenService.SignToken(account, access)
+	// Subject is who authenticated, the client supplied account is only a hint
+	// and catalog listings trust the subject. Their id and sign in ride along
+	// so registry handlers can look them up
+	tokenStr, err := h.tokenService.SignTokenAs(audience, authUser, access)
 	if err != nil {
 		h.log.Error("token auth: failed to sign token: %v", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
@@ -207,6 +269,11 @@ func (h *TokenHandler) resolveAccess(r *http.Request, user *AuthenticatedUser, s
 		resourceName := parts[1]
 		requestedActions := strings.Split(parts[2], ",")
 
+		// Catalog grant only lets the bearer ask, listings filter per subject
+		if resourceType == "registry" && resourceName == "catalog" {
+			result = append(result, &ResourceActions{Type: resourceType, Name: resourceName, Actions: []string{"*"}})
+			continue
+		}
 		if resourceType != "repository" {
 			continue
 		}
@@ -218,6 +285,10 @@ func (h *TokenHandler) resolveAccess(r *http.Request, user *AuthenticatedUser, s
 				continue
 			}
 		}
+		// Names still bare land in the caller's home namespace, as RegistryAliases rewrites them
+		if !strings.Contains(resourceName, "/") && !IsAnonymous(user) {
+			resourceName = user.HomeNamespace() + "/" + resourceName
+		}
 
 		granted := h.filterActions(r, user, resourceName, requestedActions)
 		if h.policy != nil && !h.policy.AllowPush(r) {
@@ -245,7 +316,20 @@ func withoutAction(actions []string, drop string) []string {
 	return kept
 }
 
+// Routes bridged artifact names to their own access rules, pull only
+func (h *TokenHandler) SetBridge(b BridgedRepos) {
+	h.bridge = b
+}
+
 func (h *TokenHandler) filterActions(r *http.Request, user *AuthenticatedUser, repoName string, requested []string) []string {
+	if h.bridge != nil {
+		if handled, allowed := h.bridge.BridgedPull(r.Context(), user, repoName); handled {
+			if allowed && slices.Contains(requested, "pull") && user.Allows(rbac.ResourceArtifacts, rbac.ActionPull) {
+				return []string{"pull"}
+			}
+			return nil
+		}
+	}
 	namespaceName := strings.SplitN(repoName, "/", 2)
 	if len(namespaceName) != 2 {
 		return nil
@@ -262,13 +346,23 @@ func (h *TokenHandler) filterActions(r *http.Request, user *AuthenticatedUser, r
 	for _, action := range requested {
 		switch action {
 		case "pull":
-			if h.canPull(r, user, namespace, repo) {
+			if user.Allows(rbac.ResourceRepositories, rbac.ActionPull) && h.canPull(r, user, namespace, repo) {
 				granted = append(granted, "pull")
 			}
 		case "push":
-			if h.canPush(r, user, namespace) {
+			// Pushes create repos, so the name policy applies here
+			if err := utils.ValidateImagePath(repoName); err != nil {
+				h.log.Warn("token auth: refusing push to %s: %v", repoName, err)
+				continue
+			}
+			if user.Allows(rbac.ResourceRepositories, rbac.ActionPush) && h.canPush(r, user, namespace, namespaceName[1]) {
 				granted = append(granted, "push")
 			}
+		case "delete":
+			// Raw manifest and blob deletes, tags alone go through DeleteTag
+			if user.Allows(rbac.ResourceRepositories, rbac.ActionDeleteBlob) && h.canDeleteContent(r, user, namespace, namespaceName[1]) {
+				granted = append(granted, "delete")
+			}
 		}
 	}
 	return granted
@@ -279,9 +373,9 @@ func (h *TokenHandler) canPull(r *http.Request, user *AuthenticatedUser, namespa
 	if repo == nil {
 		return user != nil
 	}
-	// Public repos are pullable by anyone
+	// Public repos are pullable by anyone the namespace still lets in
 	if !repo.IsPrivate {
-		return true
+		return user != nil || h.authManager == nil || h.authManager.AnonymousReadable(r.Context(), namespace, repo.Name)
 	}
 	if user == nil {
 		return false
@@ -298,14 +392,40 @@ func (h *TokenHandler) canPull(r *http.Request, user *AuthenticatedUser, namespa
 		return true
 	}
 	// Org member can pull org repos
-	isMember, _, _ := h.store.IsOrgMember(r.Context(), namespace, user.ID)
-	return isMember
+	if isMember, _, _ := h.store.IsOrgMember(r.Context(), namespace, user.ID); isMember {
+		return true
+	}
+	return h.aclAllows(r, user, namespace, repo.Name, rbac.ActionPull)
 }
 
-func (h *TokenHandler) canPush(r *http.Request, user *AuthenticatedUser, namespace string) bool {
+// Namespace push rights or a write grant on the repo
+func (h *TokenHandler) canPush(r *http.Request, user *AuthenticatedUser, namespace, name string) bool {
 	if user == nil {
 		return false
 	}
+	return h.canPushNamespace(r, user, namespace) || h.aclAllows(r, user, namespace, name, rbac.ActionPush)
+}
+
+// Push rights plus a role holding delete_blob on the repo
+func (h *TokenHandler) canDeleteContent(r *http.Request, user *AuthenticatedUser, namespace, name string) bool {
+	if user == nil || h.enforcer == nil {
+		return false
+	}
+	allowed, _ := h.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionDeleteBlob, namespace+"/"+name)
+	return allowed && h.canPush(r, user, namespace, name)
+}
+
+// Repository ACL grant to the user or one of its roles
+func (h *TokenHandler) aclAllows(r *http.Request, user *AuthenticatedUser, namespace, name, action string) bool {
+	levels, err := h.store.RepositoryPermissionLevels(r.Context(), namespace, name, user.ID, user.Roles)
+	if err != nil {
+		h.log.Error("token auth: failed to look up grants on %s/%s: %v", namespace, name, err)
+		return false
+	}
+	return rbac.LevelsAllow(levels, action)
+}
+
+func (h *TokenHandler) canPushNamespace(r *http.Request, user *AuthenticatedUser, namespace string) bool {
 	// Namespace owner can always push
 	if user.Username == namespace {
 		return true
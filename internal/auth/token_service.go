@@ -12,6 +12,7 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth/token"
@@ -29,10 +30,13 @@ type TokenService struct {
 	certPath   string
 	keyID      string
 	issuer     string
-	service    string
+	audiences  []string // First is the embedded registry's own service
 	res        *settings.Resolver
 }
 
+// Service the embedded registry names in its bearer challenges
+const RegistryService = "distroface-registry"
+
 // ResourceActions matches the Distribution v3 token claim format.
 type ResourceActions struct {
 	Type    string   `json:"type"`
@@ -50,10 +54,23 @@ type ClaimSet struct {
 	IssuedAt   *josejwt.NumericDate `json:"iat"`
 	JWTID      string               `json:"jti"`
 	Access     []*ResourceActions   `json:"access"`
+	Identity   *TokenIdentity       `json:"df,omitempty"`
+}
+
+// Which account and sign in the token was minted for. Roles, scopes and
+// the rest are looked up per request, see WithIdentity
+type TokenIdentity struct {
+	UserID  string `json:"uid"`
+	TokenID string `json:"tid,omitempty"`
 }
 
 // NewTokenService initializes keys from disk or generates them, then returns a TokenService.
-func NewTokenService(dataDir, issuer, service string, res *settings.Resolver) (*TokenService, error) {
+// Tokens are minted and accepted only for the given audiences, the first is the default
+func NewTokenService(dataDir, issuer string, audiences []string, res *settings.Resolver) (*TokenService, error) {
+	if len(audiences) == 0 {
+		return nil, fmt.Errorf("token service needs at least one audience")
+	}
+
 	keysDir := filepath.Join(dataDir, "keys")
 	if err := os.MkdirAll(keysDir, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create keys directory: %w", err)
@@ -63,10 +80,10 @@ func NewTokenService(dataDir, issuer, service string, res *settings.Resolver) (*
 	certPath := filepath.Join(keysDir, "token.crt")
 
 	ts := &TokenService{
-		certPath: certPath,
-		issuer:   issuer,
-		service:  service,
-		res:      res,
+		certPath:  certPath,
+		issuer:    issuer,
+		audiences: slices.Clone(audiences),
+		res:       res,
 	}
 
 	if err := ts.loadOrGenerate(keyPath, certPath); err != nil {
@@ -83,6 +100,20 @@ func (ts *TokenService) CertPath() string {
 	return ts.certPath
 }
 
+// Accepted audiences, the default first
+func (ts *TokenService) Audiences() []string {
+	return slices.Clone(ts.audiences)
+}
+
+// Audience a token request for service is minted for, empty picks the
+// default and unknown services are refused
+func (ts *TokenService) Audience(service string) (string, bool) {
+	if service == "" {
+		return ts.audiences[0], true
+	}
+	return service, slices.Contains(ts.audiences, service)
+}
+
 // Live registry token lifetime
 func (ts *TokenService) expiry() time.Duration {
 	return time.Duration(ts.res.System(context.Background()).GetAuth().GetTokenExpirySeconds()) * time.Second
@@ -90,17 +121,39 @@ func (ts *TokenService) expiry() time.Duration {
 
 // SignToken creates a signed JWT for the given subject and access claims.
 func (ts *TokenService) SignToken(subject string, access []*ResourceActions) (string, error) {
+	return ts.SignTokenFor(ts.audiences[0], subject, access)
+}
+
+// Like SignToken with the audience of a token request, see Audience
+func (ts *TokenService) SignTokenFor(audience, subject string, access []*ResourceActions) (string, error) {
+	return ts.sign(audience, subject, access, nil)
+}
+
+// SignTokenFor a signed in user, their id and the api token or session
+// they used ride along. Nil users get an anonymous token
+func (ts *TokenService) SignTokenAs(audience string, user *AuthenticatedUser, access []*ResourceActions) (string, error) {
+	if user == nil {
+		return ts.sign(audience, "", access, nil)
+	}
+	return ts.sign(audience, user.Username, access, &TokenIdentity{UserID: user.ID, TokenID: user.TokenID})
+}
+
+func (ts *TokenService) sign(audience, subject string, access []*ResourceActions, identity *TokenIdentity) (string, error) {
+	if !slices.Contains(ts.audiences, audience) {
+		return "", fmt.Errorf("audience %q is not accepted", audience)
+	}
 	now := time.Now().UTC()
 
 	claims := ClaimSet{
 		Issuer:     ts.issuer,
 		Subject:    subject,
-		Audience:   josejwt.Audience{ts.service},
+		Audience:   josejwt.Audience{audience},
 		Expiration: josejwt.NewNumericDate(now.Add(ts.expiry())),
 		NotBefore:  josejwt.NewNumericDate(now.Add(-10 * time.Second)),
 		IssuedAt:   josejwt.NewNumericDate(now),
 		JWTID:      uuid.New().String(),
 		Access:     access,
+		Identity:   identity,
 	}
 
 	signerOpts := jose.SignerOptions{}
@@ -141,6 +194,28 @@ func (ts *TokenService) VerifyTokenSubject(raw string) (string, error) {
 	return claims.Subject, nil
 }
 
+// Full check of a registry token, signature, issuer, audience and lifetime
+func (ts *TokenService) VerifyToken(raw string) (*ClaimSet, error) {
+	tok, err := josejwt.ParseSigned(raw, []jose.SignatureAlgorithm{jose.ES256})
+	if err != nil {
+		return nil, err
+	}
+	var claims ClaimSet
+	if err := tok.Claims(ts.privateKey.Public(), &claims); err != nil {
+		return nil, err
+	}
+	std := josejwt.Claims{
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		Expiry:    claims.Expiration,
+		NotBefore: claims.NotBefore,
+	}
+	if err := std.Validate(josejwt.Expected{Issuer: ts.issuer, AnyAudience: josejwt.Audience(ts.audiences)}); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
 func (ts *TokenService) loadOrGenerate(keyPath, certPath string) error {
 	keyData, keyErr := os.ReadFile(keyPath)
 	certData, certErr := os.ReadFile(certPath)
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+func TestTokenAudience(t *testing.T) {
+	store := newTestStore(t)
+	res := settings.NewResolver(store, &v1.Settings{})
+	dir := t.TempDir()
+	ts, err := NewTokenService(dir, "distroface", []string{RegistryService, "registry.internal"}, res)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	// Same keys, issuer and default audience, different extra audience
+	other, err := NewTokenService(dir, "distroface", []string{RegistryService, "registry.elsewhere"}, res)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+
+	for service, want := range map[string]string{"": RegistryService, RegistryService: RegistryService, "registry.internal": "registry.internal"} {
+		if got, ok := ts.Audience(service); !ok || got != want {
+			t.Errorf("Audience(%q) = %q, %v, want %q", service, got, ok, want)
+		}
+	}
+	if _, ok := ts.Audience("registry.elsewhere"); ok {
+		t.Fatal("unconfigured service accepted")
+	}
+	if _, err := ts.SignTokenFor("registry.elsewhere", "alice", nil); err == nil {
+		t.Fatal("signed a token for an unconfigured audience")
+	}
+
+	internal, err := ts.SignTokenFor("registry.internal", "alice", nil)
+	if err != nil {
+		t.Fatalf("SignTokenFor: %v", err)
+	}
+	claims, err := ts.VerifyToken(internal)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "registry.internal" {
+		t.Fatalf("audience = %v", claims.Audience)
+	}
+	if _, err := other.VerifyToken(internal); err == nil {
+		t.Fatal("token minted for registry.internal accepted by a service that does not answer for it")
+	}
+
+	foreign, err := other.SignTokenFor("registry.elsewhere", "alice", nil)
+	if err != nil {
+		t.Fatalf("SignTokenFor: %v", err)
+	}
+	if _, err := ts.VerifyToken(foreign); err == nil {
+		t.Fatal("token minted for registry.elsewhere accepted")
+	}
+	if _, err := ts.VerifyToken(mustSign(t, other)); err != nil {
+		t.Fatalf("default audience token refused: %v", err)
+	}
+
+	if _, err := NewTokenService(t.TempDir(), "distroface", nil, res); err == nil {
+		t.Fatal("token service built without audiences")
+	}
+}
+
+func TestTokenHandlerUnknownService(t *testing.T) {
+	store := newTestStore(t)
+	ts, err := NewTokenService(t.TempDir(), "distroface", []string{RegistryService}, settings.NewResolver(store, &v1.Settings{}))
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	h := NewTokenHandler(ts, store, nil, nil, nil, nil, nil, logger.New())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/token?service=registry.elsewhere&scope=repository:alice/app:pull", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown service: status %d, want 400", rec.Code)
+	}
+}
+
+// Tokens name the account and sign in, the middleware looks the user up
+// per request. Role changes apply at once, revoked sign ins and inactive
+// users are refused. Tokens without an identity and bad ones add no user
+func TestTokenIdentity(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	res := settings.NewResolver(store, &v1.Settings{})
+	ts, err := NewTokenService(t.TempDir(), "distroface", []string{RegistryService}, res)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	m, err := NewManager(store, nil, "0123456789abcdef0123456789abcdef", res)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	alice := &db.User{ID: uuid.New().String(), Username: "alice", AuthProvider: "local", DefaultNamespace: "team", IsActive: true}
+	if err := store.CreateUser(ctx, alice); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	_, pat, err := m.GenerateAPIToken(ctx, alice.ID, "ci", nil, []string{"repo:read"})
+	if err != nil {
+		t.Fatalf("GenerateAPIToken: %v", err)
+	}
+	session := &db.Session{UserID: alice.ID, Token: "session-jwt", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.CreateSession(ctx, session); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	sign := func(tokenID string, roles ...string) string {
+		t.Helper()
+		// Roles handed to the signer never reach the registry
+		tok, err := ts.SignTokenAs(RegistryService, &AuthenticatedUser{ID: alice.ID, Username: "alice", Roles: roles, TokenID: tokenID}, nil)
+		if err != nil {
+			t.Fatalf("SignTokenAs: %v", err)
+		}
+		return tok
+	}
+	anon, err := ts.SignTokenAs(RegistryService, nil, nil)
+	if err != nil {
+		t.Fatalf("SignTokenAs: %v", err)
+	}
+
+	var got *AuthenticatedUser
+	h := ts.WithIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = UserFromContext(r.Context())
+	}), m)
+	serve := func(bearer string) (*AuthenticatedUser, int) {
+		t.Helper()
+		got = nil
+		req := httptest.NewRequest(http.MethodGet, "/v2/team/app/manifests/latest", nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return got, rec.Code
+	}
+
+	patToken, sessionToken := sign(pat.ID, "admin"), sign(session.ID)
+	u, _ := serve(patToken)
+	if u == nil || u.Username != "alice" || u.ID != alice.ID || u.HomeNamespace() != "team" || u.TokenID != pat.ID || len(u.Scopes) != 1 {
+		t.Fatalf("user from token = %+v", u)
+	}
+	// No roles is still an answer, callers must not look them up again
+	if u.Roles == nil || len(u.Roles) != 0 {
+		t.Fatalf("roles = %v, want the stored none", u.Roles)
+	}
+	if err := store.AssignRole(ctx, alice.ID, "admin", "local"); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if u, _ := serve(patToken); u == nil || len(u.Roles) != 1 || u.Roles[0] != "admin" {
+		t.Fatalf("granted role not seen by a live token: %+v", u)
+	}
+	if err := store.UnassignRole(ctx, alice.ID, "admin"); err != nil {
+		t.Fatalf("UnassignRole: %v", err)
+	}
+	if u, _ := serve(patToken); u == nil || len(u.Roles) != 0 {
+		t.Fatalf("removed role still honored: %+v", u)
+	}
+	if u, _ := serve(sessionToken); u == nil || u.Scopes != nil {
+		t.Fatalf("user from session token = %+v", u)
+	}
+
+	// Revoking the sign in refuses the registry token before it expires
+	if err := store.DeleteAPIToken(ctx, pat.ID, alice.ID); err != nil {
+		t.Fatalf("DeleteAPIToken: %v", err)
+	}
+	if u, code := serve(patToken); u != nil || code != http.StatusUnauthorized {
+		t.Fatalf("revoked api token = %+v %d", u, code)
+	}
+	if err := store.DeleteSession(ctx, session.Token); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if u, code := serve(sessionToken); u != nil || code != http.StatusUnauthorized {
+		t.Fatalf("signed out session = %+v %d", u, code)
+	}
+	alice.IsActive = false
+	if err := store.UpdateUser(ctx, alice); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if u, code := serve(sign("")); u != nil || code != http.StatusUnauthorized {
+		t.Fatalf("inactive user = %+v %d", u, code)
+	}
+
+	for name, bearer := range map[string]string{"anonymous": anon, "subject only": mustSign(t, ts), "garbage": "not-a-token", "none": ""} {
+		if u, code := serve(bearer); u != nil || code != http.StatusOK {
+			t.Errorf("%s token gave user %+v, status %d", name, u, code)
+		}
+	}
+}
+
+func mustSign(t *testing.T, ts *TokenService) string {
+	t.Helper()
+	tok, err := ts.SignToken("alice", nil)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	return tok
+}
@@ -181,6 +181,13 @@ func (s *ACMEServer) setNonce(w http.ResponseWriter, r *http.Request) {
 	_ = r
 }
 
+// Live nonce, order and authorization counts
+func (s *ACMEServer) StateSizes() (nonces, orders, authzs int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.nonces), len(s.orders), len(s.authzs)
+}
+
 // Drops expired nonces, orders, and authorizations
 func (s *ACMEServer) gcLocked() {
 	now := time.Now()
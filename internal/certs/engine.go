@@ -52,6 +52,7 @@ type Engine struct {
 	bindHost string
 
 	configCert *tls.Certificate // From cert_file/key_file at startup
+	noHTTP2    bool             // Drops h2 from alpn, set before serving
 
 	mu           sync.Mutex
 	managers     map[string]*autocert.Manager // Keyed directory plus email
@@ -246,14 +247,21 @@ func (e *Engine) managerForHost(ctx context.Context, host string) *autocert.Mana
 // Shared server config, sni picks the right cert per hostname and
 // GetConfigForClient layers on any per host client certificate policy
 func (e *Engine) TLSConfig() *tls.Config {
+	protos := []string{"h2", "http/1.1", acme.ALPNProto}
+	if e.noHTTP2 {
+		protos = protos[1:]
+	}
 	return &tls.Config{
 		MinVersion:         tls.VersionTLS12,
-		NextProtos:         []string{"h2", "http/1.1", acme.ALPNProto},
+		NextProtos:         protos,
 		GetCertificate:     e.getCertificate,
 		GetConfigForClient: e.getConfigForClient,
 	}
 }
 
+// Stops advertising h2, call before any listener takes the config
+func (e *Engine) DisableHTTP2() { e.noHTTP2 = true }
+
 // Returns a per connection config only when the host enforces mtls
 func (e *Engine) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
 	cfg, err := e.configForClient(hello)
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 
+	storage "github.com/nickheyer/distroface/internal/db"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 )
 
@@ -15,6 +16,7 @@ type ClientIdentity struct {
 	CommonName string
 	DNSNames   []string
 	Issuer     string
+	Leaf       *x509.Certificate // Checked against the stored cas for sign-in
 }
 
 type clientIDKey struct{}
@@ -30,6 +32,55 @@ func ClientIdentityFrom(ctx context.Context) *ClientIdentity {
 	return id
 }
 
+// Names a verified client certificate can sign in as, common name then
+// dns sans, and the org whose signing ca issued it, empty for the instance
+// root. Any verified chain gets through the handshake, so only leaves the
+// root or an org ca signed directly name anyone, acme and other issuers
+// sign in nobody. Nil without a verified certificate
+func (e *Engine) ClientNames(ctx context.Context) ([]string, string) {
+	id := ClientIdentityFrom(ctx)
+	if id == nil || id.Leaf == nil {
+		return nil, ""
+	}
+	orgID, ok := e.leafIssuer(ctx, id.Leaf)
+	if !ok {
+		return nil, ""
+	}
+	var names []string
+	if id.CommonName != "" {
+		names = append(names, id.CommonName)
+	}
+	return append(names, id.DNSNames...), orgID
+}
+
+// Org whose signing ca signed leaf, empty for the instance root, false
+// when neither did
+func (e *Engine) leafIssuer(ctx context.Context, leaf *x509.Certificate) (string, bool) {
+	signedBy := func(row *storage.TLSCertificate) bool {
+		chain, err := parseChain([]byte(row.CertPEM))
+		return err == nil && leaf.CheckSignatureFrom(chain[0]) == nil
+	}
+	root, err := e.store.GetTLSCertificate(ctx, v1.TLSScope_TLS_SCOPE_APP_CA, "", "")
+	if err != nil {
+		e.log.Error("mtls: loading the instance root: %v", err)
+		return "", false
+	}
+	if root != nil && signedBy(root) {
+		return "", true
+	}
+	orgCAs, err := e.store.ListOrgCACertificates(ctx)
+	if err != nil {
+		e.log.Error("mtls: loading org cas: %v", err)
+		return "", false
+	}
+	for _, row := range orgCAs {
+		if signedBy(row) {
+			return row.OrgID, true
+		}
+	}
+	return "", false
+}
+
 // Resolves the mtls mode for the target, portal scope overrides system
 func (e *Engine) mtlsModeForHost(ctx context.Context, portal *TLSPortal) v1.MTLSMode {
 	if portal != nil {
@@ -124,5 +175,6 @@ func VerifiedIdentity(state *tls.ConnectionState) *ClientIdentity {
 		CommonName: leaf.Subject.CommonName,
 		DNSNames:   leaf.DNSNames,
 		Issuer:     leaf.Issuer.CommonName,
+		Leaf:       leaf,
 	}
 }
@@ -2,9 +2,15 @@ package certs
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"testing"
 
+	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"google.golang.org/protobuf/proto"
@@ -146,3 +152,108 @@ func TestVerifiedIdentity(t *testing.T) {
 	}
 	_ = proto.String // keep import parity with sibling tests
 }
+
+// An org admin picks the names on their org ca's leaves, so those leaves
+// never authenticate as clients and never sign in anyone outside the org
+func TestOrgCACertCannotSignInAsAdmin(t *testing.T) {
+	store := newTestStore(t)
+	res := newTestResolver(t, store)
+	e := newTestEngine(t, store, res, "", "")
+	ctx := context.Background()
+	seedSystem(t, res, &v1.Settings{Auth: &v1.AuthSettings{ClientCert: &v1.ClientCertAuthSettings{
+		Enabled: proto.Bool(true), MatchUsernames: proto.Bool(true),
+	}}}, "auth.client_cert.enabled", "auth.client_cert.match_usernames")
+	seedAppRoot(t, store)
+	registerApproved(t, store, "admin")
+	registerApproved(t, store, "alice")
+
+	for _, name := range []string{"admin", "alice"} {
+		if err := store.CreateUser(ctx, &storage.User{ID: "u-" + name, Username: name, AuthProvider: "local", IsActive: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CreateOrganization(ctx, &storage.Organization{ID: "o1", Name: "acme", CreatedBy: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddOrgMember(ctx, &storage.OrgMember{ID: "m1", OrgID: "o1", UserID: "u-alice", Role: storage.OrgRoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := store.GetTLSCertificate(ctx, v1.TLSScope_TLS_SCOPE_APP_CA, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	icaPEM, icaKeyPEM, err := IssueICA(root.CertPEM, root.KeyPEM, "acme ica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveTLSCertificate(ctx, &storage.TLSCertificate{
+		Scope: v1.TLSScope_TLS_SCOPE_ORG_CA, OrgID: "o1", CertPEM: icaPEM, KeyPEM: icaKeyPEM,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := auth.NewManager(store, nil, "0123456789abcdef0123456789abcdef", res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetClientNames(e.ClientNames)
+	signIn := func(leaf *x509.Certificate) (*auth.AuthenticatedUser, error) {
+		return m.ClientCertUser(WithClientIdentity(ctx, &ClientIdentity{CommonName: leaf.Subject.CommonName, Leaf: leaf}))
+	}
+
+	// What SignCSR hands an org admin cannot get through the handshake
+	signed, err := e.SignServerCert(ctx, "o1", &key.PublicKey, []string{"admin"}, 30)
+	if err != nil {
+		t.Fatalf("SignServerCert: %v", err)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(icaPEM))
+	if _, err := signed.Leaf.Verify(x509.VerifyOptions{
+		Roots: appRootPool(t, store), Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err == nil {
+		t.Fatal("org ca leaf verified for client auth")
+	}
+
+	// Nor does one minted straight off the org ca key, past the members
+	ca, err := e.caMaterial(ctx, v1.TLSScope_TLS_SCOPE_ORG_CA, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := ca.signLeaf(&key.PublicKey, "admin", []string{"admin"}, nil, 30, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, org := e.ClientNames(WithClientIdentity(ctx, &ClientIdentity{CommonName: "admin", Leaf: forged.Leaf})); org != "o1" || len(names) == 0 {
+		t.Fatalf("forged leaf bound to %q as %v, want org o1", org, names)
+	}
+	if u, err := signIn(forged.Leaf); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("org ca cert for admin signed in %v, %v", u, err)
+	}
+	member, err := ca.signLeaf(&key.PublicKey, "alice", nil, nil, 30, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := signIn(member.Leaf); err != nil || u == nil || u.Username != "alice" {
+		t.Fatalf("org ca cert for a member = %v, %v", u, err)
+	}
+
+	// Acme leaves name nobody, the instance root names anyone
+	acme, err := e.SignACMELeaf(ctx, &key.PublicKey, []string{"admin"}, 30)
+	if err != nil {
+		t.Fatalf("SignACMELeaf: %v", err)
+	}
+	if u, err := signIn(acme.Leaf); u != nil || err != nil {
+		t.Fatalf("acme leaf signed in %v, %v", u, err)
+	}
+	rootSigned, err := e.SignServerCert(ctx, "", &key.PublicKey, []string{"admin"}, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := signIn(rootSigned.Leaf); err != nil || u == nil || u.Username != "admin" {
+		t.Fatalf("root signed cert = %v, %v", u, err)
+	}
+}
@@ -53,8 +53,9 @@ func (e *Engine) caMaterial(ctx context.Context, scope v1.TLSScope, orgID string
 	return &caMaterial{cert: caCert, key: pair.PrivateKey, chain: pair.Certificate[1:]}, nil
 }
 
-// Signs a public key into a server leaf for the given sans
-func (ca *caMaterial) signLeaf(pub any, commonName string, dnsNames []string, ips []net.IP, days int) (*SignedLeaf, error) {
+// Signs a public key into a server leaf for the given sans, clientAuth
+// also lets it authenticate as a client
+func (ca *caMaterial) signLeaf(pub any, commonName string, dnsNames []string, ips []net.IP, days int, clientAuth bool) (*SignedLeaf, error) {
 	if days <= 0 {
 		days = defaultLeafDays
 	}
@@ -68,10 +69,12 @@ func (ca *caMaterial) signLeaf(pub any, commonName string, dnsNames []string, ip
 		NotBefore:    time.Now().Add(-time.Hour),
 		NotAfter:     time.Now().Add(time.Duration(days) * 24 * time.Hour),
 		KeyUsage:     x509.KeyUsageDigitalSignature,
-		// Both usages so one leaf serves tls and authenticates as a client
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		DNSNames:    dnsNames,
-		IPAddresses: ips,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	if clientAuth {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
 	}
 	if tmpl.NotAfter.After(ca.cert.NotAfter) {
 		tmpl.NotAfter = ca.cert.NotAfter
@@ -182,7 +185,9 @@ func (e *Engine) checkSANs(ctx context.Context, sans []string) error {
 }
 
 // SignServerCert validates the sans against policy and signs from a ca,
-// empty orgID uses the app ca, populated uses that org's signing ca
+// empty orgID uses the app ca, populated uses that org's signing ca. Only
+// app ca leaves authenticate as clients, client certificate sign-in maps
+// their names to accounts and org admins pick the names on their own
 func (e *Engine) SignServerCert(ctx context.Context, orgID string, pub any, sans []string, days int) (*SignedLeaf, error) {
 	if err := e.checkSANs(ctx, sans); err != nil {
 		return nil, err
@@ -196,7 +201,7 @@ func (e *Engine) SignServerCert(ctx context.Context, orgID string, pub any, sans
 		return nil, err
 	}
 	dns, ips := splitSANs(sans)
-	return ca.signLeaf(pub, sans[0], dns, ips, days)
+	return ca.signLeaf(pub, sans[0], dns, ips, days, orgID == "")
 }
 
 // SignACMELeaf signs an issued acme certificate from the built in
@@ -210,7 +215,7 @@ func (e *Engine) SignACMELeaf(ctx context.Context, pub any, sans []string, days
 		return nil, err
 	}
 	dns, ips := splitSANs(sans)
-	return ca.signLeaf(pub, sans[0], dns, ips, days)
+	return ca.signLeaf(pub, sans[0], dns, ips, days, false)
 }
 
 // Loads the acme issuing ca, minting it whenever it is missing or no
@@ -3,23 +3,110 @@ package container
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
 	"github.com/nickheyer/distroface/internal/auth"
 	"github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/config"
 	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/utils"
 )
 
 // Sentinel creator for orgs seeded without an owner
 const createdByBootstrap = "bootstrap"
 
-// Creates configured users then orgs never touching existing rows
-func Run(ctx context.Context, cfg config.BootstrapConfig, store *stores.Store, authManager *auth.Manager, log *logger.Logger) error {
+// Applies settings, then roles, users, orgs and artifact repos. Missing
+// rows are created, declared grants reapplied, nothing is ever deleted
+func Run(ctx context.Context, cfg config.BootstrapConfig, store *stores.Store, authManager *auth.Manager, enforcer *rbac.Enforcer, res *settings.Resolver, log *logger.Logger) error {
+	if err := res.ApplySystem(ctx, cfg.Settings); err != nil {
+		return fmt.Errorf("bootstrap settings: %w", err)
+	}
+	if err := seedRoles(ctx, cfg.Roles, store, enforcer, log); err != nil {
+		return err
+	}
 	if err := seedUsers(ctx, cfg.Users, store, authManager, log); err != nil {
 		return err
 	}
-	return seedOrgs(ctx, cfg.Orgs, store, log)
+	if err := seedOrgs(ctx, cfg.Orgs, store, log); err != nil {
+		return err
+	}
+	return seedArtifactRepos(ctx, cfg.ArtifactRepos, store, res, log)
+}
+
+func seedRoles(ctx context.Context, roles []config.BootstrapRole, store *stores.Store, enforcer *rbac.Enforcer, log *logger.Logger) error {
+	for _, r := range roles {
+		if r.Name == "" {
+			return fmt.Errorf("bootstrap role requires name")
+		}
+		perms := make([]rbac.Permission, 0, len(r.Permissions))
+		for _, p := range r.Permissions {
+			if !validPermission(p.Resource, p.Action) {
+				return fmt.Errorf("bootstrap role %q: unknown permission %s/%s", r.Name, p.Resource, p.Action)
+			}
+			perms = append(perms, rbac.Permission{Resource: p.Resource, Action: p.Action, ObjectID: p.Object})
+		}
+
+		role, err := store.GetRoleByName(ctx, r.Name)
+		if err != nil {
+			return fmt.Errorf("bootstrap role %q: %w", r.Name, err)
+		}
+		if role == nil {
+			role = &db.Role{Name: r.Name, Description: r.Description, IsDefault: r.Default}
+			if err := store.CreateRole(ctx, role); err != nil {
+				return fmt.Errorf("bootstrap role %q: %w", r.Name, err)
+			}
+			log.Info("Bootstrap created role %q", r.Name)
+		} else if len(perms) > 0 && role.IsSystem {
+			return fmt.Errorf("bootstrap role %q: system role permissions cannot be changed", r.Name)
+		}
+
+		if len(perms) == 0 || samePermissions(enforcer.GetPermissionsForRole(r.Name), perms) {
+			continue
+		}
+		if err := enforcer.SetPermissionsForRole(r.Name, perms); err != nil {
+			return fmt.Errorf("bootstrap role %q: %w", r.Name, err)
+		}
+		log.Info("Bootstrap set %d permissions on role %q", len(perms), r.Name)
+	}
+	return nil
+}
+
+// Resource and action pair the rbac model knows, * stands for any
+func validPermission(resource, action string) bool {
+	if resource == "*" {
+		return action != ""
+	}
+	for _, e := range rbac.ResourceActions {
+		if e.Resource == resource {
+			return action == "*" || slices.Contains(e.Actions, action)
+		}
+	}
+	return false
+}
+
+func samePermissions(current, want []rbac.Permission) bool {
+	key := func(p rbac.Permission) string {
+		obj := p.ObjectID
+		if obj == "" {
+			obj = "*"
+		}
+		return p.Resource + "\x00" + p.Action + "\x00" + obj
+	}
+	have := map[string]bool{}
+	for _, p := range current {
+		have[key(p)] = true
+	}
+	wanted := map[string]bool{}
+	for _, p := range want {
+		wanted[key(p)] = true
+	}
+	return maps.Equal(have, wanted)
 }
 
 func seedUsers(ctx context.Context, users []config.BootstrapUser, store *stores.Store, authManager *auth.Manager, log *logger.Logger) error {
@@ -33,6 +120,9 @@ func seedUsers(ctx context.Context, users []config.BootstrapUser, store *stores.
 			return fmt.Errorf("bootstrap user %q: %w", u.Username, err)
 		}
 		if existing != nil {
+			if err := grantMissingRoles(ctx, store, existing.ID, u.Username, u.Roles, log); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -62,6 +152,27 @@ func seedUsers(ctx context.Context, users []config.BootstrapUser, store *stores.
 	return nil
 }
 
+// Roles declared since the user was created, none are taken away
+func grantMissingRoles(ctx context.Context, store *stores.Store, userID, username string, roles []string, log *logger.Logger) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	held, err := store.GetUserRoleNames(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("bootstrap user %q: %w", username, err)
+	}
+	for _, role := range roles {
+		if slices.Contains(held, role) {
+			continue
+		}
+		if err := store.AssignRole(ctx, userID, role, "local"); err != nil {
+			return fmt.Errorf("bootstrap user %q role %q: %w", username, role, err)
+		}
+		log.Info("Bootstrap granted role %q to %q", role, username)
+	}
+	return nil
+}
+
 func seedOrgs(ctx context.Context, orgs []config.BootstrapOrg, store *stores.Store, log *logger.Logger) error {
 	for _, o := range orgs {
 		if o.Name == "" {
@@ -168,3 +279,68 @@ func resolveCreator(ctx context.Context, members []config.BootstrapOrgMember, st
 	}
 	return createdByBootstrap
 }
+
+// Repo types bootstrap may create, mirrors need their upstream checked live
+var bootstrapRepoTypes = map[string]v1.ArtifactRepoType{
+	"":       v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_FILE,
+	"file":   v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_FILE,
+	"debian": v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN,
+	"rpm":    v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_RPM,
+}
+
+func seedArtifactRepos(ctx context.Context, repos []config.BootstrapArtifactRepo, store *stores.Store, res *settings.Resolver, log *logger.Logger) error {
+	for _, r := range repos {
+		if r.Namespace == "" || r.Name == "" {
+			return fmt.Errorf("bootstrap artifact repo requires namespace and name")
+		}
+		full := r.Namespace + "/" + r.Name
+		repoType, ok := bootstrapRepoTypes[strings.ToLower(r.Type)]
+		if !ok {
+			return fmt.Errorf("bootstrap artifact repo %q: type must be file, debian or rpm", full)
+		}
+		name, err := utils.NormalizeRepoName(utils.ArtifactRepo, r.Name)
+		if err != nil {
+			return fmt.Errorf("bootstrap artifact repo %q: %w", full, err)
+		}
+
+		existing, err := store.GetArtifactRepository(ctx, r.Namespace, name)
+		if err != nil {
+			return fmt.Errorf("bootstrap artifact repo %q: %w", full, err)
+		}
+		if existing != nil {
+			continue
+		}
+
+		// Owned by the namespace user, or the org's creator
+		var ownerID string
+		private := res.System(ctx).GetArtifacts().GetPrivateByDefault()
+		if user, err := store.GetUserByUsername(ctx, r.Namespace); err == nil && user != nil {
+			ownerID = user.ID
+		} else if org, err := store.GetOrganization(ctx, r.Namespace); err == nil && org != nil {
+			if org.CreatedBy != createdByBootstrap {
+				ownerID = org.CreatedBy
+			}
+			private = res.Org(ctx, org.ID).GetArtifacts().GetPrivateByDefault()
+		} else {
+			// May appear later so retried on next startup
+			log.Error("Bootstrap artifact repo %q: namespace not found, skipping", full)
+			continue
+		}
+		if r.Private != nil {
+			private = *r.Private
+		}
+
+		if err := store.CreateArtifactRepository(ctx, &db.ArtifactRepository{
+			Namespace:   r.Namespace,
+			Name:        name,
+			Description: r.Description,
+			OwnerID:     ownerID,
+			IsPrivate:   private,
+			Type:        repoType,
+		}); err != nil {
+			return fmt.Errorf("bootstrap artifact repo %q: %w", full, err)
+		}
+		log.Info("Bootstrap created artifact repo %q", full)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package container
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -14,23 +15,34 @@ import (
 
 	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/alerts"
 	"github.com/nickheyer/distroface/internal/artifacts"
 	"github.com/nickheyer/distroface/internal/audit"
 	"github.com/nickheyer/distroface/internal/auth"
 	"github.com/nickheyer/distroface/internal/certs"
 	"github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/jobs"
+	"github.com/nickheyer/distroface/internal/journal"
 	"github.com/nickheyer/distroface/internal/mirror"
+	"github.com/nickheyer/distroface/internal/notify"
+	"github.com/nickheyer/distroface/internal/notify/channel"
+	"github.com/nickheyer/distroface/internal/policy"
 	"github.com/nickheyer/distroface/internal/portal"
 	"github.com/nickheyer/distroface/internal/rbac"
 	"github.com/nickheyer/distroface/internal/registry"
+	"github.com/nickheyer/distroface/internal/registry/packed"
 	"github.com/nickheyer/distroface/internal/rpc"
+	"github.com/nickheyer/distroface/internal/scan"
 	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/signing"
+	"github.com/nickheyer/distroface/internal/vault"
 	"github.com/nickheyer/distroface/internal/webhook"
 	"github.com/nickheyer/distroface/pkg/config"
 	"github.com/nickheyer/distroface/pkg/logger"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
 )
 
 // App holds all initialized dependencies and the HTTP server.
@@ -45,13 +57,30 @@ type App struct {
 	RegistryAccess *registry.RegistryAccess
 	PortalProxies  *portal.Manager
 	CertEngine     *certs.Engine
+	AlertSignals   *alerts.Signals
 	Server         *http.Server
+
+	stop context.CancelFunc // Ends the schedulers and job workers New started
+	jobs *jobs.Queue
 }
 
+// Background job workers, scans hold one each for the length of a file read
+const jobWorkers = 2
+
+// How long requests in flight and running jobs get to finish on shutdown
+const shutdownTimeout = 30 * time.Second
+
 // New builds the entire application: config, logger, store, settings
 // resolver, RBAC enforcer, auth manager, registry handler, and HTTP server.
 func New() (*App, error) {
-	ctx := context.Background()
+	// Every scheduler and worker runs under ctx until shutdown cancels it
+	ctx, stop := context.WithCancel(context.Background())
+	started := false
+	defer func() {
+		if !started {
+			stop()
+		}
+	}()
 
 	cfg, err := config.Load(".")
 	if err != nil {
@@ -78,9 +107,10 @@ func New() (*App, error) {
 	}
 
 	store, err := stores.NewSQLiteStore(cfg.Database.Path, stores.DBConfig{
-		MaxOpenConns:    cfg.Database.MaxConnections,
-		MaxIdleConns:    cfg.Database.MaxIdleConns,
-		ConnMaxLifetime: time.Duration(cfg.Database.ConnMaxLifetime) * time.Second,
+		MaxOpenConns:     cfg.Database.MaxConnections,
+		MaxIdleConns:     cfg.Database.MaxIdleConns,
+		ConnMaxLifetime:  time.Duration(cfg.Database.ConnMaxLifetime) * time.Second,
+		ManualMigrations: !cfg.Database.AutoMigrate,
 	})
 	if err != nil {
 		log.Close()
@@ -117,25 +147,28 @@ func New() (*App, error) {
 	if err != nil {
 		return fail("initializing auth manager", err)
 	}
-	if !authManager.IsAnyAuthEnabled() {
-		log.Warn("SECURITY: no auth provider is enabled. Every request runs as admin, do not expose this instance")
-	}
 
 	if err := admin.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
 		return fail("configuring trusted proxies", err)
 	}
 
-	if err := Run(ctx, cfg.Bootstrap, store, authManager, log); err != nil {
+	if err := Run(ctx, cfg.Bootstrap, store, authManager, enforcer, resolver, log); err != nil {
 		return fail("bootstrap seeding", err)
 	}
 
 	// ECDSA keys for registry JWTs, separate from HS256 sessions
-	tokenService, err := auth.NewTokenService(cfg.Storage.DataDir, "distroface", "distroface-registry", resolver)
+	tokenService, err := auth.NewTokenService(cfg.Storage.DataDir, "distroface", append([]string{auth.RegistryService}, cfg.Registry.TokenAudiences...), resolver)
 	if err != nil {
 		return fail("initializing token service", err)
 	}
 	log.Info("Token service initialized (cert: %s)", tokenService.CertPath())
 
+	// Upstream secrets sealed with a key that never enters the database
+	credentialVault, err := vault.Open(cfg.Storage.DataDir, store)
+	if err != nil {
+		return fail("opening credential vault", err)
+	}
+
 	registryLog := log.Module("distroface-registry")
 	logrus.SetOutput(registryLog)
 	logrus.SetLevel(logrus.InfoLevel)
@@ -143,28 +176,88 @@ func New() (*App, error) {
 		DisableColors:    true,
 		DisableTimestamp: true,
 	})
+	authLog, artifactLog, migrationLog := log.Scoped("auth"), log.Scoped("artifacts"), log.Scoped("migration")
+	subscribeLogLevels(resolver, log, map[string]*logger.Logger{
+		"auth":      authLog,
+		"registry":  registryLog,
+		"artifacts": artifactLog,
+		"migration": migrationLog,
+	})
 
 	if err := os.MkdirAll(cfg.Registry.StoragePath, 0755); err != nil {
 		return fail("creating registry storage directory", err)
 	}
 
+	// Link files live in one place, a half migrated tree would hide tags
+	packedLinks, err := packedLinkStore(ctx, cfg, store)
+	if err != nil {
+		return fail("checking registry link storage", err)
+	}
+
 	dispatcher := webhook.NewDispatcher(store, registryLog, resolver)
 
+	// Self gates on the notification settings, watches see every repo event
+	notifier := notify.NewNotifier(store, resolver, enforcer, dispatcher.Notify, log)
+	dispatcher.Observe(notifier.Observe)
+	notifier.Schedule(ctx)
+
+	// Slack, teams, mail and webhook channels from the notification settings
+	notificationHub := channel.NewHub(resolver, webhook.NewSafeClient(resolver), log)
+	dispatcher.Observe(notificationHub.ObserveRepository)
+
 	// Recorder self gates on the live audit setting
 	auditRecorder := audit.NewRecorder(store, resolver, log)
 	auditRecorder.ScheduleRetention(ctx)
 	auditService := audit.NewService(store, log)
 
-	registry.RegisterListenerMiddleware(store, registryLog, dispatcher, auditRecorder)
+	registryAccess, err := registry.NewRegistryAccess(cfg.Registry.StoragePath, packedLinks)
+	if err != nil {
+		return fail("initializing registry access", err)
+	}
+	registryAccess.UseLinkIndex(store)
+	go rebuildLinkIndex(ctx, registryAccess, registryLog)
 
-	registryCfg := registry.BuildConfig(cfg.Registry.StoragePath, tokenService.CertPath(), cfg.Server.Host, cfg.Server.Port)
-	registryApp := handlers.NewApp(ctx, registryCfg)
-	registryLog.Info("Distribution v3 initialized")
+	// Self gates on the signing settings of each namespace
+	imageSigner := signing.NewSigner(store, credentialVault, resolver, registryAccess, registryLog)
+
+	// Self gates on the push policy settings, pushes pass while it is off
+	pushPolicy := policy.NewHook(resolver, registryLog)
+
+	registry.RegisterListenerMiddleware(store, registryLog, dispatcher, auditRecorder, imageSigner, pushPolicy)
+	// Freeze windows hold pushes and untags of matching repos, admins push through
+	freezes := policy.NewFreezes(store, enforcer, registryLog)
+	registry.RegisterFreezes(freezes)
+	// Image pushes count against the same repo quota as artifact uploads
+	registry.RegisterQuota(resolver)
+	// Group rules for who may publish repos or flip their visibility
+	visibility := policy.NewVisibility(store, resolver)
+	registry.RegisterVisibility(visibility)
+
+	// Counters behind the rate based alert rules
+	alertSignals := alerts.NewSignals()
+	registry.RegisterAlertSignals(alertSignals)
+
+	// Tags in link directories would share one on storage that folds case
+	if caseInsensitiveStorage(cfg, registryLog) {
+		registry.RegisterCaseInsensitive()
+		registryLog.Info("Registry storage is case insensitive, tags differing only by case are refused")
+	}
 
-	registryAccess, err := registry.NewRegistryAccess(cfg.Registry.StoragePath)
+	// Intent log for pushes and deletes, replayed once every owner registered
+	opJournal, err := journal.Open(cfg.Storage.DataDir, log)
 	if err != nil {
-		return fail("initializing registry access", err)
+		return fail("opening operation journal", err)
 	}
+	registry.RegisterJournal(opJournal, store, registryAccess, registryLog)
+
+	// Breakers fed by driver calls and probes, open ones fail pushes fast
+	registryHealth := admin.NewStorageHealth("registry", nil, registryLog)
+	registry.RegisterStorageHealth(registryHealth)
+
+	registryCfg := registry.BuildConfig(cfg.Registry.StoragePath, packedLinks, tokenService.CertPath(), tokenService.Audiences(), cfg.Server.Host, cfg.Server.Port)
+	registryApp := handlers.NewApp(ctx, registryCfg)
+	registryLog.Info("Distribution v3 initialized")
+	registryHealth.Schedule(ctx)
 
 	portalResolver := portal.NewResolver(store, resolver, registryLog)
 
@@ -179,6 +272,7 @@ func New() (*App, error) {
 		rl := rateLimits()
 		return int(rl.GetAuthFailureLimit()), time.Duration(rl.GetAuthFailureWindowSeconds()) * time.Second
 	})
+	authLimiter.OnRecord(alertSignals.LoginFailed)
 	pullLimiter := admin.NewDynamicLimiter(func() (int, time.Duration) {
 		return int(rateLimits().GetPullPerMinute()), time.Minute
 	})
@@ -186,15 +280,61 @@ func New() (*App, error) {
 		return int(rateLimits().GetAnonPullPerMinute()), time.Minute
 	})
 
-	tokenHandler := auth.NewTokenHandler(tokenService, store, authManager, enforcer, portalResolver, authLimiter, auditRecorder, registryLog)
-	registryHandler := registry.PullRateLimit(registryApp, tokenService, pullLimiter, anonPullLimiter, registryLog)
-
 	blobStore, err := artifacts.NewBlobStore(cfg.Artifacts.StoragePath)
 	if err != nil {
 		return fail("initializing artifact storage", err)
 	}
-	artifactManager := artifacts.NewManager(store, blobStore, resolver, log)
-	artifactV1Facade := artifacts.NewV1API(store, artifactManager, authManager, enforcer, authLimiter, auditRecorder, log)
+	if cfg.Artifacts.ColdStoragePath != "" {
+		if err := blobStore.SetColdTier(cfg.Artifacts.ColdStoragePath); err != nil {
+			return fail("initializing artifact cold storage", err)
+		}
+	}
+	artifactManager := artifacts.NewManager(store, blobStore, resolver, artifactLog)
+	artifactManager.SetPushPolicy(pushPolicy)
+	artifactManager.SetJournal(opJournal)
+	artifactManager.SetVisibility(visibility)
+	artifactManager.SetAlertSignals(alertSignals)
+	// Self gates on the scan settings, uploads land unscanned while it is off
+	artifactManager.SetScanner(scan.NewScanner(resolver, artifactLog))
+	artifactHealth := admin.NewStorageHealth("artifacts", blobStore.Probe, artifactLog)
+	artifactManager.SetStorageHealth(artifactHealth)
+	artifactHealth.Schedule(ctx)
+
+	// Before any request can touch what an interrupted operation left
+	if replayed, failed, err := opJournal.Replay(ctx); err != nil {
+		return fail("replaying operation journal", err)
+	} else if replayed+failed > 0 {
+		log.Info("Operation journal: %d interrupted operations resolved, %d kept for the next start", replayed, failed)
+	}
+
+	// Exported artifact repos answer under /v2 ahead of the registry
+	ociBridge := artifacts.NewOCIBridge(store, artifactManager, enforcer, tokenService, artifactLog)
+	tokenHandler := auth.NewTokenHandler(tokenService, store, authManager, enforcer, portalResolver, authLimiter, auditRecorder, authLog)
+	tokenHandler.SetBridge(ociBridge)
+	uploadCoalescer := registry.CoalesceUploads(tokenHandler.ScopedCatalog(registryApp), tokenService, registryLog)
+	referrers := signing.NewReferrers(imageSigner, tokenService)
+	// Large manifest and blob deletes wait for a second admin like the rpc deletes
+	deleteGate := registry.GateDeletes(uploadCoalescer, policy.NewApprovals(store, resolver, enforcer), registryAccess, tokenService, registryLog)
+	pullGate := registry.RestrictPulls(registry.NegotiateManifests(referrers.Wrap(ociBridge.Wrap(registry.GuardUploads(deleteGate, registryHealth)))), store, tokenService, auditRecorder, registryLog)
+	registryHandler := registry.AliasBareNames(registry.PullRateLimit(pullGate, tokenService, pullLimiter, anonPullLimiter, registryLog), store, tokenService, registryLog)
+	// Outermost, every wrapper and push policy sees the token's user
+	registryHandler = tokenService.WithIdentity(registryHandler, authManager)
+	artifactV1Facade := artifacts.NewV1API(store, artifactManager, authManager, enforcer, authLimiter, auditRecorder, artifactLog)
+	packageRepos := artifacts.NewPackageRepos(store, artifactManager, authManager, enforcer, imageSigner, artifactLog.Scoped("packages"))
+
+	// Scans, retention and index builds run here instead of in the upload
+	jobQueue := jobs.New(store, log.Scoped("jobs"), jobWorkers)
+	artifactManager.SetJobs(jobQueue)
+	packageRepos.SetJobs(jobQueue)
+
+	// Pushes go straight into the embedded registry handler
+	ociSyncer := mirror.NewOCISyncer(registryApp, tokenService)
+	mirrorMonitor := mirror.NewMonitor(store, resolver, artifactManager, ociSyncer, credentialVault, migrationLog)
+
+	// Tagged pushes matching a replication rule are copied on by the queue
+	replicator := mirror.NewReplicator(store, mirrorMonitor, migrationLog)
+	replicator.SetJobs(jobQueue)
+	registry.RegisterReplication(replicator)
 
 	// Portal listeners serve the whole app on their own ports
 	portalProxies := portal.NewManager(portalResolver, cfg.Server.Host, registryLog)
@@ -207,6 +347,24 @@ func New() (*App, error) {
 	if err != nil {
 		return fail("initializing tls engine", err)
 	}
+	// Certificate sign-in needs the stored cas to tell who issued a leaf
+	authManager.SetClientNames(certEngine.ClientNames)
+	if !authManager.IsAnyAuthEnabled() {
+		log.Warn("SECURITY: no auth provider is enabled. Every request runs as admin, do not expose this instance")
+	}
+
+	// One stream budget shared by tls h2 and cleartext h2c
+	h2Server := &http2.Server{
+		MaxConcurrentStreams: cfg.Server.HTTP2.MaxConcurrentStreams,
+		IdleTimeout:          time.Duration(cfg.Server.IdleTimeout) * time.Second,
+	}
+	var h2cServer *http2.Server
+	if cfg.Server.HTTP2.Enabled && cfg.Server.HTTP2.H2C != config.H2COff {
+		h2cServer = h2Server
+	}
+	if !cfg.Server.HTTP2.Enabled {
+		certEngine.DisableHTTP2()
+	}
 	resolver.Subscribe(func() { certEngine.Invalidate(context.Background()) })
 	portalResolver.SetCertReady(func(ctx context.Context, p *portal.Portal, host string) bool {
 		return certEngine.PortalCertReady(ctx, p.CertSource, p.OrgID, p.ID, host)
@@ -226,12 +384,13 @@ func New() (*App, error) {
 	certService := certs.NewService(store, enforcer, certEngine, resolver, log)
 	acmeServer := certs.NewACMEServer(certEngine)
 
-	oidcHandler := auth.NewOIDCHandler(authManager, store, resolver, portalResolver, log)
+	oidcHandler := auth.NewOIDCHandler(authManager, store, resolver, portalResolver, authLog)
 
-	gcCollector, err := admin.NewCollector(cfg.Registry.StoragePath, registryLog)
+	gcCollector, err := admin.NewCollector(cfg.Registry.StoragePath, packed.New(cfg.Registry.StoragePath, packedLinks), registryLog)
 	if err != nil {
 		return fail("initializing garbage collector", err)
 	}
+	gcCollector.AfterRun(func() { rebuildLinkIndex(ctx, registryAccess, registryLog) })
 	gcCollector.Schedule(ctx, resolver)
 
 	if removed, err := blobStore.CleanStaleUploads(artifactManager.StaleUploadAge(ctx)); err != nil {
@@ -239,19 +398,85 @@ func New() (*App, error) {
 	} else if removed > 0 {
 		log.Info("Cleaned %d stale artifact upload sessions", removed)
 	}
+	if err := jobQueue.Start(ctx); err != nil {
+		return fail("starting job queue", err)
+	}
+	if queued, err := artifactManager.ResumeScans(ctx); err != nil {
+		log.Error("resuming artifact scans: %v", err)
+	} else if queued > 0 {
+		log.Info("Resumed %d pending artifact scans", queued)
+	}
 
-	artifactReaper := artifacts.NewReaper(artifactManager, store, log)
+	artifactReaper := artifacts.NewReaper(artifactManager, store, artifactLog)
 	artifactReaper.Schedule(ctx)
+	registry.NewTagExpirer(store, registryAccess, registryLog).Schedule(ctx)
 
-	// Pushes go straight into the embedded registry handler
-	ociSyncer := mirror.NewOCISyncer(registryApp, tokenService)
-	mirrorMonitor := mirror.NewMonitor(store, resolver, artifactManager, ociSyncer, log)
 	mirrorMonitor.Schedule(ctx)
 
+	alertPaths := []string{cfg.Storage.DataDir, cfg.Registry.StoragePath, cfg.Artifacts.StoragePath}
+	if cfg.Artifacts.ColdStoragePath != "" {
+		alertPaths = append(alertPaths, cfg.Artifacts.ColdStoragePath)
+	}
+	alertMonitor := alerts.NewMonitor(resolver, alertSignals, dispatcher, alertPaths, log)
+	alertMonitor.UseChannels(notificationHub)
+	alertMonitor.Schedule(ctx)
+
 	if err := seedLegacyACMEDomains(ctx, cfg.LegacyACMEDomains, store, log); err != nil {
 		return fail("seeding legacy acme domains", err)
 	}
 
+	// In memory state that should drain, a steady climb is a leak
+	metrics := admin.NewMetrics()
+	metrics.Gauge("distroface_registry_upload_claims", "Blob upload claims held by the push coalescer",
+		func() float64 { return float64(uploadCoalescer.Claims()) })
+	metrics.Gauge("distroface_artifact_upload_sessions", "Artifact upload sessions open on disk", func() float64 {
+		n, _ := blobStore.PendingUploads()
+		return float64(n)
+	})
+	// Tier sizes are tallied by reaper sweeps, not per scrape
+	for tier, pick := range map[string]func(artifacts.TierUsage) (int64, int64){
+		"hot":  func(u artifacts.TierUsage) (int64, int64) { return u.HotBlobs, u.HotBytes },
+		"cold": func(u artifacts.TierUsage) (int64, int64) { return u.ColdBlobs, u.ColdBytes },
+	} {
+		metrics.Gauge("distroface_artifact_tier_blobs", "Artifact blobs per storage tier as of the last sweep", func() float64 {
+			n, _ := pick(blobStore.Usage())
+			return float64(n)
+		}, "tier", tier)
+		metrics.Gauge("distroface_artifact_tier_bytes", "Artifact blob bytes per storage tier as of the last sweep", func() float64 {
+			_, n := pick(blobStore.Usage())
+			return float64(n)
+		}, "tier", tier)
+	}
+	metrics.StorageHealth(registryHealth)
+	metrics.StorageHealth(artifactHealth)
+	for name, l := range map[string]*admin.Limiter{"auth": authLimiter, "pull": pullLimiter, "anon_pull": anonPullLimiter} {
+		metrics.Gauge("distroface_ratelimit_tracked_keys", "Clients with events in a rate limit window",
+			func() float64 { return float64(l.Keys()) }, "limiter", name)
+	}
+	metrics.Gauge("distroface_mirror_syncs_inflight", "Mirror syncs queued or running",
+		func() float64 { return float64(mirrorMonitor.Inflight()) })
+	metrics.Gauge("distroface_mirror_event_subscribers", "Clients watching mirror sync events",
+		func() float64 { return float64(mirrorMonitor.Subscribers()) })
+	metrics.Gauge("distroface_artifact_reaper_running", "One while a retention sweep is in progress", func() float64 {
+		if artifactReaper.Running() {
+			return 1
+		}
+		return 0
+	})
+	acmeHelp := "Pending state held by the built in acme server"
+	metrics.Gauge("distroface_acme_state_entries", acmeHelp, func() float64 {
+		n, _, _ := acmeServer.StateSizes()
+		return float64(n)
+	}, "kind", "nonces")
+	metrics.Gauge("distroface_acme_state_entries", acmeHelp, func() float64 {
+		_, n, _ := acmeServer.StateSizes()
+		return float64(n)
+	}, "kind", "orders")
+	metrics.Gauge("distroface_acme_state_entries", acmeHelp, func() float64 {
+		_, _, n := acmeServer.StateSizes()
+		return float64(n)
+	}, "kind", "authorizations")
+
 	rpcServer := rpc.NewServer(rpc.ServerDeps{
 		Store:               store,
 		Resolver:            resolver,
@@ -269,13 +494,30 @@ func New() (*App, error) {
 		CertEngine:          certEngine,
 		ACMEServer:          acmeServer,
 		AuthLimiter:         authLimiter,
+		PullLimiter:         pullLimiter,
 		ArtifactManager:     artifactManager,
 		ArtifactV1Facade:    artifactV1Facade,
+		PackageRepos:        packageRepos,
 		MirrorMonitor:       mirrorMonitor,
+		Replicator:          replicator,
+		Vault:               credentialVault,
+		Signer:              imageSigner,
 		GCCollector:         gcCollector,
+		AlertMonitor:        alertMonitor,
+		Journal:             opJournal,
+		Visibility:          visibility,
+		Freezes:             freezes,
+		Jobs:                jobQueue,
 		CertService:         certService,
 		AuditRecorder:       auditRecorder,
 		AuditService:        auditService,
+		Notifier:            notifier,
+		NotificationHub:     notificationHub,
+		Metrics:             metrics,
+		StorageHealth:       []*admin.StorageHealth{registryHealth, artifactHealth},
+		H2C:                 h2cServer,
+		H2CTrustedOnly:      cfg.Server.HTTP2.H2C == config.H2CTrusted,
+		Compression:         compressionOptions(cfg.Server.Compression),
 	})
 
 	// Portal listeners reuse the fully built app handler
@@ -288,10 +530,20 @@ func New() (*App, error) {
 		Addr:              fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port),
 		Handler:           rpcServer.Handler(),
 		ReadHeaderTimeout: time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout) * time.Second,
 		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+	}
+	if cfg.Server.HTTP2.Enabled {
+		if err := http2.ConfigureServer(srv, h2Server); err != nil {
+			return fail("configuring http/2", err)
+		}
+	} else {
+		// Non nil empty map keeps net/http from enabling h2 on its own
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 	}
 
-	return &App{
+	app := &App{
 		Config:         cfg,
 		Log:            log,
 		Store:          store,
@@ -302,8 +554,13 @@ func New() (*App, error) {
 		RegistryAccess: registryAccess,
 		PortalProxies:  portalProxies,
 		CertEngine:     certEngine,
+		AlertSignals:   alertSignals,
 		Server:         srv,
-	}, nil
+		stop:           stop,
+		jobs:           jobQueue,
+	}
+	started = true
+	return app, nil
 }
 
 // Reseeds the anonymous policy tier when the toggle flips
@@ -324,6 +581,56 @@ func subscribeAnonymousReseed(resolver *settings.Resolver, enforcer *rbac.Enforc
 	})
 }
 
+// Applies logging settings now and on every settings change. Bad stored
+// values fall back to info rather than silencing a module
+func subscribeLogLevels(resolver *settings.Resolver, root *logger.Logger, modules map[string]*logger.Logger) {
+	started := false
+	apply := func() {
+		cfg := resolver.System(context.Background()).GetLogging()
+		global, err := logger.ParseLevel(cfg.GetLevel())
+		if err != nil {
+			global = logger.LevelInfo
+		}
+		root.SetLevel(global)
+		overrides := map[string]string{
+			"auth":      cfg.GetAuth(),
+			"registry":  cfg.GetRegistry(),
+			"artifacts": cfg.GetArtifacts(),
+			"migration": cfg.GetMigration(),
+		}
+		for name, l := range modules {
+			level := global
+			if v := overrides[name]; v != "" {
+				if parsed, err := logger.ParseLevel(v); err == nil {
+					level = parsed
+				}
+			}
+			if started && l.GetLevel() != level {
+				root.Info("Log level for %s set to %s", name, level)
+			}
+			l.SetLevel(level)
+			if name == "registry" {
+				logrus.SetLevel(logrusLevel(level))
+			}
+		}
+		started = true
+	}
+	apply()
+	resolver.Subscribe(apply)
+}
+
+func logrusLevel(level logger.Level) logrus.Level {
+	switch level {
+	case logger.LevelDebug:
+		return logrus.DebugLevel
+	case logger.LevelWarn:
+		return logrus.WarnLevel
+	case logger.LevelError:
+		return logrus.ErrorLevel
+	}
+	return logrus.InfoLevel
+}
+
 // Seeds retired static acme domains as approved system rows
 func seedLegacyACMEDomains(ctx context.Context, domains []string, store *stores.Store, log *logger.Logger) error {
 	for _, domain := range domains {
@@ -348,6 +655,50 @@ func seedLegacyACMEDomains(ctx context.Context, domains []string, store *stores.
 	return nil
 }
 
+// The store holding registry link files, nil when they stay on disk.
+// Refuses to start over links the other mode left behind
+func packedLinkStore(ctx context.Context, cfg *config.Config, store *stores.Store) (*stores.Store, error) {
+	if cfg.Registry.PackLinks {
+		onDisk, err := packed.HasLinkFiles(cfg.Registry.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		if onDisk {
+			return nil, fmt.Errorf("registry.pack_links is set but link files remain on disk, run 'distroface migrate pack-links' with the server stopped")
+		}
+		return store, nil
+	}
+	stored, err := store.HasPackedLinks(ctx, "/")
+	if err != nil {
+		return nil, err
+	}
+	if stored {
+		return nil, fmt.Errorf("registry links are packed in the database, set registry.pack_links or run 'distroface migrate unpack-links'")
+	}
+	return nil, nil
+}
+
+// Syncs the blob link index with storage, sharing lookups walk until it lands
+// Nil when disabled, the server then sends every response as is
+func compressionOptions(cfg config.CompressionConfig) *rpc.CompressionOptions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &rpc.CompressionOptions{MinSize: cfg.MinSize, ContentTypes: cfg.ContentTypes}
+}
+
+func rebuildLinkIndex(ctx context.Context, access *registry.RegistryAccess, log *logger.Logger) {
+	start := time.Now()
+	n, err := access.RebuildLinkIndex(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error("Rebuilding blob link index: %v", err)
+		}
+		return
+	}
+	log.Info("Indexed %d blob links in %s", n, time.Since(start).Round(time.Millisecond))
+}
+
 // Starts listening and blocks until a SIGINT/SIGTERM is received then shuts down
 func (a *App) Start() error {
 	go func() {
@@ -357,6 +708,8 @@ func (a *App) Start() error {
 			return
 		}
 		a.Log.Info("Starting Distroface on %s (tls+cleartext)", a.Server.Addr)
+		ln = admin.LimitConnsPerIP(ln, a.Config.Server.MaxConnsPerIP)
+		ln = a.AlertSignals.CountEgress(ln)
 		ln = certs.DualSchemeListener(ln, a.CertEngine.TLSConfig(), a.Server.ReadHeaderTimeout)
 		if err := a.Server.Serve(ln); err != nil && err != http.ErrServerClosed {
 			a.Log.Fatal("Failed to start server: %v", err)
@@ -371,18 +724,36 @@ func (a *App) Start() error {
 
 	a.Log.Info("Shutting down server...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// A second signal skips the drain
+	go func() {
+		<-quit
+		a.Log.Warn("Second signal, exiting without draining")
+		os.Exit(1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	if a.PortalProxies != nil {
-		a.PortalProxies.Close()
-	}
 	if a.CertEngine != nil {
 		a.CertEngine.Close()
 	}
+	// Listeners close at once, requests in flight such as blob uploads finish
+	var wg sync.WaitGroup
+	if a.PortalProxies != nil {
+		wg.Go(func() { a.PortalProxies.Shutdown(ctx) })
+	}
 	if err := a.Server.Shutdown(ctx); err != nil {
 		a.Log.Error("Server forced to shutdown: %v", err)
 	}
+	wg.Wait()
+
+	// Nothing can queue work any more, stop the tickers and let jobs wind down
+	a.stop()
+	if a.jobs != nil {
+		if err := a.jobs.Wait(ctx); err != nil {
+			a.Log.Warn("Job workers still running at exit, their jobs rerun on the next start")
+		}
+	}
 
 	a.Log.Info("Server stopped")
 	return nil
@@ -390,6 +761,9 @@ func (a *App) Start() error {
 
 // Close releases all held resources
 func (a *App) Close() {
+	if a.stop != nil {
+		a.stop()
+	}
 	if a.PortalProxies != nil {
 		a.PortalProxies.Close()
 	}
@@ -400,3 +774,24 @@ func (a *App) Close() {
 		a.Log.Close()
 	}
 }
+
+// Whether the registry must keep tags apart that differ only by case. In
+// auto mode the storage path is probed, packed links live in the database
+// and never collide
+func caseInsensitiveStorage(cfg *config.Config, log *logger.Logger) bool {
+	switch cfg.Storage.CaseInsensitive {
+	case config.CaseOn:
+		return true
+	case config.CaseOff:
+		return false
+	}
+	if cfg.Registry.PackLinks {
+		return false
+	}
+	folds, err := registry.CaseInsensitiveFS(cfg.Registry.StoragePath)
+	if err != nil {
+		log.Warn("Probing registry storage case handling: %v", err)
+		return false
+	}
+	return folds
+}
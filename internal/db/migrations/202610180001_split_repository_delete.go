@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"github.com/nickheyer/distroface/pkg/logger"
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(migration{
+		id:      "202610180001",
+		name:    "split_repository_delete",
+		migrate: splitRepositoryDelete,
+	})
+}
+
+// Roles that could delete repositories keep untagging under the new
+// delete_tag action, raw /v2 deletes stay with admins until granted.
+// Fresh installs have no policy table yet and get it from the seed
+func splitRepositoryDelete(tx *gorm.DB, log *logger.Logger) error {
+	if !tx.Migrator().HasTable("casbin_rule") {
+		return nil
+	}
+	res := tx.Exec(`INSERT INTO casbin_rule (ptype, v0, v1, v2, v3, v4, v5)
+		SELECT c.ptype, c.v0, c.v1, 'delete_tag', c.v3, c.v4, c.v5 FROM casbin_rule c
+		WHERE c.ptype = 'p' AND c.v1 = 'repositories' AND c.v2 = 'delete'
+		AND NOT EXISTS (SELECT 1 FROM casbin_rule d
+			WHERE d.ptype = 'p' AND d.v0 = c.v0 AND d.v1 = c.v1 AND d.v2 = 'delete_tag' AND d.v3 = c.v3)`)
+	if res.Error != nil {
+		return res.Error
+	}
+	log.Info("granted delete_tag to %d repository delete policies", res.RowsAffected)
+	return nil
+}
@@ -0,0 +1,94 @@
+package migrations
+
+import (
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func init() {
+	register(migration{
+		id:      "202610190001",
+		name:    "move_artifact_repo_overrides",
+		migrate: moveArtifactRepoOverrides,
+	})
+}
+
+// The retention and query override columns of artifact repos move onto
+// their repo settings tier, which is all the resolver reads. Set column
+// fields win over the tier as they did, override keep rules replace.
+// Fresh installs never had the columns
+func moveArtifactRepoOverrides(tx *gorm.DB, log *logger.Logger) error {
+	if !tx.Migrator().HasColumn("artifact_repositories", "retention_config") {
+		return nil
+	}
+	type repoOverride struct {
+		Namespace       string
+		Name            string
+		RetentionConfig string `gorm:"column:retention_config"`
+		QueryConfig     string `gorm:"column:query_config"`
+	}
+	var rows []repoOverride
+	if err := tx.Table("artifact_repositories").
+		Where("retention_config <> '' OR query_config <> ''").Find(&rows).Error; err != nil {
+		return err
+	}
+	scope := int32(v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO)
+	for _, r := range rows {
+		scopeID := r.Namespace + "/" + r.Name
+		doc := &v1.Settings{}
+		var existing db.SettingsRow
+		err := tx.Where("scope_type = ? AND scope_id = ?", scope, scopeID).Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing.Value != "" {
+			if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal([]byte(existing.Value), doc); err != nil {
+				log.Warn("skipping overrides of %s, its repo settings do not parse: %v", scopeID, err)
+				continue
+			}
+		}
+		if doc.Artifacts == nil {
+			doc.Artifacts = &v1.ArtifactSettings{}
+		}
+		var retention v1.ArtifactRetentionSettings
+		if r.RetentionConfig != "" && protojson.Unmarshal([]byte(r.RetentionConfig), &retention) == nil {
+			if doc.Artifacts.Retention == nil {
+				doc.Artifacts.Retention = &v1.ArtifactRetentionSettings{}
+			}
+			if len(retention.KeepRules) > 0 {
+				doc.Artifacts.Retention.KeepRules = nil
+			}
+			proto.Merge(doc.Artifacts.Retention, &retention)
+		}
+		var query v1.ArtifactQuerySettings
+		if r.QueryConfig != "" && protojson.Unmarshal([]byte(r.QueryConfig), &query) == nil {
+			if doc.Artifacts.Query == nil {
+				doc.Artifacts.Query = &v1.ArtifactQuerySettings{}
+			}
+			proto.Merge(doc.Artifacts.Query, &query)
+		}
+		value, err := (protojson.MarshalOptions{UseProtoNames: true}).Marshal(doc)
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "scope_type"}, {Name: "scope_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+		}).Create(&db.SettingsRow{ScopeType: scope, ScopeID: scopeID, Value: string(value)}).Error
+		if err != nil {
+			return err
+		}
+	}
+	for _, col := range []string{"retention_config", "query_config"} {
+		if err := tx.Exec("ALTER TABLE artifact_repositories DROP COLUMN " + col).Error; err != nil {
+			return err
+		}
+	}
+	log.Info("moved the overrides of %d artifact repositories onto their repo settings", len(rows))
+	return nil
+}
@@ -1,15 +1,18 @@
-// Runs versioned data migrations after auto migrate
-// Auto inits files named <id>_<name>.go
-// Ids are 12 digit YYYYMMDDNNNN
+// Runs versioned migrations after auto migrate
+// Go migrations auto init from files named <id>_<name>.go, SQL migrations
+// are embedded pairs sql/<id>_<name>.up.sql and sql/<id>_<name>.down.sql
+// Ids are 12 digit YYYYMMDDNNNN, both kinds share one ordered sequence
 package migrations
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"sort"
 	"time"
 
 	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/pkg/logger"
 	"gorm.io/gorm"
 )
@@ -19,6 +22,7 @@ type migrateFunc func(tx *gorm.DB, log *logger.Logger) error
 type migration struct {
 	id       string
 	name     string
+	kind     string // go or sql
 	migrate  migrateFunc
 	rollback migrateFunc
 }
@@ -27,8 +31,14 @@ var registry []migration
 
 var idPattern = regexp.MustCompile(`^\d{12}$`)
 
+// Applied ids live in the gormigrate table, history in migration_events
+const appliedTable = "migrations"
+
 // Called from init in each migration file
 func register(m migration) {
+	if m.kind == "" {
+		m.kind = "go"
+	}
 	registry = append(registry, m)
 }
 
@@ -39,23 +49,43 @@ func Run(gdb *gorm.DB) error {
 
 // Applies pending migrations in id order inside transactions
 func RunWithLogger(gdb *gorm.DB, log *logger.Logger) error {
+	return Up(gdb, log, "")
+}
+
+// Applies pending migrations up to and including target, all when empty
+func Up(gdb *gorm.DB, log *logger.Logger, target string) error {
 	ms, err := ordered()
 	if err != nil {
 		return err
 	}
+	return up(gdb, log, ms, target)
+}
+
+func up(gdb *gorm.DB, log *logger.Logger, ms []migration, target string) error {
 	if len(ms) == 0 {
 		log.Info("no migrations registered")
 		return nil
 	}
-
-	applied := 0
-	gms := make([]*gormigrate.Migration, len(ms))
-	for i, m := range ms {
-		gms[i] = m.wrap(log, &applied)
+	if err := ensureHistory(gdb); err != nil {
+		return err
+	}
+	if err := checkUnknown(gdb, ms); err != nil {
+		return err
 	}
 
+	applied := 0
+	m := gormigrate.New(gdb, options(), wrapAll(ms, log, &applied))
+	var err error
 	log.Info("checking %d registered migrations", len(ms))
-	if err := gormigrate.New(gdb, options(), gms).Migrate(); err != nil {
+	if target == "" {
+		err = m.Migrate()
+	} else {
+		err = m.MigrateTo(target)
+	}
+	if errors.Is(err, gormigrate.ErrMigrationIDDoesNotExist) {
+		return fmt.Errorf("no migration %q in this release", target)
+	}
+	if err != nil {
 		return fmt.Errorf("migration failed after %d applied: %w", applied, err)
 	}
 	if applied == 0 {
@@ -72,29 +102,211 @@ func RollbackLast(gdb *gorm.DB, log *logger.Logger) error {
 	if err != nil {
 		return err
 	}
+	return rollback(gdb, log, ms, "")
+}
+
+// Reverts every applied migration newer than target, target stays applied
+func RollbackTo(gdb *gorm.DB, log *logger.Logger, target string) error {
+	ms, err := ordered()
+	if err != nil {
+		return err
+	}
+	return rollback(gdb, log, ms, target)
+}
+
+func rollback(gdb *gorm.DB, log *logger.Logger, ms []migration, target string) error {
 	if len(ms) == 0 {
 		log.Info("no migrations registered")
 		return nil
 	}
+	if err := ensureHistory(gdb); err != nil {
+		return err
+	}
+	if err := checkReversible(gdb, ms, target); err != nil {
+		return err
+	}
 
 	applied := 0
-	gms := make([]*gormigrate.Migration, len(ms))
+	m := gormigrate.New(gdb, options(), wrapAll(ms, log, &applied))
+	var err error
+	if target == "" {
+		err = m.RollbackLast()
+	} else {
+		err = m.RollbackTo(target)
+	}
+	switch {
+	case errors.Is(err, gormigrate.ErrNoRunMigration):
+		log.Info("no applied migrations to roll back")
+		return nil
+	case errors.Is(err, gormigrate.ErrMigrationIDDoesNotExist):
+		return fmt.Errorf("no migration %q in this release", target)
+	}
+	return err
+}
+
+// Fails before reverting anything when one step in range has no down
+func checkReversible(gdb *gorm.DB, ms []migration, target string) error {
+	states, _, err := status(gdb, ms)
+	if err != nil {
+		return err
+	}
+	for i := len(states) - 1; i >= 0; i-- {
+		s := states[i]
+		if target != "" && s.ID <= target {
+			break
+		}
+		if !s.Applied {
+			continue
+		}
+		if !s.Reversible {
+			return fmt.Errorf("migration %s %s has no down step, nothing was reverted", s.ID, s.Name)
+		}
+		if target == "" {
+			break
+		}
+	}
+	return nil
+}
+
+// Applied state of one registered migration
+type State struct {
+	ID         string
+	Name       string
+	Kind       string
+	Reversible bool
+	Applied    bool
+	AppliedAt  time.Time // Zero when applied before history was kept
+}
+
+// Registered migrations in order, plus applied ids this release lacks
+func Status(gdb *gorm.DB) ([]State, []string, error) {
+	ms, err := ordered()
+	if err != nil {
+		return nil, nil, err
+	}
+	return status(gdb, ms)
+}
+
+func status(gdb *gorm.DB, ms []migration) ([]State, []string, error) {
+	applied, err := appliedIDs(gdb)
+	if err != nil {
+		return nil, nil, err
+	}
+	at := map[string]time.Time{}
+	if gdb.Migrator().HasTable(&db.MigrationEvent{}) {
+		var events []db.MigrationEvent
+		if err := gdb.Order("id").Find(&events).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, e := range events {
+			if e.Direction == "up" {
+				at[e.MigrationID] = e.CreatedAt
+			}
+		}
+	}
+
+	states := make([]State, len(ms))
+	known := make(map[string]bool, len(ms))
 	for i, m := range ms {
-		gms[i] = m.wrap(log, &applied)
+		known[m.id] = true
+		states[i] = State{
+			ID:         m.id,
+			Name:       m.name,
+			Kind:       m.kind,
+			Reversible: m.rollback != nil,
+			Applied:    applied[m.id],
+			AppliedAt:  at[m.id],
+		}
+	}
+	var unknown []string
+	for id := range applied {
+		if !known[id] {
+			unknown = append(unknown, id)
+		}
 	}
-	return gormigrate.New(gdb, options(), gms).RollbackLast()
+	sort.Strings(unknown)
+	return states, unknown, nil
+}
+
+// Registered migrations not applied yet
+func Pending(gdb *gorm.DB) ([]string, error) {
+	states, unknown, err := Status(gdb)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		return nil, unknownErr(states, unknown)
+	}
+	var pending []string
+	for _, s := range states {
+		if !s.Applied {
+			pending = append(pending, s.ID)
+		}
+	}
+	return pending, nil
+}
+
+func appliedIDs(gdb *gorm.DB) (map[string]bool, error) {
+	out := map[string]bool{}
+	if !gdb.Migrator().HasTable(appliedTable) {
+		return out, nil
+	}
+	var ids []string
+	if err := gdb.Table(appliedTable).Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("reading applied migrations: %w", err)
+	}
+	for _, id := range ids {
+		out[id] = true
+	}
+	return out, nil
+}
+
+// A newer release applied migrations this one cannot see, running on would
+// mix schemas. The newer release has to roll them back first
+func checkUnknown(gdb *gorm.DB, ms []migration) error {
+	states, unknown, err := status(gdb, ms)
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return unknownErr(states, unknown)
+	}
+	return nil
+}
+
+func unknownErr(states []State, unknown []string) error {
+	last := "the oldest migration"
+	if len(states) > 0 {
+		last = states[len(states)-1].ID
+	}
+	return fmt.Errorf("database has migrations %v from a newer release, run 'distroface migrate down --to %s' with that release first", unknown, last)
+}
+
+func ensureHistory(gdb *gorm.DB) error {
+	if err := gdb.AutoMigrate(&db.MigrationEvent{}); err != nil {
+		return fmt.Errorf("creating migration history: %w", err)
+	}
+	return nil
 }
 
 func options() *gormigrate.Options {
 	opts := *gormigrate.DefaultOptions
+	opts.TableName = appliedTable
 	opts.UseTransaction = true
 	return &opts
 }
 
 // Validates entries then sorts so run order is deterministic
 func ordered() ([]migration, error) {
-	seen := make(map[string]bool, len(registry))
-	for _, m := range registry {
+	if sqlErr != nil {
+		return nil, sqlErr
+	}
+	return validate(registry)
+}
+
+func validate(ms []migration) ([]migration, error) {
+	seen := make(map[string]bool, len(ms))
+	for _, m := range ms {
 		if !idPattern.MatchString(m.id) {
 			return nil, fmt.Errorf("migration id %q must be 12 digits", m.id)
 		}
@@ -109,12 +321,20 @@ func ordered() ([]migration, error) {
 		}
 		seen[m.id] = true
 	}
-	out := append([]migration(nil), registry...)
+	out := append([]migration(nil), ms...)
 	sort.Slice(out, func(i, j int) bool { return out[i].id < out[j].id })
 	return out, nil
 }
 
-// Adds timing and outcome logs around gormigrate execution
+func wrapAll(ms []migration, log *logger.Logger, applied *int) []*gormigrate.Migration {
+	gms := make([]*gormigrate.Migration, len(ms))
+	for i, m := range ms {
+		gms[i] = m.wrap(log, applied)
+	}
+	return gms
+}
+
+// Adds timing, outcome logs and a history row around gormigrate execution
 func (m migration) wrap(log *logger.Logger, applied *int) *gormigrate.Migration {
 	g := &gormigrate.Migration{
 		ID: m.id,
@@ -125,6 +345,9 @@ func (m migration) wrap(log *logger.Logger, applied *int) *gormigrate.Migration
 				log.Error("migration %s failed: %v", m.id, err)
 				return err
 			}
+			if err := m.record(tx, "up", time.Since(start)); err != nil {
+				return err
+			}
 			*applied++
 			log.Info("migration %s done in %s", m.id, time.Since(start).Round(time.Millisecond))
 			return nil
@@ -133,13 +356,27 @@ func (m migration) wrap(log *logger.Logger, applied *int) *gormigrate.Migration
 	if m.rollback != nil {
 		g.Rollback = func(tx *gorm.DB) error {
 			log.Warn("rolling back %s %s", m.id, m.name)
+			start := time.Now()
 			if err := m.rollback(tx, log); err != nil {
 				log.Error("rollback %s failed: %v", m.id, err)
 				return err
 			}
+			if err := m.record(tx, "down", time.Since(start)); err != nil {
+				return err
+			}
 			log.Info("rolled back %s", m.id)
 			return nil
 		}
 	}
 	return g
 }
+
+// Same transaction as the migration, history never claims a reverted step
+func (m migration) record(tx *gorm.DB, direction string, took time.Duration) error {
+	return tx.Create(&db.MigrationEvent{
+		MigrationID: m.id,
+		Name:        m.name,
+		Direction:   direction,
+		DurationMs:  took.Milliseconds(),
+	}).Error
+}
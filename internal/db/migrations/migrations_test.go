@@ -0,0 +1,201 @@
+package migrations
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gdb
+}
+
+func testMigrations(t *testing.T) []migration {
+	t.Helper()
+	ms, err := loadSQL(fstest.MapFS{
+		"sql/202601010001_create_widgets.up.sql":   {Data: []byte("CREATE TABLE widgets (id INTEGER PRIMARY KEY, name TEXT);")},
+		"sql/202601010001_create_widgets.down.sql": {Data: []byte("DROP TABLE widgets;")},
+		"sql/202601010002_widget_color.up.sql":     {Data: []byte("ALTER TABLE widgets ADD COLUMN color TEXT;\nCREATE INDEX idx_widget_color ON widgets (color);")},
+		"sql/202601010002_widget_color.down.sql":   {Data: []byte("DROP INDEX idx_widget_color;\nALTER TABLE widgets DROP COLUMN color;")},
+		"sql/202601010003_seed_widgets.up.sql":     {Data: []byte("INSERT INTO widgets (name, color) VALUES ('a', 'red');")},
+	}, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err = validate(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ms
+}
+
+func applied(t *testing.T, gdb *gorm.DB, ms []migration) []string {
+	t.Helper()
+	states, _, err := status(gdb, ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, s := range states {
+		if s.Applied {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids
+}
+
+func TestLoadSQL(t *testing.T) {
+	ms := testMigrations(t)
+	if len(ms) != 3 {
+		t.Fatalf("loaded %d migrations, want 3", len(ms))
+	}
+	if ms[0].kind != "sql" || ms[0].name != "create_widgets" || ms[0].rollback == nil {
+		t.Errorf("first migration = %+v", ms[0])
+	}
+	if ms[2].rollback != nil {
+		t.Error("migration without a down file is reversible")
+	}
+
+	for name, files := range map[string]fstest.MapFS{
+		"bad name":     {"sql/1_x.up.sql": {}},
+		"no up":        {"sql/202601010001_x.down.sql": {}},
+		"name differs": {"sql/202601010001_x.up.sql": {}, "sql/202601010001_y.down.sql": {}},
+	} {
+		if _, err := loadSQL(files, "sql"); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	gdb := testDB(t)
+	ms := testMigrations(t)
+	log := logger.New().Module("migrations")
+
+	if err := up(gdb, log, ms, "202601010002"); err != nil {
+		t.Fatalf("up to 0002: %v", err)
+	}
+	if got := applied(t, gdb, ms); len(got) != 2 {
+		t.Fatalf("applied = %v, want the first two", got)
+	}
+	if !gdb.Migrator().HasColumn("widgets", "color") {
+		t.Fatal("second migration did not run")
+	}
+
+	if err := rollback(gdb, log, ms, ""); err != nil {
+		t.Fatalf("rollback last: %v", err)
+	}
+	if gdb.Migrator().HasColumn("widgets", "color") {
+		t.Fatal("down step did not run")
+	}
+
+	if err := up(gdb, log, ms, ""); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if got := applied(t, gdb, ms); len(got) != 3 {
+		t.Fatalf("applied = %v, want all", got)
+	}
+
+	// The seed has no down, so nothing in range may be reverted
+	if err := rollback(gdb, log, ms, "202601010001"); err == nil || !strings.Contains(err.Error(), "202601010003") {
+		t.Fatalf("rollback across an irreversible step = %v", err)
+	}
+	if got := applied(t, gdb, ms); len(got) != 3 {
+		t.Fatalf("failed rollback reverted %v", got)
+	}
+
+	// Two up, one down, two up again
+	var events int64
+	gdb.Table("migration_events").Count(&events)
+	if events != 5 {
+		t.Errorf("history has %d events, want 5", events)
+	}
+}
+
+// An older release must refuse a database a newer one migrated
+func TestUnknownApplied(t *testing.T) {
+	gdb := testDB(t)
+	ms := testMigrations(t)
+	log := logger.New().Module("migrations")
+
+	if err := up(gdb, log, ms, ""); err != nil {
+		t.Fatal(err)
+	}
+	older := ms[:1]
+	if err := up(gdb, log, older, ""); err == nil || !strings.Contains(err.Error(), "newer release") {
+		t.Fatalf("up with unknown applied migrations = %v", err)
+	}
+	_, unknown, err := status(gdb, older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unknown) != 2 {
+		t.Errorf("unknown = %v, want the two newer ids", unknown)
+	}
+}
+
+// Column overrides land on the repo tier over what it already held
+func TestMoveArtifactRepoOverrides(t *testing.T) {
+	gdb := testDB(t)
+	log := logger.New().Module("migrations")
+	if err := gdb.Exec(`CREATE TABLE artifact_repositories (id INTEGER PRIMARY KEY, namespace TEXT, name TEXT,
+		retention_config TEXT NOT NULL DEFAULT '', query_config TEXT NOT NULL DEFAULT '')`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.AutoMigrate(&db.SettingsRow{}); err != nil {
+		t.Fatal(err)
+	}
+	gdb.Exec(`INSERT INTO artifact_repositories (namespace, name, retention_config, query_config) VALUES
+		('alice', 'app', '{"maxVersions":2,"keepRules":[{"key":"pinned"}]}', '{"num":3}'),
+		('alice', 'plain', '', '')`)
+	repoScope := int32(v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO)
+	gdb.Create(&db.SettingsRow{ScopeType: repoScope, ScopeID: "alice/app",
+		Value: `{"artifacts":{"retention":{"max_age_days":30,"keep_rules":[{"key":"release"}]}}}`})
+
+	if err := moveArtifactRepoOverrides(gdb, log); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if gdb.Migrator().HasColumn("artifact_repositories", "retention_config") || gdb.Migrator().HasColumn("artifact_repositories", "query_config") {
+		t.Fatal("override columns kept")
+	}
+	var row db.SettingsRow
+	if err := gdb.First(&row, "scope_type = ? AND scope_id = ?", repoScope, "alice/app").Error; err != nil {
+		t.Fatal(err)
+	}
+	doc := &v1.Settings{}
+	if err := protojson.Unmarshal([]byte(row.Value), doc); err != nil {
+		t.Fatal(err)
+	}
+	r := doc.GetArtifacts().GetRetention()
+	if r.GetMaxVersions() != 2 || r.GetMaxAgeDays() != 30 || len(r.KeepRules) != 1 || r.KeepRules[0].Key != "pinned" {
+		t.Errorf("retention = %v", r)
+	}
+	if doc.GetArtifacts().GetQuery().GetNum() != 3 {
+		t.Errorf("query = %v", doc.GetArtifacts().GetQuery())
+	}
+	var count int64
+	gdb.Model(&db.SettingsRow{}).Where("scope_id = ?", "alice/plain").Count(&count)
+	if count != 0 {
+		t.Error("repo without overrides got a settings row")
+	}
+
+	if err := moveArtifactRepoOverrides(gdb, log); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+}
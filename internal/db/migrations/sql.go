@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/nickheyer/distroface/pkg/logger"
+	"gorm.io/gorm"
+)
+
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+var sqlName = regexp.MustCompile(`^(\d{12})_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Bad embedded files surface from ordered, not as an init panic
+var sqlErr error
+
+func init() {
+	ms, err := loadSQL(sqlFiles, "sql")
+	if err != nil {
+		sqlErr = err
+		return
+	}
+	for _, m := range ms {
+		register(m)
+	}
+}
+
+// Pairs up and down files by id, a missing down file makes it irreversible
+func loadSQL(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]*migration{}
+	var ids []string
+	for _, e := range entries {
+		match := sqlName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("sql migration %q must be named <id>_<name>.up.sql or .down.sql", e.Name())
+		}
+		id, name, direction := match[1], match[2], match[3]
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byID[id]
+		if m == nil {
+			m = &migration{id: id, name: name, kind: "sql"}
+			byID[id] = m
+			ids = append(ids, id)
+		} else if m.name != name {
+			return nil, fmt.Errorf("sql migration %s is named both %q and %q", id, m.name, name)
+		}
+		fn := execSQL(string(body))
+		if direction == "up" {
+			m.migrate = fn
+		} else {
+			m.rollback = fn
+		}
+	}
+
+	out := make([]migration, 0, len(ids))
+	for _, id := range ids {
+		if byID[id].migrate == nil {
+			return nil, fmt.Errorf("sql migration %s has a down file but no up file", id)
+		}
+		out = append(out, *byID[id])
+	}
+	return out, nil
+}
+
+// The sqlite driver runs every statement of a multi statement exec
+func execSQL(body string) migrateFunc {
+	return func(tx *gorm.DB, log *logger.Logger) error {
+		if strings.TrimSpace(body) == "" {
+			return nil
+		}
+		return tx.Exec(body).Error
+	}
+}
@@ -0,0 +1,2 @@
+-- Delivery logs list the newest attempts of one webhook first
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_recent ON webhook_deliveries (webhook_id, delivered_at DESC);
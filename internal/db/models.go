@@ -24,6 +24,9 @@ type User struct {
 	OIDCIssuer         string     `json:"oidc_issuer" gorm:"column:oidc_issuer;uniqueIndex:idx_oidc_identity,where:oidc_subject != ''"`
 	IsActive           bool       `json:"is_active" gorm:"not null;default:true"`
 	MustChangePassword bool       `json:"must_change_password" gorm:"not null;default:false;column:must_change_password"`
+	PendingApproval    bool       `json:"pending_approval" gorm:"not null;default:false;column:pending_approval"` // Self registered, inactive until an admin approves
+	DefaultNamespace   string     `json:"default_namespace" gorm:"not null;default:'';column:default_namespace"`  // Bare repo names resolve here, empty for the user's own
+	ArtifactQuotaMB    *int64     `json:"artifact_quota_mb" gorm:"column:artifact_quota_mb"`                      // Nil inherits artifacts.quota.owner_mb
 	LastLogin          *time.Time `json:"last_login" gorm:"column:last_login"`
 	CreatedAt          time.Time  `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt          time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
@@ -73,6 +76,7 @@ type APIToken struct {
 	UserID     string     `json:"user_id" gorm:"not null;index;column:user_id"`
 	Name       string     `json:"name" gorm:"not null"`
 	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex;column:token_hash"`
+	Scopes     string     `json:"scopes" gorm:"not null;default:'';column:scopes"` // Comma separated, empty for the owner's full access
 	ExpiresAt  *time.Time `json:"expires_at" gorm:"column:expires_at"`
 	LastUsedAt *time.Time `json:"last_used_at" gorm:"column:last_used_at"`
 	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
@@ -116,10 +120,24 @@ type Repository struct {
 	MirrorState     string            `json:"-" gorm:"type:text;not null;default:'';column:mirror_state"`  // Sync cursor and cooldown bookkeeping
 	MirrorLastSync  *time.Time        `json:"mirror_last_sync" gorm:"column:mirror_last_sync"`
 	MirrorLastError string            `json:"mirror_last_error" gorm:"column:mirror_last_error"`
+	ForkedFrom      string            `json:"forked_from" gorm:"not null;default:'';column:forked_from"`      // Source namespace/name of a fork
+	PullRestriction string            `json:"-" gorm:"type:text;not null;default:'';column:pull_restriction"` // Protojson download allowlist, empty allows every puller
 	CreatedAt       time.Time         `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt       time.Time         `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
+type RepositoryPermission struct { // ACL grant on the image and artifact repos at one path, to a user or every holder of a role
+	ID        string    `json:"id" gorm:"primaryKey"`
+	Namespace string    `json:"namespace" gorm:"not null;uniqueIndex:idx_repo_permission_subject"`
+	Name      string    `json:"name" gorm:"not null;uniqueIndex:idx_repo_permission_subject"`
+	UserID    string    `json:"user_id" gorm:"not null;default:'';uniqueIndex:idx_repo_permission_subject;index;column:user_id"`
+	RoleName  string    `json:"role_name" gorm:"not null;default:'';uniqueIndex:idx_repo_permission_subject;index;column:role_name"`
+	Level     string    `json:"level" gorm:"not null"` // read, write or admin
+	GrantedBy string    `json:"granted_by" gorm:"not null;default:'';column:granted_by"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
 type Star struct {
 	ID        string      `json:"id" gorm:"primaryKey"`
 	UserID    string      `json:"user_id" gorm:"not null;uniqueIndex:idx_star_user_repo;column:user_id"`
@@ -129,6 +147,46 @@ type Star struct {
 	Repo      *Repository `json:"-" gorm:"foreignKey:RepoID;constraint:OnDelete:CASCADE"`
 }
 
+type Watch struct { // User subscription to an image repository's events
+	ID        string      `json:"id" gorm:"primaryKey"`
+	UserID    string      `json:"user_id" gorm:"not null;uniqueIndex:idx_watch_user_repo;column:user_id"`
+	RepoID    string      `json:"repo_id" gorm:"not null;uniqueIndex:idx_watch_user_repo;index;column:repo_id"`
+	Events    string      `json:"events" gorm:"type:text;not null;default:'[]'"` // JSON array of tag, delete, comment, empty watches all
+	CreatedAt time.Time   `json:"created_at" gorm:"autoCreateTime"`
+	User      *User       `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Repo      *Repository `json:"-" gorm:"foreignKey:RepoID;constraint:OnDelete:CASCADE"`
+}
+
+type NotificationPreference struct { // Delivery choices, users without a row get the defaults
+	UserID        string     `json:"user_id" gorm:"primaryKey;column:user_id"`
+	Email         bool       `json:"email" gorm:"not null"`
+	WebhookURL    string     `json:"webhook_url" gorm:"not null;default:'';column:webhook_url"`
+	WebhookSecret string     `json:"-" gorm:"not null;default:'';column:webhook_secret"`
+	Mentions      bool       `json:"mentions" gorm:"not null"`
+	Digest        string     `json:"digest" gorm:"not null;default:''"` // Empty, hourly or daily
+	LastDigestAt  *time.Time `json:"last_digest_at" gorm:"column:last_digest_at"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+	User          *User      `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+type Notification struct { // Inbox entry, delivered once mailed or posted per the owner's preferences
+	ID        string    `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"user_id" gorm:"not null;index:idx_notification_user_created;column:user_id"`
+	Reason    string    `json:"reason" gorm:"not null"` // watch or mention
+	Event     string    `json:"event" gorm:"not null"`  // tag, delete or comment
+	Namespace string    `json:"namespace" gorm:"not null"`
+	Name      string    `json:"name" gorm:"not null"`
+	Ref       string    `json:"ref" gorm:"not null;default:''"`
+	Digest    string    `json:"digest" gorm:"not null;default:''"`
+	Actor     string    `json:"actor" gorm:"not null;default:''"`
+	Excerpt   string    `json:"excerpt" gorm:"type:text;not null;default:''"`
+	CommentID string    `json:"comment_id" gorm:"not null;default:'';column:comment_id"`
+	Read      bool      `json:"read" gorm:"not null;default:false"`
+	Delivered bool      `json:"delivered" gorm:"not null;default:false;index"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime;index:idx_notification_user_created"`
+	User      *User     `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
 // Webhook scope constants
 const (
 	WebhookScopeRepository   = "repository"
@@ -213,6 +271,7 @@ type ArtifactRepository struct { // Generic artifact repo, integer PK kept for v
 	MirrorState     string              `json:"-" gorm:"type:text;not null;default:'';column:mirror_state"`  // Sync cursor and cooldown bookkeeping
 	MirrorLastSync  *time.Time          `json:"mirror_last_sync" gorm:"column:mirror_last_sync"`
 	MirrorLastError string              `json:"mirror_last_error" gorm:"column:mirror_last_error"`
+	OCIExport       bool                `json:"oci_export" gorm:"not null;default:false;column:oci_export"` // Versions served read only under /v2
 	CreatedAt       time.Time           `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt       time.Time           `json:"updated_at" gorm:"autoUpdateTime"`
 }
@@ -228,10 +287,16 @@ type Artifact struct {
 	Digest     string              `json:"digest" gorm:"not null;index"`                                                     // Full sha256 content address
 	Size       int64               `json:"size" gorm:"not null"`
 	MimeType   string              `json:"mime_type" gorm:"column:mime_type"`
-	Metadata   string              `json:"metadata" gorm:"type:text;not null;default:'{}'"` // Arbitrary JSON object
+	Metadata   string              `json:"metadata" gorm:"type:text;not null;default:'{}'"`                 // Arbitrary JSON object
+	ScanStatus string              `json:"scan_status" gorm:"not null;default:'';index;column:scan_status"` // Empty when uploaded while scanning was off
+	ScanEngine string              `json:"scan_engine" gorm:"not null;default:'';column:scan_engine"`
+	ScanResult string              `json:"scan_result" gorm:"not null;default:'';column:scan_result"` // Signature when infected, reason when skipped or failed
+	ScannedAt  *time.Time          `json:"scanned_at" gorm:"column:scanned_at"`
+	ExpiresAt  *time.Time          `json:"expires_at" gorm:"index;column:expires_at"` // Reaper deletes it after, nil keeps it
 	CreatedAt  time.Time           `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt  time.Time           `json:"updated_at" gorm:"autoUpdateTime"`
 	Properties map[string]string   `json:"properties" gorm:"-"` // Loaded from artifact_properties
+	Package    *ArtifactPackage    `json:"-" gorm:"-"`          // Written with the row for debian and rpm repos
 	Repo       *ArtifactRepository `json:"-" gorm:"foreignKey:RepoID;constraint:OnDelete:CASCADE"`
 }
 
@@ -244,6 +309,20 @@ type ArtifactProperty struct {
 	Artifact   *Artifact `json:"-" gorm:"foreignKey:ArtifactID;constraint:OnDelete:CASCADE"`
 }
 
+type ArtifactPackage struct { // Parsed package header of a debian or rpm repo artifact, the source of its apt or yum index
+	ArtifactID   string    `json:"artifact_id" gorm:"primaryKey;column:artifact_id"`
+	RepoID       int64     `json:"repo_id" gorm:"not null;index;column:repo_id"`
+	Kind         string    `json:"kind" gorm:"not null"` // deb or rpm
+	Name         string    `json:"name" gorm:"not null"`
+	Version      string    `json:"version" gorm:"not null"`
+	Arch         string    `json:"arch" gorm:"not null"`
+	Distribution string    `json:"distribution" gorm:"not null;default:''"` // Debian only
+	Component    string    `json:"component" gorm:"not null;default:''"`    // Debian only
+	Index        string    `json:"-" gorm:"type:text;not null"`             // Control paragraph, or rpm header fields as JSON
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
+	Artifact     *Artifact `json:"-" gorm:"foreignKey:ArtifactID;constraint:OnDelete:CASCADE"`
+}
+
 type CertificateDomain struct { // Allowlist and approval entry for a portal hostname
 	ID         string                    `json:"id" gorm:"primaryKey"`
 	Domain     string                    `json:"domain" gorm:"not null;uniqueIndex"`
@@ -282,6 +361,17 @@ type AuditEvent struct {
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime;index"`
 }
 
+// First half of a two admin delete, one pending request per target
+type DeletionApproval struct {
+	Resource    string    `json:"resource" gorm:"primaryKey"` // rbac resource and target, repositories:ns/name
+	RequestedBy string    `json:"requested_by" gorm:"not null"`
+	Requester   string    `json:"requester"`
+	Reason      string    `json:"reason" gorm:"type:text"`
+	SizeBytes   int64     `json:"size_bytes"`
+	ExpiresAt   time.Time `json:"expires_at" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
 type RegistrationInvite struct {
 	ID          string     `json:"id" gorm:"primaryKey"`
 	Code        string     `json:"code" gorm:"not null;uniqueIndex"`
@@ -294,3 +384,199 @@ type RegistrationInvite struct {
 	CreatedBy   string     `json:"created_by" gorm:"not null;column:created_by"`
 	CreatedAt   time.Time  `json:"created_at" gorm:"autoCreateTime"`
 }
+
+type Credential struct { // Upstream login kept in the vault, referenced by id from mirror configs
+	ID          string    `json:"id" gorm:"primaryKey"`
+	Namespace   string    `json:"namespace" gorm:"not null;uniqueIndex:idx_credential_ns_name"`
+	Name        string    `json:"name" gorm:"not null;uniqueIndex:idx_credential_ns_name"`
+	Description string    `json:"description"`
+	Username    string    `json:"username"`
+	Secret      string    `json:"-" gorm:"type:text;not null"` // Sealed by the vault key, never plaintext
+	CreatedBy   string    `json:"created_by" gorm:"not null;column:created_by"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+type SigningKey struct { // Image signing key pair, the private half sealed by the vault key
+	ID         string     `json:"id" gorm:"primaryKey"`
+	Scope      string     `json:"scope" gorm:"not null;default:'';index"` // namespace/name for a repository key, empty for the instance key
+	PublicKey  string     `json:"public_key" gorm:"type:text;not null;column:public_key"`
+	PrivateKey string     `json:"-" gorm:"type:text;not null;column:private_key"` // Sealed pkcs8 pem
+	Active     bool       `json:"active" gorm:"not null;default:false"`           // One per scope, new signatures use it
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	RetiredAt  *time.Time `json:"retired_at" gorm:"column:retired_at"`
+}
+
+type ImageSignature struct { // Server made signature manifest attached to a pushed manifest
+	ID            string    `json:"id" gorm:"primaryKey"`
+	Namespace     string    `json:"namespace" gorm:"not null;index:idx_image_signature_subject"`
+	Name          string    `json:"name" gorm:"not null;index:idx_image_signature_subject"`
+	SubjectDigest string    `json:"subject_digest" gorm:"not null;index:idx_image_signature_subject;column:subject_digest"`
+	Digest        string    `json:"digest" gorm:"not null"` // Signature manifest
+	Size          int64     `json:"size" gorm:"not null"`
+	KeyID         string    `json:"key_id" gorm:"not null;index;column:key_id"`
+	CreatedAt     time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+type TagPush struct { // Latest push of each image tag, registry storage keeps no pusher
+	Namespace string    `json:"namespace" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Tag       string    `json:"tag" gorm:"primaryKey"`
+	Digest    string    `json:"digest" gorm:"not null"`
+	PushedBy  string    `json:"pushed_by" gorm:"not null;default:'';column:pushed_by"` // Empty for pushes without auth
+	PushedAt  time.Time `json:"pushed_at" gorm:"not null;column:pushed_at"`
+	Labels    string    `json:"-" gorm:"type:text;not null;default:'{}'"` // Image config labels as a json object, repo listings filter on them
+}
+
+type TagPull struct { // Pulls of each image tag by name, digest pulls carry no tag
+	Namespace string    `json:"namespace" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Tag       string    `json:"tag" gorm:"primaryKey"`
+	PullCount int64     `json:"pull_count" gorm:"not null;default:0;column:pull_count"`
+	PulledAt  time.Time `json:"pulled_at" gorm:"not null;column:pulled_at"`
+}
+
+type TagExpiry struct { // When an image tag is untagged, kept across pushes to the tag
+	Namespace string    `json:"namespace" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Tag       string    `json:"tag" gorm:"primaryKey"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index;column:expires_at"`
+	SetBy     string    `json:"set_by" gorm:"not null;default:'';column:set_by"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+type TagProvenance struct { // Copy that last set an image tag, kept while the tag still points at Digest
+	Namespace    string    `json:"namespace" gorm:"primaryKey"`
+	Name         string    `json:"name" gorm:"primaryKey"`
+	Tag          string    `json:"tag" gorm:"primaryKey"`
+	Digest       string    `json:"digest" gorm:"not null"`
+	SourceRepo   string    `json:"source_repo" gorm:"not null;column:source_repo"`
+	SourceTag    string    `json:"source_tag" gorm:"not null;column:source_tag"`
+	SourceDigest string    `json:"source_digest" gorm:"not null;column:source_digest"`
+	OriginRepo   string    `json:"origin_repo" gorm:"not null;column:origin_repo"` // First repo along a chain of copies
+	OriginTag    string    `json:"origin_tag" gorm:"not null;column:origin_tag"`
+	CopiedBy     string    `json:"copied_by" gorm:"not null;default:'';column:copied_by"`
+	PipelineID   string    `json:"pipeline_id" gorm:"not null;default:'';column:pipeline_id"`
+	CopiedAt     time.Time `json:"copied_at" gorm:"not null;column:copied_at"`
+}
+
+type BlobLink struct { // A layer or manifest revision link of one repository, sharing lookups skip the storage walk
+	Digest    string    `json:"digest" gorm:"primaryKey"`
+	Repo      string    `json:"repo" gorm:"primaryKey;index"` // namespace/name
+	Kind      string    `json:"kind" gorm:"primaryKey"`       // layer or manifest
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+}
+
+type PackedLink struct { // Registry link file kept in the database instead of on disk, Path is the storage driver path
+	Path    string    `json:"path" gorm:"primaryKey"`
+	Content []byte    `json:"content" gorm:"not null"`
+	ModTime time.Time `json:"mod_time" gorm:"not null;column:mod_time"`
+}
+
+type FreezeWindow struct { // Push freeze over image repos matching Pattern, a nil EndsAt holds until removed
+	ID        string     `json:"id" gorm:"primaryKey"`
+	Pattern   string     `json:"pattern" gorm:"not null"` // namespace/name glob, prod/* covers every repo in prod
+	Reason    string     `json:"reason" gorm:"not null;default:''"`
+	StartsAt  time.Time  `json:"starts_at" gorm:"not null;index;column:starts_at"`
+	EndsAt    *time.Time `json:"ends_at" gorm:"index;column:ends_at"`
+	CreatedBy string     `json:"created_by" gorm:"not null;default:'';column:created_by"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+type Comment struct { // Markdown note on an image tag or artifact version, exactly one repo id is set
+	ID             string              `json:"id" gorm:"primaryKey"`
+	RepoID         *string             `json:"repo_id" gorm:"index:idx_comment_image_target;column:repo_id"`
+	ArtifactRepoID *int64              `json:"artifact_repo_id" gorm:"index:idx_comment_artifact_target;column:artifact_repo_id"`
+	Ref            string              `json:"ref" gorm:"not null;index:idx_comment_image_target;index:idx_comment_artifact_target"` // Tag or version
+	Digest         string              `json:"digest" gorm:"not null;default:''"`                                                    // Tag target when written
+	AuthorID       string              `json:"author_id" gorm:"not null;column:author_id"`
+	Author         string              `json:"author" gorm:"not null"` // Username kept after the account goes
+	Body           string              `json:"body" gorm:"type:text;not null"`
+	CreatedAt      time.Time           `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time           `json:"updated_at" gorm:"autoUpdateTime"`
+	Repo           *Repository         `json:"-" gorm:"foreignKey:RepoID;constraint:OnDelete:CASCADE"`
+	ArtifactRepo   *ArtifactRepository `json:"-" gorm:"foreignKey:ArtifactRepoID;constraint:OnDelete:CASCADE"`
+}
+
+type MigrationEvent struct { // One apply or rollback of a versioned migration, kept for audit
+	ID          uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	MigrationID string    `json:"migration_id" gorm:"not null;index;column:migration_id"`
+	Name        string    `json:"name" gorm:"not null"`
+	Direction   string    `json:"direction" gorm:"not null"` // up or down
+	DurationMs  int64     `json:"duration_ms" gorm:"not null;column:duration_ms"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+type ArtifactLatestPointer struct { // Newest upload of one path matching the latest filter, one row swap moves it
+	RepoID     int64               `json:"repo_id" gorm:"primaryKey;autoIncrement:false;column:repo_id"`
+	Path       string              `json:"path" gorm:"primaryKey"`
+	ArtifactID string              `json:"artifact_id" gorm:"not null;index;column:artifact_id"`
+	Version    string              `json:"version" gorm:"not null"`
+	Digest     string              `json:"digest" gorm:"not null"`
+	Filter     string              `json:"filter" gorm:"type:text;not null;default:'{}'"` // JSON properties the pointer moved under, deletes fall back within it
+	UpdatedAt  time.Time           `json:"updated_at" gorm:"autoUpdateTime"`
+	Repo       *ArtifactRepository `json:"-" gorm:"foreignKey:RepoID;constraint:OnDelete:CASCADE"`
+}
+
+// Artifact change kinds
+const (
+	ArtifactChangeUpload     = "upload"
+	ArtifactChangeDelete     = "delete"
+	ArtifactChangeRename     = "rename"
+	ArtifactChangeProperties = "properties"
+	ArtifactChangeMetadata   = "metadata"
+)
+
+type ArtifactChange struct { // Append only history of a repo's artifacts, written with the change it records
+	ID         int64               `json:"id" gorm:"primaryKey;autoIncrement"` // Export cursor, commits serialize so it only grows
+	RepoID     int64               `json:"repo_id" gorm:"not null;index;column:repo_id"`
+	ArtifactID string              `json:"artifact_id" gorm:"not null;column:artifact_id"`
+	Kind       string              `json:"kind" gorm:"not null"`
+	Actor      string              `json:"actor" gorm:"not null;default:''"` // Empty for server initiated changes like retention
+	Data       string              `json:"data" gorm:"type:text;not null;default:'{}'"`
+	CreatedAt  time.Time           `json:"created_at" gorm:"autoCreateTime"`
+	Repo       *ArtifactRepository `json:"-" gorm:"foreignKey:RepoID;constraint:OnDelete:CASCADE"`
+}
+
+// Job status constants
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+type Job struct { // Background work item, a pending job per kind and subject absorbs repeats
+	ID         string     `json:"id" gorm:"primaryKey"`
+	Kind       string     `json:"kind" gorm:"not null;index:idx_job_subject"`
+	Subject    string     `json:"subject" gorm:"not null;default:'';index:idx_job_subject"` // What the job works on, artifact id or repo:<id>
+	Payload    string     `json:"payload" gorm:"type:text;not null;default:'{}'"`
+	Status     string     `json:"status" gorm:"not null;index"`
+	Attempts   int        `json:"attempts" gorm:"not null;default:0"`
+	LastError  string     `json:"last_error" gorm:"type:text;not null;default:'';column:last_error"`
+	RunAt      time.Time  `json:"run_at" gorm:"not null;index;column:run_at"` // Earliest start, pushed out by retry backoff
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+	FinishedAt *time.Time `json:"finished_at" gorm:"index;column:finished_at"`
+}
+
+type ReplicationRule struct { // Copies tags pushed to matching repos of Namespace on to a remote registry
+	ID            string     `json:"id" gorm:"primaryKey"`
+	Namespace     string     `json:"namespace" gorm:"not null;uniqueIndex:idx_replication_ns_name"`
+	Name          string     `json:"name" gorm:"not null;uniqueIndex:idx_replication_ns_name"`
+	RepoPattern   string     `json:"repo_pattern" gorm:"not null;default:'*';column:repo_pattern"` // Repo name glob within the namespace
+	TagPattern    string     `json:"tag_pattern" gorm:"not null;default:'*';column:tag_pattern"`
+	Target        string     `json:"target" gorm:"not null"` // Remote registry host and path prefix, the repo name is appended
+	CredentialID  string     `json:"credential_id" gorm:"not null;default:'';index;column:credential_id"`
+	Enabled       bool       `json:"enabled" gorm:"not null;default:false"`
+	CreatedBy     string     `json:"created_by" gorm:"not null;default:'';column:created_by"`
+	LastRunAt     *time.Time `json:"last_run_at" gorm:"column:last_run_at"`
+	LastSuccessAt *time.Time `json:"last_success_at" gorm:"column:last_success_at"`
+	LastRef       string     `json:"last_ref" gorm:"not null;default:'';column:last_ref"` // name:tag of the last attempt
+	LastDigest    string     `json:"last_digest" gorm:"not null;default:'';column:last_digest"`
+	LastError     string     `json:"last_error" gorm:"type:text;not null;default:'';column:last_error"` // Empty when the last attempt landed
+	Replicated    int64      `json:"replicated" gorm:"not null;default:0"`
+	Failures      int64      `json:"failures" gorm:"not null;default:0"` // Failed attempts, retries included
+	CreatedAt     time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,97 @@
+package stores
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+)
+
+// ── Artifact changelog ───────────────────────────────────────────────────
+
+type actorKey struct{}
+
+// Names who is acting on ctx so changelog rows written under it carry them
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// Artifact as a change saw it
+type ArtifactSnapshot struct {
+	Name       string            `json:"name"`
+	Path       string            `json:"path"`
+	Version    string            `json:"version"`
+	Digest     string            `json:"digest"`
+	Size       int64             `json:"size"`
+	MimeType   string            `json:"mime_type,omitempty"`
+	Metadata   json.RawMessage   `json:"metadata,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// Changelog row payload. Artifact is the state after the change, before
+// it for deletes. Previous is the state a rename or edit replaced, or the
+// row an upload overwrote
+type ArtifactChangeData struct {
+	Artifact *ArtifactSnapshot `json:"artifact"`
+	Previous *ArtifactSnapshot `json:"previous,omitempty"`
+}
+
+func snapshotArtifact(a *db.Artifact, properties map[string]string) *ArtifactSnapshot {
+	snap := &ArtifactSnapshot{
+		Name:       a.Name,
+		Path:       a.Path,
+		Version:    a.Version,
+		Digest:     a.Digest,
+		Size:       a.Size,
+		MimeType:   a.MimeType,
+		Properties: properties,
+	}
+	if a.Metadata != "" && a.Metadata != "{}" && json.Valid([]byte(a.Metadata)) {
+		snap.Metadata = json.RawMessage(a.Metadata)
+	}
+	return snap
+}
+
+func propertiesTx(tx *gorm.DB, artifactID string) (map[string]string, error) {
+	var rows []*db.ArtifactProperty
+	if err := tx.Find(&rows, "artifact_id = ?", artifactID).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(rows))
+	for _, p := range rows {
+		out[p.Key] = p.Value
+	}
+	return out, nil
+}
+
+// Appends in the caller's transaction, the change and its record commit together
+func recordArtifactChangeTx(tx *gorm.DB, repoID int64, artifactID, kind string, data ArtifactChangeData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&db.ArtifactChange{
+		RepoID:     repoID,
+		ArtifactID: artifactID,
+		Kind:       kind,
+		Actor:      actorFrom(tx.Statement.Context),
+		Data:       string(raw),
+	}).Error
+}
+
+// Changes of a repo after the cursor, oldest first
+func (s *Store) ListArtifactChanges(ctx context.Context, repoID, after int64, limit int) ([]*db.ArtifactChange, error) {
+	var changes []*db.ArtifactChange
+	err := s.db.WithContext(ctx).Where("repo_id = ? AND id > ?", repoID, after).
+		Order("id ASC").Limit(limit).Find(&changes).Error
+	return changes, err
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"sort"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/nickheyer/distroface/pkg/pages"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Property update would collide with another artifact
@@ -42,16 +45,30 @@ func (s *Store) CreateArtifactRepository(ctx context.Context, repo *db.ArtifactR
 	return s.db.WithContext(ctx).Create(repo).Error
 }
 
+// Repos served read only under /v2 by the oci bridge
+func (s *Store) ListOCIExportedRepositories(ctx context.Context) ([]*db.ArtifactRepository, error) {
+	var repos []*db.ArtifactRepository
+	err := s.db.WithContext(ctx).Where("oci_export = ?", true).Order("namespace, name").Find(&repos).Error
+	return repos, err
+}
+
+// Exact match first, then ignoring case. Names that only differ in case
+// from before the case policy stay reachable by their exact spelling
 func (s *Store) GetArtifactRepository(ctx context.Context, namespace, name string) (*db.ArtifactRepository, error) {
 	var repo db.ArtifactRepository
 	err := s.db.WithContext(ctx).First(&repo, "namespace = ? AND name = ?", namespace, name).Error
-	if err == gorm.ErrRecordNotFound {
-		return nil, nil
+	if err == nil {
+		return &repo, nil
 	}
-	if err != nil {
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	var folded []*db.ArtifactRepository
+	err = s.db.WithContext(ctx).Where("namespace = ? AND name = ? COLLATE NOCASE", namespace, name).Limit(2).Find(&folded).Error
+	if err != nil || len(folded) != 1 {
 		return nil, err
 	}
-	return &repo, nil
+	return folded[0], nil
 }
 
 type ArtifactRepoListOptions struct {
@@ -137,7 +154,20 @@ func (s *Store) DeleteArtifactRepository(ctx context.Context, id int64) ([]strin
 	if err != nil {
 		return nil, err
 	}
-	if err := s.db.WithContext(ctx).Delete(&db.ArtifactRepository{}, "id = ?", id).Error; err != nil {
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var repo db.ArtifactRepository
+		if err := tx.First(&repo, "id = ?", id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			return err
+		}
+		if err := tx.Delete(&db.ArtifactRepository{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return dropOrphanPermissions(tx, repo.Namespace, repo.Name)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return digests, nil
@@ -174,16 +204,31 @@ func (s *Store) GetArtifactRepoStats(ctx context.Context, repoIDs []int64) (map[
 
 // Inserts replacing same version path properties, returns replaced digest
 func (s *Store) CreateArtifact(ctx context.Context, artifact *db.Artifact, properties map[string]string) (replacedDigest string, err error) {
+	return s.createArtifact(ctx, artifact, properties, nil)
+}
+
+// CreateArtifact that moves the latest pointer of its path onto it in the
+// same transaction. The filter is kept so deletes fall back within it
+func (s *Store) CreateArtifactAsLatest(ctx context.Context, artifact *db.Artifact, properties, filter map[string]string) (replacedDigest string, err error) {
+	if filter == nil {
+		filter = map[string]string{}
+	}
+	return s.createArtifact(ctx, artifact, properties, filter)
+}
+
+func (s *Store) createArtifact(ctx context.Context, artifact *db.Artifact, properties, latest map[string]string) (replacedDigest string, err error) {
 	if artifact.ID == "" {
 		artifact.ID = uuid.New().String()
 	}
 	artifact.PropsHash = PropsFingerprint(properties)
 	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		var existing db.Artifact
+		change := ArtifactChangeData{Artifact: snapshotArtifact(artifact, properties)}
 		findErr := tx.First(&existing, "repo_id = ? AND version = ? AND path = ? AND props_hash = ?",
 			artifact.RepoID, artifact.Version, artifact.Path, artifact.PropsHash).Error
 		if findErr == nil {
 			replacedDigest = existing.Digest
+			change.Previous = snapshotArtifact(&existing, properties)
 			if err := tx.Delete(&db.Artifact{}, "id = ?", existing.ID).Error; err != nil {
 				return err
 			}
@@ -194,7 +239,43 @@ func (s *Store) CreateArtifact(ctx context.Context, artifact *db.Artifact, prope
 		if err := tx.Create(artifact).Error; err != nil {
 			return err
 		}
-		return createPropertiesTx(tx, artifact.ID, properties)
+		if err := createPropertiesTx(tx, artifact.ID, properties); err != nil {
+			return err
+		}
+		if err := recordArtifactChangeTx(tx, artifact.RepoID, artifact.ID, db.ArtifactChangeUpload, change); err != nil {
+			return err
+		}
+		if pkg := artifact.Package; pkg != nil {
+			pkg.ArtifactID, pkg.RepoID = artifact.ID, artifact.RepoID
+			if err := tx.Create(pkg).Error; err != nil {
+				return err
+			}
+		}
+		// A pointer at the replaced row follows its replacement
+		if findErr == nil {
+			if err := tx.Model(&db.ArtifactLatestPointer{}).Where("artifact_id = ?", existing.ID).
+				Updates(map[string]any{"artifact_id": artifact.ID, "digest": artifact.Digest}).Error; err != nil {
+				return err
+			}
+		}
+		if latest == nil {
+			return nil
+		}
+		filter, err := json.Marshal(latest)
+		if err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "repo_id"}, {Name: "path"}},
+			DoUpdates: clause.AssignmentColumns([]string{"artifact_id", "version", "digest", "filter", "updated_at"}),
+		}).Create(&db.ArtifactLatestPointer{
+			RepoID:     artifact.RepoID,
+			Path:       artifact.Path,
+			ArtifactID: artifact.ID,
+			Version:    artifact.Version,
+			Digest:     artifact.Digest,
+			Filter:     string(filter),
+		}).Error
 	})
 	if err != nil {
 		return "", err
@@ -247,6 +328,20 @@ func (s *Store) GetArtifactByPathVersion(ctx context.Context, repoID int64, vers
 	return &artifact, nil
 }
 
+// Every property variant at one version and path, newest first
+func (s *Store) ListArtifactsAtPath(ctx context.Context, repoID int64, version, path string) ([]*db.Artifact, error) {
+	var artifacts []*db.Artifact
+	err := s.db.WithContext(ctx).Order("created_at DESC, id DESC").
+		Find(&artifacts, "repo_id = ? AND version = ? AND path = ?", repoID, version, path).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadArtifactProperties(ctx, artifacts); err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
 // Row matching the full four part identity
 func (s *Store) GetArtifactByIdentity(ctx context.Context, repoID int64, version, path string, properties map[string]string) (*db.Artifact, error) {
 	var artifact db.Artifact
@@ -431,35 +526,102 @@ func (s *Store) SearchArtifacts(ctx context.Context, criteria ArtifactSearchCrit
 	return artifacts, total, nil
 }
 
+// Saves the row, renames and metadata edits land in the changelog
 func (s *Store) UpdateArtifact(ctx context.Context, artifact *db.Artifact) error {
-	return s.db.WithContext(ctx).Save(artifact).Error
-}
-
-// Replaces the full property set, identity hash follows
-func (s *Store) SetArtifactProperties(ctx context.Context, artifactID string, properties map[string]string) error {
-	hash := PropsFingerprint(properties)
 	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		var artifact db.Artifact
-		if err := tx.First(&artifact, "id = ?", artifactID).Error; err != nil {
+		var before db.Artifact
+		if err := tx.First(&before, "id = ?", artifact.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Save(artifact).Error; err != nil {
+			return err
+		}
+		renamed := before.Name != artifact.Name || before.Path != artifact.Path || before.Version != artifact.Version
+		if !renamed && before.Metadata == artifact.Metadata {
+			return nil
+		}
+		properties, err := propertiesTx(tx, artifact.ID)
+		if err != nil {
 			return err
 		}
-		if artifact.PropsHash != hash {
-			var occupied int64
-			if err := tx.Model(&db.Artifact{}).Where("repo_id = ? AND version = ? AND path = ? AND props_hash = ?",
-				artifact.RepoID, artifact.Version, artifact.Path, hash).Count(&occupied).Error; err != nil {
+		after := snapshotArtifact(artifact, properties)
+		if renamed {
+			prev := snapshotArtifact(&before, properties)
+			prev.Metadata = after.Metadata
+			if err := recordArtifactChangeTx(tx, artifact.RepoID, artifact.ID, db.ArtifactChangeRename, ArtifactChangeData{Artifact: after, Previous: prev}); err != nil {
 				return err
 			}
-			if occupied > 0 {
-				return ErrDuplicateIdentity
-			}
-			if err := tx.Model(&db.Artifact{}).Where("id = ?", artifactID).Update("props_hash", hash).Error; err != nil {
+		}
+		if before.Metadata != artifact.Metadata {
+			prev := *after
+			prev.Metadata = snapshotArtifact(&before, nil).Metadata
+			return recordArtifactChangeTx(tx, artifact.RepoID, artifact.ID, db.ArtifactChangeMetadata, ArtifactChangeData{Artifact: after, Previous: &prev})
+		}
+		return nil
+	})
+}
+
+// Replaces the full property set, identity hash follows
+func (s *Store) SetArtifactProperties(ctx context.Context, artifactID string, properties map[string]string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return setArtifactPropertiesTx(tx, artifactID, properties)
+	})
+}
+
+// Replaces property sets of many artifacts in one transaction, any
+// identity collision rolls every change back
+func (s *Store) SetArtifactPropertiesBulk(ctx context.Context, changes map[string]map[string]string) error {
+	ids := make([]string, 0, len(changes))
+	for id := range changes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, id := range ids {
+			if err := setArtifactPropertiesTx(tx, id, changes[id]); err != nil {
 				return err
 			}
 		}
-		if err := tx.Delete(&db.ArtifactProperty{}, "artifact_id = ?", artifactID).Error; err != nil {
+		return nil
+	})
+}
+
+func setArtifactPropertiesTx(tx *gorm.DB, artifactID string, properties map[string]string) error {
+	hash := PropsFingerprint(properties)
+	var artifact db.Artifact
+	if err := tx.First(&artifact, "id = ?", artifactID).Error; err != nil {
+		return err
+	}
+	if artifact.PropsHash != hash {
+		var occupied int64
+		if err := tx.Model(&db.Artifact{}).Where("repo_id = ? AND version = ? AND path = ? AND props_hash = ?",
+			artifact.RepoID, artifact.Version, artifact.Path, hash).Count(&occupied).Error; err != nil {
+			return err
+		}
+		if occupied > 0 {
+			return ErrDuplicateIdentity
+		}
+		if err := tx.Model(&db.Artifact{}).Where("id = ?", artifactID).Update("props_hash", hash).Error; err != nil {
 			return err
 		}
-		return createPropertiesTx(tx, artifactID, properties)
+	}
+	before, err := propertiesTx(tx, artifactID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Delete(&db.ArtifactProperty{}, "artifact_id = ?", artifactID).Error; err != nil {
+		return err
+	}
+	if err := createPropertiesTx(tx, artifactID, properties); err != nil {
+		return err
+	}
+	after, err := propertiesTx(tx, artifactID)
+	if err != nil || maps.Equal(before, after) {
+		return err
+	}
+	return recordArtifactChangeTx(tx, artifact.RepoID, artifactID, db.ArtifactChangeProperties, ArtifactChangeData{
+		Artifact: snapshotArtifact(&artifact, after),
+		Previous: snapshotArtifact(&artifact, before),
 	})
 }
 
@@ -518,8 +680,90 @@ func (s *Store) backfillArtifactRepoNamespace() error {
 }
 
 // Properties cascade with the row
+// Latest pointers at the row fall back to the newest remaining match
 func (s *Store) DeleteArtifact(ctx context.Context, id string) error {
-	return s.db.WithContext(ctx).Delete(&db.Artifact{}, "id = ?", id).Error
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var pointers []*db.ArtifactLatestPointer
+		if err := tx.Find(&pointers, "artifact_id = ?", id).Error; err != nil {
+			return err
+		}
+		var artifact db.Artifact
+		findErr := tx.First(&artifact, "id = ?", id).Error
+		if findErr != nil && findErr != gorm.ErrRecordNotFound {
+			return findErr
+		}
+		properties, err := propertiesTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&db.Artifact{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if findErr == nil {
+			if err := recordArtifactChangeTx(tx, artifact.RepoID, id, db.ArtifactChangeDelete, ArtifactChangeData{Artifact: snapshotArtifact(&artifact, properties)}); err != nil {
+				return err
+			}
+		}
+		for _, p := range pointers {
+			if err := repointLatestTx(tx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ── Latest pointers ──────────────────────────────────────────────────────
+
+func repointLatestTx(tx *gorm.DB, p *db.ArtifactLatestPointer) error {
+	var filter map[string]string
+	if err := json.Unmarshal([]byte(p.Filter), &filter); err != nil {
+		return fmt.Errorf("latest filter of %s: %w", p.Path, err)
+	}
+	q := tx.Where("repo_id = ? AND path = ?", p.RepoID, p.Path)
+	for k, v := range filter {
+		if v == "" {
+			q = q.Where("EXISTS (SELECT 1 FROM artifact_properties p WHERE p.artifact_id = artifacts.id AND p.key = ?)", k)
+		} else {
+			q = q.Where("EXISTS (SELECT 1 FROM artifact_properties p WHERE p.artifact_id = artifacts.id AND p.key = ? AND p.value = ?)", k, v)
+		}
+	}
+	var next db.Artifact
+	err := q.Order("created_at DESC, id DESC").First(&next).Error
+	if err == gorm.ErrRecordNotFound {
+		return tx.Delete(&db.ArtifactLatestPointer{}, "repo_id = ? AND path = ?", p.RepoID, p.Path).Error
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&db.ArtifactLatestPointer{}).Where("repo_id = ? AND path = ?", p.RepoID, p.Path).
+		Updates(map[string]any{"artifact_id": next.ID, "version": next.Version, "digest": next.Digest}).Error
+}
+
+// Artifact the latest pointer of a path names, nil without one. One
+// query so a concurrent swap yields the old or the new row, never neither
+func (s *Store) GetLatestArtifact(ctx context.Context, repoID int64, path string) (*db.Artifact, error) {
+	var artifact db.Artifact
+	err := s.db.WithContext(ctx).
+		Joins("JOIN artifact_latest_pointers l ON l.artifact_id = artifacts.id").
+		Where("l.repo_id = ? AND l.path = ?", repoID, path).
+		First(&artifact).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadArtifactProperties(ctx, []*db.Artifact{&artifact}); err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+func (s *Store) ListLatestPointers(ctx context.Context, repoID int64) ([]*db.ArtifactLatestPointer, error) {
+	var pointers []*db.ArtifactLatestPointer
+	err := s.db.WithContext(ctx).Where("repo_id = ?", repoID).Order("path").Find(&pointers).Error
+	return pointers, err
 }
 
 // ── Blob reference counting ──────────────────────────────────────────────
@@ -533,12 +777,58 @@ func (s *Store) ArtifactUniqueBlobBytes(ctx context.Context) (int64, error) {
 	return total, err
 }
 
+// Unique blob bytes per owner across their artifact repos, owner quotas
+// count against these
+func (s *Store) ArtifactBytesByOwner(ctx context.Context) (map[string]int64, error) {
+	var rows []struct {
+		OwnerID string
+		Bytes   int64
+	}
+	err := s.db.WithContext(ctx).
+		Raw(`SELECT owner_id, SUM(size) AS bytes FROM (
+			SELECT r.owner_id AS owner_id, MAX(a.size) AS size FROM artifacts a
+			JOIN artifact_repositories r ON r.id = a.repo_id
+			GROUP BY r.owner_id, a.digest)
+		GROUP BY owner_id`).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		out[r.OwnerID] = r.Bytes
+	}
+	return out, nil
+}
+
+// Every digest some artifact row still references
+func (s *Store) ListArtifactDigests(ctx context.Context) ([]string, error) {
+	var digests []string
+	err := s.db.WithContext(ctx).Model(&db.Artifact{}).Distinct("digest").Order("digest").Pluck("digest", &digests).Error
+	return digests, err
+}
+
 func (s *Store) CountArtifactsByDigest(ctx context.Context, digest string) (int64, error) {
 	var count int64
 	err := s.db.WithContext(ctx).Model(&db.Artifact{}).Where("digest = ?", digest).Count(&count).Error
 	return count, err
 }
 
+func (s *Store) ArtifactDigestInRepo(ctx context.Context, repoID int64, digest string) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&db.Artifact{}).
+		Where("repo_id = ? AND digest = ?", repoID, digest).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// Repositories holding at least one artifact with this digest
+func (s *Store) ListArtifactReposByDigest(ctx context.Context, digest string) ([]*db.ArtifactRepository, error) {
+	var repos []*db.ArtifactRepository
+	holders := s.db.Model(&db.Artifact{}).Select("repo_id").Where("digest = ?", digest)
+	err := s.db.WithContext(ctx).Where("id IN (?)", holders).Order("id").Find(&repos).Error
+	return repos, err
+}
+
 func (s *Store) ListArtifactDigestsByRepo(ctx context.Context, repoID int64) ([]string, error) {
 	var digests []string
 	err := s.db.WithContext(ctx).Model(&db.Artifact{}).
@@ -546,6 +836,46 @@ func (s *Store) ListArtifactDigestsByRepo(ctx context.Context, repoID int64) ([]
 	return digests, err
 }
 
+// ── Scan results ─────────────────────────────────────────────────────────
+
+// Records a scan verdict without touching updated_at
+func (s *Store) SetArtifactScan(ctx context.Context, id, status, engine, result string, at *time.Time) error {
+	return s.db.WithContext(ctx).Model(&db.Artifact{}).Where("id = ?", id).UpdateColumns(map[string]any{
+		"scan_status": status,
+		"scan_engine": engine,
+		"scan_result": result,
+		"scanned_at":  at,
+	}).Error
+}
+
+// Nil clears the expiry
+func (s *Store) SetArtifactExpiry(ctx context.Context, id string, at *time.Time) error {
+	return s.db.WithContext(ctx).Model(&db.Artifact{}).Where("id = ?", id).UpdateColumn("expires_at", at).Error
+}
+
+// Artifacts whose expiry passed by now, soonest first
+func (s *Store) ListExpiredArtifacts(ctx context.Context, now time.Time, limit int) ([]*db.Artifact, error) {
+	var artifacts []*db.Artifact
+	err := s.db.WithContext(ctx).Where("expires_at IS NOT NULL AND expires_at <= ?", now).
+		Order("expires_at, id").Limit(limit).Find(&artifacts).Error
+	return artifacts, err
+}
+
+// Artifacts still waiting on a scan, oldest first
+func (s *Store) ListArtifactsByScanStatus(ctx context.Context, status string) ([]*db.Artifact, error) {
+	var artifacts []*db.Artifact
+	err := s.db.WithContext(ctx).Where("scan_status = ?", status).Order("created_at, id").Find(&artifacts).Error
+	return artifacts, err
+}
+
+// Distinct scan states of the rows sharing a blob
+func (s *Store) ArtifactDigestScanStatuses(ctx context.Context, digest string) ([]string, error) {
+	var statuses []string
+	err := s.db.WithContext(ctx).Model(&db.Artifact{}).
+		Distinct("scan_status").Where("digest = ?", digest).Pluck("scan_status", &statuses).Error
+	return statuses, err
+}
+
 // ── Helpers ──────────────────────────────────────────────────────────────
 
 func (s *Store) loadArtifactProperties(ctx context.Context, artifacts []*db.Artifact) error {
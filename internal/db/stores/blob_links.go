@@ -0,0 +1,87 @@
+package stores
+
+import (
+	"context"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ── Blob link index ──────────────────────────────────────────────────────
+
+// Keeps IN lists under sqlite's variable limit
+const blobLinkChunk = 500
+
+func (s *Store) AddBlobLink(ctx context.Context, digest, repo, kind string) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&db.BlobLink{Digest: digest, Repo: repo, Kind: kind}).Error
+}
+
+func (s *Store) RemoveBlobLink(ctx context.Context, digest, repo, kind string) error {
+	return s.db.WithContext(ctx).Delete(&db.BlobLink{}, "digest = ? AND repo = ? AND kind = ?", digest, repo, kind).Error
+}
+
+func (s *Store) RemoveRepoBlobLinks(ctx context.Context, repo string) error {
+	return s.db.WithContext(ctx).Delete(&db.BlobLink{}, "repo = ?", repo).Error
+}
+
+// Drops the links of every repository under a namespace
+func (s *Store) RemoveNamespaceBlobLinks(ctx context.Context, namespace string) error {
+	prefix := namespace + "/"
+	return s.db.WithContext(ctx).Delete(&db.BlobLink{}, "substr(repo, 1, ?) = ?", len(prefix), prefix).Error
+}
+
+// Gives dst the links of src, forks share every blob of their source
+func (s *Store) CopyBlobLinks(ctx context.Context, src, dst string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&db.BlobLink{}, "repo = ?", dst).Error; err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO blob_links (digest, repo, kind, created_at) SELECT digest, ?, kind, ? FROM blob_links WHERE repo = ?",
+			dst, time.Now(), src).Error
+	})
+}
+
+// Distinct digests a repository links
+func (s *Store) BlobLinkDigests(ctx context.Context, repo string) ([]string, error) {
+	var digests []string
+	err := s.db.WithContext(ctx).Model(&db.BlobLink{}).Where("repo = ?", repo).Distinct().Pluck("digest", &digests).Error
+	return digests, err
+}
+
+// Repositories linking each digest, sorted and without duplicates
+func (s *Store) BlobLinkRepos(ctx context.Context, digests []string) (map[string][]string, error) {
+	out := make(map[string][]string, len(digests))
+	for start := 0; start < len(digests); start += blobLinkChunk {
+		end := min(start+blobLinkChunk, len(digests))
+		var rows []struct {
+			Digest string
+			Repo   string
+		}
+		err := s.db.WithContext(ctx).Model(&db.BlobLink{}).Distinct("digest", "repo").
+			Where("digest IN ?", digests[start:end]).Order("digest, repo").Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			out[r.Digest] = append(out[r.Digest], r.Repo)
+		}
+	}
+	return out, nil
+}
+
+// Replaces links older than since with a storage snapshot taken after it.
+// Links recorded while the snapshot was taken survive the swap
+func (s *Store) SyncBlobLinks(ctx context.Context, links []db.BlobLink, since time.Time) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&db.BlobLink{}, "created_at < ?", since).Error; err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(links, blobLinkChunk).Error
+	})
+}
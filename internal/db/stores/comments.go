@@ -0,0 +1,72 @@
+package stores
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ── Comment operations ────────────────────────────────────────────────────
+
+// What a comment hangs off, exactly one repo id is set
+type CommentTarget struct {
+	RepoID         string
+	ArtifactRepoID int64
+	Ref            string
+}
+
+func (t CommentTarget) scope(tx *gorm.DB) *gorm.DB {
+	if t.RepoID != "" {
+		return tx.Where("repo_id = ? AND ref = ?", t.RepoID, t.Ref)
+	}
+	return tx.Where("artifact_repo_id = ? AND ref = ?", t.ArtifactRepoID, t.Ref)
+}
+
+func (s *Store) CreateComment(ctx context.Context, c *db.Comment) error {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	return s.db.WithContext(ctx).Create(c).Error
+}
+
+func (s *Store) GetComment(ctx context.Context, id string) (*db.Comment, error) {
+	var c db.Comment
+	err := s.db.WithContext(ctx).Preload("Repo").Preload("ArtifactRepo").First(&c, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Oldest first so threads read top down
+func (s *Store) ListComments(ctx context.Context, target CommentTarget, limit, offset int) ([]*db.Comment, int64, error) {
+	tx := target.scope(s.db.WithContext(ctx).Model(&db.Comment{}))
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var comments []*db.Comment
+	err := tx.Order("created_at ASC, id ASC").Limit(limit).Offset(offset).Find(&comments).Error
+	return comments, total, err
+}
+
+// Writes a new body and refreshes the edit time on c
+func (s *Store) UpdateCommentBody(ctx context.Context, c *db.Comment, body string) error {
+	if err := s.db.WithContext(ctx).Model(c).Omit(clause.Associations).Update("body", body).Error; err != nil {
+		return err
+	}
+	c.Body = body
+	return nil
+}
+
+func (s *Store) DeleteComment(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Delete(&db.Comment{}, "id = ?", id).Error
+}
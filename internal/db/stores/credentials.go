@@ -0,0 +1,67 @@
+package stores
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+)
+
+// ── Credential operations ─────────────────────────────────────────────────
+
+func (s *Store) CreateCredential(ctx context.Context, cred *db.Credential) error {
+	if cred.ID == "" {
+		cred.ID = uuid.New().String()
+	}
+	return s.db.WithContext(ctx).Create(cred).Error
+}
+
+func (s *Store) GetCredential(ctx context.Context, id string) (*db.Credential, error) {
+	var cred db.Credential
+	err := s.db.WithContext(ctx).First(&cred, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cred, nil
+}
+
+func (s *Store) GetCredentialByName(ctx context.Context, namespace, name string) (*db.Credential, error) {
+	var cred db.Credential
+	err := s.db.WithContext(ctx).First(&cred, "namespace = ? AND name = ?", namespace, name).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// Nil namespaces lists every namespace, admin views only
+func (s *Store) ListCredentials(ctx context.Context, namespaces []string, limit, offset int) ([]*db.Credential, int64, error) {
+	tx := s.db.WithContext(ctx).Model(&db.Credential{})
+	if namespaces != nil {
+		tx = tx.Where("namespace IN ?", namespaces)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var creds []*db.Credential
+	err := tx.Order("namespace ASC, name ASC").Limit(limit).Offset(offset).Find(&creds).Error
+	return creds, total, err
+}
+
+func (s *Store) UpdateCredential(ctx context.Context, cred *db.Credential) error {
+	return s.db.WithContext(ctx).Save(cred).Error
+}
+
+func (s *Store) DeleteCredential(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Delete(&db.Credential{}, "id = ?", id).Error
+}
@@ -0,0 +1,56 @@
+package stores
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+)
+
+// ── Freeze windows ───────────────────────────────────────────────────────
+
+func (s *Store) CreateFreezeWindow(ctx context.Context, w *db.FreezeWindow) error {
+	if w.ID == "" {
+		w.ID = uuid.New().String()
+	}
+	return s.db.WithContext(ctx).Create(w).Error
+}
+
+func (s *Store) GetFreezeWindow(ctx context.Context, id string) (*db.FreezeWindow, error) {
+	var w db.FreezeWindow
+	err := s.db.WithContext(ctx).First(&w, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+// Soonest first, windows over by now are left out unless past is set
+func (s *Store) ListFreezeWindows(ctx context.Context, now time.Time, past bool) ([]*db.FreezeWindow, error) {
+	tx := s.db.WithContext(ctx)
+	if !past {
+		tx = tx.Where("ends_at IS NULL OR ends_at > ?", now)
+	}
+	var windows []*db.FreezeWindow
+	err := tx.Order("starts_at ASC, id ASC").Find(&windows).Error
+	return windows, err
+}
+
+// Windows running at now, checked on every push
+func (s *Store) ActiveFreezeWindows(ctx context.Context, now time.Time) ([]*db.FreezeWindow, error) {
+	var windows []*db.FreezeWindow
+	err := s.db.WithContext(ctx).
+		Where("starts_at <= ? AND (ends_at IS NULL OR ends_at > ?)", now, now).
+		Order("starts_at ASC, id ASC").Find(&windows).Error
+	return windows, err
+}
+
+func (s *Store) DeleteFreezeWindow(ctx context.Context, id string) (bool, error) {
+	res := s.db.WithContext(ctx).Delete(&db.FreezeWindow{}, "id = ?", id)
+	return res.RowsAffected > 0, res.Error
+}
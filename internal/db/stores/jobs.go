@@ -0,0 +1,179 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/pkg/pages"
+	"gorm.io/gorm"
+)
+
+// ── Background jobs ──────────────────────────────────────────────────────
+
+// Queues a job, a pending one of the same kind and subject is returned
+// instead so bursts of uploads to a repo run its follow-up work once
+func (s *Store) EnqueueJob(ctx context.Context, job *db.Job) (*db.Job, error) {
+	if job.Subject != "" {
+		var existing db.Job
+		err := s.db.WithContext(ctx).
+			Where("kind = ? AND subject = ? AND status = ?", job.Kind, job.Subject, db.JobPending).
+			First(&existing).Error
+		if err == nil {
+			return &existing, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	if job.Payload == "" {
+		job.Payload = "{}"
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	job.Status = db.JobPending
+	return job, s.db.WithContext(ctx).Create(job).Error
+}
+
+func (s *Store) GetJob(ctx context.Context, id string) (*db.Job, error) {
+	var job db.Job
+	err := s.db.WithContext(ctx).First(&job, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Marks the oldest due job of the given kinds running, nil when none is due
+func (s *Store) ClaimJob(ctx context.Context, kinds []string, now time.Time) (*db.Job, error) {
+	for {
+		var job db.Job
+		err := s.db.WithContext(ctx).
+			Where("status = ? AND run_at <= ? AND kind IN ?", db.JobPending, now, kinds).
+			Order("run_at ASC, created_at ASC").First(&job).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		res := s.db.WithContext(ctx).Model(&db.Job{}).
+			Where("id = ? AND status = ?", job.ID, db.JobPending).
+			Updates(map[string]any{"status": db.JobRunning, "attempts": gorm.Expr("attempts + 1")})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		// Another worker got there first, look again
+		if res.RowsAffected == 0 {
+			continue
+		}
+		job.Status = db.JobRunning
+		job.Attempts++
+		return &job, nil
+	}
+}
+
+func (s *Store) FinishJob(ctx context.Context, id string, now time.Time) error {
+	return s.db.WithContext(ctx).Model(&db.Job{}).Where("id = ?", id).
+		Updates(map[string]any{"status": db.JobDone, "last_error": "", "finished_at": now}).Error
+}
+
+// Puts a failed attempt back in the queue to start no earlier than runAt
+func (s *Store) RetryJobAt(ctx context.Context, id, lastError string, runAt time.Time) error {
+	return s.db.WithContext(ctx).Model(&db.Job{}).Where("id = ?", id).
+		Updates(map[string]any{"status": db.JobPending, "last_error": lastError, "run_at": runAt}).Error
+}
+
+func (s *Store) FailJob(ctx context.Context, id, lastError string, now time.Time) error {
+	return s.db.WithContext(ctx).Model(&db.Job{}).Where("id = ?", id).
+		Updates(map[string]any{"status": db.JobFailed, "last_error": lastError, "finished_at": now}).Error
+}
+
+// Gives a failed job a fresh set of attempts, false when it is not failed
+func (s *Store) ResetJob(ctx context.Context, id string, now time.Time) (bool, error) {
+	res := s.db.WithContext(ctx).Model(&db.Job{}).Where("id = ? AND status = ?", id, db.JobFailed).
+		Updates(map[string]any{"status": db.JobPending, "attempts": 0, "run_at": now, "finished_at": nil})
+	return res.RowsAffected > 0, res.Error
+}
+
+// Makes a pending job due at now, false when it is not pending
+func (s *Store) ExpediteJob(ctx context.Context, id string, now time.Time) (bool, error) {
+	res := s.db.WithContext(ctx).Model(&db.Job{}).Where("id = ? AND status = ?", id, db.JobPending).
+		Update("run_at", now)
+	return res.RowsAffected > 0, res.Error
+}
+
+// Jobs a crash cut off mid run go back to pending, call before workers start
+func (s *Store) RequeueRunningJobs(ctx context.Context) (int64, error) {
+	res := s.db.WithContext(ctx).Model(&db.Job{}).Where("status = ?", db.JobRunning).
+		Update("status", db.JobPending)
+	return res.RowsAffected, res.Error
+}
+
+// JobsQuery allowlists job list filters
+var JobsQuery = pages.Spec{
+	Fields: map[string]string{
+		"kind":    "kind",
+		"status":  "status",
+		"subject": "subject",
+	},
+	Text: []string{"kind", "subject", "last_error"},
+}
+
+func (s *Store) ListJobs(ctx context.Context, q pages.Query, limit, offset int) ([]*db.Job, int64, error) {
+	tx := s.db.WithContext(ctx).Model(&db.Job{}).Scopes(JobsQuery.Scope(q))
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var jobs []*db.Job
+	err := tx.Order("created_at DESC").Limit(limit).Offset(offset).Find(&jobs).Error
+	return jobs, total, err
+}
+
+// Pending and running job counts
+func (s *Store) CountQueuedJobs(ctx context.Context) (pending, running int64, err error) {
+	var rows []struct {
+		Status string
+		N      int64
+	}
+	err = s.db.WithContext(ctx).Model(&db.Job{}).Select("status, COUNT(*) AS n").
+		Where("status IN ?", []string{db.JobPending, db.JobRunning}).Group("status").Scan(&rows).Error
+	for _, r := range rows {
+		if r.Status == db.JobPending {
+			pending = r.N
+		} else {
+			running = r.N
+		}
+	}
+	return pending, running, err
+}
+
+// Drops finished jobs, failed ones included, that ended before cutoff
+func (s *Store) PruneJobs(ctx context.Context, cutoff time.Time) (int64, error) {
+	res := s.db.WithContext(ctx).
+		Where("status IN ? AND finished_at < ?", []string{db.JobDone, db.JobFailed}, cutoff).
+		Delete(&db.Job{})
+	return res.RowsAffected, res.Error
+}
+
+// Unfinished and failed jobs of a kind whose subject starts with prefix,
+// newest first
+func (s *Store) ListOpenJobs(ctx context.Context, kind, prefix string) ([]*db.Job, error) {
+	var jobs []*db.Job
+	err := s.db.WithContext(ctx).
+		Where("kind = ? AND subject LIKE ? AND status IN ?", kind, prefix+"%", []string{db.JobPending, db.JobRunning, db.JobFailed}).
+		Order("created_at DESC").Find(&jobs).Error
+	return jobs, err
+}
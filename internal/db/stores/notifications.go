@@ -0,0 +1,176 @@
+package stores
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ── Watch operations ─────────────────────────────────────────────────────
+
+// Creates the watch or replaces the events of an existing one
+func (s *Store) UpsertWatch(ctx context.Context, w *db.Watch) error {
+	if w.ID == "" {
+		w.ID = uuid.New().String()
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "repo_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"events"}),
+	}).Create(w).Error
+}
+
+func (s *Store) GetWatch(ctx context.Context, userID, repoID string) (*db.Watch, error) {
+	var w db.Watch
+	err := s.db.WithContext(ctx).First(&w, "user_id = ? AND repo_id = ?", userID, repoID).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *Store) DeleteWatch(ctx context.Context, userID, repoID string) error {
+	return s.db.WithContext(ctx).Where("user_id = ? AND repo_id = ?", userID, repoID).Delete(&db.Watch{}).Error
+}
+
+// Newest first, with the repo loaded
+func (s *Store) ListWatches(ctx context.Context, userID string, limit, offset int) ([]*db.Watch, int64, error) {
+	tx := s.db.WithContext(ctx).Model(&db.Watch{}).Where("user_id = ?", userID)
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var watches []*db.Watch
+	err := tx.Preload("Repo").Order("created_at DESC, id ASC").Limit(limit).Offset(offset).Find(&watches).Error
+	return watches, total, err
+}
+
+// Every watch on a repo, with the watcher loaded
+func (s *Store) ListRepoWatchers(ctx context.Context, repoID string) ([]*db.Watch, error) {
+	var watches []*db.Watch
+	err := s.db.WithContext(ctx).Preload("User").Where("repo_id = ?", repoID).Find(&watches).Error
+	return watches, err
+}
+
+// Users whose name is one of names, across every auth provider
+func (s *Store) ListUsersByUsernames(ctx context.Context, names []string) ([]*db.User, error) {
+	var users []*db.User
+	if len(names) == 0 {
+		return users, nil
+	}
+	err := s.db.WithContext(ctx).Where("username IN ?", names).Find(&users).Error
+	return users, err
+}
+
+// ── Notification preferences ─────────────────────────────────────────────
+
+// Nil when the user never saved any
+func (s *Store) GetNotificationPreference(ctx context.Context, userID string) (*db.NotificationPreference, error) {
+	var p db.NotificationPreference
+	err := s.db.WithContext(ctx).First(&p, "user_id = ?", userID).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Store) SaveNotificationPreference(ctx context.Context, p *db.NotificationPreference) error {
+	return s.db.WithContext(ctx).Omit(clause.Associations).Save(p).Error
+}
+
+// Stamps the digest send time without touching UpdatedAt
+func (s *Store) SetNotificationDigestSent(ctx context.Context, userID string, at time.Time) error {
+	return s.db.WithContext(ctx).Model(&db.NotificationPreference{}).
+		Where("user_id = ?", userID).
+		UpdateColumn("last_digest_at", at).Error
+}
+
+// ── Notification operations ──────────────────────────────────────────────
+
+func (s *Store) CreateNotifications(ctx context.Context, ns []*db.Notification) error {
+	if len(ns) == 0 {
+		return nil
+	}
+	for _, n := range ns {
+		if n.ID == "" {
+			n.ID = uuid.New().String()
+		}
+	}
+	return s.db.WithContext(ctx).Omit(clause.Associations).Create(ns).Error
+}
+
+// Newest first, plus the user's unread count
+func (s *Store) ListNotifications(ctx context.Context, userID string, unreadOnly bool, limit, offset int) ([]*db.Notification, int64, int64, error) {
+	tx := s.db.WithContext(ctx).Model(&db.Notification{}).Where("user_id = ?", userID)
+
+	var unread int64
+	if err := tx.Session(&gorm.Session{}).Where("read = ?", false).Count(&unread).Error; err != nil {
+		return nil, 0, 0, err
+	}
+	if unreadOnly {
+		tx = tx.Where("read = ?", false)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, 0, err
+	}
+
+	var ns []*db.Notification
+	err := tx.Order("created_at DESC, id ASC").Limit(limit).Offset(offset).Find(&ns).Error
+	return ns, total, unread, err
+}
+
+// Marks the user's entries read, every unread one when ids is empty
+func (s *Store) MarkNotificationsRead(ctx context.Context, userID string, ids []string) (int64, error) {
+	tx := s.db.WithContext(ctx).Model(&db.Notification{}).Where("user_id = ? AND read = ?", userID, false)
+	if len(ids) > 0 {
+		tx = tx.Where("id IN ?", ids)
+	}
+	res := tx.UpdateColumn("read", true)
+	return res.RowsAffected, res.Error
+}
+
+// Oldest first, the entries not yet mailed or posted
+func (s *Store) ListUndeliveredNotifications(ctx context.Context, userID string) ([]*db.Notification, error) {
+	var ns []*db.Notification
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND delivered = ?", userID, false).
+		Order("created_at ASC, id ASC").Find(&ns).Error
+	return ns, err
+}
+
+// Users with anything waiting on a digest
+func (s *Store) ListUndeliveredNotificationUsers(ctx context.Context) ([]string, error) {
+	var ids []string
+	err := s.db.WithContext(ctx).Model(&db.Notification{}).
+		Where("delivered = ?", false).
+		Distinct().Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+func (s *Store) MarkNotificationsDelivered(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Model(&db.Notification{}).
+		Where("id IN ?", ids).
+		UpdateColumn("delivered", true).Error
+}
+
+// Removes read entries created before cutoff
+func (s *Store) PruneNotifications(ctx context.Context, before time.Time) (int64, error) {
+	res := s.db.WithContext(ctx).Where("read = ? AND created_at < ?", true, before).Delete(&db.Notification{})
+	return res.RowsAffected, res.Error
+}
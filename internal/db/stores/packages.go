@@ -0,0 +1,68 @@
+package stores
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+)
+
+// ── Package indexes ──────────────────────────────────────────────────────
+
+// Package header with the artifact fields its index entry needs
+type PackageEntry struct {
+	db.ArtifactPackage
+	Digest     string
+	Path       string
+	Size       int64
+	UploadedAt time.Time
+}
+
+// Every package of a debian or rpm repo, ordered by path
+func (s *Store) ListArtifactPackages(ctx context.Context, repoID int64) ([]*PackageEntry, error) {
+	var entries []*PackageEntry
+	err := s.db.WithContext(ctx).Table("artifact_packages AS p").
+		Select("p.*, a.digest, a.path, a.size, a.created_at AS uploaded_at").
+		Joins("JOIN artifacts a ON a.id = p.artifact_id").
+		Where("p.repo_id = ?", repoID).
+		Order("a.path ASC, a.id ASC").
+		Scan(&entries).Error
+	return entries, err
+}
+
+// Changes whenever a package of the repo lands or goes, cheap enough to
+// check on every index request
+func (s *Store) ArtifactPackagesStamp(ctx context.Context, repoID int64) (string, error) {
+	var row struct {
+		Count int64
+		Last  string
+	}
+	err := s.db.WithContext(ctx).Model(&db.ArtifactPackage{}).
+		Select("COUNT(*) AS count, COALESCE(MAX(created_at), '') AS last").
+		Where("repo_id = ?", repoID).
+		Scan(&row).Error
+	if err != nil {
+		return "", err
+	}
+	return row.Last + "/" + strconv.FormatInt(row.Count, 10), nil
+}
+
+// Newest package artifact at a path, nil when none. Pool and Packages/
+// paths carry no version, the package at the path is the one served
+func (s *Store) GetPackageArtifactByPath(ctx context.Context, repoID int64, path string) (*db.Artifact, error) {
+	var artifact db.Artifact
+	err := s.db.WithContext(ctx).
+		Where("repo_id = ? AND path = ?", repoID, path).
+		Where("EXISTS (SELECT 1 FROM artifact_packages p WHERE p.artifact_id = artifacts.id)").
+		Order("created_at DESC, id DESC").
+		First(&artifact).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
@@ -0,0 +1,129 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ── Packed registry links ────────────────────────────────────────────────
+
+// Bounds of every path below dir, '0' sorts right after '/' so the primary
+// key index answers prefix scans
+func packedRange(dir string) (string, string) {
+	lo := strings.TrimSuffix(dir, "/") + "/"
+	return lo, lo[:len(lo)-1] + "0"
+}
+
+func (s *Store) GetPackedLink(ctx context.Context, path string) (*db.PackedLink, error) {
+	var l db.PackedLink
+	err := s.db.WithContext(ctx).First(&l, "path = ?", path).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Inserts or replaces links, batched under sqlite's variable limit
+func (s *Store) PutPackedLinks(ctx context.Context, links []db.PackedLink) error {
+	if len(links) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "path"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content", "mod_time"}),
+	}).CreateInBatches(links, blobLinkChunk).Error
+}
+
+func (s *Store) PutPackedLink(ctx context.Context, path string, content []byte, modTime time.Time) error {
+	return s.PutPackedLinks(ctx, []db.PackedLink{{Path: path, Content: content, ModTime: modTime}})
+}
+
+// Removes the link at path and every link below it
+func (s *Store) DeletePackedLinks(ctx context.Context, path string) (int64, error) {
+	lo, hi := packedRange(path)
+	res := s.db.WithContext(ctx).Delete(&db.PackedLink{}, "path = ? OR (path >= ? AND path < ?)", path, lo, hi)
+	return res.RowsAffected, res.Error
+}
+
+// Removes exactly the given links
+func (s *Store) DeletePackedLinkPaths(ctx context.Context, paths []string) error {
+	for start := 0; start < len(paths); start += blobLinkChunk {
+		end := min(start+blobLinkChunk, len(paths))
+		if err := s.db.WithContext(ctx).Delete(&db.PackedLink{}, "path IN ?", paths[start:end]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renames one link, replacing whatever dst held. False when src is missing
+func (s *Store) MovePackedLink(ctx context.Context, src, dst string) (bool, error) {
+	moved := false
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&db.PackedLink{}, "path = ?", dst).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&db.PackedLink{}).Where("path = ?", src).Update("path", dst)
+		moved = res.RowsAffected > 0
+		return res.Error
+	})
+	return moved, err
+}
+
+// Replaces the links below dst with copies of those below src
+func (s *Store) CopyPackedLinks(ctx context.Context, src, dst string) (int64, error) {
+	srcLo, srcHi := packedRange(src)
+	dstLo, dstHi := packedRange(dst)
+	var copied int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&db.PackedLink{}, "path >= ? AND path < ?", dstLo, dstHi).Error; err != nil {
+			return err
+		}
+		res := tx.Exec("INSERT INTO packed_links (path, content, mod_time) SELECT ? || substr(path, ?), content, ? FROM packed_links WHERE path >= ? AND path < ?",
+			dstLo, len(srcLo)+1, time.Now(), srcLo, srcHi)
+		copied = res.RowsAffected
+		return res.Error
+	})
+	return copied, err
+}
+
+// Names of the direct children of dir, links and the directories holding them
+func (s *Store) PackedLinkChildren(ctx context.Context, dir string) ([]string, error) {
+	lo, hi := packedRange(dir)
+	var names []string
+	err := s.db.WithContext(ctx).Raw(`SELECT DISTINCT CASE WHEN instr(rest, '/') > 0 THEN substr(rest, 1, instr(rest, '/') - 1) ELSE rest END
+		FROM (SELECT substr(path, ?) AS rest FROM packed_links WHERE path >= ? AND path < ?)`,
+		len(lo)+1, lo, hi).Scan(&names).Error
+	return names, err
+}
+
+func (s *Store) HasPackedLinks(ctx context.Context, dir string) (bool, error) {
+	lo, hi := packedRange(dir)
+	var found int
+	err := s.db.WithContext(ctx).Raw("SELECT 1 FROM packed_links WHERE path >= ? AND path < ? LIMIT 1", lo, hi).Scan(&found).Error
+	return found == 1, err
+}
+
+// Every link path below dir
+func (s *Store) PackedLinkPaths(ctx context.Context, dir string) ([]string, error) {
+	lo, hi := packedRange(dir)
+	var paths []string
+	err := s.db.WithContext(ctx).Model(&db.PackedLink{}).Where("path >= ? AND path < ?", lo, hi).Order("path").Pluck("path", &paths).Error
+	return paths, err
+}
+
+// One page of links in path order, resumed after the last path seen
+func (s *Store) ListPackedLinks(ctx context.Context, after string, limit int) ([]db.PackedLink, error) {
+	var links []db.PackedLink
+	err := s.db.WithContext(ctx).Where("path > ?", after).Order("path").Limit(limit).Find(&links).Error
+	return links, err
+}
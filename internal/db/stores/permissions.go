@@ -0,0 +1,78 @@
+package stores
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ── Repository ACLs ──────────────────────────────────────────────────────
+
+// Upserts the grant of one user or role on a repo path
+func (s *Store) SetRepositoryPermission(ctx context.Context, p *db.RepositoryPermission) error {
+	if p.ID == "" {
+		p.ID = uuid.New().String()
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "namespace"}, {Name: "name"}, {Name: "user_id"}, {Name: "role_name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"level", "granted_by", "updated_at"}),
+	}).Create(p).Error
+}
+
+// Grants on a repo path, roles first then users in grant order
+func (s *Store) ListRepositoryPermissions(ctx context.Context, namespace, name string) ([]*db.RepositoryPermission, error) {
+	var rows []*db.RepositoryPermission
+	err := s.db.WithContext(ctx).Where("namespace = ? AND name = ?", namespace, name).
+		Order("role_name DESC, created_at ASC").Find(&rows).Error
+	return rows, err
+}
+
+// Exactly one of userID and roleName is set
+func (s *Store) DeleteRepositoryPermission(ctx context.Context, namespace, name, userID, roleName string) (bool, error) {
+	res := s.db.WithContext(ctx).Delete(&db.RepositoryPermission{},
+		"namespace = ? AND name = ? AND user_id = ? AND role_name = ?", namespace, name, userID, roleName)
+	return res.RowsAffected > 0, res.Error
+}
+
+// Levels granted on a repo path to the user directly or through any of roles
+func (s *Store) RepositoryPermissionLevels(ctx context.Context, namespace, name, userID string, roles []string) ([]string, error) {
+	var levels []string
+	err := s.db.WithContext(ctx).Model(&db.RepositoryPermission{}).
+		Where("namespace = ? AND name = ?", namespace, name).
+		Where(permissionSubject(s.db, userID, roles)).
+		Pluck("level", &levels).Error
+	return levels, err
+}
+
+// Repo paths as namespace/name with any grant to the user or roles, for listings
+func (s *Store) ListPermittedRepositories(ctx context.Context, userID string, roles []string) ([]string, error) {
+	var paths []string
+	err := s.db.WithContext(ctx).Model(&db.RepositoryPermission{}).
+		Where(permissionSubject(s.db, userID, roles)).
+		Distinct().Pluck("namespace || '/' || name", &paths).Error
+	return paths, err
+}
+
+func permissionSubject(tx *gorm.DB, userID string, roles []string) *gorm.DB {
+	cond := tx.Where("user_id = ? AND user_id <> ''", userID)
+	if len(roles) > 0 {
+		cond = cond.Or("role_name IN ?", roles)
+	}
+	return cond
+}
+
+// Grants outlive one of the image and artifact repos at a path, not both,
+// so a repo made later under a freed path starts without them
+func dropOrphanPermissions(tx *gorm.DB, namespace, name string) error {
+	var n int64
+	if err := tx.Model(&db.Repository{}).Where("namespace = ? AND name = ?", namespace, name).Count(&n).Error; err != nil || n > 0 {
+		return err
+	}
+	if err := tx.Model(&db.ArtifactRepository{}).Where("namespace = ? AND name = ?", namespace, name).Count(&n).Error; err != nil || n > 0 {
+		return err
+	}
+	return tx.Delete(&db.RepositoryPermission{}, "namespace = ? AND name = ?", namespace, name).Error
+}
@@ -0,0 +1,94 @@
+package stores
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+)
+
+// ── Replication rules ────────────────────────────────────────────────────
+
+func (s *Store) CreateReplicationRule(ctx context.Context, r *db.ReplicationRule) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return s.db.WithContext(ctx).Create(r).Error
+}
+
+func (s *Store) GetReplicationRule(ctx context.Context, id string) (*db.ReplicationRule, error) {
+	var r db.ReplicationRule
+	err := s.db.WithContext(ctx).First(&r, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *Store) GetReplicationRuleByName(ctx context.Context, namespace, name string) (*db.ReplicationRule, error) {
+	var r db.ReplicationRule
+	err := s.db.WithContext(ctx).First(&r, "namespace = ? AND name = ?", namespace, name).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Rules of the given namespaces, nil lists every namespace
+func (s *Store) ListReplicationRules(ctx context.Context, namespaces []string) ([]*db.ReplicationRule, error) {
+	tx := s.db.WithContext(ctx)
+	if namespaces != nil {
+		tx = tx.Where("namespace IN ?", namespaces)
+	}
+	var rules []*db.ReplicationRule
+	err := tx.Order("namespace ASC, name ASC").Find(&rules).Error
+	return rules, err
+}
+
+// Enabled rules of a namespace, checked on every tagged push
+func (s *Store) EnabledReplicationRules(ctx context.Context, namespace string) ([]*db.ReplicationRule, error) {
+	var rules []*db.ReplicationRule
+	err := s.db.WithContext(ctx).Where("namespace = ? AND enabled = ?", namespace, true).
+		Order("name ASC").Find(&rules).Error
+	return rules, err
+}
+
+// Rules pushing with the credential
+func (s *Store) ReplicationRulesByCredential(ctx context.Context, credentialID string) ([]*db.ReplicationRule, error) {
+	var rules []*db.ReplicationRule
+	err := s.db.WithContext(ctx).Where("credential_id = ?", credentialID).
+		Order("namespace ASC, name ASC").Find(&rules).Error
+	return rules, err
+}
+
+// Saves the configurable columns, status columns belong to the worker
+func (s *Store) UpdateReplicationRule(ctx context.Context, r *db.ReplicationRule) error {
+	return s.db.WithContext(ctx).Model(r).
+		Select("repo_pattern", "tag_pattern", "target", "credential_id", "enabled", "updated_at").
+		Updates(r).Error
+}
+
+func (s *Store) DeleteReplicationRule(ctx context.Context, id string) (bool, error) {
+	res := s.db.WithContext(ctx).Delete(&db.ReplicationRule{}, "id = ?", id)
+	return res.RowsAffected > 0, res.Error
+}
+
+// Records one replication attempt, an empty errMsg means it landed
+func (s *Store) RecordReplication(ctx context.Context, id, ref, digest, errMsg string, at time.Time) error {
+	updates := map[string]any{"last_run_at": at, "last_ref": ref, "last_digest": digest, "last_error": errMsg}
+	if errMsg == "" {
+		updates["last_success_at"] = at
+		updates["replicated"] = gorm.Expr("replicated + 1")
+	} else {
+		updates["failures"] = gorm.Expr("failures + 1")
+	}
+	return s.db.WithContext(ctx).Model(&db.ReplicationRule{}).Where("id = ?", id).Updates(updates).Error
+}
@@ -2,6 +2,7 @@ package stores
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,6 +10,7 @@ import (
 	"github.com/nickheyer/distroface/pkg/pages"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ── Repository operations ────────────────────────────────────────────────
@@ -17,9 +19,10 @@ func (s *Store) CreateRepository(ctx context.Context, repo *db.Repository) error
 	return s.db.WithContext(ctx).Create(repo).Error
 }
 
+// Registry names are lowercase, so lookups fold case
 func (s *Store) GetRepository(ctx context.Context, namespace, name string) (*db.Repository, error) {
 	var repo db.Repository
-	err := s.db.WithContext(ctx).First(&repo, "namespace = ? AND name = ?", namespace, name).Error
+	err := s.db.WithContext(ctx).First(&repo, "namespace = ? AND name = ?", strings.ToLower(namespace), strings.ToLower(name)).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
@@ -29,33 +32,92 @@ func (s *Store) GetRepository(ctx context.Context, namespace, name string) (*db.
 	return &repo, nil
 }
 
-// ListRepositories returns repositories with visibility filtering.
-//
-// If canManage is true, all repositories are returned (no visibility filtering).
-// Otherwise, the returned set is:
-//   - All public repositories
-//   - Private repos owned by userID (owner_id matches)
-//   - Private repos whose namespace is a user's username (personal repos)
-//   - Private repos in organizations the user is a member of
-//   - Private repos explicitly granted via RBAC (grantedRepos contains "namespace/name")
-//
+// Any image repo, artifact repo or org already living under the namespace
+func (s *Store) NamespaceClaimed(ctx context.Context, namespace string) (bool, error) {
+	tx := s.db.WithContext(ctx)
+	for _, model := range []any{&db.Repository{}, &db.ArtifactRepository{}} {
+		var n int64
+		if err := tx.Model(model).Where("namespace = ?", namespace).Limit(1).Count(&n).Error; err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	org, err := s.GetOrganization(ctx, namespace)
+	return org != nil, err
+}
+
+// RepoSortColumns allowlists order_by columns for repository listings
+var RepoSortColumns = map[string]bool{
+	"name": true, "namespace": true, "pull_count": true,
+	"push_count": true, "created_at": true, "updated_at": true,
+}
+
 // ReposQuery allowlists docker repository list filters
 var ReposQuery = pages.Spec{
 	Fields: map[string]string{
 		"name":        "name",
 		"namespace":   "namespace",
 		"description": "description",
+		"owner":       "(SELECT u.username FROM users u WHERE u.id = repositories.owner_id)",
 	},
 	Text: []string{"name", "namespace", "description"},
 }
 
-// If userID is empty (anonymous), only public repos are returned.
-func (s *Store) ListRepositories(ctx context.Context, namespace string, q pages.Query, userID string, canManage bool, grantedRepos []string, limit, offset int) ([]*db.Repository, int64, error) {
-	tx := s.db.WithContext(ctx).Model(&db.Repository{})
+// Image config label a listed repository must carry on some pushed tag,
+// an empty Value only needs the key
+type RepoLabel struct {
+	Key   string
+	Value string
+}
+
+// Narrows repository listings ahead of the visibility rules, zero fields
+// match everything
+type RepoFilter struct {
+	Namespace    string
+	Private      *bool
+	Labels       []RepoLabel // All must match
+	UpdatedSince time.Time
+}
 
-	if namespace != "" {
-		tx = tx.Where("namespace = ?", namespace)
+func (f RepoFilter) scope(tx *gorm.DB) *gorm.DB {
+	if f.Namespace != "" {
+		tx = tx.Where("namespace = ?", f.Namespace)
+	}
+	if f.Private != nil {
+		tx = tx.Where("is_private = ?", *f.Private)
+	}
+	if !f.UpdatedSince.IsZero() {
+		tx = tx.Where("updated_at >= ?", f.UpdatedSince)
 	}
+	for _, l := range f.Labels {
+		// Quoted member path so dotted keys like org.opencontainers.* stay whole
+		path := `$."` + l.Key + `"`
+		match := "json_type(tp.labels, ?) IS NOT NULL"
+		args := []any{path}
+		if l.Value != "" {
+			match = "json_extract(tp.labels, ?) = ?"
+			args = append(args, l.Value)
+		}
+		tx = tx.Where("EXISTS (SELECT 1 FROM tag_pushes tp WHERE tp.namespace = repositories.namespace AND tp.name = repositories.name AND "+match+")", args...)
+	}
+	return tx
+}
+
+// ListRepositories returns repositories with visibility filtering.
+//
+// If canManage is true, all repositories are returned (no visibility filtering).
+// Otherwise, the returned set is:
+//   - All public repositories
+//   - Private repos owned by userID (owner_id matches)
+//   - Private repos whose namespace is a user's username (personal repos)
+//   - Private repos in organizations the user is a member of
+//   - Private repos explicitly granted via RBAC (grantedRepos contains "namespace/name")
+//
+// If userID is empty (anonymous), only public repos are returned.
+func (s *Store) ListRepositories(ctx context.Context, filter RepoFilter, q pages.Query, orderBy, userID string, canManage bool, grantedRepos []string, limit, offset int) ([]*db.Repository, int64, error) {
+	tx := s.db.WithContext(ctx).Model(&db.Repository{}).Scopes(filter.scope)
 	tx = tx.Scopes(ReposQuery.Scope(q))
 
 	if !canManage {
@@ -81,13 +143,147 @@ func (s *Store) ListRepositories(ctx context.Context, namespace string, q pages.
 		return nil, 0, err
 	}
 
+	if orderBy == "" {
+		orderBy = "updated_at DESC"
+	}
 	var repos []*db.Repository
-	err := tx.Order("updated_at DESC").Limit(limit).Offset(offset).Find(&repos).Error
+	err := tx.Order(orderBy).Order("id ASC").Limit(limit).Offset(offset).Find(&repos).Error
 	return repos, total, err
 }
 
 func (s *Store) DeleteRepository(ctx context.Context, namespace, name string) error {
-	return s.db.WithContext(ctx).Delete(&db.Repository{}, "namespace = ? AND name = ?", namespace, name).Error
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&db.TagPush{}, "namespace = ? AND name = ?", namespace, name).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&db.TagPull{}, "namespace = ? AND name = ?", namespace, name).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&db.TagProvenance{}, "namespace = ? AND name = ?", namespace, name).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&db.TagExpiry{}, "namespace = ? AND name = ?", namespace, name).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&db.Repository{}, "namespace = ? AND name = ?", namespace, name).Error; err != nil {
+			return err
+		}
+		return dropOrphanPermissions(tx, namespace, name)
+	})
+}
+
+// Upserts who pushed a tag last, empty Labels keep the stored ones
+func (s *Store) RecordTagPush(ctx context.Context, push *db.TagPush) error {
+	columns := []string{"digest", "pushed_by", "pushed_at"}
+	if push.Labels != "" {
+		columns = append(columns, "labels")
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "namespace"}, {Name: "name"}, {Name: "tag"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(push).Error
+}
+
+// Recorded tag pushes of a repository keyed by tag
+func (s *Store) ListTagPushes(ctx context.Context, namespace, name string) (map[string]*db.TagPush, error) {
+	var rows []*db.TagPush
+	if err := s.db.WithContext(ctx).Where("namespace = ? AND name = ?", namespace, name).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]*db.TagPush, len(rows))
+	for _, r := range rows {
+		out[r.Tag] = r
+	}
+	return out, nil
+}
+
+func (s *Store) DeleteTagPush(ctx context.Context, namespace, name, tag string) error {
+	return s.db.WithContext(ctx).Delete(&db.TagPush{}, "namespace = ? AND name = ? AND tag = ?", namespace, name, tag).Error
+}
+
+// Counts a pull of a tag and stamps when it happened
+func (s *Store) RecordTagPull(ctx context.Context, namespace, name, tag string, at time.Time) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "namespace"}, {Name: "name"}, {Name: "tag"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"pull_count": gorm.Expr("tag_pulls.pull_count + 1"),
+			"pulled_at":  at,
+		}),
+	}).Create(&db.TagPull{Namespace: namespace, Name: name, Tag: tag, PullCount: 1, PulledAt: at}).Error
+}
+
+// Recorded tag pulls of a repository keyed by tag
+func (s *Store) ListTagPulls(ctx context.Context, namespace, name string) (map[string]*db.TagPull, error) {
+	var rows []*db.TagPull
+	if err := s.db.WithContext(ctx).Where("namespace = ? AND name = ?", namespace, name).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]*db.TagPull, len(rows))
+	for _, r := range rows {
+		out[r.Tag] = r
+	}
+	return out, nil
+}
+
+func (s *Store) DeleteTagPull(ctx context.Context, namespace, name, tag string) error {
+	return s.db.WithContext(ctx).Delete(&db.TagPull{}, "namespace = ? AND name = ? AND tag = ?", namespace, name, tag).Error
+}
+
+// Upserts the copy that set a tag
+func (s *Store) RecordTagProvenance(ctx context.Context, p *db.TagProvenance) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "namespace"}, {Name: "name"}, {Name: "tag"}},
+		UpdateAll: true,
+	}).Create(p).Error
+}
+
+// Nil when the tag was never copied
+func (s *Store) GetTagProvenance(ctx context.Context, namespace, name, tag string) (*db.TagProvenance, error) {
+	var p db.TagProvenance
+	err := s.db.WithContext(ctx).First(&p, "namespace = ? AND name = ? AND tag = ?", namespace, name, tag).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Store) DeleteTagProvenance(ctx context.Context, namespace, name, tag string) error {
+	return s.db.WithContext(ctx).Delete(&db.TagProvenance{}, "namespace = ? AND name = ? AND tag = ?", namespace, name, tag).Error
+}
+
+// Upserts when a tag expires
+func (s *Store) SetTagExpiry(ctx context.Context, e *db.TagExpiry) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "namespace"}, {Name: "name"}, {Name: "tag"}},
+		DoUpdates: clause.AssignmentColumns([]string{"expires_at", "set_by", "updated_at"}),
+	}).Create(e).Error
+}
+
+// Expiring tags of a repository keyed by tag
+func (s *Store) ListTagExpiries(ctx context.Context, namespace, name string) (map[string]*db.TagExpiry, error) {
+	var rows []*db.TagExpiry
+	if err := s.db.WithContext(ctx).Where("namespace = ? AND name = ?", namespace, name).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]*db.TagExpiry, len(rows))
+	for _, r := range rows {
+		out[r.Tag] = r
+	}
+	return out, nil
+}
+
+// Tags whose expiry passed by now, soonest first
+func (s *Store) ListExpiredTags(ctx context.Context, now time.Time, limit int) ([]*db.TagExpiry, error) {
+	var rows []*db.TagExpiry
+	err := s.db.WithContext(ctx).Where("expires_at <= ?", now).Order("expires_at").Limit(limit).Find(&rows).Error
+	return rows, err
+}
+
+func (s *Store) DeleteTagExpiry(ctx context.Context, namespace, name, tag string) error {
+	return s.db.WithContext(ctx).Delete(&db.TagExpiry{}, "namespace = ? AND name = ? AND tag = ?", namespace, name, tag).Error
 }
 
 func (s *Store) UpdateRepository(ctx context.Context, repo *db.Repository) error {
@@ -70,7 +70,10 @@ var RolesQuery = pages.Spec{
 	Text: []string{"name", "description"},
 }
 
-func (s *Store) ListRoles(ctx context.Context, q pages.Query, limit, offset int) ([]*db.Role, int64, error) {
+// RoleSortColumns allowlists order_by columns for role listings
+var RoleSortColumns = map[string]bool{"name": true, "created_at": true}
+
+func (s *Store) ListRoles(ctx context.Context, q pages.Query, orderBy string, limit, offset int) ([]*db.Role, int64, error) {
 	tx := s.db.WithContext(ctx).Model(&db.Role{}).Scopes(RolesQuery.Scope(q))
 
 	var total int64
@@ -81,8 +84,11 @@ func (s *Store) ListRoles(ctx context.Context, q pages.Query, limit, offset int)
 	if limit > 0 {
 		tx = tx.Limit(limit).Offset(offset)
 	}
+	if orderBy == "" {
+		orderBy = "is_system DESC, name ASC"
+	}
 	var roles []*db.Role
-	err := tx.Order("is_system DESC, name ASC").Find(&roles).Error
+	err := tx.Order(orderBy).Order("id ASC").Find(&roles).Error
 	return roles, total, err
 }
 
@@ -96,13 +102,16 @@ func (s *Store) UpdateRole(ctx context.Context, role *db.Role) error {
 	return s.db.WithContext(ctx).Save(role).Error
 }
 
-// Rename role and repoint user rows one transaction
+// Rename role and repoint user and repo ACL rows one transaction
 func (s *Store) RenameRole(ctx context.Context, role *db.Role, oldName string) error {
 	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Save(role).Error; err != nil {
 			return err
 		}
-		return tx.Model(&db.UserRole{}).Where("role_name = ?", oldName).Update("role_name", role.Name).Error
+		if err := tx.Model(&db.UserRole{}).Where("role_name = ?", oldName).Update("role_name", role.Name).Error; err != nil {
+			return err
+		}
+		return tx.Model(&db.RepositoryPermission{}).Where("role_name = ?", oldName).Update("role_name", role.Name).Error
 	})
 }
 
@@ -118,6 +127,9 @@ func (s *Store) DeleteRole(ctx context.Context, id string) error {
 		if err := tx.Where("role_name = ?", role.Name).Delete(&db.UserRole{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("role_name = ?", role.Name).Delete(&db.RepositoryPermission{}).Error; err != nil {
+			return err
+		}
 		return tx.Delete(&db.Role{}, "id = ?", id).Error
 	})
 }
@@ -200,3 +212,21 @@ func (s *Store) GetUserRoles(ctx context.Context, userID string) ([]*db.Role, er
 		Find(&roles).Error
 	return roles, err
 }
+
+// Users holding a role, filtered like the user list
+func (s *Store) ListRoleMembers(ctx context.Context, roleName string, q pages.Query, orderBy string, limit, offset int) ([]*db.User, int64, error) {
+	holders := s.db.Model(&db.UserRole{}).Select("user_id").Where("role_name = ?", roleName)
+	tx := s.db.WithContext(ctx).Model(&db.User{}).Where("id IN (?)", holders).Scopes(UsersQuery.Scope(q))
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if orderBy == "" {
+		orderBy = "username ASC"
+	}
+	var users []*db.User
+	err := tx.Order(orderBy).Order("id ASC").Limit(limit).Offset(offset).Find(&users).Error
+	return users, total, err
+}
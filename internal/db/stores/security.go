@@ -239,3 +239,28 @@ func (s *Store) DeleteAuditEventsBefore(ctx context.Context, cutoff time.Time) (
 	res := s.db.WithContext(ctx).Delete(&db.AuditEvent{}, "created_at < ?", cutoff)
 	return res.RowsAffected, res.Error
 }
+
+// ── Deletion approval operations ─────────────────────────────────────────
+
+func (s *Store) GetDeletionApproval(ctx context.Context, resource string) (*db.DeletionApproval, error) {
+	var a db.DeletionApproval
+	err := s.db.WithContext(ctx).First(&a, "resource = ?", resource).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Replaces any earlier request for the same target
+func (s *Store) SaveDeletionApproval(ctx context.Context, a *db.DeletionApproval) error {
+	return s.db.WithContext(ctx).Save(a).Error
+}
+
+// Reports whether this caller removed the row, so only one approver proceeds
+func (s *Store) ClaimDeletionApproval(ctx context.Context, resource string) (bool, error) {
+	res := s.db.WithContext(ctx).Delete(&db.DeletionApproval{}, "resource = ?", resource)
+	return res.RowsAffected > 0, res.Error
+}
@@ -30,6 +30,19 @@ func (s *Store) GetSession(ctx context.Context, token string) (*db.Session, erro
 	return &session, nil
 }
 
+// Live session by id, nil once it expired or was signed out
+func (s *Store) GetSessionByID(ctx context.Context, id string) (*db.Session, error) {
+	var session db.Session
+	err := s.db.WithContext(ctx).Where("id = ? AND expires_at > ?", id, time.Now()).First(&session).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
 func (s *Store) DeleteSession(ctx context.Context, token string) error {
 	return s.db.WithContext(ctx).Where("token = ?", token).Delete(&db.Session{}).Error
 }
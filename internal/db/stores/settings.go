@@ -65,6 +65,16 @@ func (s *Store) GetPortalOrgID(ctx context.Context, portalID string) (string, er
 	return portal.OrgID, nil
 }
 
+// Org id owning a namespace, empty for user namespaces
+func (s *Store) GetNamespaceOrgID(ctx context.Context, namespace string) (string, error) {
+	var ids []string
+	err := s.db.WithContext(ctx).Model(&db.Organization{}).Where("name = ?", namespace).Limit(1).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return ids[0], nil
+}
+
 // ── Legacy kv and column migration ───────────────────────────────────────
 
 // Legacy string keys retired by the typed settings documents
@@ -0,0 +1,77 @@
+package stores
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/db"
+	"gorm.io/gorm"
+)
+
+// ── Signing key operations ───────────────────────────────────────────────
+
+func (s *Store) GetActiveSigningKey(ctx context.Context, scope string) (*db.SigningKey, error) {
+	var key db.SigningKey
+	err := s.db.WithContext(ctx).First(&key, "scope = ? AND active = ?", scope, true).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *Store) GetSigningKey(ctx context.Context, id string) (*db.SigningKey, error) {
+	var key db.SigningKey
+	err := s.db.WithContext(ctx).First(&key, "id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Newest first, retired keys included
+func (s *Store) ListSigningKeys(ctx context.Context, scope string) ([]*db.SigningKey, error) {
+	var keys []*db.SigningKey
+	err := s.db.WithContext(ctx).Where("scope = ?", scope).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// Retires the scope's active key and activates key in one transaction
+func (s *Store) ActivateSigningKey(ctx context.Context, key *db.SigningKey) error {
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&db.SigningKey{}).Where("scope = ? AND active = ?", key.Scope, true).
+			Updates(map[string]any{"active": false, "retired_at": time.Now().UTC()}).Error
+		if err != nil {
+			return err
+		}
+		key.Active = true
+		return tx.Create(key).Error
+	})
+}
+
+// ── Image signature operations ───────────────────────────────────────────
+
+func (s *Store) CreateImageSignature(ctx context.Context, sig *db.ImageSignature) error {
+	if sig.ID == "" {
+		sig.ID = uuid.New().String()
+	}
+	return s.db.WithContext(ctx).Create(sig).Error
+}
+
+// Oldest first
+func (s *Store) ListImageSignatures(ctx context.Context, namespace, name, subject string) ([]*db.ImageSignature, error) {
+	var sigs []*db.ImageSignature
+	err := s.db.WithContext(ctx).
+		Where("namespace = ? AND name = ? AND subject_digest = ?", namespace, name, subject).
+		Order("created_at ASC").Find(&sigs).Error
+	return sigs, err
+}
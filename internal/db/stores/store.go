@@ -1,7 +1,9 @@
 package stores
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/nickheyer/distroface/internal/db"
@@ -13,9 +15,10 @@ import (
 )
 
 type DBConfig struct {
-	MaxOpenConns    int
-	MaxIdleConns    int
-	ConnMaxLifetime time.Duration
+	MaxOpenConns     int
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	ManualMigrations bool // Refuse to start with pending versioned migrations
 }
 
 type Store struct {
@@ -23,6 +26,26 @@ type Store struct {
 }
 
 func NewSQLiteStore(dbPath string, config ...DBConfig) (*Store, error) {
+	store, err := OpenSQLiteStore(dbPath, config...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config) > 0 && config[0].ManualMigrations {
+		err = store.checkMigrated()
+	} else {
+		err = store.Migrate()
+	}
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return store, nil
+}
+
+// Opens without touching the schema, for migration tooling
+func OpenSQLiteStore(dbPath string, config ...DBConfig) (*Store, error) {
 	dsn := dbPath + "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on&_txlock=immediate"
 	gdb, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
@@ -52,19 +75,18 @@ func NewSQLiteStore(dbPath string, config ...DBConfig) (*Store, error) {
 		}
 	}
 
-	store := &Store{db: gdb}
-
-	if err := store.Migrate(); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
-	}
-
-	return store, nil
+	return &Store{db: gdb}, nil
 }
 
 func (s *Store) DB() *gorm.DB {
 	return s.db
 }
 
+// Insert lost a race with a concurrent create of the same name
+func IsUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint")
+}
+
 func (s *Store) Close() error {
 	sqlDB, err := s.db.DB()
 	if err != nil {
@@ -73,7 +95,104 @@ func (s *Store) Close() error {
 	return sqlDB.Close()
 }
 
+// Row counts for the admin summary
+type InstanceCounts struct {
+	Users                int64
+	Organizations        int64
+	Repositories         int64
+	ArtifactRepositories int64
+	Artifacts            int64
+}
+
+func (s *Store) CountInstance(ctx context.Context) (InstanceCounts, error) {
+	var c InstanceCounts
+	tx := s.db.WithContext(ctx)
+	for _, count := range []struct {
+		model any
+		dst   *int64
+	}{
+		{&db.User{}, &c.Users},
+		{&db.Organization{}, &c.Organizations},
+		{&db.Repository{}, &c.Repositories},
+		{&db.ArtifactRepository{}, &c.ArtifactRepositories},
+		{&db.Artifact{}, &c.Artifacts},
+	} {
+		if err := tx.Model(count.model).Count(count.dst).Error; err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// What one user owns, for their usage view
+type OwnerCounts struct {
+	Repositories         int64
+	PrivateRepositories  int64
+	ArtifactRepositories int64
+	Artifacts            int64
+	ArtifactBytes        int64 // Unique blob bytes across the owned artifact repos
+}
+
+func (s *Store) CountOwned(ctx context.Context, ownerID string) (OwnerCounts, error) {
+	var c OwnerCounts
+	tx := s.db.WithContext(ctx)
+	if err := tx.Model(&db.Repository{}).Where("owner_id = ?", ownerID).Count(&c.Repositories).Error; err != nil {
+		return c, err
+	}
+	if err := tx.Model(&db.Repository{}).Where("owner_id = ? AND is_private = ?", ownerID, true).Count(&c.PrivateRepositories).Error; err != nil {
+		return c, err
+	}
+	if err := tx.Model(&db.ArtifactRepository{}).Where("owner_id = ?", ownerID).Count(&c.ArtifactRepositories).Error; err != nil {
+		return c, err
+	}
+	owned := tx.Model(&db.ArtifactRepository{}).Select("id").Where("owner_id = ?", ownerID)
+	if err := tx.Model(&db.Artifact{}).Where("repo_id IN (?)", owned).Count(&c.Artifacts).Error; err != nil {
+		return c, err
+	}
+	var err error
+	c.ArtifactBytes, err = s.OwnerArtifactBytes(ctx, ownerID)
+	return c, err
+}
+
+// Unique blob bytes across the artifact repos one user owns
+func (s *Store) OwnerArtifactBytes(ctx context.Context, ownerID string) (int64, error) {
+	var total int64
+	owned := s.db.Model(&db.ArtifactRepository{}).Select("id").Where("owner_id = ?", ownerID)
+	err := s.db.WithContext(ctx).
+		Raw(`SELECT COALESCE(SUM(size),0) FROM (SELECT MAX(size) AS size FROM artifacts WHERE repo_id IN (?) GROUP BY digest)`, owned).
+		Scan(&total).Error
+	return total, err
+}
+
+// Baseline schema then every pending versioned migration
 func (s *Store) Migrate() error {
+	if err := s.SyncSchema(); err != nil {
+		return err
+	}
+	if err := migrations.Run(s.db); err != nil {
+		return fmt.Errorf("failed to run data migrations: %w", err)
+	}
+	return nil
+}
+
+// Baseline schema only, then fails if versioned migrations are pending
+func (s *Store) checkMigrated() error {
+	if err := s.SyncSchema(); err != nil {
+		return err
+	}
+	pending, err := migrations.Pending(s.db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations %v, run 'distroface migrate up'", len(pending), pending)
+	}
+	return nil
+}
+
+// Auto migrates the models and patches legacy layouts. Additive and safe to
+// repeat, changes that need ordering or a way back go in versioned migrations
+func (s *Store) SyncSchema() error {
 	// Rename old webhook secret column keeping stored values
 	if s.db.Migrator().HasTable("webhooks") &&
 		s.db.Migrator().HasColumn(&db.Webhook{}, "secret_hash") &&
@@ -143,11 +262,32 @@ func (s *Store) Migrate() error {
 		&db.ArtifactRepository{},
 		&db.Artifact{},
 		&db.ArtifactProperty{},
+		&db.ArtifactPackage{},
 		&db.CertificateDomain{},
 		&db.ACMECacheEntry{},
 		&db.ACMEAccount{},
 		&db.TLSCertificate{},
 		&db.AuditEvent{},
+		&db.DeletionApproval{},
+		&db.Credential{},
+		&db.SigningKey{},
+		&db.ImageSignature{},
+		&db.TagPush{},
+		&db.TagPull{},
+		&db.TagProvenance{},
+		&db.TagExpiry{},
+		&db.RepositoryPermission{},
+		&db.BlobLink{},
+		&db.PackedLink{},
+		&db.FreezeWindow{},
+		&db.Comment{},
+		&db.ArtifactLatestPointer{},
+		&db.ArtifactChange{},
+		&db.Watch{},
+		&db.NotificationPreference{},
+		&db.Notification{},
+		&db.Job{},
+		&db.ReplicationRule{},
 	); err != nil {
 		return fmt.Errorf("failed to auto-migrate: %w", err)
 	}
@@ -188,10 +328,6 @@ func (s *Store) Migrate() error {
 		return fmt.Errorf("failed to seed system roles: %w", err)
 	}
 
-	if err := migrations.Run(s.db); err != nil {
-		return fmt.Errorf("failed to run data migrations: %w", err)
-	}
-
 	return nil
 }
 
@@ -32,6 +32,18 @@ func (s *Store) GetAPITokenByHash(ctx context.Context, hash string) (*db.APIToke
 	return &token, nil
 }
 
+func (s *Store) GetAPITokenByID(ctx context.Context, id string) (*db.APIToken, error) {
+	var token db.APIToken
+	err := s.db.WithContext(ctx).Where("id = ?", id).First(&token).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
 // TokensQuery allowlists api token list filters
 var TokensQuery = pages.Spec{
 	Fields: map[string]string{"name": "name"},
@@ -102,7 +102,13 @@ var UsersQuery = pages.Spec{
 	Text: []string{"username", "email", "display_name"},
 }
 
-func (s *Store) ListUsers(ctx context.Context, q pages.Query, limit, offset int) ([]*db.User, int64, error) {
+// UserSortColumns allowlists order_by columns for user listings
+var UserSortColumns = map[string]bool{
+	"username": true, "email": true, "display_name": true,
+	"last_login": true, "created_at": true,
+}
+
+func (s *Store) ListUsers(ctx context.Context, q pages.Query, orderBy string, limit, offset int) ([]*db.User, int64, error) {
 	tx := s.db.WithContext(ctx).Model(&db.User{}).Scopes(UsersQuery.Scope(q))
 
 	var total int64
@@ -110,8 +116,11 @@ func (s *Store) ListUsers(ctx context.Context, q pages.Query, limit, offset int)
 		return nil, 0, err
 	}
 
+	if orderBy == "" {
+		orderBy = "created_at DESC"
+	}
 	var users []*db.User
-	err := tx.Order("created_at DESC").Limit(limit).Offset(offset).Find(&users).Error
+	err := tx.Order(orderBy).Order("id ASC").Limit(limit).Offset(offset).Find(&users).Error
 	return users, total, err
 }
 
@@ -119,6 +128,14 @@ func (s *Store) UpdateUser(ctx context.Context, user *db.User) error {
 	return s.db.WithContext(ctx).Save(user).Error
 }
 
+// Swaps a password hash unless it changed since it was read, a password
+// change racing a sign-in keeps the new password
+func (s *Store) ReplacePasswordHash(ctx context.Context, userID, old, hash string) error {
+	return s.db.WithContext(ctx).Model(&db.User{}).
+		Where("id = ? AND password_hash = ?", userID, old).
+		Update("password_hash", hash).Error
+}
+
 func (s *Store) DeleteUser(ctx context.Context, id string) error {
 	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Where("user_id = ?", id).Delete(&db.Session{}).Error; err != nil {
@@ -133,6 +150,9 @@ func (s *Store) DeleteUser(ctx context.Context, id string) error {
 		if err := tx.Where("user_id = ?", id).Delete(&db.OrgMember{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("user_id = ?", id).Delete(&db.RepositoryPermission{}).Error; err != nil {
+			return err
+		}
 		return tx.Delete(&db.User{}, "id = ?", id).Error
 	})
 }
@@ -143,7 +163,7 @@ func (s *Store) BulkDeleteUsers(ctx context.Context, ids []string) error {
 		return nil
 	}
 	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		for _, model := range []any{&db.Session{}, &db.APIToken{}, &db.UserRole{}, &db.OrgMember{}} {
+		for _, model := range []any{&db.Session{}, &db.APIToken{}, &db.UserRole{}, &db.OrgMember{}, &db.RepositoryPermission{}} {
 			if err := tx.Where("user_id IN ?", ids).Delete(model).Error; err != nil {
 				return err
 			}
@@ -156,7 +176,12 @@ func (s *Store) BulkSetUsersActive(ctx context.Context, ids []string, active boo
 	if len(ids) == 0 {
 		return nil
 	}
-	return s.db.WithContext(ctx).Model(&db.User{}).Where("id IN ?", ids).Update("is_active", active).Error
+	updates := map[string]any{"is_active": active}
+	if active {
+		// Activation is the approval of a pending signup
+		updates["pending_approval"] = false
+	}
+	return s.db.WithContext(ctx).Model(&db.User{}).Where("id IN ?", ids).Updates(updates).Error
 }
 
 // Existing subset of the requested ids
@@ -0,0 +1,227 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry"
+)
+
+// Bumped when the manifest layout changes incompatibly
+const FormatVersion = 1
+
+// Content of a set of repositories as an air-gapped sync tool sees it.
+// Encoded with encoding/json, so the signed bytes are stable for a value
+type Manifest struct {
+	Version     int            `json:"version"`
+	Instance    string         `json:"instance"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Images      []Image        `json:"images"`
+	Artifacts   []ArtifactRepo `json:"artifacts"`
+}
+
+// One image repository, blobs cover manifests, configs and layers
+type Image struct {
+	Repository string `json:"repository"`
+	Tags       []Tag  `json:"tags"`
+	Blobs      []Blob `json:"blobs"`
+}
+
+type Tag struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+}
+
+type Blob struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"media_type,omitempty"`
+}
+
+type ArtifactRepo struct {
+	Repository string `json:"repository"`
+	Files      []File `json:"files"`
+}
+
+type File struct {
+	Version    string            `json:"version"`
+	Path       string            `json:"path"`
+	Digest     string            `json:"digest"`
+	Size       int64             `json:"size"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+func (m *Manifest) Encode() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// Parses a manifest payload, refusing layouts newer than this server
+func Decode(payload []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, fmt.Errorf("invalid export manifest: %w", err)
+	}
+	if m.Version < 1 || m.Version > FormatVersion {
+		return nil, fmt.Errorf("unsupported export manifest version %d", m.Version)
+	}
+	return &m, nil
+}
+
+// Reads repository content for manifests, callers check access first
+type Builder struct {
+	store    *stores.Store
+	registry *registry.RegistryAccess
+}
+
+func NewBuilder(store *stores.Store, reg *registry.RegistryAccess) *Builder {
+	return &Builder{store: store, registry: reg}
+}
+
+func (b *Builder) Image(ctx context.Context, repo *storage.Repository) (Image, error) {
+	out := Image{Repository: repo.Namespace + "/" + repo.Name, Tags: []Tag{}, Blobs: []Blob{}}
+	tags, blobs, err := b.registry.TaggedContent(ctx, repo.Namespace, repo.Name)
+	if err != nil {
+		return out, fmt.Errorf("reading %s: %w", out.Repository, err)
+	}
+	for tag, dgst := range tags {
+		out.Tags = append(out.Tags, Tag{Name: tag, Digest: dgst})
+	}
+	sort.Slice(out.Tags, func(i, j int) bool { return out.Tags[i].Name < out.Tags[j].Name })
+	for _, blob := range blobs {
+		out.Blobs = append(out.Blobs, Blob{Digest: blob.Digest, Size: blob.Size, MediaType: blob.MediaType})
+	}
+	return out, nil
+}
+
+func (b *Builder) Artifacts(ctx context.Context, repo *storage.ArtifactRepository) (ArtifactRepo, error) {
+	out := ArtifactRepo{Repository: repo.Namespace + "/" + repo.Name, Files: []File{}}
+	artifacts, _, err := b.store.ListArtifacts(ctx, repo.ID, "", 0, 0)
+	if err != nil {
+		return out, fmt.Errorf("reading %s: %w", out.Repository, err)
+	}
+	for _, a := range artifacts {
+		f := File{Version: a.Version, Path: a.Path, Digest: a.Digest, Size: a.Size}
+		if len(a.Properties) > 0 {
+			f.Properties = a.Properties
+		}
+		out.Files = append(out.Files, f)
+	}
+	sort.Slice(out.Files, func(i, j int) bool { return out.Files[i].key() < out.Files[j].key() })
+	return out, nil
+}
+
+// Identity of a file within its repository, the digest stays out so a
+// replaced file reads as changed rather than as a second file
+func (f File) key() string {
+	keys := make([]string, 0, len(f.Properties))
+	for k := range f.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(f.Version + "\x00" + f.Path)
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + f.Properties[k])
+	}
+	return b.String()
+}
+
+// Repository and tag, or repository and file, paired with what it points at
+type TagChange struct {
+	Repository string
+	Tag        Tag
+}
+
+type FileChange struct {
+	Repository string
+	File       File
+}
+
+type BlobChange struct {
+	Repository string
+	Blob       Blob
+}
+
+// What moves base to current
+type Delta struct {
+	Blobs        []BlobChange // Digests base holds nowhere, each listed once
+	Tags         []TagChange  // New or moved tags
+	Files        []FileChange // New or replaced files
+	RemovedTags  []TagChange
+	RemovedFiles []FileChange
+	Bytes        int64
+}
+
+// Compares the repositories of current against the same repositories in
+// base. Blobs are content addressed, so one held by any repository of
+// base needs no copy. Repositories only in base are left alone
+func Diff(base, current *Manifest) Delta {
+	var d Delta
+	have := map[string]bool{}
+	baseImages := map[string]Image{}
+	for _, img := range base.Images {
+		baseImages[img.Repository] = img
+		for _, blob := range img.Blobs {
+			have[blob.Digest] = true
+		}
+	}
+	for _, img := range current.Images {
+		for _, blob := range img.Blobs {
+			if have[blob.Digest] {
+				continue
+			}
+			have[blob.Digest] = true
+			d.Blobs = append(d.Blobs, BlobChange{Repository: img.Repository, Blob: blob})
+			d.Bytes += blob.Size
+		}
+		old := map[string]string{}
+		for _, t := range baseImages[img.Repository].Tags {
+			old[t.Name] = t.Digest
+		}
+		seen := map[string]bool{}
+		for _, t := range img.Tags {
+			seen[t.Name] = true
+			if old[t.Name] != t.Digest {
+				d.Tags = append(d.Tags, TagChange{Repository: img.Repository, Tag: t})
+			}
+		}
+		for _, t := range baseImages[img.Repository].Tags {
+			if !seen[t.Name] {
+				d.RemovedTags = append(d.RemovedTags, TagChange{Repository: img.Repository, Tag: t})
+			}
+		}
+	}
+
+	baseRepos := map[string]ArtifactRepo{}
+	for _, repo := range base.Artifacts {
+		baseRepos[repo.Repository] = repo
+	}
+	for _, repo := range current.Artifacts {
+		old := map[string]File{}
+		for _, f := range baseRepos[repo.Repository].Files {
+			old[f.key()] = f
+		}
+		seen := map[string]bool{}
+		for _, f := range repo.Files {
+			k := f.key()
+			seen[k] = true
+			if prev, ok := old[k]; ok && prev.Digest == f.Digest {
+				continue
+			}
+			d.Files = append(d.Files, FileChange{Repository: repo.Repository, File: f})
+			d.Bytes += f.Size
+		}
+		for _, f := range baseRepos[repo.Repository].Files {
+			if !seen[f.key()] {
+				d.RemovedFiles = append(d.RemovedFiles, FileChange{Repository: repo.Repository, File: f})
+			}
+		}
+	}
+	return d
+}
@@ -0,0 +1,115 @@
+package export
+
+import (
+	"testing"
+	"time"
+)
+
+func testManifest() *Manifest {
+	return &Manifest{
+		Version:     FormatVersion,
+		Instance:    "registry.example.com",
+		GeneratedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Images: []Image{{
+			Repository: "team/app",
+			Tags:       []Tag{{Name: "v1", Digest: "sha256:m1"}, {Name: "latest", Digest: "sha256:m1"}},
+			Blobs:      []Blob{{Digest: "sha256:m1", Size: 10}, {Digest: "sha256:base", Size: 1000}, {Digest: "sha256:l1", Size: 100}},
+		}},
+		Artifacts: []ArtifactRepo{{
+			Repository: "team/builds",
+			Files: []File{
+				{Version: "1.0", Path: "app.tar", Digest: "sha256:a1", Size: 50},
+				{Version: "1.0", Path: "app.tar", Digest: "sha256:a1arm", Size: 60, Properties: map[string]string{"arch": "arm64"}},
+			},
+		}},
+	}
+}
+
+// Unchanged content needs nothing carried across
+func TestDiffIdentical(t *testing.T) {
+	d := Diff(testManifest(), testManifest())
+	if len(d.Blobs)+len(d.Tags)+len(d.Files)+len(d.RemovedTags)+len(d.RemovedFiles) != 0 || d.Bytes != 0 {
+		t.Fatalf("identical manifests diff = %+v", d)
+	}
+}
+
+func TestDiffChanges(t *testing.T) {
+	base := testManifest()
+	current := testManifest()
+
+	// v2 shares the base layer, latest moves to it, v1 goes away
+	current.Images[0].Tags = []Tag{{Name: "v2", Digest: "sha256:m2"}, {Name: "latest", Digest: "sha256:m2"}}
+	current.Images[0].Blobs = []Blob{{Digest: "sha256:m2", Size: 12}, {Digest: "sha256:base", Size: 1000}, {Digest: "sha256:l2", Size: 200}}
+	// A new repo whose only layer base already holds under another name
+	current.Images = append(current.Images, Image{
+		Repository: "team/tool",
+		Tags:       []Tag{{Name: "v1", Digest: "sha256:t1"}},
+		Blobs:      []Blob{{Digest: "sha256:t1", Size: 5}, {Digest: "sha256:l1", Size: 100}},
+	})
+	// The arm variant is rebuilt, the plain one removed, 1.1 added
+	current.Artifacts[0].Files = []File{
+		{Version: "1.0", Path: "app.tar", Digest: "sha256:a1arm2", Size: 61, Properties: map[string]string{"arch": "arm64"}},
+		{Version: "1.1", Path: "app.tar", Digest: "sha256:a2", Size: 70},
+	}
+
+	d := Diff(base, current)
+
+	blobs := map[string]string{}
+	for _, b := range d.Blobs {
+		blobs[b.Blob.Digest] = b.Repository
+	}
+	want := map[string]string{"sha256:m2": "team/app", "sha256:l2": "team/app", "sha256:t1": "team/tool"}
+	if len(blobs) != len(want) {
+		t.Fatalf("blobs = %v, want %v", blobs, want)
+	}
+	for dgst, repo := range want {
+		if blobs[dgst] != repo {
+			t.Errorf("blob %s under %q, want %q", dgst, blobs[dgst], repo)
+		}
+	}
+
+	tags := map[string]bool{}
+	for _, c := range d.Tags {
+		tags[c.Repository+":"+c.Tag.Name] = true
+	}
+	for _, tag := range []string{"team/app:v2", "team/app:latest", "team/tool:v1"} {
+		if !tags[tag] {
+			t.Errorf("tag %s not set, got %v", tag, tags)
+		}
+	}
+	if len(d.RemovedTags) != 1 || d.RemovedTags[0].Tag.Name != "v1" || d.RemovedTags[0].Repository != "team/app" {
+		t.Errorf("removed tags = %+v, want team/app:v1", d.RemovedTags)
+	}
+
+	if len(d.Files) != 2 {
+		t.Fatalf("files = %+v, want the rebuilt arm variant and 1.1", d.Files)
+	}
+	if len(d.RemovedFiles) != 1 || d.RemovedFiles[0].File.Digest != "sha256:a1" {
+		t.Errorf("removed files = %+v, want the plain 1.0 file", d.RemovedFiles)
+	}
+	if want := int64(12 + 200 + 5 + 61 + 70); d.Bytes != want {
+		t.Errorf("bytes = %d, want %d", d.Bytes, want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	payload, err := testManifest().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Decode(payload)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	again, _ := m.Encode()
+	if string(again) != string(payload) {
+		t.Fatalf("re-encoding changed the signed bytes:\n%s\n%s", payload, again)
+	}
+
+	if _, err := Decode([]byte(`{"version": 99}`)); err == nil {
+		t.Error("future manifest version accepted")
+	}
+	if _, err := Decode([]byte(`not json`)); err == nil {
+		t.Error("garbage accepted")
+	}
+}
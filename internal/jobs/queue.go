@@ -0,0 +1,254 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+const (
+	MaxAttempts    = 5
+	baseRetryDelay = 10 * time.Second
+	maxRetryDelay  = 15 * time.Minute
+	pollInterval   = 5 * time.Second
+	pruneInterval  = time.Hour
+	// Finished jobs stay listed this long
+	keepFinished = 7 * 24 * time.Hour
+)
+
+// Runs one job. Errors retry with backoff until MaxAttempts, wrap with
+// Permanent when another attempt cannot help
+type Handler func(ctx context.Context, subject string, payload json.RawMessage) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Fails the job on the spot instead of retrying
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Exponential 10s 40s 160s 640s capped with 20 percent jitter
+func retryDelay(attempt int) time.Duration {
+	delay := baseRetryDelay << (2 * (attempt - 1))
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	jitter := 0.8 + 0.4*rand.Float64()
+	return time.Duration(float64(delay) * jitter)
+}
+
+// Queue runs work handed off from request paths. Jobs live in the
+// database so a restart picks up whatever was queued or cut off mid run
+type Queue struct {
+	store   *stores.Store
+	log     *logger.Logger
+	workers int
+	wake    chan struct{}
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	running sync.WaitGroup // Workers and pruner Start launched
+}
+
+func New(store *stores.Store, log *logger.Logger, workers int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Queue{
+		store:    store,
+		log:      log,
+		workers:  workers,
+		wake:     make(chan struct{}, 1),
+		handlers: map[string]Handler{},
+	}
+}
+
+// Registers the handler for a job kind, register before Start
+func (q *Queue) Handle(kind string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = h
+}
+
+// Queues a job of a registered kind. A pending job with the same kind and
+// subject absorbs the new one and is returned in its place
+func (q *Queue) Enqueue(ctx context.Context, kind, subject string, payload any) (*db.Job, error) {
+	q.mu.RLock()
+	_, ok := q.handlers[kind]
+	q.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no handler for job kind %q", kind)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s job: %w", kind, err)
+	}
+	job, err := q.store.EnqueueJob(ctx, &db.Job{Kind: kind, Subject: subject, Payload: string(raw)})
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+// Gives a failed job a fresh set of attempts, false when it is not failed
+func (q *Queue) Retry(ctx context.Context, id string) (bool, error) {
+	ok, err := q.store.ResetJob(ctx, id, time.Now())
+	if ok {
+		q.notify()
+	}
+	return ok, err
+}
+
+// Runs a pending job now instead of after its backoff, false when it is
+// not pending
+func (q *Queue) Expedite(ctx context.Context, id string) (bool, error) {
+	ok, err := q.store.ExpediteJob(ctx, id, time.Now())
+	if ok {
+		q.notify()
+	}
+	return ok, err
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Requeues jobs a crash cut off and starts the workers, which stop with ctx
+func (q *Queue) Start(ctx context.Context) error {
+	n, err := q.store.RequeueRunningJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("requeueing interrupted jobs: %w", err)
+	}
+	if n > 0 {
+		q.log.Info("jobs: requeued %d interrupted job(s)", n)
+	}
+	for range q.workers {
+		q.running.Go(func() { q.work(ctx) })
+	}
+	q.running.Go(func() { q.prune(ctx) })
+	return nil
+}
+
+// Blocks until the workers have returned after Start's ctx ended, so the
+// store can close under them. Gives up with ctx's error
+func (q *Queue) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		for ctx.Err() == nil && q.runNext(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Claims and runs one due job, false when none was due
+func (q *Queue) runNext(ctx context.Context) bool {
+	q.mu.RLock()
+	kinds := make([]string, 0, len(q.handlers))
+	for k := range q.handlers {
+		kinds = append(kinds, k)
+	}
+	q.mu.RUnlock()
+	if len(kinds) == 0 {
+		return false
+	}
+
+	job, err := q.store.ClaimJob(ctx, kinds, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			q.log.Error("jobs: claiming next job: %v", err)
+		}
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	q.mu.RLock()
+	h := q.handlers[job.Kind]
+	q.mu.RUnlock()
+	err = q.run(ctx, h, job)
+
+	// A stopping server leaves the job running, the next start requeues it
+	if ctx.Err() != nil {
+		return false
+	}
+	bg := context.Background()
+	now := time.Now()
+	var permanent permanentError
+	switch {
+	case err == nil:
+		err = q.store.FinishJob(bg, job.ID, now)
+	case errors.As(err, &permanent) || job.Attempts >= MaxAttempts:
+		q.log.Error("jobs: %s %s failed after %d attempt(s): %v", job.Kind, job.Subject, job.Attempts, err)
+		err = q.store.FailJob(bg, job.ID, err.Error(), now)
+	default:
+		delay := retryDelay(job.Attempts)
+		q.log.Warn("jobs: %s %s attempt %d/%d failed, retrying in %s: %v", job.Kind, job.Subject, job.Attempts, MaxAttempts, delay.Round(time.Second), err)
+		err = q.store.RetryJobAt(bg, job.ID, err.Error(), now.Add(delay))
+	}
+	if err != nil {
+		q.log.Error("jobs: recording outcome of job %s: %v", job.ID, err)
+	}
+	return true
+}
+
+// Runs a handler, a panic fails the attempt instead of the server
+func (q *Queue) run(ctx context.Context, h Handler, job *db.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job.Subject, json.RawMessage(job.Payload))
+}
+
+func (q *Queue) prune(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := q.store.PruneJobs(ctx, time.Now().Add(-keepFinished)); err != nil {
+				q.log.Error("jobs: pruning finished jobs: %v", err)
+			} else if n > 0 {
+				q.log.Debug("jobs: pruned %d finished job(s)", n)
+			}
+		}
+	}
+}
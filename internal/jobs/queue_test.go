@@ -0,0 +1,227 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+func newTestQueue(t *testing.T) (*Queue, *stores.Store) {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return New(store, logger.NewWithConfig(&logger.Config{Enabled: false}), 1), store
+}
+
+func mustJob(t *testing.T, store *stores.Store, id string) *db.Job {
+	t.Helper()
+	job, err := store.GetJob(context.Background(), id)
+	if err != nil || job == nil {
+		t.Fatalf("GetJob %s: %v", id, err)
+	}
+	return job
+}
+
+// Failures back off and retry, success finishes the job
+func TestRetryBackoff(t *testing.T) {
+	q, store := newTestQueue(t)
+	ctx := context.Background()
+	var runs []string
+	q.Handle("flaky", func(_ context.Context, subject string, _ json.RawMessage) error {
+		runs = append(runs, subject)
+		if len(runs) == 1 {
+			return errors.New("disk busy")
+		}
+		return nil
+	})
+
+	job, err := q.Enqueue(ctx, "flaky", "a1", nil)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if !q.runNext(ctx) {
+		t.Fatal("due job not run")
+	}
+	got := mustJob(t, store, job.ID)
+	if got.Status != db.JobPending || got.Attempts != 1 || got.LastError != "disk busy" {
+		t.Fatalf("after failure: %+v", got)
+	}
+	if !got.RunAt.After(time.Now().Add(5 * time.Second)) {
+		t.Fatalf("retry not backed off: run_at %s", got.RunAt)
+	}
+	if q.runNext(ctx) {
+		t.Fatal("job ran before its backoff")
+	}
+
+	store.RetryJobAt(ctx, job.ID, got.LastError, time.Now())
+	if !q.runNext(ctx) {
+		t.Fatal("retry not run")
+	}
+	got = mustJob(t, store, job.ID)
+	if got.Status != db.JobDone || got.Attempts != 2 || got.LastError != "" || got.FinishedAt == nil {
+		t.Fatalf("after success: %+v", got)
+	}
+	if len(runs) != 2 || runs[0] != "a1" {
+		t.Fatalf("runs = %v", runs)
+	}
+}
+
+// Permanent errors, panics and spent attempts fail, Retry starts over
+func TestFailAndRetry(t *testing.T) {
+	q, store := newTestQueue(t)
+	ctx := context.Background()
+	q.Handle("bad", func(context.Context, string, json.RawMessage) error {
+		return Permanent(errors.New("blob gone"))
+	})
+	q.Handle("panics", func(context.Context, string, json.RawMessage) error {
+		panic("boom")
+	})
+
+	bad, _ := q.Enqueue(ctx, "bad", "x", nil)
+	q.runNext(ctx)
+	if got := mustJob(t, store, bad.ID); got.Status != db.JobFailed || got.LastError != "blob gone" {
+		t.Fatalf("permanent error: %+v", got)
+	}
+
+	p, _ := q.Enqueue(ctx, "panics", "y", nil)
+	for i := 0; i < MaxAttempts; i++ {
+		store.RetryJobAt(ctx, p.ID, "", time.Now())
+		if !q.runNext(ctx) {
+			t.Fatalf("attempt %d not run", i+1)
+		}
+	}
+	if got := mustJob(t, store, p.ID); got.Status != db.JobFailed || got.Attempts != MaxAttempts || got.LastError != "panic: boom" {
+		t.Fatalf("spent attempts: %+v", got)
+	}
+
+	if ok, err := q.Retry(ctx, p.ID); !ok || err != nil {
+		t.Fatalf("Retry = %v, %v", ok, err)
+	}
+	if got := mustJob(t, store, p.ID); got.Status != db.JobPending || got.Attempts != 0 || got.FinishedAt != nil {
+		t.Fatalf("after Retry: %+v", got)
+	}
+	if ok, _ := q.Retry(ctx, p.ID); ok {
+		t.Fatal("retried a job that has not failed")
+	}
+}
+
+func TestEnqueueCoalesces(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx := context.Background()
+	q.Handle("retention", func(context.Context, string, json.RawMessage) error { return nil })
+
+	first, _ := q.Enqueue(ctx, "retention", "repo:1", nil)
+	again, _ := q.Enqueue(ctx, "retention", "repo:1", nil)
+	other, _ := q.Enqueue(ctx, "retention", "repo:2", nil)
+	if again.ID != first.ID || other.ID == first.ID {
+		t.Fatalf("ids %s %s %s", first.ID, again.ID, other.ID)
+	}
+	if _, err := q.Enqueue(ctx, "unknown", "", nil); err == nil {
+		t.Fatal("queued a kind with no handler")
+	}
+}
+
+// Expedite skips the backoff of a pending job and nothing else
+func TestExpedite(t *testing.T) {
+	q, store := newTestQueue(t)
+	ctx := context.Background()
+	q.Handle("push", func(context.Context, string, json.RawMessage) error { return errors.New("remote down") })
+
+	job, _ := q.Enqueue(ctx, "push", "r1 app:v1", nil)
+	q.runNext(ctx)
+	if q.runNext(ctx) {
+		t.Fatal("job ran before its backoff")
+	}
+	if ok, err := q.Expedite(ctx, job.ID); !ok || err != nil {
+		t.Fatalf("Expedite = %v, %v", ok, err)
+	}
+	if !q.runNext(ctx) {
+		t.Fatal("expedited job not run")
+	}
+	if got := mustJob(t, store, job.ID); got.Attempts != 2 || got.Status != db.JobPending {
+		t.Fatalf("after expedited run: %+v", got)
+	}
+
+	store.FailJob(ctx, job.ID, "remote down", time.Now())
+	if ok, _ := q.Expedite(ctx, job.ID); ok {
+		t.Fatal("expedited a job that is not pending")
+	}
+}
+
+// Jobs a crash left running go back to pending and run on start
+func TestStartRequeues(t *testing.T) {
+	q, store := newTestQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan string, 1)
+	q.Handle("scan", func(_ context.Context, subject string, _ json.RawMessage) error {
+		done <- subject
+		return nil
+	})
+	job, _ := store.EnqueueJob(ctx, &db.Job{Kind: "scan", Subject: "a1"})
+	if _, err := store.ClaimJob(ctx, []string{"scan"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	select {
+	case subject := <-done:
+		if subject != "a1" {
+			t.Fatalf("ran %s", subject)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("interrupted job not resumed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mustJob(t, store, job.ID).Status != db.JobDone {
+		if time.Now().After(deadline) {
+			t.Fatalf("job not finished: %+v", mustJob(t, store, job.ID))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Stopping waits out the running handler and leaves its job for the next start
+func TestWaitAfterStop(t *testing.T) {
+	q, store := newTestQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var finished bool
+	q.Handle("scan", func(ctx context.Context, _ string, _ json.RawMessage) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		finished = true
+		return ctx.Err()
+	})
+	job, _ := q.Enqueue(context.Background(), "scan", "a1", nil)
+	if err := q.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	<-started
+	cancel()
+
+	waitCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	if err := q.Wait(waitCtx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if !finished {
+		t.Fatal("Wait returned before the handler")
+	}
+	if got := mustJob(t, store, job.ID); got.Status != db.JobRunning {
+		t.Fatalf("interrupted job recorded as %s", got.Status)
+	}
+}
@@ -0,0 +1,197 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+// Intent record written before a multi step operation touches anything
+type Entry struct {
+	ID      string          `json:"id"`
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+	Started time.Time       `json:"started"`
+}
+
+// Finishes or unwinds an interrupted operation, must be idempotent since
+// a crash during replay runs it again on the next start
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// Journal keeps one fsynced file per in flight operation. Finished
+// operations drop their file, whatever is left at startup was cut short
+type Journal struct {
+	dir string
+	log *logger.Logger
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// Opens or creates the journal under dataDir/journal
+func Open(dataDir string, log *logger.Logger) (*Journal, error) {
+	dir := filepath.Join(dataDir, "journal")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	return &Journal{dir: dir, log: log, handlers: map[string]Handler{}}, nil
+}
+
+// Registers the replay handler for an operation kind
+func (j *Journal) Handle(kind string, h Handler) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.handlers[kind] = h
+}
+
+// Durably records an operation before it starts, nil journals record nothing
+func (j *Journal) Begin(kind string, payload any) (string, error) {
+	if j == nil {
+		return "", nil
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("encoding %s journal entry: %w", kind, err)
+	}
+	now := time.Now().UTC()
+	e := Entry{
+		ID:      fmt.Sprintf("%020d-%s", now.UnixNano(), uuid.NewString()[:8]),
+		Kind:    kind,
+		Payload: raw,
+		Started: now,
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	if err := j.write(e.ID, body); err != nil {
+		return "", fmt.Errorf("writing %s journal entry: %w", kind, err)
+	}
+	return e.ID, nil
+}
+
+// Temp file, fsync, rename, then fsync the directory so the entry
+// survives a power cut the moment Begin returns
+func (j *Journal) write(id string, body []byte) error {
+	tmp := filepath.Join(j.dir, id+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(j.dir, id+".json")); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return j.syncDir()
+}
+
+func (j *Journal) syncDir() error {
+	d, err := os.Open(j.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Some filesystems refuse directory fsync, the rename already landed
+	_ = d.Sync()
+	return nil
+}
+
+// Drops a finished operation, the empty id from a nil journal is a no-op
+func (j *Journal) Done(id string) {
+	if j == nil || id == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(j.dir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		j.log.Error("journal: dropping entry %s: %v", id, err)
+	}
+}
+
+// Entries still on disk, oldest first
+func (j *Journal) Pending() ([]Entry, error) {
+	if j == nil {
+		return nil, nil
+	}
+	files, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Begin never returned for these, nothing ran behind them
+			os.Remove(filepath.Join(j.dir, name))
+			continue
+		}
+		if f.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(j.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(raw, &e); err != nil || e.ID == "" {
+			j.log.Error("journal: skipping unreadable entry %s: %v", name, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].ID < entries[b].ID })
+	return entries, nil
+}
+
+// Runs the handler of every pending entry in start order. Entries whose
+// handler fails or is missing stay on disk for the next start
+func (j *Journal) Replay(ctx context.Context) (replayed, failed int, err error) {
+	entries, err := j.Pending()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		j.mu.RLock()
+		h := j.handlers[e.Kind]
+		j.mu.RUnlock()
+		if h == nil {
+			j.log.Warn("journal: no handler for %s entry %s, keeping it", e.Kind, e.ID)
+			failed++
+			continue
+		}
+		if err := h(ctx, e.Payload); err != nil {
+			j.log.Error("journal: replaying %s entry %s: %v", e.Kind, e.ID, err)
+			failed++
+			continue
+		}
+		j.Done(e.ID)
+		replayed++
+	}
+	return replayed, failed, nil
+}
@@ -0,0 +1,83 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+type op struct {
+	Name string `json:"name"`
+}
+
+// Only unfinished entries replay, in start order, and failures stay put
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	log := logger.NewWithConfig(&logger.Config{Enabled: false})
+	j, err := Open(dir, log)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	done, _ := j.Begin("finish", op{Name: "done"})
+	j.Done(done)
+	for _, name := range []string{"a", "b"} {
+		if _, err := j.Begin("finish", op{Name: name}); err != nil {
+			t.Fatalf("Begin: %v", err)
+		}
+	}
+	j.Begin("flaky", op{Name: "c"})
+	j.Begin("unknown", op{Name: "d"})
+	// Torn write from a crash inside Begin
+	os.WriteFile(filepath.Join(dir, "journal", "00000000000000000001-torn.tmp"), []byte("{"), 0600)
+
+	restarted, err := Open(dir, log)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	var seen []string
+	restarted.Handle("finish", func(_ context.Context, payload json.RawMessage) error {
+		var o op
+		if err := json.Unmarshal(payload, &o); err != nil {
+			return err
+		}
+		seen = append(seen, o.Name)
+		return nil
+	})
+	restarted.Handle("flaky", func(context.Context, json.RawMessage) error { return errors.New("storage offline") })
+
+	replayed, failed, err := restarted.Replay(context.Background())
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if replayed != 2 || failed != 2 || len(seen) != 2 || seen[0] != "a" || seen[1] != "b" {
+		t.Fatalf("replayed=%d failed=%d seen=%v", replayed, failed, seen)
+	}
+
+	pending, err := restarted.Pending()
+	if err != nil {
+		t.Fatalf("Pending: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Kind != "flaky" || pending[1].Kind != "unknown" {
+		t.Fatalf("pending after replay = %+v", pending)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "journal", "00000000000000000001-torn.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("torn entry not cleaned: %v", err)
+	}
+}
+
+// Nil journals record nothing so callers need no guards
+func TestNilJournal(t *testing.T) {
+	var j *Journal
+	id, err := j.Begin("finish", op{})
+	if err != nil || id != "" {
+		t.Fatalf("Begin on nil = %q, %v", id, err)
+	}
+	j.Done(id)
+	j.Handle("finish", nil)
+}
@@ -0,0 +1,95 @@
+// Plain text mail shared by alerts and user notifications
+package mail
+
+import (
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const timeout = 30 * time.Second
+
+// Smtp relay and sender, Username empty skips auth
+type Transport struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string // Defaults to distroface@host
+}
+
+// Sends over smtp, upgraded with STARTTLS when the server offers it.
+// Credentials are only sent once the link is encrypted
+func Send(t Transport, to []string, subject, body string) error {
+	host, _, err := net.SplitHostPort(t.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", t.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if t.Username != "" {
+		// PlainAuth itself refuses unencrypted links to remote hosts
+		if err := c.Auth(smtp.PlainAuth("", t.Username, t.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	from := t.From
+	if from == "" {
+		from = "distroface@" + host
+	}
+	if err := c.Mail(envelope(from)); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(envelope(addr)); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	msg := strings.Join([]string{
+		"From: " + from,
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Bare address of a header style "Name <addr>" for the smtp envelope
+func envelope(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		return a.Address
+	}
+	return addr
+}
@@ -67,6 +67,20 @@ func (m *Monitor) Subscribe() (<-chan Event, func()) {
 	return m.events.subscribe()
 }
 
+// Open event subscriptions, one per watching client
+func (m *Monitor) Subscribers() int {
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+	return len(m.events.subs)
+}
+
+// Queued or running syncs, keyed per repository
+func (m *Monitor) Inflight() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.inflight)
+}
+
 // Active snapshots every sync running right now
 func (m *Monitor) Active() []Event {
 	m.mu.Lock()
@@ -55,8 +55,10 @@ func ApplyUpdate(prevRaw string, next *v1.MirrorConfig) (string, error) {
 		SyncDepth:           next.GetSyncDepth(),
 		SyncIntervalMinutes: next.GetSyncIntervalMinutes(),
 		Paused:              next.GetPaused(),
+		CredentialId:        next.GetCredentialId(),
 	}
-	if merged.AuthToken == nil {
+	// Moving to a vault credential drops the inline token for good
+	if merged.AuthToken == nil && merged.CredentialId == "" {
 		prev, err := ParseConfig(prevRaw)
 		if err == nil && prev.GetAuthToken() != "" {
 			merged.AuthToken = prev.AuthToken
@@ -81,6 +83,9 @@ func validateCommon(cfg *v1.MirrorConfig) error {
 	if cfg == nil || strings.TrimSpace(cfg.GetUpstream()) == "" {
 		return fmt.Errorf("%w: upstream is required", ErrInvalid)
 	}
+	if cfg.GetCredentialId() != "" && (cfg.GetAuthToken() != "" || cfg.GetUsername() != "") {
+		return fmt.Errorf("%w: use either a credential or an inline username and token", ErrInvalid)
+	}
 	if p := cfg.GetPattern(); p != "" {
 		if _, err := path.Match(p, "probe"); err != nil {
 			return fmt.Errorf("%w: pattern %q is not a valid glob", ErrInvalid, p)
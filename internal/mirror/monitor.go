@@ -13,8 +13,10 @@ import (
 	"github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/vault"
 	"github.com/nickheyer/distroface/pkg/logger"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/proto"
 )
 
 // ErrSyncInFlight rejects a manual sync while one is running
@@ -44,6 +46,7 @@ type Monitor struct {
 	res       *settings.Resolver
 	artifacts *artifacts.Manager
 	oci       *ociSyncer
+	vault     *vault.Vault
 	log       *logger.Logger
 	client    *http.Client
 
@@ -57,7 +60,7 @@ type Monitor struct {
 	events      *hub
 }
 
-func NewMonitor(store *stores.Store, res *settings.Resolver, mgr *artifacts.Manager, oci *ociSyncer, log *logger.Logger) *Monitor {
+func NewMonitor(store *stores.Store, res *settings.Resolver, mgr *artifacts.Manager, oci *ociSyncer, creds *vault.Vault, log *logger.Logger) *Monitor {
 	allowPrivate := func() bool {
 		return res.System(context.Background()).GetMirror().GetAllowPrivateNetworks()
 	}
@@ -85,6 +88,7 @@ func NewMonitor(store *stores.Store, res *settings.Resolver, mgr *artifacts.Mana
 		res:       res,
 		artifacts: mgr,
 		oci:       oci,
+		vault:     creds,
 		log:       log,
 		client:    client,
 		baseCtx:     context.Background(),
@@ -358,7 +362,10 @@ func (m *Monitor) execArtifactSync(ctx context.Context, repo *db.ArtifactReposit
 	m.armCancel(key, cancel)
 	m.beginSync(key, ev)
 
-	syncErr := m.syncArtifactRepo(runCtx, repo, cfg, &state)
+	resolved, syncErr := m.withCredential(runCtx, repo.Namespace, cfg)
+	if syncErr == nil {
+		syncErr = m.syncArtifactRepo(runCtx, repo, resolved, &state)
+	}
 	cancel()
 	if m.disarmCancel(key) && syncErr != nil {
 		syncErr = ErrSyncStopped
@@ -391,7 +398,10 @@ func (m *Monitor) execImageSync(ctx context.Context, repo *db.Repository, cfg *v
 	m.armCancel(key, cancel)
 	m.beginSync(key, ev)
 
-	syncErr := m.oci.syncRepo(runCtx, repo, cfg, m.log)
+	resolved, syncErr := m.withCredential(runCtx, repo.Namespace, cfg)
+	if syncErr == nil {
+		syncErr = m.oci.syncRepo(runCtx, repo, resolved, m.log)
+	}
 	cancel()
 	if m.disarmCancel(key) && syncErr != nil {
 		syncErr = ErrSyncStopped
@@ -471,7 +481,7 @@ func (m *Monitor) syncArtifactRepo(ctx context.Context, repo *db.ArtifactReposit
 	state.ListETag = list.etag
 	rels := filterReleases(list.releases, cfg)
 
-	maxBytes := m.artifacts.EffectiveMaxFileSizeBytes(ctx, repo.Namespace)
+	maxBytes := m.artifacts.RepoMaxFileSizeBytes(ctx, repo)
 	var errs []error
 	synced := 0
 	// Oldest first so ingest order matches release chronology
@@ -565,7 +575,7 @@ func (m *Monitor) downloadAsset(ctx context.Context, repo *db.ArtifactRepository
 	}
 
 	blobs := m.artifacts.Blobs()
-	uploadID, err := blobs.InitiateUpload()
+	uploadID, err := m.artifacts.InitiateUpload(ctx, repo, max(resp.ContentLength, 0))
 	if err != nil {
 		return err
 	}
@@ -608,8 +618,60 @@ func (m *Monitor) checkInterval(ctx context.Context, cfg *v1.MirrorConfig) error
 	return nil
 }
 
+// Copy of cfg carrying the login of its vault credential, upstream calls
+// never see the reference
+func (m *Monitor) withCredential(ctx context.Context, namespace string, cfg *v1.MirrorConfig) (*v1.MirrorConfig, error) {
+	if cfg.GetCredentialId() == "" {
+		return cfg, nil
+	}
+	if m.vault == nil {
+		return nil, fmt.Errorf("%w: credential vault is unavailable", ErrInvalid)
+	}
+	user, secret, err := m.vault.Resolve(ctx, namespace, cfg.GetCredentialId())
+	if errors.Is(err, vault.ErrNotFound) || errors.Is(err, vault.ErrForeign) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := proto.Clone(cfg).(*v1.MirrorConfig)
+	out.Username, out.AuthToken = user, &secret
+	return out, nil
+}
+
+// Mirror repos and replication rules that reference the credential
+func (m *Monitor) CredentialUsers(ctx context.Context, id string) ([]string, error) {
+	var names []string
+	repos, err := m.store.ListMirrorArtifactRepositories(ctx, MirrorArtifactTypes)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range repos {
+		if cfg, err := ParseConfig(repo.MirrorConfig); err == nil && cfg.GetCredentialId() == id {
+			names = append(names, repo.Namespace+"/"+repo.Name)
+		}
+	}
+	imageRepos, err := m.store.ListMirrorRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range imageRepos {
+		if cfg, err := ParseConfig(repo.MirrorConfig); err == nil && cfg.GetCredentialId() == id {
+			names = append(names, repo.Namespace+"/"+repo.Name)
+		}
+	}
+	rules, err := m.store.ReplicationRulesByCredential(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		names = append(names, "replication rule "+rule.Namespace+"/"+rule.Name)
+	}
+	return names, nil
+}
+
 // ValidateArtifactMirror rejects bad configs and probes the upstream live
-func (m *Monitor) ValidateArtifactMirror(ctx context.Context, t v1.ArtifactRepoType, cfg *v1.MirrorConfig) error {
+func (m *Monitor) ValidateArtifactMirror(ctx context.Context, namespace string, t v1.ArtifactRepoType, cfg *v1.MirrorConfig) error {
 	drv := driverFor(t)
 	if drv == nil {
 		return fmt.Errorf("%w: repo type %v does not support mirroring", ErrInvalid, t)
@@ -620,13 +682,17 @@ func (m *Monitor) ValidateArtifactMirror(ctx context.Context, t v1.ArtifactRepoT
 	if err := m.checkInterval(ctx, cfg); err != nil {
 		return err
 	}
+	cfg, err := m.withCredential(ctx, namespace, cfg)
+	if err != nil {
+		return err
+	}
 	probeCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 	return drv.validate(probeCtx, m.client, cfg)
 }
 
 // ValidateRegistryMirror rejects bad configs and probes the oci upstream live
-func (m *Monitor) ValidateRegistryMirror(ctx context.Context, cfg *v1.MirrorConfig) error {
+func (m *Monitor) ValidateRegistryMirror(ctx context.Context, namespace string, cfg *v1.MirrorConfig) error {
 	if m.oci == nil {
 		return fmt.Errorf("%w: registry mirroring is unavailable", ErrInvalid)
 	}
@@ -636,6 +702,10 @@ func (m *Monitor) ValidateRegistryMirror(ctx context.Context, cfg *v1.MirrorConf
 	if err := m.checkInterval(ctx, cfg); err != nil {
 		return err
 	}
+	cfg, err := m.withCredential(ctx, namespace, cfg)
+	if err != nil {
+		return err
+	}
 	probeCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 	return m.oci.validate(probeCtx, cfg)
@@ -165,6 +165,45 @@ func (o *ociSyncer) syncRepo(ctx context.Context, repo *db.Repository, cfg *v1.M
 	return nil
 }
 
+// Fetches whatever a mirrored image is missing here from upstream. Pinned
+// to the digest the tag already holds, a moved upstream tag changes nothing
+func (m *Monitor) PrewarmImage(ctx context.Context, repo *db.Repository, dgst string) error {
+	if m.oci == nil || repo.Type != v1.RepositoryType_REPOSITORY_TYPE_MIRROR {
+		return fmt.Errorf("%w: repository is not a mirror", ErrInvalid)
+	}
+	cfg, err := ParseConfig(repo.MirrorConfig)
+	if err != nil {
+		return err
+	}
+	if !m.res.System(ctx).GetMirror().GetEnabled() {
+		return ErrDisabled
+	}
+	if state := ParseState(repo.MirrorState); state.RateLimited && state.CoolingDown(time.Now()) {
+		return &CooldownError{Until: state.CooldownUntil}
+	}
+	resolved, err := m.withCredential(ctx, repo.Namespace, cfg)
+	if err != nil {
+		return err
+	}
+	return m.oci.fetchDigest(ctx, repo, resolved, dgst)
+}
+
+func (o *ociSyncer) fetchDigest(ctx context.Context, repo *db.Repository, cfg *v1.MirrorConfig, dgst string) error {
+	src, err := upstreamRepo(cfg.GetUpstream())
+	if err != nil {
+		return err
+	}
+	dst, err := name.NewDigest(localRegistryHost + "/" + repo.Namespace + "/" + repo.Name + "@" + dgst)
+	if err != nil {
+		return err
+	}
+	// Blobs already here are skipped by the writes, only the gaps transfer
+	if err := o.copyTag(src.Digest(dgst), dst, o.srcOpts(ctx, cfg), o.dstOpts(ctx, repo.Namespace, repo.Name)); err != nil {
+		return classifyOCIErr(err)
+	}
+	return nil
+}
+
 // True when the newest collected error is an upstream rate limit
 func rateLimited(errs []error) bool {
 	if len(errs) == 0 {
@@ -183,8 +222,40 @@ func classifyOCIErr(err error) error {
 	return err
 }
 
+// Copies a local image to dst:dstTag through the registry handler as actor,
+// so push policy, webhooks and signing see an ordinary push. The source is
+// pinned by digest, a concurrent retag cannot change what lands
+func (m *Monitor) CopyLocalTag(ctx context.Context, actor, src, srcDigest, dst, dstTag string) error {
+	if m.oci == nil {
+		return fmt.Errorf("registry copies are not available")
+	}
+	return m.oci.copyLocal(ctx, actor, src, srcDigest, dst, dstTag)
+}
+
+func (o *ociSyncer) copyLocal(ctx context.Context, actor, src, srcDigest, dst, dstTag string) error {
+	from, err := name.NewDigest(localRegistryHost + "/" + src + "@" + srcDigest)
+	if err != nil {
+		return err
+	}
+	to, err := name.NewTag(localRegistryHost + "/" + dst + ":" + dstTag)
+	if err != nil {
+		return err
+	}
+	rt := &inprocTransport{
+		handler: o.registry,
+		token: func() (string, error) {
+			return o.tokens.SignToken(actor, []*auth.ResourceActions{
+				{Type: "repository", Name: src, Actions: []string{"pull"}},
+				{Type: "repository", Name: dst, Actions: []string{"pull", "push"}},
+			})
+		},
+	}
+	opts := []remote.Option{remote.WithContext(ctx), remote.WithTransport(rt)}
+	return o.copyTag(from, to, opts, opts)
+}
+
 // Copies one tag preserving multi arch indexes
-func (o *ociSyncer) copyTag(src, dst name.Tag, srcOpts, dstOpts []remote.Option) error {
+func (o *ociSyncer) copyTag(src, dst name.Reference, srcOpts, dstOpts []remote.Option) error {
 	desc, err := remote.Get(src, srcOpts...)
 	if err != nil {
 		return err
@@ -0,0 +1,318 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/jobs"
+	"github.com/nickheyer/distroface/internal/vault"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/pages"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Job kind copying one pushed tag to a replication target, the subject is
+// the rule id and name:tag
+const JobReplicate = "image.replicate"
+
+// Actor local reads of the replicator run as
+const replicationActor = "system:replication"
+
+// The local tag went away before its copy ran
+var errTagGone = errors.New("tag no longer exists")
+
+// Copies pushed tags on to the remote registries of replication rules
+type Replicator struct {
+	store     *stores.Store
+	oci       *ociSyncer
+	vault     *vault.Vault
+	jobs      *jobs.Queue
+	transport http.RoundTripper
+	log       *logger.Logger
+}
+
+// Shares the monitor's registry access and vault. Remotes get the mirror
+// network guard but no pacing, the targets are the owner's own registries
+func NewReplicator(store *stores.Store, m *Monitor, log *logger.Logger) *Replicator {
+	allowPrivate := func() bool {
+		return m.res.System(context.Background()).GetMirror().GetAllowPrivateNetworks()
+	}
+	return &Replicator{store: store, oci: m.oci, vault: m.vault, transport: safeTransport(allowPrivate), log: log}
+}
+
+// Copies run on the queue so pushes return at once, and failed copies
+// retry with backoff and survive restarts
+func (r *Replicator) SetJobs(q *jobs.Queue) {
+	r.jobs = q
+	q.Handle(JobReplicate, r.run)
+}
+
+// Available once the queue and the registry handler are wired
+func (r *Replicator) Available() bool {
+	return r.jobs != nil && r.oci != nil
+}
+
+// Queues a copy for every enabled rule covering the pushed tag. A pending
+// copy of the tag absorbs it, jobs copy what the tag holds when they run
+func (r *Replicator) Pushed(ctx context.Context, namespace, repo, tag string) {
+	if !r.Available() || tag == "" {
+		return
+	}
+	rules, err := r.store.EnabledReplicationRules(ctx, namespace)
+	if err != nil {
+		r.log.Error("replication: failed to load rules of %s: %v", namespace, err)
+		return
+	}
+	for _, rule := range rules {
+		if RuleCovers(rule, repo, tag) {
+			r.queue(ctx, rule, repo, tag)
+		}
+	}
+}
+
+// Retries the rule's failed copies, runs the ones backing off now and
+// queues every tag the rule covers
+func (r *Replicator) Sync(ctx context.Context, rule *db.ReplicationRule) (int, error) {
+	if !r.Available() {
+		return 0, errors.New("replication is unavailable")
+	}
+	open, err := r.store.ListOpenJobs(ctx, JobReplicate, rule.ID+" ")
+	if err != nil {
+		return 0, err
+	}
+	for _, j := range open {
+		switch j.Status {
+		case db.JobFailed:
+			_, err = r.jobs.Retry(ctx, j.ID)
+		case db.JobPending:
+			_, err = r.jobs.Expedite(ctx, j.ID)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	queued := 0
+	const pageSize = 500
+	for offset := 0; ; offset += pageSize {
+		repos, _, err := r.store.ListRepositories(ctx, stores.RepoFilter{Namespace: rule.Namespace}, pages.Query{}, "name ASC", "", true, nil, pageSize, offset)
+		if err != nil {
+			return queued, err
+		}
+		for _, repo := range repos {
+			if ok, _ := path.Match(rule.RepoPattern, repo.Name); !ok {
+				continue
+			}
+			src, err := name.NewRepository(localRegistryHost + "/" + repo.Namespace + "/" + repo.Name)
+			if err != nil {
+				continue
+			}
+			tags, err := remote.List(src, r.oci.pullOpts(ctx, repo.Namespace+"/"+repo.Name)...)
+			if err != nil {
+				// Repos without a push yet have no tags to list
+				continue
+			}
+			for _, tag := range tags {
+				if RuleCovers(rule, repo.Name, tag) && r.queue(ctx, rule, repo.Name, tag) {
+					queued++
+				}
+			}
+		}
+		if len(repos) < pageSize {
+			return queued, nil
+		}
+	}
+}
+
+// Copies of the rule still queued, running or out of retries
+func (r *Replicator) Jobs(ctx context.Context, ruleID string) ([]*db.Job, error) {
+	return r.store.ListOpenJobs(ctx, JobReplicate, ruleID+" ")
+}
+
+func (r *Replicator) queue(ctx context.Context, rule *db.ReplicationRule, repo, tag string) bool {
+	if _, err := r.jobs.Enqueue(ctx, JobReplicate, replicationSubject(rule.ID, repo, tag), nil); err != nil {
+		r.log.Error("replication: failed to queue %s/%s:%s for %s: %v", rule.Namespace, repo, tag, rule.Name, err)
+		return false
+	}
+	return true
+}
+
+func (r *Replicator) run(ctx context.Context, subject string, _ json.RawMessage) error {
+	ruleID, repo, tag, ok := parseReplicationSubject(subject)
+	if !ok {
+		return jobs.Permanent(errors.New("subject is not a rule id and name:tag"))
+	}
+	rule, err := r.store.GetReplicationRule(ctx, ruleID)
+	if err != nil {
+		return err
+	}
+	// Removed, paused or narrowed since the push
+	if rule == nil || !rule.Enabled || !RuleCovers(rule, repo, tag) {
+		return nil
+	}
+
+	dgst, err := r.replicate(ctx, rule, repo, tag)
+	if errors.Is(err, errTagGone) {
+		return nil
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = truncate(err.Error(), 1000)
+	}
+	if rerr := r.store.RecordReplication(statusCtx(ctx), rule.ID, repo+":"+tag, dgst, errMsg, time.Now()); rerr != nil {
+		r.log.Error("replication: failed to record status of %s: %v", rule.Name, rerr)
+	}
+	if err != nil {
+		return err
+	}
+	r.log.Info("replication: copied %s/%s:%s to %s (%s)", rule.Namespace, repo, tag, rule.Target, dgst)
+	return nil
+}
+
+func (r *Replicator) replicate(ctx context.Context, rule *db.ReplicationRule, repo, tag string) (string, error) {
+	if r.oci == nil {
+		return "", jobs.Permanent(errors.New("registry replication is unavailable"))
+	}
+	src, err := name.NewTag(localRegistryHost + "/" + rule.Namespace + "/" + repo + ":" + tag)
+	if err != nil {
+		return "", jobs.Permanent(err)
+	}
+	dst, err := replicaTag(rule.Target, repo, tag)
+	if err != nil {
+		return "", jobs.Permanent(err)
+	}
+	login, err := r.remoteAuth(ctx, rule)
+	if err != nil {
+		return "", err
+	}
+	dstOpts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithTransport(r.transport),
+		remote.WithAuth(login),
+	}
+	return r.oci.replicate(src, dst, r.oci.pullOpts(ctx, rule.Namespace+"/"+repo), dstOpts)
+}
+
+// Login for the rule's target, anonymous without a credential
+func (r *Replicator) remoteAuth(ctx context.Context, rule *db.ReplicationRule) (authn.Authenticator, error) {
+	if rule.CredentialID == "" {
+		return authn.Anonymous, nil
+	}
+	if r.vault == nil {
+		return nil, jobs.Permanent(errors.New("credential vault is unavailable"))
+	}
+	user, secret, err := r.vault.Resolve(ctx, rule.Namespace, rule.CredentialID)
+	if errors.Is(err, vault.ErrNotFound) || errors.Is(err, vault.ErrForeign) {
+		return nil, jobs.Permanent(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return upstreamAuth(&v1.MirrorConfig{Username: user, AuthToken: &secret}), nil
+}
+
+// Reads a local repository through the registry handler
+func (o *ociSyncer) pullOpts(ctx context.Context, repo string) []remote.Option {
+	rt := &inprocTransport{
+		handler: o.registry,
+		token: func() (string, error) {
+			return o.tokens.SignToken(replicationActor, []*auth.ResourceActions{
+				{Type: "repository", Name: repo, Actions: []string{"pull"}},
+			})
+		},
+	}
+	return []remote.Option{remote.WithContext(ctx), remote.WithTransport(rt)}
+}
+
+// Copies what src holds to dst and returns its digest. The source is
+// pinned once resolved, and a remote already holding it is left alone
+func (o *ociSyncer) replicate(src, dst name.Tag, srcOpts, dstOpts []remote.Option) (string, error) {
+	desc, err := remote.Head(src, srcOpts...)
+	if err != nil {
+		var te *transport.Error
+		if errors.As(err, &te) && te.StatusCode == http.StatusNotFound {
+			return "", errTagGone
+		}
+		return "", err
+	}
+	dgst := desc.Digest.String()
+	if have, err := remote.Head(dst, dstOpts...); err == nil && have.Digest == desc.Digest {
+		return dgst, nil
+	}
+	if err := o.copyTag(src.Context().Digest(dgst), dst, srcOpts, dstOpts); err != nil {
+		var te *transport.Error
+		if errors.As(err, &te) && (te.StatusCode == http.StatusUnauthorized || te.StatusCode == http.StatusForbidden) {
+			// Retrying with the same login gets the same answer
+			return dgst, jobs.Permanent(err)
+		}
+		return dgst, err
+	}
+	return dgst, nil
+}
+
+// True when the rule's patterns cover the repo name and tag
+func RuleCovers(rule *db.ReplicationRule, repo, tag string) bool {
+	repoOK, _ := path.Match(rule.RepoPattern, repo)
+	tagOK, _ := path.Match(rule.TagPattern, tag)
+	return repoOK && tagOK
+}
+
+// Normalizes a replication target, a registry host with an optional path
+func ReplicationTarget(target string) (string, error) {
+	s := strings.TrimSpace(target)
+	s = strings.TrimPrefix(s, "https://")
+	s = strings.TrimPrefix(s, "http://")
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return "", errors.New("target is required")
+	}
+	host, _, _ := strings.Cut(s, "/")
+	// Without one a bare name would quietly mean docker hub
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "", errors.New("target must start with a registry host, e.g. ghcr.io/acme")
+	}
+	if host == localRegistryHost {
+		return "", fmt.Errorf("target host %q is reserved", host)
+	}
+	if strings.ContainsAny(path.Base(s), "@") || (strings.Contains(s, "/") && strings.Contains(path.Base(s), ":")) {
+		return "", errors.New("target must be a registry path without a tag or digest")
+	}
+	if _, err := replicaTag(s, "probe", "latest"); err != nil {
+		return "", fmt.Errorf("invalid target: %v", err)
+	}
+	return s, nil
+}
+
+// Remote tag a rule copies name:tag to
+func replicaTag(target, repo, tag string) (name.Tag, error) {
+	return name.NewTag(target+"/"+repo+":"+tag, name.StrictValidation)
+}
+
+func replicationSubject(ruleID, repo, tag string) string {
+	return ruleID + " " + repo + ":" + tag
+}
+
+func parseReplicationSubject(subject string) (ruleID, repo, tag string, ok bool) {
+	ruleID, ref, ok := strings.Cut(subject, " ")
+	if !ok || ruleID == "" {
+		return "", "", "", false
+	}
+	i := strings.LastIndex(ref, ":")
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", "", false
+	}
+	return ruleID, ref[:i], ref[i+1:], true
+}
@@ -0,0 +1,152 @@
+package mirror
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/nickheyer/distroface/internal/db"
+)
+
+func TestReplicationTarget(t *testing.T) {
+	cases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"ghcr.io/acme", "ghcr.io/acme", true},
+		{" https://ghcr.io/acme/ ", "ghcr.io/acme", true},
+		{"registry.example.com:5000", "registry.example.com:5000", true},
+		{"localhost:5000/team/mirror", "localhost:5000/team/mirror", true},
+		{"docker.io/acme", "docker.io/acme", true},
+		{"", "", false},
+		{"acme", "", false},
+		{"acme/app", "", false},
+		{"ghcr.io/acme:v1", "", false},
+		{"ghcr.io/acme@sha256:abc", "", false},
+		{"ghcr.io/Acme", "", false},
+		{localRegistryHost + "/acme", "", false},
+	}
+	for _, tc := range cases {
+		got, err := ReplicationTarget(tc.in)
+		if (err == nil) != tc.ok {
+			t.Errorf("ReplicationTarget(%q) err = %v, want ok %v", tc.in, err, tc.ok)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ReplicationTarget(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestRuleCovers(t *testing.T) {
+	rule := &db.ReplicationRule{RepoPattern: "api-*", TagPattern: "v*"}
+	cases := []struct {
+		repo, tag string
+		want      bool
+	}{
+		{"api-gateway", "v1.2.0", true},
+		{"api-gateway", "latest", false},
+		{"web", "v1.2.0", false},
+		{"api-", "v", true},
+	}
+	for _, tc := range cases {
+		if got := RuleCovers(rule, tc.repo, tc.tag); got != tc.want {
+			t.Errorf("RuleCovers(%q, %q) = %v, want %v", tc.repo, tc.tag, got, tc.want)
+		}
+	}
+}
+
+func TestReplicationSubject(t *testing.T) {
+	subject := replicationSubject("rule-1", "team/app", "v1.0")
+	id, repo, tag, ok := parseReplicationSubject(subject)
+	if !ok || id != "rule-1" || repo != "team/app" || tag != "v1.0" {
+		t.Fatalf("parse(%q) = %q %q %q %v", subject, id, repo, tag, ok)
+	}
+	for _, bad := range []string{"", "rule-1", "rule-1 app", "rule-1 app:", " app:v1"} {
+		if _, _, _, ok := parseReplicationSubject(bad); ok {
+			t.Errorf("parse(%q) accepted a malformed subject", bad)
+		}
+	}
+}
+
+func quietRegistry() http.Handler {
+	return registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+}
+
+func TestReplicateCopiesTag(t *testing.T) {
+	// Source is served in process like the embedded registry
+	local := quietRegistry()
+	srcOpts := []remote.Option{remote.WithTransport(&inprocTransport{
+		handler: local,
+		token:   func() (string, error) { return "test", nil },
+	})}
+	src, err := name.NewTag(localRegistryHost + "/acme/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(src, img, srcOpts...); err != nil {
+		t.Fatalf("seeding source: %v", err)
+	}
+	want, _ := img.Digest()
+
+	remoteSrv := httptest.NewServer(quietRegistry())
+	defer remoteSrv.Close()
+	target, err := ReplicationTarget(strings.TrimPrefix(remoteSrv.URL, "http://") + "/mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := replicaTag(target, "app", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := &ociSyncer{}
+	dgst, err := o.replicate(src, dst, srcOpts, nil)
+	if err != nil {
+		t.Fatalf("replicate: %v", err)
+	}
+	if dgst != want.String() {
+		t.Fatalf("replicated digest %s, want %s", dgst, want)
+	}
+	desc, err := remote.Head(dst)
+	if err != nil {
+		t.Fatalf("remote copy missing: %v", err)
+	}
+	if desc.Digest != want {
+		t.Fatalf("remote holds %s, want %s", desc.Digest, want)
+	}
+
+	// A repeat finds the remote current and sends nothing
+	var writes int
+	counted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writes++
+		}
+		remoteSrv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counted.Close()
+	again, _ := replicaTag(strings.TrimPrefix(counted.URL, "http://")+"/mirror", "app", "v1")
+	if _, err := o.replicate(src, again, srcOpts, nil); err != nil {
+		t.Fatalf("repeat replicate: %v", err)
+	}
+	if writes != 0 {
+		t.Fatalf("repeat replicate made %d writes, want none", writes)
+	}
+
+	gone, _ := name.NewTag(localRegistryHost + "/acme/app:v2")
+	if _, err := o.replicate(gone, dst, srcOpts, nil); !errors.Is(err, errTagGone) {
+		t.Fatalf("replicating a missing tag: %v, want errTagGone", err)
+	}
+}
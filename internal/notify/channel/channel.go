@@ -0,0 +1,278 @@
+// Outbound notification channels shared by alerts, repository events and
+// user notifications. A channel turns one Message into an email, a chat
+// post or a webhook body, optionally through an operator template
+package channel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/mail"
+	"github.com/nickheyer/distroface/internal/webhook"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Channel types
+const (
+	TypeEmail   = "email"
+	TypeSlack   = "slack"
+	TypeTeams   = "teams"
+	TypeWebhook = "webhook"
+)
+
+// Sent by the test endpoint, matched by every channel
+const EventTest = "test"
+
+const maxResponseBody = 1024
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// One event as every channel type sees it. Data is the source payload,
+// posted as is by webhook channels and reachable from templates
+type Message struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Subject   string    `json:"subject"`
+	Text      string    `json:"text"`
+	Fields    []Field   `json:"fields,omitempty"`
+	Link      string    `json:"link,omitempty"`
+	Data      any       `json:"data,omitempty"`
+}
+
+// Labelled value shown under the text, facts in teams
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Channel interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// What channels need from the server, Mail is swapped out by tests
+type Deps struct {
+	SMTP   mail.Transport
+	Client *http.Client
+	Mail   func(t mail.Transport, to []string, subject, body string) error
+}
+
+// Builds the channel a config describes, Validate errors come back as is
+func New(cfg *v1.NotificationChannel, deps Deps) (Channel, error) {
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	switch cfg.GetType() {
+	case TypeEmail:
+		if deps.SMTP.Addr == "" {
+			return nil, fmt.Errorf("channel %s: no smtp server configured", cfg.GetName())
+		}
+		send := deps.Mail
+		if send == nil {
+			send = mail.Send
+		}
+		return &Email{Transport: deps.SMTP, To: cfg.GetTo(), Template: cfg.GetTemplate(), Mailer: send}, nil
+	case TypeSlack:
+		return &Slack{URL: cfg.GetUrl(), Template: cfg.GetTemplate(), Client: deps.Client}, nil
+	case TypeTeams:
+		return &Teams{URL: cfg.GetUrl(), Template: cfg.GetTemplate(), Client: deps.Client}, nil
+	default:
+		return &Webhook{URL: cfg.GetUrl(), Secret: cfg.GetSecret(), Template: cfg.GetTemplate(), Client: deps.Client}, nil
+	}
+}
+
+// Checks a channel config before it is stored or used
+func Validate(cfg *v1.NotificationChannel) error {
+	if !namePattern.MatchString(cfg.GetName()) {
+		return fmt.Errorf("channel name %q must be letters, digits, '.', '_' or '-'", cfg.GetName())
+	}
+	switch cfg.GetType() {
+	case TypeEmail:
+		if len(cfg.GetTo()) == 0 {
+			return fmt.Errorf("email channel %s needs at least one recipient", cfg.GetName())
+		}
+		for _, to := range cfg.GetTo() {
+			if _, err := netmail.ParseAddress(to); err != nil {
+				return fmt.Errorf("email channel %s: invalid recipient %q", cfg.GetName(), to)
+			}
+		}
+	case TypeSlack, TypeTeams, TypeWebhook:
+		u, err := url.Parse(cfg.GetUrl())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s channel %s needs an absolute http(s) url", cfg.GetType(), cfg.GetName())
+		}
+	default:
+		return fmt.Errorf("channel %s: type must be email, slack, teams or webhook", cfg.GetName())
+	}
+	if len(cfg.GetEvents()) == 0 {
+		return fmt.Errorf("channel %s needs at least one event pattern", cfg.GetName())
+	}
+	for _, e := range cfg.GetEvents() {
+		if _, err := path.Match(e, ""); err != nil || strings.TrimSpace(e) == "" {
+			return fmt.Errorf("channel %s: invalid event pattern %q", cfg.GetName(), e)
+		}
+	}
+	if err := ValidateTemplate(cfg.GetTemplate()); err != nil {
+		return fmt.Errorf("channel %s: %w", cfg.GetName(), err)
+	}
+	return nil
+}
+
+// Whether the channel takes the event, glob patterns like alert.* match
+// by path rules. Tests reach every channel
+func Matches(cfg *v1.NotificationChannel, event string) bool {
+	if event == EventTest {
+		return true
+	}
+	for _, p := range cfg.GetEvents() {
+		if ok, _ := path.Match(p, event); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Plain text mail
+type Email struct {
+	Transport mail.Transport
+	To        []string
+	Template  string
+	Mailer    func(t mail.Transport, to []string, subject, body string) error
+}
+
+func (c *Email) Send(ctx context.Context, msg Message) error {
+	body, err := render(c.Template, msg, plainText)
+	if err != nil {
+		return err
+	}
+	return c.Mailer(c.Transport, c.To, msg.Subject, body)
+}
+
+// Slack incoming webhook, mrkdwn text
+type Slack struct {
+	URL      string
+	Template string
+	Client   *http.Client
+}
+
+func (c *Slack) Send(ctx context.Context, msg Message) error {
+	text, err := render(c.Template, msg, slackText)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return post(ctx, c.Client, c.URL, body, nil)
+}
+
+// Teams incoming webhook, a message card with the fields as facts
+type Teams struct {
+	URL      string
+	Template string
+	Client   *http.Client
+}
+
+type teamsCard struct {
+	Type     string         `json:"@type"`
+	Context  string         `json:"@context"`
+	Summary  string         `json:"summary"`
+	Title    string         `json:"title"`
+	Text     string         `json:"text"`
+	Sections []teamsSection `json:"sections,omitempty"`
+}
+
+type teamsSection struct {
+	Facts []Field `json:"facts"`
+}
+
+func (c *Teams) Send(ctx context.Context, msg Message) error {
+	text := msg.Text
+	if msg.Link != "" {
+		text += "\n\n" + msg.Link
+	}
+	card := teamsCard{
+		Type:    "MessageCard",
+		Context: "https://schema.org/extensions",
+		Summary: msg.Subject,
+		Title:   msg.Subject,
+		Text:    text,
+	}
+	if c.Template != "" {
+		rendered, err := render(c.Template, msg, nil)
+		if err != nil {
+			return err
+		}
+		card.Text = rendered
+	} else if len(msg.Fields) > 0 {
+		card.Sections = []teamsSection{{Facts: msg.Fields}}
+	}
+	body, err := json.Marshal(card)
+	if err != nil {
+		return err
+	}
+	return post(ctx, c.Client, c.URL, body, nil)
+}
+
+// Generic json post signed like repository webhooks. The template, when
+// set, renders the whole body
+type Webhook struct {
+	URL      string
+	Secret   string
+	Template string
+	Client   *http.Client
+}
+
+func (c *Webhook) Send(ctx context.Context, msg Message) error {
+	var body []byte
+	if c.Template != "" {
+		rendered, err := render(c.Template, msg, nil)
+		if err != nil {
+			return err
+		}
+		body = []byte(rendered)
+	} else {
+		raw, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		body = raw
+	}
+	headers := map[string]string{"X-Distroface-Event": msg.Event}
+	if c.Secret != "" {
+		name, value := webhook.Signature(c.Secret, body)
+		headers[name] = value
+	}
+	return post(ctx, c.Client, c.URL, body, headers)
+}
+
+func post(ctx context.Context, client *http.Client, target string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+		return fmt.Errorf("%s answered %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
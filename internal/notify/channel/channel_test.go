@@ -0,0 +1,191 @@
+package channel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nickheyer/distroface/internal/mail"
+	"github.com/nickheyer/distroface/internal/webhook"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Records every post the channels make
+type capture struct {
+	srv     *httptest.Server
+	bodies  []string
+	headers []http.Header
+	status  int
+}
+
+func newCapture(t *testing.T) *capture {
+	c := &capture{status: http.StatusOK}
+	c.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		c.bodies = append(c.bodies, string(b))
+		c.headers = append(c.headers, r.Header.Clone())
+		w.WriteHeader(c.status)
+		io.WriteString(w, "nope")
+	}))
+	t.Cleanup(c.srv.Close)
+	return c
+}
+
+var alertMsg = Message{
+	Event:   "alert.firing",
+	Subject: "[distroface] storage alert FIRING",
+	Text:    "Data volume is 91% full",
+	Fields:  []Field{{Name: "Rule", Value: "storage"}},
+	Data:    map[string]any{"rule": "storage"},
+}
+
+func TestValidate(t *testing.T) {
+	ok := &v1.NotificationChannel{Name: "ops", Type: TypeSlack, Url: "https://hooks.slack.com/x", Events: []string{"alert.*"}}
+	if err := Validate(ok); err != nil {
+		t.Fatalf("valid channel: %v", err)
+	}
+	for name, mutate := range map[string]func(c *v1.NotificationChannel){
+		"bad name":       func(c *v1.NotificationChannel) { c.Name = "has space" },
+		"unknown type":   func(c *v1.NotificationChannel) { c.Type = "pager" },
+		"relative url":   func(c *v1.NotificationChannel) { c.Url = "/hook" },
+		"no events":      func(c *v1.NotificationChannel) { c.Events = nil },
+		"bad pattern":    func(c *v1.NotificationChannel) { c.Events = []string{"alert.["} },
+		"bad template":   func(c *v1.NotificationChannel) { c.Template = "{{ .Nope" },
+		"no recipients":  func(c *v1.NotificationChannel) { c.Type, c.Url = TypeEmail, "" },
+		"bad recipients": func(c *v1.NotificationChannel) { c.Type, c.To = TypeEmail, []string{"not an address"} },
+	} {
+		c := proto.Clone(ok).(*v1.NotificationChannel)
+		mutate(c)
+		if err := Validate(c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	c := &v1.NotificationChannel{Events: []string{"alert.*", "push"}}
+	for event, want := range map[string]bool{
+		"alert.firing":   true,
+		"alert.resolved": true,
+		"push":           true,
+		"pull":           false,
+		"delete":         false,
+		EventTest:        true,
+	} {
+		if got := Matches(c, event); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", event, got, want)
+		}
+	}
+}
+
+func TestSlack(t *testing.T) {
+	hook := newCapture(t)
+	ch, err := New(&v1.NotificationChannel{Name: "chat", Type: TypeSlack, Url: hook.srv.URL, Events: []string{"*"}}, Deps{Client: hook.srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Send(context.Background(), alertMsg); err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]string
+	if err := json.Unmarshal([]byte(hook.bodies[0]), &body); err != nil {
+		t.Fatal(err)
+	}
+	if want := "*[distroface] storage alert FIRING*\nData volume is 91% full\n• *Rule:* storage"; body["text"] != want {
+		t.Fatalf("text = %q, want %q", body["text"], want)
+	}
+
+	hook.status = http.StatusBadRequest
+	if err := ch.Send(context.Background(), alertMsg); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("rejected post: err = %v", err)
+	}
+}
+
+func TestTeams(t *testing.T) {
+	hook := newCapture(t)
+	ch, _ := New(&v1.NotificationChannel{Name: "teams", Type: TypeTeams, Url: hook.srv.URL, Events: []string{"*"}}, Deps{Client: hook.srv.Client()})
+	if err := ch.Send(context.Background(), alertMsg); err != nil {
+		t.Fatal(err)
+	}
+	var card teamsCard
+	if err := json.Unmarshal([]byte(hook.bodies[0]), &card); err != nil {
+		t.Fatal(err)
+	}
+	if card.Type != "MessageCard" || card.Title != alertMsg.Subject || card.Text != alertMsg.Text {
+		t.Fatalf("card = %+v", card)
+	}
+	if len(card.Sections) != 1 || card.Sections[0].Facts[0] != alertMsg.Fields[0] {
+		t.Fatalf("facts = %+v", card.Sections)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	hook := newCapture(t)
+	cfg := &v1.NotificationChannel{Name: "hook", Type: TypeWebhook, Url: hook.srv.URL, Secret: proto.String("s3cret"), Events: []string{"*"}}
+	ch, _ := New(cfg, Deps{Client: hook.srv.Client()})
+	if err := ch.Send(context.Background(), alertMsg); err != nil {
+		t.Fatal(err)
+	}
+	var got Message
+	if err := json.Unmarshal([]byte(hook.bodies[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != alertMsg.Event || got.Subject != alertMsg.Subject {
+		t.Fatalf("body = %s", hook.bodies[0])
+	}
+	name, value := webhook.Signature("s3cret", []byte(hook.bodies[0]))
+	if hook.headers[0].Get(name) != value || hook.headers[0].Get("X-Distroface-Event") != "alert.firing" {
+		t.Fatalf("headers = %v", hook.headers[0])
+	}
+
+	// A template renders the whole body
+	cfg.Template = `{"alert":"{{ .Data.rule }}","text":{{ toJSON .Text }}}`
+	ch, _ = New(cfg, Deps{Client: hook.srv.Client()})
+	if err := ch.Send(context.Background(), alertMsg); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"alert":"storage","text":"Data volume is 91% full"}`; hook.bodies[1] != want {
+		t.Fatalf("templated body = %s, want %s", hook.bodies[1], want)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	var subject, body string
+	var to []string
+	deps := Deps{
+		SMTP: mail.Transport{Addr: "smtp.example.com:25"},
+		Mail: func(_ mail.Transport, rcpt []string, s, b string) error {
+			to, subject, body = rcpt, s, b
+			return nil
+		},
+	}
+	cfg := &v1.NotificationChannel{Name: "oncall", Type: TypeEmail, To: []string{"ops@example.com"}, Events: []string{"alert.*"}}
+	ch, err := New(cfg, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Send(context.Background(), alertMsg); err != nil {
+		t.Fatal(err)
+	}
+	if subject != alertMsg.Subject || to[0] != "ops@example.com" || body != "Data volume is 91% full\n\nRule: storage\n" {
+		t.Fatalf("mail = %q %q %q", to, subject, body)
+	}
+
+	cfg.Template = "{{ toUpper .Event }}: {{ .Text }}"
+	ch, _ = New(cfg, deps)
+	if err := ch.Send(context.Background(), alertMsg); err != nil {
+		t.Fatal(err)
+	}
+	if body != "ALERT.FIRING: Data volume is 91% full" {
+		t.Fatalf("templated mail = %q", body)
+	}
+
+	if _, err := New(cfg, Deps{}); err == nil {
+		t.Fatal("email channel without an smtp server built")
+	}
+}
@@ -0,0 +1,190 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/mail"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/webhook"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	maxAttempts = 3
+	retryDelay  = 10 * time.Second
+	sendTimeout = 30 * time.Second
+)
+
+// Fans system events out to the channels in the notifications settings.
+// Channels are built from the live settings on every event, so edits
+// apply to the next one
+type Hub struct {
+	res    *settings.Resolver
+	client *http.Client
+	log    *logger.Logger
+
+	mail func(t mail.Transport, to []string, subject, body string) error
+}
+
+func NewHub(res *settings.Resolver, client *http.Client, log *logger.Logger) *Hub {
+	return &Hub{res: res, client: client, log: log, mail: mail.Send}
+}
+
+func (h *Hub) deps(cfg *v1.NotificationSettings) Deps {
+	return Deps{
+		SMTP: mail.Transport{
+			Addr:     cfg.GetSmtpAddr(),
+			Username: cfg.GetUsername(),
+			Password: cfg.GetPassword(),
+			From:     cfg.GetFrom(),
+		},
+		Client: h.client,
+		Mail:   h.mail,
+	}
+}
+
+// Sends msg in the background to every enabled channel taking its event,
+// retrying failed deliveries a few times
+func (h *Hub) Publish(ctx context.Context, msg Message) {
+	if h == nil {
+		return
+	}
+	cfg := h.res.System(ctx).GetNotifications()
+	if !cfg.GetEnabled() {
+		return
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now().UTC()
+	}
+	for _, c := range cfg.GetChannels() {
+		if c.GetDisabled() || !Matches(c, msg.Event) {
+			continue
+		}
+		ch, err := New(c, h.deps(cfg))
+		if err != nil {
+			h.log.Error("notify: channel %s: %v", c.GetName(), err)
+			continue
+		}
+		go h.deliver(c.GetName(), ch, msg)
+	}
+}
+
+func (h *Hub) deliver(name string, ch Channel, msg Message) {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := ch.Send(ctx, msg)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt == maxAttempts {
+			h.log.Error("notify: channel %s gave up on %s: %v", name, msg.Event, err)
+			return
+		}
+		h.log.Warn("notify: channel %s attempt %d/%d for %s failed: %v", name, attempt, maxAttempts, msg.Event, err)
+		time.Sleep(retryDelay * time.Duration(attempt))
+	}
+}
+
+// Outcome of one test delivery, Err nil when it went through
+type Result struct {
+	Channel string
+	Err     error
+}
+
+// Sends a test message right away, ignoring event filters and the
+// enabled toggles. Name picks one configured channel, override tries an
+// unsaved config, neither tests every configured channel
+func (h *Hub) Test(ctx context.Context, name string, override *v1.NotificationChannel) ([]Result, error) {
+	cfg := h.res.System(ctx).GetNotifications()
+	var targets []*v1.NotificationChannel
+	switch {
+	case override != nil:
+		c := proto.Clone(override).(*v1.NotificationChannel)
+		if c.Secret == nil {
+			if stored := findChannel(cfg, c.GetName()); stored != nil {
+				c.Secret = stored.Secret
+			}
+		}
+		targets = append(targets, c)
+	case name != "":
+		c := findChannel(cfg, name)
+		if c == nil {
+			return nil, fmt.Errorf("no notification channel named %q", name)
+		}
+		targets = append(targets, c)
+	default:
+		targets = cfg.GetChannels()
+	}
+
+	msg := Message{
+		Event:     EventTest,
+		Timestamp: time.Now().UTC(),
+		Subject:   "[distroface] Test notification",
+		Text:      "Notification channels on this instance can reach you.",
+	}
+	results := make([]Result, 0, len(targets))
+	for _, c := range targets {
+		res := Result{Channel: c.GetName()}
+		ch, err := New(c, h.deps(cfg))
+		if err == nil {
+			sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+			err = ch.Send(sendCtx, msg)
+			cancel()
+		}
+		res.Err = err
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func findChannel(cfg *v1.NotificationSettings, name string) *v1.NotificationChannel {
+	for _, c := range cfg.GetChannels() {
+		if c.GetName() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Dispatcher observer routing repository events to channels under the
+// webhook event names, push, pull, delete and comment
+func (h *Hub) ObserveRepository(ctx context.Context, p webhook.WebhookPayload) {
+	// Untagged pushes are index children and referrers, not news
+	if p.Event == "push" && p.Tag == "" {
+		return
+	}
+	target := p.Repository.FullName
+	if p.Tag != "" {
+		target += ":" + p.Tag
+	}
+	msg := Message{
+		Event:   p.Event,
+		Subject: fmt.Sprintf("[distroface] %s %s", target, p.Event),
+		Text:    fmt.Sprintf("%s: %s", p.Event, target),
+		Data:    p,
+	}
+	switch p.Event {
+	case "push":
+		msg.Subject = fmt.Sprintf("[distroface] %s pushed", target)
+		msg.Text = target + " was pushed"
+	case "pull":
+		msg.Subject = fmt.Sprintf("[distroface] %s pulled", target)
+		msg.Text = target + " was pulled"
+	case "delete":
+		msg.Subject = fmt.Sprintf("[distroface] %s deleted", target)
+		msg.Text = target + " was deleted"
+	case "comment":
+		msg.Subject = fmt.Sprintf("[distroface] %s commented on %s", p.Comment.Author, target)
+		msg.Text = p.Comment.Body
+	}
+	if p.Digest != "" {
+		msg.Fields = append(msg.Fields, Field{Name: "Digest", Value: p.Digest})
+	}
+	h.Publish(ctx, msg)
+}
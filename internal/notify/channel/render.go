@@ -0,0 +1,74 @@
+package channel
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/webhook"
+)
+
+// Parses the template and runs it against a sample message so mistakes
+// surface when the channel is saved. Empty is valid
+func ValidateTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	sample := Message{
+		Event:     "alert.firing",
+		Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Subject:   "[distroface] storage alert FIRING",
+		Text:      "Data volume is 91% full",
+		Fields:    []Field{{Name: "Rule", Value: "storage"}},
+		Data:      map[string]any{"rule": "storage", "value": 91.0},
+	}
+	_, err := render(tmpl, sample, nil)
+	return err
+}
+
+// Template output, or the channel's default layout when there is none
+func render(tmpl string, msg Message, fallback func(Message) string) (string, error) {
+	if tmpl == "" {
+		if fallback == nil {
+			return msg.Text, nil
+		}
+		return fallback(msg), nil
+	}
+	t, err := template.New("channel").Funcs(webhook.TemplateFuncs).Option("missingkey=zero").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid template syntax: %w", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, msg); err != nil {
+		return "", fmt.Errorf("template execution failed: %w", err)
+	}
+	return b.String(), nil
+}
+
+func plainText(msg Message) string {
+	var b strings.Builder
+	b.WriteString(msg.Text)
+	if len(msg.Fields) > 0 {
+		b.WriteString("\n\n")
+		for _, f := range msg.Fields {
+			fmt.Fprintf(&b, "%s: %s\n", f.Name, f.Value)
+		}
+	}
+	if msg.Link != "" {
+		fmt.Fprintf(&b, "\n%s\n", msg.Link)
+	}
+	return b.String()
+}
+
+func slackText(msg Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*\n%s", msg.Subject, msg.Text)
+	for _, f := range msg.Fields {
+		fmt.Fprintf(&b, "\n• *%s:* %s", f.Name, f.Value)
+	}
+	if msg.Link != "" {
+		fmt.Fprintf(&b, "\n<%s>", msg.Link)
+	}
+	return b.String()
+}
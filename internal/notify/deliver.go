@@ -0,0 +1,220 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/mail"
+	"github.com/nickheyer/distroface/internal/notify/channel"
+)
+
+const (
+	tick = time.Minute
+
+	EventNotification = "notification"
+	EventDigest       = "notification.digest"
+)
+
+// Body posted to a user's notification webhook
+type Payload struct {
+	Event         string `json:"event"`
+	Timestamp     string `json:"timestamp"`
+	User          string `json:"user"`
+	Notifications []Item `json:"notifications"`
+}
+
+type Item struct {
+	ID        string `json:"id"`
+	Reason    string `json:"reason"`
+	Event     string `json:"event"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Ref       string `json:"ref,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+	Actor     string `json:"actor,omitempty"`
+	Excerpt   string `json:"excerpt,omitempty"`
+	CommentID string `json:"comment_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Sends digests as they come due and prunes old read entries
+func (n *Notifier) Schedule(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n.enabled(ctx) {
+					n.sweep(ctx, time.Now())
+				}
+			}
+		}
+	}()
+}
+
+func (n *Notifier) sweep(ctx context.Context, now time.Time) {
+	users, err := n.store.ListUndeliveredNotificationUsers(ctx)
+	if err != nil {
+		n.log.Error("notify: listing pending deliveries: %v", err)
+		return
+	}
+	for _, id := range users {
+		prefs, err := n.Preferences(ctx, id)
+		if err != nil {
+			n.log.Error("notify: loading preferences: %v", err)
+			continue
+		}
+		// Immediate users only land here after switching off a digest
+		if prefs.Digest != "" && prefs.LastDigestAt != nil && now.Sub(*prefs.LastDigestAt) < digestInterval(prefs.Digest) {
+			continue
+		}
+		n.deliver(ctx, prefs)
+		if prefs.Digest != "" {
+			if err := n.store.SetNotificationDigestSent(ctx, id, now); err != nil {
+				n.log.Error("notify: stamping digest: %v", err)
+			}
+		}
+	}
+
+	days := n.res.System(ctx).GetNotifications().GetRetentionDays()
+	if days <= 0 {
+		return
+	}
+	if removed, err := n.store.PruneNotifications(ctx, now.AddDate(0, 0, -int(days))); err != nil {
+		n.log.Error("notify: pruning read notifications: %v", err)
+	} else if removed > 0 {
+		n.log.Info("notify: pruned %d read notifications", removed)
+	}
+}
+
+func digestInterval(digest string) time.Duration {
+	if digest == DigestDaily {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// Mails and posts everything the user has pending as one message. Entries
+// count as delivered even when a channel fails, the inbox still has them
+func (n *Notifier) deliver(ctx context.Context, prefs *db.NotificationPreference) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	pending, err := n.store.ListUndeliveredNotifications(ctx, prefs.UserID)
+	if err != nil {
+		n.log.Error("notify: listing pending deliveries: %v", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+	ids := make([]string, len(pending))
+	for i, e := range pending {
+		ids[i] = e.ID
+	}
+	if err := n.store.MarkNotificationsDelivered(ctx, ids); err != nil {
+		n.log.Error("notify: marking deliveries: %v", err)
+		return
+	}
+
+	user, err := n.store.GetUserByID(ctx, prefs.UserID)
+	if err != nil || user == nil || !user.IsActive {
+		return
+	}
+
+	if prefs.WebhookURL != "" && n.post != nil {
+		p := Payload{
+			Event:     EventNotification,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			User:      user.Username,
+		}
+		if prefs.Digest != "" {
+			p.Event = EventDigest
+		}
+		for _, e := range pending {
+			p.Notifications = append(p.Notifications, item(e))
+		}
+		n.post(prefs.WebhookURL, prefs.WebhookSecret, p.Event, p)
+	}
+
+	cfg := n.res.System(ctx).GetNotifications()
+	if !prefs.Email || cfg.GetSmtpAddr() == "" || user.Email == nil || *user.Email == "" {
+		return
+	}
+	email := &channel.Email{
+		Transport: mail.Transport{
+			Addr:     cfg.GetSmtpAddr(),
+			Username: cfg.GetUsername(),
+			Password: cfg.GetPassword(),
+			From:     cfg.GetFrom(),
+		},
+		To:     []string{*user.Email},
+		Mailer: n.mail,
+	}
+	subject, body := message(pending)
+	msg := channel.Message{Event: EventNotification, Timestamp: time.Now().UTC(), Subject: subject, Text: body}
+	if prefs.Digest != "" {
+		msg.Event = EventDigest
+	}
+	go func() {
+		if err := email.Send(context.Background(), msg); err != nil {
+			n.log.Error("notify: mail to %s failed: %v", user.Username, err)
+		}
+	}()
+}
+
+func item(e *db.Notification) Item {
+	return Item{
+		ID:        e.ID,
+		Reason:    e.Reason,
+		Event:     e.Event,
+		Namespace: e.Namespace,
+		Name:      e.Name,
+		Ref:       e.Ref,
+		Digest:    e.Digest,
+		Actor:     e.Actor,
+		Excerpt:   e.Excerpt,
+		CommentID: e.CommentID,
+		CreatedAt: e.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// One line per entry, the subject names the entry or counts them
+func message(entries []*db.Notification) (string, string) {
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s  %s\r\n", e.CreatedAt.UTC().Format(time.RFC3339), summary(e))
+		if e.Excerpt != "" {
+			fmt.Fprintf(&b, "    %s\r\n", e.Excerpt)
+		}
+	}
+	if len(entries) == 1 {
+		return "[distroface] " + summary(entries[0]), b.String()
+	}
+	return fmt.Sprintf("[distroface] %d notifications", len(entries)), b.String()
+}
+
+func summary(e *db.Notification) string {
+	target := e.Namespace + "/" + e.Name
+	if e.Ref != "" {
+		target += ":" + e.Ref
+	} else if e.Digest != "" {
+		target += "@" + e.Digest
+	}
+	switch {
+	case e.Reason == ReasonMention:
+		return fmt.Sprintf("%s mentioned you on %s", e.Actor, target)
+	case e.Event == EventComment:
+		return fmt.Sprintf("%s commented on %s", e.Actor, target)
+	case e.Event == EventDelete:
+		return target + " was deleted"
+	default:
+		return target + " was pushed"
+	}
+}
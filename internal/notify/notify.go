@@ -0,0 +1,292 @@
+// Repository watches and @mentions feeding a per user inbox, mailed or
+// posted to the user's own webhook immediately or as a digest
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/mail"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/webhook"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+const (
+	ReasonWatch   = "watch"
+	ReasonMention = "mention"
+
+	EventTag     = "tag"
+	EventDelete  = "delete"
+	EventComment = "comment"
+
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+)
+
+const (
+	excerptLen  = 200
+	maxMentions = 20
+)
+
+// @name not preceded by a word character, so mail addresses are skipped
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([a-zA-Z0-9_][a-zA-Z0-9._-]{0,63})`)
+
+func ValidEvent(e string) bool {
+	return e == EventTag || e == EventDelete || e == EventComment
+}
+
+func ValidDigest(d string) bool {
+	return d == "" || d == DigestHourly || d == DigestDaily
+}
+
+// Preferences of a user who never saved any
+func DefaultPreference(userID string) *db.NotificationPreference {
+	return &db.NotificationPreference{UserID: userID, Email: true, Mentions: true}
+}
+
+// Event is one thing that happened on a repository
+type Event struct {
+	Kind      string // tag, delete or comment
+	Namespace string
+	Name      string
+	Ref       string
+	Digest    string
+	Actor     string // Comment author, empty for registry events
+	ActorID   string
+	CommentID string
+	Body      string
+}
+
+// Reports whether the user may read the repository the event is on
+type ReadCheck func(user *auth.AuthenticatedUser) bool
+
+type Notifier struct {
+	store    *stores.Store
+	res      *settings.Resolver
+	enforcer *rbac.Enforcer
+	log      *logger.Logger
+	mail     func(t mail.Transport, to []string, subject, body string) error
+	post     func(url, secret, event string, payload any)
+	mu       sync.Mutex // One delivery at a time so nothing goes out twice
+}
+
+// Post is nil when webhooks are unavailable, mail still goes out
+func NewNotifier(store *stores.Store, res *settings.Resolver, enforcer *rbac.Enforcer, post func(url, secret, event string, payload any), log *logger.Logger) *Notifier {
+	return &Notifier{store: store, res: res, enforcer: enforcer, log: log, mail: mail.Send, post: post}
+}
+
+func (n *Notifier) enabled(ctx context.Context) bool {
+	return n != nil && n.res.System(ctx).GetNotifications().GetEnabled()
+}
+
+// Dispatcher observer turning image repository events into notifications
+func (n *Notifier) Observe(ctx context.Context, p webhook.WebhookPayload) {
+	ev := Event{Namespace: p.Repository.Namespace, Name: p.Repository.Name, Ref: p.Tag, Digest: p.Digest}
+	switch p.Event {
+	case "push":
+		// Untagged pushes are index children and referrers, not news
+		if p.Tag == "" {
+			return
+		}
+		ev.Kind = EventTag
+	case "delete":
+		ev.Kind = EventDelete
+	case "comment":
+		ev.Kind = EventComment
+		ev.Actor, ev.ActorID = p.Comment.Author, p.Comment.AuthorID
+		ev.CommentID, ev.Body = p.Comment.ID, p.Comment.Body
+	default:
+		return
+	}
+	if !n.enabled(ctx) {
+		return
+	}
+	go n.RepositoryEvent(context.WithoutCancel(ctx), ev)
+}
+
+// Notifies the repository's watchers and anyone mentioned in a comment
+func (n *Notifier) RepositoryEvent(ctx context.Context, ev Event) {
+	if !n.enabled(ctx) {
+		return
+	}
+	repo, err := n.store.GetRepository(ctx, ev.Namespace, ev.Name)
+	if err != nil {
+		n.log.Error("notify: looking up %s/%s: %v", ev.Namespace, ev.Name, err)
+		return
+	}
+	if repo == nil {
+		return
+	}
+	canRead := func(u *auth.AuthenticatedUser) bool {
+		if !repo.IsPrivate {
+			return true
+		}
+		ok, _ := n.enforcer.Enforce(u.Roles, rbac.ResourceRepositories, rbac.ActionRead, repo.Namespace+"/"+repo.Name)
+		return ok
+	}
+
+	out, mentioned := n.mentions(ctx, ev, canRead)
+	watches, err := n.store.ListRepoWatchers(ctx, repo.ID)
+	if err != nil {
+		n.log.Error("notify: listing watchers of %s/%s: %v", ev.Namespace, ev.Name, err)
+	}
+	for _, w := range watches {
+		if w.User == nil || w.UserID == ev.ActorID || mentioned[w.UserID] || !watchesEvent(w, ev.Kind) {
+			continue
+		}
+		if !n.allowed(ctx, w.User, canRead) {
+			continue
+		}
+		out = append(out, entry(w.UserID, ReasonWatch, ev))
+	}
+	n.record(ctx, out)
+}
+
+// Mentions in a comment on a target without watches, artifact versions
+func (n *Notifier) Mentions(ctx context.Context, ev Event, canRead ReadCheck) {
+	if !n.enabled(ctx) {
+		return
+	}
+	out, _ := n.mentions(ctx, ev, canRead)
+	n.record(ctx, out)
+}
+
+// Mention entries for users who want them and can read the target
+func (n *Notifier) mentions(ctx context.Context, ev Event, canRead ReadCheck) ([]*db.Notification, map[string]bool) {
+	mentioned := make(map[string]bool)
+	names := ParseMentions(ev.Body)
+	if ev.Kind != EventComment || len(names) == 0 {
+		return nil, mentioned
+	}
+	users, err := n.store.ListUsersByUsernames(ctx, names)
+	if err != nil {
+		n.log.Error("notify: resolving mentions: %v", err)
+		return nil, mentioned
+	}
+	var out []*db.Notification
+	for _, u := range users {
+		if u.ID == ev.ActorID || !n.allowed(ctx, u, canRead) {
+			continue
+		}
+		prefs, err := n.Preferences(ctx, u.ID)
+		if err != nil {
+			n.log.Error("notify: loading preferences of %s: %v", u.Username, err)
+			continue
+		}
+		if !prefs.Mentions {
+			continue
+		}
+		mentioned[u.ID] = true
+		out = append(out, entry(u.ID, ReasonMention, ev))
+	}
+	return out, mentioned
+}
+
+// Active accounts that can still read the repository
+func (n *Notifier) allowed(ctx context.Context, u *db.User, canRead ReadCheck) bool {
+	if !u.IsActive {
+		return false
+	}
+	roles, err := n.store.GetUserRoleNames(ctx, u.ID)
+	if err != nil {
+		n.log.Error("notify: loading roles of %s: %v", u.Username, err)
+		return false
+	}
+	return canRead(&auth.AuthenticatedUser{ID: u.ID, Username: u.Username, Roles: roles, Provider: u.AuthProvider})
+}
+
+// Unique names mentioned in body, in order of first mention
+func ParseMentions(body string) []string {
+	var names []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		name := strings.TrimRight(m[1], ".")
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
+
+func watchesEvent(w *db.Watch, kind string) bool {
+	var events []string
+	if err := json.Unmarshal([]byte(w.Events), &events); err != nil || len(events) == 0 {
+		return true
+	}
+	return slices.Contains(events, kind)
+}
+
+func entry(userID, reason string, ev Event) *db.Notification {
+	return &db.Notification{
+		UserID:    userID,
+		Reason:    reason,
+		Event:     ev.Kind,
+		Namespace: ev.Namespace,
+		Name:      ev.Name,
+		Ref:       ev.Ref,
+		Digest:    ev.Digest,
+		Actor:     ev.Actor,
+		Excerpt:   excerpt(ev.Body),
+		CommentID: ev.CommentID,
+	}
+}
+
+func excerpt(body string) string {
+	body = strings.Join(strings.Fields(body), " ")
+	if utf8.RuneCountInString(body) <= excerptLen {
+		return body
+	}
+	return string([]rune(body)[:excerptLen-1]) + "…"
+}
+
+// Stores the entries and sends those whose owners want them right away
+func (n *Notifier) record(ctx context.Context, out []*db.Notification) {
+	if len(out) == 0 {
+		return
+	}
+	if err := n.store.CreateNotifications(ctx, out); err != nil {
+		n.log.Error("notify: recording %d notifications: %v", len(out), err)
+		return
+	}
+	seen := make(map[string]bool)
+	for _, e := range out {
+		if seen[e.UserID] {
+			continue
+		}
+		seen[e.UserID] = true
+		prefs, err := n.Preferences(ctx, e.UserID)
+		if err != nil {
+			n.log.Error("notify: loading preferences: %v", err)
+			continue
+		}
+		if prefs.Digest == "" {
+			n.deliver(ctx, prefs)
+		}
+	}
+}
+
+func (n *Notifier) Preferences(ctx context.Context, userID string) (*db.NotificationPreference, error) {
+	return Preferences(ctx, n.store, userID)
+}
+
+// Saved preferences, or the defaults for users who never saved any
+func Preferences(ctx context.Context, store *stores.Store, userID string) (*db.NotificationPreference, error) {
+	p, err := store.GetNotificationPreference(ctx, userID)
+	if err != nil || p != nil {
+		return p, err
+	}
+	return DefaultPreference(userID), nil
+}
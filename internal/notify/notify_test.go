@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/mail"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+type sent struct {
+	mu    sync.Mutex
+	mails []string
+	posts []Payload
+}
+
+func (s *sent) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.mails), len(s.posts)
+}
+
+func newTestNotifier(t *testing.T) (*Notifier, *stores.Store, *sent) {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	res := settings.NewResolver(store, nil)
+	cfg := &v1.NotificationSettings{SmtpAddr: proto.String("127.0.0.1:25")}
+	if err := res.SeedSystem(context.Background(), &v1.Settings{Notifications: cfg}); err != nil {
+		t.Fatalf("SeedSystem: %v", err)
+	}
+	enforcer, err := rbac.NewEnforcer(store.DB())
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+
+	out := &sent{}
+	n := NewNotifier(store, res, enforcer, func(url, secret, event string, payload any) {
+		out.mu.Lock()
+		defer out.mu.Unlock()
+		out.posts = append(out.posts, payload.(Payload))
+	}, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	n.mail = func(t mail.Transport, to []string, subject, body string) error {
+		out.mu.Lock()
+		defer out.mu.Unlock()
+		out.mails = append(out.mails, subject)
+		return nil
+	}
+	return n, store, out
+}
+
+func newUser(t *testing.T, store *stores.Store, name string) *db.User {
+	t.Helper()
+	email := name + "@example.com"
+	u := &db.User{Username: name, Email: &email}
+	if err := store.CreateUser(context.Background(), u); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return u
+}
+
+func newRepo(t *testing.T, store *stores.Store, name string, private bool) *db.Repository {
+	t.Helper()
+	r := &db.Repository{ID: name, Namespace: "team", Name: name, IsPrivate: private}
+	if err := store.CreateRepository(context.Background(), r); err != nil {
+		t.Fatalf("CreateRepository: %v", err)
+	}
+	return r
+}
+
+func inbox(t *testing.T, store *stores.Store, u *db.User) []*db.Notification {
+	t.Helper()
+	ns, _, _, err := store.ListNotifications(context.Background(), u.ID, false, 100, 0)
+	if err != nil {
+		t.Fatalf("ListNotifications: %v", err)
+	}
+	return ns
+}
+
+func TestParseMentions(t *testing.T) {
+	got := ParseMentions("@alice see this, cc @bob. mail carol@example.com or @alice again\n@dave_2")
+	want := []string{"alice", "bob", "dave_2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMentions = %v, want %v", got, want)
+	}
+	if got := ParseMentions("no mentions here"); got != nil {
+		t.Errorf("ParseMentions without mentions = %v", got)
+	}
+}
+
+func TestRepositoryEvent(t *testing.T) {
+	ctx := context.Background()
+	n, store, out := newTestNotifier(t)
+	author := newUser(t, store, "author")
+	watcher := newUser(t, store, "watcher")
+	tagsOnly := newUser(t, store, "tagsonly")
+	mentioned := newUser(t, store, "mentioned")
+	public := newRepo(t, store, "app", false)
+	private := newRepo(t, store, "secret", true)
+
+	for _, w := range []*db.Watch{
+		{UserID: watcher.ID, RepoID: public.ID, Events: `[]`},
+		{UserID: author.ID, RepoID: public.ID, Events: `[]`},
+		{UserID: tagsOnly.ID, RepoID: public.ID, Events: `["tag"]`},
+		{UserID: watcher.ID, RepoID: private.ID, Events: `[]`},
+	} {
+		if err := store.UpsertWatch(ctx, w); err != nil {
+			t.Fatalf("UpsertWatch: %v", err)
+		}
+	}
+
+	n.RepositoryEvent(ctx, Event{
+		Kind: EventComment, Namespace: "team", Name: "app", Ref: "v1",
+		Actor: author.Username, ActorID: author.ID, CommentID: "c1",
+		Body: "@mentioned @watcher please check, @author @nobody",
+	})
+	// The author never hears about their own comment
+	if got := inbox(t, store, author); len(got) != 0 {
+		t.Errorf("author inbox = %d entries, want 0", len(got))
+	}
+	// A mention replaces the watch notification
+	if got := inbox(t, store, watcher); len(got) != 1 || got[0].Reason != ReasonMention {
+		t.Errorf("watcher inbox = %+v, want one mention", got)
+	}
+	if got := inbox(t, store, mentioned); len(got) != 1 || got[0].Reason != ReasonMention || got[0].Excerpt == "" {
+		t.Errorf("mentioned inbox = %+v, want one mention", got)
+	}
+	if got := inbox(t, store, tagsOnly); len(got) != 0 {
+		t.Errorf("tag only watcher got a comment: %+v", got)
+	}
+
+	n.RepositoryEvent(ctx, Event{Kind: EventTag, Namespace: "team", Name: "app", Ref: "v2"})
+	if got := inbox(t, store, tagsOnly); len(got) != 1 || got[0].Reason != ReasonWatch || got[0].Ref != "v2" {
+		t.Errorf("tag only watcher inbox = %+v, want the v2 push", got)
+	}
+
+	// Without a read grant the private repo stays silent
+	n.RepositoryEvent(ctx, Event{Kind: EventTag, Namespace: "team", Name: "secret", Ref: "v1"})
+	if got := inbox(t, store, watcher); len(got) != 2 || got[0].Name != "app" {
+		t.Errorf("watcher saw a private repo event: %+v", got)
+	}
+
+	// Immediate delivery mails each entry as it lands, the author's
+	// watch still covers pushes
+	waitFor(t, func() bool {
+		mails, _ := out.counts()
+		return mails == 5
+	})
+}
+
+func TestDigest(t *testing.T) {
+	ctx := context.Background()
+	n, store, out := newTestNotifier(t)
+	u := newUser(t, store, "digester")
+	repo := newRepo(t, store, "app", false)
+	if err := store.UpsertWatch(ctx, &db.Watch{UserID: u.ID, RepoID: repo.ID, Events: `[]`}); err != nil {
+		t.Fatalf("UpsertWatch: %v", err)
+	}
+	last := time.Now()
+	prefs := &db.NotificationPreference{UserID: u.ID, Email: true, WebhookURL: "https://hooks.example.com/me", Digest: DigestHourly, LastDigestAt: &last}
+	if err := store.SaveNotificationPreference(ctx, prefs); err != nil {
+		t.Fatalf("SaveNotificationPreference: %v", err)
+	}
+
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		n.RepositoryEvent(ctx, Event{Kind: EventTag, Namespace: "team", Name: "app", Ref: tag})
+	}
+	n.sweep(ctx, last.Add(30*time.Minute))
+	if mails, posts := out.counts(); mails != 0 || posts != 0 {
+		t.Fatalf("digest went out early: %d mails, %d posts", mails, posts)
+	}
+
+	n.sweep(ctx, last.Add(61*time.Minute))
+	waitFor(t, func() bool {
+		mails, _ := out.counts()
+		return mails == 1
+	})
+	out.mu.Lock()
+	if len(out.posts) != 1 || out.posts[0].Event != EventDigest || len(out.posts[0].Notifications) != 3 {
+		t.Errorf("digest posts = %+v, want one with 3 entries", out.posts)
+	}
+	if !strings.Contains(out.mails[0], "3 notifications") {
+		t.Errorf("digest subject = %q", out.mails[0])
+	}
+	out.mu.Unlock()
+
+	// Everything went out, the next window has nothing to send
+	n.sweep(ctx, last.Add(3*time.Hour))
+	if _, posts := out.counts(); posts != 1 {
+		t.Errorf("empty digest was posted, %d posts", posts)
+	}
+
+	// Only read entries past retention are pruned
+	if _, err := store.MarkNotificationsRead(ctx, u.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	n.sweep(ctx, time.Now().AddDate(0, 0, 31))
+	if got := inbox(t, store, u); len(got) != 0 {
+		t.Errorf("read entries survived retention: %d", len(got))
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for delivery")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
+)
+
+const defaultApprovalTTL = 24 * time.Hour
+
+var (
+	// Parked deletes the caller's roles may not complete
+	ErrSecondAdmin = errors.New("deletion awaits a second admin")
+	// Parked deletes another admin completed first
+	ErrApprovalClaimed = errors.New("deletion was already approved")
+)
+
+// Two admin rule for deleting anything above the configured size. Every
+// destructive path, rpc or registry, goes through Check
+type Approvals struct {
+	store    *stores.Store
+	res      *settings.Resolver
+	enforcer *rbac.Enforcer
+}
+
+func NewApprovals(store *stores.Store, res *settings.Resolver, enforcer *rbac.Enforcer) *Approvals {
+	return &Approvals{store: store, res: res, enforcer: enforcer}
+}
+
+// Nil expiry lets the delete proceed. Otherwise the request is parked and
+// the same call from a different admin of the resource completes it. Size
+// is only measured when the rule is enabled
+func (a *Approvals) Check(ctx context.Context, user *auth.AuthenticatedUser, resource, target, reason string, size func() (int64, error)) (*time.Time, error) {
+	cfg := a.res.System(ctx).GetSecurity().GetDeletion()
+	threshold := cfg.GetDualApprovalBytes()
+	if threshold <= 0 {
+		return nil, nil
+	}
+	bytes, err := size()
+	if err != nil {
+		return nil, fmt.Errorf("measuring %s: %w", target, err)
+	}
+	if bytes < threshold {
+		return nil, nil
+	}
+
+	key := resource + ":" + target
+	now := time.Now().UTC()
+	pending, err := a.store.GetDeletionApproval(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil && now.Before(pending.ExpiresAt) {
+		if pending.RequestedBy == user.ID {
+			return &pending.ExpiresAt, nil
+		}
+		if !a.enforcer.HasPermission(user.Roles, resource, rbac.ActionManage) {
+			return nil, fmt.Errorf("%w: deleting %s needs another %s admin", ErrSecondAdmin, target, resource)
+		}
+		claimed, err := a.store.ClaimDeletionApproval(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			return nil, fmt.Errorf("%w: %s", ErrApprovalClaimed, target)
+		}
+		return nil, nil
+	}
+
+	ttl := time.Duration(cfg.GetApprovalTtlMinutes()) * time.Minute
+	if ttl <= 0 {
+		ttl = defaultApprovalTTL
+	}
+	pending = &db.DeletionApproval{
+		Resource:    key,
+		RequestedBy: user.ID,
+		Requester:   user.Username,
+		Reason:      reason,
+		SizeBytes:   bytes,
+		ExpiresAt:   now.Add(ttl),
+	}
+	if err := a.store.SaveDeletionApproval(ctx, pending); err != nil {
+		return nil, err
+	}
+	return &pending.ExpiresAt, nil
+}
+
+// Check for registry deletes, which carry only the token subject. Roles
+// come from the store, never from the token
+func (a *Approvals) CheckImageDelete(ctx context.Context, username, target string, size func() (int64, error)) (*time.Time, error) {
+	user, err := a.store.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("%w: unknown user %q", ErrSecondAdmin, username)
+	}
+	roles, err := a.store.GetUserRoleNames(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	caller := &auth.AuthenticatedUser{ID: user.ID, Username: user.Username, Roles: roles}
+	return a.Check(ctx, caller, rbac.ResourceRepositories, target, "", size)
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestApprovals(t *testing.T, threshold int64) (*Approvals, map[string]*auth.AuthenticatedUser) {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	enforcer, err := rbac.NewEnforcer(store.DB())
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+	if err := enforcer.SeedDefaultPolicies(false); err != nil {
+		t.Fatalf("SeedDefaultPolicies: %v", err)
+	}
+	ctx := context.Background()
+	users := map[string]*auth.AuthenticatedUser{}
+	for _, u := range []struct{ name, role string }{{"alice", "admin"}, {"bob", "admin"}, {"carol", "user"}} {
+		user := &db.User{Username: u.name}
+		if err := store.CreateUser(ctx, user); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := store.AssignRole(ctx, user.ID, u.role, "test"); err != nil {
+			t.Fatalf("AssignRole: %v", err)
+		}
+		users[u.name] = &auth.AuthenticatedUser{ID: user.ID, Username: u.name, Roles: []string{u.role}}
+	}
+	res := settings.NewResolver(store, &v1.Settings{Security: &v1.SecuritySettings{
+		Deletion: &v1.DeletionSettings{DualApprovalBytes: proto.Int64(threshold)},
+	}})
+	return NewApprovals(store, res, enforcer), users
+}
+
+func fixedSize(n int64) func() (int64, error) {
+	return func() (int64, error) { return n, nil }
+}
+
+// Large deletes park until a different admin repeats them, once
+func TestApprovalsSecondAdmin(t *testing.T) {
+	a, users := newTestApprovals(t, 100)
+	ctx := context.Background()
+	check := func(who string) error {
+		_, err := a.Check(ctx, users[who], rbac.ResourceRepositories, "acme/app", "", fixedSize(500))
+		return err
+	}
+
+	first, err := a.Check(ctx, users["alice"], rbac.ResourceRepositories, "acme/app", "cleanup", fixedSize(500))
+	if err != nil || first == nil {
+		t.Fatalf("first request = %v, %v, want parked", first, err)
+	}
+	again, err := a.Check(ctx, users["alice"], rbac.ResourceRepositories, "acme/app", "", fixedSize(500))
+	if err != nil || again == nil || !again.Equal(*first) {
+		t.Fatalf("requester repeating = %v, %v, want the same parked request", again, err)
+	}
+	if err := check("carol"); !errors.Is(err, ErrSecondAdmin) {
+		t.Fatalf("non admin approving = %v, want ErrSecondAdmin", err)
+	}
+	if expires, err := a.Check(ctx, users["bob"], rbac.ResourceRepositories, "acme/app", "", fixedSize(500)); err != nil || expires != nil {
+		t.Fatalf("second admin = %v, %v, want the delete to proceed", expires, err)
+	}
+
+	// The approval is spent, the next delete starts over
+	if expires, err := a.Check(ctx, users["bob"], rbac.ResourceRepositories, "acme/app", "", fixedSize(500)); err != nil || expires == nil {
+		t.Fatalf("after approval = %v, %v, want a new parked request", expires, err)
+	}
+
+	// Targets are keyed per resource
+	if expires, err := a.Check(ctx, users["alice"], rbac.ResourceOrganizations, "acme/app", "", fixedSize(500)); err != nil || expires == nil {
+		t.Fatalf("same name on another resource = %v, %v, want its own request", expires, err)
+	}
+}
+
+// Small deletes and a disabled rule never measure or park
+func TestApprovalsBelowThreshold(t *testing.T) {
+	ctx := context.Background()
+	a, users := newTestApprovals(t, 100)
+	if expires, err := a.Check(ctx, users["carol"], rbac.ResourceRepositories, "carol/app:1.0", "", fixedSize(99)); err != nil || expires != nil {
+		t.Fatalf("small delete = %v, %v", expires, err)
+	}
+	if _, err := a.Check(ctx, users["carol"], rbac.ResourceRepositories, "carol/app", "", func() (int64, error) {
+		return 0, errors.New("disk gone")
+	}); err == nil {
+		t.Fatal("measuring failure was swallowed")
+	}
+
+	off, users := newTestApprovals(t, 0)
+	measured := false
+	if expires, err := off.Check(ctx, users["carol"], rbac.ResourceRepositories, "carol/app", "", func() (int64, error) {
+		measured = true
+		return 1 << 40, nil
+	}); err != nil || expires != nil || measured {
+		t.Fatalf("disabled rule = %v, %v, measured %v", expires, err, measured)
+	}
+}
+
+// Registry deletes take roles from the store, never from the token
+func TestApprovalsImageDelete(t *testing.T) {
+	a, users := newTestApprovals(t, 100)
+	ctx := context.Background()
+
+	if expires, err := a.CheckImageDelete(ctx, "alice", "acme/app:1.0", fixedSize(500)); err != nil || expires == nil {
+		t.Fatalf("registry delete = %v, %v, want parked", expires, err)
+	}
+	// Carol's token may claim admin, her stored role decides
+	admin := auth.WithUser(ctx, &auth.AuthenticatedUser{ID: users["carol"].ID, Username: "carol", Roles: []string{"admin"}})
+	if _, err := a.CheckImageDelete(admin, "carol", "acme/app:1.0", fixedSize(500)); !errors.Is(err, ErrSecondAdmin) {
+		t.Fatalf("non admin approving = %v, want ErrSecondAdmin", err)
+	}
+	if _, err := a.CheckImageDelete(ctx, "mallory", "acme/app:1.0", fixedSize(500)); !errors.Is(err, ErrSecondAdmin) {
+		t.Fatalf("unknown user = %v, want ErrSecondAdmin", err)
+	}
+
+	// The rpc and registry paths share one request per tag
+	if expires, err := a.Check(ctx, users["bob"], rbac.ResourceRepositories, "acme/app:1.0", "", fixedSize(500)); err != nil || expires != nil {
+		t.Fatalf("rpc approval of a registry request = %v, %v", expires, err)
+	}
+}
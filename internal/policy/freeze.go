@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+// Pushes refused by a running freeze window
+var ErrFrozen = errors.New("repository is frozen")
+
+// Whether a freeze pattern covers namespace/name. Patterns are path globs,
+// a bare namespace covers every repo in it
+func FreezeCovers(pattern, repo string) bool {
+	if ok, _ := path.Match(pattern, repo); ok {
+		return true
+	}
+	ns, _ := path.Split(repo)
+	return ns != "" && pattern == ns[:len(ns)-1]
+}
+
+// Rejects malformed freeze patterns
+func ValidFreezePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// Refuses image pushes to repos under a running freeze window, holders
+// of the freeze override permission push through
+type Freezes struct {
+	store    *stores.Store
+	enforcer *rbac.Enforcer
+	log      *logger.Logger
+}
+
+func NewFreezes(store *stores.Store, enforcer *rbac.Enforcer, log *logger.Logger) *Freezes {
+	return &Freezes{store: store, enforcer: enforcer, log: log}
+}
+
+// Nil when no running window covers the repo or user may override it,
+// ErrFrozen naming the window otherwise. Mirror syncs carry no user and
+// are held like everyone else
+func (f *Freezes) Check(ctx context.Context, user, namespace, name string) error {
+	if f == nil {
+		return nil
+	}
+	repo := namespace + "/" + name
+	windows, err := f.store.ActiveFreezeWindows(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	var hit *db.FreezeWindow
+	for _, w := range windows {
+		if FreezeCovers(w.Pattern, repo) {
+			hit = w
+			break
+		}
+	}
+	if hit == nil {
+		return nil
+	}
+
+	if user != "" && f.canOverride(ctx, user, repo) {
+		f.log.Info("policy: %s pushed to %s through freeze %s", user, repo, hit.ID)
+		return nil
+	}
+	f.log.Info("policy: refused push to %s by %s, frozen by %s", repo, user, hit.ID)
+	return fmt.Errorf("%w: %s", ErrFrozen, describeFreeze(hit))
+}
+
+func (f *Freezes) canOverride(ctx context.Context, username, repo string) bool {
+	if f.enforcer == nil {
+		return false
+	}
+	roles, ok := userRoles(ctx, f.store, username)
+	if !ok {
+		return false
+	}
+	allowed, _ := f.enforcer.Enforce(roles, rbac.ResourceFreezes, rbac.ActionOverride, repo)
+	return allowed
+}
+
+// What docker push prints, the pattern, until when and why
+func describeFreeze(w *db.FreezeWindow) string {
+	msg := "pushes to " + w.Pattern + " are frozen"
+	if w.EndsAt != nil {
+		msg += " until " + w.EndsAt.UTC().Format(time.RFC3339)
+	} else {
+		msg += " until the freeze is lifted"
+	}
+	if w.Reason != "" {
+		msg += " (" + w.Reason + ")"
+	}
+	return msg
+}
@@ -0,0 +1,126 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+func TestFreezeCovers(t *testing.T) {
+	cases := []struct {
+		pattern, repo string
+		want          bool
+	}{
+		{"prod/*", "prod/api", true},
+		{"prod", "prod/api", true},
+		{"prod/api", "prod/api", true},
+		{"prod/*", "production/api", false},
+		{"prod", "production/api", false},
+		{"*/api", "staging/api", true},
+		{"*/api", "staging/web", false},
+	}
+	for _, c := range cases {
+		if got := FreezeCovers(c.pattern, c.repo); got != c.want {
+			t.Errorf("FreezeCovers(%q, %q) = %v, want %v", c.pattern, c.repo, got, c.want)
+		}
+	}
+}
+
+func newTestFreezes(t *testing.T) (*Freezes, *stores.Store) {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	enforcer, err := rbac.NewEnforcer(store.DB())
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+	if err := enforcer.SeedDefaultPolicies(false); err != nil {
+		t.Fatalf("SeedDefaultPolicies: %v", err)
+	}
+	ctx := context.Background()
+	for _, u := range []struct{ name, role string }{{"alice", "user"}, {"root", "admin"}} {
+		user := &db.User{Username: u.name}
+		if err := store.CreateUser(ctx, user); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := store.AssignRole(ctx, user.ID, u.role, "test"); err != nil {
+			t.Fatalf("AssignRole: %v", err)
+		}
+	}
+	return NewFreezes(store, enforcer, logger.NewWithConfig(&logger.Config{Enabled: false})), store
+}
+
+// Running windows refuse pushes with a readable reason, admins push through
+func TestFreezeCheck(t *testing.T) {
+	f, store := newTestFreezes(t)
+	ctx := context.Background()
+	now := time.Now()
+	ended := now.Add(-time.Hour)
+	until := now.Add(time.Hour)
+
+	windows := []*db.FreezeWindow{
+		{Pattern: "prod/*", Reason: "release 4.2", StartsAt: now.Add(-time.Minute), EndsAt: &until},
+		{Pattern: "staging", StartsAt: now.Add(-2 * time.Hour), EndsAt: &ended},
+		{Pattern: "qa", StartsAt: now.Add(time.Hour)},
+	}
+	for _, w := range windows {
+		if err := store.CreateFreezeWindow(ctx, w); err != nil {
+			t.Fatalf("CreateFreezeWindow: %v", err)
+		}
+	}
+
+	err := f.Check(ctx, "alice", "prod", "api")
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("push during freeze = %v, want ErrFrozen", err)
+	}
+	if !strings.Contains(err.Error(), "release 4.2") || !strings.Contains(err.Error(), until.UTC().Format(time.RFC3339)) {
+		t.Errorf("freeze error does not explain itself: %v", err)
+	}
+	if err := f.Check(ctx, "", "prod", "api"); !errors.Is(err, ErrFrozen) {
+		t.Errorf("mirror sync during freeze = %v, want ErrFrozen", err)
+	}
+	if err := f.Check(ctx, "root", "prod", "api"); err != nil {
+		t.Errorf("admin override refused: %v", err)
+	}
+	for _, repo := range [][2]string{{"staging", "api"}, {"qa", "api"}, {"alice", "app"}} {
+		if err := f.Check(ctx, "alice", repo[0], repo[1]); err != nil {
+			t.Errorf("push to %s/%s outside any window: %v", repo[0], repo[1], err)
+		}
+	}
+}
+
+// Roles of the caller in ctx are used for its own user, not for someone
+// else's push
+func TestFreezeOverrideFromContext(t *testing.T) {
+	f, store := newTestFreezes(t)
+	ctx := context.Background()
+	if err := store.CreateFreezeWindow(ctx, &db.FreezeWindow{Pattern: "prod/*", StartsAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("CreateFreezeWindow: %v", err)
+	}
+
+	// Not in the store, only the caller in ctx has the admin role
+	ops := auth.WithUser(ctx, &auth.AuthenticatedUser{Username: "ops", Roles: []string{"admin"}})
+	if err := f.Check(ops, "ops", "prod", "api"); err != nil {
+		t.Errorf("admin from token refused: %v", err)
+	}
+	if err := f.Check(ops, "alice", "prod", "api"); !errors.Is(err, ErrFrozen) {
+		t.Errorf("another user's push took the caller's roles: %v", err)
+	}
+	// Callers without roles fall back to the store
+	root := auth.WithUser(ctx, &auth.AuthenticatedUser{Username: "root"})
+	if err := f.Check(root, "root", "prod", "api"); err != nil {
+		t.Errorf("admin from store refused: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/webhook"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+// Pushes refused by the hook, the wrapped message is the hook's reason
+var ErrDenied = errors.New("push denied by policy")
+
+const (
+	EventManifestPush = "manifest.push"
+	EventArtifactPush = "artifact.push"
+
+	maxResponseBody = 64 * 1024
+)
+
+// Push metadata posted to the hook
+type Push struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	User      string `json:"user"` // Empty for mirror syncs
+	Namespace string `json:"namespace"`
+	Repo      string `json:"repository"`
+	Digest    string `json:"digest"`
+	MediaType string `json:"media_type,omitempty"`
+	Size      int64  `json:"size"` // Whole tree for indexes, shared layers once
+
+	// Images
+	Tag    string            `json:"tag,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Artifacts
+	Version    string            `json:"version,omitempty"`
+	Path       string            `json:"path,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// Hook verdict, an opa result may also be a bare boolean
+type decision struct {
+	Allow   *bool  `json:"allow"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+// Asks the configured pre-receive hook whether a push may land
+type Hook struct {
+	res    *settings.Resolver
+	log    *logger.Logger
+	client *http.Client
+}
+
+func NewHook(res *settings.Resolver, log *logger.Logger) *Hook {
+	return &Hook{res: res, log: log, client: &http.Client{}}
+}
+
+// Nil when the push may proceed or no hook is enabled, ErrDenied with
+// the hook's message otherwise. Unreachable hooks deny unless fail_open
+func (h *Hook) Check(ctx context.Context, p Push) error {
+	if h == nil {
+		return nil
+	}
+	cfg := h.res.System(ctx).GetPushPolicy()
+	if !cfg.GetEnabled() || cfg.GetUrl() == "" {
+		return nil
+	}
+	if p.Timestamp == "" {
+		p.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	allow, msg, err := h.ask(ctx, cfg.GetUrl(), cfg.GetFormat(), cfg.GetSecret(), time.Duration(cfg.GetTimeoutMs())*time.Millisecond, p)
+	if err != nil {
+		if cfg.GetFailOpen() {
+			h.log.Warn("policy: hook failed, accepting %s to %s/%s: %v", p.Event, p.Namespace, p.Repo, err)
+			return nil
+		}
+		h.log.Error("policy: hook failed, refusing %s to %s/%s: %v", p.Event, p.Namespace, p.Repo, err)
+		return fmt.Errorf("%w: policy hook is unavailable, try again later", ErrDenied)
+	}
+	if allow {
+		return nil
+	}
+	h.log.Info("policy: refused %s to %s/%s by %s: %s", p.Event, p.Namespace, p.Repo, p.User, msg)
+	if msg == "" {
+		return ErrDenied
+	}
+	return fmt.Errorf("%w: %s", ErrDenied, msg)
+}
+
+func (h *Hook) ask(ctx context.Context, url, format, secret string, timeout time.Duration, p Push) (bool, string, error) {
+	opa := strings.EqualFold(format, "opa")
+	var payload any = p
+	if opa {
+		payload = map[string]any{"input": p}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false, "", err
+	}
+
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Distroface-Event", "push.pre_receive")
+	if secret != "" {
+		req.Header.Set(webhook.Signature(secret, body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+
+	if opa {
+		return opaDecision(resp.StatusCode, raw)
+	}
+	return webhookDecision(resp.StatusCode, raw)
+}
+
+// 2xx allows unless the body says otherwise, 4xx denies with the body as
+// the message, anything else is a hook failure
+func webhookDecision(status int, raw []byte) (bool, string, error) {
+	var d decision
+	parsed := json.Unmarshal(raw, &d) == nil
+	msg := d.Message
+	if msg == "" {
+		msg = d.Reason
+	}
+	switch {
+	case status >= 200 && status < 300:
+		if parsed && d.Allow != nil && !*d.Allow {
+			return false, msg, nil
+		}
+		return true, "", nil
+	case status >= 400 && status < 500:
+		if !parsed {
+			msg = strings.TrimSpace(string(raw))
+		}
+		return false, msg, nil
+	default:
+		return false, "", fmt.Errorf("hook returned status %d", status)
+	}
+}
+
+// OPA data api responses, an undefined result is a misconfigured policy
+func opaDecision(status int, raw []byte) (bool, string, error) {
+	if status < 200 || status >= 300 {
+		return false, "", fmt.Errorf("opa returned status %d", status)
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return false, "", fmt.Errorf("decoding opa response: %w", err)
+	}
+	if len(resp.Result) == 0 {
+		return false, "", fmt.Errorf("opa policy result is undefined")
+	}
+	var allow bool
+	if json.Unmarshal(resp.Result, &allow) == nil {
+		return allow, "", nil
+	}
+	var d decision
+	if err := json.Unmarshal(resp.Result, &d); err != nil || d.Allow == nil {
+		return false, "", fmt.Errorf("opa result must be a boolean or have an allow field")
+	}
+	msg := d.Message
+	if msg == "" {
+		msg = d.Reason
+	}
+	return *d.Allow, msg, nil
+}
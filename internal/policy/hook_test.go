@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+func newTestHook(t *testing.T, cfg *v1.PushPolicySettings) *Hook {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	res := settings.NewResolver(store, nil)
+	if err := res.SeedSystem(context.Background(), &v1.Settings{PushPolicy: cfg}); err != nil {
+		t.Fatalf("SeedSystem: %v", err)
+	}
+	return NewHook(res, logger.NewWithConfig(&logger.Config{Enabled: false}))
+}
+
+// Webhook hooks allow on 2xx and deny on 4xx or an explicit allow false
+func TestHookWebhookDecisions(t *testing.T) {
+	var got Push
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Distroface-Signature-256") == "" {
+			t.Error("hook body not signed")
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		switch got.Repo {
+		case "denied":
+			http.Error(w, "no pushes on fridays", http.StatusForbidden)
+		case "json":
+			w.Write([]byte(`{"allow": false, "message": "labels missing"}`))
+		case "broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	h := newTestHook(t, &v1.PushPolicySettings{Enabled: proto.Bool(true), Url: proto.String(srv.URL), Secret: proto.String("s3cret")})
+	ctx := context.Background()
+
+	if err := h.Check(ctx, Push{Event: EventManifestPush, User: "alice", Namespace: "alice", Repo: "app", Tag: "v1"}); err != nil {
+		t.Fatalf("allowed push: %v", err)
+	}
+	if got.User != "alice" || got.Tag != "v1" || got.Timestamp == "" {
+		t.Fatalf("hook got %+v", got)
+	}
+	if err := h.Check(ctx, Push{Repo: "denied"}); !errors.Is(err, ErrDenied) || !strings.Contains(err.Error(), "no pushes on fridays") {
+		t.Fatalf("4xx: %v", err)
+	}
+	if err := h.Check(ctx, Push{Repo: "json"}); !errors.Is(err, ErrDenied) || !strings.Contains(err.Error(), "labels missing") {
+		t.Fatalf("allow false: %v", err)
+	}
+	if err := h.Check(ctx, Push{Repo: "broken"}); !errors.Is(err, ErrDenied) || !strings.Contains(err.Error(), "unavailable") {
+		t.Fatalf("failing hook should deny: %v", err)
+	}
+}
+
+// OPA hooks get the push as input and answer with a result
+func TestHookOPADecisions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input Push `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body.Input.Repo {
+		case "plain":
+			w.Write([]byte(`{"result": true}`))
+		case "object":
+			w.Write([]byte(`{"result": {"allow": false, "reason": "too big"}}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	h := newTestHook(t, &v1.PushPolicySettings{Enabled: proto.Bool(true), Url: proto.String(srv.URL), Format: proto.String("opa")})
+	ctx := context.Background()
+
+	if err := h.Check(ctx, Push{Repo: "plain"}); err != nil {
+		t.Fatalf("boolean result: %v", err)
+	}
+	if err := h.Check(ctx, Push{Repo: "object"}); !errors.Is(err, ErrDenied) || !strings.Contains(err.Error(), "too big") {
+		t.Fatalf("object result: %v", err)
+	}
+	if err := h.Check(ctx, Push{Repo: "undefined"}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("undefined result should deny: %v", err)
+	}
+}
+
+// Unreachable hooks only pass pushes with fail_open, disabled hooks never run
+func TestHookUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	ctx := context.Background()
+
+	open := newTestHook(t, &v1.PushPolicySettings{Enabled: proto.Bool(true), Url: proto.String(url), FailOpen: proto.Bool(true)})
+	if err := open.Check(ctx, Push{Repo: "app"}); err != nil {
+		t.Fatalf("fail open: %v", err)
+	}
+	closed := newTestHook(t, &v1.PushPolicySettings{Enabled: proto.Bool(true), Url: proto.String(url)})
+	if err := closed.Check(ctx, Push{Repo: "app"}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("fail closed: %v", err)
+	}
+	off := newTestHook(t, &v1.PushPolicySettings{Enabled: proto.Bool(false), Url: proto.String(url)})
+	if err := off.Check(ctx, Push{Repo: "app"}); err != nil {
+		t.Fatalf("disabled hook: %v", err)
+	}
+}
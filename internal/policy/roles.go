@@ -0,0 +1,26 @@
+package policy
+
+import (
+	"context"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db/stores"
+)
+
+// Roles of username, from the caller in ctx when that is them, otherwise
+// looked up. Callers in ctx were loaded from the store for this request,
+// registry tokens only name the account. False for unknown users
+func userRoles(ctx context.Context, store *stores.Store, username string) ([]string, bool) {
+	if u := auth.UserFromContext(ctx); u != nil && u.Username == username && u.Roles != nil {
+		return u.Roles, true
+	}
+	user, err := store.GetUserByUsername(ctx, username)
+	if err != nil || user == nil {
+		return nil, false
+	}
+	roles, err := store.GetUserRoleNames(ctx, user.ID)
+	if err != nil {
+		return nil, false
+	}
+	return roles, true
+}
@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/settings"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+var (
+	// Public repos the caller's roles may not create or publish
+	ErrPublishDenied = errors.New("your roles may not make repositories public")
+	// Visibility changes the caller's roles may not make
+	ErrToggleDenied = errors.New("your roles may not change repository visibility")
+)
+
+// Applies the visibility settings to image and artifact repos. A nil
+// Visibility imposes nothing
+type Visibility struct {
+	store *stores.Store
+	res   *settings.Resolver
+}
+
+func NewVisibility(store *stores.Store, res *settings.Resolver) *Visibility {
+	return &Visibility{store: store, res: res}
+}
+
+func (v *Visibility) settings(ctx context.Context) *v1.VisibilitySettings {
+	return v.res.System(ctx).GetVisibility()
+}
+
+func holdsAny(roles, listed []string) bool {
+	for _, r := range roles {
+		if slices.ContainsFunc(listed, func(l string) bool { return strings.EqualFold(l, r) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAdmin(roles []string) bool {
+	return holdsAny(roles, []string{"admin"})
+}
+
+// Reports whether these roles may create public repos or make one public
+func (v *Visibility) CanPublish(ctx context.Context, roles []string) bool {
+	if v == nil || isAdmin(roles) {
+		return true
+	}
+	cfg := v.settings(ctx)
+	if holdsAny(roles, cfg.GetForcedPrivateRoles()) {
+		return false
+	}
+	return len(cfg.GetPublicRoles()) == 0 || holdsAny(roles, cfg.GetPublicRoles())
+}
+
+// Reports whether these roles may change an existing repo's visibility
+func (v *Visibility) CanToggle(ctx context.Context, roles []string) bool {
+	if v == nil || isAdmin(roles) {
+		return true
+	}
+	toggle := v.settings(ctx).GetToggleRoles()
+	return len(toggle) == 0 || holdsAny(roles, toggle)
+}
+
+// Visibility a new repo gets. Asking for a public repo without the right
+// fails when explicit, otherwise the repo quietly starts private
+func (v *Visibility) ForCreate(ctx context.Context, roles []string, private, explicit bool) (bool, error) {
+	if private || v.CanPublish(ctx, roles) {
+		return private, nil
+	}
+	if explicit {
+		return false, ErrPublishDenied
+	}
+	return true, nil
+}
+
+// Checks a visibility change on an existing repo, nil when nothing changes
+func (v *Visibility) CheckChange(ctx context.Context, roles []string, wasPrivate, private bool) error {
+	if wasPrivate == private {
+		return nil
+	}
+	if !v.CanToggle(ctx, roles) {
+		return ErrToggleDenied
+	}
+	if !private && !v.CanPublish(ctx, roles) {
+		return ErrPublishDenied
+	}
+	return nil
+}
+
+// Whether a repo created by a first push starts private. Pushes without a
+// known user carry no roles
+func (v *Visibility) PushedPrivate(ctx context.Context, username string) bool {
+	if v == nil {
+		return false
+	}
+	var roles []string
+	if username != "" {
+		roles, _ = userRoles(ctx, v.store, username)
+	}
+	return !v.CanPublish(ctx, roles)
+}
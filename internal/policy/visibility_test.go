@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/settings"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+func newTestVisibility(t *testing.T, cfg *v1.VisibilitySettings) *Visibility {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+	for _, role := range []string{"developers", "contractors"} {
+		if err := store.CreateRole(ctx, &db.Role{Name: role}); err != nil {
+			t.Fatalf("CreateRole: %v", err)
+		}
+	}
+	for _, u := range []struct{ name, role string }{{"alice", "developers"}, {"bob", "contractors"}} {
+		user := &db.User{Username: u.name}
+		if err := store.CreateUser(ctx, user); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := store.AssignRole(ctx, user.ID, u.role, "test"); err != nil {
+			t.Fatalf("AssignRole: %v", err)
+		}
+	}
+	return NewVisibility(store, settings.NewResolver(store, &v1.Settings{Visibility: cfg}))
+}
+
+func TestVisibilityPublish(t *testing.T) {
+	ctx := context.Background()
+	v := newTestVisibility(t, &v1.VisibilitySettings{
+		PublicRoles:        []string{"developers", "contractors"},
+		ForcedPrivateRoles: []string{"contractors"},
+		ToggleRoles:        []string{"developers"},
+	})
+
+	cases := []struct {
+		name    string
+		roles   []string
+		publish bool
+		toggle  bool
+	}{
+		{"listed", []string{"developers"}, true, true},
+		{"forced private wins", []string{"developers", "contractors"}, false, true},
+		{"unlisted", []string{"user"}, false, false},
+		{"admin", []string{"admin"}, true, true},
+		{"no roles", nil, false, false},
+	}
+	for _, c := range cases {
+		if got := v.CanPublish(ctx, c.roles); got != c.publish {
+			t.Errorf("%s: CanPublish = %v, want %v", c.name, got, c.publish)
+		}
+		if got := v.CanToggle(ctx, c.roles); got != c.toggle {
+			t.Errorf("%s: CanToggle = %v, want %v", c.name, got, c.toggle)
+		}
+	}
+
+	// Defaults go private quietly, asking for public outright fails
+	if private, err := v.ForCreate(ctx, []string{"user"}, false, false); err != nil || !private {
+		t.Fatalf("default create = %v, %v, want private", private, err)
+	}
+	if _, err := v.ForCreate(ctx, []string{"user"}, false, true); !errors.Is(err, ErrPublishDenied) {
+		t.Fatalf("explicit public create err = %v, want ErrPublishDenied", err)
+	}
+	if private, err := v.ForCreate(ctx, []string{"developers"}, false, true); err != nil || private {
+		t.Fatalf("developer create = %v, %v, want public", private, err)
+	}
+
+	if err := v.CheckChange(ctx, []string{"user"}, true, false); !errors.Is(err, ErrToggleDenied) {
+		t.Fatalf("unlisted toggle err = %v, want ErrToggleDenied", err)
+	}
+	if err := v.CheckChange(ctx, []string{"developers", "contractors"}, true, false); !errors.Is(err, ErrPublishDenied) {
+		t.Fatalf("forced private publish err = %v, want ErrPublishDenied", err)
+	}
+	if err := v.CheckChange(ctx, []string{"developers", "contractors"}, false, true); err != nil {
+		t.Fatalf("making private: %v", err)
+	}
+	if err := v.CheckChange(ctx, []string{"user"}, true, true); err != nil {
+		t.Fatalf("unchanged visibility: %v", err)
+	}
+
+	if v.PushedPrivate(ctx, "alice") {
+		t.Fatal("developer push created a private repo")
+	}
+	if !v.PushedPrivate(ctx, "bob") {
+		t.Fatal("contractor push created a public repo")
+	}
+}
+
+func TestVisibilityUnset(t *testing.T) {
+	ctx := context.Background()
+	v := newTestVisibility(t, nil)
+	if !v.CanPublish(ctx, []string{"user"}) || !v.CanToggle(ctx, []string{"user"}) {
+		t.Fatal("empty settings restricted visibility")
+	}
+	var none *Visibility
+	if none.PushedPrivate(ctx, "alice") || none.CheckChange(ctx, nil, true, false) != nil {
+		t.Fatal("nil visibility imposed a policy")
+	}
+}
@@ -75,6 +75,7 @@ func (res *Resolver) Middleware(primaryHost func() string, next http.Handler) ht
 					r.URL.Path = "/v2/" + mapped + "/" + suffix
 					r.URL.RawPath = "" // Repo names have no escapable chars
 				}
+				mapMountSource(r, p)
 			}
 
 		case strings.HasPrefix(r.URL.Path, "/api/v1/artifacts"):
@@ -131,6 +132,24 @@ func (res *Resolver) Middleware(primaryHost func() string, next http.Handler) ht
 	})
 }
 
+// Cross repository mounts name their source in the query, it maps like
+// the path. A source outside the portal drops the mount, so the client
+// uploads the blob instead of learning the foreign repo holds it
+func mapMountSource(r *http.Request, p *Portal) {
+	q := r.URL.Query()
+	from := q.Get("from")
+	if r.Method != http.MethodPost || from == "" || q.Get("mount") == "" {
+		return
+	}
+	if mapped := p.MapName(from); !p.InScope(mapped) {
+		q.Del("mount")
+		q.Del("from")
+	} else {
+		q.Set("from", mapped)
+	}
+	r.URL.RawQuery = q.Encode()
+}
+
 // Isolated portals answer foreign repos as unknown
 func denyForeignOCI(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
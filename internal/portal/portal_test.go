@@ -784,6 +784,20 @@ func TestMiddlewareIsolated(t *testing.T) {
 		}
 	}
 
+	// Mount sources map like paths, foreign ones turn into plain uploads
+	var gotFrom, gotMount string
+	mh := res.Middleware(func() string { return "" }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotFrom, gotMount = r.URL.Query().Get("from"), r.URL.Query().Get("mount")
+	}))
+	mh.ServeHTTP(httptest.NewRecorder(), portalRequest(http.MethodPost, "/v2/myimg/blobs/uploads/?mount=sha256:abc&from=base", "acme.example.com", 0))
+	if gotFrom != "acme/base" || gotMount != "sha256:abc" {
+		t.Errorf("mapped mount = from %q mount %q, want acme/base", gotFrom, gotMount)
+	}
+	mh.ServeHTTP(httptest.NewRecorder(), portalRequest(http.MethodPost, "/v2/myimg/blobs/uploads/?mount=sha256:abc&from=other/base", "acme.example.com", 0))
+	if gotFrom != "" || gotMount != "" {
+		t.Errorf("foreign mount kept from %q mount %q", gotFrom, gotMount)
+	}
+
 	// Unmappable repo params collapse into the org scope
 	var gotNS, gotRepo string
 	h := res.Middleware(func() string { return "" }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -147,6 +147,27 @@ func (m *Manager) ProbePort(port int) error {
 	return ln.Close()
 }
 
+// Stops every portal listener and waits for requests in flight, such as
+// blob uploads, to finish. Whatever still runs when ctx ends is cut off
+func (m *Manager) Shutdown(ctx context.Context) {
+	m.mu.Lock()
+	servers := m.servers
+	m.servers = map[int]*portListener{}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for port, pl := range servers {
+		wg.Go(func() {
+			_ = pl.raw.Close()
+			if err := pl.srv.Shutdown(ctx); err != nil {
+				m.log.Warn("portal listener on port %d cut off at shutdown: %v", port, err)
+				_ = pl.srv.Close()
+			}
+		})
+	}
+	wg.Wait()
+}
+
 // Closes every portal listener
 func (m *Manager) Close() {
 	m.mu.Lock()
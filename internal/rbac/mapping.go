@@ -17,19 +17,28 @@ type ProcedurePermission struct {
 
 // PublicProcedures lists RPC procedures that require no authentication.
 var PublicProcedures = map[string]bool{
-	distrofacev1connect.AuthServiceRegisterProcedure:                  true,
-	distrofacev1connect.AuthServiceLoginProcedure:                     true,
-	distrofacev1connect.AuthServiceGetAuthStatusProcedure:             true,
-	distrofacev1connect.AuthServiceGetOIDCLoginURLProcedure:           true,
-	distrofacev1connect.HealthServiceHealthCheckProcedure: true,
+	distrofacev1connect.AuthServiceRegisterProcedure:        true,
+	distrofacev1connect.AuthServiceLoginProcedure:           true,
+	distrofacev1connect.AuthServiceGetAuthStatusProcedure:   true,
+	distrofacev1connect.AuthServiceGetOIDCLoginURLProcedure: true,
+	distrofacev1connect.HealthServiceHealthCheckProcedure:   true,
+	// Clients probe features before signing in
+	distrofacev1connect.HealthServiceGetCapabilitiesProcedure: true,
 	// Anonymous callers receive the redacted public subset only
 	distrofacev1connect.SettingsServiceGetEffectiveSettingsProcedure: true,
+	// Login screens show branding before anyone signs in
+	distrofacev1connect.SettingsServiceGetBrandingProcedure: true,
 	// Public repo browsing (visibility filtering handled in service)
-	distrofacev1connect.RepositoryServiceGetRepositoryProcedure:    true,
-	distrofacev1connect.RepositoryServiceListRepositoriesProcedure: true,
-	distrofacev1connect.RepositoryServiceListTagsProcedure:         true,
-	distrofacev1connect.RepositoryServiceResolveTagProcedure:       true,
-	distrofacev1connect.UserServiceGetUserProcedure:                true,
+	distrofacev1connect.RepositoryServiceGetRepositoryProcedure:        true,
+	distrofacev1connect.RepositoryServiceListRepositoriesProcedure:     true,
+	distrofacev1connect.RepositoryServiceListTagsProcedure:             true,
+	distrofacev1connect.RepositoryServiceResolveTagProcedure:           true,
+	distrofacev1connect.RepositoryServiceGetTagProvenanceProcedure:     true,
+	distrofacev1connect.RepositoryServiceVerifyImageSignatureProcedure: true,
+	distrofacev1connect.RepositoryServiceListSigningKeysProcedure:      true,
+	distrofacev1connect.UserServiceGetUserProcedure:                    true,
+	// Comments follow the visibility of the repo they sit on
+	distrofacev1connect.CommentServiceListCommentsProcedure: true,
 	// Invite validation is public (used during registration)
 	distrofacev1connect.AuthServiceValidateInviteProcedure: true,
 	// Portal identity for the serving host, needed pre-login
@@ -47,15 +56,71 @@ var AuthenticatedOnlyProcedures = map[string]bool{
 	// User - self-service
 	distrofacev1connect.UserServiceUpdateUserProcedure:     true,
 	distrofacev1connect.UserServiceChangePasswordProcedure: true,
+	distrofacev1connect.UserServiceGetMyUsageProcedure:     true,
 
 	// Stars - read access enforced in-service
 	distrofacev1connect.RepositoryServiceStarRepositoryProcedure:          true,
 	distrofacev1connect.RepositoryServiceUnstarRepositoryProcedure:        true,
 	distrofacev1connect.RepositoryServiceListStarredRepositoriesProcedure: true,
 
+	// Fork - source read and target namespace checked in-service
+	distrofacev1connect.RepositoryServiceForkRepositoryProcedure: true,
+
+	// Tag copy - source read and target push checked in-service
+	distrofacev1connect.RepositoryServiceCopyTagProcedure: true,
+
+	// Tag expiry - repo read and push checked in-service
+	distrofacev1connect.RepositoryServiceSetTagExpiryProcedure: true,
+
+	// Layer sharing walks storage, repo read checked in-service
+	distrofacev1connect.RepositoryServiceGetLayerSharingProcedure: true,
+
+	// Repository ACLs - admin over the image or artifact repo checked in-service
+	distrofacev1connect.RepositoryServiceListRepositoryPermissionsProcedure:  true,
+	distrofacev1connect.RepositoryServiceSetRepositoryPermissionProcedure:    true,
+	distrofacev1connect.RepositoryServiceRemoveRepositoryPermissionProcedure: true,
+
+	// Prewarm - repo read checked in-service
+	distrofacev1connect.RepositoryServicePrewarmTagProcedure: true,
+
+	// Comments - target read and authorship checked in-service
+	distrofacev1connect.CommentServiceCreateCommentProcedure: true,
+	distrofacev1connect.CommentServiceUpdateCommentProcedure: true,
+	distrofacev1connect.CommentServiceDeleteCommentProcedure: true,
+
+	// Notifications - self scoped, repo read checked in-service
+	distrofacev1connect.NotificationServiceWatchRepositoryProcedure:               true,
+	distrofacev1connect.NotificationServiceUnwatchRepositoryProcedure:             true,
+	distrofacev1connect.NotificationServiceListWatchesProcedure:                   true,
+	distrofacev1connect.NotificationServiceGetNotificationPreferencesProcedure:    true,
+	distrofacev1connect.NotificationServiceUpdateNotificationPreferencesProcedure: true,
+	distrofacev1connect.NotificationServiceListNotificationsProcedure:             true,
+	distrofacev1connect.NotificationServiceMarkNotificationsReadProcedure:         true,
+
+	// Export manifests - read of every selected repo checked in-service
+	distrofacev1connect.ExportServiceGetExportManifestProcedure:  true,
+	distrofacev1connect.ExportServiceDiffExportManifestProcedure: true,
+
+	// Key rotation - repo manage or settings update checked in-service
+	distrofacev1connect.RepositoryServiceRotateSigningKeyProcedure: true,
+
 	// Org slug resolution, object scoped read enforced in-service
 	distrofacev1connect.OrganizationServiceGetOrganizationProcedure: true,
 
+	// Credential namespace ownership enforced in-service
+	distrofacev1connect.CredentialServiceCreateCredentialProcedure: true,
+	distrofacev1connect.CredentialServiceListCredentialsProcedure:  true,
+	distrofacev1connect.CredentialServiceUpdateCredentialProcedure: true,
+	distrofacev1connect.CredentialServiceDeleteCredentialProcedure: true,
+
+	// Replication rule namespace ownership enforced in-service
+	distrofacev1connect.ReplicationServiceListReplicationRulesProcedure:  true,
+	distrofacev1connect.ReplicationServiceGetReplicationRuleProcedure:    true,
+	distrofacev1connect.ReplicationServiceCreateReplicationRuleProcedure: true,
+	distrofacev1connect.ReplicationServiceUpdateReplicationRuleProcedure: true,
+	distrofacev1connect.ReplicationServiceDeleteReplicationRuleProcedure: true,
+	distrofacev1connect.ReplicationServiceSyncReplicationRuleProcedure:   true,
+
 	// Settings scope permissions enforced in-service per tier
 	distrofacev1connect.SettingsServiceGetSettingsProcedure:    true,
 	distrofacev1connect.SettingsServiceUpdateSettingsProcedure: true,
@@ -73,6 +138,7 @@ var AuthenticatedOnlyProcedures = map[string]bool{
 var ProcedurePermissions = map[string]ProcedurePermission{
 	// ── RepositoryService ─────────────────────────────────────────────
 	distrofacev1connect.RepositoryServiceDeleteRepositoryProcedure: {Resource: ResourceRepositories, Action: ActionDelete, ObjectIDField: "namespace+name"},
+	distrofacev1connect.RepositoryServiceDeleteTagProcedure:        {Resource: ResourceRepositories, Action: ActionDeleteTag, ObjectIDField: "namespace+name"},
 	distrofacev1connect.RepositoryServiceUpdateRepositoryProcedure: {Resource: ResourceRepositories, Action: ActionUpdate, ObjectIDField: "namespace+name"},
 
 	// ── UserService (admin) ───────────────────────────────────────────
@@ -95,18 +161,28 @@ var ProcedurePermissions = map[string]ProcedurePermission{
 	distrofacev1connect.RoleServiceAssignRoleProcedure:           {Resource: ResourceRoles, Action: ActionCreate},
 	distrofacev1connect.RoleServiceUnassignRoleProcedure:         {Resource: ResourceRoles, Action: ActionDelete},
 	distrofacev1connect.RoleServiceGetUserRolesProcedure:         {Resource: ResourceRoles, Action: ActionRead},
+	distrofacev1connect.RoleServiceListRoleMembersProcedure:      {Resource: ResourceRoles, Action: ActionRead},
 
 	// ── GCService (admin) ─────────────────────────────────────────────
-	distrofacev1connect.GCServiceRunGCProcedure:           {Resource: ResourceSettings, Action: ActionUpdate},
-	distrofacev1connect.GCServiceGetGCStatusProcedure:     {Resource: ResourceSettings, Action: ActionRead},
-	distrofacev1connect.GCServiceGetStorageUsageProcedure: {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.GCServiceRunGCProcedure:                {Resource: ResourceSettings, Action: ActionUpdate},
+	distrofacev1connect.GCServiceGetGCStatusProcedure:          {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.GCServiceGetStorageUsageProcedure:      {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.GCServiceGetArtifactUsageProcedure:     {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.GCServiceGetRetentionForecastProcedure: {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.GCServicePruneUploadsProcedure:         {Resource: ResourceSettings, Action: ActionUpdate},
+	distrofacev1connect.GCServiceVerifyStorageProcedure:        {Resource: ResourceSettings, Action: ActionUpdate},
+	distrofacev1connect.GCServiceGetAdminSummaryProcedure:      {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.GCServiceListAlertsProcedure:           {Resource: ResourceSettings, Action: ActionRead},
+
+	// ── NotificationService (admin) ───────────────────────────────────
+	distrofacev1connect.NotificationServiceTestNotificationChannelProcedure: {Resource: ResourceSettings, Action: ActionUpdate},
 
 	// ── AuthService (admin) ───────────────────────────────────────────
-	distrofacev1connect.AuthServiceCreateInviteProcedure: {Resource: ResourceSettings, Action: ActionCreate},
-	distrofacev1connect.AuthServiceListInvitesProcedure:        {Resource: ResourceSettings, Action: ActionRead},
-	distrofacev1connect.AuthServiceGetInviteProcedure:          {Resource: ResourceSettings, Action: ActionRead},
-	distrofacev1connect.AuthServiceDeleteInviteProcedure:       {Resource: ResourceSettings, Action: ActionDelete},
-	distrofacev1connect.AuthServiceBulkDeleteInvitesProcedure:  {Resource: ResourceSettings, Action: ActionDelete},
+	distrofacev1connect.AuthServiceCreateInviteProcedure:      {Resource: ResourceSettings, Action: ActionCreate},
+	distrofacev1connect.AuthServiceListInvitesProcedure:       {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.AuthServiceGetInviteProcedure:         {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.AuthServiceDeleteInviteProcedure:      {Resource: ResourceSettings, Action: ActionDelete},
+	distrofacev1connect.AuthServiceBulkDeleteInvitesProcedure: {Resource: ResourceSettings, Action: ActionDelete},
 
 	// ── TokenService ────────────────────────────────────────────────
 	distrofacev1connect.TokenServiceCreateAPITokenProcedure: {Resource: ResourceTokens, Action: ActionCreate},
@@ -136,31 +212,44 @@ var ProcedurePermissions = map[string]ProcedurePermission{
 	distrofacev1connect.CertificateServiceAddCertificateDomainProcedure:     {Resource: ResourceOrganizations, Action: ActionUpdate, ObjectIDField: "org_id"},
 	distrofacev1connect.CertificateServiceApproveCertificateDomainProcedure: {Resource: ResourceSettings, Action: ActionManage},
 	distrofacev1connect.CertificateServiceUploadTLSCertificateProcedure:     {Resource: ResourceOrganizations, Action: ActionUpdate, ObjectIDField: "org_id"},
-	distrofacev1connect.CertificateServiceDeleteTLSCertificateProcedure:         {Resource: ResourceOrganizations, Action: ActionUpdate, ObjectIDField: "org_id"},
-	distrofacev1connect.CertificateServiceGetTLSMaterialProcedure:               {Resource: ResourceOrganizations, Action: ActionRead, ObjectIDField: "org_id"},
-	distrofacev1connect.CertificateServiceGenerateOrgCAProcedure:                {Resource: ResourceOrganizations, Action: ActionUpdate, ObjectIDField: "org_id"},
-	distrofacev1connect.CertificateServiceGenerateAppCAProcedure:                {Resource: ResourceSettings, Action: ActionManage},
-	distrofacev1connect.CertificateServiceIssueOrgICAProcedure:                  {Resource: ResourceOrganizations, Action: ActionUpdate, ObjectIDField: "org_id"},
-	distrofacev1connect.CertificateServiceGetCertStatusProcedure:                {Resource: ResourceOrganizations, Action: ActionRead, ObjectIDField: "org_id"},
+	distrofacev1connect.CertificateServiceDeleteTLSCertificateProcedure:     {Resource: ResourceOrganizations, Action: ActionUpdate, ObjectIDField: "org_id"},
+	distrofacev1connect.CertificateServiceGetTLSMaterialProcedure:           {Resource: ResourceOrganizations, Action: ActionRead, ObjectIDField: "org_id"},
+	distrofacev1connect.CertificateServiceGenerateOrgCAProcedure:            {Resource: ResourceOrganizations, Action: ActionUpdate, ObjectIDField: "org_id"},
+	distrofacev1connect.CertificateServiceGenerateAppCAProcedure:            {Resource: ResourceSettings, Action: ActionManage},
+	distrofacev1connect.CertificateServiceIssueOrgICAProcedure:              {Resource: ResourceOrganizations, Action: ActionUpdate, ObjectIDField: "org_id"},
+	distrofacev1connect.CertificateServiceGetCertStatusProcedure:            {Resource: ResourceOrganizations, Action: ActionRead, ObjectIDField: "org_id"},
 
 	// ── AuditService (admin) ──────────────────────────────────────────
 	distrofacev1connect.AuditServiceListAuditEventsProcedure: {Resource: ResourceSettings, Action: ActionRead},
 
+	// ── JobService (admin) ────────────────────────────────────────────
+	distrofacev1connect.JobServiceListJobsProcedure: {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.JobServiceGetJobProcedure:   {Resource: ResourceSettings, Action: ActionRead},
+	distrofacev1connect.JobServiceRetryJobProcedure: {Resource: ResourceSettings, Action: ActionUpdate},
+
 	// ── ArtifactService ───────────────────────────────────────────────
-	distrofacev1connect.ArtifactServiceCreateArtifactRepositoryProcedure: {Resource: ResourceArtifacts, Action: ActionCreate},
-	distrofacev1connect.ArtifactServiceGetArtifactRepositoryProcedure:    {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+name"},
-	distrofacev1connect.ArtifactServiceListArtifactRepositoriesProcedure: {Resource: ResourceArtifacts, Action: ActionRead},
-	distrofacev1connect.ArtifactServiceUpdateArtifactRepositoryProcedure: {Resource: ResourceArtifacts, Action: ActionUpdate, ObjectIDField: "namespace+name"},
-	distrofacev1connect.ArtifactServiceDeleteArtifactRepositoryProcedure: {Resource: ResourceArtifacts, Action: ActionDelete, ObjectIDField: "namespace+name"},
-	distrofacev1connect.ArtifactServiceInitiateArtifactUploadProcedure:   {Resource: ResourceArtifacts, Action: ActionPush, ObjectIDField: "namespace+repo_name"},
-	distrofacev1connect.ArtifactServiceCompleteArtifactUploadProcedure:   {Resource: ResourceArtifacts, Action: ActionPush, ObjectIDField: "namespace+repo_name"},
-	distrofacev1connect.ArtifactServiceGetArtifactProcedure:              {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+repo_name"},
-	distrofacev1connect.ArtifactServiceListArtifactsProcedure:            {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+repo_name"},
-	distrofacev1connect.ArtifactServiceListArtifactVersionsProcedure:     {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+repo_name"},
-	distrofacev1connect.ArtifactServiceSearchArtifactsProcedure:          {Resource: ResourceArtifacts, Action: ActionRead},
-	distrofacev1connect.ArtifactServiceUpdateArtifactProcedure:           {Resource: ResourceArtifacts, Action: ActionUpdate, ObjectIDField: "namespace+repo_name"},
-	distrofacev1connect.ArtifactServiceSetArtifactPropertiesProcedure:    {Resource: ResourceArtifacts, Action: ActionUpdate, ObjectIDField: "namespace+repo_name"},
-	distrofacev1connect.ArtifactServiceDeleteArtifactProcedure:           {Resource: ResourceArtifacts, Action: ActionDelete, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceCreateArtifactRepositoryProcedure:   {Resource: ResourceArtifacts, Action: ActionCreate},
+	distrofacev1connect.ArtifactServiceGetArtifactRepositoryProcedure:      {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+name"},
+	distrofacev1connect.ArtifactServiceListArtifactRepositoriesProcedure:   {Resource: ResourceArtifacts, Action: ActionRead},
+	distrofacev1connect.ArtifactServiceUpdateArtifactRepositoryProcedure:   {Resource: ResourceArtifacts, Action: ActionUpdate, ObjectIDField: "namespace+name"},
+	distrofacev1connect.ArtifactServiceDeleteArtifactRepositoryProcedure:   {Resource: ResourceArtifacts, Action: ActionDelete, ObjectIDField: "namespace+name"},
+	distrofacev1connect.ArtifactServiceInitiateArtifactUploadProcedure:     {Resource: ResourceArtifacts, Action: ActionPush, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceCompleteArtifactUploadProcedure:     {Resource: ResourceArtifacts, Action: ActionPush, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceGetArtifactProcedure:                {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceListArtifactsProcedure:              {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceListArtifactVersionsProcedure:       {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceListLatestArtifactsProcedure:        {Resource: ResourceArtifacts, Action: ActionRead, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceSearchArtifactsProcedure:            {Resource: ResourceArtifacts, Action: ActionRead},
+	distrofacev1connect.ArtifactServiceUpdateArtifactProcedure:             {Resource: ResourceArtifacts, Action: ActionUpdate, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceSetArtifactPropertiesProcedure:      {Resource: ResourceArtifacts, Action: ActionUpdate, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceBulkEditArtifactPropertiesProcedure: {Resource: ResourceArtifacts, Action: ActionUpdate},
+	distrofacev1connect.ArtifactServiceDeleteArtifactProcedure:             {Resource: ResourceArtifacts, Action: ActionDelete, ObjectIDField: "namespace+repo_name"},
+	distrofacev1connect.ArtifactServiceSetArtifactExpiryProcedure:          {Resource: ResourceArtifacts, Action: ActionUpdate, ObjectIDField: "namespace+repo_name"},
+
+	// ── FreezeService ─────────────────────────────────────────────────
+	distrofacev1connect.FreezeServiceListFreezeWindowsProcedure:  {Resource: ResourceFreezes, Action: ActionRead},
+	distrofacev1connect.FreezeServiceCreateFreezeWindowProcedure: {Resource: ResourceFreezes, Action: ActionCreate},
+	distrofacev1connect.FreezeServiceDeleteFreezeWindowProcedure: {Resource: ResourceFreezes, Action: ActionDelete},
 
 	// ── WebhookService ────────────────────────────────────────────────
 	distrofacev1connect.WebhookServiceCreateWebhookProcedure:         {Resource: ResourceWebhooks, Action: ActionCreate},
@@ -172,6 +261,32 @@ var ProcedurePermissions = map[string]ProcedurePermission{
 	distrofacev1connect.WebhookServiceRedeliverWebhookProcedure:      {Resource: ResourceWebhooks, Action: ActionUpdate},
 }
 
+// TokenScopeProcedures names what scoped api tokens need for the public and
+// authenticated only rpcs they may call, an empty resource takes any scope.
+// Scoped tokens reach no other rpc outside ProcedurePermissions, so one
+// can never mint tokens, change its owner or touch settings
+var TokenScopeProcedures = map[string]ProcedurePermission{
+	distrofacev1connect.AuthServiceGetCurrentUserProcedure: {},
+
+	distrofacev1connect.RepositoryServiceGetRepositoryProcedure:              {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServiceListRepositoriesProcedure:           {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServiceListTagsProcedure:                   {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServiceResolveTagProcedure:                 {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServiceGetLayerSharingProcedure:            {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServiceGetTagProvenanceProcedure:           {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServiceVerifyImageSignatureProcedure:       {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServiceListSigningKeysProcedure:            {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServicePrewarmTagProcedure:                 {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.RepositoryServiceCopyTagProcedure:                    {Resource: ResourceRepositories, Action: ActionPush},
+	distrofacev1connect.RepositoryServiceSetTagExpiryProcedure:               {Resource: ResourceRepositories, Action: ActionPush},
+	distrofacev1connect.RepositoryServiceForkRepositoryProcedure:             {Resource: ResourceRepositories, Action: ActionCreate},
+	distrofacev1connect.RepositoryServiceListRepositoryPermissionsProcedure:  {Resource: ResourceRepositories, Action: ActionManage},
+	distrofacev1connect.RepositoryServiceSetRepositoryPermissionProcedure:    {Resource: ResourceRepositories, Action: ActionManage},
+	distrofacev1connect.RepositoryServiceRemoveRepositoryPermissionProcedure: {Resource: ResourceRepositories, Action: ActionManage},
+	distrofacev1connect.ExportServiceGetExportManifestProcedure:              {Resource: ResourceRepositories, Action: ActionRead},
+	distrofacev1connect.ExportServiceDiffExportManifestProcedure:             {Resource: ResourceRepositories, Action: ActionRead},
+}
+
 // ExtractObjectID extracts a field value from a protobuf request using reflection.
 // If field contains "+", it splits on "+", extracts each proto field, and joins
 // with "/" to form a composite ID (e.g., "namespace+name" → "nick/myimage").
@@ -73,6 +73,7 @@ func (e *Enforcer) SeedDefaultPolicies(anonymousEnabled bool) error {
 			{"user", ResourceRepositories, ActionPull, "*"},
 			{"user", ResourceRepositories, ActionUpdate, "*"},
 			{"user", ResourceRepositories, ActionDelete, "*"},
+			{"user", ResourceRepositories, ActionDeleteTag, "*"},
 			{"user", ResourceTokens, ActionRead, "*"},
 			{"user", ResourceTokens, ActionCreate, "*"},
 			{"user", ResourceTokens, ActionDelete, "*"},
@@ -88,6 +89,7 @@ func (e *Enforcer) SeedDefaultPolicies(anonymousEnabled bool) error {
 			{"user", ResourceArtifacts, ActionCreate, "*"},
 			{"user", ResourceArtifacts, ActionUpdate, "*"},
 			{"user", ResourceArtifacts, ActionDelete, "*"},
+			{"user", ResourceFreezes, ActionRead, "*"},
 		},
 		"anonymous": {
 			{"anonymous", ResourceRepositories, ActionRead, "*"},
@@ -10,17 +10,23 @@ const (
 	ResourceOrganizations = "organizations"
 	ResourceWebhooks      = "webhooks"
 	ResourceArtifacts     = "artifacts"
+	ResourceFreezes       = "freezes"
 )
 
 // Action constants
 const (
-	ActionRead   = "read"
-	ActionCreate = "create"
-	ActionUpdate = "update"
-	ActionDelete = "delete"
-	ActionPush   = "push"
-	ActionPull   = "pull"
-	ActionManage = "manage"
+	ActionRead     = "read"
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete" // Whole repositories, artifacts and other objects
+	ActionPush     = "push"
+	ActionPull     = "pull"
+	ActionManage   = "manage"
+	ActionOverride = "override" // Push through a running freeze window
+
+	// Image deletes finer than removing the repository
+	ActionDeleteTag  = "delete_tag"  // Untag, the manifest stays for gc
+	ActionDeleteBlob = "delete_blob" // Raw manifest and blob deletes over /v2
 )
 
 // Pairs a resource with its valid actions
@@ -31,7 +37,7 @@ type ResourceActionEntry struct {
 
 // Valid actions for each resource
 var ResourceActions = []ResourceActionEntry{
-	{Resource: ResourceRepositories, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionPush, ActionPull, ActionManage}},
+	{Resource: ResourceRepositories, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionDeleteTag, ActionDeleteBlob, ActionPush, ActionPull, ActionManage}},
 	{Resource: ResourceUsers, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionManage}},
 	{Resource: ResourceRoles, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionManage}},
 	{Resource: ResourceSettings, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionManage}},
@@ -39,4 +45,35 @@ var ResourceActions = []ResourceActionEntry{
 	{Resource: ResourceOrganizations, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionManage}},
 	{Resource: ResourceWebhooks, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete}},
 	{Resource: ResourceArtifacts, Actions: []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionPush, ActionPull, ActionManage}},
+	{Resource: ResourceFreezes, Actions: []string{ActionRead, ActionCreate, ActionDelete, ActionOverride}},
+}
+
+// Repository ACL levels, each includes the ones before it
+const (
+	LevelRead  = "read"  // See and pull
+	LevelWrite = "write" // Push, upload and edit artifacts
+	LevelAdmin = "admin" // Delete, settings and the ACL itself
+)
+
+var levelRank = map[string]int{LevelRead: 1, LevelWrite: 2, LevelAdmin: 3}
+
+func ValidLevel(level string) bool {
+	return levelRank[level] > 0
+}
+
+// Whether any of the granted levels covers action on the repo
+func LevelsAllow(levels []string, action string) bool {
+	need := LevelAdmin
+	switch action {
+	case ActionRead, ActionPull:
+		need = LevelRead
+	case ActionPush, ActionCreate, ActionUpdate:
+		need = LevelWrite
+	}
+	for _, l := range levels {
+		if levelRank[l] >= levelRank[need] {
+			return true
+		}
+	}
+	return false
 }
@@ -6,12 +6,14 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/distribution/distribution/v3"
 	regstorage "github.com/distribution/distribution/v3/registry/storage"
-	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
 	"github.com/distribution/reference"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry/packed"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/nickheyer/distroface/pkg/utils"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -22,28 +24,47 @@ import (
 type RegistryAccess struct {
 	registry    distribution.Namespace
 	storagePath string
+
+	links      *stores.Store // Blob link index, nil walks storage
+	linksReady atomic.Bool
+
+	packed *stores.Store // Holds the link files, nil keeps them on disk
 }
 
 // NewRegistryAccess creates a RegistryAccess backed by the filesystem storage driver
 // at the given path. This path must match the registry's configured storage path.
-func NewRegistryAccess(storagePath string) (*RegistryAccess, error) {
-	driver := filesystem.New(filesystem.DriverParameters{
-		RootDirectory: storagePath,
-		MaxThreads:    100,
-	})
+// A non nil packedLinks must match the registry's packed link store too.
+func NewRegistryAccess(storagePath string, packedLinks *stores.Store) (*RegistryAccess, error) {
+	driver := packed.New(storagePath, packedLinks)
 
 	reg, err := regstorage.NewRegistry(context.Background(), driver)
 	if err != nil {
 		return nil, fmt.Errorf("creating registry namespace: %w", err)
 	}
 
-	return &RegistryAccess{registry: reg, storagePath: storagePath}, nil
+	return &RegistryAccess{registry: reg, storagePath: storagePath, packed: packedLinks}, nil
+}
+
+// Drops the packed links of a repository or namespace removed from disk
+func (r *RegistryAccess) dropPacked(repo string) error {
+	if r.packed == nil {
+		return nil
+	}
+	_, err := r.packed.DeletePackedLinks(context.Background(), packed.RepoPath(repo))
+	return err
 }
 
 // DeleteNamespace removes all registry storage for a given namespace.
 func (r *RegistryAccess) DeleteNamespace(namespace string) error {
 	repoPath := filepath.Join(r.storagePath, "docker", "registry", "v2", "repositories", namespace)
-	return os.RemoveAll(repoPath)
+	if err := os.RemoveAll(repoPath); err != nil {
+		return err
+	}
+	if err := r.dropPacked(namespace); err != nil {
+		return err
+	}
+	r.dropNamespaceLinks(namespace)
+	return nil
 }
 
 // ListTags returns all tags for a repository as proto Tag messages.
@@ -102,7 +123,7 @@ func (r *RegistryAccess) ListTags(ctx context.Context, namespace, name string) (
 
 		manifest, err := manifestService.Get(ctx, desc.Digest)
 		if err == nil {
-			t.SizeBytes = utils.ComputeManifestSize(manifest)
+			t.SizeBytes = utils.ComputeImageSize(ctx, manifestService, manifest)
 
 			if t.MediaType == "" {
 				mt, _, _ := manifest.Payload()
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"cmp"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+var bareRepoPathRe = regexp.MustCompile(`^/v2/([^/]+)/((?:manifests|tags|referrers|blobs)/.*)$`)
+
+// Rewrites unqualified repo paths like /v2/myapp/manifests/latest into the
+// bearer's home namespace, their chosen default or their own. The token
+// handler grants the same mapped name, anonymous callers are left alone
+// and get the usual challenge. A bare mount source resolves the same way
+func AliasBareNames(next http.Handler, store *stores.Store, verifier SubjectVerifier, log *logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := bareRepoPathRe.FindStringSubmatch(r.URL.Path)
+		from, mount := mountSource(r)
+		bareFrom := mount && r.Method == http.MethodPost && !strings.Contains(from, "/")
+		raw, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if (m == nil && !bareFrom) || !bearer || verifier == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		subject, namespace := "", ""
+		if user := auth.UserFromContext(r.Context()); user != nil {
+			// The token carries the home namespace, no lookup
+			subject, namespace = user.Username, user.HomeNamespace()
+		} else {
+			sub, err := verifier.VerifyTokenSubject(strings.TrimSpace(raw))
+			if err != nil || sub == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			u, err := store.GetUserByUsername(r.Context(), sub)
+			if err != nil || u == nil || !u.IsActive {
+				next.ServeHTTP(w, r)
+				return
+			}
+			subject, namespace = sub, cmp.Or(u.DefaultNamespace, u.Username)
+		}
+		if m != nil {
+			log.Debug("registry: %s resolves %s to %s/%s", subject, m[1], namespace, m[1])
+			r.URL.Path = "/v2/" + namespace + "/" + m[1] + "/" + m[2]
+			r.URL.RawPath = "" // Repo names have no escapable chars
+		}
+		if bareFrom {
+			setMountSource(r, namespace+"/"+from)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
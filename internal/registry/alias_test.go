@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+// Bare names land in the bearer's default namespace, qualified names and
+// anonymous callers pass through untouched
+func TestAliasBareNames(t *testing.T) {
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+	for _, u := range []*db.User{
+		{Username: "alice", IsActive: true},
+		{Username: "bob", IsActive: true, DefaultNamespace: "acme"},
+	} {
+		if err := store.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	var seen string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r.URL.Path })
+	h := AliasBareNames(next, store, subjectEcho{}, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	for _, c := range []struct {
+		path, token, want string
+	}{
+		{"/v2/app/manifests/latest", "alice", "/v2/alice/app/manifests/latest"},
+		{"/v2/app/blobs/uploads/", "bob", "/v2/acme/app/blobs/uploads/"},
+		{"/v2/app/tags/list", "bob", "/v2/acme/app/tags/list"},
+		{"/v2/acme/app/manifests/latest", "alice", "/v2/acme/app/manifests/latest"},
+		{"/v2/app/manifests/latest", "", "/v2/app/manifests/latest"},
+		{"/v2/app/manifests/latest", "bad", "/v2/app/manifests/latest"},
+		{"/v2/app/manifests/latest", "mallory", "/v2/app/manifests/latest"},
+		{"/v2/_catalog", "alice", "/v2/_catalog"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		seen = ""
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if seen != c.want {
+			t.Errorf("%s as %q reached %s, want %s", c.path, c.token, seen, c.want)
+		}
+	}
+
+	// Mount sources resolve like paths, even under a qualified target
+	var from string
+	h = AliasBareNames(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { from = r.URL.Query().Get("from") }), store, subjectEcho{}, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	for _, c := range []struct {
+		path, token, want string
+	}{
+		{"/v2/app/blobs/uploads/?mount=sha256:abc&from=base", "bob", "acme/base"},
+		{"/v2/alice/app/blobs/uploads/?mount=sha256:abc&from=base", "alice", "alice/base"},
+		{"/v2/app/blobs/uploads/?mount=sha256:abc&from=other/base", "alice", "other/base"},
+		{"/v2/alice/app/blobs/uploads/?mount=sha256:abc&from=base", "", "base"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if from != c.want {
+			t.Errorf("%s as %q mounted from %q, want %q", c.path, c.token, from, c.want)
+		}
+	}
+}
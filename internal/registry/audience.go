@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// Access controller the embedded registry authenticates with
+const audienceAuth = "distroface-token"
+
+func init() {
+	if err := auth.Register(audienceAuth, newAudienceController); err != nil {
+		panic(err)
+	}
+}
+
+// Distribution's token auth accepts one service. This holds one token
+// controller per accepted audience and takes a token any of them accepts,
+// challenges come from the first, the registry's own service
+type audienceController struct {
+	controllers []auth.AccessController
+}
+
+func newAudienceController(options map[string]any) (auth.AccessController, error) {
+	audiences, _ := options["audiences"].([]string)
+	if len(audiences) == 0 {
+		return nil, fmt.Errorf("%s auth requires at least one audience", audienceAuth)
+	}
+	ac := &audienceController{}
+	for _, aud := range audiences {
+		opts := maps.Clone(options)
+		delete(opts, "audiences")
+		opts["service"] = aud
+		c, err := auth.GetAccessController("token", opts)
+		if err != nil {
+			return nil, fmt.Errorf("token auth for audience %q: %w", aud, err)
+		}
+		ac.controllers = append(ac.controllers, c)
+	}
+	return ac, nil
+}
+
+// A token missing only the pull on a mount source still starts the
+// upload, the mount is dropped so the client sends the blob itself
+func (ac *audienceController) Authorized(req *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	grant, err := ac.authorized(req, access...)
+	if err == nil {
+		return grant, nil
+	}
+	if rest, ok := withoutMountSource(req, access); ok {
+		if g, merr := ac.authorized(req, rest...); merr == nil {
+			// Distribution parsed the form already, the copy it hands us shares it
+			req.Form.Del("mount")
+			req.Form.Del("from")
+			dropMount(req)
+			return g, nil
+		}
+	}
+	return nil, err
+}
+
+func (ac *audienceController) authorized(req *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	grant, err := ac.controllers[0].Authorized(req, access...)
+	if err == nil {
+		return grant, nil
+	}
+	for _, c := range ac.controllers[1:] {
+		if g, cerr := c.Authorized(req, access...); cerr == nil {
+			return g, nil
+		}
+	}
+	return nil, err
+}
+
+// Access minus the pull distribution adds for a mount source, unless the
+// source is the pushed repo itself
+func withoutMountSource(req *http.Request, access []auth.Access) ([]auth.Access, bool) {
+	if req.Method != http.MethodPost || req.FormValue("mount") == "" {
+		return nil, false
+	}
+	from := req.FormValue("from")
+	if from == "" || slices.ContainsFunc(access, func(a auth.Access) bool { return a.Name == from && a.Action == "push" }) {
+		return nil, false
+	}
+	rest := slices.DeleteFunc(slices.Clone(access), func(a auth.Access) bool {
+		return a.Type == "repository" && a.Name == from && a.Action == "pull"
+	})
+	return rest, len(rest) < len(access)
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	distauth "github.com/distribution/distribution/v3/registry/auth"
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/settings"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+func TestAudienceController(t *testing.T) {
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	res := settings.NewResolver(store, &v1.Settings{})
+	dir := t.TempDir()
+	ts, err := auth.NewTokenService(dir, "distroface", []string{auth.RegistryService, "registry.internal"}, res)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	foreign, err := auth.NewTokenService(dir, "distroface", []string{"registry.elsewhere"}, res)
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+
+	cfg := BuildConfig(t.TempDir(), nil, ts.CertPath(), ts.Audiences(), "127.0.0.1", "5000")
+	ac, err := distauth.GetAccessController(cfg.Auth.Type(), cfg.Auth.Parameters())
+	if err != nil {
+		t.Fatalf("GetAccessController: %v", err)
+	}
+
+	pull := distauth.Access{Resource: distauth.Resource{Type: "repository", Name: "alice/app"}, Action: "pull"}
+	grant := []*auth.ResourceActions{{Type: "repository", Name: "alice/app", Actions: []string{"pull"}}}
+	authorize := func(tok string) error {
+		req := httptest.NewRequest(http.MethodGet, "/v2/alice/app/tags/list", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		_, err := ac.Authorized(req, pull)
+		return err
+	}
+
+	for _, aud := range ts.Audiences() {
+		tok, err := ts.SignTokenFor(aud, "alice", grant)
+		if err != nil {
+			t.Fatalf("SignTokenFor %s: %v", aud, err)
+		}
+		if err := authorize(tok); err != nil {
+			t.Fatalf("token for %s refused: %v", aud, err)
+		}
+	}
+
+	tok, err := foreign.SignToken("alice", grant)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	err = authorize(tok)
+	challenge, ok := err.(distauth.Challenge)
+	if !ok {
+		t.Fatalf("foreign audience token: err = %v, want a challenge", err)
+	}
+	rec := httptest.NewRecorder()
+	challenge.SetHeaders(httptest.NewRequest(http.MethodGet, "/v2/", nil), rec)
+	if got := rec.Header().Get("WWW-Authenticate"); !strings.Contains(got, `service="`+auth.RegistryService+`"`) {
+		t.Fatalf("challenge = %q", got)
+	}
+
+	// Mounting from a repo the token cannot pull degrades to an upload
+	push := distauth.Access{Resource: distauth.Resource{Type: "repository", Name: "alice/app"}, Action: "push"}
+	source := distauth.Access{Resource: distauth.Resource{Type: "repository", Name: "bob/base"}, Action: "pull"}
+	mount := func(actions ...string) (*http.Request, error) {
+		tok, err := ts.SignToken("alice", []*auth.ResourceActions{{Type: "repository", Name: "alice/app", Actions: actions}})
+		if err != nil {
+			t.Fatalf("SignToken: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/v2/alice/app/blobs/uploads/?mount=sha256:abc&from=bob/base", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		_ = req.FormValue("from") // Distribution reads it building the access list
+		_, err = ac.Authorized(req.WithContext(req.Context()), pull, push, source)
+		return req, err
+	}
+	req, err := mount("pull", "push")
+	if err != nil {
+		t.Fatalf("push token without source pull refused: %v", err)
+	}
+	if req.FormValue("mount") != "" || req.FormValue("from") != "" {
+		t.Errorf("mount kept after the source was refused: %v", req.Form)
+	}
+	if _, err := mount("pull"); err == nil {
+		t.Error("pull only token started an upload")
+	}
+}
@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+// Reports whether dir lives on a filesystem that folds case, like NTFS
+// and default APFS. A probe file is created and looked up in upper case
+func CaseInsensitiveFS(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, ".case-probe-*")
+	if err != nil {
+		return false, fmt.Errorf("probing filesystem case: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(name), strings.ToUpper(filepath.Base(name))))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	default:
+		return false, fmt.Errorf("probing filesystem case: %w", err)
+	}
+}
+
+// Refuses tags that differ from an existing one only by case, storage
+// that folds case would keep them in one directory. Must be called before
+// handlers.NewApp
+func RegisterCaseInsensitive() {
+	listenerDeps.caseInsensitive = true
+}
+
+// Existing tag a new one would share a directory with, empty when none
+func caseCollision(existing []string, tag string) string {
+	for _, t := range existing {
+		if t != tag && strings.EqualFold(t, tag) {
+			return t
+		}
+	}
+	return ""
+}
+
+func checkTagCase(ctx context.Context, tags distribution.TagService, tag string) error {
+	if tags == nil || tag == "" {
+		return nil
+	}
+	// A repo without tags yet has nothing to collide with
+	existing, _ := tags.All(ctx)
+	if other := caseCollision(existing, tag); other != "" {
+		return errcode.ErrorCodeTagInvalid.WithMessage(fmt.Sprintf("tag %q differs from existing tag %q only by case, which this server's storage cannot keep apart", tag, other))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestCaseInsensitiveFS(t *testing.T) {
+	dir := t.TempDir()
+	folds, err := CaseInsensitiveFS(dir)
+	if err != nil {
+		t.Fatalf("CaseInsensitiveFS: %v", err)
+	}
+	if runtime.GOOS == "linux" && folds {
+		t.Fatal("linux temp dir reported as case insensitive")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("probe left %d files behind", len(entries))
+	}
+	if _, err := CaseInsensitiveFS(dir + "/missing"); err == nil {
+		t.Fatal("probing a missing dir should fail")
+	}
+}
+
+func TestCaseCollision(t *testing.T) {
+	existing := []string{"latest", "v1.0", "RC-1"}
+	cases := []struct {
+		tag, want string
+	}{
+		{"Latest", "latest"},
+		{"rc-1", "RC-1"},
+		{"latest", ""},
+		{"v1.1", ""},
+		{"V1.0", "v1.0"},
+	}
+	for _, c := range cases {
+		if got := caseCollision(existing, c.tag); got != c.want {
+			t.Errorf("caseCollision(%q) = %q, want %q", c.tag, got, c.want)
+		}
+	}
+}
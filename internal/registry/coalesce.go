@@ -0,0 +1,259 @@
+package registry
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+var (
+	blobHeadRe    = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[a-f0-9]{64})$`)
+	blobSessionRe = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/?([^/]*)$`)
+)
+
+// Claims idle longer than this stop holding back other pushers, so an
+// abandoned push costs the next client one wait at most
+const uploadClaimIdle = 2 * time.Minute
+
+// First pusher to miss a blob, others wait for its upload to land
+type uploadClaim struct {
+	claimant string
+	expires  time.Time
+	done     chan struct{}
+}
+
+// Full registry token check, signature, issuer, audience and lifetime
+type TokenVerifier interface {
+	VerifyToken(raw string) (*auth.ClaimSet, error)
+}
+
+// Digest keyed coordination of concurrent layer pushes. The first pusher
+// whose HEAD misses claims the blob, other pushers HEADing the same blob
+// in the same repo wait until the claimant's PUT lands and are answered
+// from the finished blob instead of uploading it again. Only callers
+// holding a push grant on the repo take part, the rest pass straight on
+// to distribution and its auth.
+type UploadCoalescer struct {
+	next     http.Handler
+	verifier TokenVerifier
+	log      *logger.Logger
+
+	mu     sync.Mutex
+	claims map[string]*uploadClaim // repo@digest
+}
+
+// Wraps the registry so concurrent pushes of one layer upload it once
+func CoalesceUploads(next http.Handler, verifier TokenVerifier, log *logger.Logger) *UploadCoalescer {
+	return &UploadCoalescer{next: next, verifier: verifier, log: log, claims: make(map[string]*uploadClaim)}
+}
+
+// Open blob claims, a count that only grows means claims are never released
+func (c *UploadCoalescer) Claims() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.claims)
+}
+
+// Status capture, blob HEAD and upload responses carry no streamed body
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (c *UploadCoalescer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+		if m := blobHeadRe.FindStringSubmatch(r.URL.Path); m != nil {
+			if claimant := c.pusher(r, m[1]); claimant != "" {
+				c.serveHead(w, r, m[1]+"@"+m[2], claimant)
+				return
+			}
+		}
+	case http.MethodPost, http.MethodPatch, http.MethodPut:
+		if m := blobSessionRe.FindStringSubmatch(r.URL.Path); m != nil {
+			if claimant := c.pusher(r, m[1]); claimant != "" {
+				c.serveUpload(w, r, m[1], claimant)
+				return
+			}
+		}
+	}
+	c.next.ServeHTTP(w, r)
+}
+
+// Subject of a verified bearer granting push on exactly repo, empty for
+// everyone else. The coalescer runs ahead of distribution's auth, so
+// nobody it would refuse may claim a blob or wait on one
+func (c *UploadCoalescer) pusher(r *http.Request, repo string) string {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || c.verifier == nil {
+		return ""
+	}
+	claims, err := c.verifier.VerifyToken(strings.TrimSpace(raw))
+	if err != nil || claims.Subject == "" {
+		return ""
+	}
+	for _, ra := range claims.Access {
+		if ra.Type == "repository" && ra.Name == repo && slices.Contains(ra.Actions, "push") {
+			return claims.Subject
+		}
+	}
+	return ""
+}
+
+func (c *UploadCoalescer) serveHead(w http.ResponseWriter, r *http.Request, key, claimant string) {
+	var claim *uploadClaim
+	for {
+		held, mine := c.acquire(key, claimant)
+		if mine {
+			claim = held
+			break
+		}
+		c.wait(r, key, held)
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+
+	sw := &statusWriter{ResponseWriter: w}
+	c.next.ServeHTTP(sw, r)
+
+	// A miss keeps the claim for the upload that follows, a hit ends it
+	if sw.status != http.StatusNotFound {
+		c.mu.Lock()
+		c.release(key, claim)
+		c.mu.Unlock()
+	}
+}
+
+// Takes or refreshes the claim, or returns another client's live one
+func (c *UploadCoalescer) acquire(key, claimant string) (*uploadClaim, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if claim := c.claims[key]; claim != nil {
+		if now.Before(claim.expires) && claim.claimant != claimant {
+			return claim, false
+		}
+		if now.Before(claim.expires) {
+			claim.expires = now.Add(uploadClaimIdle)
+			return claim, true
+		}
+		c.release(key, claim)
+	}
+	c.sweep(now)
+	claim := &uploadClaim{claimant: claimant, expires: now.Add(uploadClaimIdle), done: make(chan struct{})}
+	c.claims[key] = claim
+	return claim, true
+}
+
+// Drops claims nobody came back for, caller holds mu
+func (c *UploadCoalescer) sweep(now time.Time) {
+	for key, claim := range c.claims {
+		if now.After(claim.expires) {
+			c.release(key, claim)
+		}
+	}
+}
+
+// Blocks until the claim resolves, goes idle, or the waiter hangs up
+func (c *UploadCoalescer) wait(r *http.Request, key string, claim *uploadClaim) {
+	c.log.Debug("registry: %s is being pushed by %s, waiting", key, claim.claimant)
+	for {
+		c.mu.Lock()
+		remaining := time.Until(claim.expires)
+		if remaining <= 0 {
+			c.release(key, claim)
+		}
+		c.mu.Unlock()
+		if remaining <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-claim.done:
+			timer.Stop()
+			return
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			// Loop rechecks, upload activity may have pushed expiry out
+		}
+	}
+}
+
+// Caller holds mu
+func (c *UploadCoalescer) release(key string, claim *uploadClaim) {
+	if c.claims[key] != claim {
+		return
+	}
+	delete(c.claims, key)
+	close(claim.done)
+}
+
+// Upload activity keeps the claimant's claims in that repo alive, the
+// final PUT names the digest and wakes its waiters either way. A mount
+// that landed finishes the blob too, one that fell back to an upload
+// keeps the claim for the PUT to come
+func (c *UploadCoalescer) serveUpload(w http.ResponseWriter, r *http.Request, repo, claimant string) {
+	c.touch(repo, claimant)
+
+	sw := &statusWriter{ResponseWriter: w}
+	c.next.ServeHTTP(sw, r)
+	c.touch(repo, claimant)
+
+	var digest string
+	switch r.Method {
+	case http.MethodPut:
+		digest = r.URL.Query().Get("digest")
+	case http.MethodPost:
+		if sw.status == http.StatusCreated {
+			digest = r.URL.Query().Get("mount")
+		}
+	}
+	if digest == "" {
+		return
+	}
+	key := repo + "@" + digest
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if claim := c.claims[key]; claim != nil && claim.claimant == claimant {
+		if sw.status != http.StatusCreated {
+			c.log.Debug("registry: push of %s by %s failed with %d, releasing waiters", key, claimant, sw.status)
+		}
+		c.release(key, claim)
+	}
+}
+
+func (c *UploadCoalescer) touch(repo, claimant string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := repo + "@"
+	expires := time.Now().Add(uploadClaimIdle)
+	for key, claim := range c.claims {
+		if claim.claimant == claimant && strings.HasPrefix(key, prefix) {
+			claim.expires = expires
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+const coalesceBlob = "sha256:" + "ab12ab12ab12ab12ab12ab12ab12ab12ab12ab12ab12ab12ab12ab12ab12ab12"
+
+// Bearers read "<subject>:<actions>", granted on acme/app
+type grantEcho struct{}
+
+func (grantEcho) VerifyToken(raw string) (*auth.ClaimSet, error) {
+	sub, actions, ok := strings.Cut(raw, ":")
+	if !ok {
+		return nil, errors.New("bad token")
+	}
+	return &auth.ClaimSet{Subject: sub, Access: []*auth.ResourceActions{
+		{Type: "repository", Name: "acme/app", Actions: strings.Split(actions, ",")},
+	}}, nil
+}
+
+// Registry stand in, HEAD finds a blob once a PUT stored it
+type blobBackend struct {
+	mu     sync.Mutex
+	stored map[string]bool
+	heads  int
+}
+
+func (b *blobBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch r.Method {
+	case http.MethodHead:
+		b.heads++
+		if b.stored[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]] {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case http.MethodPut:
+		b.stored[r.URL.Query().Get("digest")] = true
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func newTestCoalescer() (*UploadCoalescer, *blobBackend) {
+	backend := &blobBackend{stored: map[string]bool{}}
+	return CoalesceUploads(backend, grantEcho{}, logger.NewWithConfig(&logger.Config{Enabled: false})), backend
+}
+
+func coalesceDo(h http.Handler, method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+// A second pusher waits for the first one's upload and finds the blob
+func TestCoalesceUploadsWaitsForClaimant(t *testing.T) {
+	c, _ := newTestCoalescer()
+	head := "/v2/acme/app/blobs/" + coalesceBlob
+
+	if code := coalesceDo(c, http.MethodHead, head, "alice:pull,push"); code != http.StatusNotFound {
+		t.Fatalf("first HEAD = %d, want a miss", code)
+	}
+	if c.Claims() != 1 {
+		t.Fatalf("claims = %d after a miss, want 1", c.Claims())
+	}
+
+	done := make(chan int)
+	go func() { done <- coalesceDo(c, http.MethodHead, head, "bob:pull,push") }()
+	select {
+	case code := <-done:
+		t.Fatalf("second pusher answered %d before the upload landed", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The claimant comes back to its own claim without waiting
+	if code := coalesceDo(c, http.MethodHead, head, "alice:pull,push"); code != http.StatusNotFound {
+		t.Fatalf("claimant HEAD = %d", code)
+	}
+	coalesceDo(c, http.MethodPost, "/v2/acme/app/blobs/uploads/", "alice:pull,push")
+	coalesceDo(c, http.MethodPut, "/v2/acme/app/blobs/uploads/u1?digest="+coalesceBlob, "alice:pull,push")
+
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Fatalf("second pusher = %d, want the finished blob", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second pusher still waiting after the upload landed")
+	}
+	if c.Claims() != 0 {
+		t.Fatalf("claims = %d after the upload, want 0", c.Claims())
+	}
+}
+
+// Callers without a verified push grant neither claim nor wait, whatever
+// address they send from
+func TestCoalesceUploadsRequiresPushGrant(t *testing.T) {
+	c, backend := newTestCoalescer()
+	head := "/v2/acme/app/blobs/" + coalesceBlob
+
+	for _, token := range []string{"", "garbage", "reader:pull", ":pull,push"} {
+		if code := coalesceDo(c, http.MethodHead, head, token); code != http.StatusNotFound {
+			t.Fatalf("HEAD with %q = %d", token, code)
+		}
+		if c.Claims() != 0 {
+			t.Fatalf("HEAD with %q took a claim", token)
+		}
+	}
+
+	if coalesceDo(c, http.MethodHead, head, "alice:pull,push"); c.Claims() != 1 {
+		t.Fatal("pusher took no claim")
+	}
+	before := backend.heads
+	for _, token := range []string{"", "reader:pull"} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			coalesceDo(c, http.MethodHead, head, token)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("HEAD with %q waited on alice's claim", token)
+		}
+	}
+	if backend.heads != before+2 {
+		t.Fatalf("registry saw %d HEADs, want 2", backend.heads-before)
+	}
+
+	// Someone else's PUT of the digest does not resolve alice's claim
+	coalesceDo(c, http.MethodPut, "/v2/acme/app/blobs/uploads/u2?digest="+coalesceBlob, "reader:pull")
+	if c.Claims() != 1 {
+		t.Fatal("a caller without push released the claim")
+	}
+}
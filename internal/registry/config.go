@@ -7,19 +7,30 @@ import (
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/google/uuid"
 
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry/packed"
+
 	_ "github.com/distribution/distribution/v3/registry/auth/token"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
 )
 
-// BuildConfig creates a Distribution v3 configuration for the embedded registry
-func BuildConfig(storagePath, certPath, host, port string) *configuration.Configuration {
+// BuildConfig creates a Distribution v3 configuration for the embedded registry.
+// A non nil packedLinks keeps link files in the database instead of on disk.
+// Tokens for any of audiences are accepted, the first is the challenged service
+func BuildConfig(storagePath string, packedLinks *stores.Store, certPath string, audiences []string, host, port string) *configuration.Configuration {
 	addr := fmt.Sprintf("%s:%s", host, port)
 	realm := fmt.Sprintf("http://%s/auth/token", addr)
 
+	driver := "filesystem"
+	if packedLinks != nil {
+		packed.Register(packedLinks)
+		driver = packed.DriverName
+	}
+
 	cfg := &configuration.Configuration{
 		Version: "0.1",
 		Storage: configuration.Storage{
-			"filesystem": configuration.Parameters{
+			driver: configuration.Parameters{
 				"rootdirectory": storagePath,
 			},
 			"delete": configuration.Parameters{
@@ -30,10 +41,10 @@ func BuildConfig(storagePath, certPath, host, port string) *configuration.Config
 			},
 		},
 		Auth: configuration.Auth{
-			"token": configuration.Parameters{
+			audienceAuth: configuration.Parameters{
 				"realm":          realm,
 				"issuer":         "distroface",
-				"service":        "distroface-registry",
+				"audiences":      audiences,
 				"rootcertbundle": certPath,
 			},
 		},
@@ -50,6 +61,9 @@ func BuildConfig(storagePath, certPath, host, port string) *configuration.Config
 			"repository": {
 				{Name: "distroface"},
 			},
+			"storage": {
+				{Name: healthMiddleware},
+			},
 		},
 	}
 
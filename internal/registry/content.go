@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func (r *RegistryAccess) repository(ctx context.Context, namespace, name string) (distribution.Repository, error) {
+	repoRef, err := reference.WithName(namespace + "/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository name: %w", err)
+	}
+	return r.registry.Repository(ctx, repoRef)
+}
+
+// Manifest descriptor behind a tag or digest reference
+func (r *RegistryAccess) ResolveManifest(ctx context.Context, namespace, name, ref string) (ocispec.Descriptor, error) {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		desc, err := repo.Tags(ctx).Get(ctx, ref)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("tag not found: %w", err)
+		}
+		dgst = desc.Digest
+	}
+	mediaType, payload, err := r.GetManifest(ctx, namespace, name, dgst)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}, nil
+}
+
+// Raw manifest bytes as pushed
+func (r *RegistryAccess) GetManifest(ctx context.Context, namespace, name string, dgst digest.Digest) (string, []byte, error) {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return "", nil, err
+	}
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("accessing manifest service: %w", err)
+	}
+	m, err := ms.Get(ctx, dgst)
+	if err != nil {
+		return "", nil, fmt.Errorf("manifest %s: %w", dgst, err)
+	}
+	return m.Payload()
+}
+
+// Whole blob, only meant for small documents like configs and payloads
+func (r *RegistryAccess) GetBlob(ctx context.Context, namespace, name string, dgst digest.Digest) ([]byte, error) {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Blobs(ctx).Get(ctx, dgst)
+}
+
+// Writes blobs and a manifest or index straight to storage, optionally
+// tagged. An index's children must already be stored in the repo. Nothing
+// passes through the http app, so no push events or webhooks fire
+func (r *RegistryAccess) PutManifest(ctx context.Context, namespace, name string, blobs map[string][]byte, manifest []byte, tag string) (ocispec.Descriptor, error) {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	for mediaType, b := range blobs {
+		if _, err := repo.Blobs(ctx).Put(ctx, mediaType, b); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("writing blob: %w", err)
+		}
+	}
+	m, desc, err := distribution.UnmarshalManifest(manifestMediaType(manifest), manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("accessing manifest service: %w", err)
+	}
+	if _, err := ms.Put(ctx, m); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("writing manifest: %w", err)
+	}
+	if tag != "" {
+		if err := repo.Tags(ctx).Tag(ctx, tag, desc); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("tagging manifest: %w", err)
+		}
+	}
+	return desc, nil
+}
+
+// Points tag at an existing manifest
+func (r *RegistryAccess) TagManifest(ctx context.Context, namespace, name, tag string, desc ocispec.Descriptor) error {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	return repo.Tags(ctx).Tag(ctx, tag, desc)
+}
+
+// Removes a tag, the manifest stays until garbage collection
+func (r *RegistryAccess) UntagManifest(ctx context.Context, namespace, name, tag string) error {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	return repo.Tags(ctx).Untag(ctx, tag)
+}
+
+// Untags like a /v2 tag delete would, dropping the tag's records and
+// firing the same webhooks and audit events
+func (r *RegistryAccess) DeleteTag(ctx context.Context, namespace, name, tag string) error {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	if err := repo.Tags(ctx).Untag(ctx, tag); err != nil {
+		return err
+	}
+	if listenerDeps.store != nil {
+		obs := &observer{store: listenerDeps.store, log: listenerDeps.log, dispatcher: listenerDeps.dispatcher, recorder: listenerDeps.recorder}
+		obs.tagDeleted(ctx, repo.Named(), tag)
+	}
+	return nil
+}
+
+// Media type a manifest payload declares. OCI leaves the field optional,
+// without it a manifests list marks an index
+func manifestMediaType(payload []byte) string {
+	var probe struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(payload, &probe); err == nil {
+		if probe.MediaType != "" {
+			return probe.MediaType
+		}
+		if probe.Manifests != nil {
+			return ocispec.MediaTypeImageIndex
+		}
+	}
+	return ocispec.MediaTypeImageManifest
+}
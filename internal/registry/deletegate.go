@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/nickheyer/distroface/internal/policy"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/utils"
+)
+
+// Parks deletes above the dual approval size until another admin repeats them
+type DeleteApprover interface {
+	CheckImageDelete(ctx context.Context, username, target string, size func() (int64, error)) (*time.Time, error)
+}
+
+// Holds manifest and blob deletes to the two admin rule the rpc deletes
+// follow. Anonymous deletes pass so distribution can challenge them
+func GateDeletes(next http.Handler, approver DeleteApprover, access *RegistryAccess, verifier SubjectVerifier, log *logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := contentPathRe.FindStringSubmatch(r.URL.Path)
+		if r.Method != http.MethodDelete || m == nil || approver == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		namespace, name := utils.SplitRepoName(m[1])
+		username := bearerSubject(r, verifier)
+		if namespace == "" || name == "" || username == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		kind, ref := m[2], m[3]
+		target := m[1] + ":" + ref
+		if _, err := digest.Parse(ref); err == nil {
+			target = m[1] + "@" + ref
+		}
+		expires, err := approver.CheckImageDelete(r.Context(), username, target, func() (int64, error) {
+			if kind == "blobs" {
+				return access.blobSize(digest.Digest(ref)), nil
+			}
+			size, err := access.ManifestSize(r.Context(), namespace, name, ref)
+			if err != nil {
+				return 0, nil // Unknown manifests are reported by distribution
+			}
+			return size, nil
+		})
+		switch {
+		case errors.Is(err, policy.ErrSecondAdmin), errors.Is(err, policy.ErrApprovalClaimed):
+			_ = errcode.ServeJSON(w, errcode.ErrorCodeDenied.WithMessage(err.Error()))
+			return
+		case err != nil:
+			log.Error("registry: checking delete approval of %s: %v", target, err)
+			_ = errcode.ServeJSON(w, errcode.ErrorCodeUnknown.WithMessage("checking delete approval failed"))
+			return
+		case expires != nil:
+			log.Info("registry: delete of %s by %s awaits a second admin", target, username)
+			_ = errcode.ServeJSON(w, errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf(
+				"deleting %s awaits a second admin, another admin repeating the delete before %s completes it",
+				target, expires.Format(time.RFC3339))))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
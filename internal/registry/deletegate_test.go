@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/nickheyer/distroface/internal/policy"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+// Parks every delete until approve is set, recording what it was asked
+type fakeApprover struct {
+	approve bool
+	err     error
+	targets []string
+	sizes   []int64
+}
+
+func (f *fakeApprover) CheckImageDelete(ctx context.Context, username, target string, size func() (int64, error)) (*time.Time, error) {
+	n, err := size()
+	if err != nil {
+		return nil, err
+	}
+	f.targets = append(f.targets, username+" "+target)
+	f.sizes = append(f.sizes, n)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.approve {
+		return nil, nil
+	}
+	expires := time.Now().Add(time.Hour)
+	return &expires, nil
+}
+
+func TestGateDeletes(t *testing.T) {
+	ctx := context.Background()
+	access, err := NewRegistryAccess(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, layer := []byte(`{"architecture":"amd64","os":"linux"}`), []byte("layer bytes")
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    []ocispec.Descriptor{{MediaType: ocispec.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := access.PutManifest(ctx, "acme", "app", map[string][]byte{
+		ocispec.MediaTypeImageConfig: config,
+		ocispec.MediaTypeImageLayer:  layer,
+	}, manifest, "1.0")
+	if err != nil {
+		t.Fatalf("PutManifest: %v", err)
+	}
+	imageSize := int64(len(config) + len(layer) + len(manifest))
+
+	approver := &fakeApprover{}
+	reached := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+		w.WriteHeader(http.StatusAccepted)
+	})
+	h := GateDeletes(next, approver, access, subjectEcho{}, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// Parked deletes answer DENIED and never reach distribution
+	w := do(http.MethodDelete, "/v2/acme/app/manifests/1.0", "alice")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"DENIED"`) || !strings.Contains(w.Body.String(), "second admin") {
+		t.Fatalf("parked delete = %d %s", w.Code, w.Body.String())
+	}
+	if reached != 0 {
+		t.Fatal("parked delete reached the registry")
+	}
+	if got := approver.targets[0]; got != "alice acme/app:1.0" || approver.sizes[0] != imageSize {
+		t.Fatalf("asked about %q at %d bytes, want acme/app:1.0 at %d", got, approver.sizes[0], imageSize)
+	}
+
+	do(http.MethodDelete, "/v2/acme/app/manifests/"+desc.Digest.String(), "alice")
+	if got := approver.targets[1]; got != "alice acme/app@"+desc.Digest.String() || approver.sizes[1] != imageSize {
+		t.Fatalf("digest delete asked about %q at %d bytes", got, approver.sizes[1])
+	}
+	do(http.MethodDelete, "/v2/acme/app/blobs/"+digest.FromBytes(layer).String(), "alice")
+	if approver.sizes[2] != int64(len(layer)) {
+		t.Fatalf("blob delete measured %d bytes, want %d", approver.sizes[2], len(layer))
+	}
+
+	// Refusals from the rule read as DENIED too
+	approver.err = fmt.Errorf("%w: deleting acme/app:1.0 needs another repositories admin", policy.ErrSecondAdmin)
+	if w := do(http.MethodDelete, "/v2/acme/app/manifests/1.0", "carol"); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "another repositories admin") {
+		t.Fatalf("refused approval = %d %s", w.Code, w.Body.String())
+	}
+	approver.err = nil
+
+	// Approved, anonymous and non delete requests pass through
+	approver.approve = true
+	asked := len(approver.targets)
+	for _, c := range []struct{ method, path, token string }{
+		{http.MethodDelete, "/v2/acme/app/manifests/1.0", "bob"},
+		{http.MethodDelete, "/v2/acme/app/manifests/1.0", ""},
+		{http.MethodGet, "/v2/acme/app/manifests/1.0", "alice"},
+		{http.MethodDelete, "/v2/acme/app/manifests/unknown", "bob"},
+	} {
+		if w := do(c.method, c.path, c.token); w.Code != http.StatusAccepted {
+			t.Errorf("%s %s as %q = %d, want it passed on", c.method, c.path, c.token, w.Code)
+		}
+	}
+	if reached != 4 || len(approver.targets) != asked+2 {
+		t.Fatalf("reached %d, asked %d more times", reached, len(approver.targets)-asked)
+	}
+}
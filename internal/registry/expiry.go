@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+// Image config label that expires a pushed tag, a duration like 14d
+const ExpiresLabel = "distroface.expires"
+
+// Expired tags removed per store query
+const expiryBatch = 200
+
+// TagExpirer removes image tags whose expiry passed. Untagged manifests
+// and layers are left for garbage collection to reclaim
+type TagExpirer struct {
+	store  *stores.Store
+	access *RegistryAccess
+	log    *logger.Logger
+}
+
+func NewTagExpirer(store *stores.Store, access *RegistryAccess, log *logger.Logger) *TagExpirer {
+	return &TagExpirer{store: store, access: access, log: log}
+}
+
+// Schedule removes expired tags every minute
+func (e *TagExpirer) Schedule(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n, err := e.Expire(ctx); err != nil {
+					e.log.Error("Tag expiry: %v", err)
+				} else if n > 0 {
+					e.log.Info("Tag expiry removed %d tags", n)
+				}
+			}
+		}
+	}()
+}
+
+// Removes every tag whose expiry passed, returning how many went. Tags
+// already gone just lose their expiry, ones that fail wait for the next run
+func (e *TagExpirer) Expire(ctx context.Context) (int, error) {
+	// Same webhooks and audit rows as an untag over /v2
+	obs := &observer{store: e.store, log: e.log, dispatcher: listenerDeps.dispatcher, recorder: listenerDeps.recorder}
+	removed := 0
+	for {
+		expired, err := e.store.ListExpiredTags(ctx, time.Now().UTC(), expiryBatch)
+		if err != nil {
+			return removed, err
+		}
+		failed := false
+		for _, x := range expired {
+			repo, err := reference.WithName(x.Namespace + "/" + x.Name)
+			if err == nil {
+				err = e.access.UntagManifest(ctx, x.Namespace, x.Name, x.Tag)
+			}
+			var gone driver.PathNotFoundError
+			switch {
+			case err == nil:
+				obs.tagDeleted(ctx, repo, x.Tag)
+				e.log.Info("Removed expired tag %s/%s:%s", x.Namespace, x.Name, x.Tag)
+				removed++
+			case errors.As(err, &gone):
+				if err := e.store.DeleteTagExpiry(ctx, x.Namespace, x.Name, x.Tag); err != nil {
+					return removed, err
+				}
+			default:
+				e.log.Error("Removing expired tag %s/%s:%s: %v", x.Namespace, x.Name, x.Tag, err)
+				failed = true
+			}
+		}
+		if failed || len(expired) < expiryBatch {
+			return removed, nil
+		}
+	}
+}
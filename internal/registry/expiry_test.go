@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+func writeTag(t *testing.T, root, repo, tag, hex string) {
+	t.Helper()
+	dir := filepath.Join(root, "docker", "registry", "v2", "repositories", filepath.FromSlash(repo), "_manifests", "tags", tag, "current")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "link"), []byte("sha256:"+hex), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Due tags are untagged with their push and pull records, tags already gone just
+// lose the expiry and later ones stay put
+func TestTagExpirer(t *testing.T) {
+	root := t.TempDir()
+	manifest := strings.Repeat("c", 64)
+	writeTag(t, root, "alice/app", "pr-1", manifest)
+	writeTag(t, root, "alice/app", "pr-2", manifest)
+
+	access, err := NewRegistryAccess(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	ctx := context.Background()
+	past, future := time.Now().UTC().Add(-time.Minute), time.Now().UTC().Add(time.Hour)
+	for tag, at := range map[string]time.Time{"pr-1": past, "pr-2": future, "pr-gone": past} {
+		if err := store.SetTagExpiry(ctx, &storage.TagExpiry{Namespace: "alice", Name: "app", Tag: tag, ExpiresAt: at}); err != nil {
+			t.Fatalf("SetTagExpiry(%s): %v", tag, err)
+		}
+	}
+	if err := store.RecordTagPush(ctx, &storage.TagPush{Namespace: "alice", Name: "app", Tag: "pr-1", Digest: "sha256:" + manifest, PushedAt: past}); err != nil {
+		t.Fatalf("RecordTagPush: %v", err)
+	}
+	for range 2 {
+		if err := store.RecordTagPull(ctx, "alice", "app", "pr-1", past); err != nil {
+			t.Fatalf("RecordTagPull: %v", err)
+		}
+	}
+	if pulls, _ := store.ListTagPulls(ctx, "alice", "app"); pulls["pr-1"] == nil || pulls["pr-1"].PullCount != 2 {
+		t.Fatalf("pull record = %+v, want two pulls of pr-1", pulls["pr-1"])
+	}
+
+	expirer := NewTagExpirer(store, access, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	if n, err := expirer.Expire(ctx); err != nil || n != 1 {
+		t.Fatalf("Expire = %d, %v", n, err)
+	}
+
+	tags, err := access.ListTags(ctx, "alice", "app")
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if len(tags) != 1 || tags[0].Name != "pr-2" {
+		t.Fatalf("tags left = %v, want only pr-2", tags)
+	}
+	left, err := store.ListTagExpiries(ctx, "alice", "app")
+	if err != nil {
+		t.Fatalf("ListTagExpiries: %v", err)
+	}
+	if len(left) != 1 || left["pr-2"] == nil {
+		t.Fatalf("expiries left = %v, want only pr-2", left)
+	}
+	if pushes, _ := store.ListTagPushes(ctx, "alice", "app"); pushes["pr-1"] != nil {
+		t.Fatal("push record of the expired tag survived")
+	}
+	if pulls, _ := store.ListTagPulls(ctx, "alice", "app"); pulls["pr-1"] != nil {
+		t.Fatal("pull record of the expired tag survived")
+	}
+}
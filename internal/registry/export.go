@@ -0,0 +1,47 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Tags of a repository with the manifest each points at, and every blob
+// those manifest trees reference sorted by digest. Untagged manifests are
+// left out, nothing a pull by tag needs lives only there
+func (r *RegistryAccess) TaggedContent(ctx context.Context, namespace, name string) (map[string]string, []BlobShare, error) {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("accessing manifest service: %w", err)
+	}
+	tagService := repo.Tags(ctx)
+	all, err := tagService.All(ctx)
+	if err != nil {
+		// Distribution reports a repo nobody pushed to as unknown
+		return map[string]string{}, nil, nil
+	}
+
+	tags := make(map[string]string, len(all))
+	blobs := map[digest.Digest]string{}
+	for _, tag := range all {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			continue
+		}
+		tags[tag] = desc.Digest.String()
+		collectManifestBlobs(ctx, manifests, desc.Digest, desc.MediaType, blobs)
+	}
+
+	out := make([]BlobShare, 0, len(blobs))
+	for d, mediaType := range blobs {
+		out = append(out, BlobShare{Digest: d.String(), Size: r.blobSize(d), MediaType: mediaType})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Digest < out[j].Digest })
+	return tags, out, nil
+}
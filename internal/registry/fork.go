@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/nickheyer/distroface/internal/registry/packed"
+)
+
+// Forks src into dst by copying only the link files under _layers and
+// _manifests. Blobs are content addressed and shared, so the fork costs a
+// few kilobytes and later pushes or deletes on either side stay private.
+// dst is replaced wholesale, callers own the name before forking
+func (r *RegistryAccess) ForkRepository(src, dst string) (int, error) {
+	root := filepath.Join(r.storagePath, "docker", "registry", "v2", "repositories")
+	srcDir := filepath.Join(root, filepath.FromSlash(src))
+	dstDir := filepath.Join(root, filepath.FromSlash(dst))
+
+	if err := os.RemoveAll(dstDir); err != nil {
+		return 0, err
+	}
+	r.dropRepoLinks(dst)
+	if err := r.dropPacked(dst); err != nil {
+		return 0, err
+	}
+	if r.packed != nil {
+		return r.forkPacked(src, dst)
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "_manifests")); errors.Is(err, fs.ErrNotExist) {
+		return 0, nil // Nothing pushed yet, the fork starts empty too
+	}
+
+	links := 0
+	for _, sub := range []string{"_layers", "_manifests"} {
+		from := filepath.Join(srcDir, sub)
+		err := filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			rel, _ := filepath.Rel(srcDir, path)
+			target := filepath.Join(dstDir, rel)
+			if d.IsDir() {
+				return os.MkdirAll(target, 0755)
+			}
+			if d.Name() != "link" {
+				return nil
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			links++
+			return os.WriteFile(target, b, 0644)
+		})
+		if err != nil {
+			_ = os.RemoveAll(dstDir)
+			return 0, fmt.Errorf("copying %s links: %w", sub, err)
+		}
+	}
+	r.copyRepoLinks(src, dst)
+	return links, nil
+}
+
+// Same fork with the links held in the store, no directories are created
+func (r *RegistryAccess) forkPacked(src, dst string) (int, error) {
+	ctx := context.Background()
+	links := 0
+	for _, sub := range []string{"_layers", "_manifests"} {
+		n, err := r.packed.CopyPackedLinks(ctx, packed.RepoPath(src)+"/"+sub, packed.RepoPath(dst)+"/"+sub)
+		if err != nil {
+			_ = r.dropPacked(dst)
+			return 0, fmt.Errorf("copying %s links: %w", sub, err)
+		}
+		links += int(n)
+	}
+	r.copyRepoLinks(src, dst)
+	return links, nil
+}
@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/nickheyer/distroface/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Deletes the per platform manifests a deleted index listed, unless a tag
+// or another index in the repo still points at them. Kept ones are left
+// for GC, so a failed lookup never takes a manifest something still needs
+func (m *observedManifests) pruneChildren(ctx context.Context, children []digest.Digest) {
+	if len(children) == 0 {
+		return
+	}
+	listed, err := m.listedChildren(ctx)
+	if err != nil {
+		m.obs.log.Warn("listener: keeping children of deleted index in %s: %v", m.repo.Name(), err)
+		return
+	}
+	for _, child := range children {
+		if listed[child] {
+			continue
+		}
+		tags, err := m.tags.Lookup(ctx, ocispec.Descriptor{Digest: child})
+		if err != nil || len(tags) > 0 {
+			continue
+		}
+		var grandchildren []digest.Digest
+		if manifest, err := m.ManifestService.Get(ctx, child); err == nil {
+			grandchildren = utils.IndexChildren(manifest)
+		}
+		if err := m.ManifestService.Delete(ctx, child); err != nil {
+			if _, unknown := err.(distribution.ErrManifestUnknownRevision); !unknown && err != distribution.ErrBlobUnknown {
+				m.obs.log.Error("listener: failed to delete index child %s in %s: %v", child, m.repo.Name(), err)
+			}
+			continue
+		}
+		m.obs.unlinked(ctx, m.repo, child, linkManifest)
+		m.obs.manifestDeleted(ctx, m.repo, child)
+		m.pruneChildren(ctx, grandchildren)
+	}
+}
+
+// Manifests the repo's remaining indexes list
+func (m *observedManifests) listedChildren(ctx context.Context) (map[digest.Digest]bool, error) {
+	enum, ok := m.ManifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	listed := map[digest.Digest]bool{}
+	err := enum.Enumerate(ctx, func(dgst digest.Digest) error {
+		manifest, err := m.ManifestService.Get(ctx, dgst)
+		if err != nil {
+			return nil
+		}
+		for _, c := range utils.IndexChildren(manifest) {
+			listed[c] = true
+		}
+		return nil
+	})
+	return listed, err
+}
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	regstorage "github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/reference"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry/packed"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Index tags report their whole tree and deleting one takes along only
+// the platform manifests nothing else lists
+func TestImageIndex(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	access, err := NewRegistryAccess(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared := []byte("base layer shared by every platform")
+	image := func(arch, tag string) ocispec.Descriptor {
+		t.Helper()
+		config, layer := []byte(`{"architecture":"`+arch+`","os":"linux"}`), []byte(arch+" layer")
+		raw, err := json.Marshal(ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+			Layers: []ocispec.Descriptor{
+				{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(shared), Size: int64(len(shared))},
+				{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := access.PutManifest(ctx, "alice", "app", map[string][]byte{"config": config, "shared": shared, "layer": layer}, raw, tag); err != nil {
+			t.Fatalf("PutManifest %s: %v", arch, err)
+		}
+		return ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(raw), Size: int64(len(raw)),
+			Platform: &ocispec.Platform{Architecture: arch, OS: "linux"},
+		}
+	}
+	amd64, arm64, s390x := image("amd64", ""), image("arm64", ""), image("s390x", "s390x-only")
+	index := func(tag string, children ...ocispec.Descriptor) (digest.Digest, int) {
+		t.Helper()
+		// No mediaType field, the manifests list alone marks it an index
+		raw, err := json.Marshal(map[string]any{"schemaVersion": 2, "manifests": children})
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := access.PutManifest(ctx, "alice", "app", nil, raw, tag)
+		if err != nil {
+			t.Fatalf("PutManifest index: %v", err)
+		}
+		if desc.MediaType != ocispec.MediaTypeImageIndex {
+			t.Fatalf("index stored as %s", desc.MediaType)
+		}
+		return desc.Digest, len(raw)
+	}
+	multi, multiSize := index("multi", amd64, arm64, s390x)
+	index("arm", arm64)
+
+	tags, err := access.ListTags(ctx, "alice", "app")
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	var got int64
+	for _, tag := range tags {
+		if tag.Name == "multi" {
+			got = tag.SizeBytes
+		}
+	}
+	// Index, three manifests with a config and own layer each, shared layer once
+	want := int64(multiSize) + int64(len(shared))
+	for _, d := range []ocispec.Descriptor{amd64, arm64, s390x} {
+		arch := d.Platform.Architecture
+		want += d.Size + int64(len(`{"architecture":"`+arch+`","os":"linux"}`)) + int64(len(arch+" layer"))
+	}
+	if got != want {
+		t.Fatalf("multi size = %d, want %d", got, want)
+	}
+
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	reg, err := regstorage.NewRegistry(ctx, packed.New(root, nil), regstorage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, _ := reference.WithName("alice/app")
+	repo, err := reg.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	observed := &observedRepo{Repository: repo, obs: &observer{store: store, log: logger.New()}}
+	ms, err := observed.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Delete(ctx, multi); err != nil {
+		t.Fatalf("Delete index: %v", err)
+	}
+	for _, c := range []struct {
+		d    ocispec.Descriptor
+		kept bool
+	}{{amd64, false}, {arm64, true}, {s390x, true}} {
+		if ok, _ := ms.Exists(ctx, c.d.Digest); ok != c.kept {
+			t.Errorf("%s manifest exists = %v, want %v", c.d.Platform.Architecture, ok, c.kept)
+		}
+	}
+}
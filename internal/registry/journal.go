@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/journal"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/utils"
+)
+
+// Journal kinds for registry operations spanning storage and the database
+const (
+	OpManifestPush = "image.push"
+	OpRepoDelete   = "image.repo_delete"
+	OpRepoFork     = "image.repo_fork"
+	OpTagDelete    = "image.tag_delete"
+)
+
+type pushOp struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Tag       string `json:"tag,omitempty"`
+	Digest    string `json:"digest"`
+	User      string `json:"user,omitempty"`
+}
+
+// Repo level operation payload
+type RepoOp struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Tag level operation payload
+type TagOp struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Tag       string `json:"tag"`
+}
+
+// Journals manifest pushes and registers replay for every registry kind.
+// Interrupted pushes roll forward when the manifest landed, deletes and
+// untags roll forward, and forks roll back since the caller never saw them
+// succeed. Must be called before handlers.NewApp
+func RegisterJournal(j *journal.Journal, store *stores.Store, access *RegistryAccess, log *logger.Logger) {
+	listenerDeps.journal = j
+	obs := &observer{store: store, log: log, visibility: listenerDeps.visibility}
+
+	j.Handle(OpManifestPush, func(ctx context.Context, payload json.RawMessage) error {
+		var op pushOp
+		if err := json.Unmarshal(payload, &op); err != nil {
+			return err
+		}
+		dgst, err := digest.Parse(op.Digest)
+		if err != nil {
+			return err
+		}
+		if _, _, err := access.GetManifest(ctx, op.Namespace, op.Name, dgst); err != nil {
+			// Never stored, leftover blobs are registry GC's to collect
+			return nil
+		}
+		if !obs.ensureRepository(ctx, op.Namespace, op.Name, op.User) {
+			return fmt.Errorf("repository %s/%s missing after push", op.Namespace, op.Name)
+		}
+		if op.Tag != "" {
+			if desc, err := access.ResolveManifest(ctx, op.Namespace, op.Name, op.Tag); err == nil && desc.Digest == dgst {
+				obs.recordTagPush(ctx, op.Namespace, op.Name, op.Tag, op.Digest, op.User, nil)
+			}
+		}
+		log.Info("journal: completed interrupted push of %s/%s@%s", op.Namespace, op.Name, op.Digest)
+		return nil
+	})
+
+	j.Handle(OpRepoDelete, func(ctx context.Context, payload json.RawMessage) error {
+		var op RepoOp
+		if err := json.Unmarshal(payload, &op); err != nil {
+			return err
+		}
+		if err := store.DeleteRepository(ctx, op.Namespace, op.Name); err != nil {
+			return err
+		}
+		if err := access.DeleteRepository(op.Namespace, op.Name); err != nil {
+			return err
+		}
+		log.Info("journal: completed interrupted delete of %s/%s", op.Namespace, op.Name)
+		return nil
+	})
+
+	j.Handle(OpTagDelete, func(ctx context.Context, payload json.RawMessage) error {
+		var op TagOp
+		if err := json.Unmarshal(payload, &op); err != nil {
+			return err
+		}
+		repo, err := access.repository(ctx, op.Namespace, op.Name)
+		if err != nil {
+			return err
+		}
+		// Gone already when the crash came after storage and before the records
+		var gone driver.PathNotFoundError
+		if err := repo.Tags(ctx).Untag(ctx, op.Tag); err != nil && !errors.As(err, &gone) {
+			return err
+		}
+		obs.tagDeleted(ctx, repo.Named(), op.Tag)
+		log.Info("journal: completed interrupted untag of %s/%s:%s", op.Namespace, op.Name, op.Tag)
+		return nil
+	})
+
+	j.Handle(OpRepoFork, func(ctx context.Context, payload json.RawMessage) error {
+		var op RepoOp
+		if err := json.Unmarshal(payload, &op); err != nil {
+			return err
+		}
+		if err := access.DeleteRepository(op.Namespace, op.Name); err != nil {
+			return err
+		}
+		if err := store.DeleteRepository(ctx, op.Namespace, op.Name); err != nil {
+			return err
+		}
+		log.Info("journal: rolled back interrupted fork into %s/%s", op.Namespace, op.Name)
+		return nil
+	})
+}
+
+// Records a manifest push before distribution writes it
+func (o *observer) beginPush(ctx context.Context, repo reference.Named, m distribution.Manifest, options ...distribution.ManifestServiceOption) (string, error) {
+	if o.journal == nil {
+		return "", nil
+	}
+	namespace, name := utils.SplitRepoName(repo.Name())
+	if namespace == "" || name == "" {
+		return "", nil
+	}
+	_, dgst := utils.ExtractRef(repo, m)
+	user, _ := ctx.Value("auth.user.name").(string)
+	return o.journal.Begin(OpManifestPush, pushOp{
+		Namespace: namespace,
+		Name:      name,
+		Tag:       utils.TagFromOptions(options),
+		Digest:    dgst,
+		User:      user,
+	})
+}
+
+// Removes the link tree of one repository, shared blobs stay for GC
+func (r *RegistryAccess) DeleteRepository(namespace, name string) error {
+	root := filepath.Join(r.storagePath, "docker", "registry", "v2", "repositories")
+	if err := os.RemoveAll(filepath.Join(root, filepath.FromSlash(namespace+"/"+name))); err != nil {
+		return err
+	}
+	if err := r.dropPacked(namespace + "/" + name); err != nil {
+		return err
+	}
+	r.dropRepoLinks(namespace + "/" + name)
+	return nil
+}
@@ -30,19 +30,16 @@ func PullRateLimit(next http.Handler, verifier SubjectVerifier, userLimiter, ano
 			return
 		}
 
-		raw, isBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
-		if !isBearer {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		limiter := anonLimiter
 		key := "ip:" + admin.ClientIP(r.RemoteAddr, r.Header)
-		if verifier != nil {
-			if sub, err := verifier.VerifyTokenSubject(strings.TrimSpace(raw)); err == nil && sub != "" {
-				limiter = userLimiter
-				key = "user:" + sub
-			}
+		if sub := bearerSubject(r, verifier); sub != "" {
+			limiter = userLimiter
+			key = "user:" + sub
 		}
 		if limiter == nil || limiter.Limit() <= 0 {
 			next.ServeHTTP(w, r)
@@ -0,0 +1,269 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry/packed"
+)
+
+// Blob link kinds, a repo can link one digest as both
+const (
+	linkLayer    = "layer"
+	linkManifest = "manifest"
+)
+
+// Answers sharing lookups from the blob_links table instead of walking
+// storage. Lookups fall back to the walk until the first rebuild lands
+func (r *RegistryAccess) UseLinkIndex(store *stores.Store) {
+	r.links = store
+}
+
+// Syncs the index with a fresh storage walk, links recorded meanwhile
+// survive. Runs at startup and after GC, which unlinks behind the listener
+func (r *RegistryAccess) RebuildLinkIndex(ctx context.Context) (int, error) {
+	if r.links == nil {
+		return 0, nil
+	}
+	since := time.Now()
+	links, err := r.scanLinks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.links.SyncBlobLinks(ctx, links, since); err != nil {
+		return 0, fmt.Errorf("storing blob links: %w", err)
+	}
+	r.linksReady.Store(true)
+	return len(links), nil
+}
+
+func (r *RegistryAccess) linkIndex() *stores.Store {
+	if r.links == nil || !r.linksReady.Load() {
+		return nil
+	}
+	return r.links
+}
+
+// Repositories linking each target digest. With seed the targets are first
+// filled with every digest repoName links itself
+func (r *RegistryAccess) linkingRepos(ctx context.Context, repoName string, targets map[digest.Digest]string, seed bool) (map[digest.Digest][]string, error) {
+	out := map[digest.Digest][]string{}
+	if idx := r.linkIndex(); idx != nil {
+		if seed {
+			own, err := idx.BlobLinkDigests(ctx, repoName)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range own {
+				targets[digest.Digest(d)] = ""
+			}
+		}
+		keys := make([]string, 0, len(targets))
+		for d := range targets {
+			keys = append(keys, d.String())
+		}
+		repos, err := idx.BlobLinkRepos(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		for d, rs := range repos {
+			out[digest.Digest(d)] = rs
+		}
+		return out, nil
+	}
+
+	links, err := r.scanLinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range links {
+		d := digest.Digest(l.Digest)
+		if seed && l.Repo == repoName {
+			targets[d] = ""
+		}
+		out[d] = append(out[d], l.Repo)
+	}
+	for d, rs := range out {
+		if _, ok := targets[d]; !ok {
+			delete(out, d)
+			continue
+		}
+		out[d] = dedupeSorted(rs)
+	}
+	return out, nil
+}
+
+// Every layer and manifest revision link in storage. Checks ctx per
+// directory so a cancelled request stops the walk
+func (r *RegistryAccess) scanLinks(ctx context.Context) ([]storage.BlobLink, error) {
+	root := filepath.Join(r.storagePath, "docker", "registry", "v2", "repositories")
+	var links []storage.BlobLink
+	add := func(repo, dir, kind string) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				links = append(links, storage.BlobLink{
+					Digest: digest.NewDigestFromEncoded(digest.SHA256, e.Name()).String(),
+					Repo:   repo,
+					Kind:   kind,
+				})
+			}
+		}
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch d.Name() {
+		case "_uploads":
+			return fs.SkipDir
+		case "_layers":
+			add(repoFromLinkDir(root, path), filepath.Join(path, "sha256"), linkLayer)
+			return fs.SkipDir
+		case "_manifests":
+			add(repoFromLinkDir(root, path), filepath.Join(path, "revisions", "sha256"), linkManifest)
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning repository links: %w", err)
+	}
+
+	if r.packed != nil {
+		stored, err := packed.RepoLinks(ctx, r.packed)
+		if err != nil {
+			return nil, fmt.Errorf("listing packed links: %w", err)
+		}
+		for _, l := range stored {
+			kind := linkLayer
+			if l.Manifest {
+				kind = linkManifest
+			}
+			links = append(links, storage.BlobLink{
+				Digest: digest.NewDigestFromEncoded(digest.SHA256, l.Hex).String(),
+				Repo:   l.Repo,
+				Kind:   kind,
+			})
+		}
+	}
+	return links, nil
+}
+
+// Index upkeep for storage changes made outside the registry handlers
+func (r *RegistryAccess) dropRepoLinks(repo string) {
+	if r.links != nil {
+		_ = r.links.RemoveRepoBlobLinks(context.Background(), repo)
+	}
+}
+
+func (r *RegistryAccess) dropNamespaceLinks(namespace string) {
+	if r.links != nil {
+		_ = r.links.RemoveNamespaceBlobLinks(context.Background(), namespace)
+	}
+}
+
+func (r *RegistryAccess) copyRepoLinks(src, dst string) {
+	if r.links != nil {
+		_ = r.links.CopyBlobLinks(context.Background(), src, dst)
+	}
+}
+
+// Records layer links as uploads commit, mount, or get deleted
+type observedBlobs struct {
+	distribution.BlobStore
+	repo reference.Named
+	obs  *observer
+}
+
+func (r *observedRepo) Blobs(ctx context.Context) distribution.BlobStore {
+	return &observedBlobs{BlobStore: r.Repository.Blobs(ctx), repo: r.Repository.Named(), obs: r.obs}
+}
+
+func (b *observedBlobs) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
+	desc, err := b.BlobStore.Put(ctx, mediaType, p)
+	if err == nil {
+		b.obs.linked(ctx, b.repo, desc.Digest, linkLayer)
+	}
+	return desc, err
+}
+
+func (b *observedBlobs) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	w, err := b.BlobStore.Create(ctx, options...)
+	var mounted distribution.ErrBlobMounted
+	if errors.As(err, &mounted) {
+		b.obs.linked(ctx, b.repo, mounted.Descriptor.Digest, linkLayer)
+	}
+	if err != nil {
+		return w, err
+	}
+	return &observedWriter{BlobWriter: w, blobs: b}, nil
+}
+
+func (b *observedBlobs) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
+	w, err := b.BlobStore.Resume(ctx, id)
+	if err != nil {
+		return w, err
+	}
+	return &observedWriter{BlobWriter: w, blobs: b}, nil
+}
+
+func (b *observedBlobs) Delete(ctx context.Context, dgst digest.Digest) error {
+	err := b.BlobStore.Delete(ctx, dgst)
+	if err == nil {
+		b.obs.unlinked(ctx, b.repo, dgst, linkLayer)
+	}
+	return err
+}
+
+type observedWriter struct {
+	distribution.BlobWriter
+	blobs *observedBlobs
+}
+
+func (w *observedWriter) Commit(ctx context.Context, provisional distribution.Descriptor) (distribution.Descriptor, error) {
+	if err := w.blobs.checkQuota(ctx, provisional.Digest, w.Size()); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	desc, err := w.BlobWriter.Commit(ctx, provisional)
+	if err == nil {
+		w.blobs.obs.linked(ctx, w.blobs.repo, desc.Digest, linkLayer)
+	}
+	return desc, err
+}
+
+func (o *observer) linked(ctx context.Context, repo reference.Named, dgst digest.Digest, kind string) {
+	if err := o.store.AddBlobLink(ctx, dgst.String(), repo.Name(), kind); err != nil {
+		o.log.Error("listener: failed to index %s link %s in %s: %v", kind, dgst, repo.Name(), err)
+	}
+}
+
+func (o *observer) unlinked(ctx context.Context, repo reference.Named, dgst digest.Digest, kind string) {
+	if err := o.store.RemoveBlobLink(ctx, dgst.String(), repo.Name(), kind); err != nil {
+		o.log.Error("listener: failed to unindex %s link %s in %s: %v", kind, dgst, repo.Name(), err)
+	}
+}
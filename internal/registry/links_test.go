@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+)
+
+func writeLink(t *testing.T, root, repo, sub, hex string) {
+	t.Helper()
+	dir := filepath.Join(root, "docker", "registry", "v2", "repositories", filepath.FromSlash(repo), sub, "sha256", hex)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "link"), []byte("sha256:"+hex), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sharedWith(t *testing.T, r *RegistryAccess, ns, name string) map[string][]string {
+	t.Helper()
+	blobs, err := r.BlobSharing(context.Background(), ns, name, "")
+	if err != nil {
+		t.Fatalf("BlobSharing(%s/%s): %v", ns, name, err)
+	}
+	out := map[string][]string{}
+	for _, b := range blobs {
+		out[b.Digest] = b.Repos
+	}
+	return out
+}
+
+// The index answers exactly what the storage walk did and follows forks and deletes
+func TestLinkIndex(t *testing.T) {
+	root := t.TempDir()
+	layer, shared, manifest := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	writeLink(t, root, "alice/app", "_layers", layer)
+	writeLink(t, root, "alice/app", "_layers", shared)
+	writeLink(t, root, "alice/app", "_manifests/revisions", manifest)
+	writeLink(t, root, "bob/tool", "_layers", shared)
+
+	access, err := NewRegistryAccess(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	access.UseLinkIndex(store)
+
+	walked := sharedWith(t, access, "alice", "app")
+	want := map[string][]string{"sha256:" + layer: nil, "sha256:" + shared: {"bob/tool"}, "sha256:" + manifest: nil}
+	if !reflect.DeepEqual(walked, want) {
+		t.Fatalf("walk = %v, want %v", walked, want)
+	}
+
+	if n, err := access.RebuildLinkIndex(context.Background()); err != nil || n != 4 {
+		t.Fatalf("RebuildLinkIndex = %d, %v", n, err)
+	}
+	// Storage changes the index did not see must not show up now
+	writeLink(t, root, "carol/other", "_layers", layer)
+	if indexed := sharedWith(t, access, "alice", "app"); !reflect.DeepEqual(indexed, want) {
+		t.Fatalf("index = %v, want %v", indexed, want)
+	}
+
+	if _, err := access.ForkRepository("alice/app", "alice/app-fork"); err != nil {
+		t.Fatal(err)
+	}
+	if err := access.DeleteRepository("bob", "tool"); err != nil {
+		t.Fatal(err)
+	}
+	got := sharedWith(t, access, "alice", "app")
+	for d, repos := range got {
+		if !reflect.DeepEqual(repos, []string{"alice/app-fork"}) {
+			t.Errorf("%s shared with %v, want only the fork", d, repos)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := access.scanLinks(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled scan = %v", err)
+	}
+}
@@ -2,17 +2,26 @@ package registry
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/reference"
 	"github.com/google/uuid"
 	"github.com/opencontainers/go-digest"
 
+	"github.com/nickheyer/distroface/internal/alerts"
 	"github.com/nickheyer/distroface/internal/audit"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/journal"
+	"github.com/nickheyer/distroface/internal/policy"
 	"github.com/nickheyer/distroface/internal/webhook"
 	"github.com/nickheyer/distroface/pkg/logger"
 	"github.com/nickheyer/distroface/pkg/utils"
@@ -24,15 +33,76 @@ var listenerDeps struct {
 	log        *logger.Logger
 	dispatcher *webhook.Dispatcher
 	recorder   *audit.Recorder
+	signer     PushSigner
+	policy     PushPolicy
+	freezes    FreezeGate
+	journal    *journal.Journal
+	signals    *alerts.Signals
+	visibility *policy.Visibility
+	replicator PushReplicator
+	quota      RepoQuota
+
+	caseInsensitive bool
+}
+
+// Signs manifests once a push was accepted
+type PushSigner interface {
+	SignPush(ctx context.Context, namespace, name, tag, mediaType string, payload []byte)
+}
+
+// Vets manifests before they are stored, an error refuses the push
+type PushPolicy interface {
+	Check(ctx context.Context, p policy.Push) error
+}
+
+// Holds pushes to frozen repos, an error refuses the push
+type FreezeGate interface {
+	Check(ctx context.Context, user, namespace, name string) error
+}
+
+// Queues copies of pushed tags to remote registries
+type PushReplicator interface {
+	Pushed(ctx context.Context, namespace, name, tag string)
 }
 
 // RegisterListenerMiddleware stores the dependencies needed by the
 // repository middleware observer. Must be called before handlers.NewApp.
-func RegisterListenerMiddleware(store *stores.Store, log *logger.Logger, dispatcher *webhook.Dispatcher, recorder *audit.Recorder) {
+func RegisterListenerMiddleware(store *stores.Store, log *logger.Logger, dispatcher *webhook.Dispatcher, recorder *audit.Recorder, signer PushSigner, gate PushPolicy) {
 	listenerDeps.store = store
 	listenerDeps.log = log
 	listenerDeps.dispatcher = dispatcher
 	listenerDeps.recorder = recorder
+	listenerDeps.signer = signer
+	listenerDeps.policy = gate
+}
+
+// Refuses pushes during freeze windows. Must be called before handlers.NewApp
+func RegisterFreezes(f FreezeGate) {
+	listenerDeps.freezes = f
+}
+
+// Decides whether repos created by a first push start private. Must be
+// called before handlers.NewApp and RegisterJournal
+func RegisterVisibility(v *policy.Visibility) {
+	listenerDeps.visibility = v
+}
+
+// Feeds manifest push outcomes to the alert monitor. Must be called
+// before handlers.NewApp
+func RegisterAlertSignals(s *alerts.Signals) {
+	listenerDeps.signals = s
+}
+
+// Refuses blob uploads that would take a repo past its quota. Must be
+// called before handlers.NewApp
+func RegisterQuota(q RepoQuota) {
+	listenerDeps.quota = q
+}
+
+// Hands tagged pushes to the replication rules. Repos are wrapped per
+// request, so this may follow handlers.NewApp but must precede serving
+func RegisterReplication(r PushReplicator) {
+	listenerDeps.replicator = r
 }
 
 func init() {
@@ -47,6 +117,16 @@ func init() {
 			log:        listenerDeps.log,
 			dispatcher: listenerDeps.dispatcher,
 			recorder:   listenerDeps.recorder,
+			signer:     listenerDeps.signer,
+			policy:     listenerDeps.policy,
+			freezes:    listenerDeps.freezes,
+			journal:    listenerDeps.journal,
+			signals:    listenerDeps.signals,
+			visibility: listenerDeps.visibility,
+			replicator: listenerDeps.replicator,
+			quota:      listenerDeps.quota,
+
+			caseInsensitive: listenerDeps.caseInsensitive,
 		}}, nil
 	})
 }
@@ -57,6 +137,16 @@ type observer struct {
 	log        *logger.Logger
 	dispatcher *webhook.Dispatcher
 	recorder   *audit.Recorder
+	signer     PushSigner
+	policy     PushPolicy
+	freezes    FreezeGate
+	journal    *journal.Journal
+	signals    *alerts.Signals
+	visibility *policy.Visibility
+	replicator PushReplicator
+	quota      RepoQuota
+
+	caseInsensitive bool
 }
 
 type observedRepo struct {
@@ -69,7 +159,7 @@ func (r *observedRepo) Manifests(ctx context.Context, options ...distribution.Ma
 	if err != nil {
 		return nil, err
 	}
-	return &observedManifests{ManifestService: ms, repo: r.Repository.Named(), obs: r.obs}, nil
+	return &observedManifests{ManifestService: ms, repo: r.Repository.Named(), blobs: r.Repository.Blobs(ctx), tags: r.Repository.Tags(ctx), obs: r.obs}, nil
 }
 
 func (r *observedRepo) Tags(ctx context.Context) distribution.TagService {
@@ -78,8 +168,10 @@ func (r *observedRepo) Tags(ctx context.Context) distribution.TagService {
 
 type observedManifests struct {
 	distribution.ManifestService
-	repo reference.Named
-	obs  *observer
+	repo  reference.Named
+	blobs distribution.BlobStore
+	tags  distribution.TagService
+	obs   *observer
 }
 
 func (m *observedManifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
@@ -91,17 +183,33 @@ func (m *observedManifests) Get(ctx context.Context, dgst digest.Digest, options
 }
 
 func (m *observedManifests) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	if err := m.obs.checkPush(ctx, m.repo, m.ManifestService, m.blobs, m.tags, manifest, options...); err != nil {
+		return "", err
+	}
+	opID, err := m.obs.beginPush(ctx, m.repo, manifest, options...)
+	if err != nil {
+		return "", err
+	}
+	defer m.obs.journal.Done(opID)
 	dgst, err := m.ManifestService.Put(ctx, manifest, options...)
+	m.obs.signals.Upload(err != nil)
 	if err == nil {
-		m.obs.manifestPushed(ctx, m.repo, manifest, options...)
+		m.obs.linked(ctx, m.repo, dgst, linkManifest)
+		m.obs.manifestPushed(ctx, m.repo, m.blobs, manifest, options...)
 	}
 	return dgst, err
 }
 
 func (m *observedManifests) Delete(ctx context.Context, dgst digest.Digest) error {
+	var children []digest.Digest
+	if manifest, err := m.ManifestService.Get(ctx, dgst); err == nil {
+		children = utils.IndexChildren(manifest)
+	}
 	err := m.ManifestService.Delete(ctx, dgst)
 	if err == nil {
+		m.obs.unlinked(ctx, m.repo, dgst, linkManifest)
 		m.obs.manifestDeleted(ctx, m.repo, dgst)
+		m.pruneChildren(ctx, children)
 	}
 	return err
 }
@@ -120,19 +228,62 @@ func (t *observedTags) Untag(ctx context.Context, tag string) error {
 	return err
 }
 
-func (o *observer) manifestPushed(ctx context.Context, repo reference.Named, m distribution.Manifest, options ...distribution.ManifestServiceOption) {
+func (o *observer) manifestPushed(ctx context.Context, repo reference.Named, blobs distribution.BlobStore, m distribution.Manifest, options ...distribution.ManifestServiceOption) {
 	namespace, name := utils.SplitRepoName(repo.Name())
 	if namespace == "" || name == "" {
 		return
 	}
 
+	pusher, _ := ctx.Value("auth.user.name").(string)
+	if !o.ensureRepository(ctx, namespace, name, pusher) {
+		return
+	}
+
+	if err := o.store.IncrementPushCount(ctx, namespace, name); err != nil {
+		o.log.Error("listener: failed to increment push count for %s/%s: %v", namespace, name, err)
+	}
+
+	tag := utils.TagFromOptions(options)
+	_, dgst := utils.ExtractRef(repo, m)
+	if tag != "" {
+		labels := configLabels(ctx, blobs, m)
+		if labels == nil {
+			// Indexes carry none, the tag's previous labels no longer apply
+			labels = map[string]string{}
+		}
+		o.recordTagPush(ctx, namespace, name, tag, dgst, pusher, labels)
+		o.recordLabelExpiry(ctx, namespace, name, tag, pusher, labels[ExpiresLabel])
+	}
+	if o.dispatcher != nil {
+		o.dispatcher.Dispatch(ctx, "push", namespace, name, tag, dgst)
+	}
+	o.audit(ctx, "push", namespace, name, tag, dgst)
+
+	if o.signer != nil {
+		if mediaType, payload, err := m.Payload(); err == nil {
+			o.signer.SignPush(ctx, namespace, name, tag, mediaType, payload)
+		}
+	}
+	if o.replicator != nil && tag != "" {
+		o.replicator.Pushed(ctx, namespace, name, tag)
+	}
+}
+
+// Creates the repo row on first push, private when the pusher may not
+// publish. False when it is missing and could not be made
+func (o *observer) ensureRepository(ctx context.Context, namespace, name, pusher string) bool {
 	r, err := o.store.GetRepository(ctx, namespace, name)
 	if err != nil {
 		o.log.Error("listener: failed to look up repo %s/%s: %v", namespace, name, err)
-		return
+		return false
 	}
 
 	if r == nil {
+		// Token auth refuses these pushes, this covers instances without auth
+		if err := utils.ValidateImagePath(namespace + "/" + name); err != nil {
+			o.log.Error("listener: not creating repo %s/%s: %v", namespace, name, err)
+			return false
+		}
 		ownerID := ""
 		isOrgNamespace := false
 		user, err := o.store.GetUserByUsername(ctx, namespace)
@@ -157,25 +308,51 @@ func (o *observer) manifestPushed(ctx context.Context, repo reference.Named, m d
 			Namespace:      namespace,
 			Name:           name,
 			OwnerID:        ownerID,
+			IsPrivate:      o.visibility.PushedPrivate(ctx, pusher),
 			IsOrgNamespace: isOrgNamespace,
 		}
 		if err := o.store.CreateRepository(ctx, r); err != nil {
 			o.log.Error("listener: failed to create repo %s/%s: %v", namespace, name, err)
-			return
+			return false
 		}
 		o.log.Info("listener: auto-created repository %s/%s", namespace, name)
 	}
+	return true
+}
 
-	if err := o.store.IncrementPushCount(ctx, namespace, name); err != nil {
-		o.log.Error("listener: failed to increment push count for %s/%s: %v", namespace, name, err)
+// Upserts the pusher and image labels of a tag for the tag details view
+// and label filters. Nil labels keep the recorded ones. Any earlier copy
+// provenance is void now, copies record theirs after the push lands
+func (o *observer) recordTagPush(ctx context.Context, namespace, name, tag, dgst, pusher string, labels map[string]string) {
+	push := &storage.TagPush{Namespace: namespace, Name: name, Tag: tag, Digest: dgst, PushedBy: pusher, PushedAt: time.Now()}
+	if labels != nil {
+		if raw, err := json.Marshal(labels); err == nil {
+			push.Labels = string(raw)
+		}
 	}
+	if err := o.store.RecordTagPush(ctx, push); err != nil {
+		o.log.Error("listener: failed to record push of %s/%s:%s: %v", namespace, name, tag, err)
+	}
+	if err := o.store.DeleteTagProvenance(ctx, namespace, name, tag); err != nil {
+		o.log.Error("listener: failed to drop provenance of %s/%s:%s: %v", namespace, name, tag, err)
+	}
+}
 
-	tag := utils.TagFromOptions(options)
-	_, dgst := utils.ExtractRef(repo, m)
-	if o.dispatcher != nil {
-		o.dispatcher.Dispatch(ctx, "push", namespace, name, tag, dgst)
+// Expires the tag as its image label asks, images without one leave any
+// expiry set through the api alone
+func (o *observer) recordLabelExpiry(ctx context.Context, namespace, name, tag, pusher, label string) {
+	if label == "" {
+		return
+	}
+	ttl, err := utils.ParseTTL(label)
+	if err != nil || ttl == 0 {
+		o.log.Warn("listener: ignoring %s label on %s/%s:%s: %q", ExpiresLabel, namespace, name, tag, label)
+		return
+	}
+	expiry := &storage.TagExpiry{Namespace: namespace, Name: name, Tag: tag, ExpiresAt: time.Now().UTC().Add(ttl), SetBy: pusher}
+	if err := o.store.SetTagExpiry(ctx, expiry); err != nil {
+		o.log.Error("listener: failed to record expiry of %s/%s:%s: %v", namespace, name, tag, err)
 	}
-	o.audit(ctx, "push", namespace, name, tag, dgst)
 }
 
 func (o *observer) manifestPulled(ctx context.Context, repo reference.Named, m distribution.Manifest, options ...distribution.ManifestServiceOption) {
@@ -189,6 +366,11 @@ func (o *observer) manifestPulled(ctx context.Context, repo reference.Named, m d
 	}
 
 	tag := utils.TagFromOptions(options)
+	if tag != "" {
+		if err := o.store.RecordTagPull(ctx, namespace, name, tag, time.Now().UTC()); err != nil {
+			o.log.Error("listener: failed to record pull of %s/%s:%s: %v", namespace, name, tag, err)
+		}
+	}
 	_, dgst := utils.ExtractRef(repo, m)
 	if o.dispatcher != nil {
 		o.dispatcher.Dispatch(ctx, "pull", namespace, name, tag, dgst)
@@ -212,6 +394,18 @@ func (o *observer) tagDeleted(ctx context.Context, repo reference.Named, tag str
 	if namespace == "" || name == "" {
 		return
 	}
+	if err := o.store.DeleteTagPush(ctx, namespace, name, tag); err != nil {
+		o.log.Error("listener: failed to drop push record of %s/%s:%s: %v", namespace, name, tag, err)
+	}
+	if err := o.store.DeleteTagPull(ctx, namespace, name, tag); err != nil {
+		o.log.Error("listener: failed to drop pull record of %s/%s:%s: %v", namespace, name, tag, err)
+	}
+	if err := o.store.DeleteTagProvenance(ctx, namespace, name, tag); err != nil {
+		o.log.Error("listener: failed to drop provenance of %s/%s:%s: %v", namespace, name, tag, err)
+	}
+	if err := o.store.DeleteTagExpiry(ctx, namespace, name, tag); err != nil {
+		o.log.Error("listener: failed to drop expiry of %s/%s:%s: %v", namespace, name, tag, err)
+	}
 	if o.dispatcher != nil {
 		o.dispatcher.Dispatch(ctx, "delete", namespace, name, tag, "")
 	}
@@ -244,3 +438,78 @@ func (o *observer) audit(ctx context.Context, action, namespace, name, tag, dgst
 		Actor:    actor,
 	})
 }
+
+// Largest image config read for labels, bigger configs are skipped
+const maxConfigLabelsSize = 1 << 20
+
+// Checks tag case and freeze windows then asks the push policy about a
+// manifest, refusals surface as TAG_INVALID or DENIED
+func (o *observer) checkPush(ctx context.Context, repo reference.Named, manifests distribution.ManifestService, blobs distribution.BlobStore, tags distribution.TagService, m distribution.Manifest, options ...distribution.ManifestServiceOption) error {
+	namespace, name := utils.SplitRepoName(repo.Name())
+	user, _ := ctx.Value("auth.user.name").(string)
+	if o.caseInsensitive {
+		if err := checkTagCase(ctx, tags, utils.TagFromOptions(options)); err != nil {
+			return err
+		}
+	}
+	if o.freezes != nil {
+		// Frozen repos refuse before the hook is asked
+		if err := o.freezes.Check(ctx, user, namespace, name); err != nil {
+			if errors.Is(err, policy.ErrFrozen) {
+				return errcode.ErrorCodeDenied.WithMessage(err.Error())
+			}
+			return err
+		}
+	}
+	if o.policy == nil {
+		return nil
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		return err
+	}
+	err = o.policy.Check(ctx, policy.Push{
+		Event:     policy.EventManifestPush,
+		User:      user,
+		Namespace: namespace,
+		Repo:      name,
+		Tag:       utils.TagFromOptions(options),
+		Digest:    digest.FromBytes(payload).String(),
+		MediaType: mediaType,
+		Size:      utils.ComputeImageSize(ctx, manifests, m),
+		Labels:    configLabels(ctx, blobs, m),
+	})
+	if errors.Is(err, policy.ErrDenied) {
+		return errcode.ErrorCodeDenied.WithMessage(err.Error())
+	}
+	return err
+}
+
+// Labels from an image config, nil for indexes and unreadable configs
+func configLabels(ctx context.Context, blobs distribution.BlobStore, m distribution.Manifest) map[string]string {
+	var cfg distribution.Descriptor
+	switch mm := m.(type) {
+	case *ocischema.DeserializedManifest:
+		cfg = mm.Config
+	case *schema2.DeserializedManifest:
+		cfg = mm.Config
+	default:
+		return nil
+	}
+	if blobs == nil || cfg.Size <= 0 || cfg.Size > maxConfigLabelsSize {
+		return nil
+	}
+	raw, err := blobs.Get(ctx, cfg.Digest)
+	if err != nil {
+		return nil
+	}
+	var image struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if json.Unmarshal(raw, &image) != nil {
+		return nil
+	}
+	return image.Config.Labels
+}
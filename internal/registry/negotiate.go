@@ -0,0 +1,276 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Every manifest type the registry stores, asked for on the client's
+// behalf so the stored form always comes back
+var storedManifestTypes = []string{
+	ocispec.MediaTypeImageManifest,
+	ocispec.MediaTypeImageIndex,
+	schema2.MediaTypeManifest,
+	manifestlist.MediaTypeManifestList,
+}
+
+// Docker media types by their OCI counterpart, the reverse is derived
+var ociToDocker = map[string]string{
+	ocispec.MediaTypeImageManifest:                  schema2.MediaTypeManifest,
+	ocispec.MediaTypeImageIndex:                     manifestlist.MediaTypeManifestList,
+	ocispec.MediaTypeImageConfig:                    schema2.MediaTypeImageConfig,
+	ocispec.MediaTypeImageLayerGzip:                 schema2.MediaTypeLayer,
+	ocispec.MediaTypeImageLayer:                     schema2.MediaTypeUncompressedLayer,
+	ocispec.MediaTypeImageLayerNonDistributableGzip: schema2.MediaTypeForeignLayer,
+}
+
+var dockerToOCI = func() map[string]string {
+	out := make(map[string]string, len(ociToDocker))
+	for o, d := range ociToDocker {
+		out[d] = o
+	}
+	return out
+}()
+
+// Answers manifest GETs and HEADs in a media type the client's Accept
+// header names. The stored form is served when acceptable, otherwise a
+// tag is converted between its Docker v2 and OCI forms where nothing is
+// lost, and anything else is refused with 406. Conversion changes the
+// digest, so manifests addressed by digest are never converted
+type ManifestNegotiator struct {
+	next http.Handler
+}
+
+// Wraps the registry with Accept based manifest negotiation
+func NegotiateManifests(next http.Handler) *ManifestNegotiator {
+	return &ManifestNegotiator{next: next}
+}
+
+// Buffered response, manifests are small and may need rewriting
+type captureWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) Header() http.Header { return w.header }
+
+func (w *captureWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+func (n *ManifestNegotiator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := contentPathRe.FindStringSubmatch(r.URL.Path)
+	if m == nil || m[2] != "manifests" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		n.next.ServeHTTP(w, r)
+		return
+	}
+	accepted, anyType := acceptedTypes(r.Header.Values("Accept"))
+	if anyType {
+		// No preference, whatever is stored is fine
+		inner := r.Clone(r.Context())
+		inner.Header.Set("Accept", strings.Join(storedManifestTypes, ", "))
+		n.next.ServeHTTP(w, inner)
+		return
+	}
+
+	// HEAD goes in as GET, converting needs the body
+	inner := r.Clone(r.Context())
+	inner.Method = http.MethodGet
+	inner.Header.Set("Accept", strings.Join(storedManifestTypes, ", "))
+	inner.Header.Del("If-None-Match")
+	cw := &captureWriter{header: http.Header{}}
+	n.next.ServeHTTP(cw, inner)
+
+	stored, _, _ := mime.ParseMediaType(cw.header.Get("Content-Type"))
+	if cw.status != http.StatusOK || accepted[stored] {
+		if cw.status == http.StatusOK && etagMatch(r, cw.header.Get("Docker-Content-Digest")) {
+			cw.status, cw.body = http.StatusNotModified, bytes.Buffer{}
+		}
+		replay(w, r, cw.header, cw.status, cw.body.Bytes())
+		return
+	}
+
+	// Clients knowing no index at all get distribution's default platform pick
+	if isIndexType(stored) && !accepted[ocispec.MediaTypeImageIndex] && !accepted[manifestlist.MediaTypeManifestList] {
+		n.next.ServeHTTP(w, r)
+		return
+	}
+
+	var (
+		mediaType string
+		payload   []byte
+		err       error
+	)
+	if _, notDigest := digest.Parse(m[3]); notDigest == nil {
+		err = fmt.Errorf("%s is stored as %s, converting it would change its digest", m[3], stored)
+	} else {
+		mediaType, payload, err = convertManifest(cw.body.Bytes(), stored, accepted)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotAcceptable)
+		if r.Method == http.MethodGet {
+			body, _ := json.Marshal(map[string]any{"errors": []map[string]any{{
+				"code":    "MANIFEST_UNACCEPTABLE",
+				"message": "no acceptable manifest media type: " + err.Error(),
+				"detail":  map[string]string{"stored": stored},
+			}}})
+			_, _ = w.Write(body)
+		}
+		return
+	}
+
+	dgst := digest.FromBytes(payload)
+	header := cw.header.Clone()
+	header.Set("Content-Type", mediaType)
+	header.Set("Content-Length", strconv.Itoa(len(payload)))
+	header.Set("Docker-Content-Digest", dgst.String())
+	header.Set("Etag", fmt.Sprintf(`"%s"`, dgst))
+	if etagMatch(r, dgst.String()) {
+		replay(w, r, header, http.StatusNotModified, nil)
+		return
+	}
+	replay(w, r, header, http.StatusOK, payload)
+}
+
+// Writes a buffered response, without the body for HEAD
+func replay(w http.ResponseWriter, r *http.Request, header http.Header, status int, body []byte) {
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	if status == http.StatusNotModified {
+		w.Header().Del("Content-Length")
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead && len(body) > 0 {
+		_, _ = w.Write(body)
+	}
+}
+
+func etagMatch(r *http.Request, dgst string) bool {
+	if dgst == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("If-None-Match") {
+		if v == dgst || v == `"`+dgst+`"` {
+			return true
+		}
+	}
+	return false
+}
+
+// Media types named in Accept headers, any is set when the client takes
+// anything (no header or a wildcard). Entries with q=0 are refusals
+func acceptedTypes(values []string) (map[string]bool, bool) {
+	out := map[string]bool{}
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			if mt == "*/*" || mt == "application/*" {
+				return nil, true
+			}
+			out[mt] = true
+		}
+	}
+	return out, len(out) == 0
+}
+
+func isIndexType(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageIndex || mediaType == manifestlist.MediaTypeManifestList
+}
+
+// Rewrites a manifest into its Docker or OCI counterpart when the client
+// accepts that and every descriptor inside has an equivalent
+func convertManifest(payload []byte, stored string, accepted map[string]bool) (string, []byte, error) {
+	target, toDocker := ociToDocker[stored], true
+	if target == "" {
+		target, toDocker = dockerToOCI[stored], false
+	}
+	if target == "" || !accepted[target] {
+		return "", nil, fmt.Errorf("%s has no accepted counterpart", stored)
+	}
+	mapping := dockerToOCI
+	if toDocker {
+		mapping = ociToDocker
+	}
+	mapDesc := func(d ocispec.Descriptor) (ocispec.Descriptor, error) {
+		mt, ok := mapping[d.MediaType]
+		if !ok {
+			return d, fmt.Errorf("%s has no counterpart", d.MediaType)
+		}
+		d.MediaType = mt
+		if toDocker {
+			d.Annotations, d.ArtifactType, d.Data = nil, "", nil
+		}
+		return d, nil
+	}
+
+	if isIndexType(stored) {
+		var idx ocispec.Index
+		if err := json.Unmarshal(payload, &idx); err != nil {
+			return "", nil, fmt.Errorf("decoding %s: %w", stored, err)
+		}
+		if toDocker {
+			if idx.ArtifactType != "" || idx.Subject != nil {
+				return "", nil, fmt.Errorf("artifact indexes have no Docker form")
+			}
+			idx.Annotations = nil
+			for i := range idx.Manifests {
+				idx.Manifests[i].Annotations, idx.Manifests[i].ArtifactType, idx.Manifests[i].Data = nil, "", nil
+			}
+		}
+		// Children stay as stored, they are fetched by digest
+		idx.MediaType = target
+		out, err := json.Marshal(idx)
+		return target, out, err
+	}
+
+	var mf ocispec.Manifest
+	if err := json.Unmarshal(payload, &mf); err != nil {
+		return "", nil, fmt.Errorf("decoding %s: %w", stored, err)
+	}
+	if toDocker && (mf.ArtifactType != "" || mf.Subject != nil) {
+		return "", nil, fmt.Errorf("artifact manifests have no Docker form")
+	}
+	var err error
+	if mf.Config, err = mapDesc(mf.Config); err != nil {
+		return "", nil, fmt.Errorf("config %w", err)
+	}
+	for i := range mf.Layers {
+		if mf.Layers[i], err = mapDesc(mf.Layers[i]); err != nil {
+			return "", nil, fmt.Errorf("layer %w", err)
+		}
+	}
+	if toDocker {
+		mf.Annotations = nil
+	}
+	mf.MediaType = target
+	out, err := json.Marshal(mf)
+	return target, out, err
+}
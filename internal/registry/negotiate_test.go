@@ -0,0 +1,197 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Accept headers real clients send for manifests
+const (
+	dockerAccept     = "application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.oci.image.index.v1+json, application/vnd.oci.image.manifest.v1+json"
+	oldDockerAccept  = "application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.docker.distribution.manifest.v1+prettyjws"
+	podmanAccept     = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.v1+prettyjws, application/vnd.docker.distribution.manifest.v1+json, application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.oci.image.index.v1+json"
+	containerdAccept = "application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.oci.image.manifest.v1+json, application/vnd.oci.image.index.v1+json, */*"
+	ociOnlyAccept    = "application/vnd.oci.image.manifest.v1+json, application/vnd.oci.image.index.v1+json"
+)
+
+// Serves stored manifests the way distribution does, refusing OCI types
+// the Accept header leaves out
+type manifestBackend map[string]ocispec.Descriptor
+
+func (b manifestBackend) add(t *testing.T, tag string, v any) ocispec.Descriptor {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	_ = json.Unmarshal(raw, &probe)
+	desc := ocispec.Descriptor{MediaType: probe.MediaType, Digest: digest.FromBytes(raw), Size: int64(len(raw)), Data: raw}
+	b[tag], b[desc.Digest.String()] = desc, desc
+	return desc
+}
+
+func (b manifestBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := contentPathRe.FindStringSubmatch(r.URL.Path)
+	desc, ok := b[m[3]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if strings.HasPrefix(desc.MediaType, "application/vnd.oci.") && !strings.Contains(r.Header.Get("Accept"), desc.MediaType) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(desc.Size))
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(desc.Data)
+	}
+}
+
+func TestNegotiateManifests(t *testing.T) {
+	config := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromString("config"), Size: 6}
+	layer := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("layer"), Size: 5}
+	backend := manifestBackend{}
+	oci := backend.add(t, "oci", ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Config:      config,
+		Layers:      []ocispec.Descriptor{layer},
+		Annotations: map[string]string{"org.opencontainers.image.source": "https://example.com"},
+	})
+	dockerConfig, dockerLayer := config, layer
+	dockerConfig.MediaType, dockerLayer.MediaType = schema2.MediaTypeImageConfig, schema2.MediaTypeLayer
+	docker := backend.add(t, "docker", ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: schema2.MediaTypeManifest,
+		Config:    dockerConfig,
+		Layers:    []ocispec.Descriptor{dockerLayer},
+	})
+	artifactConfig := config
+	artifactConfig.MediaType = "application/vnd.example.sbom.config+json"
+	backend.add(t, "sbom", ocispec.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/spdx+json",
+		Config:       artifactConfig,
+		Layers:       []ocispec.Descriptor{layer},
+	})
+	backend.add(t, "index", ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{{
+			MediaType: oci.MediaType, Digest: oci.Digest, Size: oci.Size,
+			Platform: &ocispec.Platform{Architecture: "amd64", OS: "linux"},
+		}},
+	})
+	h := NegotiateManifests(backend)
+
+	cases := []struct {
+		name, ref, accept, method string
+		status                    int
+		mediaType                 string
+		stored                    bool // Served byte for byte as pushed
+	}{
+		{name: "docker, oci stored", ref: "oci", accept: dockerAccept, status: 200, mediaType: ocispec.MediaTypeImageManifest, stored: true},
+		{name: "podman, oci stored", ref: "oci", accept: podmanAccept, status: 200, mediaType: ocispec.MediaTypeImageManifest, stored: true},
+		{name: "containerd, docker stored", ref: "docker", accept: containerdAccept, status: 200, mediaType: schema2.MediaTypeManifest, stored: true},
+		{name: "no accept header, oci stored", ref: "oci", status: 200, mediaType: ocispec.MediaTypeImageManifest, stored: true},
+		{name: "old docker, oci stored", ref: "oci", accept: oldDockerAccept, status: 200, mediaType: schema2.MediaTypeManifest},
+		{name: "old docker head, oci stored", ref: "oci", accept: oldDockerAccept, method: http.MethodHead, status: 200, mediaType: schema2.MediaTypeManifest},
+		{name: "oci only, docker stored", ref: "docker", accept: ociOnlyAccept, status: 200, mediaType: ocispec.MediaTypeImageManifest},
+		{name: "old docker, oci index", ref: "index", accept: oldDockerAccept, status: 200, mediaType: manifestlist.MediaTypeManifestList},
+		{name: "oci only, docker by digest", ref: docker.Digest.String(), accept: ociOnlyAccept, status: http.StatusNotAcceptable},
+		{name: "old docker, artifact", ref: "sbom", accept: oldDockerAccept, status: http.StatusNotAcceptable},
+		{name: "q=0 refuses the stored type", ref: "oci", accept: ocispec.MediaTypeImageManifest + ";q=0, " + schema2.MediaTypeManifest, status: 200, mediaType: schema2.MediaTypeManifest},
+		{name: "missing tag", ref: "gone", accept: oldDockerAccept, status: http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/v2/alice/app/manifests/"+tc.ref, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tc.mediaType {
+				t.Fatalf("Content-Type = %s, want %s", got, tc.mediaType)
+			}
+			want := backend[tc.ref]
+			if method == http.MethodHead {
+				if rec.Body.Len() != 0 || rec.Header().Get("Docker-Content-Digest") == want.Digest.String() {
+					t.Fatalf("HEAD body %d bytes, digest %s", rec.Body.Len(), rec.Header().Get("Docker-Content-Digest"))
+				}
+				return
+			}
+			body := rec.Body.Bytes()
+			if got := digest.FromBytes(body).String(); got != rec.Header().Get("Docker-Content-Digest") {
+				t.Fatalf("Docker-Content-Digest %s does not match the body %s", rec.Header().Get("Docker-Content-Digest"), got)
+			}
+			if tc.stored != (string(body) == string(want.Data)) {
+				t.Fatalf("stored = %v, body %s", !tc.stored, body)
+			}
+			var probe struct {
+				MediaType string
+				Config    ocispec.Descriptor
+				Layers    []ocispec.Descriptor
+				Manifests []ocispec.Descriptor
+			}
+			if err := json.Unmarshal(body, &probe); err != nil || probe.MediaType != tc.mediaType {
+				t.Fatalf("body mediaType %q, %v", probe.MediaType, err)
+			}
+			switch probe.MediaType {
+			case schema2.MediaTypeManifest:
+				if probe.Config.MediaType != schema2.MediaTypeImageConfig || probe.Layers[0].MediaType != schema2.MediaTypeLayer {
+					t.Fatalf("descriptors not converted: %s", body)
+				}
+				if strings.Contains(string(body), "annotations") {
+					t.Fatalf("annotations kept in a Docker manifest: %s", body)
+				}
+			case ocispec.MediaTypeImageManifest:
+				if probe.Config.MediaType != ocispec.MediaTypeImageConfig || probe.Layers[0].MediaType != ocispec.MediaTypeImageLayerGzip {
+					t.Fatalf("descriptors not converted: %s", body)
+				}
+			case manifestlist.MediaTypeManifestList:
+				if probe.Manifests[0].Digest != oci.Digest {
+					t.Fatalf("index children changed: %s", body)
+				}
+			}
+		})
+	}
+
+	// Revalidation against the converted digest short circuits
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v2/alice/app/manifests/oci", nil)
+	req.Header.Set("Accept", oldDockerAccept)
+	h.ServeHTTP(rec, req)
+	req.Header.Set("If-None-Match", rec.Header().Get("Etag"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("revalidation status %d, want 304", rec.Code)
+	}
+}
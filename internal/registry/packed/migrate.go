@@ -0,0 +1,208 @@
+package packed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+)
+
+const batchSize = 500
+
+func repositoriesDir(root string) string {
+	return filepath.Join(root, "docker", "registry", "v2", "repositories")
+}
+
+// Moves every link file under root into the store and removes the
+// directories left empty. Run with the server down, links are only
+// deleted from disk once their batch is stored
+func Pack(ctx context.Context, root string, store *stores.Store, progress func(n int)) (int, error) {
+	base := repositoriesDir(root)
+	var batch []db.PackedLink
+	var files, dirs []string
+	packed := 0
+
+	flush := func() error {
+		if err := store.PutPackedLinks(ctx, batch); err != nil {
+			return fmt.Errorf("storing links: %w", err)
+		}
+		for _, f := range files {
+			if err := os.Remove(f); err != nil {
+				return err
+			}
+		}
+		packed += len(batch)
+		batch, files = batch[:0], files[:0]
+		if progress != nil {
+			progress(packed)
+		}
+		return nil
+	}
+
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "_uploads" {
+				return fs.SkipDir
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			dirs = append(dirs, p)
+			return nil
+		}
+		if d.Name() != "link" {
+			return nil
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		batch = append(batch, db.PackedLink{Path: "/" + filepath.ToSlash(rel), Content: content, ModTime: info.ModTime()})
+		files = append(files, p)
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	if err != nil {
+		return packed, err
+	}
+
+	// Children sort after their parent, reversed they empty out first.
+	// Removing a directory that still holds files fails and is skipped
+	slices.Reverse(dirs)
+	for _, dir := range dirs {
+		if dir != base {
+			_ = os.Remove(dir)
+		}
+	}
+	return packed, nil
+}
+
+// Writes every stored link back to its file under root and drops it from
+// the store, the way back to the plain filesystem driver
+func Unpack(ctx context.Context, root string, store *stores.Store, progress func(n int)) (int, error) {
+	unpacked := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return unpacked, err
+		}
+		// Written rows are deleted, so each page starts from the top
+		links, err := store.ListPackedLinks(ctx, "", batchSize)
+		if err != nil {
+			return unpacked, fmt.Errorf("listing links: %w", err)
+		}
+		if len(links) == 0 {
+			return unpacked, nil
+		}
+		paths := make([]string, len(links))
+		for i, l := range links {
+			target := filepath.Join(root, filepath.FromSlash(l.Path))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return unpacked, err
+			}
+			if err := os.WriteFile(target, l.Content, 0644); err != nil {
+				return unpacked, err
+			}
+			_ = os.Chtimes(target, l.ModTime, l.ModTime)
+			paths[i] = l.Path
+		}
+		if err := store.DeletePackedLinkPaths(ctx, paths); err != nil {
+			return unpacked, fmt.Errorf("dropping links: %w", err)
+		}
+		unpacked += len(links)
+		if progress != nil {
+			progress(unpacked)
+		}
+	}
+}
+
+// A layer or manifest revision link held in the store
+type RepoLink struct {
+	Repo     string // namespace/name
+	Manifest bool   // Manifest revision, otherwise a layer
+	Hex      string // sha256 encoded digest
+}
+
+// Layer and manifest revision links in the store, for the code that walks
+// link directories on disk
+func RepoLinks(ctx context.Context, store *stores.Store) ([]RepoLink, error) {
+	return repoLinks(ctx, store, RepoPath(""))
+}
+
+// Links of the repositories under one namespace
+func NamespaceLinks(ctx context.Context, store *stores.Store, namespace string) ([]RepoLink, error) {
+	return repoLinks(ctx, store, RepoPath(namespace+"/"))
+}
+
+func repoLinks(ctx context.Context, store *stores.Store, under string) ([]RepoLink, error) {
+	prefix := RepoPath("")
+	paths, err := store.PackedLinkPaths(ctx, under)
+	if err != nil {
+		return nil, err
+	}
+	var out []RepoLink
+	for _, p := range paths {
+		rest := strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/link")
+		dir, hex := path.Split(rest)
+		switch {
+		case strings.HasSuffix(dir, "/_layers/sha256/"):
+			out = append(out, RepoLink{Repo: strings.TrimSuffix(dir, "/_layers/sha256/"), Hex: hex})
+		case strings.HasSuffix(dir, "/_manifests/revisions/sha256/"):
+			out = append(out, RepoLink{Repo: strings.TrimSuffix(dir, "/_manifests/revisions/sha256/"), Manifest: true, Hex: hex})
+		}
+	}
+	return out, nil
+}
+
+// Storage driver path of a repository's link tree
+func RepoPath(repo string) string {
+	return "/docker/registry/v2/repositories/" + repo
+}
+
+var errFound = errors.New("found")
+
+// Whether any link file is left on disk, stops at the first one
+func HasLinkFiles(root string) (bool, error) {
+	err := filepath.WalkDir(repositoriesDir(root), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() && d.Name() == "_uploads" {
+			return fs.SkipDir
+		}
+		if !d.IsDir() && d.Name() == "link" {
+			return errFound
+		}
+		return nil
+	})
+	if errors.Is(err, errFound) {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,264 @@
+// Storage driver keeping registry link files in the database. Every tag,
+// layer and manifest revision is a tiny file in its own directory on the
+// filesystem driver, millions of them exhaust inodes long before disk
+// space. Blob data and upload sessions stay on disk
+package packed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+)
+
+// Registration name for the registry configuration
+const DriverName = "distroface-packed"
+
+var (
+	registerOnce sync.Once
+	registered   *stores.Store
+)
+
+// Makes the driver available to the registry app, the first store wins
+func Register(store *stores.Store) {
+	registerOnce.Do(func() {
+		registered = store
+		factory.Register(DriverName, driverFactory{})
+	})
+}
+
+type driverFactory struct{}
+
+func (driverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	fs, err := filesystem.FromParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return &Driver{StorageDriver: fs, store: registered}, nil
+}
+
+// Filesystem driver with link files routed to the store
+type Driver struct {
+	storagedriver.StorageDriver
+	store *stores.Store
+}
+
+// Plain filesystem driver over root when store is nil
+func New(root string, store *stores.Store) storagedriver.StorageDriver {
+	fs := filesystem.New(filesystem.DriverParameters{
+		RootDirectory: root,
+		MaxThreads:    100,
+	})
+	if store == nil {
+		return fs
+	}
+	return &Driver{StorageDriver: fs, store: store}
+}
+
+// Distribution names every link file "link", nothing else uses the name
+func IsLink(p string) bool {
+	return path.Base(p) == "link"
+}
+
+func (d *Driver) Name() string {
+	return DriverName
+}
+
+func (d *Driver) GetContent(ctx context.Context, p string) ([]byte, error) {
+	if !IsLink(p) {
+		return d.StorageDriver.GetContent(ctx, p)
+	}
+	l, err := d.store.GetPackedLink(ctx, p)
+	if err != nil {
+		return nil, d.wrap(err)
+	}
+	if l == nil {
+		return nil, storagedriver.PathNotFoundError{Path: p, DriverName: DriverName}
+	}
+	return l.Content, nil
+}
+
+func (d *Driver) PutContent(ctx context.Context, p string, content []byte) error {
+	if !IsLink(p) {
+		return d.StorageDriver.PutContent(ctx, p, content)
+	}
+	return d.wrap(d.store.PutPackedLink(ctx, p, content, time.Now()))
+}
+
+func (d *Driver) Reader(ctx context.Context, p string, offset int64) (io.ReadCloser, error) {
+	if !IsLink(p) {
+		return d.StorageDriver.Reader(ctx, p, offset)
+	}
+	content, err := d.GetContent(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset > int64(len(content)) {
+		return nil, storagedriver.InvalidOffsetError{Path: p, Offset: offset, DriverName: DriverName}
+	}
+	return io.NopCloser(bytes.NewReader(content[offset:])), nil
+}
+
+func (d *Driver) Writer(ctx context.Context, p string, append bool) (storagedriver.FileWriter, error) {
+	if !IsLink(p) {
+		return d.StorageDriver.Writer(ctx, p, append)
+	}
+	w := &linkWriter{driver: d, path: p}
+	if append {
+		content, err := d.GetContent(ctx, p)
+		if err != nil && !errors.As(err, new(storagedriver.PathNotFoundError)) {
+			return nil, err
+		}
+		w.buf.Write(content)
+	}
+	return w, nil
+}
+
+func (d *Driver) Stat(ctx context.Context, p string) (storagedriver.FileInfo, error) {
+	if IsLink(p) {
+		l, err := d.store.GetPackedLink(ctx, p)
+		if err != nil {
+			return nil, d.wrap(err)
+		}
+		if l == nil {
+			return nil, storagedriver.PathNotFoundError{Path: p, DriverName: DriverName}
+		}
+		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path: p, Size: int64(len(l.Content)), ModTime: l.ModTime,
+		}}, nil
+	}
+
+	fi, err := d.StorageDriver.Stat(ctx, p)
+	if !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		return fi, err
+	}
+	// Directories holding only packed links exist just in the store
+	found, serr := d.store.HasPackedLinks(ctx, p)
+	if serr != nil {
+		return nil, d.wrap(serr)
+	}
+	if !found {
+		return nil, err
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path: p, ModTime: time.Now(), IsDir: true,
+	}}, nil
+}
+
+func (d *Driver) List(ctx context.Context, p string) ([]string, error) {
+	keys, err := d.StorageDriver.List(ctx, p)
+	missing := errors.As(err, new(storagedriver.PathNotFoundError))
+	if err != nil && !missing {
+		return nil, err
+	}
+	names, serr := d.store.PackedLinkChildren(ctx, p)
+	if serr != nil {
+		return nil, d.wrap(serr)
+	}
+	if missing && len(names) == 0 {
+		return nil, err
+	}
+	for _, name := range names {
+		if key := path.Join(p, name); !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (d *Driver) Move(ctx context.Context, src, dst string) error {
+	if !IsLink(src) {
+		return d.StorageDriver.Move(ctx, src, dst)
+	}
+	moved, err := d.store.MovePackedLink(ctx, src, dst)
+	if err != nil {
+		return d.wrap(err)
+	}
+	if !moved {
+		return storagedriver.PathNotFoundError{Path: src, DriverName: DriverName}
+	}
+	return nil
+}
+
+// Deletes from both, missing only when neither held anything at p
+func (d *Driver) Delete(ctx context.Context, p string) error {
+	err := d.StorageDriver.Delete(ctx, p)
+	missing := errors.As(err, new(storagedriver.PathNotFoundError))
+	if err != nil && !missing {
+		return err
+	}
+	removed, serr := d.store.DeletePackedLinks(ctx, p)
+	if serr != nil {
+		return d.wrap(serr)
+	}
+	if missing && removed == 0 {
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) RedirectURL(r *http.Request, p string) (string, error) {
+	return "", nil
+}
+
+// The filesystem walk would miss directories that only exist in the store
+func (d *Driver) Walk(ctx context.Context, p string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, d, p, f, options...)
+}
+
+func (d *Driver) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	return storagedriver.Error{DriverName: DriverName, Detail: err}
+}
+
+// Buffers a link write until commit, links are a digest string long
+type linkWriter struct {
+	driver    *Driver
+	path      string
+	buf       bytes.Buffer
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *linkWriter) Write(p []byte) (int, error) {
+	if w.closed || w.committed || w.cancelled {
+		return 0, errors.New("link writer already closed")
+	}
+	return w.buf.Write(p)
+}
+
+func (w *linkWriter) Size() int64 {
+	return int64(w.buf.Len())
+}
+
+func (w *linkWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (w *linkWriter) Cancel(ctx context.Context) error {
+	w.cancelled = true
+	return nil
+}
+
+func (w *linkWriter) Commit(ctx context.Context) error {
+	if w.closed || w.committed || w.cancelled {
+		return errors.New("link writer already closed")
+	}
+	w.committed = true
+	return w.driver.PutContent(ctx, w.path, w.buf.Bytes())
+}
@@ -0,0 +1,304 @@
+package packed
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	regstorage "github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+)
+
+func newTestStore(t testing.TB) *stores.Store {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// Pushes a one layer image, returns its manifest digest
+func push(t *testing.T, reg distribution.Namespace, name, tag string) string {
+	t.Helper()
+	ctx := context.Background()
+	named, _ := reference.WithName(name)
+	repo, err := reg.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := repo.Blobs(ctx)
+	layer, err := blobs.Put(ctx, ocispec.MediaTypeImageLayer, []byte("layer of "+name+":"+tag))
+	if err != nil {
+		t.Fatalf("put layer: %v", err)
+	}
+	layer.MediaType = ocispec.MediaTypeImageLayer
+	b := ocischema.NewManifestBuilder(blobs, []byte(`{"architecture":"amd64","os":"linux"}`), nil)
+	b.AppendReference(layer)
+	m, err := b.Build(ctx)
+	if err != nil {
+		t.Fatalf("build manifest: %v", err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, m, distribution.WithTag(tag))
+	if err != nil {
+		t.Fatalf("put manifest: %v", err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, tag, ocispec.Descriptor{Digest: dgst, MediaType: ocispec.MediaTypeImageManifest}); err != nil {
+		t.Fatalf("tag: %v", err)
+	}
+	return dgst.String()
+}
+
+func tags(t *testing.T, reg distribution.Namespace, name string) []string {
+	t.Helper()
+	ctx := context.Background()
+	named, _ := reference.WithName(name)
+	repo, err := reg.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		return nil
+	}
+	slices.Sort(all)
+	return all
+}
+
+// A registry on the packed driver works without a single link file on disk
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	root, store := t.TempDir(), newTestStore(t)
+	reg, err := regstorage.NewRegistry(ctx, New(root, store), regstorage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push(t, reg, "team/app", "v1")
+	push(t, reg, "team/app", "v2")
+	push(t, reg, "team/tool", "latest")
+
+	if onDisk, err := HasLinkFiles(root); err != nil || onDisk {
+		t.Fatalf("HasLinkFiles = %v, %v, want no link files", onDisk, err)
+	}
+	if got := tags(t, reg, "team/app"); !slices.Equal(got, []string{"v1", "v2"}) {
+		t.Errorf("tags = %v", got)
+	}
+
+	var repos []string
+	err = reg.(distribution.RepositoryEnumerator).Enumerate(ctx, func(name string) error {
+		repos = append(repos, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Enumerate: %v", err)
+	}
+	slices.Sort(repos)
+	if !slices.Equal(repos, []string{"team/app", "team/tool"}) {
+		t.Errorf("repositories = %v", repos)
+	}
+
+	links, err := RepoLinks(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := 0
+	for _, l := range links {
+		if l.Manifest {
+			manifests++
+		}
+	}
+	if manifests != 3 {
+		t.Errorf("manifest revision links = %d, want 3", manifests)
+	}
+
+	named, _ := reference.WithName("team/app")
+	repo, _ := reg.Repository(ctx, named)
+	if err := repo.Tags(ctx).Untag(ctx, "v1"); err != nil {
+		t.Fatalf("Untag: %v", err)
+	}
+	if got := tags(t, reg, "team/app"); !slices.Equal(got, []string{"v2"}) {
+		t.Errorf("tags after untag = %v", got)
+	}
+}
+
+// Directories and files on disk merge with the links below them
+func TestDriverMerge(t *testing.T) {
+	ctx := context.Background()
+	root, store := t.TempDir(), newTestStore(t)
+	d := New(root, store)
+
+	if err := d.PutContent(ctx, "/repo/_uploads/u1/startedat", []byte("now")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, "/repo/_layers/sha256/aa/link", []byte("sha256:aa")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "repo", "_layers")); !os.IsNotExist(err) {
+		t.Errorf("link created a directory on disk: %v", err)
+	}
+
+	keys, err := d.List(ctx, "/repo")
+	slices.Sort(keys)
+	if err != nil || !slices.Equal(keys, []string{"/repo/_layers", "/repo/_uploads"}) {
+		t.Errorf("List = %v, %v", keys, err)
+	}
+	if fi, err := d.Stat(ctx, "/repo/_layers/sha256"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat of a packed directory = %v, %v", fi, err)
+	}
+
+	if err := d.Move(ctx, "/repo/_layers/sha256/aa/link", "/repo/_layers/sha256/bb/link"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := d.GetContent(ctx, "/repo/_layers/sha256/bb/link"); err != nil || string(b) != "sha256:aa" {
+		t.Errorf("moved link = %q, %v", b, err)
+	}
+
+	if err := d.Delete(ctx, "/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, "/repo/_layers"); !notFound(err) {
+		t.Errorf("Stat after delete = %v, want not found", err)
+	}
+	if err := d.Delete(ctx, "/repo"); !notFound(err) {
+		t.Errorf("second Delete = %v, want not found", err)
+	}
+}
+
+func notFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
+
+func writeLinks(t testing.TB, root string, repos, links int) {
+	t.Helper()
+	for r := range repos {
+		for l := range links {
+			hex := fmt.Sprintf("%064x", r*links+l)
+			dir := filepath.Join(repositoriesDir(root), "team", fmt.Sprintf("app%d", r), "_layers", "sha256", hex)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "link"), []byte("sha256:"+hex), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// Packing empties the link tree and unpacking restores it byte for byte
+func TestPackUnpack(t *testing.T) {
+	ctx := context.Background()
+	root, store := t.TempDir(), newTestStore(t)
+	writeLinks(t, root, 3, 400)
+	upload := filepath.Join(repositoriesDir(root), "team", "app0", "_uploads", "u1", "data")
+	if err := os.MkdirAll(filepath.Dir(upload), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(upload, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Pack(ctx, root, store, nil)
+	if err != nil || n != 1200 {
+		t.Fatalf("Pack = %d, %v", n, err)
+	}
+	if onDisk, _ := HasLinkFiles(root); onDisk {
+		t.Error("link files left after packing")
+	}
+	if _, err := os.Stat(filepath.Join(repositoriesDir(root), "team", "app1")); !os.IsNotExist(err) {
+		t.Errorf("empty link directories survived: %v", err)
+	}
+	if _, err := os.Stat(upload); err != nil {
+		t.Errorf("upload session removed: %v", err)
+	}
+	hex := fmt.Sprintf("%064x", 401)
+	linkPath := RepoPath("team/app1") + "/_layers/sha256/" + hex + "/link"
+	if b, err := New(root, store).GetContent(ctx, linkPath); err != nil || string(b) != "sha256:"+hex {
+		t.Errorf("packed link = %q, %v", b, err)
+	}
+
+	if n, err := Unpack(ctx, root, store, nil); err != nil || n != 1200 {
+		t.Fatalf("Unpack = %d, %v", n, err)
+	}
+	if found, _ := store.HasPackedLinks(ctx, "/"); found {
+		t.Error("links left in the store after unpacking")
+	}
+	b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(linkPath)))
+	if err != nil || string(b) != "sha256:"+hex {
+		t.Errorf("unpacked link = %q, %v", b, err)
+	}
+}
+
+// Link operations on both drivers, the filesystem pays an inode and a
+// directory per link where the store pays a row
+func BenchmarkLinks(b *testing.B) {
+	drivers := map[string]func(b *testing.B) storagedriver.StorageDriver{
+		"filesystem": func(b *testing.B) storagedriver.StorageDriver { return New(b.TempDir(), nil) },
+		"packed":     func(b *testing.B) storagedriver.StorageDriver { return New(b.TempDir(), newTestStore(b)) },
+	}
+	for _, name := range []string{"filesystem", "packed"} {
+		b.Run(name+"/put", func(b *testing.B) {
+			d, ctx := drivers[name](b), context.Background()
+			for i := range b.N {
+				p := fmt.Sprintf("/docker/registry/v2/repositories/team/app/_layers/sha256/%064x/link", i)
+				if err := d.PutContent(ctx, p, []byte("sha256:")); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/get", func(b *testing.B) {
+			d, ctx := drivers[name](b), context.Background()
+			const links = 1000
+			for i := range links {
+				d.PutContent(ctx, fmt.Sprintf("/r/_layers/sha256/%064x/link", i), []byte("sha256:"))
+			}
+			b.ResetTimer()
+			for i := range b.N {
+				if _, err := d.GetContent(ctx, fmt.Sprintf("/r/_layers/sha256/%064x/link", i%links)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/list", func(b *testing.B) {
+			d, ctx := drivers[name](b), context.Background()
+			for i := range 1000 {
+				d.PutContent(ctx, fmt.Sprintf("/r/_manifests/tags/t%d/current/link", i), []byte("sha256:"))
+			}
+			b.ResetTimer()
+			for range b.N {
+				keys, err := d.List(ctx, "/r/_manifests/tags")
+				if err != nil || len(keys) != 1000 {
+					b.Fatalf("List = %d, %v", len(keys), err)
+				}
+			}
+		})
+	}
+}
+
+// Packing throughput, links per second is what sizes a maintenance window
+func BenchmarkPack(b *testing.B) {
+	for range b.N {
+		b.StopTimer()
+		root, store := b.TempDir(), newTestStore(b)
+		writeLinks(b, root, 10, 100)
+		b.StartTimer()
+		if _, err := Pack(context.Background(), root, store, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/nickheyer/distroface/pkg/utils"
+	"github.com/opencontainers/go-digest"
+)
+
+// One blob a tag's manifest tree references
+type TagBlob struct {
+	Digest    string
+	MediaType string
+	Size      int64 // Zero while missing
+	Present   bool  // Linked to the repository and on disk
+}
+
+// Manifest a tag points at and every blob beneath it, manifests first.
+// Children of a missing manifest are unknown until it is fetched
+func (r *RegistryAccess) TagBlobs(ctx context.Context, namespace, name, tag string) (digest.Digest, []TagBlob, error) {
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return "", nil, err
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("accessing manifest service: %w", err)
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, tag)
+	if err != nil {
+		return "", nil, fmt.Errorf("tag not found: %w", err)
+	}
+
+	targets := map[digest.Digest]string{}
+	collectManifestBlobs(ctx, manifests, desc.Digest, desc.MediaType, targets)
+	blobs := repo.Blobs(ctx)
+	out := make([]TagBlob, 0, len(targets))
+	for d, mediaType := range targets {
+		b := TagBlob{Digest: d.String(), MediaType: mediaType}
+		if utils.IsManifestMediaType(mediaType) {
+			b.Present, _ = manifests.Exists(ctx, d)
+		} else {
+			_, err := blobs.Stat(ctx, d)
+			b.Present = err == nil
+		}
+		if b.Present {
+			b.Size = r.blobSize(d)
+		}
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		mi, mj := utils.IsManifestMediaType(out[i].MediaType), utils.IsManifestMediaType(out[j].MediaType)
+		if mi != mj {
+			return mi
+		}
+		return out[i].Digest < out[j].Digest
+	})
+	return desc.Digest, out, nil
+}
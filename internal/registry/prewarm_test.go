@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// A blob gone from disk shows up missing, the rest stay present
+func TestTagBlobs(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	access, err := NewRegistryAccess(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, layer := []byte(`{"architecture":"amd64","os":"linux"}`), []byte("layer bytes")
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    []ocispec.Descriptor{{MediaType: ocispec.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := access.PutManifest(ctx, "alice", "app", map[string][]byte{
+		ocispec.MediaTypeImageConfig: config,
+		ocispec.MediaTypeImageLayer:  layer,
+	}, manifest, "1.0")
+	if err != nil {
+		t.Fatalf("PutManifest: %v", err)
+	}
+
+	present := func() map[string]bool {
+		t.Helper()
+		dgst, blobs, err := access.TagBlobs(ctx, "alice", "app", "1.0")
+		if err != nil {
+			t.Fatalf("TagBlobs: %v", err)
+		}
+		if dgst != desc.Digest {
+			t.Fatalf("digest = %s, want %s", dgst, desc.Digest)
+		}
+		if blobs[0].Digest != desc.Digest.String() {
+			t.Fatalf("manifest not listed first: %+v", blobs)
+		}
+		out := map[string]bool{}
+		for _, b := range blobs {
+			out[b.Digest] = b.Present
+		}
+		return out
+	}
+	got := present()
+	if len(got) != 3 || !got[desc.Digest.String()] || !got[digest.FromBytes(config).String()] || !got[digest.FromBytes(layer).String()] {
+		t.Fatalf("complete image = %v", got)
+	}
+
+	hex := digest.FromBytes(layer).Encoded()
+	if err := os.RemoveAll(filepath.Join(root, "docker", "registry", "v2", "blobs", "sha256", hex[:2], hex)); err != nil {
+		t.Fatal(err)
+	}
+	got = present()
+	if got[digest.FromBytes(layer).String()] || !got[digest.FromBytes(config).String()] {
+		t.Fatalf("after removing the layer = %v", got)
+	}
+
+	if _, _, err := access.TagBlobs(ctx, "alice", "app", "2.0"); err == nil {
+		t.Fatal("unknown tag resolved")
+	}
+}
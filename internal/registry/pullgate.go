@@ -0,0 +1,182 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/audit"
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/utils"
+)
+
+const maxPullRestrictionEntries = 100
+
+var contentPathRe = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs)/([^/]+)$`)
+
+// Decodes a stored pull restriction, nil when none is set
+func ParsePullRestriction(raw string) *v1.PullRestriction {
+	if raw == "" {
+		return nil
+	}
+	var p v1.PullRestriction
+	if err := protojson.Unmarshal([]byte(raw), &p); err != nil || proto.Size(&p) == 0 {
+		return nil
+	}
+	return &p
+}
+
+// Validates and encodes a pull restriction, an empty one encodes to ""
+func EncodePullRestriction(p *v1.PullRestriction) (string, error) {
+	if p == nil || proto.Size(p) == 0 {
+		return "", nil
+	}
+	if len(p.Users)+len(p.UserAgents) > maxPullRestrictionEntries {
+		return "", fmt.Errorf("pull restriction holds at most %d entries", maxPullRestrictionEntries)
+	}
+	for _, u := range p.Users {
+		if strings.TrimSpace(u) == "" {
+			return "", fmt.Errorf("pull restriction users must not be empty")
+		}
+	}
+	for _, ua := range p.UserAgents {
+		if strings.Trim(ua, "* ") == "" {
+			return "", fmt.Errorf("user agent pattern %q matches every client", ua)
+		}
+	}
+	raw, err := protojson.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// Reports whether a caller satisfies the restriction, nil allows all
+func PullAllowed(p *v1.PullRestriction, username, userAgent string) bool {
+	if p == nil {
+		return true
+	}
+	if len(p.Users) > 0 && (username == "" || !slices.Contains(p.Users, username)) {
+		return false
+	}
+	if len(p.UserAgents) > 0 && !slices.ContainsFunc(p.UserAgents, func(pattern string) bool {
+		return globMatch(pattern, userAgent)
+	}) {
+		return false
+	}
+	return true
+}
+
+// Case insensitive glob where * also crosses slashes, user agents carry them
+func globMatch(pattern, s string) bool {
+	re := "(?i)^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	ok, err := regexp.MatchString(re, s)
+	return err == nil && ok
+}
+
+// Refuses manifest and blob downloads the repo pull restriction does not
+// cover. HEAD stays open so pushers can still check for existing blobs.
+// Mounts read the source repo too, a refused one falls back to an upload
+func RestrictPulls(next http.Handler, store *stores.Store, verifier SubjectVerifier, recorder *audit.Recorder, log *logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && blobSessionRe.MatchString(r.URL.Path) {
+			if from, ok := mountSource(r); ok {
+				namespace, name := utils.SplitRepoName(from)
+				repo, err := store.GetRepository(r.Context(), namespace, name)
+				if err == nil && repo != nil && !PullAllowed(ParsePullRestriction(repo.PullRestriction), bearerSubject(r, verifier), r.UserAgent()) {
+					log.Warn("registry: mount from %s refused by its pull restriction, falling back to an upload", from)
+					dropMount(r)
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		m := contentPathRe.FindStringSubmatch(r.URL.Path)
+		if r.Method != http.MethodGet || m == nil || m[3] == "uploads" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		namespace, name := utils.SplitRepoName(m[1])
+		if namespace == "" || name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		repo, err := store.GetRepository(r.Context(), namespace, name)
+		if err != nil || repo == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		restriction := ParsePullRestriction(repo.PullRestriction)
+		if restriction == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		username := bearerSubject(r, verifier)
+		if PullAllowed(restriction, username, r.UserAgent()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log.Warn("registry: pull of %s/%s by %q (%s) refused by the repo pull restriction", namespace, name, username, r.UserAgent())
+		if recorder != nil {
+			recorder.Record(r.Context(), audit.Event{
+				Action:   "Registry/pull",
+				Resource: "registry",
+				Outcome:  audit.OutcomeDenied,
+				Detail:   fmt.Sprintf("%s/%s %s %s: not on the pull allowlist (user agent %q)", namespace, name, m[2], m[3], r.UserAgent()),
+				SourceIP: admin.ClientIP(r.RemoteAddr, r.Header),
+				Actor:    username,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"DENIED","message":"this repository only serves pulls to its allowlisted clients"}]}`))
+	})
+}
+
+// Unverified tokens leave the caller anonymous, distribution rejects them after
+func bearerSubject(r *http.Request, verifier SubjectVerifier) string {
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		return user.Username
+	}
+	if raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && verifier != nil {
+		if sub, err := verifier.VerifyTokenSubject(strings.TrimSpace(raw)); err == nil {
+			return sub
+		}
+	}
+	return ""
+}
+
+// Source repo of a cross repository blob mount, POST ?mount=<digest>&from=<repo>
+func mountSource(r *http.Request) (string, bool) {
+	q := r.URL.Query()
+	from := q.Get("from")
+	return from, from != "" && q.Get("mount") != ""
+}
+
+// Turns a mount into a plain upload session, the client then pushes the
+// blob itself as the distribution spec allows
+func dropMount(r *http.Request) {
+	q := r.URL.Query()
+	q.Del("mount")
+	q.Del("from")
+	r.URL.RawQuery = q.Encode()
+}
+
+// Rewrites the mount source, leaving the rest of the query alone
+func setMountSource(r *http.Request, from string) {
+	q := r.URL.Query()
+	q.Set("from", from)
+	r.URL.RawQuery = q.Encode()
+}
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Treats the raw bearer as the subject
+type subjectEcho struct{}
+
+func (subjectEcho) VerifyTokenSubject(raw string) (string, error) {
+	if raw == "bad" {
+		return "", errors.New("bad token")
+	}
+	return raw, nil
+}
+
+func TestPullAllowed(t *testing.T) {
+	p := &v1.PullRestriction{Users: []string{"deployer"}, UserAgents: []string{"containerd/*"}}
+	cases := []struct {
+		user, ua string
+		want     bool
+	}{
+		{"deployer", "containerd/1.7.2", true},
+		{"deployer", "Containerd/2.0 go/1.22", true},
+		{"deployer", "docker/24.0.7 go/go1.20", false},
+		{"alice", "containerd/1.7.2", false},
+		{"", "containerd/1.7.2", false},
+	}
+	for _, c := range cases {
+		if got := PullAllowed(p, c.user, c.ua); got != c.want {
+			t.Errorf("PullAllowed(%q, %q) = %v, want %v", c.user, c.ua, got, c.want)
+		}
+	}
+	if !PullAllowed(nil, "", "") {
+		t.Error("unrestricted repo refused a pull")
+	}
+	if _, err := EncodePullRestriction(&v1.PullRestriction{UserAgents: []string{"*"}}); err == nil {
+		t.Error("match-all user agent pattern accepted")
+	}
+}
+
+// Restricted repos refuse GETs from other callers but keep HEAD open
+func TestRestrictPulls(t *testing.T) {
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	raw, err := EncodePullRestriction(&v1.PullRestriction{Users: []string{"deployer"}})
+	if err != nil {
+		t.Fatalf("EncodePullRestriction: %v", err)
+	}
+	ctx := context.Background()
+	for _, r := range []*storage.Repository{
+		{ID: "1", Namespace: "acme", Name: "prod", PullRestriction: raw},
+		{ID: "2", Namespace: "acme", Name: "dev"},
+	} {
+		if err := store.CreateRepository(ctx, r); err != nil {
+			t.Fatalf("CreateRepository: %v", err)
+		}
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := RestrictPulls(next, store, subjectEcho{}, nil, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	do := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	digestPath := "/blobs/sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for _, c := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/v2/acme/prod/manifests/latest", "deployer", http.StatusOK},
+		{"GET", "/v2/acme/prod" + digestPath, "deployer", http.StatusOK},
+		{"GET", "/v2/acme/prod/manifests/latest", "alice", http.StatusForbidden},
+		{"GET", "/v2/acme/prod" + digestPath, "", http.StatusForbidden},
+		{"GET", "/v2/acme/prod/manifests/latest", "bad", http.StatusForbidden},
+		{"HEAD", "/v2/acme/prod" + digestPath, "alice", http.StatusOK},
+		{"GET", "/v2/acme/prod/blobs/uploads/abc", "alice", http.StatusOK},
+		{"GET", "/v2/acme/dev/manifests/latest", "alice", http.StatusOK},
+	} {
+		if got := do(c.method, c.path, c.token); got != c.want {
+			t.Errorf("%s %s as %q = %d, want %d", c.method, c.path, c.token, got, c.want)
+		}
+	}
+
+	// Mounting reads the source, refused callers fall back to an upload
+	var query string
+	h = RestrictPulls(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { query = r.URL.RawQuery }), store, subjectEcho{}, nil, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	for _, c := range []struct {
+		from, token string
+		mounted     bool
+	}{
+		{"acme/prod", "deployer", true},
+		{"acme/prod", "alice", false},
+		{"acme/dev", "alice", true},
+		{"acme/missing", "alice", true},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v2/alice/app/blobs/uploads/?mount=sha256:abc&from="+c.from, nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if mounted := query != ""; mounted != c.mounted {
+			t.Errorf("mount from %s as %q reached with query %q, want mounted %v", c.from, c.token, query, c.mounted)
+		}
+	}
+}
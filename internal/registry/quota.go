@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/nickheyer/distroface/pkg/utils"
+)
+
+// Caps the bytes one repo may hold, zero means unlimited
+type RepoQuota interface {
+	RepoQuotaBytes(ctx context.Context, namespace, name string) int64
+}
+
+// Refuses an upload of size bytes that would take the repo past its quota,
+// blobs the repo already links add nothing
+func (b *observedBlobs) checkQuota(ctx context.Context, dgst digest.Digest, size int64) error {
+	if b.obs.quota == nil {
+		return nil
+	}
+	namespace, name := utils.SplitRepoName(b.repo.Name())
+	limit := b.obs.quota.RepoQuotaBytes(ctx, namespace, name)
+	enum, ok := b.BlobStore.(distribution.BlobEnumerator)
+	if limit <= 0 || !ok {
+		return nil
+	}
+	var used int64
+	linked := false
+	err := enum.Enumerate(ctx, func(d digest.Digest) error {
+		if d == dgst {
+			linked = true
+		}
+		if desc, err := b.BlobStore.Stat(ctx, d); err == nil {
+			used += desc.Size
+		}
+		return nil
+	})
+	var missing storagedriver.PathNotFoundError
+	if errors.As(err, &missing) {
+		err = nil // First push to the repo
+	}
+	if err != nil || linked {
+		return err
+	}
+	if used+size > limit {
+		return errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf("quota exceeded: %s holds %d of its %d bytes, the upload needs %d more", b.repo.Name(), used, limit, size))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+type fixedQuota int64
+
+func (q fixedQuota) RepoQuotaBytes(context.Context, string, string) int64 { return int64(q) }
+
+// Blob uploads past the repo quota are refused when they complete
+func TestBlobUploadQuota(t *testing.T) {
+	access, err := NewRegistryAccess(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+	base, err := access.repository(ctx, "alice", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs := &observer{store: store, log: logger.NewWithConfig(&logger.Config{Enabled: false}), quota: fixedQuota(10)}
+	blobs := (&observedRepo{Repository: base, obs: obs}).Blobs(ctx)
+
+	upload := func(content string) error {
+		t.Helper()
+		w, err := blobs.Create(ctx)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		_, err = w.Commit(ctx, distribution.Descriptor{Digest: digest.FromString(content)})
+		if err != nil {
+			w.Cancel(ctx)
+		}
+		return err
+	}
+
+	if err := upload("sixsix"); err != nil {
+		t.Fatalf("first layer: %v", err)
+	}
+	if err := upload("sixsix"); err != nil {
+		t.Fatalf("layer the repo already holds: %v", err)
+	}
+	if err := upload("fivex"); err == nil {
+		t.Fatal("layer past the quota was accepted")
+	}
+	if _, err := blobs.Stat(ctx, digest.FromString("fivex")); err == nil {
+		t.Fatal("refused layer was linked")
+	}
+	if err := upload("four"); err != nil {
+		t.Fatalf("layer within the quota: %v", err)
+	}
+
+	obs.quota = fixedQuota(0)
+	if err := upload("unlimited now"); err != nil {
+		t.Fatalf("unlimited repo: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/nickheyer/distroface/pkg/utils"
+	"github.com/opencontainers/go-digest"
+)
+
+// One blob of a repository or image and whoever else still references it
+type BlobShare struct {
+	Digest    string
+	Size      int64
+	MediaType string   // Empty in repository mode, links carry no type
+	Repos     []string // Other repositories linking the blob, namespace/name
+	Tags      []string // Other tags of the same repository, image mode only
+}
+
+// Blobs a repository links, or with tag set the blobs that tag's manifest
+// tree references, each with the other repos and tags still using it.
+// Largest first.
+func (r *RegistryAccess) BlobSharing(ctx context.Context, namespace, name, tag string) ([]BlobShare, error) {
+	repoName := namespace + "/" + name
+	repoRef, err := reference.WithName(repoName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository name: %w", err)
+	}
+
+	// digest -> media type
+	targets := map[digest.Digest]string{}
+	tagsByBlob := map[digest.Digest][]string{}
+	if tag != "" {
+		repo, err := r.registry.Repository(ctx, repoRef)
+		if err != nil {
+			return nil, fmt.Errorf("accessing repository: %w", err)
+		}
+		manifests, err := repo.Manifests(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("accessing manifest service: %w", err)
+		}
+		tagService := repo.Tags(ctx)
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			return nil, fmt.Errorf("tag not found: %w", err)
+		}
+		collectManifestBlobs(ctx, manifests, desc.Digest, desc.MediaType, targets)
+
+		// Blobs another tag still pulls in survive this tag's deletion
+		others, _ := tagService.All(ctx)
+		for _, other := range others {
+			if other == tag {
+				continue
+			}
+			od, err := tagService.Get(ctx, other)
+			if err != nil {
+				continue
+			}
+			used := map[digest.Digest]string{}
+			collectManifestBlobs(ctx, manifests, od.Digest, od.MediaType, used)
+			for d := range used {
+				if _, ok := targets[d]; ok {
+					tagsByBlob[d] = append(tagsByBlob[d], other)
+				}
+			}
+		}
+	}
+
+	links, err := r.linkingRepos(ctx, repoName, targets, tag == "")
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]BlobShare, 0, len(targets))
+	for d, mediaType := range targets {
+		share := BlobShare{
+			Digest:    d.String(),
+			Size:      r.blobSize(d),
+			MediaType: mediaType,
+			Tags:      tagsByBlob[d],
+		}
+		for _, repo := range links[d] {
+			if repo != repoName {
+				share.Repos = append(share.Repos, repo)
+			}
+		}
+		out = append(out, share)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Size != out[j].Size {
+			return out[i].Size > out[j].Size
+		}
+		return out[i].Digest < out[j].Digest
+	})
+	return out, nil
+}
+
+// Manifest, config and layer digests reachable from one manifest. Missing
+// children are skipped, partial mirrors still report what they hold.
+func collectManifestBlobs(ctx context.Context, manifests distribution.ManifestService, dgst digest.Digest, mediaType string, out map[digest.Digest]string) {
+	if _, seen := out[dgst]; seen {
+		return
+	}
+	out[dgst] = mediaType
+	manifest, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		return
+	}
+	if mt, _, err := manifest.Payload(); err == nil && mt != "" {
+		out[dgst] = mt
+	}
+	for _, ref := range manifest.References() {
+		if utils.IsManifestMediaType(ref.MediaType) {
+			collectManifestBlobs(ctx, manifests, ref.Digest, ref.MediaType, out)
+		} else if _, seen := out[ref.Digest]; !seen {
+			out[ref.Digest] = ref.MediaType
+		}
+	}
+}
+
+func repoFromLinkDir(root, dir string) string {
+	rel, _ := filepath.Rel(root, filepath.Dir(dir))
+	return filepath.ToSlash(rel)
+}
+
+func dedupeSorted(s []string) []string {
+	sort.Strings(s)
+	out := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Bytes a manifest and everything it references hold, shared blobs included
+func (r *RegistryAccess) ManifestSize(ctx context.Context, namespace, name, ref string) (int64, error) {
+	desc, err := r.ResolveManifest(ctx, namespace, name, ref)
+	if err != nil {
+		return 0, err
+	}
+	repo, err := r.repository(ctx, namespace, name)
+	if err != nil {
+		return 0, err
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("accessing manifest service: %w", err)
+	}
+	blobs := map[digest.Digest]string{}
+	collectManifestBlobs(ctx, manifests, desc.Digest, desc.MediaType, blobs)
+	var total int64
+	for d := range blobs {
+		total += r.blobSize(d)
+	}
+	return total, nil
+}
+
+// Bytes the repos under namespace link, each blob counted once
+func (r *RegistryAccess) NamespaceSize(ctx context.Context, namespace string) (int64, error) {
+	links, err := r.scanLinks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	seen := map[string]bool{}
+	var total int64
+	for _, l := range links {
+		if !strings.HasPrefix(l.Repo, namespace+"/") || seen[l.Digest] {
+			continue
+		}
+		seen[l.Digest] = true
+		total += r.blobSize(digest.Digest(l.Digest))
+	}
+	return total, nil
+}
+
+// Bytes on disk, zero when the blob itself is gone
+func (r *RegistryAccess) blobSize(d digest.Digest) int64 {
+	if d.Validate() != nil {
+		return 0
+	}
+	hexDigest := d.Encoded()
+	info, err := os.Stat(filepath.Join(r.storagePath, "docker", "registry", "v2", "blobs", d.Algorithm().String(), hexDigest[:2], hexDigest, "data"))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
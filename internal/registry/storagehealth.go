@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+
+	"github.com/nickheyer/distroface/internal/admin"
+)
+
+// Storage middleware timing every driver call of the registry app
+const healthMiddleware = "distroface-health"
+
+// Driver path the probe writes, outside the docker tree distribution walks
+const probePath = "/distroface/health-probe"
+
+var storageHealth *admin.StorageHealth
+
+// Feeds registry driver calls to h and probes through the same driver.
+// Must be called before handlers.NewApp
+func RegisterStorageHealth(h *admin.StorageHealth) {
+	storageHealth = h
+}
+
+func init() {
+	storagemiddleware.Register(healthMiddleware, func(_ context.Context, d storagedriver.StorageDriver, _ map[string]any) (storagedriver.StorageDriver, error) {
+		if storageHealth == nil {
+			return d, nil
+		}
+		storageHealth.UseProbe(func(ctx context.Context) error { return probeDriver(ctx, d) })
+		return &healthDriver{StorageDriver: d, health: storageHealth}, nil
+	})
+}
+
+func probeDriver(ctx context.Context, d storagedriver.StorageDriver) error {
+	want := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := d.PutContent(ctx, probePath, want); err != nil {
+		return fmt.Errorf("writing probe: %w", err)
+	}
+	got, err := d.GetContent(ctx, probePath)
+	if err != nil {
+		return fmt.Errorf("reading probe: %w", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("probe read back %d bytes that differ from the %d written", len(got), len(want))
+	}
+	if err := d.Delete(ctx, probePath); err != nil {
+		return fmt.Errorf("deleting probe: %w", err)
+	}
+	return nil
+}
+
+// Driver calls timed into the health, Walk is left alone since a full
+// walk for gc legitimately runs long
+type healthDriver struct {
+	storagedriver.StorageDriver
+	health *admin.StorageHealth
+}
+
+// Deferred with &err so the call's named result is read once it returns
+func (d *healthDriver) observe(write bool, start time.Time, err *error) {
+	d.health.Observe(write, time.Since(start), backendErr(*err))
+}
+
+// Missing paths and bad offsets are answers, not backend faults
+func backendErr(err error) error {
+	switch {
+	case err == nil,
+		errors.As(err, new(storagedriver.PathNotFoundError)),
+		errors.As(err, new(storagedriver.InvalidPathError)),
+		errors.As(err, new(storagedriver.InvalidOffsetError)),
+		errors.As(err, new(storagedriver.ErrUnsupportedMethod)),
+		errors.Is(err, context.Canceled):
+		return nil
+	}
+	return err
+}
+
+func (d *healthDriver) GetContent(ctx context.Context, p string) (b []byte, err error) {
+	defer d.observe(false, time.Now(), &err)
+	return d.StorageDriver.GetContent(ctx, p)
+}
+
+func (d *healthDriver) PutContent(ctx context.Context, p string, content []byte) (err error) {
+	defer d.observe(true, time.Now(), &err)
+	return d.StorageDriver.PutContent(ctx, p, content)
+}
+
+func (d *healthDriver) Reader(ctx context.Context, p string, offset int64) (r io.ReadCloser, err error) {
+	defer d.observe(false, time.Now(), &err)
+	return d.StorageDriver.Reader(ctx, p, offset)
+}
+
+func (d *healthDriver) Writer(ctx context.Context, p string, append bool) (w storagedriver.FileWriter, err error) {
+	defer d.observe(true, time.Now(), &err)
+	return d.StorageDriver.Writer(ctx, p, append)
+}
+
+func (d *healthDriver) Stat(ctx context.Context, p string) (fi storagedriver.FileInfo, err error) {
+	defer d.observe(false, time.Now(), &err)
+	return d.StorageDriver.Stat(ctx, p)
+}
+
+func (d *healthDriver) List(ctx context.Context, p string) (entries []string, err error) {
+	defer d.observe(false, time.Now(), &err)
+	return d.StorageDriver.List(ctx, p)
+}
+
+func (d *healthDriver) Move(ctx context.Context, src, dst string) (err error) {
+	defer d.observe(true, time.Now(), &err)
+	return d.StorageDriver.Move(ctx, src, dst)
+}
+
+func (d *healthDriver) Delete(ctx context.Context, p string) (err error) {
+	defer d.observe(true, time.Now(), &err)
+	return d.StorageDriver.Delete(ctx, p)
+}
+
+// Refuses pushes with 503 while the registry storage breaker is open, so
+// clients back off instead of hanging on a stuck backend. Pulls still try
+func GuardUploads(next http.Handler, h *admin.StorageHealth) http.Handler {
+	if h == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		push := false
+		switch r.Method {
+		case http.MethodPost, http.MethodPatch:
+			push = blobSessionRe.MatchString(r.URL.Path)
+		case http.MethodPut:
+			push = blobSessionRe.MatchString(r.URL.Path) || manifestPathRe.MatchString(r.URL.Path)
+		}
+		if push {
+			if err := h.Available(); err != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(int(h.RetryAfter().Seconds())))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(`{"errors":[{"code":"UNAVAILABLE","message":"registry storage is degraded, retry later"}]}`))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
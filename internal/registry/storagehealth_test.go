@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+
+	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+type flakyDriver struct {
+	storagedriver.StorageDriver
+	down bool
+}
+
+func (d *flakyDriver) PutContent(ctx context.Context, p string, content []byte) error {
+	if d.down {
+		return errors.New("backend unreachable")
+	}
+	return d.StorageDriver.PutContent(ctx, p, content)
+}
+
+// Failed driver calls open the breaker and pushes get 503 with Retry-After,
+// misses and pulls pass, and only a passing probe closes it again
+func TestStorageHealthBreaker(t *testing.T) {
+	ctx := context.Background()
+	raw := &flakyDriver{StorageDriver: inmemory.New()}
+	health := admin.NewStorageHealth("registry", func(ctx context.Context) error { return probeDriver(ctx, raw) }, logger.NewWithConfig(&logger.Config{Enabled: false}))
+	d := &healthDriver{StorageDriver: raw, health: health}
+
+	var reached int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached++ })
+	h := GuardUploads(next, health)
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	for range 5 {
+		if _, err := d.GetContent(ctx, "/missing"); err == nil {
+			t.Fatal("GetContent of a missing path succeeded")
+		}
+	}
+	if err := health.Available(); err != nil {
+		t.Fatalf("not found misses opened the breaker: %v", err)
+	}
+
+	raw.down = true
+	for range 3 {
+		if err := d.PutContent(ctx, "/blob", []byte("x")); err == nil {
+			t.Fatal("PutContent on a down backend succeeded")
+		}
+	}
+	if !errors.Is(health.Available(), admin.ErrStorageUnavailable) {
+		t.Fatal("breaker still closed after repeated write failures")
+	}
+	if st := health.Status(); st.Writes.Calls != 3 || st.Writes.Errors != 3 || st.Reads.Errors != 0 {
+		t.Errorf("status counted reads %+v writes %+v", st.Reads, st.Writes)
+	}
+
+	for _, c := range []struct{ method, path string }{
+		{http.MethodPost, "/v2/acme/app/blobs/uploads/"},
+		{http.MethodPatch, "/v2/acme/app/blobs/uploads/abc"},
+		{http.MethodPut, "/v2/acme/app/blobs/uploads/abc"},
+		{http.MethodPut, "/v2/acme/app/manifests/latest"},
+	} {
+		rec := serve(c.method, c.path)
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s %s answered %d retry %q, want 503 with Retry-After", c.method, c.path, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+	if reached != 0 {
+		t.Errorf("%d pushes reached the registry while degraded", reached)
+	}
+	serve(http.MethodGet, "/v2/acme/app/manifests/latest")
+	if reached != 1 {
+		t.Error("pull did not reach the registry while degraded")
+	}
+
+	if err := health.Probe(ctx); err == nil {
+		t.Fatal("probe passed against a down backend")
+	}
+	raw.down = false
+	if _, err := d.GetContent(ctx, "/missing"); err == nil {
+		t.Fatal("GetContent of a missing path succeeded")
+	}
+	if health.Available() == nil {
+		t.Fatal("a live call closed the breaker before any probe passed")
+	}
+	if err := health.Probe(ctx); err != nil {
+		t.Fatalf("probe against a recovered backend: %v", err)
+	}
+	if err := health.Available(); err != nil {
+		t.Fatalf("breaker still open after a passing probe: %v", err)
+	}
+	if rec := serve(http.MethodPost, "/v2/acme/app/blobs/uploads/"); rec.Code == http.StatusServiceUnavailable || reached != 2 {
+		t.Errorf("push after recovery answered %d", rec.Code)
+	}
+}
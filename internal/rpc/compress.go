@@ -0,0 +1,237 @@
+package rpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Negotiated response compression for the json api routes
+type CompressionOptions struct {
+	MinSize      int      // Smaller bodies go out as is
+	ContentTypes []string // Media types worth compressing, type/* matches the whole type
+}
+
+func (o *CompressionOptions) compressible(contentType string) bool {
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range o.ContentTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == media || (strings.HasSuffix(t, "/*") && strings.HasPrefix(media, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	flateWriters = sync.Pool{New: func() any { w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression); return w }}
+)
+
+// Picks gzip or deflate from Accept-Encoding, gzip when both weigh the same
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		switch coding {
+		case "*":
+			coding = "gzip"
+		case "gzip", "deflate":
+		default:
+			continue
+		}
+		// q=0 refuses the coding outright
+		if q > 0 && (q > bestQ || (q == bestQ && coding == "gzip")) {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Gzip or deflate for api responses above the minimum size. Registry blobs,
+// range capable downloads and bodies already encoded pass through, connect
+// rpcs negotiate their own compression
+func withCompression(opts *CompressionOptions, next http.Handler) http.Handler {
+	if opts == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" ||
+			strings.HasPrefix(r.URL.Path, "/v2/") || isConnectPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, opts: opts, encoding: encoding}
+		defer cw.finish()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Holds the first MinSize bytes back to decide whether compressing pays
+type compressWriter struct {
+	http.ResponseWriter
+	opts        *CompressionOptions
+	encoding    string
+	status      int
+	buf         bytes.Buffer
+	enc         io.WriteCloser
+	checked     bool // Headers looked at once, on the first write
+	decided     bool
+	passthrough bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.decideEarly()
+	if w.decided {
+		if w.passthrough {
+			return w.ResponseWriter.Write(p)
+		}
+		return w.enc.Write(p)
+	}
+	w.buf.Write(p)
+	if w.buf.Len() >= w.opts.MinSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Headers alone can rule compression out before any body is buffered
+func (w *compressWriter) decideEarly() {
+	if w.checked {
+		return
+	}
+	w.checked = true
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Accept-Ranges") != "" || h.Get("Content-Range") != "" ||
+		w.status == http.StatusNoContent || w.status == http.StatusNotModified || w.status == http.StatusPartialContent {
+		w.passthroughNow()
+		return
+	}
+	if !w.opts.compressible(h.Get("Content-Type")) {
+		w.passthroughNow()
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < w.opts.MinSize {
+		w.passthroughNow()
+	}
+}
+
+func (w *compressWriter) passthroughNow() {
+	w.decided, w.passthrough = true, true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Sends the headers and whatever was buffered, compressed or as is
+func (w *compressWriter) start(compress bool) error {
+	w.decided, w.passthrough = true, !compress
+	if compress {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		if w.encoding == "gzip" {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(w.ResponseWriter)
+			w.enc = gz
+		} else {
+			fl := flateWriters.Get().(*flate.Writer)
+			fl.Reset(w.ResponseWriter)
+			w.enc = fl
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if compress {
+		_, err = w.enc.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// A flush before the minimum size commits to sending as is, streams
+// should not wait on a buffer
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.decideEarly()
+	if !w.decided {
+		_ = w.start(false)
+	}
+	if fl, ok := w.enc.(interface{ Flush() error }); ok {
+		_ = fl.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Closes the encoder, short bodies are written out uncompressed
+func (w *compressWriter) finish() {
+	if !w.decided {
+		if w.status == 0 {
+			// Handler wrote nothing, net/http sends its own 200
+			return
+		}
+		w.decideEarly()
+		if !w.decided {
+			_ = w.start(false)
+		}
+	}
+	switch enc := w.enc.(type) {
+	case *gzip.Writer:
+		_ = enc.Close()
+		gzipWriters.Put(enc)
+	case *flate.Writer:
+		_ = enc.Close()
+		flateWriters.Put(enc)
+	}
+	w.enc = nil
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
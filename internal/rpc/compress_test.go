@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                          "",
+		"br":                        "",
+		"gzip":                      "gzip",
+		"deflate":                   "deflate",
+		"deflate, gzip":             "gzip",
+		"gzip;q=0.5, deflate":       "deflate",
+		"gzip;q=0, deflate;q=0.1":   "deflate",
+		"GZIP;q=0":                  "",
+		"*":                         "gzip",
+		"gzip;q=nope, deflate;q=.2": "deflate",
+	} {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	opts := &CompressionOptions{MinSize: 64, ContentTypes: []string{"application/json", "text/*"}}
+	big := strings.Repeat(`{"name":"app"}`, 20)
+
+	serve := func(path, contentType, body string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		h := withCompression(opts, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Content-Type", contentType)
+			_, _ = io.WriteString(w, body)
+		}))
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate;q=0.5")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/api/v1/repos", "application/json; charset=utf-8", big, nil)
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("headers = %v, want gzip varying on Accept-Encoding", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gz); string(got) != big {
+		t.Fatalf("decompressed body = %q", got)
+	}
+
+	for _, c := range []struct {
+		name, path, contentType, body string
+		header                        http.Header
+	}{
+		{"short body", "/api/v1/repos", "application/json", `{}`, nil},
+		{"binary type", "/api/v1/artifacts/x", "application/octet-stream", big, nil},
+		{"registry route", "/v2/alice/app/manifests/1.0", "application/json", big, nil},
+		{"connect route", "/distroface.v1.RepositoryService/ListRepositories", "application/json", big, nil},
+		{"already encoded", "/api/v1/repos", "application/json", big, http.Header{"Content-Encoding": {"br"}}},
+		{"range capable", "/api/v1/repos", "text/plain", big, http.Header{"Accept-Ranges": {"bytes"}}},
+	} {
+		rec := serve(c.path, c.contentType, c.body, c.header)
+		if enc := rec.Header().Get("Content-Encoding"); enc == "gzip" || enc == "deflate" {
+			t.Errorf("%s: compressed as %s", c.name, enc)
+		}
+		if rec.Body.String() != c.body {
+			t.Errorf("%s: body = %q, want %q", c.name, rec.Body.String(), c.body)
+		}
+	}
+}
+
+func TestWithCompressionDeflateAndFlush(t *testing.T) {
+	opts := &CompressionOptions{MinSize: 1024, ContentTypes: []string{"application/json"}}
+	big := strings.Repeat("x", 2048)
+	h := withCompression(opts, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Has("stream") {
+			// Flushed while still under the minimum, so sent as is
+			_, _ = io.WriteString(w, "first ")
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, big)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, big)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/things", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0.2, deflate")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("got %d %v, want 201 deflate", rec.Code, rec.Header())
+	}
+	if got, _ := io.ReadAll(flate.NewReader(rec.Body)); string(got) != big {
+		t.Fatalf("inflated body has %d bytes, want %d", len(got), len(big))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/things?stream=1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "first "+big {
+		t.Fatalf("flushed stream = %v, %d bytes", rec.Header(), rec.Body.Len())
+	}
+}
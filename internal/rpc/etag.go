@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/proto"
+)
+
+// Read only list and search procedures whose responses carry an etag
+var cacheableProcedures = map[string]bool{
+	distrofacev1connect.ArtifactServiceListArtifactRepositoriesProcedure: true,
+	distrofacev1connect.ArtifactServiceGetArtifactRepositoryProcedure:    true,
+	distrofacev1connect.ArtifactServiceListArtifactsProcedure:            true,
+	distrofacev1connect.ArtifactServiceListArtifactVersionsProcedure:     true,
+	distrofacev1connect.ArtifactServiceSearchArtifactsProcedure:          true,
+	distrofacev1connect.RepositoryServiceListRepositoriesProcedure:       true,
+	distrofacev1connect.RepositoryServiceListTagsProcedure:               true,
+	distrofacev1connect.RoleServiceListRolesProcedure:                    true,
+	distrofacev1connect.RoleServiceListRoleMembersProcedure:              true,
+	distrofacev1connect.UserServiceListUsersProcedure:                    true,
+}
+
+// Buffers cacheable unary responses so the body can be hashed
+type etagRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *etagRecorder) Header() http.Header { return r.header }
+
+func (r *etagRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *etagRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(p)
+}
+
+// Strong etag on cacheable rpc responses, a matching If-None-Match gets 304
+func withETags(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !cacheableProcedures[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &etagRecorder{header: make(http.Header)}
+		next.ServeHTTP(rec, r)
+
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		if rec.status != http.StatusOK {
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(rec.body.Bytes())
+		etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:16]))
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Add("Vary", "Authorization")
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(rec.body.Bytes())
+	})
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Binary codec with sorted map keys so identical responses hash identically
+type deterministicProtoCodec struct{}
+
+func (deterministicProtoCodec) Name() string { return "proto" }
+
+func (deterministicProtoCodec) Marshal(msg any) ([]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", msg)
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
+
+func (deterministicProtoCodec) Unmarshal(data []byte, msg any) error {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", msg)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	benchLayers    = 32
+	benchLayerSize = 1 << 20
+)
+
+// Stands in for blob upload chunks, drains the body and pays a little
+// storage latency per request like the registry does
+func layerSink(t testing.TB, wantProto int) http.Handler {
+	var once sync.Once
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			if r.ProtoMajor != wantProto {
+				t.Errorf("request over HTTP/%d, want HTTP/%d", r.ProtoMajor, wantProto)
+			}
+		})
+		_, _ = io.Copy(io.Discard, r.Body)
+		time.Sleep(2 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// One docker push, every layer uploaded at once through the client
+func pushLayers(b *testing.B, client *http.Client, url string, layer []byte) {
+	var wg sync.WaitGroup
+	for i := range benchLayers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/v2/acme/app/blobs/uploads/%d", url, i), bytes.NewReader(layer))
+			resp, err := client.Do(req)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+// Many layer pushes through one proxy. HTTP/1.1 proxies hold a handful of
+// upstream connections and queue the rest, h2c multiplexes every layer on
+// one connection up to http2.max_concurrent_streams. One h2c connection
+// beats one http/1.1 connection by far, several http/1.1 connections still
+// move more bytes than one h2c connection on loopback.
+//
+//	go test ./internal/rpc -run '^$' -bench ConcurrentPush
+func BenchmarkConcurrentPush(b *testing.B) {
+	layer := bytes.Repeat([]byte{0x5a}, benchLayerSize)
+
+	for _, conns := range []int{1, 6} {
+		b.Run(fmt.Sprintf("http1-%dconns", conns), func(b *testing.B) {
+			srv := httptest.NewServer(layerSink(b, 1))
+			defer srv.Close()
+			client := &http.Client{Transport: &http.Transport{MaxConnsPerHost: conns}}
+			b.SetBytes(benchLayers * benchLayerSize)
+			for b.Loop() {
+				pushLayers(b, client, srv.URL, layer)
+			}
+		})
+	}
+
+	for _, streams := range []uint32{8, 250} {
+		b.Run(fmt.Sprintf("h2c-1conn-%dstreams", streams), func(b *testing.B) {
+			s := &Server{ServerDeps: ServerDeps{H2C: &http2.Server{MaxConcurrentStreams: streams}}}
+			srv := httptest.NewServer(s.withH2C(layerSink(b, 2)))
+			defer srv.Close()
+			client := &http.Client{Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, addr)
+				},
+			}}
+			b.SetBytes(benchLayers * benchLayerSize)
+			for b.Loop() {
+				pushLayers(b, client, srv.URL, layer)
+			}
+		})
+	}
+}
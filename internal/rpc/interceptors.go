@@ -31,6 +31,26 @@ var auditedAuthProcedures = map[string]bool{
 	distrofacev1connect.UserServiceChangePasswordProcedure: true,
 }
 
+// Authenticated only rpcs audited under the resource they touch, the
+// object field names the target in the event detail
+var auditedInServiceProcedures = map[string]rbac.ProcedurePermission{
+	distrofacev1connect.CommentServiceCreateCommentProcedure: {Resource: "comments", ObjectIDField: "namespace+name+ref"},
+	distrofacev1connect.CommentServiceUpdateCommentProcedure: {Resource: "comments", ObjectIDField: "id"},
+	distrofacev1connect.CommentServiceDeleteCommentProcedure: {Resource: "comments", ObjectIDField: "id"},
+}
+
+// Destructive rpcs carrying a reason for the audit trail
+var deleteReasonProcedures = map[string]bool{
+	distrofacev1connect.RepositoryServiceDeleteRepositoryProcedure:       true,
+	distrofacev1connect.RepositoryServiceDeleteTagProcedure:              true,
+	distrofacev1connect.ArtifactServiceDeleteArtifactRepositoryProcedure: true,
+	distrofacev1connect.ArtifactServiceDeleteArtifactProcedure:           true,
+	distrofacev1connect.UserServiceAdminDeleteUserProcedure:              true,
+	distrofacev1connect.UserServiceAdminBulkDeleteUsersProcedure:         true,
+}
+
+const maxDeleteReasonLen = 500
+
 // Rpcs still reachable while a password rotation is pending
 var mustChangeExemptProcedures = map[string]bool{
 	distrofacev1connect.AuthServiceGetCurrentUserProcedure: true,
@@ -98,6 +118,11 @@ func (s *Server) authInterceptor() connect.UnaryInterceptorFunc {
 					}
 					// Public route with bad token - proceed without user
 				}
+			} else if certUser, err := s.AuthManager.ClientCertUser(ctx); certUser != nil {
+				user = certUser
+			} else if err != nil && !isPublic {
+				s.recordAuthDenial(ctx, req, "client certificate refused")
+				return nil, connect.NewError(connect.CodeUnauthenticated, err)
 			} else if s.AuthManager.IsAnonymousAccessEnabled() && portalAllowsAnonymous(ctx) {
 				user = s.AuthManager.AnonymousUser()
 			} else if !isPublic {
@@ -112,6 +137,11 @@ func (s *Server) authInterceptor() connect.UnaryInterceptorFunc {
 				return nil, err
 			}
 
+			if err := tokenScopeAllows(user, procedure, isPublic); err != nil {
+				s.recordAuthDenial(ctx, req, "token scope")
+				return nil, err
+			}
+
 			// Public procedures - no further checks
 			if isPublic {
 				return next(ctx, req)
@@ -178,6 +208,16 @@ func (a *streamAuthInterceptor) WrapStreamingHandler(next connect.StreamingHandl
 		}
 		token := auth.ExtractToken(conn.RequestHeader())
 		if token == "" {
+			certUser, err := srv.AuthManager.ClientCertUser(ctx)
+			if err != nil {
+				return connect.NewError(connect.CodeUnauthenticated, err)
+			}
+			if certUser != nil {
+				if certUser.MustChangePassword {
+					return connect.NewError(connect.CodePermissionDenied, fmt.Errorf("password change required before continuing"))
+				}
+				return next(auth.WithUser(ctx, certUser), conn)
+			}
 			if srv.AuthManager.IsAnonymousAccessEnabled() && portalAllowsAnonymous(ctx) {
 				return next(auth.WithUser(ctx, srv.AuthManager.AnonymousUser()), conn)
 			}
@@ -190,10 +230,35 @@ func (a *streamAuthInterceptor) WrapStreamingHandler(next connect.StreamingHandl
 		if user.MustChangePassword {
 			return connect.NewError(connect.CodePermissionDenied, fmt.Errorf("password change required before continuing"))
 		}
+		if err := tokenScopeAllows(user, conn.Spec().Procedure, false); err != nil {
+			return err
+		}
 		return next(auth.WithUser(ctx, user), conn)
 	}
 }
 
+// Scoped api tokens reach only rpcs their scopes cover. Public rpcs
+// outside the scope table stay open to them like to anyone
+func tokenScopeAllows(user *auth.AuthenticatedUser, procedure string, public bool) error {
+	if !user.Scoped() {
+		return nil
+	}
+	perm, ok := rbac.ProcedurePermissions[procedure]
+	if !ok {
+		perm, ok = rbac.TokenScopeProcedures[procedure]
+	}
+	if !ok {
+		if public {
+			return nil
+		}
+		return connect.NewError(connect.CodePermissionDenied, fmt.Errorf("api token scopes do not cover %s", procedure))
+	}
+	if perm.Resource != "" && !user.Allows(perm.Resource, perm.Action) {
+		return connect.NewError(connect.CodePermissionDenied, fmt.Errorf("api token scopes do not cover %s/%s", perm.Resource, perm.Action))
+	}
+	return nil
+}
+
 // Auth interceptor denials never reach the audit interceptor
 func (s *Server) recordAuthDenial(ctx context.Context, req connect.AnyRequest, detail string) {
 	procedure := req.Spec().Procedure
@@ -254,7 +319,11 @@ func (s *Server) auditInterceptor(recorder *audit.Recorder) connect.UnaryInterce
 					ev.Outcome = audit.OutcomeError
 				}
 			}
-			if perm, ok := rbac.ProcedurePermissions[procedure]; ok && perm.ObjectIDField != "" {
+			perm, ok := rbac.ProcedurePermissions[procedure]
+			if !ok {
+				perm, ok = auditedInServiceProcedures[procedure]
+			}
+			if ok && perm.ObjectIDField != "" {
 				if obj := rbac.ExtractObjectID(req, perm.ObjectIDField); obj != "*" {
 					ev.Detail = obj
 				}
@@ -268,12 +337,52 @@ func (s *Server) auditInterceptor(recorder *audit.Recorder) connect.UnaryInterce
 					}
 				}
 			}
+			if deleteReasonProcedures[procedure] {
+				if reason := rbac.ExtractObjectID(req, "reason"); reason != "*" {
+					ev.Detail = strings.TrimPrefix(ev.Detail+"; reason: "+reason, "; ")
+				}
+			}
+			// First half of a two admin delete, nothing was removed yet
+			if p, ok := responseAny(resp, err).(interface{ GetPendingApproval() bool }); ok && p.GetPendingApproval() {
+				ev.Outcome = audit.OutcomePending
+			}
 			recorder.Record(ctx, ev)
 			return resp, err
 		}
 	}
 }
 
+// Failed handlers hand back a typed nil response, Any would panic on it
+func responseAny(resp connect.AnyResponse, err error) any {
+	if resp == nil || err != nil {
+		return nil
+	}
+	return resp.Any()
+}
+
+// Refuses deletes without a reason when settings require one, runs inside
+// the audit interceptor so refusals are recorded
+func (s *Server) deleteReasonInterceptor() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if !deleteReasonProcedures[req.Spec().Procedure] {
+				return next(ctx, req)
+			}
+			reason := strings.TrimSpace(rbac.ExtractObjectID(req, "reason"))
+			if reason == "*" {
+				reason = ""
+			}
+			if len(reason) > maxDeleteReasonLen {
+				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("reason exceeds %d characters", maxDeleteReasonLen))
+			}
+			if reason == "" && s.Resolver != nil && s.Resolver.System(ctx).GetSecurity().GetDeletion().GetRequireReason() {
+				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("a reason is required for deletes"))
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
 type loggingInterceptor struct {
 	log *logger.Logger
 }
@@ -303,6 +412,9 @@ func auditableResource(procedure string) (string, bool) {
 	if auditedAuthProcedures[procedure] {
 		return "auth", true
 	}
+	if perm, ok := auditedInServiceProcedures[procedure]; ok {
+		return perm.Resource, true
+	}
 	perm, ok := rbac.ProcedurePermissions[procedure]
 	if !ok || perm.Action == rbac.ActionRead || perm.Action == rbac.ActionPull {
 		return "", false
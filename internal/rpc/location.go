@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/portal"
+	"github.com/nickheyer/distroface/internal/settings"
+)
+
+// Registry and v1 artifact upload flows hand out Location headers
+func hasLocationFlow(path string) bool {
+	return strings.HasPrefix(path, "/v2/") || strings.HasPrefix(path, "/api/v1/artifacts/")
+}
+
+// Rewrites Location on upload flows to an absolute url on the external
+// base, or the forwarded origin when none is configured. Portal hosts
+// always answer on their own origin.
+func withExternalLocations(res *settings.Resolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasLocationFlow(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&locationWriter{ResponseWriter: w, req: r, res: res}, r)
+	})
+}
+
+type locationWriter struct {
+	http.ResponseWriter
+	req         *http.Request
+	res         *settings.Resolver
+	wroteHeader bool
+}
+
+func (lw *locationWriter) WriteHeader(code int) {
+	if !lw.wroteHeader {
+		lw.wroteHeader = true
+		if loc := lw.Header().Get("Location"); loc != "" {
+			lw.Header().Set("Location", lw.absolute(loc))
+		}
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *locationWriter) Write(p []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.WriteHeader(http.StatusOK)
+	}
+	return lw.ResponseWriter.Write(p)
+}
+
+func (lw *locationWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Only our own routes are rebased, the registry already made them absolute
+// from headers any client can set
+func (lw *locationWriter) absolute(loc string) string {
+	u, err := url.Parse(loc)
+	if err != nil || !hasLocationFlow(u.Path) {
+		return loc
+	}
+	base := ""
+	if portal.FromContext(lw.req.Context()) == nil {
+		base = strings.TrimRight(lw.res.System(lw.req.Context()).GetServer().GetExternalUrl(), "/")
+	}
+	if base == "" {
+		base = admin.RequestOrigin(lw.req)
+	}
+	out := base + u.EscapedPath()
+	if u.RawQuery != "" {
+		out += "?" + u.RawQuery
+	}
+	return out
+}
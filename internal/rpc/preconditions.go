@@ -0,0 +1,347 @@
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/rpc/services"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Output only counters and timestamps left out of resource etags, they move
+// without anyone changing the resource
+var volatileFields = map[protoreflect.FullName][]protoreflect.Name{
+	"distroface.v1.User":         {"updated_at"},
+	"distroface.v1.Organization": {"member_count", "current_user_role", "updated_at"},
+	"distroface.v1.Repository": {
+		"tag_count", "size_bytes", "updated_at", "last_pushed_at", "pull_count", "push_count",
+		"star_count", "is_starred", "mirror_last_sync", "mirror_last_error", "mirror_next_attempt", "mirror_syncing",
+	},
+}
+
+// Strong etag over the managed fields of a resource
+func resourceETag(msg proto.Message) string {
+	m := proto.Clone(msg)
+	clearVolatile(m.ProtoReflect())
+	b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:16]))
+}
+
+func clearVolatile(m protoreflect.Message) {
+	fields := m.Descriptor().Fields()
+	for _, name := range volatileFields[m.Descriptor().FullName()] {
+		if fd := fields.ByName(name); fd != nil {
+			m.Clear(fd)
+		}
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() {
+			clearVolatile(v.Message())
+		}
+		return true
+	})
+}
+
+// One managed resource kind, reads and writes address it the same way
+type versionedResource struct {
+	// Lock key naming the target of a request
+	key func(ctx context.Context, req connect.AnyRequest) string
+	// Current rendering of the target as a read would return it
+	current func(ctx context.Context, req connect.AnyRequest) (proto.Message, error)
+	// Resource carried in a response, nil when there is none
+	resource func(resp connect.AnyResponse) proto.Message
+}
+
+// Procedures under etag concurrency control, reads get an ETag header and
+// writes honor If-Match so infrastructure tools can manage resources safely
+type preconditions struct {
+	reads  map[string]*versionedResource
+	writes map[string]*versionedResource
+	locks  [64]sync.Mutex
+}
+
+func newPreconditions() *preconditions {
+	return &preconditions{
+		reads:  make(map[string]*versionedResource),
+		writes: make(map[string]*versionedResource),
+	}
+}
+
+func (p *preconditions) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &p.locks[h.Sum32()%uint32(len(p.locks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Writes sharing a resource serialize so the If-Match check and the write
+// are atomic, a stale tag answers 409 aborted
+func (p *preconditions) interceptor() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			procedure := req.Spec().Procedure
+			if res := p.reads[procedure]; res != nil {
+				resp, err := next(ctx, req)
+				if err == nil {
+					setETag(resp, res)
+				}
+				return resp, err
+			}
+			res := p.writes[procedure]
+			if res == nil {
+				return next(ctx, req)
+			}
+
+			defer p.lock(res.key(ctx, req))()
+			if want := req.Header().Get("If-Match"); want != "" {
+				cur, err := res.current(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+				if !etagMatches(want, resourceETag(cur)) {
+					return nil, connect.NewError(connect.CodeAborted, fmt.Errorf("resource was modified since it was read, fetch it again and retry"))
+				}
+			}
+			resp, err := next(ctx, req)
+			if err == nil {
+				setETag(resp, res)
+			}
+			return resp, err
+		}
+	}
+}
+
+func setETag(resp connect.AnyResponse, res *versionedResource) {
+	if msg := res.resource(resp); msg != nil && msg.ProtoReflect().IsValid() {
+		resp.Header().Set("ETag", resourceETag(msg))
+	}
+}
+
+// Wires the managed resources to the services that render them
+func (p *preconditions) register(store *stores.Store, users *services.UserService, roles *services.RoleService, repos *services.RepositoryService, orgs *services.OrganizationService, settingsSvc *services.SettingsService) {
+	userByID := func(ctx context.Context, id string) (proto.Message, error) {
+		u, err := store.GetUserByID(ctx, id)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		if u == nil {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("user not found"))
+		}
+		resp, err := users.GetUser(ctx, connect.NewRequest(&v1.GetUserRequest{Username: u.Username}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.Msg.User, nil
+	}
+	user := &versionedResource{
+		resource: func(resp connect.AnyResponse) proto.Message {
+			switch m := resp.Any().(type) {
+			case *v1.GetUserResponse:
+				return m.GetUser()
+			case *v1.UpdateUserResponse:
+				return m.GetUser()
+			case *v1.AdminUpdateUserResponse:
+				return m.GetUser()
+			}
+			return nil
+		},
+	}
+	selfUser := *user
+	selfUser.key = func(ctx context.Context, req connect.AnyRequest) string {
+		if caller := auth.UserFromContext(ctx); caller != nil {
+			return "user:" + caller.ID
+		}
+		return "user:"
+	}
+	selfUser.current = func(ctx context.Context, req connect.AnyRequest) (proto.Message, error) {
+		caller := auth.UserFromContext(ctx)
+		if caller == nil {
+			return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+		}
+		return userByID(ctx, caller.ID)
+	}
+	adminUser := *user
+	adminUser.key = func(ctx context.Context, req connect.AnyRequest) string {
+		switch m := req.Any().(type) {
+		case *v1.AdminUpdateUserRequest:
+			return "user:" + m.UserId
+		case *v1.AdminDeleteUserRequest:
+			return "user:" + m.UserId
+		}
+		return "user:"
+	}
+	adminUser.current = func(ctx context.Context, req connect.AnyRequest) (proto.Message, error) {
+		switch m := req.Any().(type) {
+		case *v1.AdminUpdateUserRequest:
+			return userByID(ctx, m.UserId)
+		case *v1.AdminDeleteUserRequest:
+			return userByID(ctx, m.UserId)
+		}
+		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
+	}
+	p.reads[distrofacev1connect.UserServiceGetUserProcedure] = user
+	p.writes[distrofacev1connect.UserServiceUpdateUserProcedure] = &selfUser
+	p.writes[distrofacev1connect.UserServiceAdminUpdateUserProcedure] = &adminUser
+	p.writes[distrofacev1connect.UserServiceAdminDeleteUserProcedure] = &adminUser
+
+	role := &versionedResource{
+		key: func(ctx context.Context, req connect.AnyRequest) string {
+			return "role:" + roleTarget(req)
+		},
+		current: func(ctx context.Context, req connect.AnyRequest) (proto.Message, error) {
+			resp, err := roles.GetRole(ctx, connect.NewRequest(&v1.GetRoleRequest{Id: roleTarget(req)}))
+			if err != nil {
+				return nil, err
+			}
+			return resp.Msg.Role, nil
+		},
+		resource: func(resp connect.AnyResponse) proto.Message {
+			switch m := resp.Any().(type) {
+			case *v1.GetRoleResponse:
+				return m.GetRole()
+			case *v1.UpdateRoleResponse:
+				return m.GetRole()
+			}
+			return nil
+		},
+	}
+	p.reads[distrofacev1connect.RoleServiceGetRoleProcedure] = role
+	p.writes[distrofacev1connect.RoleServiceUpdateRoleProcedure] = role
+	p.writes[distrofacev1connect.RoleServiceDeleteRoleProcedure] = role
+	p.writes[distrofacev1connect.RoleServiceUpdatePermissionsProcedure] = role
+
+	repo := &versionedResource{
+		key: func(ctx context.Context, req connect.AnyRequest) string {
+			ns, name := repoTarget(req)
+			return "repo:" + ns + "/" + name
+		},
+		current: func(ctx context.Context, req connect.AnyRequest) (proto.Message, error) {
+			ns, name := repoTarget(req)
+			resp, err := repos.GetRepository(ctx, connect.NewRequest(&v1.GetRepositoryRequest{Namespace: ns, Name: name}))
+			if err != nil {
+				return nil, err
+			}
+			return resp.Msg.Repository, nil
+		},
+		resource: func(resp connect.AnyResponse) proto.Message {
+			switch m := resp.Any().(type) {
+			case *v1.GetRepositoryResponse:
+				return m.GetRepository()
+			case *v1.UpdateRepositoryResponse:
+				return m.GetRepository()
+			}
+			return nil
+		},
+	}
+	p.reads[distrofacev1connect.RepositoryServiceGetRepositoryProcedure] = repo
+	p.writes[distrofacev1connect.RepositoryServiceUpdateRepositoryProcedure] = repo
+	p.writes[distrofacev1connect.RepositoryServiceDeleteRepositoryProcedure] = repo
+
+	org := &versionedResource{
+		key: func(ctx context.Context, req connect.AnyRequest) string {
+			return "org:" + orgTarget(req)
+		},
+		current: func(ctx context.Context, req connect.AnyRequest) (proto.Message, error) {
+			o, err := store.GetOrganizationByID(ctx, orgTarget(req))
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInternal, err)
+			}
+			if o == nil {
+				return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("organization not found"))
+			}
+			resp, err := orgs.GetOrganization(ctx, connect.NewRequest(&v1.GetOrganizationRequest{Name: o.Name}))
+			if err != nil {
+				return nil, err
+			}
+			return resp.Msg.Organization, nil
+		},
+		resource: func(resp connect.AnyResponse) proto.Message {
+			switch m := resp.Any().(type) {
+			case *v1.GetOrganizationResponse:
+				return m.GetOrganization()
+			case *v1.UpdateOrganizationResponse:
+				return m.GetOrganization()
+			}
+			return nil
+		},
+	}
+	p.reads[distrofacev1connect.OrganizationServiceGetOrganizationProcedure] = org
+	p.writes[distrofacev1connect.OrganizationServiceUpdateOrganizationProcedure] = org
+	p.writes[distrofacev1connect.OrganizationServiceDeleteOrganizationProcedure] = org
+
+	scoped := &versionedResource{
+		key: func(ctx context.Context, req connect.AnyRequest) string {
+			scope := settingsTarget(req)
+			return "settings:" + scope.GetType().String() + ":" + scope.GetScopeId()
+		},
+		current: func(ctx context.Context, req connect.AnyRequest) (proto.Message, error) {
+			resp, err := settingsSvc.GetSettings(ctx, connect.NewRequest(&v1.GetSettingsRequest{Scope: settingsTarget(req)}))
+			if err != nil {
+				return nil, err
+			}
+			return resp.Msg.Settings, nil
+		},
+		resource: func(resp connect.AnyResponse) proto.Message {
+			switch m := resp.Any().(type) {
+			case *v1.GetSettingsResponse:
+				return m.GetSettings()
+			case *v1.UpdateSettingsResponse:
+				return m.GetStored().GetSettings()
+			}
+			return nil
+		},
+	}
+	p.reads[distrofacev1connect.SettingsServiceGetSettingsProcedure] = scoped
+	p.writes[distrofacev1connect.SettingsServiceUpdateSettingsProcedure] = scoped
+}
+
+func roleTarget(req connect.AnyRequest) string {
+	switch m := req.Any().(type) {
+	case *v1.UpdateRoleRequest:
+		return m.Id
+	case *v1.DeleteRoleRequest:
+		return m.Id
+	case *v1.UpdatePermissionsRequest:
+		return m.RoleId
+	}
+	return ""
+}
+
+func repoTarget(req connect.AnyRequest) (string, string) {
+	switch m := req.Any().(type) {
+	case *v1.UpdateRepositoryRequest:
+		return m.Namespace, m.Name
+	case *v1.DeleteRepositoryRequest:
+		return m.Namespace, m.Name
+	}
+	return "", ""
+}
+
+func orgTarget(req connect.AnyRequest) string {
+	switch m := req.Any().(type) {
+	case *v1.UpdateOrganizationRequest:
+		return m.Id
+	case *v1.DeleteOrganizationRequest:
+		return m.Id
+	}
+	return ""
+}
+
+func settingsTarget(req connect.AnyRequest) *v1.SettingsScope {
+	if m, ok := req.Any().(*v1.UpdateSettingsRequest); ok {
+		return m.GetScope()
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/proto"
+)
+
+// One repository whose update reads, pauses, then writes, so unserialized
+// writers would both pass their If-Match check
+type fakeRepos struct {
+	distrofacev1connect.UnimplementedRepositoryServiceHandler
+	mu   sync.Mutex
+	repo *v1.Repository
+}
+
+func (f *fakeRepos) snapshot() *v1.Repository {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return proto.Clone(f.repo).(*v1.Repository)
+}
+
+func (f *fakeRepos) GetRepository(ctx context.Context, req *connect.Request[v1.GetRepositoryRequest]) (*connect.Response[v1.GetRepositoryResponse], error) {
+	return connect.NewResponse(&v1.GetRepositoryResponse{Repository: f.snapshot()}), nil
+}
+
+func (f *fakeRepos) UpdateRepository(ctx context.Context, req *connect.Request[v1.UpdateRepositoryRequest]) (*connect.Response[v1.UpdateRepositoryResponse], error) {
+	next := f.snapshot()
+	time.Sleep(5 * time.Millisecond)
+	next.Description = req.Msg.GetDescription()
+	f.mu.Lock()
+	f.repo = next
+	f.mu.Unlock()
+	return connect.NewResponse(&v1.UpdateRepositoryResponse{Repository: proto.Clone(next).(*v1.Repository)}), nil
+}
+
+func newPreconditionServer(t *testing.T) (*fakeRepos, distrofacev1connect.RepositoryServiceClient, string) {
+	t.Helper()
+	fake := &fakeRepos{repo: &v1.Repository{Namespace: "alice", Name: "app", Description: "first"}}
+	p := newPreconditions()
+	res := &versionedResource{
+		key: func(ctx context.Context, req connect.AnyRequest) string {
+			ns, name := repoTarget(req)
+			return "repo:" + ns + "/" + name
+		},
+		current: func(ctx context.Context, req connect.AnyRequest) (proto.Message, error) {
+			return fake.snapshot(), nil
+		},
+		resource: func(resp connect.AnyResponse) proto.Message {
+			switch m := resp.Any().(type) {
+			case *v1.GetRepositoryResponse:
+				return m.GetRepository()
+			case *v1.UpdateRepositoryResponse:
+				return m.GetRepository()
+			}
+			return nil
+		},
+	}
+	p.reads[distrofacev1connect.RepositoryServiceGetRepositoryProcedure] = res
+	p.writes[distrofacev1connect.RepositoryServiceUpdateRepositoryProcedure] = res
+
+	mux := http.NewServeMux()
+	mux.Handle(distrofacev1connect.NewRepositoryServiceHandler(fake, connect.WithInterceptors(p.interceptor())))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return fake, distrofacev1connect.NewRepositoryServiceClient(srv.Client(), srv.URL), srv.URL
+}
+
+func getETag(t *testing.T, client distrofacev1connect.RepositoryServiceClient) string {
+	t.Helper()
+	resp, err := client.GetRepository(context.Background(), connect.NewRequest(&v1.GetRepositoryRequest{Namespace: "alice", Name: "app"}))
+	if err != nil {
+		t.Fatalf("GetRepository: %v", err)
+	}
+	etag := resp.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("read returned no ETag")
+	}
+	return etag
+}
+
+func update(client distrofacev1connect.RepositoryServiceClient, ifMatch, description string) (*connect.Response[v1.UpdateRepositoryResponse], error) {
+	req := connect.NewRequest(&v1.UpdateRepositoryRequest{Namespace: "alice", Name: "app", Description: proto.String(description)})
+	if ifMatch != "" {
+		req.Header().Set("If-Match", ifMatch)
+	}
+	return client.UpdateRepository(context.Background(), req)
+}
+
+func TestPreconditionMatch(t *testing.T) {
+	_, client, _ := newPreconditionServer(t)
+	etag := getETag(t, client)
+	resp, err := update(client, etag, "second")
+	if err != nil {
+		t.Fatalf("update with the current tag: %v", err)
+	}
+	next := resp.Header().Get("ETag")
+	if next == "" || next == etag {
+		t.Fatalf("write ETag = %q, want a new tag", next)
+	}
+	if got := getETag(t, client); got != next {
+		t.Fatalf("read after write = %s, want the write's %s", got, next)
+	}
+	if _, err := update(client, "W/"+next+`, "other"`, "third"); err != nil {
+		t.Fatalf("weak tag in a list: %v", err)
+	}
+}
+
+func TestPreconditionMismatch(t *testing.T) {
+	fake, client, url := newPreconditionServer(t)
+	stale := getETag(t, client)
+	if _, err := update(client, "", "moved on"); err != nil {
+		t.Fatalf("unconditional update: %v", err)
+	}
+
+	_, err := update(client, stale, "lost update")
+	if connect.CodeOf(err) != connect.CodeAborted {
+		t.Fatalf("stale tag: %v, want aborted", err)
+	}
+	if got := fake.snapshot().Description; got != "moved on" {
+		t.Fatalf("refused write landed: %q", got)
+	}
+
+	// Plain HTTP callers see a conflict
+	req, _ := http.NewRequest(http.MethodPost, url+distrofacev1connect.RepositoryServiceUpdateRepositoryProcedure,
+		strings.NewReader(`{"namespace":"alice","name":"app","description":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", stale)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("stale tag over HTTP: %d, want 409", resp.StatusCode)
+	}
+}
+
+// Without If-Match writes are unconditional, last one wins
+func TestPreconditionMissingHeader(t *testing.T) {
+	fake, client, _ := newPreconditionServer(t)
+	for _, d := range []string{"a", "b"} {
+		if _, err := update(client, "", d); err != nil {
+			t.Fatalf("update %s: %v", d, err)
+		}
+	}
+	if got := fake.snapshot().Description; got != "b" {
+		t.Fatalf("description = %q", got)
+	}
+}
+
+// Writers holding the same tag race, the stripe lets exactly one through
+func TestPreconditionConcurrentWriters(t *testing.T) {
+	fake, client, _ := newPreconditionServer(t)
+	etag := getETag(t, client)
+
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = update(client, etag, "writer")
+		}()
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case connect.CodeOf(err) != connect.CodeAborted:
+			t.Errorf("writer failed with %v, want aborted", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d writers passed the same If-Match, want 1", won)
+	}
+	if got := fake.snapshot().Description; got != "writer" {
+		t.Fatalf("description = %q", got)
+	}
+
+	var ce *connect.Error
+	if _, err := update(client, etag, "late"); !errors.As(err, &ce) || ce.Code() != connect.CodeAborted {
+		t.Fatalf("tag from before the race: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Serves a plain rest request as procedure on handler, posted as connect
+// json so every interceptor applies the same as for rpc clients
+func serveREST(w http.ResponseWriter, r *http.Request, handler http.Handler, procedure string, body []byte) {
+	rpcReq := r.Clone(r.Context())
+	rpcReq.Method = http.MethodPost
+	rpcReq.URL.Path, rpcReq.URL.RawPath, rpcReq.URL.RawQuery = procedure, "", ""
+	rpcReq.Header.Set("Content-Type", "application/json")
+	rpcReq.Body, rpcReq.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+	handler.ServeHTTP(w, rpcReq)
+}
+
+// Like serveREST with msg as the request
+func serveRESTMessage(w http.ResponseWriter, r *http.Request, handler http.Handler, procedure string, msg proto.Message) {
+	body, err := protojson.Marshal(msg)
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	serveREST(w, r, handler, procedure, body)
+}
+
+// Route for a procedure that takes an empty request
+func restRoute(handler http.Handler, procedure string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveREST(w, r, handler, procedure, []byte("{}"))
+	}
+}
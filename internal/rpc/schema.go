@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Models published for wrapper and provider authors, keyed by the cli name
+var schemaModels = map[string]proto.Message{
+	"artifact":            &v1.Artifact{},
+	"artifact-repository": &v1.ArtifactRepository{},
+	"repository":          &v1.Repository{},
+	"user":                &v1.User{},
+	"role":                &v1.Role{},
+	"organization":        &v1.Organization{},
+	"webhook":             &v1.Webhook{},
+	"credential":          &v1.Credential{},
+}
+
+// GET /api/meta/schemas lists model names, /api/meta/schemas/{name} serves
+// the JSON Schema of its protojson wire form
+func serveSchemas(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var body any
+	contentType := "application/schema+json"
+	if name == "" {
+		names := make([]string, 0, len(schemaModels))
+		for n := range schemaModels {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		body = map[string]any{"models": names}
+		contentType = "application/json"
+	} else {
+		msg, ok := schemaModels[strings.TrimSuffix(name, ".json")]
+		if !ok {
+			http.Error(w, "unknown model", http.StatusNotFound)
+			return
+		}
+		body = messageSchema(msg.ProtoReflect().Descriptor())
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(body)
+}
+
+// Root schema with nested messages collected under $defs
+func messageSchema(md protoreflect.MessageDescriptor) map[string]any {
+	defs := map[string]any{}
+	root := objectSchema(md, defs)
+	root["$schema"] = schemaDialect
+	root["$id"] = "distroface:" + string(md.FullName())
+	if len(defs) > 0 {
+		root["$defs"] = defs
+	}
+	return root
+}
+
+func objectSchema(md protoreflect.MessageDescriptor, defs map[string]any) map[string]any {
+	props := map[string]any{}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		props[fd.JSONName()] = fieldSchema(fd, defs)
+	}
+	return map[string]any{
+		"title":                string(md.Name()),
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+func fieldSchema(fd protoreflect.FieldDescriptor, defs map[string]any) map[string]any {
+	if fd.IsMap() {
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": kindSchema(fd.MapValue(), defs),
+		}
+	}
+	s := kindSchema(fd, defs)
+	if fd.IsList() {
+		return map[string]any{"type": "array", "items": s}
+	}
+	return s
+}
+
+// Protojson encodings, 64 bit integers travel as strings
+func kindSchema(fd protoreflect.FieldDescriptor, defs map[string]any) map[string]any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]any{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]any{"type": "integer"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]any{"type": []string{"string", "integer"}, "pattern": "^-?[0-9]+$"}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return map[string]any{"type": "number"}
+	case protoreflect.StringKind:
+		return map[string]any{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]any{"type": "string", "contentEncoding": "base64"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]string, values.Len())
+		for i := range names {
+			names[i] = string(values.Get(i).Name())
+		}
+		return map[string]any{"type": "string", "enum": names}
+	}
+
+	md := fd.Message()
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return map[string]any{"type": "string", "format": "date-time"}
+	case "google.protobuf.Duration":
+		return map[string]any{"type": "string", "pattern": "^-?[0-9]+(\\.[0-9]+)?s$"}
+	case "google.protobuf.Struct":
+		return map[string]any{"type": "object"}
+	}
+	key := string(md.FullName())
+	if _, seen := defs[key]; !seen {
+		defs[key] = nil // Placeholder breaks recursive messages
+		defs[key] = objectSchema(md, defs)
+	}
+	return map[string]any{"$ref": "#/$defs/" + key}
+}
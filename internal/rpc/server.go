@@ -1,24 +1,36 @@
 package rpc
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"connectrpc.com/connect"
 	"connectrpc.com/grpcreflect"
 	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/alerts"
 	"github.com/nickheyer/distroface/internal/artifacts"
 	"github.com/nickheyer/distroface/internal/audit"
 	"github.com/nickheyer/distroface/internal/auth"
 	"github.com/nickheyer/distroface/internal/certs"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/jobs"
+	"github.com/nickheyer/distroface/internal/journal"
 	"github.com/nickheyer/distroface/internal/mirror"
+	"github.com/nickheyer/distroface/internal/notify"
+	"github.com/nickheyer/distroface/internal/notify/channel"
+	"github.com/nickheyer/distroface/internal/policy"
 	"github.com/nickheyer/distroface/internal/portal"
 	"github.com/nickheyer/distroface/internal/rbac"
 	"github.com/nickheyer/distroface/internal/registry"
 	"github.com/nickheyer/distroface/internal/rpc/services"
 	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/signing"
+	"github.com/nickheyer/distroface/internal/vault"
 	"github.com/nickheyer/distroface/internal/webhook"
 	"github.com/nickheyer/distroface/pkg/logger"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
@@ -27,6 +39,8 @@ import (
 	web "github.com/nickheyer/distroface/web/distroface"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 type ServerDeps struct {
@@ -46,13 +60,30 @@ type ServerDeps struct {
 	CertEngine          *certs.Engine
 	ACMEServer          *certs.ACMEServer // Nil hides the built in acme directory
 	AuthLimiter         *admin.Limiter    // Lockout limiter nil disables
+	PullLimiter         *admin.Limiter    // Nil leaves pull limits out of usage reports
 	ArtifactManager     *artifacts.Manager
 	ArtifactV1Facade    *artifacts.V1API
+	PackageRepos        *artifacts.PackageRepos // Nil serves no apt or yum trees
 	MirrorMonitor       *mirror.Monitor
+	Replicator          *mirror.Replicator // Nil hides the replication api
+	Vault               *vault.Vault
+	Signer              *signing.Signer
 	GCCollector         *admin.Collector
-	CertService         *certs.Service  // Nil hides the certificate api
-	AuditRecorder       *audit.Recorder // Nil disables the audit trail
+	AlertMonitor        *alerts.Monitor // Nil reports no alerts
+	Journal             *journal.Journal
+	Visibility          *policy.Visibility // Nil imposes no visibility policy
+	Freezes             *policy.Freezes    // Nil lets untags through freeze windows
+	Jobs                *jobs.Queue        // Nil hides the job api
+	CertService         *certs.Service     // Nil hides the certificate api
+	AuditRecorder       *audit.Recorder    // Nil disables the audit trail
 	AuditService        *audit.Service
+	Notifier            *notify.Notifier       // Nil sends no artifact comment mentions
+	NotificationHub     *channel.Hub           // Nil fails notification channel tests
+	Metrics             *admin.Metrics         // Nil hides /metrics
+	StorageHealth       []*admin.StorageHealth // Reported by the health check
+	H2C                 *http2.Server          // Nil disables cleartext http/2
+	H2CTrustedOnly      bool                   // Only trusted proxies may speak h2c
+	Compression         *CompressionOptions    // Nil sends every response uncompressed
 }
 
 type Server struct {
@@ -78,9 +109,20 @@ func (s *Server) setupHandler() {
 	if s.AuditRecorder != nil {
 		interceptors = append(interceptors, connect.UnaryInterceptorFunc(s.auditInterceptor(s.AuditRecorder)))
 	}
+	interceptors = append(interceptors, s.deleteReasonInterceptor())
+	// Innermost so If-Match checks run as the authenticated caller
+	pre := newPreconditions()
+	interceptors = append(interceptors, pre.interceptor())
 
 	opts := []connect.HandlerOption{
 		connect.WithInterceptors(interceptors...),
+		connect.WithCodec(deterministicProtoCodec{}),
+	}
+	if s.Compression != nil {
+		opts = append(opts, connect.WithCompressMinBytes(s.Compression.MinSize))
+	} else {
+		// Connect gzips for any client asking, unregistering turns that off
+		opts = append(opts, connect.WithCompression("gzip", nil, nil))
 	}
 
 	// Registry handler (OCI Distribution API)
@@ -104,6 +146,15 @@ func (s *Server) setupHandler() {
 		mux.Handle("/.well-known/distroface/ca.pem", s.CertEngine.TrustBundleHandler())
 	}
 
+	// Prometheus scrape target, answers trusted peers only
+	if s.Metrics != nil {
+		mux.Handle("GET /metrics", s.Metrics)
+	}
+
+	// Model schemas for wrappers and generators, public since they only describe wire shapes
+	mux.HandleFunc("GET /api/meta/schemas", serveSchemas)
+	mux.HandleFunc("GET /api/meta/schemas/{name}", serveSchemas)
+
 	// OIDC HTTP handlers (not Connect RPC - these are OAuth2 redirect flows)
 	// Registered unconditionally, handlers self gate on runtime settings
 	if s.OIDCHandler != nil {
@@ -111,10 +162,13 @@ func (s *Server) setupHandler() {
 		mux.HandleFunc("/api/v1/auth/oidc/callback", s.OIDCHandler.HandleCallback)
 	}
 
+	authService := services.NewAuthService(s.Store, s.AuthManager, s.Enforcer, s.OIDCHandler, s.WebhookDispatcher, s.Log.Scoped("auth"))
+
 	// V1 artifact facade for old dfcli and ci, gated per request
 	if s.ArtifactV1Facade != nil {
 		v1mux := http.NewServeMux()
 		s.ArtifactV1Facade.RegisterAuth(v1mux)
+		v1mux.HandleFunc("POST /api/v1/auth/register", authService.HandleV1Register)
 		s.ArtifactV1Facade.RegisterArtifacts(v1mux)
 		mux.Handle("/api/v1/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if !s.Resolver.System(r.Context()).GetArtifacts().GetV1Compat() {
@@ -125,39 +179,122 @@ func (s *Server) setupHandler() {
 		}))
 	}
 
+	// Apt and yum clients fetch debian and rpm repos straight from here
+	if s.PackageRepos != nil {
+		s.PackageRepos.Register(mux)
+	}
+
 	// Register RPC services
-	healthService := services.NewHealthService(s.Log)
+	healthService := services.NewHealthService(s.Log, s.StorageHealth...)
+	healthService.SetFeatures(services.Features{
+		Artifacts:   s.ArtifactV1Facade != nil,
+		Registry:    s.RegistryHandler != nil,
+		Webhooks:    s.WebhookDispatcher != nil,
+		Compression: s.Compression != nil,
+	}, s.Resolver)
 	healthPath, healthHandler := distrofacev1connect.NewHealthServiceHandler(healthService, opts...)
 	mux.Handle(healthPath, healthHandler)
+	// Plain GET so any client can discover features before using them
+	mux.HandleFunc("GET /api/v1/capabilities", restRoute(healthHandler, distrofacev1connect.HealthServiceGetCapabilitiesProcedure))
 
-	authService := services.NewAuthService(s.Store, s.AuthManager, s.Enforcer, s.OIDCHandler, s.Log)
 	authPath, authHandler := distrofacev1connect.NewAuthServiceHandler(authService, opts...)
 	mux.Handle(authPath, authHandler)
 
 	userService := services.NewUserService(s.Store, s.AuthManager, s.Enforcer, s.Log)
+	userService.SetUsageSources(s.RegistryStoragePath, s.Resolver, s.PullLimiter, s.AuthLimiter)
 	userPath, userHandler := distrofacev1connect.NewUserServiceHandler(userService, opts...)
 	mux.Handle(userPath, userHandler)
+	// Plain GET for curl and scripts, served as the rpc so every interceptor applies
+	mux.HandleFunc("GET /api/v1/users/me/usage", restRoute(userHandler, distrofacev1connect.UserServiceGetMyUsageProcedure))
 
-	repoService := services.NewRepositoryService(s.Store, s.RegistryAccess, s.Enforcer, s.MirrorMonitor, s.Log)
+	repoService := services.NewRepositoryService(s.Store, s.Resolver, s.RegistryAccess, s.Enforcer, s.MirrorMonitor, s.Signer, s.Log)
+	repoService.SetJournal(s.Journal)
+	repoService.SetVisibility(s.Visibility)
+	repoService.SetFreezes(s.Freezes)
 	repoPath, repoHandler := distrofacev1connect.NewRepositoryServiceHandler(repoService, opts...)
 	mux.Handle(repoPath, repoHandler)
+	// Plain post for deploy pipelines, served as the rpc so every interceptor applies
+	mux.HandleFunc("POST /api/v1/repositories/{namespace}/{name}/tags/{tag}/prewarm", func(w http.ResponseWriter, r *http.Request) {
+		serveRESTMessage(w, r, repoHandler, distrofacev1connect.RepositoryServicePrewarmTagProcedure, &v1.PrewarmTagRequest{
+			Namespace: r.PathValue("namespace"),
+			Name:      r.PathValue("name"),
+			Tag:       r.PathValue("tag"),
+		})
+	})
+	// Repository ACLs over plain rest, served as the rpcs like the token routes
+	mux.HandleFunc("GET /api/v1/repositories/{namespace}/{name}/permissions", func(w http.ResponseWriter, r *http.Request) {
+		serveRESTMessage(w, r, repoHandler, distrofacev1connect.RepositoryServiceListRepositoryPermissionsProcedure, &v1.ListRepositoryPermissionsRequest{
+			Namespace: r.PathValue("namespace"),
+			Name:      r.PathValue("name"),
+		})
+	})
+	mux.HandleFunc("PUT /api/v1/repositories/{namespace}/{name}/permissions/{subject_type}/{subject}", func(w http.ResponseWriter, r *http.Request) {
+		var grant struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&grant); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		serveRESTMessage(w, r, repoHandler, distrofacev1connect.RepositoryServiceSetRepositoryPermissionProcedure, &v1.SetRepositoryPermissionRequest{
+			Namespace:   r.PathValue("namespace"),
+			Name:        r.PathValue("name"),
+			SubjectType: r.PathValue("subject_type"),
+			Subject:     r.PathValue("subject"),
+			Level:       grant.Level,
+		})
+	})
+	mux.HandleFunc("DELETE /api/v1/repositories/{namespace}/{name}/permissions/{subject_type}/{subject}", func(w http.ResponseWriter, r *http.Request) {
+		serveRESTMessage(w, r, repoHandler, distrofacev1connect.RepositoryServiceRemoveRepositoryPermissionProcedure, &v1.RemoveRepositoryPermissionRequest{
+			Namespace:   r.PathValue("namespace"),
+			Name:        r.PathValue("name"),
+			SubjectType: r.PathValue("subject_type"),
+			Subject:     r.PathValue("subject"),
+		})
+	})
+	// Tag cleanup for pipelines without a registry delete grant, ?reason= for the audit trail
+	mux.HandleFunc("DELETE /api/v1/repositories/{namespace}/{name}/tags/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		serveRESTMessage(w, r, repoHandler, distrofacev1connect.RepositoryServiceDeleteTagProcedure, &v1.DeleteTagRequest{
+			Namespace: r.PathValue("namespace"),
+			Name:      r.PathValue("name"),
+			Tag:       r.PathValue("tag"),
+			Reason:    r.URL.Query().Get("reason"),
+		})
+	})
 
 	settingsService := services.NewSettingsService(s.Store, s.Resolver, s.Enforcer, s.Log)
 	settingsPath, settingsHandler := distrofacev1connect.NewSettingsServiceHandler(settingsService, opts...)
 	mux.Handle(settingsPath, settingsHandler)
+	// Plain GET for login pages and scripts, served as the rpc so every interceptor applies
+	mux.HandleFunc("GET /api/v1/branding", restRoute(settingsHandler, distrofacev1connect.SettingsServiceGetBrandingProcedure))
 
 	roleService := services.NewRoleService(s.Store, s.Enforcer, s.Log)
 	rolePath, roleHandler := distrofacev1connect.NewRoleServiceHandler(roleService, opts...)
 	mux.Handle(rolePath, roleHandler)
 
-	tokenService := services.NewTokenService(s.AuthManager, s.Enforcer, s.Log)
+	tokenService := services.NewTokenService(s.AuthManager, s.Enforcer, s.Log.Scoped("auth"))
 	tokenSvcPath, tokenSvcHandler := distrofacev1connect.NewTokenServiceHandler(tokenService, opts...)
 	mux.Handle(tokenSvcPath, tokenSvcHandler)
+	// Plain rest for scripts and ci, served as the rpcs so every interceptor applies
+	mux.HandleFunc("GET /api/v1/tokens", restRoute(tokenSvcHandler, distrofacev1connect.TokenServiceListAPITokensProcedure))
+	mux.HandleFunc("POST /api/v1/tokens", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		serveREST(w, r, tokenSvcHandler, distrofacev1connect.TokenServiceCreateAPITokenProcedure, body)
+	})
+	mux.HandleFunc("DELETE /api/v1/tokens/{id}", func(w http.ResponseWriter, r *http.Request) {
+		serveRESTMessage(w, r, tokenSvcHandler, distrofacev1connect.TokenServiceDeleteAPITokenProcedure, &v1.DeleteAPITokenRequest{Id: r.PathValue("id")})
+	})
 
 	orgService := services.NewOrganizationService(s.Store, s.RegistryAccess, s.Enforcer, s.Resolver, s.Log)
 	orgPath, orgHandler := distrofacev1connect.NewOrganizationServiceHandler(orgService, opts...)
 	mux.Handle(orgPath, orgHandler)
 
+	pre.register(s.Store, userService, roleService, repoService, orgService, settingsService)
+
 	webhookService := services.NewWebhookService(s.Store, s.Enforcer, s.WebhookDispatcher, s.Log)
 	webhookPath, webhookHandler := distrofacev1connect.NewWebhookServiceHandler(webhookService, opts...)
 	mux.Handle(webhookPath, webhookHandler)
@@ -167,22 +304,80 @@ func (s *Server) setupHandler() {
 		mux.Handle(portalPath, portalHandler)
 	}
 
+	var artifactService *services.ArtifactService
 	if s.ArtifactManager != nil {
-		artifactService := services.NewArtifactService(s.Store, s.ArtifactManager, s.Enforcer, s.MirrorMonitor, s.Log)
+		artifactService = services.NewArtifactService(s.Store, s.Resolver, s.ArtifactManager, s.Enforcer, s.MirrorMonitor, s.Log.Scoped("artifacts"))
 		artifactPath, artifactHandler := distrofacev1connect.NewArtifactServiceHandler(artifactService, opts...)
 		mux.Handle(artifactPath, artifactHandler)
 	}
 
+	commentService := services.NewCommentService(s.Store, repoService, artifactService, s.WebhookDispatcher, s.Notifier, s.Log)
+	commentPath, commentHandler := distrofacev1connect.NewCommentServiceHandler(commentService, opts...)
+	mux.Handle(commentPath, commentHandler)
+
+	notificationService := services.NewNotificationService(s.Store, repoService, s.Log)
+	notificationService.SetChannels(s.NotificationHub)
+	notificationPath, notificationHandler := distrofacev1connect.NewNotificationServiceHandler(notificationService, opts...)
+	mux.Handle(notificationPath, notificationHandler)
+	// Plain json post for curl and scripts, served as the rpc so every interceptor applies
+	mux.HandleFunc("POST /api/v1/notifications/test", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			body = []byte("{}")
+		}
+		serveREST(w, r, notificationHandler, distrofacev1connect.NotificationServiceTestNotificationChannelProcedure, body)
+	})
+
+	freezeService := services.NewFreezeService(s.Store, s.Log)
+	freezePath, freezeHandler := distrofacev1connect.NewFreezeServiceHandler(freezeService, opts...)
+	mux.Handle(freezePath, freezeHandler)
+
+	exportService := services.NewExportService(s.Store, repoService, artifactService, s.Resolver, s.Log)
+	exportPath, exportHandler := distrofacev1connect.NewExportServiceHandler(exportService, opts...)
+	mux.Handle(exportPath, exportHandler)
+
+	credentialService := services.NewCredentialService(s.Store, s.Vault, s.MirrorMonitor, s.Enforcer, s.Log)
+	credentialPath, credentialHandler := distrofacev1connect.NewCredentialServiceHandler(credentialService, opts...)
+	mux.Handle(credentialPath, credentialHandler)
+
 	if s.MirrorMonitor != nil {
-		mirrorService := services.NewMirrorService(s.MirrorMonitor, s.Enforcer, artifacts.NewAccess(s.Store, s.Enforcer), s.Log)
+		mirrorService := services.NewMirrorService(s.MirrorMonitor, s.Enforcer, artifacts.NewAccess(s.Store, s.Enforcer, s.Resolver), s.Log)
 		mirrorPath, mirrorHandler := distrofacev1connect.NewMirrorServiceHandler(mirrorService, opts...)
 		mux.Handle(mirrorPath, mirrorHandler)
 	}
 
 	// Registered even without a collector, it also serves storage usage
-	gcService := services.NewGCService(s.GCCollector, s.Store, s.RegistryStoragePath, s.Resolver, s.Log)
+	gcService := services.NewGCService(s.GCCollector, s.Store, s.RegistryStoragePath, s.ArtifactManager, s.AlertMonitor, s.Resolver, s.Log)
 	gcPath, gcHandler := distrofacev1connect.NewGCServiceHandler(gcService, opts...)
 	mux.Handle(gcPath, gcHandler)
+	// Plain GET for dashboards and scripts, served as the rpc so every interceptor applies
+	mux.HandleFunc("GET /api/v1/admin/summary", restRoute(gcHandler, distrofacev1connect.GCServiceGetAdminSummaryProcedure))
+	// Plain GET for capacity reports, served as the rpc so every interceptor applies
+	mux.HandleFunc("GET /api/v1/storage/forecast", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		msg := &v1.GetRetentionForecastRequest{Namespace: q.Get("namespace")}
+		for _, d := range q["days"] {
+			n, err := strconv.ParseInt(d, 10, 32)
+			if err != nil {
+				http.Error(w, "invalid days", http.StatusBadRequest)
+				return
+			}
+			msg.HorizonDays = append(msg.HorizonDays, int32(n))
+		}
+		if wd := q.Get("window"); wd != "" {
+			n, err := strconv.ParseInt(wd, 10, 32)
+			if err != nil {
+				http.Error(w, "invalid window", http.StatusBadRequest)
+				return
+			}
+			msg.WindowDays = int32(n)
+		}
+		serveRESTMessage(w, r, gcHandler, distrofacev1connect.GCServiceGetRetentionForecastProcedure, msg)
+	})
 
 	if s.CertService != nil {
 		certPath, certHandler := distrofacev1connect.NewCertificateServiceHandler(s.CertService, opts...)
@@ -194,6 +389,58 @@ func (s *Server) setupHandler() {
 		mux.Handle(auditPath, auditHandler)
 	}
 
+	if s.Replicator != nil {
+		replicationService := services.NewReplicationService(s.Store, s.Replicator, s.Enforcer, s.Log)
+		replicationPath, replicationHandler := distrofacev1connect.NewReplicationServiceHandler(replicationService, opts...)
+		mux.Handle(replicationPath, replicationHandler)
+		// Decodes a json body into msg, false once the error went out
+		replicationBody := func(w http.ResponseWriter, r *http.Request, msg proto.Message) bool {
+			raw, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+			if err == nil {
+				err = protojson.Unmarshal(raw, msg)
+			}
+			if err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return false
+			}
+			return true
+		}
+		// Plain rest for scripts and ci, served as the rpcs so every interceptor applies
+		mux.HandleFunc("GET /api/v1/replication", func(w http.ResponseWriter, r *http.Request) {
+			serveRESTMessage(w, r, replicationHandler, distrofacev1connect.ReplicationServiceListReplicationRulesProcedure, &v1.ListReplicationRulesRequest{
+				Namespace: r.URL.Query().Get("namespace"),
+			})
+		})
+		mux.HandleFunc("POST /api/v1/replication", func(w http.ResponseWriter, r *http.Request) {
+			msg := &v1.CreateReplicationRuleRequest{}
+			if replicationBody(w, r, msg) {
+				serveRESTMessage(w, r, replicationHandler, distrofacev1connect.ReplicationServiceCreateReplicationRuleProcedure, msg)
+			}
+		})
+		mux.HandleFunc("GET /api/v1/replication/{id}", func(w http.ResponseWriter, r *http.Request) {
+			serveRESTMessage(w, r, replicationHandler, distrofacev1connect.ReplicationServiceGetReplicationRuleProcedure, &v1.GetReplicationRuleRequest{Id: r.PathValue("id")})
+		})
+		mux.HandleFunc("PATCH /api/v1/replication/{id}", func(w http.ResponseWriter, r *http.Request) {
+			msg := &v1.UpdateReplicationRuleRequest{}
+			if replicationBody(w, r, msg) {
+				msg.Id = r.PathValue("id")
+				serveRESTMessage(w, r, replicationHandler, distrofacev1connect.ReplicationServiceUpdateReplicationRuleProcedure, msg)
+			}
+		})
+		mux.HandleFunc("DELETE /api/v1/replication/{id}", func(w http.ResponseWriter, r *http.Request) {
+			serveRESTMessage(w, r, replicationHandler, distrofacev1connect.ReplicationServiceDeleteReplicationRuleProcedure, &v1.DeleteReplicationRuleRequest{Id: r.PathValue("id")})
+		})
+		mux.HandleFunc("POST /api/v1/replication/{id}/sync", func(w http.ResponseWriter, r *http.Request) {
+			serveRESTMessage(w, r, replicationHandler, distrofacev1connect.ReplicationServiceSyncReplicationRuleProcedure, &v1.SyncReplicationRuleRequest{Id: r.PathValue("id")})
+		})
+	}
+
+	if s.Jobs != nil {
+		jobService := services.NewJobService(s.Store, s.Jobs, s.Log)
+		jobPath, jobHandler := distrofacev1connect.NewJobServiceHandler(jobService, opts...)
+		mux.Handle(jobPath, jobHandler)
+	}
+
 	// GRPC reflection
 	reflector := grpcreflect.NewStaticReflector(
 		distrofacev1connect.HealthServiceName,
@@ -208,9 +455,16 @@ func (s *Server) setupHandler() {
 		distrofacev1connect.PortalServiceName,
 		distrofacev1connect.ArtifactServiceName,
 		distrofacev1connect.MirrorServiceName,
+		distrofacev1connect.CredentialServiceName,
 		distrofacev1connect.GCServiceName,
 		distrofacev1connect.CertificateServiceName,
 		distrofacev1connect.AuditServiceName,
+		distrofacev1connect.CommentServiceName,
+		distrofacev1connect.ExportServiceName,
+		distrofacev1connect.NotificationServiceName,
+		distrofacev1connect.FreezeServiceName,
+		distrofacev1connect.JobServiceName,
+		distrofacev1connect.ReplicationServiceName,
 	)
 	reflectV1Path, reflectV1Handler := grpcreflect.NewHandlerV1(reflector)
 	mux.Handle(reflectV1Path, s.requireAuth(reflectV1Handler))
@@ -221,7 +475,7 @@ func (s *Server) setupHandler() {
 	s.setupFrontend(mux)
 
 	// Headers stay app only, proxied backends own their responses
-	inner := utils.Headers(s.Resolver, s.httpsOnlyRedirect(mux))
+	inner := utils.Headers(s.Resolver, s.httpsOnlyRedirect(withExternalLocations(s.Resolver, withCompression(s.Compression, withETags(mux)))))
 	// Portal hosts get the whole app, org scoped by the resolved portal
 	var root http.Handler = inner
 	if s.PortalResolver != nil {
@@ -229,7 +483,25 @@ func (s *Server) setupHandler() {
 	}
 	// Verified mtls identity rides the request context for auth and audit
 	root = certs.ClientCertMiddleware(root)
-	s.handler = h2c.NewHandler(root, &http2.Server{})
+	s.handler = s.withH2C(root)
+}
+
+// Cleartext http/2 for prior knowledge and upgrade clients, optionally proxies only
+func (s *Server) withH2C(root http.Handler) http.Handler {
+	if s.H2C == nil {
+		return root
+	}
+	h2 := h2c.NewHandler(root, s.H2C)
+	if !s.H2CTrustedOnly {
+		return h2
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if admin.IsTrustedPeer(r.RemoteAddr) {
+			h2.ServeHTTP(w, r)
+			return
+		}
+		root.ServeHTTP(w, r)
+	})
 }
 
 // Live public hostname for portal aware middleware
@@ -241,8 +513,9 @@ func (s *Server) primaryHostname() string {
 // enforcement waits until a certificate is actually servable
 func (s *Server) httpsOnlyRedirect(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Acme and the trust anchor must work before a client trusts our tls
-		if strings.HasPrefix(r.URL.Path, "/acme/") || r.URL.Path == "/.well-known/distroface/ca.pem" {
+		// Acme and the trust anchor must work before a client trusts our tls,
+		// scrapers on the private network stay on cleartext
+		if strings.HasPrefix(r.URL.Path, "/acme/") || r.URL.Path == "/.well-known/distroface/ca.pem" || r.URL.Path == "/metrics" {
 			next.ServeHTTP(w, r)
 			return
 		}
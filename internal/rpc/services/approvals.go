@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/policy"
+)
+
+// Two admin rule for deleting repositories, tags and organizations above
+// the configured size
+type deletionApprovals struct {
+	gate *policy.Approvals
+}
+
+// policy.Approvals.Check with its refusals mapped to connect codes
+func (d *deletionApprovals) check(ctx context.Context, user *auth.AuthenticatedUser, resource, target, reason string, size func() (int64, error)) (*time.Time, error) {
+	expires, err := d.gate.Check(ctx, user, resource, target, reason, size)
+	switch {
+	case err == nil:
+		return expires, nil
+	case errors.Is(err, policy.ErrSecondAdmin):
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	case errors.Is(err, policy.ErrApprovalClaimed):
+		return nil, connect.NewError(connect.CodeAborted, err)
+	}
+	return nil, connect.NewError(connect.CodeInternal, err)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Turns on the two admin rule for deletes of at least bytes
+func (e *testEnv) dualApproval(bytes int64) {
+	e.t.Helper()
+	patch := &v1.Settings{Security: &v1.SecuritySettings{Deletion: &v1.DeletionSettings{DualApprovalBytes: proto.Int64(bytes)}}}
+	if _, err := e.res.Update(context.Background(), v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", patch, []string{"security.deletion.dual_approval_bytes"}); err != nil {
+		e.t.Fatalf("Update: %v", err)
+	}
+}
+
+// Stores a one layer image under tag
+func (e *testEnv) image(namespace, name, tag string, layer []byte) {
+	e.t.Helper()
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    []ocispec.Descriptor{{MediaType: ocispec.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if _, err := e.registry.PutManifest(context.Background(), namespace, name, map[string][]byte{
+		ocispec.MediaTypeImageConfig: config,
+		ocispec.MediaTypeImageLayer:  layer,
+	}, manifest, tag); err != nil {
+		e.t.Fatalf("PutManifest: %v", err)
+	}
+}
+
+func (e *testEnv) org(name string) *db.Organization {
+	e.t.Helper()
+	org := &db.Organization{ID: uuid.New().String(), Name: name, CreatedBy: "test"}
+	if err := e.store.CreateOrganization(context.Background(), org); err != nil {
+		e.t.Fatalf("CreateOrganization: %v", err)
+	}
+	return org
+}
+
+// Untagging a large image waits for a second admin like deleting its repo
+func TestDeleteTagNeedsSecondAdmin(t *testing.T) {
+	e := newTestEnv(t)
+	e.dualApproval(1000)
+	alice, bob, carol := e.user("alice", "admin"), e.user("bob", "admin"), e.user("carol", "user")
+	e.org("acme")
+	e.repo("acme", "app", false)
+	e.image("acme", "app", "big", make([]byte, 2000))
+	e.image("acme", "app", "small", []byte("tiny"))
+
+	untag := func(ctx context.Context, tag string) (*v1.DeleteTagResponse, error) {
+		resp, err := e.repos.DeleteTag(ctx, connect.NewRequest(&v1.DeleteTagRequest{Namespace: "acme", Name: "app", Tag: tag}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.Msg, nil
+	}
+	tagged := func(tag string) bool {
+		_, err := e.registry.ResolveManifest(context.Background(), "acme", "app", tag)
+		return err == nil
+	}
+
+	if resp, err := untag(alice, "small"); err != nil || resp.PendingApproval || tagged("small") {
+		t.Fatalf("small untag = %v, %v", resp, err)
+	}
+	resp, err := untag(alice, "big")
+	if err != nil || !resp.PendingApproval || resp.ApprovalExpiresAt == nil {
+		t.Fatalf("large untag = %v, %v, want pending", resp, err)
+	}
+	if !tagged("big") {
+		t.Fatal("pending untag removed the tag")
+	}
+	e.grant(carol, "acme", "app", "write")
+	if _, err := untag(carol, "big"); connectCode(err) != connect.CodePermissionDenied {
+		t.Fatalf("non admin approving: %v, want permission denied", err)
+	}
+	if resp, err := untag(bob, "big"); err != nil || resp.PendingApproval || tagged("big") {
+		t.Fatalf("second admin untag = %v, %v, want removed", resp, err)
+	}
+}
+
+// Deleting an org drops every repo in its namespace, so it is held the same way
+func TestDeleteOrganizationNeedsSecondAdmin(t *testing.T) {
+	e := newTestEnv(t)
+	e.dualApproval(1000)
+	orgs := NewOrganizationService(e.store, e.registry, e.enforcer, e.res, logger.New())
+	alice, bob := e.user("alice", "admin"), e.user("bob", "admin")
+	org := e.org("acme")
+	e.repo("acme", "app", false)
+	e.image("acme", "app", "1.0", make([]byte, 2000))
+
+	del := func(ctx context.Context) (*v1.DeleteOrganizationResponse, error) {
+		resp, err := orgs.DeleteOrganization(ctx, connect.NewRequest(&v1.DeleteOrganizationRequest{Id: org.ID}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.Msg, nil
+	}
+	resp, err := del(alice)
+	if err != nil || !resp.PendingApproval {
+		t.Fatalf("first delete = %v, %v, want pending", resp, err)
+	}
+	if got, _ := e.store.GetOrganizationByID(context.Background(), org.ID); got == nil {
+		t.Fatal("pending delete removed the organization")
+	}
+	if _, err := e.registry.ResolveManifest(context.Background(), "acme", "app", "1.0"); err != nil {
+		t.Fatalf("pending delete removed storage: %v", err)
+	}
+	if resp, err := del(bob); err != nil || resp.PendingApproval {
+		t.Fatalf("second admin delete = %v, %v", resp, err)
+	}
+	if got, _ := e.store.GetOrganizationByID(context.Background(), org.ID); got != nil {
+		t.Fatal("approved delete kept the organization")
+	}
+}
@@ -2,43 +2,56 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"path"
-	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
 	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/admin"
 	"github.com/nickheyer/distroface/internal/artifacts"
 	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/mirror"
+	"github.com/nickheyer/distroface/internal/policy"
 	"github.com/nickheyer/distroface/internal/portal"
 	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/scan"
+	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
 	"github.com/nickheyer/distroface/pkg/pages"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"github.com/nickheyer/distroface/pkg/utils"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var _ distrofacev1connect.ArtifactServiceHandler = (*ArtifactService)(nil)
 
-var artifactRepoNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,127}$`)
-
 type ArtifactService struct {
-	store   *stores.Store
-	manager *artifacts.Manager
-	access  *artifacts.Access
-	mirrors *mirror.Monitor
-	log     *logger.Logger
+	store     *stores.Store
+	manager   *artifacts.Manager
+	access    *artifacts.Access
+	mirrors   *mirror.Monitor
+	approvals *deletionApprovals
+	log       *logger.Logger
 }
 
-func NewArtifactService(store *stores.Store, manager *artifacts.Manager, enforcer *rbac.Enforcer, mirrors *mirror.Monitor, log *logger.Logger) *ArtifactService {
-	return &ArtifactService{store: store, manager: manager, access: artifacts.NewAccess(store, enforcer), mirrors: mirrors, log: log}
+func NewArtifactService(store *stores.Store, resolver *settings.Resolver, manager *artifacts.Manager, enforcer *rbac.Enforcer, mirrors *mirror.Monitor, log *logger.Logger) *ArtifactService {
+	return &ArtifactService{
+		store:     store,
+		manager:   manager,
+		access:    artifacts.NewAccess(store, enforcer, resolver),
+		mirrors:   mirrors,
+		approvals: &deletionApprovals{gate: policy.NewApprovals(store, resolver, enforcer)},
+		log:       log,
+	}
 }
 
 // ── Repositories ─────────────────────────────────────────────────────────
@@ -51,41 +64,59 @@ func (s *ArtifactService) CreateArtifactRepository(ctx context.Context, req *con
 
 	msg := req.Msg
 	ns, name := repoRef(ctx, user, msg.Namespace, msg.Name)
-	if !artifactRepoNamePattern.MatchString(name) {
-		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid repository name"))
+	name, err := utils.NormalizeRepoName(utils.ArtifactRepo, name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 	if !s.access.CanCreateInNamespace(ctx, user, ns) {
 		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot create repository in namespace %q", ns))
 	}
+	if err := s.access.CheckNewNamespace(ctx, user, ns, msg.AllowReserved); err != nil {
+		if msg.AllowReserved {
+			return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("overriding reserved namespace %q needs artifacts manage", ns))
+		}
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("namespace %q is reserved, an admin may override", ns))
+	}
 
+	// Lookups ignore case, so does uniqueness
 	existing, err := s.store.GetArtifactRepository(ctx, ns, name)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 	if existing != nil {
-		return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("repository %q already exists", ns+"/"+name))
+		return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("repository %q already exists", existing.Namespace+"/"+existing.Name))
 	}
 
 	repoType := msg.Type
 	if repoType == v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_UNSPECIFIED {
 		repoType = v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_FILE
 	}
+	if _, ok := v1.ArtifactRepoType_name[int32(repoType)]; !ok {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown repository type %d", repoType))
+	}
 	mirrorCfg := ""
-	if repoType != v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_FILE {
-		if err := s.mirrors.ValidateArtifactMirror(ctx, repoType, msg.Mirror); err != nil {
+	if slices.Contains(mirror.MirrorArtifactTypes, repoType) {
+		if err := s.mirrors.ValidateArtifactMirror(ctx, ns, repoType, msg.Mirror); err != nil {
 			return nil, mapMirrorErr(err)
 		}
 		if mirrorCfg, err = mirror.EncodeConfig(msg.Mirror); err != nil {
 			return nil, connect.NewError(connect.CodeInternal, err)
 		}
 	} else if msg.Mirror != nil {
-		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("file repositories do not take mirror settings"))
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s repositories do not take mirror settings", repoTypeLabel(repoType)))
 	}
 
 	isPrivate := msg.IsPrivate
 	if !isPrivate && ns != user.Username {
 		isPrivate = s.manager.EffectivePrivateByDefault(ctx, ns)
 	}
+	// A bare flag cannot tell an asked for public repo from the default
+	isPrivate, _ = s.manager.Visibility().ForCreate(ctx, user.Roles, isPrivate, false)
+	if msg.OciExport {
+		if err := artifacts.ValidateOCIBridgeName(ns, name); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
 	repo := &storage.ArtifactRepository{
 		Namespace:    ns,
 		Name:         name,
@@ -94,6 +125,7 @@ func (s *ArtifactService) CreateArtifactRepository(ctx context.Context, req *con
 		IsPrivate:    isPrivate,
 		Type:         repoType,
 		MirrorConfig: mirrorCfg,
+		OCIExport:    msg.OciExport,
 	}
 	if err := s.store.CreateArtifactRepository(ctx, repo); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
@@ -116,8 +148,15 @@ func (s *ArtifactService) GetArtifactRepository(ctx context.Context, req *connec
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
+	retention, queryDefaults, err := s.manager.RepoOverrides(ctx, repo)
+	if err != nil {
+		s.log.Error("repo settings for repo %d: %v", repo.ID, err)
+	}
+
 	return connect.NewResponse(&v1.GetArtifactRepositoryResponse{
-		Repository: s.repoToProto(ctx, repo, stats),
+		Repository:    s.repoToProto(ctx, repo, stats),
+		Retention:     retention,
+		QueryDefaults: queryDefaults,
 	}), nil
 }
 
@@ -131,7 +170,7 @@ func (s *ArtifactService) ListArtifactRepositories(ctx context.Context, req *con
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	opts := s.access.ListOptions(user, portal.ScopeNamespace(ctx, msg.Namespace))
+	opts := s.access.ListOptions(ctx, user, portal.ScopeNamespace(ctx, msg.Namespace))
 	opts.Query = q
 	opts.Limit = limit
 	opts.Offset = offset
@@ -172,11 +211,22 @@ func (s *ArtifactService) UpdateArtifactRepository(ctx context.Context, req *con
 		repo.Description = *req.Msg.Description
 	}
 	if req.Msg.IsPrivate != nil {
+		if err := s.manager.Visibility().CheckChange(ctx, user.Roles, repo.IsPrivate, *req.Msg.IsPrivate); err != nil {
+			return nil, connect.NewError(connect.CodePermissionDenied, err)
+		}
 		repo.IsPrivate = *req.Msg.IsPrivate
 	}
+	if req.Msg.OciExport != nil {
+		if *req.Msg.OciExport {
+			if err := artifacts.ValidateOCIBridgeName(repo.Namespace, repo.Name); err != nil {
+				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+			}
+		}
+		repo.OCIExport = *req.Msg.OciExport
+	}
 	if req.Msg.Mirror != nil {
-		if repo.Type == v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_FILE {
-			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("file repositories do not take mirror settings"))
+		if !slices.Contains(mirror.MirrorArtifactTypes, repo.Type) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s repositories do not take mirror settings", repoTypeLabel(repo.Type)))
 		}
 		merged, err := mirror.ApplyUpdate(repo.MirrorConfig, req.Msg.Mirror)
 		if err != nil {
@@ -186,13 +236,17 @@ func (s *ArtifactService) UpdateArtifactRepository(ctx context.Context, req *con
 		if err != nil {
 			return nil, mapMirrorErr(err)
 		}
-		if err := s.mirrors.ValidateArtifactMirror(ctx, repo.Type, full); err != nil {
+		if err := s.mirrors.ValidateArtifactMirror(ctx, repo.Namespace, repo.Type, full); err != nil {
 			return nil, mapMirrorErr(err)
 		}
 		repo.MirrorConfig = merged
 		// Fresh config invalidates the conditional request cursor
 		repo.MirrorState = ""
 	}
+	// Both live on the repo settings tier, the one source the resolver reads
+	if err := s.manager.SetRepoOverrides(ctx, repo, req.Msg.Retention, req.Msg.QueryDefaults); err != nil {
+		return nil, mapArtifactErr(err)
+	}
 	if err := s.store.UpdateArtifactRepository(ctx, repo); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -209,6 +263,22 @@ func (s *ArtifactService) DeleteArtifactRepository(ctx context.Context, req *con
 		return nil, err
 	}
 
+	target := repo.Namespace + "/" + repo.Name
+	expires, err := s.approvals.check(ctx, user, rbac.ResourceArtifacts, target, req.Msg.Reason, func() (int64, error) {
+		stats, err := s.store.GetArtifactRepoStats(ctx, []int64{repo.ID})
+		return stats[repo.ID].Size, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expires != nil {
+		s.log.Info("Delete of artifact repository %s by %s awaits a second admin", target, user.Username)
+		return connect.NewResponse(&v1.DeleteArtifactRepositoryResponse{
+			PendingApproval:   true,
+			ApprovalExpiresAt: timestamppb.New(*expires),
+		}), nil
+	}
+
 	if err := s.manager.DeleteRepository(ctx, repo); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -248,9 +318,9 @@ func (s *ArtifactService) InitiateArtifactUpload(ctx context.Context, req *conne
 		return nil, err
 	}
 
-	uploadID, err := s.manager.Blobs().InitiateUpload()
+	uploadID, err := s.manager.InitiateUpload(ctx, repo, req.Msg.Size)
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+		return nil, mapArtifactErr(err)
 	}
 
 	return connect.NewResponse(&v1.InitiateArtifactUploadResponse{
@@ -267,13 +337,20 @@ func (s *ArtifactService) CompleteArtifactUpload(ctx context.Context, req *conne
 		return nil, err
 	}
 
-	artifact, err := s.manager.CompleteUpload(ctx, repo, msg.UploadId, msg.Version, msg.Path, msg.Metadata, msg.Properties)
+	opts := artifacts.WriteOptions{
+		IfNotExists: msg.IfNotExists,
+		Overwrite:   msg.Overwrite,
+		Checksum:    msg.Sha256,
+		ExpiresIn:   time.Duration(msg.ExpiresInSeconds) * time.Second,
+	}
+	artifact, skipped, err := s.manager.CompleteUploadWith(ctx, repo, msg.UploadId, msg.Version, msg.Path, msg.Metadata, msg.Properties, opts)
 	if err != nil {
 		return nil, mapArtifactErr(err)
 	}
 
 	return connect.NewResponse(&v1.CompleteArtifactUploadResponse{
 		Artifact: artifactToProto(artifact),
+		Skipped:  skipped,
 	}), nil
 }
 
@@ -354,6 +431,33 @@ func (s *ArtifactService) ListArtifactVersions(ctx context.Context, req *connect
 	}), nil
 }
 
+func (s *ArtifactService) ListLatestArtifacts(ctx context.Context, req *connect.Request[v1.ListLatestArtifactsRequest]) (*connect.Response[v1.ListLatestArtifactsResponse], error) {
+	user := auth.UserFromContext(ctx)
+	repo, err := s.visibleRepo(ctx, user, req.Msg.Namespace, req.Msg.RepoName)
+	if err != nil {
+		return nil, err
+	}
+	pointers, err := s.store.ListLatestPointers(ctx, repo.ID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.ListLatestArtifactsResponse{}
+	for _, p := range pointers {
+		latest := &v1.LatestArtifact{
+			Path:       p.Path,
+			Version:    p.Version,
+			Digest:     p.Digest,
+			ArtifactId: p.ArtifactID,
+			UpdatedAt:  timestamppb.New(p.UpdatedAt),
+		}
+		if err := json.Unmarshal([]byte(p.Filter), &latest.Filter); err != nil {
+			s.log.Warn("latest pointer %s in repo %d has a bad filter: %v", p.Path, repo.ID, err)
+		}
+		resp.Latest = append(resp.Latest, latest)
+	}
+	return connect.NewResponse(resp), nil
+}
+
 // Version groups default to natural version order newest first
 func versionOrder(p *v1.PageRequest) (byVersion, desc bool) {
 	fields := strings.Fields(strings.ToLower(p.GetOrderBy()))
@@ -387,14 +491,16 @@ func (s *ArtifactService) SearchArtifacts(ctx context.Context, req *connect.Requ
 		Offset:     offset,
 	}
 
+	var fullNames map[int64]string
 	if msg.RepoName != "" {
 		repo, err := s.visibleRepo(ctx, user, msg.Namespace, msg.RepoName)
 		if err != nil {
 			return nil, err
 		}
 		criteria.RepoID = &repo.ID
+		fullNames = map[int64]string{repo.ID: repo.Namespace + "/" + repo.Name}
 	} else {
-		repoIDs, err := s.visibleRepoIDs(ctx, user, portal.ScopeNamespace(ctx, msg.Namespace))
+		repoIDs, names, err := s.visibleRepoIDs(ctx, user, portal.ScopeNamespace(ctx, msg.Namespace))
 		if err != nil {
 			return nil, connect.NewError(connect.CodeInternal, err)
 		}
@@ -402,6 +508,7 @@ func (s *ArtifactService) SearchArtifacts(ctx context.Context, req *connect.Requ
 			return connect.NewResponse(&v1.SearchArtifactsResponse{Artifacts: []*v1.Artifact{}, Page: &v1.PageInfo{}}), nil
 		}
 		criteria.RepoIDs = repoIDs
+		fullNames = names
 	}
 
 	list, total, err := s.store.SearchArtifacts(ctx, criteria)
@@ -409,8 +516,13 @@ func (s *ArtifactService) SearchArtifacts(ctx context.Context, req *connect.Requ
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
+	out := artifactsToProto(list)
+	for _, a := range out {
+		a.RepoFullName = fullNames[a.RepoId]
+	}
+
 	return connect.NewResponse(&v1.SearchArtifactsResponse{
-		Artifacts: artifactsToProto(list),
+		Artifacts: out,
 		Page:      pages.Info(offset, limit, total),
 	}), nil
 }
@@ -430,7 +542,13 @@ func (s *ArtifactService) UpdateArtifact(ctx context.Context, req *connect.Reque
 		return nil, err
 	}
 
+	if msg.Path != nil || msg.Name != nil || msg.Version != nil {
+		if err := artifacts.CheckPackageRename(repo); err != nil {
+			return nil, mapArtifactErr(err)
+		}
+	}
 	if msg.Path != nil {
+		*msg.Path = artifacts.NormalizePath(*msg.Path)
 		if err := artifacts.ValidatePath(*msg.Path); err != nil {
 			return nil, mapArtifactErr(err)
 		}
@@ -461,7 +579,7 @@ func (s *ArtifactService) UpdateArtifact(ctx context.Context, req *connect.Reque
 	}
 
 	if err := s.store.UpdateArtifact(ctx, artifact); err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint") {
+		if stores.IsUniqueViolation(err) {
 			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("an artifact with that version and path already exists"))
 		}
 		return nil, connect.NewError(connect.CodeInternal, err)
@@ -485,6 +603,9 @@ func (s *ArtifactService) SetArtifactProperties(ctx context.Context, req *connec
 		return nil, err
 	}
 
+	if err := s.manager.ValidateProperties(ctx, repo, msg.Properties); err != nil {
+		return nil, mapArtifactErr(err)
+	}
 	if err := s.store.SetArtifactProperties(ctx, artifact.ID, msg.Properties); err != nil {
 		if errors.Is(err, stores.ErrDuplicateIdentity) {
 			return nil, connect.NewError(connect.CodeAlreadyExists, err)
@@ -498,46 +619,181 @@ func (s *ArtifactService) SetArtifactProperties(ctx context.Context, req *connec
 	}), nil
 }
 
-func (s *ArtifactService) DeleteArtifact(ctx context.Context, req *connect.Request[v1.DeleteArtifactRequest]) (*connect.Response[v1.DeleteArtifactResponse], error) {
+// Bulk edits cap out here, narrower criteria split larger jobs
+const bulkEditLimit = 10000
+
+func (s *ArtifactService) BulkEditArtifactProperties(ctx context.Context, req *connect.Request[v1.BulkEditArtifactPropertiesRequest]) (*connect.Response[v1.BulkEditArtifactPropertiesResponse], error) {
 	user := auth.UserFromContext(ctx)
 	msg := req.Msg
-	repo, err := s.mutableRepo(ctx, user, msg.Namespace, msg.RepoName, rbac.ActionDelete)
-	if err != nil {
-		return nil, err
+	if len(msg.Set) == 0 && len(msg.Unset) == 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("set or unset at least one property"))
+	}
+	for k := range msg.Set {
+		if k == "" || slices.Contains(msg.Unset, k) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("property %q is empty or both set and unset", k))
+		}
+	}
+	if len(msg.MatchProperties) == 0 && len(msg.Query.GetFilters()) == 0 && msg.Query.GetText() == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("a query or match properties are required"))
+	}
+
+	q := pages.ParseQuery(&v1.PageRequest{Query: msg.Query})
+	if err := stores.ArtifactsQuery.Validate(q); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	criteria := stores.ArtifactSearchCriteria{
+		Query:      q,
+		Properties: msg.MatchProperties,
+		OrderBy:    "created_at ASC",
+		Limit:      bulkEditLimit + 1,
 	}
 
-	var artifact *storage.Artifact
-	if msg.Id != "" {
-		artifact, err = s.repoArtifact(ctx, repo, msg.Id)
+	repos := map[int64]*storage.ArtifactRepository{}
+	if msg.RepoName != "" {
+		repo, err := s.mutableRepo(ctx, user, msg.Namespace, msg.RepoName, rbac.ActionUpdate)
 		if err != nil {
 			return nil, err
 		}
+		criteria.RepoID = &repo.ID
+		repos[repo.ID] = repo
 	} else {
-		if msg.Version == "" || msg.Path == "" {
-			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("id or version+path is required"))
-		}
-		artifact, err = s.store.GetArtifactByPathVersion(ctx, repo.ID, msg.Version, msg.Path)
+		visible, _, err := s.store.ListArtifactRepositories(ctx, s.access.ListOptions(ctx, user, portal.ScopeNamespace(ctx, msg.Namespace)))
 		if err != nil {
 			return nil, connect.NewError(connect.CodeInternal, err)
 		}
-		if artifact == nil {
-			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("artifact not found"))
+		for _, r := range visible {
+			repos[r.ID] = r
+			criteria.RepoIDs = append(criteria.RepoIDs, r.ID)
+		}
+		if len(repos) == 0 {
+			return connect.NewResponse(&v1.BulkEditArtifactPropertiesResponse{Changes: []*v1.ArtifactPropertyChange{}, Applied: !msg.Preview}), nil
 		}
 	}
 
+	matched, _, err := s.store.SearchArtifacts(ctx, criteria)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if len(matched) > bulkEditLimit {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("more than %d artifacts match, narrow the criteria", bulkEditLimit))
+	}
+
+	// Every touched repo must allow the edit, partial runs are refused
+	allowed := map[int64]bool{}
+	changes := map[string]map[string]string{}
+	out := []*v1.ArtifactPropertyChange{}
+	for _, a := range matched {
+		props := maps.Clone(a.Properties)
+		if props == nil {
+			props = map[string]string{}
+		}
+		maps.Copy(props, msg.Set)
+		for _, k := range msg.Unset {
+			delete(props, k)
+		}
+		if maps.Equal(props, a.Properties) {
+			continue
+		}
+		repo := repos[a.RepoID]
+		ok, seen := allowed[a.RepoID]
+		if !seen {
+			ok = repo != nil && s.access.HasRepoAccess(ctx, user, repo, rbac.ActionUpdate)
+			allowed[a.RepoID] = ok
+		}
+		if !ok {
+			return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("no update access to a matched repository, narrow the criteria"))
+		}
+		if err := s.manager.ValidateProperties(ctx, repo, props); err != nil {
+			return nil, mapArtifactErr(fmt.Errorf("%s %s: %w", a.Version, a.Path, err))
+		}
+
+		changes[a.ID] = props
+		pa := artifactToProto(a)
+		pa.RepoFullName = repo.Namespace + "/" + repo.Name
+		out = append(out, &v1.ArtifactPropertyChange{Artifact: pa, Properties: props})
+	}
+
+	if !msg.Preview && len(changes) > 0 {
+		if err := s.store.SetArtifactPropertiesBulk(ctx, changes); err != nil {
+			if errors.Is(err, stores.ErrDuplicateIdentity) {
+				return nil, connect.NewError(connect.CodeAlreadyExists, err)
+			}
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		s.log.Info("Bulk property edit by %s changed %d of %d matched artifacts", user.Username, len(changes), len(matched))
+	}
+
+	return connect.NewResponse(&v1.BulkEditArtifactPropertiesResponse{
+		Matched: int32(len(matched)),
+		Changes: out,
+		Applied: !msg.Preview,
+	}), nil
+}
+
+func (s *ArtifactService) DeleteArtifact(ctx context.Context, req *connect.Request[v1.DeleteArtifactRequest]) (*connect.Response[v1.DeleteArtifactResponse], error) {
+	user := auth.UserFromContext(ctx)
+	msg := req.Msg
+	repo, err := s.mutableRepo(ctx, user, msg.Namespace, msg.RepoName, rbac.ActionDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact, err := s.pickArtifact(ctx, repo, msg.Id, msg.Version, msg.Path)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := s.manager.DeleteArtifact(ctx, artifact); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 	return connect.NewResponse(&v1.DeleteArtifactResponse{}), nil
 }
 
+func (s *ArtifactService) SetArtifactExpiry(ctx context.Context, req *connect.Request[v1.SetArtifactExpiryRequest]) (*connect.Response[v1.SetArtifactExpiryResponse], error) {
+	user := auth.UserFromContext(ctx)
+	msg := req.Msg
+	repo, err := s.mutableRepo(ctx, user, msg.Namespace, msg.RepoName, rbac.ActionUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact, err := s.pickArtifact(ctx, repo, msg.Id, msg.Version, msg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.manager.SetExpiry(ctx, artifact, time.Duration(msg.ExpiresInSeconds)*time.Second); err != nil {
+		return nil, mapArtifactErr(err)
+	}
+	return connect.NewResponse(&v1.SetArtifactExpiryResponse{
+		Artifact: artifactToProto(artifact),
+	}), nil
+}
+
 // ── Access helpers ───────────────────────────────────────────────────────
 
-// Portal mapping first, then empty namespace defaults to caller username
+// Artifact by id, or by version and path
+func (s *ArtifactService) pickArtifact(ctx context.Context, repo *storage.ArtifactRepository, id, version, artifactPath string) (*storage.Artifact, error) {
+	if id != "" {
+		return s.repoArtifact(ctx, repo, id)
+	}
+	if version == "" || artifactPath == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("id or version+path is required"))
+	}
+	artifact, err := s.store.GetArtifactByPathVersion(ctx, repo.ID, version, artifactPath)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if artifact == nil {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("artifact not found"))
+	}
+	return artifact, nil
+}
+
+// Portal mapping first, then empty namespace defaults to the caller's home
 func repoRef(ctx context.Context, user *auth.AuthenticatedUser, namespace, name string) (string, string) {
 	namespace, name = portal.ScopeRepoRef(ctx, namespace, name)
 	if namespace == "" && user != nil {
-		namespace = user.Username
+		namespace = user.HomeNamespace()
 	}
 	return namespace, name
 }
@@ -547,7 +803,6 @@ func (s *ArtifactService) visibleRepo(ctx context.Context, user *auth.Authentica
 	if name == "" {
 		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("repository name is required"))
 	}
-	bare := namespace == ""
 	ns, name := repoRef(ctx, user, namespace, name)
 	if portal.ForeignRef(ctx, ns) {
 		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("artifact repository not found"))
@@ -556,48 +811,15 @@ func (s *ArtifactService) visibleRepo(ctx context.Context, user *auth.Authentica
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
-	// Portal traffic resolves by mapping alone, never fuzzy
-	if repo == nil && bare && portal.FromContext(ctx) == nil {
-		if repo, err = s.visibleRepoByName(ctx, user, name); err != nil {
-			return nil, err
-		}
-	}
 	if repo == nil {
 		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("artifact repository not found"))
 	}
-	if repo.IsPrivate && !s.access.HasRepoAccess(ctx, user, repo, rbac.ActionRead) {
+	if !s.access.CanSee(ctx, user, repo) {
 		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("access denied"))
 	}
 	return repo, nil
 }
 
-// Bare refs fall back to the unique visible match anywhere
-func (s *ArtifactService) visibleRepoByName(ctx context.Context, user *auth.AuthenticatedUser, name string) (*storage.ArtifactRepository, error) {
-	repos, _, err := s.store.ListArtifactRepositories(ctx, s.access.ListOptions(user, ""))
-	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
-	}
-	var matches []*storage.ArtifactRepository
-	for _, r := range repos {
-		if r.Name == name {
-			matches = append(matches, r)
-		}
-	}
-	switch len(matches) {
-	case 0:
-		return nil, nil
-	case 1:
-		return matches[0], nil
-	default:
-		var full []string
-		for _, m := range matches {
-			full = append(full, m.Namespace+"/"+m.Name)
-		}
-		return nil, connect.NewError(connect.CodeInvalidArgument,
-			fmt.Errorf("repository name %q is ambiguous, qualify it as one of: %s", name, strings.Join(full, ", ")))
-	}
-}
-
 // Upload access, private repos need owner or grant
 func (s *ArtifactService) pushableRepo(ctx context.Context, user *auth.AuthenticatedUser, namespace, name string) (*storage.ArtifactRepository, error) {
 	repo, cerr := s.visibleRepo(ctx, user, namespace, name)
@@ -632,18 +854,20 @@ func (s *ArtifactService) mutableRepo(ctx context.Context, user *auth.Authentica
 	return repo, nil
 }
 
-// Repo ids readable by the user, optionally scoped to a namespace
-func (s *ArtifactService) visibleRepoIDs(ctx context.Context, user *auth.AuthenticatedUser, namespace string) ([]int64, error) {
-	repos, _, err := s.store.ListArtifactRepositories(ctx, s.access.ListOptions(user, namespace))
+// Repo ids readable by the user with their full names, optionally scoped to a namespace
+func (s *ArtifactService) visibleRepoIDs(ctx context.Context, user *auth.AuthenticatedUser, namespace string) ([]int64, map[int64]string, error) {
+	repos, _, err := s.store.ListArtifactRepositories(ctx, s.access.ListOptions(ctx, user, namespace))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	ids := make([]int64, 0, len(repos))
+	names := make(map[int64]string, len(repos))
 	for _, r := range repos {
 		ids = append(ids, r.ID)
+		names[r.ID] = r.Namespace + "/" + r.Name
 	}
 	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
-	return ids, nil
+	return ids, names, nil
 }
 
 // Upload URL, org repos carry a namespace marker the v1 facade strips
@@ -671,16 +895,36 @@ func (s *ArtifactService) repoArtifact(ctx context.Context, repo *storage.Artifa
 
 func mapArtifactErr(err error) error {
 	switch {
-	case errors.Is(err, artifacts.ErrInvalid):
+	case errors.Is(err, artifacts.ErrInvalid), errors.Is(err, artifacts.ErrChecksumMismatch):
 		return connect.NewError(connect.CodeInvalidArgument, err)
 	case errors.Is(err, artifacts.ErrUploadNotFound):
 		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, artifacts.ErrExists):
+		return connect.NewError(connect.CodeAlreadyExists, err)
+	case errors.Is(err, artifacts.ErrNoSpace), errors.Is(err, artifacts.ErrQuotaExceeded):
+		return connect.NewError(connect.CodeResourceExhausted, err)
+	case errors.Is(err, admin.ErrStorageUnavailable):
+		return connect.NewError(connect.CodeUnavailable, err)
+	case errors.Is(err, policy.ErrDenied):
+		return connect.NewError(connect.CodePermissionDenied, err)
 	default:
 		return connect.NewError(connect.CodeInternal, err)
 	}
 }
 
 // Config faults map to invalid, unreachable upstreams to unavailable
+// Short lowercase name for messages, file for plain repositories
+func repoTypeLabel(t v1.ArtifactRepoType) string {
+	switch t {
+	case v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN:
+		return "debian"
+	case v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_RPM:
+		return "rpm"
+	default:
+		return "file"
+	}
+}
+
 func mapMirrorErr(err error) error {
 	if errors.Is(err, mirror.ErrInvalid) {
 		return connect.NewError(connect.CodeInvalidArgument, err)
@@ -721,9 +965,13 @@ func (s *ArtifactService) repoToProto(ctx context.Context, repo *storage.Artifac
 		Type:            repo.Type,
 		Mirror:          mirror.Redacted(repo.MirrorConfig),
 		MirrorLastError: repo.MirrorLastError,
+		OciExport:       repo.OCIExport,
 		CreatedAt:       timestamppb.New(repo.CreatedAt),
 		UpdatedAt:       timestamppb.New(repo.UpdatedAt),
 	}
+	if repo.OCIExport {
+		out.OciName = artifacts.OCIBridgeName(repo.Namespace, repo.Name)
+	}
 	if repo.MirrorLastSync != nil {
 		out.MirrorLastSync = timestamppb.New(*repo.MirrorLastSync)
 	}
@@ -741,7 +989,7 @@ func (s *ArtifactService) repoToProto(ctx context.Context, repo *storage.Artifac
 }
 
 func artifactToProto(a *storage.Artifact) *v1.Artifact {
-	return &v1.Artifact{
+	out := &v1.Artifact{
 		Id:         a.ID,
 		RepoId:     a.RepoID,
 		Name:       a.Name,
@@ -756,6 +1004,25 @@ func artifactToProto(a *storage.Artifact) *v1.Artifact {
 		CreatedAt:  timestamppb.New(a.CreatedAt),
 		UpdatedAt:  timestamppb.New(a.UpdatedAt),
 	}
+	if a.ScanStatus != "" {
+		out.Scan = &v1.ArtifactScan{
+			Status:      a.ScanStatus,
+			Engine:      a.ScanEngine,
+			Quarantined: a.ScanStatus == scan.StatusInfected,
+		}
+		if a.ScanStatus == scan.StatusInfected {
+			out.Scan.Signature = a.ScanResult
+		} else {
+			out.Scan.Error = a.ScanResult
+		}
+		if a.ScannedAt != nil {
+			out.Scan.ScannedAt = timestamppb.New(*a.ScannedAt)
+		}
+	}
+	if a.ExpiresAt != nil {
+		out.ExpiresAt = timestamppb.New(*a.ExpiresAt)
+	}
+	return out
 }
 
 func artifactsToProto(list []*storage.Artifact) []*v1.Artifact {
@@ -5,7 +5,9 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -14,6 +16,8 @@ import (
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/webhook"
 	"github.com/nickheyer/distroface/pkg/logger"
 	"github.com/nickheyer/distroface/pkg/pages"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
@@ -27,16 +31,33 @@ var _ distrofacev1connect.AuthServiceHandler = (*AuthService)(nil)
 
 var usernameRegex = utils.UsernameRegex
 
+// Usernames double as namespaces, so reserved names and namespaces
+// already held by repos or orgs cannot be claimed
+func checkUsernameClaimable(ctx context.Context, store *stores.Store, res *settings.Resolver, username string) error {
+	if err := res.CheckNamespace(ctx, username); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("username %q is reserved", username))
+	}
+	claimed, err := store.NamespaceClaimed(ctx, username)
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	if claimed {
+		return connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("username %q collides with an existing namespace", username))
+	}
+	return nil
+}
+
 type AuthService struct {
 	store       *stores.Store
 	log         *logger.Logger
 	authManager *auth.Manager
 	enforcer    *rbac.Enforcer
 	oidcHandler *auth.OIDCHandler
+	hooks       *webhook.Dispatcher
 }
 
-func NewAuthService(store *stores.Store, manager *auth.Manager, enforcer *rbac.Enforcer, oidcHandler *auth.OIDCHandler, log *logger.Logger) *AuthService {
-	return &AuthService{store: store, authManager: manager, enforcer: enforcer, oidcHandler: oidcHandler, log: log}
+func NewAuthService(store *stores.Store, manager *auth.Manager, enforcer *rbac.Enforcer, oidcHandler *auth.OIDCHandler, hooks *webhook.Dispatcher, log *logger.Logger) *AuthService {
+	return &AuthService{store: store, authManager: manager, enforcer: enforcer, oidcHandler: oidcHandler, hooks: hooks, log: log}
 }
 
 func (s *AuthService) Register(ctx context.Context, req *connect.Request[v1.RegisterRequest]) (*connect.Response[v1.RegisterResponse], error) {
@@ -86,6 +107,15 @@ func (s *AuthService) Register(ctx context.Context, req *connect.Request[v1.Regi
 		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
 	}
 
+	sys := s.authManager.Settings().System(ctx)
+	if err := checkUsernameClaimable(ctx, s.store, s.authManager.Settings(), msg.Username); err != nil {
+		// Collisions read as a taken username, private namespaces stay hidden
+		if connect.CodeOf(err) == connect.CodeAlreadyExists {
+			return nil, connect.NewError(connect.CodeAlreadyExists, nil)
+		}
+		return nil, err
+	}
+
 	existing, err := s.store.GetUserByUsername(ctx, msg.Username)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
@@ -104,7 +134,7 @@ func (s *AuthService) Register(ctx context.Context, req *connect.Request[v1.Regi
 		}
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(msg.Password), bcrypt.DefaultCost)
+	hash, err := s.authManager.HashPassword(ctx, msg.Password)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -119,13 +149,17 @@ func (s *AuthService) Register(ctx context.Context, req *connect.Request[v1.Regi
 		emailPtr = &msg.Email
 	}
 
+	// Invites are admin issued and the first user bootstraps the instance
+	pending := count > 0 && invite == nil && sys.GetAuth().GetRegistrationRequiresApproval()
+
 	user := &storage.User{
-		Username:     msg.Username,
-		Email:        emailPtr,
-		PasswordHash: string(hash),
-		DisplayName:  msg.Username,
-		AuthProvider: "local",
-		IsActive:     true,
+		Username:        msg.Username,
+		Email:           emailPtr,
+		PasswordHash:    hash,
+		DisplayName:     msg.Username,
+		AuthProvider:    "local",
+		IsActive:        !pending,
+		PendingApproval: pending,
 	}
 
 	if err := s.store.CreateUser(ctx, user); err != nil {
@@ -162,6 +196,15 @@ func (s *AuthService) Register(ctx context.Context, req *connect.Request[v1.Regi
 	}
 
 	roles, _ := s.store.GetUserRoles(ctx, user.ID)
+	s.notifyRegistered(sys.GetAuth(), user, pending)
+
+	// Default roles are already in place for when an admin approves
+	if pending {
+		return connect.NewResponse(&v1.RegisterResponse{
+			User:            userToProto(user, roles),
+			PendingApproval: true,
+		}), nil
+	}
 
 	// Login the newly registered user
 	_, _, token, _, err := s.authManager.Login(ctx, msg.Username, msg.Password)
@@ -178,6 +221,81 @@ func (s *AuthService) Register(ctx context.Context, req *connect.Request[v1.Regi
 	}), nil
 }
 
+// Registration hook body, receivers send verification mail or page admins
+type registeredPayload struct {
+	Event           string `json:"event"`
+	Timestamp       string `json:"timestamp"`
+	UserID          string `json:"user_id"`
+	Username        string `json:"username"`
+	Email           string `json:"email,omitempty"`
+	PendingApproval bool   `json:"pending_approval"`
+}
+
+func (s *AuthService) notifyRegistered(cfg *v1.AuthSettings, user *storage.User, pending bool) {
+	if s.hooks == nil || cfg.GetRegistrationHookUrl() == "" {
+		return
+	}
+	payload := registeredPayload{
+		Event:           "user.registered",
+		Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		UserID:          user.ID,
+		Username:        user.Username,
+		PendingApproval: pending,
+	}
+	if user.Email != nil {
+		payload.Email = *user.Email
+	}
+	s.hooks.Notify(cfg.GetRegistrationHookUrl(), cfg.GetRegistrationHookSecret(), payload.Event, payload)
+}
+
+// V1 compat signup for old dfcli and scripts, same rules as Register
+func (s *AuthService) HandleV1Register(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username   string `json:"username"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		InviteCode string `json:"invite_code"`
+		InvitePin  string `json:"invite_pin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "INVALID REQUEST", http.StatusBadRequest)
+		return
+	}
+	req := &v1.RegisterRequest{Username: body.Username, Email: body.Email, Password: body.Password}
+	if body.InviteCode != "" {
+		req.InviteCode = &body.InviteCode
+		req.InvitePin = &body.InvitePin
+	}
+
+	resp, err := s.Register(r.Context(), connect.NewRequest(req))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch connect.CodeOf(err) {
+		case connect.CodeInvalidArgument:
+			status = http.StatusBadRequest
+		case connect.CodeAlreadyExists:
+			status = http.StatusConflict
+		case connect.CodePermissionDenied:
+			status = http.StatusForbidden
+		}
+		msg := strings.ToUpper(http.StatusText(status))
+		var cerr *connect.Error
+		if errors.As(err, &cerr) && cerr.Message() != "" {
+			msg = cerr.Message()
+		}
+		http.Error(w, msg, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"username":         resp.Msg.User.Username,
+		"token":            resp.Msg.SessionToken,
+		"pending_approval": resp.Msg.PendingApproval,
+	})
+}
+
 func (s *AuthService) Login(ctx context.Context, req *connect.Request[v1.LoginRequest]) (*connect.Response[v1.LoginResponse], error) {
 	msg := req.Msg
 
@@ -531,6 +649,9 @@ func userToProto(u *storage.User, roles []*storage.Role) *v1.User {
 		IsActive:           u.IsActive,
 		MustChangePassword: u.MustChangePassword,
 		OidcLinked:         u.OIDCSubject != "",
+		PendingApproval:    u.PendingApproval,
+		DefaultNamespace:   u.DefaultNamespace,
+		ArtifactQuotaMb:    u.ArtifactQuotaMB,
 		CreatedAt:          timestamppb.New(u.CreatedAt),
 		UpdatedAt:          timestamppb.New(u.UpdatedAt),
 	}
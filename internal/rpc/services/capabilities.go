@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/artifacts"
+	"github.com/nickheyer/distroface/internal/settings"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Optional parts mounted at startup, settings backed ones are read per call
+type Features struct {
+	Artifacts   bool
+	Registry    bool
+	Webhooks    bool
+	Compression bool
+}
+
+// Where GetCapabilities reads what is mounted and the live settings
+func (s *HealthService) SetFeatures(f Features, res *settings.Resolver) {
+	s.features, s.res = f, res
+}
+
+// Public like the health check, it only describes the wire
+func (s *HealthService) GetCapabilities(ctx context.Context, req *connect.Request[v1.GetCapabilitiesRequest]) (*connect.Response[v1.GetCapabilitiesResponse], error) {
+	// Uploads, downloads and archives all ride the v1 data plane
+	dataPlane := s.features.Artifacts && (s.res == nil || s.res.System(ctx).GetArtifacts().GetV1Compat())
+	caps := []*v1.Capability{
+		{
+			Name:     "artifacts.resumable_uploads",
+			Enabled:  dataPlane,
+			Endpoint: "/api/v1/artifacts/{repo}/upload/{upload_id}",
+			Description: "PATCH appends a chunk, an optional Content-Range first-last must start at the current offset or gets 416. " +
+				"HEAD answers Upload-Offset and Range with what landed, resume from there. PUT completes the upload",
+		},
+		{
+			Name:     "artifacts.range_downloads",
+			Enabled:  dataPlane,
+			Endpoint: "/api/v1/artifacts/{repo}/{version}/{path}",
+			Description: "GET honors Range and If-Range, HEAD sizes the file first. " +
+				"ETag is the sha256 digest and If-None-Match with it answers 304",
+		},
+		{
+			Name:        "artifacts.archives",
+			Enabled:     dataPlane,
+			Endpoint:    "/api/v1/artifacts/{repo}/query",
+			Description: "Streams every matching artifact as one archive, pick the format with ?format=",
+			Options:     []string{artifacts.FormatZip, artifacts.FormatTarGz},
+		},
+		{
+			Name:        "artifacts.zstd_archives",
+			Description: "Archives are not offered zstd compressed, use tar.gz",
+		},
+		{
+			Name:        "registry.referrers",
+			Enabled:     s.features.Registry,
+			Endpoint:    "/v2/{name}/referrers/{digest}",
+			Description: "OCI referrers API, lists the signatures this server made for a manifest",
+		},
+		{
+			Name:        "webhooks",
+			Enabled:     s.features.Webhooks,
+			Description: "Repository and system webhooks, managed through WebhookService",
+		},
+		{
+			Name:        "api.compression",
+			Enabled:     s.features.Compression,
+			Description: "API responses are gzip or deflate compressed for clients sending Accept-Encoding",
+			Options:     []string{"gzip", "deflate"},
+		},
+	}
+	return connect.NewResponse(&v1.GetCapabilitiesResponse{
+		Version:      appVersion(),
+		Capabilities: caps,
+	}), nil
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/auth"
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/notify"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/webhook"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/pages"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ distrofacev1connect.CommentServiceHandler = (*CommentService)(nil)
+
+const maxCommentBodyLen = 10000
+
+// Comments ride on the repo services' visibility rules
+type CommentService struct {
+	store      *stores.Store
+	repos      *RepositoryService
+	artifacts  *ArtifactService // Nil when artifact storage is off
+	dispatcher *webhook.Dispatcher
+	notifier   *notify.Notifier // Image comments reach it through the dispatcher
+	log        *logger.Logger
+}
+
+func NewCommentService(store *stores.Store, repos *RepositoryService, artifacts *ArtifactService, dispatcher *webhook.Dispatcher, notifier *notify.Notifier, log *logger.Logger) *CommentService {
+	return &CommentService{store: store, repos: repos, artifacts: artifacts, dispatcher: dispatcher, notifier: notifier, log: log}
+}
+
+// Resolved comment target, exactly one repo is set
+type commentTarget struct {
+	kind         v1.CommentTargetType
+	repo         *storage.Repository
+	artifactRepo *storage.ArtifactRepository
+	ref          string
+}
+
+func (t *commentTarget) key() stores.CommentTarget {
+	if t.repo != nil {
+		return stores.CommentTarget{RepoID: t.repo.ID, Ref: t.ref}
+	}
+	return stores.CommentTarget{ArtifactRepoID: t.artifactRepo.ID, Ref: t.ref}
+}
+
+func (t *commentTarget) path() (string, string) {
+	if t.repo != nil {
+		return t.repo.Namespace, t.repo.Name
+	}
+	return t.artifactRepo.Namespace, t.artifactRepo.Name
+}
+
+// Loads the repo behind a target the caller can read, hidden ones read as missing
+func (s *CommentService) target(ctx context.Context, kind v1.CommentTargetType, namespace, name, ref string) (*commentTarget, error) {
+	if ref == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("ref is required"))
+	}
+	switch kind {
+	case v1.CommentTargetType_COMMENT_TARGET_TYPE_IMAGE_TAG:
+		repo, err := s.repos.readableRepo(ctx, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		return &commentTarget{kind: kind, repo: repo, ref: ref}, nil
+	case v1.CommentTargetType_COMMENT_TARGET_TYPE_ARTIFACT_VERSION:
+		if s.artifacts == nil {
+			return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("artifact storage is disabled"))
+		}
+		repo, err := s.artifacts.visibleRepo(ctx, auth.UserFromContext(ctx), namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		return &commentTarget{kind: kind, artifactRepo: repo, ref: ref}, nil
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("target type is required"))
+	}
+}
+
+// Target of a stored comment, nil when the caller can no longer read it
+func (s *CommentService) commentTarget(ctx context.Context, c *storage.Comment) *commentTarget {
+	switch {
+	case c.Repo != nil:
+		if !s.repos.canReadRepo(ctx, c.Repo) {
+			return nil
+		}
+		return &commentTarget{kind: v1.CommentTargetType_COMMENT_TARGET_TYPE_IMAGE_TAG, repo: c.Repo, ref: c.Ref}
+	case c.ArtifactRepo != nil && s.artifacts != nil:
+		if !s.artifacts.access.CanSee(ctx, auth.UserFromContext(ctx), c.ArtifactRepo) {
+			return nil
+		}
+		return &commentTarget{kind: v1.CommentTargetType_COMMENT_TARGET_TYPE_ARTIFACT_VERSION, artifactRepo: c.ArtifactRepo, ref: c.Ref}
+	}
+	return nil
+}
+
+// Repo managers moderate every comment on their repo
+func (s *CommentService) canModerate(ctx context.Context, user *auth.AuthenticatedUser, t *commentTarget) bool {
+	if t.repo != nil {
+		return s.repos.canManageRepo(ctx, user, t.repo)
+	}
+	return s.artifacts.access.HasRepoAccess(ctx, user, t.artifactRepo, rbac.ActionUpdate)
+}
+
+func commentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("comment body is required"))
+	}
+	if utf8.RuneCountInString(body) > maxCommentBodyLen {
+		return "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("comment body exceeds %d characters", maxCommentBodyLen))
+	}
+	return body, nil
+}
+
+// Anonymous sessions read but never write
+func commentAuthor(ctx context.Context) (*auth.AuthenticatedUser, error) {
+	user := auth.UserFromContext(ctx)
+	if auth.IsAnonymous(user) {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	return user, nil
+}
+
+func (s *CommentService) ListComments(ctx context.Context, req *connect.Request[v1.ListCommentsRequest]) (*connect.Response[v1.ListCommentsResponse], error) {
+	msg := req.Msg
+	t, err := s.target(ctx, msg.TargetType, msg.Namespace, msg.Name, msg.Ref)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, offset := pages.Parse(msg.Page)
+	comments, total, err := s.store.ListComments(ctx, t.key(), limit, offset)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	out := make([]*v1.Comment, 0, len(comments))
+	for _, c := range comments {
+		out = append(out, commentToProto(t, c))
+	}
+	return connect.NewResponse(&v1.ListCommentsResponse{
+		Comments: out,
+		Page:     pages.Info(offset, limit, total),
+	}), nil
+}
+
+func (s *CommentService) CreateComment(ctx context.Context, req *connect.Request[v1.CreateCommentRequest]) (*connect.Response[v1.CreateCommentResponse], error) {
+	user, err := commentAuthor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg := req.Msg
+	body, err := commentBody(msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.target(ctx, msg.TargetType, msg.Namespace, msg.Name, msg.Ref)
+	if err != nil {
+		return nil, err
+	}
+
+	comment := &storage.Comment{
+		Ref:      t.ref,
+		AuthorID: user.ID,
+		Author:   user.Username,
+		Body:     body,
+	}
+	if t.repo != nil {
+		desc, err := s.repos.registry.ResolveManifest(ctx, t.repo.Namespace, t.repo.Name, t.ref)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("tag %q not found", t.ref))
+		}
+		comment.RepoID = &t.repo.ID
+		comment.Digest = desc.Digest.String()
+	} else {
+		_, n, err := s.store.ListArtifacts(ctx, t.artifactRepo.ID, t.ref, 1, 0)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		if n == 0 {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("version %q not found", t.ref))
+		}
+		comment.ArtifactRepoID = &t.artifactRepo.ID
+	}
+	if err := s.store.CreateComment(ctx, comment); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	// Only image repos carry webhooks
+	if t.repo != nil && s.dispatcher != nil {
+		s.dispatcher.DispatchComment(context.WithoutCancel(ctx), t.repo.Namespace, t.repo.Name, t.ref, comment.Digest, webhook.CommentPayload{
+			ID:       comment.ID,
+			Author:   comment.Author,
+			AuthorID: comment.AuthorID,
+			Body:     comment.Body,
+		})
+	}
+	if t.artifactRepo != nil && s.notifier != nil {
+		repo := t.artifactRepo
+		go s.notifier.Mentions(context.WithoutCancel(ctx), notify.Event{
+			Kind:      notify.EventComment,
+			Namespace: repo.Namespace,
+			Name:      repo.Name,
+			Ref:       t.ref,
+			Actor:     comment.Author,
+			ActorID:   comment.AuthorID,
+			CommentID: comment.ID,
+			Body:      comment.Body,
+		}, func(u *auth.AuthenticatedUser) bool {
+			return s.artifacts.access.CanSee(context.Background(), u, repo)
+		})
+	}
+	return connect.NewResponse(&v1.CreateCommentResponse{Comment: commentToProto(t, comment)}), nil
+}
+
+func (s *CommentService) UpdateComment(ctx context.Context, req *connect.Request[v1.UpdateCommentRequest]) (*connect.Response[v1.UpdateCommentResponse], error) {
+	user, err := commentAuthor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := commentBody(req.Msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	comment, t, err := s.getComment(ctx, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	// Moderators remove but never put words in someone's mouth
+	if comment.AuthorID != user.ID {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("only the author can edit a comment"))
+	}
+	if err := s.store.UpdateCommentBody(ctx, comment, body); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.UpdateCommentResponse{Comment: commentToProto(t, comment)}), nil
+}
+
+func (s *CommentService) DeleteComment(ctx context.Context, req *connect.Request[v1.DeleteCommentRequest]) (*connect.Response[v1.DeleteCommentResponse], error) {
+	user, err := commentAuthor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	comment, t, err := s.getComment(ctx, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	if comment.AuthorID != user.ID && !s.canModerate(ctx, user, t) {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("only the author or a repository manager can delete a comment"))
+	}
+	if err := s.store.DeleteComment(ctx, comment.ID); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.DeleteCommentResponse{}), nil
+}
+
+// Loads a comment on a target the caller can read, others read as missing
+func (s *CommentService) getComment(ctx context.Context, id string) (*storage.Comment, *commentTarget, error) {
+	if id == "" {
+		return nil, nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("comment id is required"))
+	}
+	comment, err := s.store.GetComment(ctx, id)
+	if err != nil {
+		return nil, nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if comment == nil {
+		return nil, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("comment not found"))
+	}
+	t := s.commentTarget(ctx, comment)
+	if t == nil {
+		return nil, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("comment not found"))
+	}
+	return comment, t, nil
+}
+
+func commentToProto(t *commentTarget, c *storage.Comment) *v1.Comment {
+	namespace, name := t.path()
+	return &v1.Comment{
+		Id:         c.ID,
+		TargetType: t.kind,
+		Namespace:  namespace,
+		Name:       name,
+		Ref:        c.Ref,
+		Body:       c.Body,
+		Author:     c.Author,
+		Digest:     c.Digest,
+		CreatedAt:  timestamppb.New(c.CreatedAt),
+		UpdatedAt:  timestamppb.New(c.UpdatedAt),
+		// Create stamps both in one go, later edits move updated_at alone
+		Edited: c.UpdatedAt.Sub(c.CreatedAt) > 0,
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+const imageTag = v1.CommentTargetType_COMMENT_TARGET_TYPE_IMAGE_TAG
+
+// Comments pin the digest they were written against, only authors edit
+// and authors or repo managers delete
+func TestImageTagComments(t *testing.T) {
+	e := newTestEnv(t)
+	comments := NewCommentService(e.store, e.repos, nil, nil, nil, logger.New())
+	alice, bob := e.user("alice"), e.user("bob")
+	e.repo("alice", "app", false)
+	e.image("alice", "app", "1.0", []byte("app layer"))
+	desc, err := e.registry.ResolveManifest(t.Context(), "alice", "app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := comments.CreateComment(bob, connect.NewRequest(&v1.CreateCommentRequest{
+		TargetType: imageTag, Namespace: "alice", Name: "app", Ref: "1.0", Body: "  works on arm64  ",
+	}))
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	c := created.Msg.Comment
+	if c.Body != "works on arm64" || c.Author != "bob" || c.Digest != desc.Digest.String() || c.Edited {
+		t.Fatalf("comment = %+v", c)
+	}
+
+	for _, bad := range []struct {
+		name string
+		msg  *v1.CreateCommentRequest
+		want connect.Code
+	}{
+		{"blank body", &v1.CreateCommentRequest{TargetType: imageTag, Namespace: "alice", Name: "app", Ref: "1.0", Body: " "}, connect.CodeInvalidArgument},
+		{"long body", &v1.CreateCommentRequest{TargetType: imageTag, Namespace: "alice", Name: "app", Ref: "1.0", Body: strings.Repeat("é", maxCommentBodyLen+1)}, connect.CodeInvalidArgument},
+		{"no ref", &v1.CreateCommentRequest{TargetType: imageTag, Namespace: "alice", Name: "app", Body: "hi"}, connect.CodeInvalidArgument},
+		{"no target type", &v1.CreateCommentRequest{Namespace: "alice", Name: "app", Ref: "1.0", Body: "hi"}, connect.CodeInvalidArgument},
+		{"missing tag", &v1.CreateCommentRequest{TargetType: imageTag, Namespace: "alice", Name: "app", Ref: "2.0", Body: "hi"}, connect.CodeNotFound},
+		{"artifacts off", &v1.CreateCommentRequest{TargetType: v1.CommentTargetType_COMMENT_TARGET_TYPE_ARTIFACT_VERSION, Namespace: "alice", Name: "files", Ref: "1.0", Body: "hi"}, connect.CodeUnimplemented},
+	} {
+		if _, err := comments.CreateComment(bob, connect.NewRequest(bad.msg)); connectCode(err) != bad.want {
+			t.Errorf("%s: %v, want %v", bad.name, err, bad.want)
+		}
+	}
+	if _, err := comments.CreateComment(t.Context(), connect.NewRequest(&v1.CreateCommentRequest{
+		TargetType: imageTag, Namespace: "alice", Name: "app", Ref: "1.0", Body: "hi",
+	})); connectCode(err) != connect.CodeUnauthenticated {
+		t.Errorf("anonymous comment: %v", err)
+	}
+
+	// The repo owner moderates but cannot reword someone else's comment
+	if _, err := comments.UpdateComment(alice, connect.NewRequest(&v1.UpdateCommentRequest{Id: c.Id, Body: "edited"})); connectCode(err) != connect.CodePermissionDenied {
+		t.Fatalf("edit by non author: %v", err)
+	}
+	updated, err := comments.UpdateComment(bob, connect.NewRequest(&v1.UpdateCommentRequest{Id: c.Id, Body: "works on arm64 and amd64"}))
+	if err != nil {
+		t.Fatalf("UpdateComment: %v", err)
+	}
+	if updated.Msg.Comment.Body != "works on arm64 and amd64" || !updated.Msg.Comment.Edited {
+		t.Fatalf("updated = %+v", updated.Msg.Comment)
+	}
+
+	list, err := comments.ListComments(alice, connect.NewRequest(&v1.ListCommentsRequest{TargetType: imageTag, Namespace: "alice", Name: "app", Ref: "1.0"}))
+	if err != nil {
+		t.Fatalf("ListComments: %v", err)
+	}
+	if len(list.Msg.Comments) != 1 || list.Msg.Comments[0].Body != "works on arm64 and amd64" || list.Msg.Page.GetTotalCount() != 1 {
+		t.Fatalf("listed = %v", list.Msg)
+	}
+
+	if _, err := comments.DeleteComment(alice, connect.NewRequest(&v1.DeleteCommentRequest{Id: c.Id})); err != nil {
+		t.Fatalf("delete by repo owner: %v", err)
+	}
+	if _, err := comments.DeleteComment(bob, connect.NewRequest(&v1.DeleteCommentRequest{Id: c.Id})); connectCode(err) != connect.CodeNotFound {
+		t.Fatalf("delete of a deleted comment: %v", err)
+	}
+}
+
+// Comments on a repo the caller cannot read look like they do not exist
+func TestCommentsOnPrivateRepos(t *testing.T) {
+	e := newTestEnv(t)
+	comments := NewCommentService(e.store, e.repos, nil, nil, nil, logger.New())
+	bob, carol, dave := e.user("bob"), e.user("carol"), e.user("dave")
+	e.org("acme")
+	e.repo("acme", "secret", true)
+	e.image("acme", "secret", "1.0", []byte("private layer"))
+	e.grant(bob, "acme", "secret", "read")
+
+	created, err := comments.CreateComment(bob, connect.NewRequest(&v1.CreateCommentRequest{
+		TargetType: imageTag, Namespace: "acme", Name: "secret", Ref: "1.0", Body: "hello",
+	}))
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	id := created.Msg.Comment.Id
+
+	if _, err := comments.ListComments(carol, connect.NewRequest(&v1.ListCommentsRequest{TargetType: imageTag, Namespace: "acme", Name: "secret", Ref: "1.0"})); connectCode(err) != connect.CodeNotFound {
+		t.Errorf("list without read: %v", err)
+	}
+	if _, err := comments.CreateComment(carol, connect.NewRequest(&v1.CreateCommentRequest{TargetType: imageTag, Namespace: "acme", Name: "secret", Ref: "1.0", Body: "hi"})); connectCode(err) != connect.CodeNotFound {
+		t.Errorf("comment without read: %v", err)
+	}
+	if _, err := comments.DeleteComment(carol, connect.NewRequest(&v1.DeleteCommentRequest{Id: id})); connectCode(err) != connect.CodeNotFound {
+		t.Errorf("delete without read: %v", err)
+	}
+
+	// Read alone does not make someone a moderator
+	e.grant(dave, "acme", "secret", "read")
+	if _, err := comments.DeleteComment(dave, connect.NewRequest(&v1.DeleteCommentRequest{Id: id})); connectCode(err) != connect.CodePermissionDenied {
+		t.Errorf("delete by reader: %v", err)
+	}
+	if _, err := comments.DeleteComment(bob, connect.NewRequest(&v1.DeleteCommentRequest{Id: id})); err != nil {
+		t.Errorf("delete by author: %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/auth"
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/mirror"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/vault"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/pages"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ distrofacev1connect.CredentialServiceHandler = (*CredentialService)(nil)
+
+type CredentialService struct {
+	store    *stores.Store
+	vault    *vault.Vault
+	mirrors  *mirror.Monitor
+	enforcer *rbac.Enforcer
+	log      *logger.Logger
+}
+
+func NewCredentialService(store *stores.Store, vlt *vault.Vault, mirrors *mirror.Monitor, enforcer *rbac.Enforcer, log *logger.Logger) *CredentialService {
+	return &CredentialService{store: store, vault: vlt, mirrors: mirrors, enforcer: enforcer, log: log}
+}
+
+// Namespace owners and org admins, or anyone with repository manage
+func (s *CredentialService) canManage(ctx context.Context, user *auth.AuthenticatedUser, namespace string) bool {
+	return canManageNamespace(ctx, s.store, s.enforcer, user, namespace)
+}
+
+// Nil means every namespace
+func (s *CredentialService) managedNamespaces(ctx context.Context, user *auth.AuthenticatedUser) ([]string, error) {
+	return managedNamespaces(ctx, s.store, s.enforcer, user)
+}
+
+// Namespace owners and org admins, or anyone with repository manage
+func canManageNamespace(ctx context.Context, store *stores.Store, enforcer *rbac.Enforcer, user *auth.AuthenticatedUser, namespace string) bool {
+	if namespace == user.Username {
+		return true
+	}
+	if isMember, role, _ := store.IsOrgMember(ctx, namespace, user.ID); isMember {
+		return role == storage.OrgRoleOwner || role == storage.OrgRoleAdmin
+	}
+	return enforcer.HasPermission(user.Roles, rbac.ResourceRepositories, rbac.ActionManage)
+}
+
+// Namespaces canManageNamespace allows, nil means every namespace
+func managedNamespaces(ctx context.Context, store *stores.Store, enforcer *rbac.Enforcer, user *auth.AuthenticatedUser) ([]string, error) {
+	if enforcer.HasPermission(user.Roles, rbac.ResourceRepositories, rbac.ActionManage) {
+		return nil, nil
+	}
+	namespaces := []string{user.Username}
+	roles, err := store.ListUserOrgRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for orgID, role := range roles {
+		if role != storage.OrgRoleOwner && role != storage.OrgRoleAdmin {
+			continue
+		}
+		if org, _ := store.GetOrganizationByID(ctx, orgID); org != nil {
+			namespaces = append(namespaces, org.Name)
+		}
+	}
+	return namespaces, nil
+}
+
+// Loads a credential the caller may manage, foreign ones read as missing
+func (s *CredentialService) getManaged(ctx context.Context, user *auth.AuthenticatedUser, id string) (*storage.Credential, error) {
+	cred, err := s.store.GetCredential(ctx, id)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if cred == nil || !s.canManage(ctx, user, cred.Namespace) {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("credential not found"))
+	}
+	return cred, nil
+}
+
+func (s *CredentialService) CreateCredential(ctx context.Context, req *connect.Request[v1.CreateCredentialRequest]) (*connect.Response[v1.CreateCredentialResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	if s.vault == nil {
+		return nil, connect.NewError(connect.CodeUnavailable, fmt.Errorf("credential vault is unavailable"))
+	}
+
+	msg := req.Msg
+	ns := msg.Namespace
+	if ns == "" {
+		ns = user.Username
+	}
+	if !imageRepoNamePattern.MatchString(msg.Name) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid credential name"))
+	}
+	if msg.Secret == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("secret is required"))
+	}
+	if !s.canManage(ctx, user, ns) {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot manage credentials in namespace %q", ns))
+	}
+	existing, err := s.store.GetCredentialByName(ctx, ns, msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if existing != nil {
+		return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("credential %q already exists in %s", msg.Name, ns))
+	}
+
+	// Sealed value binds the row id, so it is fixed before sealing
+	cred := &storage.Credential{
+		ID:          uuid.New().String(),
+		Namespace:   ns,
+		Name:        msg.Name,
+		Description: msg.Description,
+		Username:    strings.TrimSpace(msg.Username),
+		CreatedBy:   user.ID,
+	}
+	if err := s.vault.SetSecret(cred, msg.Secret); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if err := s.store.CreateCredential(ctx, cred); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.CreateCredentialResponse{Credential: credentialToProto(cred)}), nil
+}
+
+func (s *CredentialService) ListCredentials(ctx context.Context, req *connect.Request[v1.ListCredentialsRequest]) (*connect.Response[v1.ListCredentialsResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+
+	var namespaces []string
+	if ns := req.Msg.Namespace; ns != "" {
+		if !s.canManage(ctx, user, ns) {
+			return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot manage credentials in namespace %q", ns))
+		}
+		namespaces = []string{ns}
+	} else {
+		var err error
+		if namespaces, err = s.managedNamespaces(ctx, user); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+	}
+
+	limit, offset := pages.Parse(req.Msg.Page)
+	creds, total, err := s.store.ListCredentials(ctx, namespaces, limit, offset)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	out := make([]*v1.Credential, 0, len(creds))
+	for _, c := range creds {
+		out = append(out, credentialToProto(c))
+	}
+	return connect.NewResponse(&v1.ListCredentialsResponse{
+		Credentials: out,
+		Page:        pages.Info(offset, limit, total),
+	}), nil
+}
+
+func (s *CredentialService) UpdateCredential(ctx context.Context, req *connect.Request[v1.UpdateCredentialRequest]) (*connect.Response[v1.UpdateCredentialResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	cred, err := s.getManaged(ctx, user, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := req.Msg
+	if msg.Description != nil {
+		cred.Description = *msg.Description
+	}
+	if msg.Username != nil {
+		cred.Username = strings.TrimSpace(*msg.Username)
+	}
+	if msg.Secret != nil {
+		if *msg.Secret == "" {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("secret must not be empty"))
+		}
+		if s.vault == nil {
+			return nil, connect.NewError(connect.CodeUnavailable, fmt.Errorf("credential vault is unavailable"))
+		}
+		if err := s.vault.SetSecret(cred, *msg.Secret); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+	}
+	if err := s.store.UpdateCredential(ctx, cred); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.UpdateCredentialResponse{Credential: credentialToProto(cred)}), nil
+}
+
+func (s *CredentialService) DeleteCredential(ctx context.Context, req *connect.Request[v1.DeleteCredentialRequest]) (*connect.Response[v1.DeleteCredentialResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	cred, err := s.getManaged(ctx, user, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleting under a mirror would fail its next sync silently
+	if s.mirrors != nil {
+		users, err := s.mirrors.CredentialUsers(ctx, cred.ID)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		if len(users) > 0 {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("credential is used by %s", strings.Join(users, ", ")))
+		}
+	}
+	if err := s.store.DeleteCredential(ctx, cred.ID); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.DeleteCredentialResponse{}), nil
+}
+
+func credentialToProto(c *storage.Credential) *v1.Credential {
+	return &v1.Credential{
+		Id:          c.ID,
+		Namespace:   c.Namespace,
+		Name:        c.Name,
+		Description: c.Description,
+		Username:    c.Username,
+		SecretSet:   c.Secret != "",
+		CreatedBy:   c.CreatedBy,
+		CreatedAt:   timestamppb.New(c.CreatedAt),
+		UpdatedAt:   timestamppb.New(c.UpdatedAt),
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/export"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+)
+
+var _ distrofacev1connect.ExportServiceHandler = (*ExportService)(nil)
+
+// Walking manifest trees is not free, one request covers this many repos
+const maxExportRepos = 200
+
+// Export manifests cover only repositories the caller can read
+type ExportService struct {
+	repos     *RepositoryService
+	artifacts *ArtifactService // Nil when artifact storage is off
+	builder   *export.Builder
+	res       *settings.Resolver
+	log       *logger.Logger
+}
+
+func NewExportService(store *stores.Store, repos *RepositoryService, artifacts *ArtifactService, res *settings.Resolver, log *logger.Logger) *ExportService {
+	return &ExportService{
+		repos:     repos,
+		artifacts: artifacts,
+		builder:   export.NewBuilder(store, repos.registry),
+		res:       res,
+		log:       log,
+	}
+}
+
+func splitExportRepo(ref string) (string, string, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("repository %q must be namespace/name", ref))
+	}
+	return namespace, name, nil
+}
+
+// Manifest of the selected repositories, hidden ones fail like missing ones
+func (s *ExportService) build(ctx context.Context, images, artifacts []string) (*export.Manifest, error) {
+	if len(images)+len(artifacts) == 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("select at least one repository"))
+	}
+	if len(images)+len(artifacts) > maxExportRepos {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("at most %d repositories per export", maxExportRepos))
+	}
+	if len(artifacts) > 0 && s.artifacts == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("artifact storage is disabled"))
+	}
+
+	m := &export.Manifest{
+		Version:     export.FormatVersion,
+		Instance:    s.res.System(ctx).GetServer().GetPublicHostname(),
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+		Images:      []export.Image{},
+		Artifacts:   []export.ArtifactRepo{},
+	}
+	seen := map[string]bool{}
+	for _, ref := range images {
+		namespace, name, err := splitExportRepo(ref)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := s.repos.readableRepo(ctx, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if key := "image:" + repo.Namespace + "/" + repo.Name; !seen[key] {
+			seen[key] = true
+			img, err := s.builder.Image(ctx, repo)
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInternal, err)
+			}
+			m.Images = append(m.Images, img)
+		}
+	}
+	user := auth.UserFromContext(ctx)
+	for _, ref := range artifacts {
+		namespace, name, err := splitExportRepo(ref)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := s.artifacts.visibleRepo(ctx, user, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if key := "artifact:" + repo.Namespace + "/" + repo.Name; !seen[key] {
+			seen[key] = true
+			files, err := s.builder.Artifacts(ctx, repo)
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInternal, err)
+			}
+			m.Artifacts = append(m.Artifacts, files)
+		}
+	}
+	return m, nil
+}
+
+func (s *ExportService) sign(ctx context.Context, m *export.Manifest) (*v1.SignedExportManifest, error) {
+	payload, err := m.Encode()
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	key, sig, err := s.repos.signer.SignDocument(ctx, payload)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("signing export manifest: %w", err))
+	}
+	return &v1.SignedExportManifest{
+		Manifest:     payload,
+		Signature:    base64.StdEncoding.EncodeToString(sig),
+		KeyId:        key.ID,
+		PublicKeyPem: key.PublicKey,
+	}, nil
+}
+
+func (s *ExportService) GetExportManifest(ctx context.Context, req *connect.Request[v1.GetExportManifestRequest]) (*connect.Response[v1.GetExportManifestResponse], error) {
+	sel := req.Msg.GetSelection()
+	m, err := s.build(ctx, sel.GetImages(), sel.GetArtifacts())
+	if err != nil {
+		return nil, err
+	}
+	signed, err := s.sign(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &v1.GetExportManifestResponse{Signed: signed, Images: int32(len(m.Images))}
+	counted := map[string]bool{}
+	for _, img := range m.Images {
+		resp.Tags += int32(len(img.Tags))
+		for _, b := range img.Blobs {
+			if !counted[b.Digest] {
+				counted[b.Digest] = true
+				resp.Blobs++
+				resp.TotalBytes += b.Size
+			}
+		}
+	}
+	for _, repo := range m.Artifacts {
+		resp.Files += int32(len(repo.Files))
+		for _, f := range repo.Files {
+			if !counted[f.Digest] {
+				counted[f.Digest] = true
+				resp.TotalBytes += f.Size
+			}
+		}
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (s *ExportService) DiffExportManifest(ctx context.Context, req *connect.Request[v1.DiffExportManifestRequest]) (*connect.Response[v1.DiffExportManifestResponse], error) {
+	if len(req.Msg.Base) == 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("base manifest is required"))
+	}
+	base, err := export.Decode(req.Msg.Base)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	images, artifacts := req.Msg.GetSelection().GetImages(), req.Msg.GetSelection().GetArtifacts()
+	if len(images)+len(artifacts) == 0 {
+		for _, img := range base.Images {
+			images = append(images, img.Repository)
+		}
+		for _, repo := range base.Artifacts {
+			artifacts = append(artifacts, repo.Repository)
+		}
+	}
+	current, err := s.build(ctx, images, artifacts)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := s.sign(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+
+	delta := export.Diff(base, current)
+	resp := &v1.DiffExportManifestResponse{TransferBytes: delta.Bytes, Current: signed}
+	for _, b := range delta.Blobs {
+		resp.Blobs = append(resp.Blobs, &v1.ExportBlob{Repository: b.Repository, Digest: b.Blob.Digest, Size: b.Blob.Size, MediaType: b.Blob.MediaType})
+	}
+	for _, t := range delta.Tags {
+		resp.Tags = append(resp.Tags, exportTagToProto(t))
+	}
+	for _, t := range delta.RemovedTags {
+		resp.RemovedTags = append(resp.RemovedTags, exportTagToProto(t))
+	}
+	for _, f := range delta.Files {
+		resp.Files = append(resp.Files, exportFileToProto(f))
+	}
+	for _, f := range delta.RemovedFiles {
+		resp.RemovedFiles = append(resp.RemovedFiles, exportFileToProto(f))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func exportTagToProto(t export.TagChange) *v1.ExportTag {
+	return &v1.ExportTag{Repository: t.Repository, Tag: t.Tag.Name, Digest: t.Tag.Digest}
+}
+
+func exportFileToProto(f export.FileChange) *v1.ExportFile {
+	return &v1.ExportFile{
+		Repository: f.Repository,
+		Version:    f.File.Version,
+		Path:       f.File.Path,
+		Digest:     f.File.Digest,
+		Size:       f.File.Size,
+		Properties: f.File.Properties,
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/auth"
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/policy"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ distrofacev1connect.FreezeServiceHandler = (*FreezeService)(nil)
+
+const maxFreezeReasonLen = 500
+
+// Freeze windows are enforced by the registry listener, this only keeps them
+type FreezeService struct {
+	store *stores.Store
+	log   *logger.Logger
+}
+
+func NewFreezeService(store *stores.Store, log *logger.Logger) *FreezeService {
+	return &FreezeService{store: store, log: log}
+}
+
+func (s *FreezeService) ListFreezeWindows(ctx context.Context, req *connect.Request[v1.ListFreezeWindowsRequest]) (*connect.Response[v1.ListFreezeWindowsResponse], error) {
+	now := time.Now()
+	windows, err := s.store.ListFreezeWindows(ctx, now, req.Msg.IncludePast)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.ListFreezeWindowsResponse{}
+	for _, w := range windows {
+		if req.Msg.Repository != "" && !policy.FreezeCovers(w.Pattern, req.Msg.Repository) {
+			continue
+		}
+		resp.Windows = append(resp.Windows, freezeToProto(w, now))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (s *FreezeService) CreateFreezeWindow(ctx context.Context, req *connect.Request[v1.CreateFreezeWindowRequest]) (*connect.Response[v1.CreateFreezeWindowResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+
+	msg := req.Msg
+	pattern := strings.ToLower(strings.Trim(strings.TrimSpace(msg.Pattern), "/"))
+	if err := policy.ValidFreezePattern(pattern); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	reason := strings.TrimSpace(msg.Reason)
+	if utf8.RuneCountInString(reason) > maxFreezeReasonLen {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("reason is limited to %d characters", maxFreezeReasonLen))
+	}
+
+	now := time.Now()
+	w := &storage.FreezeWindow{Pattern: pattern, Reason: reason, StartsAt: now, CreatedBy: user.Username}
+	if msg.StartsAt != nil {
+		w.StartsAt = msg.StartsAt.AsTime()
+	}
+	if msg.EndsAt != nil {
+		end := msg.EndsAt.AsTime()
+		if !end.After(w.StartsAt) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("ends_at must be after starts_at"))
+		}
+		if !end.After(now) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("ends_at is already past"))
+		}
+		w.EndsAt = &end
+	}
+
+	if err := s.store.CreateFreezeWindow(ctx, w); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	s.log.Info("%s froze pushes to %s from %s", user.Username, w.Pattern, w.StartsAt.UTC().Format(time.RFC3339))
+	return connect.NewResponse(&v1.CreateFreezeWindowResponse{Window: freezeToProto(w, now)}), nil
+}
+
+func (s *FreezeService) DeleteFreezeWindow(ctx context.Context, req *connect.Request[v1.DeleteFreezeWindowRequest]) (*connect.Response[v1.DeleteFreezeWindowResponse], error) {
+	if req.Msg.Id == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("id is required"))
+	}
+	deleted, err := s.store.DeleteFreezeWindow(ctx, req.Msg.Id)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if !deleted {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("freeze window not found"))
+	}
+	return connect.NewResponse(&v1.DeleteFreezeWindowResponse{}), nil
+}
+
+func freezeToProto(w *storage.FreezeWindow, now time.Time) *v1.FreezeWindow {
+	out := &v1.FreezeWindow{
+		Id:        w.ID,
+		Pattern:   w.Pattern,
+		Reason:    w.Reason,
+		StartsAt:  timestamppb.New(w.StartsAt),
+		CreatedBy: w.CreatedBy,
+		CreatedAt: timestamppb.New(w.CreatedAt),
+		Active:    !w.StartsAt.After(now) && (w.EndsAt == nil || w.EndsAt.After(now)),
+	}
+	if w.EndsAt != nil {
+		out.EndsAt = timestamppb.New(*w.EndsAt)
+	}
+	return out
+}
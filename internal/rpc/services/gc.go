@@ -4,15 +4,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/alerts"
+	"github.com/nickheyer/distroface/internal/artifacts"
+	"github.com/nickheyer/distroface/internal/audit"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry/packed"
 	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/pages"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -24,12 +32,19 @@ type GCService struct {
 	collector    *admin.Collector
 	store        *stores.Store
 	registryPath string
+	artifacts    *artifacts.Manager   // Nil without artifact storage
+	blobs        *artifacts.BlobStore // Nil without artifact storage
+	alerts       *alerts.Monitor      // Nil reports no alerts
 	res          *settings.Resolver
 	log          *logger.Logger
 }
 
-func NewGCService(collector *admin.Collector, store *stores.Store, registryPath string, res *settings.Resolver, log *logger.Logger) *GCService {
-	return &GCService{collector: collector, store: store, registryPath: registryPath, res: res, log: log}
+func NewGCService(collector *admin.Collector, store *stores.Store, registryPath string, manager *artifacts.Manager, monitor *alerts.Monitor, res *settings.Resolver, log *logger.Logger) *GCService {
+	s := &GCService{collector: collector, store: store, registryPath: registryPath, artifacts: manager, alerts: monitor, res: res, log: log}
+	if manager != nil {
+		s.blobs = manager.Blobs()
+	}
+	return s
 }
 
 func (s *GCService) RunGC(ctx context.Context, req *connect.Request[v1.RunGCRequest]) (*connect.Response[v1.RunGCResponse], error) {
@@ -43,6 +58,10 @@ func (s *GCService) RunGC(ctx context.Context, req *connect.Request[v1.RunGCRequ
 }
 
 func (s *GCService) GetGCStatus(ctx context.Context, req *connect.Request[v1.GetGCStatusRequest]) (*connect.Response[v1.GetGCStatusResponse], error) {
+	return connect.NewResponse(s.gcStatus(ctx)), nil
+}
+
+func (s *GCService) gcStatus(ctx context.Context) *v1.GetGCStatusResponse {
 	running, last := s.collector.Status()
 	gc := s.res.System(ctx).GetGc()
 
@@ -62,13 +81,13 @@ func (s *GCService) GetGCStatus(ctx context.Context, req *connect.Request[v1.Get
 			Error:          last.Err,
 		}
 	}
-	return connect.NewResponse(resp), nil
+	return resp
 }
 
 func (s *GCService) GetStorageUsage(ctx context.Context, req *connect.Request[v1.GetStorageUsageRequest]) (*connect.Response[v1.GetStorageUsageResponse], error) {
 	resp := &v1.GetStorageUsageResponse{}
 
-	registryBytes, namespaces, err := registryUsage(s.registryPath)
+	registryBytes, namespaces, err := registryUsage(ctx, s.registryPath, s.store)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("scanning registry storage: %w", err))
 	}
@@ -78,6 +97,9 @@ func (s *GCService) GetStorageUsage(ctx context.Context, req *connect.Request[v1
 	if resp.ArtifactBytes, err = s.store.ArtifactUniqueBlobBytes(ctx); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
+	if s.blobs != nil {
+		resp.ArtifactColdBytes = s.blobs.Usage().ColdBytes
+	}
 	repos, _, err := s.store.ListArtifactRepositories(ctx, stores.ArtifactRepoListOptions{IncludePrivate: true})
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
@@ -101,8 +123,385 @@ func (s *GCService) GetStorageUsage(ctx context.Context, req *connect.Request[v1
 	return connect.NewResponse(resp), nil
 }
 
-// Walks distribution v3 filesystem layout attributing blob bytes per namespace
-func registryUsage(root string) (int64, []*v1.StorageUsageEntry, error) {
+func (s *GCService) GetArtifactUsage(ctx context.Context, req *connect.Request[v1.GetArtifactUsageRequest]) (*connect.Response[v1.GetArtifactUsageResponse], error) {
+	resp := &v1.GetArtifactUsageResponse{}
+	if s.artifacts == nil {
+		return connect.NewResponse(resp), nil
+	}
+	ownerID := ""
+	if req.Msg.Owner != "" {
+		u, err := s.store.GetUserByUsername(ctx, req.Msg.Owner)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		if u == nil {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("user %q not found", req.Msg.Owner))
+		}
+		ownerID = u.ID
+	}
+
+	repos, _, err := s.store.ListArtifactRepositories(ctx, stores.ArtifactRepoListOptions{IncludePrivate: true})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	ids := make([]int64, 0, len(repos))
+	for _, r := range repos {
+		ids = append(ids, r.ID)
+	}
+	stats, err := s.store.GetArtifactRepoStats(ctx, ids)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	ownerBytes, err := s.store.ArtifactBytesByOwner(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	owners := map[string]*v1.ArtifactOwnerUsage{}
+	for _, r := range repos {
+		if ownerID != "" && r.OwnerID != ownerID {
+			continue
+		}
+		ou := owners[r.OwnerID]
+		if ou == nil {
+			ou = &v1.ArtifactOwnerUsage{Owner: r.OwnerID, Bytes: ownerBytes[r.OwnerID]}
+			if r.OwnerID != "" {
+				u, err := s.store.GetUserByID(ctx, r.OwnerID)
+				if err != nil {
+					return nil, connect.NewError(connect.CodeInternal, err)
+				}
+				if u != nil {
+					ou.Owner = u.Username
+				}
+				ou.QuotaBytes = s.artifacts.OwnerQuotaBytes(ctx, u)
+			}
+			owners[r.OwnerID] = ou
+			resp.Owners = append(resp.Owners, ou)
+		}
+		ou.Repositories++
+		st := stats[r.ID]
+		resp.Repos = append(resp.Repos, &v1.ArtifactRepoUsage{
+			Namespace:  r.Namespace,
+			Name:       r.Name,
+			Owner:      ou.Owner,
+			Bytes:      st.Size,
+			QuotaBytes: s.artifacts.RepoQuotaBytes(ctx, r),
+			Artifacts:  int32(st.Count),
+		})
+	}
+	sort.Slice(resp.Owners, func(i, j int) bool {
+		if resp.Owners[i].Bytes != resp.Owners[j].Bytes {
+			return resp.Owners[i].Bytes > resp.Owners[j].Bytes
+		}
+		return resp.Owners[i].Owner < resp.Owners[j].Owner
+	})
+	sort.Slice(resp.Repos, func(i, j int) bool {
+		if resp.Repos[i].Bytes != resp.Repos[j].Bytes {
+			return resp.Repos[i].Bytes > resp.Repos[j].Bytes
+		}
+		return resp.Repos[i].Namespace+"/"+resp.Repos[i].Name < resp.Repos[j].Namespace+"/"+resp.Repos[j].Name
+	})
+	return connect.NewResponse(resp), nil
+}
+
+// Default horizons and rate window of retention forecasts
+var defaultForecastDays = []int32{30, 90}
+
+const defaultForecastWindowDays = 30
+
+func (s *GCService) GetRetentionForecast(ctx context.Context, req *connect.Request[v1.GetRetentionForecastRequest]) (*connect.Response[v1.GetRetentionForecastResponse], error) {
+	window := req.Msg.WindowDays
+	if window == 0 {
+		window = defaultForecastWindowDays
+	}
+	if window < 1 || window > 365 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("window_days must be between 1 and 365"))
+	}
+	days := req.Msg.HorizonDays
+	if len(days) == 0 {
+		days = defaultForecastDays
+	}
+	if len(days) > 12 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("at most 12 horizons"))
+	}
+	horizons := make([]int, len(days))
+	for i, d := range days {
+		if d < 1 || d > 3650 {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("horizon_days must be between 1 and 3650"))
+		}
+		horizons[i] = int(d)
+	}
+
+	now := time.Now().UTC()
+	resp := &v1.GetRetentionForecastResponse{
+		GeneratedAt: timestamppb.New(now),
+		WindowDays:  window,
+		HorizonDays: days,
+	}
+	for _, d := range days {
+		resp.Totals = append(resp.Totals, &v1.StorageForecast{Days: d})
+	}
+	if s.artifacts == nil {
+		return connect.NewResponse(resp), nil
+	}
+	if free, ok := s.blobs.FreeBytes(); ok {
+		resp.FreeBytes = free
+	}
+
+	repos, _, err := s.store.ListArtifactRepositories(ctx, stores.ArtifactRepoListOptions{Namespace: req.Msg.Namespace, IncludePrivate: true})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	windowDur := time.Duration(window) * 24 * time.Hour
+	for _, r := range repos {
+		f, err := s.artifacts.ForecastRetention(ctx, r, windowDur, horizons, now)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("forecasting %s/%s: %w", r.Namespace, r.Name, err))
+		}
+		rf := &v1.RepoRetentionForecast{
+			Namespace:        r.Namespace,
+			Name:             r.Name,
+			RetentionEnabled: f.Policy.Active(),
+			Artifacts:        int64(f.Artifacts),
+			Bytes:            f.Bytes,
+			QuotaBytes:       s.artifacts.RepoQuotaBytes(ctx, r),
+			NextRunArtifacts: int64(f.NextRunArtifacts),
+			NextRunBytes:     f.NextRunBytes,
+			DailyUploads:     float64(f.WindowUploads) / float64(window),
+			DailyUploadBytes: float64(f.WindowBytes) / float64(window),
+		}
+		for i, pt := range f.Points {
+			rf.Forecasts = append(rf.Forecasts, &v1.StorageForecast{
+				Days:          int32(pt.Days),
+				Artifacts:     int64(pt.Artifacts),
+				Bytes:         pt.Bytes,
+				UploadedBytes: pt.Uploaded,
+				PrunedBytes:   pt.Pruned,
+				OverQuota:     rf.QuotaBytes > 0 && pt.Bytes > rf.QuotaBytes,
+			})
+			t := resp.Totals[i]
+			t.Artifacts += int64(pt.Artifacts)
+			t.Bytes += pt.Bytes
+			t.UploadedBytes += pt.Uploaded
+			t.PrunedBytes += pt.Pruned
+		}
+		resp.Bytes += f.Bytes
+		resp.NextRunBytes += f.NextRunBytes
+		resp.Repos = append(resp.Repos, rf)
+	}
+	last := len(days) - 1
+	sort.Slice(resp.Repos, func(i, j int) bool {
+		a, b := resp.Repos[i].Forecasts[last].Bytes, resp.Repos[j].Forecasts[last].Bytes
+		if a != b {
+			return a > b
+		}
+		return resp.Repos[i].Namespace+"/"+resp.Repos[i].Name < resp.Repos[j].Namespace+"/"+resp.Repos[j].Name
+	})
+	return connect.NewResponse(resp), nil
+}
+
+func (s *GCService) PruneUploads(ctx context.Context, req *connect.Request[v1.PruneUploadsRequest]) (*connect.Response[v1.PruneUploadsResponse], error) {
+	hours := req.Msg.OlderThanHours
+	if hours < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("older_than_hours must not be negative"))
+	}
+	if hours == 0 {
+		hours = s.res.System(ctx).GetArtifacts().GetStaleUploadCleanupHours()
+	}
+	if hours <= 0 {
+		hours = 24
+	}
+	maxAge := time.Duration(hours) * time.Hour
+
+	resp := &v1.PruneUploadsResponse{}
+	sessions, freed, err := admin.PruneRegistryUploads(s.registryPath, maxAge, req.Msg.DryRun)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("pruning registry uploads: %w", err))
+	}
+	resp.RegistrySessions, resp.BytesFreed = int32(sessions), freed
+
+	if s.blobs != nil {
+		sessions, freed, err := s.blobs.PruneUploads(maxAge, req.Msg.DryRun)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("pruning artifact uploads: %w", err))
+		}
+		resp.ArtifactSessions = int32(sessions)
+		resp.BytesFreed += freed
+	}
+
+	if !req.Msg.DryRun {
+		s.log.Info("Pruned %d registry and %d artifact upload sessions older than %dh (%d bytes)",
+			resp.RegistrySessions, resp.ArtifactSessions, hours, resp.BytesFreed)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// Audit errors shown on the dashboard
+const summaryRecentErrors = 5
+
+func (s *GCService) GetAdminSummary(ctx context.Context, req *connect.Request[v1.GetAdminSummaryRequest]) (*connect.Response[v1.GetAdminSummaryResponse], error) {
+	counts, err := s.store.CountInstance(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.GetAdminSummaryResponse{
+		Version:              appVersion(),
+		Users:                counts.Users,
+		Organizations:        counts.Organizations,
+		Repositories:         counts.Repositories,
+		ArtifactRepositories: counts.ArtifactRepositories,
+		Artifacts:            counts.Artifacts,
+		Gc:                   s.gcStatus(ctx),
+	}
+
+	if resp.RegistryBytes, _, err = registryUsage(ctx, s.registryPath, s.store); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("scanning registry storage: %w", err))
+	}
+	if resp.ArtifactBytes, err = s.store.ArtifactUniqueBlobBytes(ctx); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	// Active is every session minus the ones pruning would take
+	stale := time.Duration(s.res.System(ctx).GetArtifacts().GetStaleUploadCleanupHours()) * time.Hour
+	if stale <= 0 {
+		stale = 24 * time.Hour
+	}
+	active := func(count func(time.Duration) (int, int64, error)) (int32, error) {
+		all, _, err := count(0)
+		if err != nil {
+			return 0, err
+		}
+		idle, _, err := count(stale)
+		return int32(all - idle), err
+	}
+	if resp.RegistryUploads, err = active(func(age time.Duration) (int, int64, error) {
+		return admin.PruneRegistryUploads(s.registryPath, age, true)
+	}); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("counting registry uploads: %w", err))
+	}
+	if s.blobs != nil {
+		if resp.ArtifactUploads, err = active(func(age time.Duration) (int, int64, error) {
+			return s.blobs.PruneUploads(age, true)
+		}); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("counting artifact uploads: %w", err))
+		}
+	}
+
+	errs := pages.Query{Filters: []pages.Filter{{Field: "outcome", Match: pages.MatchEquals, Value: audit.OutcomeError}}}
+	events, _, err := s.store.ListAuditEvents(ctx, errs, "created_at DESC", summaryRecentErrors, 0)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	for _, ev := range events {
+		resp.RecentErrors = append(resp.RecentErrors, audit.EventToProto(ev))
+	}
+	for _, a := range s.alerts.Alerts() {
+		if a.Firing {
+			resp.Alerts = append(resp.Alerts, alertToProto(a))
+		}
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (s *GCService) ListAlerts(ctx context.Context, req *connect.Request[v1.ListAlertsRequest]) (*connect.Response[v1.ListAlertsResponse], error) {
+	resp := &v1.ListAlertsResponse{}
+	for _, a := range s.alerts.Alerts() {
+		resp.Alerts = append(resp.Alerts, alertToProto(a))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func alertToProto(a alerts.Alert) *v1.Alert {
+	return &v1.Alert{
+		Rule:        a.Rule,
+		Firing:      a.Firing,
+		Value:       a.Value,
+		Threshold:   a.Threshold,
+		Message:     a.Message,
+		Since:       timestamppb.New(a.Since),
+		EvaluatedAt: timestamppb.New(a.EvaluatedAt),
+	}
+}
+
+// Progress ticks are throttled, problems and scope ends always go out
+const verifyProgressInterval = 500 * time.Millisecond
+
+func (s *GCService) VerifyStorage(ctx context.Context, req *connect.Request[v1.VerifyStorageRequest], stream *connect.ServerStream[v1.VerifyStorageEvent]) error {
+	doRegistry, doArtifacts := req.Msg.Registry, req.Msg.Artifacts
+	if !doRegistry && !doArtifacts {
+		doRegistry, doArtifacts = true, s.blobs != nil
+	}
+	if doArtifacts && s.blobs == nil {
+		return connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("artifact storage is not enabled"))
+	}
+
+	var sendErr error
+	send := func(ev *v1.VerifyStorageEvent) {
+		if sendErr == nil {
+			sendErr = stream.Send(ev)
+		}
+	}
+	var lastTick time.Time
+	progress := func(scope string) func(checked, total, bytes int64) {
+		return func(checked, total, bytes int64) {
+			if time.Since(lastTick) < verifyProgressInterval {
+				return
+			}
+			lastTick = time.Now()
+			send(&v1.VerifyStorageEvent{Scope: scope, Checked: checked, Total: total, BytesChecked: bytes})
+		}
+	}
+	problem := func(scope string) func(p admin.BlobProblem) {
+		return func(p admin.BlobProblem) {
+			send(&v1.VerifyStorageEvent{Scope: scope, Problem: &v1.StorageProblem{Digest: p.Digest, Kind: p.Kind, Detail: p.Detail}})
+		}
+	}
+
+	if doRegistry {
+		var checked, total, bytes int64
+		tick := progress("registry")
+		err := admin.VerifyRegistry(ctx, s.registryPath, s.store, func(c, t, b int64) {
+			checked, total, bytes = c, t, b
+			tick(c, t, b)
+		}, problem("registry"))
+		if err != nil {
+			return connect.NewError(connect.CodeInternal, fmt.Errorf("verifying registry storage: %w", err))
+		}
+		send(&v1.VerifyStorageEvent{Scope: "registry", Checked: checked, Total: total, BytesChecked: bytes, Done: true})
+	}
+
+	if doArtifacts {
+		digests, err := s.store.ListArtifactDigests(ctx)
+		if err != nil {
+			return connect.NewError(connect.CodeInternal, err)
+		}
+		tick, report := progress("artifacts"), problem("artifacts")
+		total := int64(len(digests))
+		var bytes int64
+		for i, digest := range digests {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			size, err := s.blobs.VerifyBlob(digest)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				report(admin.BlobProblem{Digest: digest, Kind: admin.ProblemMissing})
+			case errors.Is(err, artifacts.ErrBlobCorrupt):
+				report(admin.BlobProblem{Digest: digest, Kind: admin.ProblemCorrupt})
+			case err != nil:
+				report(admin.BlobProblem{Digest: digest, Kind: admin.ProblemUnreadable, Detail: err.Error()})
+			}
+			bytes += size
+			tick(int64(i+1), total, bytes)
+		}
+		send(&v1.VerifyStorageEvent{Scope: "artifacts", Checked: total, Total: total, BytesChecked: bytes, Done: true})
+	}
+	return sendErr
+}
+
+// Walks distribution v3 filesystem layout attributing blob bytes per namespace,
+// links packed into the store count like those on disk
+func registryUsage(ctx context.Context, root string, packedLinks *stores.Store) (int64, []*v1.StorageUsageEntry, error) {
 	base := filepath.Join(root, "docker", "registry", "v2")
 
 	// Unique blob bytes on disk keyed by hex digest
@@ -137,6 +536,15 @@ func registryUsage(root string) (int64, []*v1.StorageUsageEntry, error) {
 		repos   int32
 	}
 	perNS := map[string]*nsUsage{}
+	usage := func(ns string) *nsUsage {
+		u := perNS[ns]
+		if u == nil {
+			u = &nsUsage{digests: map[string]bool{}}
+			perNS[ns] = u
+		}
+		return u
+	}
+	counted := map[string]bool{}
 	repoBase := filepath.Join(base, "repositories")
 	nsEntries, err := os.ReadDir(repoBase)
 	if err != nil && !os.IsNotExist(err) {
@@ -159,12 +567,9 @@ func registryUsage(root string) (int64, []*v1.StorageUsageEntry, error) {
 			if _, err := os.Stat(filepath.Join(repoDir, "_manifests")); err != nil {
 				continue
 			}
-			u := perNS[ns]
-			if u == nil {
-				u = &nsUsage{digests: map[string]bool{}}
-				perNS[ns] = u
-			}
+			u := usage(ns)
 			u.repos++
+			counted[ns+"/"+repoEntry.Name()] = true
 			for _, linkDir := range []string{
 				filepath.Join(repoDir, "_layers", "sha256"),
 				filepath.Join(repoDir, "_manifests", "revisions", "sha256"),
@@ -180,6 +585,22 @@ func registryUsage(root string) (int64, []*v1.StorageUsageEntry, error) {
 		}
 	}
 
+	if packedLinks != nil {
+		links, err := packed.RepoLinks(ctx, packedLinks)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, l := range links {
+			ns, _, _ := strings.Cut(l.Repo, "/")
+			u := usage(ns)
+			if !counted[l.Repo] {
+				counted[l.Repo] = true
+				u.repos++
+			}
+			u.digests[l.Hex] = true
+		}
+	}
+
 	entries := make([]*v1.StorageUsageEntry, 0, len(perNS))
 	for ns, u := range perNS {
 		var bytes int64
@@ -191,6 +612,58 @@ func registryUsage(root string) (int64, []*v1.StorageUsageEntry, error) {
 	return total, largestUsageEntries(entries), nil
 }
 
+// Unique blob bytes under one namespace, only the blobs its links
+// reference are statted
+func namespaceUsage(ctx context.Context, root string, packedLinks *stores.Store, ns string) (int64, error) {
+	base := filepath.Join(root, "docker", "registry", "v2")
+	digests := map[string]bool{}
+
+	nsDir := filepath.Join(base, "repositories", ns)
+	repoEntries, err := os.ReadDir(nsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, repoEntry := range repoEntries {
+		if !repoEntry.IsDir() {
+			continue
+		}
+		repoDir := filepath.Join(nsDir, repoEntry.Name())
+		for _, linkDir := range []string{
+			filepath.Join(repoDir, "_layers", "sha256"),
+			filepath.Join(repoDir, "_manifests", "revisions", "sha256"),
+		} {
+			links, err := os.ReadDir(linkDir)
+			if err != nil {
+				continue
+			}
+			for _, l := range links {
+				digests[l.Name()] = true
+			}
+		}
+	}
+
+	if packedLinks != nil {
+		links, err := packed.NamespaceLinks(ctx, packedLinks, ns)
+		if err != nil {
+			return 0, err
+		}
+		for _, l := range links {
+			digests[l.Hex] = true
+		}
+	}
+
+	var bytes int64
+	for digest := range digests {
+		if len(digest) < 2 {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(base, "blobs", "sha256", digest[:2], digest, "data")); err == nil {
+			bytes += info.Size()
+		}
+	}
+	return bytes, nil
+}
+
 const maxUsageEntries = 5
 
 // Biggest first, truncated for the dashboard card
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
@@ -15,23 +17,44 @@ import (
 var _ distrofacev1connect.HealthServiceHandler = (*HealthService)(nil)
 
 type HealthService struct {
-	log *logger.Logger
+	log      *logger.Logger
+	storage  []*admin.StorageHealth
+	features Features
+	res      *settings.Resolver
 }
 
-func NewHealthService(log *logger.Logger) *HealthService {
-	return &HealthService{log: log}
+func NewHealthService(log *logger.Logger, storage ...*admin.StorageHealth) *HealthService {
+	return &HealthService{log: log, storage: storage}
 }
 
 func (s *HealthService) HealthCheck(ctx context.Context, req *connect.Request[v1.HealthCheckRequest]) (*connect.Response[v1.HealthCheckResponse], error) {
-	version := os.Getenv("APP_VERSION")
-	if version == "" {
-		version = "dev"
-	}
-
 	resp := &v1.HealthCheckResponse{
 		Status:    "ok",
 		Timestamp: timestamppb.New(time.Now()),
-		Version:   version,
+		Version:   appVersion(),
+	}
+	// Errors stay in the logs and metrics, the check is public
+	for _, h := range s.storage {
+		st := h.Status()
+		entry := &v1.StorageHealth{
+			Backend:        st.Backend,
+			Healthy:        !st.Open,
+			ProbeLatencyMs: st.ProbeLatency.Milliseconds(),
+		}
+		if !st.LastProbe.IsZero() {
+			entry.LastProbe = timestamppb.New(st.LastProbe)
+		}
+		if st.Open {
+			resp.Status = "degraded"
+		}
+		resp.Storage = append(resp.Storage, entry)
 	}
 	return connect.NewResponse(resp), nil
 }
+
+func appVersion() string {
+	if version := os.Getenv("APP_VERSION"); version != "" {
+		return version
+	}
+	return "dev"
+}
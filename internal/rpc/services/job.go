@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"connectrpc.com/connect"
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/jobs"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/pages"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ distrofacev1connect.JobServiceHandler = (*JobService)(nil)
+
+// Read and retry access to the background job queue
+type JobService struct {
+	store *stores.Store
+	queue *jobs.Queue
+	log   *logger.Logger
+}
+
+func NewJobService(store *stores.Store, queue *jobs.Queue, log *logger.Logger) *JobService {
+	return &JobService{store: store, queue: queue, log: log}
+}
+
+func (s *JobService) ListJobs(ctx context.Context, req *connect.Request[v1.ListJobsRequest]) (*connect.Response[v1.ListJobsResponse], error) {
+	limit, offset := pages.Parse(req.Msg.Page)
+	q := pages.ParseQuery(req.Msg.Page)
+	if err := stores.JobsQuery.Validate(q); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	list, total, err := s.store.ListJobs(ctx, q, limit, offset)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	pending, running, err := s.store.CountQueuedJobs(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	resp := &v1.ListJobsResponse{Page: pages.Info(offset, limit, total), Pending: pending, Running: running}
+	for _, j := range list {
+		resp.Jobs = append(resp.Jobs, jobToProto(j))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (s *JobService) GetJob(ctx context.Context, req *connect.Request[v1.GetJobRequest]) (*connect.Response[v1.GetJobResponse], error) {
+	job, err := s.job(ctx, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&v1.GetJobResponse{Job: jobToProto(job)}), nil
+}
+
+func (s *JobService) RetryJob(ctx context.Context, req *connect.Request[v1.RetryJobRequest]) (*connect.Response[v1.RetryJobResponse], error) {
+	job, err := s.job(ctx, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.queue.Retry(ctx, job.ID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if !ok {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("job is %s, only failed jobs can be retried", job.Status))
+	}
+	if job, err = s.store.GetJob(ctx, job.ID); err != nil || job == nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("reloading job: %v", err))
+	}
+	s.log.Info("job %s (%s %s) requeued", job.ID, job.Kind, job.Subject)
+	return connect.NewResponse(&v1.RetryJobResponse{Job: jobToProto(job)}), nil
+}
+
+func (s *JobService) job(ctx context.Context, id string) (*storage.Job, error) {
+	if id == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("id is required"))
+	}
+	job, err := s.store.GetJob(ctx, id)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if job == nil {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("job not found"))
+	}
+	return job, nil
+}
+
+func jobToProto(j *storage.Job) *v1.Job {
+	p := &v1.Job{
+		Id:          j.ID,
+		Kind:        j.Kind,
+		Subject:     j.Subject,
+		Status:      j.Status,
+		Attempts:    int32(j.Attempts),
+		MaxAttempts: jobs.MaxAttempts,
+		LastError:   j.LastError,
+		CreatedAt:   timestamppb.New(j.CreatedAt),
+		UpdatedAt:   timestamppb.New(j.UpdatedAt),
+	}
+	if j.Status == storage.JobPending {
+		p.RunAt = timestamppb.New(j.RunAt)
+	}
+	if j.FinishedAt != nil {
+		p.FinishedAt = timestamppb.New(*j.FinishedAt)
+	}
+	return p
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/auth"
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/notify"
+	"github.com/nickheyer/distroface/internal/notify/channel"
+	"github.com/nickheyer/distroface/pkg/logger"
+	"github.com/nickheyer/distroface/pkg/pages"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ distrofacev1connect.NotificationServiceHandler = (*NotificationService)(nil)
+
+// Every call is scoped to the caller, watches follow repo read access
+type NotificationService struct {
+	store    *stores.Store
+	repos    *RepositoryService
+	channels *channel.Hub // Nil fails channel tests
+	log      *logger.Logger
+}
+
+func NewNotificationService(store *stores.Store, repos *RepositoryService, log *logger.Logger) *NotificationService {
+	return &NotificationService{store: store, repos: repos, log: log}
+}
+
+// Enables test deliveries through the system notification channels
+func (s *NotificationService) SetChannels(hub *channel.Hub) {
+	s.channels = hub
+}
+
+// Anonymous sessions have no inbox
+func notificationUser(ctx context.Context) (*auth.AuthenticatedUser, error) {
+	user := auth.UserFromContext(ctx)
+	if auth.IsAnonymous(user) {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	return user, nil
+}
+
+func (s *NotificationService) WatchRepository(ctx context.Context, req *connect.Request[v1.WatchRepositoryRequest]) (*connect.Response[v1.WatchRepositoryResponse], error) {
+	user, err := notificationUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var events []string
+	for _, e := range req.Msg.Events {
+		if !notify.ValidEvent(e) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown event %q, expected tag, delete or comment", e))
+		}
+		if !slices.Contains(events, e) {
+			events = append(events, e)
+		}
+	}
+	repo, err := s.repos.readableRepo(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(events)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if events == nil {
+		encoded = []byte("[]")
+	}
+	w := &storage.Watch{UserID: user.ID, RepoID: repo.ID, Events: string(encoded)}
+	if err := s.store.UpsertWatch(ctx, w); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	// Re-read for the original creation time of a replaced watch
+	stored, err := s.store.GetWatch(ctx, user.ID, repo.ID)
+	if err != nil || stored == nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	stored.Repo = repo
+	return connect.NewResponse(&v1.WatchRepositoryResponse{Watch: watchToProto(stored)}), nil
+}
+
+func (s *NotificationService) UnwatchRepository(ctx context.Context, req *connect.Request[v1.UnwatchRepositoryRequest]) (*connect.Response[v1.UnwatchRepositoryResponse], error) {
+	user, err := notificationUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Msg.Namespace == "" || req.Msg.Name == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
+	}
+	// No read check, losing access must not strand a watch
+	repo, err := s.store.GetRepository(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if repo == nil {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+	if err := s.store.DeleteWatch(ctx, user.ID, repo.ID); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.UnwatchRepositoryResponse{}), nil
+}
+
+func (s *NotificationService) ListWatches(ctx context.Context, req *connect.Request[v1.ListWatchesRequest]) (*connect.Response[v1.ListWatchesResponse], error) {
+	user, err := notificationUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset := pages.Parse(req.Msg.Page)
+	watches, total, err := s.store.ListWatches(ctx, user.ID, limit, offset)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	out := make([]*v1.Watch, 0, len(watches))
+	for _, w := range watches {
+		if w.Repo != nil {
+			out = append(out, watchToProto(w))
+		}
+	}
+	return connect.NewResponse(&v1.ListWatchesResponse{
+		Watches: out,
+		Page:    pages.Info(offset, limit, total),
+	}), nil
+}
+
+func (s *NotificationService) GetNotificationPreferences(ctx context.Context, req *connect.Request[v1.GetNotificationPreferencesRequest]) (*connect.Response[v1.GetNotificationPreferencesResponse], error) {
+	user, err := notificationUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := notify.Preferences(ctx, s.store, user.ID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.GetNotificationPreferencesResponse{Preferences: preferencesToProto(prefs)}), nil
+}
+
+func (s *NotificationService) UpdateNotificationPreferences(ctx context.Context, req *connect.Request[v1.UpdateNotificationPreferencesRequest]) (*connect.Response[v1.UpdateNotificationPreferencesResponse], error) {
+	user, err := notificationUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg := req.Msg
+	if msg.WebhookUrl != nil && *msg.WebhookUrl != "" && !isValidWebhookURL(*msg.WebhookUrl) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("webhook url must be a valid HTTP or HTTPS URL"))
+	}
+	if msg.Digest != nil && !notify.ValidDigest(*msg.Digest) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("digest must be empty, hourly or daily"))
+	}
+
+	prefs, err := notify.Preferences(ctx, s.store, user.ID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if msg.Email != nil {
+		prefs.Email = *msg.Email
+	}
+	if msg.WebhookUrl != nil {
+		prefs.WebhookURL = *msg.WebhookUrl
+	}
+	if msg.WebhookSecret != nil {
+		prefs.WebhookSecret = *msg.WebhookSecret
+	}
+	if msg.Mentions != nil {
+		prefs.Mentions = *msg.Mentions
+	}
+	if msg.Digest != nil && *msg.Digest != prefs.Digest {
+		prefs.Digest = *msg.Digest
+		// The first digest covers a full interval from now
+		now := time.Now()
+		prefs.LastDigestAt = &now
+	}
+	if err := s.store.SaveNotificationPreference(ctx, prefs); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.UpdateNotificationPreferencesResponse{Preferences: preferencesToProto(prefs)}), nil
+}
+
+func (s *NotificationService) ListNotifications(ctx context.Context, req *connect.Request[v1.ListNotificationsRequest]) (*connect.Response[v1.ListNotificationsResponse], error) {
+	user, err := notificationUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset := pages.Parse(req.Msg.Page)
+	entries, total, unread, err := s.store.ListNotifications(ctx, user.ID, req.Msg.UnreadOnly, limit, offset)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	out := make([]*v1.Notification, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, notificationToProto(e))
+	}
+	return connect.NewResponse(&v1.ListNotificationsResponse{
+		Notifications: out,
+		Page:          pages.Info(offset, limit, total),
+		UnreadCount:   unread,
+	}), nil
+}
+
+func (s *NotificationService) MarkNotificationsRead(ctx context.Context, req *connect.Request[v1.MarkNotificationsReadRequest]) (*connect.Response[v1.MarkNotificationsReadResponse], error) {
+	user, err := notificationUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	marked, err := s.store.MarkNotificationsRead(ctx, user.ID, req.Msg.Ids)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.MarkNotificationsReadResponse{Marked: marked}), nil
+}
+
+func watchToProto(w *storage.Watch) *v1.Watch {
+	var events []string
+	json.Unmarshal([]byte(w.Events), &events)
+	return &v1.Watch{
+		Namespace: w.Repo.Namespace,
+		Name:      w.Repo.Name,
+		Events:    events,
+		CreatedAt: timestamppb.New(w.CreatedAt),
+	}
+}
+
+func preferencesToProto(p *storage.NotificationPreference) *v1.NotificationPreferences {
+	return &v1.NotificationPreferences{
+		Email:            p.Email,
+		WebhookUrl:       p.WebhookURL,
+		WebhookSecretSet: p.WebhookSecret != "",
+		Mentions:         p.Mentions,
+		Digest:           p.Digest,
+	}
+}
+
+func notificationToProto(n *storage.Notification) *v1.Notification {
+	return &v1.Notification{
+		Id:        n.ID,
+		Reason:    n.Reason,
+		Event:     n.Event,
+		Namespace: n.Namespace,
+		Name:      n.Name,
+		Ref:       n.Ref,
+		Digest:    n.Digest,
+		Actor:     n.Actor,
+		Excerpt:   n.Excerpt,
+		CommentId: n.CommentID,
+		Read:      n.Read,
+		CreatedAt: timestamppb.New(n.CreatedAt),
+	}
+}
+
+// Settings update is required by the procedure map, results carry the
+// delivery errors so one bad channel does not hide the others
+func (s *NotificationService) TestNotificationChannel(ctx context.Context, req *connect.Request[v1.TestNotificationChannelRequest]) (*connect.Response[v1.TestNotificationChannelResponse], error) {
+	if s.channels == nil {
+		return nil, connect.NewError(connect.CodeUnavailable, fmt.Errorf("notification channels are not available"))
+	}
+	if c := req.Msg.Config; c != nil {
+		if err := channel.Validate(c); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+	results, err := s.channels.Test(ctx, req.Msg.Channel, req.Msg.Config)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, err)
+	}
+	if len(results) == 0 {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("no notification channels are configured"))
+	}
+	resp := &v1.TestNotificationChannelResponse{}
+	for _, r := range results {
+		out := &v1.NotificationChannelResult{Channel: r.Channel, Ok: r.Err == nil}
+		if r.Err != nil {
+			out.Error = r.Err.Error()
+		}
+		resp.Results = append(resp.Results, out)
+	}
+	return connect.NewResponse(resp), nil
+}
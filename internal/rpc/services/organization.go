@@ -8,6 +8,7 @@ import (
 	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/policy"
 	"github.com/nickheyer/distroface/internal/portal"
 	"github.com/nickheyer/distroface/internal/rbac"
 	"github.com/nickheyer/distroface/internal/registry"
@@ -22,15 +23,23 @@ import (
 var _ distrofacev1connect.OrganizationServiceHandler = (*OrganizationService)(nil)
 
 type OrganizationService struct {
-	store    *stores.Store
-	registry *registry.RegistryAccess
-	enforcer *rbac.Enforcer
-	res      *settings.Resolver
-	log      *logger.Logger
+	store     *stores.Store
+	registry  *registry.RegistryAccess
+	enforcer  *rbac.Enforcer
+	res       *settings.Resolver
+	approvals *deletionApprovals
+	log       *logger.Logger
 }
 
 func NewOrganizationService(store *stores.Store, registry *registry.RegistryAccess, enforcer *rbac.Enforcer, res *settings.Resolver, log *logger.Logger) *OrganizationService {
-	return &OrganizationService{store: store, registry: registry, enforcer: enforcer, res: res, log: log}
+	return &OrganizationService{
+		store:     store,
+		registry:  registry,
+		enforcer:  enforcer,
+		res:       res,
+		approvals: &deletionApprovals{gate: policy.NewApprovals(store, res, enforcer)},
+		log:       log,
+	}
 }
 
 func (s *OrganizationService) CreateOrganization(ctx context.Context, req *connect.Request[v1.CreateOrganizationRequest]) (*connect.Response[v1.CreateOrganizationResponse], error) {
@@ -47,6 +56,9 @@ func (s *OrganizationService) CreateOrganization(ctx context.Context, req *conne
 	if !usernameRegex.MatchString(msg.Name) {
 		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid organization name"))
 	}
+	if err := s.res.CheckNamespace(ctx, msg.Name); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("organization name %q is reserved", msg.Name))
+	}
 
 	// Org name must not collide with existing usernames
 	existingUser, _ := s.store.GetUserByUsername(ctx, msg.Name)
@@ -72,6 +84,9 @@ func (s *OrganizationService) CreateOrganization(ctx context.Context, req *conne
 	}
 
 	if err := s.store.CreateOrganization(ctx, org); err != nil {
+		if stores.IsUniqueViolation(err) {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("organization already exists"))
+		}
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
@@ -245,6 +260,23 @@ func (s *OrganizationService) DeleteOrganization(ctx context.Context, req *conne
 		}
 	}
 
+	expires, err := s.approvals.check(ctx, user, rbac.ResourceOrganizations, org.Name, "", func() (int64, error) {
+		if s.registry == nil {
+			return 0, nil
+		}
+		return s.registry.NamespaceSize(ctx, org.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expires != nil {
+		s.log.Info("Delete of organization %s by %s awaits a second admin", org.Name, user.Username)
+		return connect.NewResponse(&v1.DeleteOrganizationResponse{
+			PendingApproval:   true,
+			ApprovalExpiresAt: timestamppb.New(*expires),
+		}), nil
+	}
+
 	_, err = s.store.DeleteOrganization(ctx, org.ID, org.Name)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
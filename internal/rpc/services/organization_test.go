@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Org names are namespaces too, reserved ones stay unclaimed
+func TestCreateOrganizationRefusesReservedNames(t *testing.T) {
+	e := newTestEnv(t)
+	orgs := NewOrganizationService(e.store, e.registry, e.enforcer, e.res, logger.New())
+	alice := e.user("alice", "admin")
+	if _, err := e.res.Update(context.Background(), v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", &v1.Settings{
+		Namespaces: &v1.NamespaceSettings{ReservedNames: []string{"v2", "infra-*"}},
+	}, []string{"namespaces.reserved_names"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	for _, name := range []string{"v2", "infra-core"} {
+		_, err := orgs.CreateOrganization(alice, connect.NewRequest(&v1.CreateOrganizationRequest{Name: name}))
+		if connectCode(err) != connect.CodeInvalidArgument {
+			t.Errorf("creating org %q = %v, want invalid argument", name, err)
+		}
+	}
+	if _, err := orgs.CreateOrganization(alice, connect.NewRequest(&v1.CreateOrganizationRequest{Name: "infra"})); err != nil {
+		t.Fatalf("creating an unreserved org: %v", err)
+	}
+}
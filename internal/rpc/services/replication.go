@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/auth"
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/mirror"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ distrofacev1connect.ReplicationServiceHandler = (*ReplicationService)(nil)
+
+// Rules live here, the replicator queues and runs their copies
+type ReplicationService struct {
+	store      *stores.Store
+	replicator *mirror.Replicator
+	enforcer   *rbac.Enforcer
+	log        *logger.Logger
+}
+
+func NewReplicationService(store *stores.Store, replicator *mirror.Replicator, enforcer *rbac.Enforcer, log *logger.Logger) *ReplicationService {
+	return &ReplicationService{store: store, replicator: replicator, enforcer: enforcer, log: log}
+}
+
+// Loads a rule the caller may manage, foreign ones read as missing
+func (s *ReplicationService) getManaged(ctx context.Context, user *auth.AuthenticatedUser, id string) (*storage.ReplicationRule, error) {
+	if id == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("id is required"))
+	}
+	rule, err := s.store.GetReplicationRule(ctx, id)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if rule == nil || !canManageNamespace(ctx, s.store, s.enforcer, user, rule.Namespace) {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("replication rule not found"))
+	}
+	return rule, nil
+}
+
+func (s *ReplicationService) ListReplicationRules(ctx context.Context, req *connect.Request[v1.ListReplicationRulesRequest]) (*connect.Response[v1.ListReplicationRulesResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+
+	var namespaces []string
+	if ns := req.Msg.Namespace; ns != "" {
+		if !canManageNamespace(ctx, s.store, s.enforcer, user, ns) {
+			return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot manage replication in namespace %q", ns))
+		}
+		namespaces = []string{ns}
+	} else {
+		var err error
+		if namespaces, err = managedNamespaces(ctx, s.store, s.enforcer, user); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+	}
+
+	rules, err := s.store.ListReplicationRules(ctx, namespaces)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.ListReplicationRulesResponse{Rules: make([]*v1.ReplicationRule, 0, len(rules))}
+	for _, r := range rules {
+		out, _, err := s.ruleToProto(ctx, r)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		resp.Rules = append(resp.Rules, out)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (s *ReplicationService) GetReplicationRule(ctx context.Context, req *connect.Request[v1.GetReplicationRuleRequest]) (*connect.Response[v1.GetReplicationRuleResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	rule, err := s.getManaged(ctx, user, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	out, jobs, err := s.ruleToProto(ctx, rule)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.GetReplicationRuleResponse{Rule: out}
+	for _, j := range jobs {
+		resp.Jobs = append(resp.Jobs, jobToProto(j))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (s *ReplicationService) CreateReplicationRule(ctx context.Context, req *connect.Request[v1.CreateReplicationRuleRequest]) (*connect.Response[v1.CreateReplicationRuleResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+
+	msg := req.Msg
+	ns := msg.Namespace
+	if ns == "" {
+		ns = user.Username
+	}
+	if !imageRepoNamePattern.MatchString(msg.Name) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid replication rule name"))
+	}
+	if !canManageNamespace(ctx, s.store, s.enforcer, user, ns) {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot manage replication in namespace %q", ns))
+	}
+	existing, err := s.store.GetReplicationRuleByName(ctx, ns, msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if existing != nil {
+		return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("replication rule %q already exists in %s", msg.Name, ns))
+	}
+
+	rule := &storage.ReplicationRule{Namespace: ns, Name: msg.Name, Enabled: !msg.Disabled, CreatedBy: user.Username}
+	if err := s.apply(ctx, rule, &msg.RepoPattern, &msg.TagPattern, &msg.Target, &msg.CredentialId); err != nil {
+		return nil, err
+	}
+	if err := s.store.CreateReplicationRule(ctx, rule); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	s.log.Info("%s added replication rule %s/%s to %s", user.Username, rule.Namespace, rule.Name, rule.Target)
+	out, _, err := s.ruleToProto(ctx, rule)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.CreateReplicationRuleResponse{Rule: out}), nil
+}
+
+func (s *ReplicationService) UpdateReplicationRule(ctx context.Context, req *connect.Request[v1.UpdateReplicationRuleRequest]) (*connect.Response[v1.UpdateReplicationRuleResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	rule, err := s.getManaged(ctx, user, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := req.Msg
+	if err := s.apply(ctx, rule, msg.RepoPattern, msg.TagPattern, msg.Target, msg.CredentialId); err != nil {
+		return nil, err
+	}
+	if msg.Enabled != nil {
+		rule.Enabled = *msg.Enabled
+	}
+	if err := s.store.UpdateReplicationRule(ctx, rule); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	out, _, err := s.ruleToProto(ctx, rule)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.UpdateReplicationRuleResponse{Rule: out}), nil
+}
+
+func (s *ReplicationService) DeleteReplicationRule(ctx context.Context, req *connect.Request[v1.DeleteReplicationRuleRequest]) (*connect.Response[v1.DeleteReplicationRuleResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	rule, err := s.getManaged(ctx, user, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.store.DeleteReplicationRule(ctx, rule.ID); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	s.log.Info("%s removed replication rule %s/%s", user.Username, rule.Namespace, rule.Name)
+	return connect.NewResponse(&v1.DeleteReplicationRuleResponse{}), nil
+}
+
+func (s *ReplicationService) SyncReplicationRule(ctx context.Context, req *connect.Request[v1.SyncReplicationRuleRequest]) (*connect.Response[v1.SyncReplicationRuleResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	rule, err := s.getManaged(ctx, user, req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !rule.Enabled {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("replication rule %s is disabled", rule.Name))
+	}
+	if !s.replicator.Available() {
+		return nil, connect.NewError(connect.CodeUnavailable, fmt.Errorf("replication is unavailable"))
+	}
+	queued, err := s.replicator.Sync(ctx, rule)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.SyncReplicationRuleResponse{Queued: int32(queued)}), nil
+}
+
+// Validates and sets the fields that are given, nil leaves a field as is
+func (s *ReplicationService) apply(ctx context.Context, rule *storage.ReplicationRule, repoPattern, tagPattern, target, credentialID *string) error {
+	for _, f := range []struct {
+		val   *string
+		field *string
+		label string
+	}{
+		{repoPattern, &rule.RepoPattern, "repo_pattern"},
+		{tagPattern, &rule.TagPattern, "tag_pattern"},
+	} {
+		if f.val == nil {
+			continue
+		}
+		glob := strings.TrimSpace(*f.val)
+		if glob == "" {
+			glob = "*"
+		}
+		if _, err := path.Match(glob, ""); err != nil || strings.Contains(glob, "/") {
+			return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid %s %q", f.label, glob))
+		}
+		*f.field = glob
+	}
+	if target != nil {
+		t, err := mirror.ReplicationTarget(*target)
+		if err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, err)
+		}
+		rule.Target = t
+	}
+	if credentialID != nil {
+		id := strings.TrimSpace(*credentialID)
+		if id != "" {
+			cred, err := s.store.GetCredential(ctx, id)
+			if err != nil {
+				return connect.NewError(connect.CodeInternal, err)
+			}
+			// Rules push with their own namespace's logins only
+			if cred == nil || cred.Namespace != rule.Namespace {
+				return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("credential not found in namespace %s", rule.Namespace))
+			}
+		}
+		rule.CredentialID = id
+	}
+	return nil
+}
+
+// The rule with its status, and the open jobs the counts come from
+func (s *ReplicationService) ruleToProto(ctx context.Context, r *storage.ReplicationRule) (*v1.ReplicationRule, []*storage.Job, error) {
+	jobs, err := s.replicator.Jobs(ctx, r.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	status := &v1.ReplicationStatus{
+		LastRef:    r.LastRef,
+		LastDigest: r.LastDigest,
+		LastError:  r.LastError,
+		Replicated: r.Replicated,
+		Failures:   r.Failures,
+	}
+	if r.LastRunAt != nil {
+		status.LastRunAt = timestamppb.New(*r.LastRunAt)
+	}
+	if r.LastSuccessAt != nil {
+		status.LastSuccessAt = timestamppb.New(*r.LastSuccessAt)
+	}
+	for _, j := range jobs {
+		if j.Status == storage.JobFailed {
+			status.Failed++
+		} else {
+			status.Pending++
+		}
+	}
+	return &v1.ReplicationRule{
+		Id:           r.ID,
+		Namespace:    r.Namespace,
+		Name:         r.Name,
+		RepoPattern:  r.RepoPattern,
+		TagPattern:   r.TagPattern,
+		Target:       r.Target,
+		CredentialId: r.CredentialID,
+		Enabled:      r.Enabled,
+		CreatedBy:    r.CreatedBy,
+		CreatedAt:    timestamppb.New(r.CreatedAt),
+		UpdatedAt:    timestamppb.New(r.UpdatedAt),
+		Status:       status,
+	}, jobs, nil
+}
@@ -3,43 +3,82 @@ package services
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"connectrpc.com/connect"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/artifacts"
 	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/journal"
 	"github.com/nickheyer/distroface/internal/mirror"
+	"github.com/nickheyer/distroface/internal/policy"
 	"github.com/nickheyer/distroface/internal/portal"
 	"github.com/nickheyer/distroface/internal/rbac"
 	"github.com/nickheyer/distroface/internal/registry"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/signing"
 	"github.com/nickheyer/distroface/pkg/logger"
 	"github.com/nickheyer/distroface/pkg/natsort"
 	"github.com/nickheyer/distroface/pkg/pages"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"github.com/nickheyer/distroface/pkg/utils"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var _ distrofacev1connect.RepositoryServiceHandler = (*RepositoryService)(nil)
 
 type RepositoryService struct {
-	store    *stores.Store
-	registry *registry.RegistryAccess
-	enforcer *rbac.Enforcer
-	mirrors  *mirror.Monitor
-	log      *logger.Logger
+	store      *stores.Store
+	settings   *settings.Resolver
+	registry   *registry.RegistryAccess
+	enforcer   *rbac.Enforcer
+	mirrors    *mirror.Monitor
+	signer     *signing.Signer
+	approvals  *deletionApprovals
+	journal    *journal.Journal
+	visibility *policy.Visibility
+	freezes    *policy.Freezes   // Nil leaves untags unfrozen
+	artifacts  *artifacts.Access // Artifact repo rules for ACLs on artifact only paths
+	log        *logger.Logger
 }
 
-func NewRepositoryService(store *stores.Store, reg *registry.RegistryAccess, enforcer *rbac.Enforcer, mirrors *mirror.Monitor, log *logger.Logger) *RepositoryService {
-	return &RepositoryService{store: store, registry: reg, enforcer: enforcer, mirrors: mirrors, log: log}
+func NewRepositoryService(store *stores.Store, resolver *settings.Resolver, reg *registry.RegistryAccess, enforcer *rbac.Enforcer, mirrors *mirror.Monitor, signer *signing.Signer, log *logger.Logger) *RepositoryService {
+	return &RepositoryService{
+		store:     store,
+		settings:  resolver,
+		registry:  reg,
+		enforcer:  enforcer,
+		mirrors:   mirrors,
+		signer:    signer,
+		approvals: &deletionApprovals{gate: policy.NewApprovals(store, resolver, enforcer)},
+		artifacts: artifacts.NewAccess(store, enforcer, resolver),
+		log:       log,
+	}
 }
 
+// Journals forks and deletes so a crash cannot strand storage or rows
+func (s *RepositoryService) SetJournal(j *journal.Journal) { s.journal = j }
+
+// Holds creates and visibility changes to the group visibility policy
+func (s *RepositoryService) SetVisibility(v *policy.Visibility) { s.visibility = v }
+
+// Holds untags to the same freeze windows as pushes
+func (s *RepositoryService) SetFreezes(f *policy.Freezes) { s.freezes = f }
+
 var imageRepoNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
 
+// OCI distribution tag grammar
+var imageTagPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
 // Namespace owners create at will, others need the manage grant
 func (s *RepositoryService) canCreateInNamespace(ctx context.Context, user *auth.AuthenticatedUser, namespace string) bool {
 	if namespace == user.Username {
@@ -65,19 +104,9 @@ func (s *RepositoryService) CreateRepository(ctx context.Context, req *connect.R
 	if portal.ForeignRef(ctx, ns) {
 		return nil, connect.NewError(connect.CodeNotFound, nil)
 	}
-	if !imageRepoNamePattern.MatchString(msg.Name) {
-		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid repository name"))
-	}
-	if !s.canCreateInNamespace(ctx, user, ns) {
-		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot create repository in namespace %q", ns))
-	}
-
-	existing, err := s.store.GetRepository(ctx, ns, msg.Name)
+	name, err := s.checkNewRepository(ctx, user, ns, msg.Name, msg.AllowReserved)
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
-	}
-	if existing != nil {
-		return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("repository %q already exists", ns+"/"+msg.Name))
+		return nil, err
 	}
 
 	repoType := msg.Type
@@ -86,7 +115,7 @@ func (s *RepositoryService) CreateRepository(ctx context.Context, req *connect.R
 	}
 	mirrorCfg := ""
 	if repoType == v1.RepositoryType_REPOSITORY_TYPE_MIRROR {
-		if err := s.mirrors.ValidateRegistryMirror(ctx, msg.Mirror); err != nil {
+		if err := s.mirrors.ValidateRegistryMirror(ctx, ns, msg.Mirror); err != nil {
 			return nil, mapMirrorErr(err)
 		}
 		if mirrorCfg, err = mirror.EncodeConfig(msg.Mirror); err != nil {
@@ -96,27 +125,27 @@ func (s *RepositoryService) CreateRepository(ctx context.Context, req *connect.R
 		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("standard repositories do not take mirror settings"))
 	}
 
-	ownerID := user.ID
-	isOrgNamespace := false
-	if ns != user.Username {
-		if org, _ := s.store.GetOrganization(ctx, ns); org != nil {
-			ownerID = org.ID
-			isOrgNamespace = true
-		}
+	isPrivate, err := s.visibility.ForCreate(ctx, user.Roles, msg.Visibility == v1.Visibility_VISIBILITY_PRIVATE, msg.Visibility != v1.Visibility_VISIBILITY_UNSPECIFIED)
+	if err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
 	}
+	ownerID, isOrgNamespace := s.namespaceOwner(ctx, user, ns)
 
 	repo := &storage.Repository{
 		ID:             uuid.New().String(),
 		Namespace:      ns,
-		Name:           msg.Name,
+		Name:           name,
 		Description:    msg.Description,
 		OwnerID:        ownerID,
-		IsPrivate:      msg.Visibility == v1.Visibility_VISIBILITY_PRIVATE,
+		IsPrivate:      isPrivate,
 		IsOrgNamespace: isOrgNamespace,
 		Type:           repoType,
 		MirrorConfig:   mirrorCfg,
 	}
 	if err := s.store.CreateRepository(ctx, repo); err != nil {
+		if stores.IsUniqueViolation(err) {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("repository %q already exists", ns+"/"+name))
+		}
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
@@ -125,21 +154,434 @@ func (s *RepositoryService) CreateRepository(ctx context.Context, req *connect.R
 	}), nil
 }
 
+// Name, namespace grant, reservation and uniqueness checks shared by create
+// and fork, returns the normalized name
+func (s *RepositoryService) checkNewRepository(ctx context.Context, user *auth.AuthenticatedUser, ns, name string, allowReserved bool) (string, error) {
+	name, err := utils.NormalizeRepoName(utils.ImageRepo, name)
+	if err != nil {
+		return "", connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if !s.canCreateInNamespace(ctx, user, ns) {
+		return "", connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot create repository in namespace %q", ns))
+	}
+	if err := s.settings.CheckNamespace(ctx, ns); err != nil {
+		if !allowReserved {
+			return "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("namespace %q is reserved, an admin may override", ns))
+		}
+		if !s.enforcer.HasPermission(user.Roles, rbac.ResourceRepositories, rbac.ActionManage) {
+			return "", connect.NewError(connect.CodePermissionDenied, fmt.Errorf("overriding reserved namespace %q needs repository manage", ns))
+		}
+	}
+
+	existing, err := s.store.GetRepository(ctx, ns, name)
+	if err != nil {
+		return "", connect.NewError(connect.CodeInternal, err)
+	}
+	if existing != nil {
+		return "", connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("repository %q already exists", ns+"/"+name))
+	}
+	return name, nil
+}
+
+// A private source the user cannot manage keeps its forks private
+func (s *RepositoryService) sourceLocked(ctx context.Context, user *auth.AuthenticatedUser, src *storage.Repository) bool {
+	return src.IsPrivate && !s.canManageRepo(ctx, user, src)
+}
+
+// Org namespaces are owned by the org, anything else by the caller
+func (s *RepositoryService) namespaceOwner(ctx context.Context, user *auth.AuthenticatedUser, ns string) (string, bool) {
+	if ns != user.Username {
+		if org, _ := s.store.GetOrganization(ctx, ns); org != nil {
+			return org.ID, true
+		}
+	}
+	return user.ID, false
+}
+
+// Copies tag and layer links into a new standard repository, blobs stay
+// shared on disk so the fork is cheap and diverges on the next push
+func (s *RepositoryService) ForkRepository(ctx context.Context, req *connect.Request[v1.ForkRepositoryRequest]) (*connect.Response[v1.ForkRepositoryResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+
+	msg := req.Msg
+	if msg.SourceNamespace == "" || msg.SourceName == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("source repository is required"))
+	}
+	src, err := s.store.GetRepository(ctx, msg.SourceNamespace, msg.SourceName)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if src == nil || !s.canReadRepo(ctx, src) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+
+	ns := msg.Namespace
+	if ns == "" {
+		ns = user.Username
+	}
+	name := msg.Name
+	if name == "" {
+		name = src.Name
+	}
+	if portal.ForeignRef(ctx, ns) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+	if name, err = s.checkNewRepository(ctx, user, ns, name, false); err != nil {
+		return nil, err
+	}
+
+	description := src.Description
+	if msg.Description != nil {
+		description = *msg.Description
+	}
+	isPrivate := src.IsPrivate
+	if msg.Visibility != v1.Visibility_VISIBILITY_UNSPECIFIED {
+		isPrivate = msg.Visibility == v1.Visibility_VISIBILITY_PRIVATE
+	}
+	// Read access to a private repo is no right to publish its content
+	if s.sourceLocked(ctx, user, src) {
+		if !isPrivate && msg.Visibility != v1.Visibility_VISIBILITY_UNSPECIFIED {
+			return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("forks of %s/%s stay private unless you manage it", src.Namespace, src.Name))
+		}
+		isPrivate = true
+	}
+	// Forking a public repo is no way around the publish policy
+	if isPrivate, err = s.visibility.ForCreate(ctx, user.Roles, isPrivate, msg.Visibility != v1.Visibility_VISIBILITY_UNSPECIFIED); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	ownerID, isOrgNamespace := s.namespaceOwner(ctx, user, ns)
+
+	srcName := src.Namespace + "/" + src.Name
+	repo := &storage.Repository{
+		ID:             uuid.New().String(),
+		Namespace:      ns,
+		Name:           name,
+		Description:    description,
+		OwnerID:        ownerID,
+		IsPrivate:      isPrivate,
+		IsOrgNamespace: isOrgNamespace,
+		Type:           v1.RepositoryType_REPOSITORY_TYPE_STANDARD,
+		ForkedFrom:     srcName,
+		// Forks must not become an unrestricted copy of a locked down repo
+		PullRestriction: src.PullRestriction,
+	}
+	// Row first so the name is ours before storage is touched
+	if err := s.store.CreateRepository(ctx, repo); err != nil {
+		if stores.IsUniqueViolation(err) {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("repository %q already exists", ns+"/"+name))
+		}
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	unwind := func() {
+		if delErr := s.store.DeleteRepository(ctx, ns, name); delErr != nil {
+			s.log.Error("Unwinding fork %s/%s: %v", ns, name, delErr)
+		}
+	}
+
+	// Replay unwinds a fork cut short, the caller never saw it succeed
+	opID, err := s.journal.Begin(registry.OpRepoFork, registry.RepoOp{Namespace: ns, Name: name})
+	if err != nil {
+		unwind()
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	defer s.journal.Done(opID)
+
+	links, err := s.registry.ForkRepository(srcName, ns+"/"+name)
+	if err != nil {
+		unwind()
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("forking %s: %w", srcName, err))
+	}
+	s.log.Info("Forked %s into %s/%s (%d links)", srcName, ns, name, links)
+
+	return connect.NewResponse(&v1.ForkRepositoryResponse{
+		Repository:    s.repoToProto(repo),
+		LinkedObjects: int32(links),
+	}), nil
+}
+
+// Header CI systems set on copies to tie a promotion to their run
+const pipelineIDHeader = "X-Pipeline-Id"
+
+// Copies a tag through the registry handler as the caller and records the
+// source, the first origin along a copy chain, and the pipeline run
+func (s *RepositoryService) CopyTag(ctx context.Context, req *connect.Request[v1.CopyTagRequest]) (*connect.Response[v1.CopyTagResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+
+	msg := req.Msg
+	if msg.SourceNamespace == "" || msg.SourceName == "" || msg.SourceTag == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("source repository and tag are required"))
+	}
+	src, err := s.store.GetRepository(ctx, msg.SourceNamespace, msg.SourceName)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if src == nil || !s.canReadRepo(ctx, src) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+
+	ns, name, tag := cmp.Or(msg.Namespace, src.Namespace), cmp.Or(msg.Name, src.Name), cmp.Or(msg.Tag, msg.SourceTag)
+	if ns == src.Namespace && name == src.Name && tag == msg.SourceTag {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("source and target are the same tag"))
+	}
+	if !imageTagPattern.MatchString(tag) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid tag %q", tag))
+	}
+	if portal.ForeignRef(ctx, ns) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+	dstName := ns + "/" + name
+	if err := utils.ValidateImagePath(dstName); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if !s.canPushTo(ctx, user, ns, name) {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot push to %q", dstName))
+	}
+
+	srcDesc, err := s.registry.ResolveManifest(ctx, src.Namespace, src.Name, msg.SourceTag)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("tag %q: %w", msg.SourceTag, err))
+	}
+	srcName := src.Namespace + "/" + src.Name
+	if err := s.mirrors.CopyLocalTag(ctx, user.Username, srcName, srcDesc.Digest.String(), dstName, tag); err != nil {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("copying %s:%s: %w", srcName, msg.SourceTag, err))
+	}
+
+	prov := &storage.TagProvenance{
+		Namespace:    ns,
+		Name:         name,
+		Tag:          tag,
+		Digest:       srcDesc.Digest.String(),
+		SourceRepo:   srcName,
+		SourceTag:    msg.SourceTag,
+		SourceDigest: srcDesc.Digest.String(),
+		OriginRepo:   srcName,
+		OriginTag:    msg.SourceTag,
+		CopiedBy:     user.Username,
+		PipelineID:   req.Header().Get(pipelineIDHeader),
+		CopiedAt:     time.Now(),
+	}
+	// The source was itself copied, the chain leads back to its origin
+	if up, err := s.store.GetTagProvenance(ctx, src.Namespace, src.Name, msg.SourceTag); err == nil && up != nil && up.Digest == prov.SourceDigest {
+		prov.OriginRepo, prov.OriginTag = up.OriginRepo, up.OriginTag
+	}
+	if err := s.store.RecordTagProvenance(ctx, prov); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	s.log.Info("%s copied %s:%s to %s:%s", user.Username, srcName, msg.SourceTag, dstName, tag)
+
+	return connect.NewResponse(&v1.CopyTagResponse{Provenance: tagProvenanceToProto(prov)}), nil
+}
+
+// Unset once the tag moved since the copy, the record no longer describes it
+func (s *RepositoryService) GetTagProvenance(ctx context.Context, req *connect.Request[v1.GetTagProvenanceRequest]) (*connect.Response[v1.GetTagProvenanceResponse], error) {
+	if req.Msg.Namespace == "" || req.Msg.Name == "" || req.Msg.Tag == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
+	}
+	repo, err := s.store.GetRepository(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if repo == nil || !s.canReadRepo(ctx, repo) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+
+	prov, err := s.store.GetTagProvenance(ctx, repo.Namespace, repo.Name, req.Msg.Tag)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.GetTagProvenanceResponse{}
+	if prov != nil {
+		if desc, err := s.registry.ResolveManifest(ctx, repo.Namespace, repo.Name, req.Msg.Tag); err == nil && desc.Digest.String() == prov.Digest {
+			resp.Provenance = tagProvenanceToProto(prov)
+		}
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// Expiry belongs to the tag, it outlives pushes over it until cleared
+func (s *RepositoryService) SetTagExpiry(ctx context.Context, req *connect.Request[v1.SetTagExpiryRequest]) (*connect.Response[v1.SetTagExpiryResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	msg := req.Msg
+	if msg.Namespace == "" || msg.Name == "" || msg.Tag == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("repository and tag are required"))
+	}
+	if msg.ExpiresInSeconds < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("expiry must not be negative"))
+	}
+	repo, err := s.store.GetRepository(ctx, msg.Namespace, msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if repo == nil || !s.canReadRepo(ctx, repo) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+	if !s.canPushTo(ctx, user, repo.Namespace, repo.Name) {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot push to %s/%s", repo.Namespace, repo.Name))
+	}
+
+	if msg.ExpiresInSeconds == 0 {
+		if err := s.store.DeleteTagExpiry(ctx, repo.Namespace, repo.Name, msg.Tag); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		return connect.NewResponse(&v1.SetTagExpiryResponse{}), nil
+	}
+	if _, err := s.registry.ResolveManifest(ctx, repo.Namespace, repo.Name, msg.Tag); err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("tag %q: %w", msg.Tag, err))
+	}
+	expiry := &storage.TagExpiry{
+		Namespace: repo.Namespace,
+		Name:      repo.Name,
+		Tag:       msg.Tag,
+		ExpiresAt: time.Now().UTC().Add(time.Duration(msg.ExpiresInSeconds) * time.Second),
+		SetBy:     user.Username,
+	}
+	if err := s.store.SetTagExpiry(ctx, expiry); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&v1.SetTagExpiryResponse{ExpiresAt: timestamppb.New(expiry.ExpiresAt)}), nil
+}
+
+// Anyone who may push may clean up tags when their role has delete_tag,
+// removing the whole repository takes delete and manage rights
+func (s *RepositoryService) DeleteTag(ctx context.Context, req *connect.Request[v1.DeleteTagRequest]) (*connect.Response[v1.DeleteTagResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	msg := req.Msg
+	if msg.Namespace == "" || msg.Name == "" || msg.Tag == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("repository and tag are required"))
+	}
+	repo, err := s.store.GetRepository(ctx, msg.Namespace, msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if repo == nil || !s.canReadRepo(ctx, repo) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+	if !s.canPushTo(ctx, user, repo.Namespace, repo.Name) {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("cannot delete tags in %s/%s", repo.Namespace, repo.Name))
+	}
+
+	// Frozen before approval too, so a held untag is not left pending
+	if err := s.freezes.Check(ctx, user.Username, repo.Namespace, repo.Name); err != nil {
+		if errors.Is(err, policy.ErrFrozen) {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, err)
+		}
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	target := repo.Namespace + "/" + repo.Name + ":" + msg.Tag
+	expires, err := s.approvals.check(ctx, user, rbac.ResourceRepositories, target, msg.Reason, func() (int64, error) {
+		size, err := s.registry.ManifestSize(ctx, repo.Namespace, repo.Name, msg.Tag)
+		if err != nil {
+			return 0, nil // Missing tags are reported by the delete itself
+		}
+		return size, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expires != nil {
+		s.log.Info("Delete of tag %s by %s awaits a second admin", target, user.Username)
+		return connect.NewResponse(&v1.DeleteTagResponse{
+			PendingApproval:   true,
+			ApprovalExpiresAt: timestamppb.New(*expires),
+		}), nil
+	}
+
+	// Replay finishes an untag cut short between storage and the tag records
+	opID, err := s.journal.Begin(registry.OpTagDelete, registry.TagOp{Namespace: repo.Namespace, Name: repo.Name, Tag: msg.Tag})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	defer s.journal.Done(opID)
+
+	if err := s.registry.DeleteTag(ctx, repo.Namespace, repo.Name, msg.Tag); err != nil {
+		var gone driver.PathNotFoundError
+		if errors.As(err, &gone) {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("tag %q not found", msg.Tag))
+		}
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	s.log.Info("%s deleted tag %s/%s:%s", user.Username, repo.Namespace, repo.Name, msg.Tag)
+	return connect.NewResponse(&v1.DeleteTagResponse{}), nil
+}
+
+// Same grant the registry token endpoint gives push scopes, copies skip it
+func (s *RepositoryService) canPushTo(ctx context.Context, user *auth.AuthenticatedUser, namespace, name string) bool {
+	if namespace == user.Username {
+		return true
+	}
+	if isMember, _, _ := s.store.IsOrgMember(ctx, namespace, user.ID); isMember {
+		return true
+	}
+	if s.aclAllows(ctx, user, namespace, name, rbac.ActionPush) {
+		return true
+	}
+	if canManage, _ := s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionManage, namespace); !canManage {
+		return false
+	}
+	if owner, _ := s.store.GetUserByUsername(ctx, namespace); owner != nil {
+		return true
+	}
+	org, _ := s.store.GetOrganization(ctx, namespace)
+	return org != nil
+}
+
+func tagProvenanceToProto(p *storage.TagProvenance) *v1.TagProvenance {
+	return &v1.TagProvenance{
+		Namespace:    p.Namespace,
+		Name:         p.Name,
+		Tag:          p.Tag,
+		Digest:       p.Digest,
+		SourceRepo:   p.SourceRepo,
+		SourceTag:    p.SourceTag,
+		SourceDigest: p.SourceDigest,
+		OriginRepo:   p.OriginRepo,
+		OriginTag:    p.OriginTag,
+		CopiedBy:     p.CopiedBy,
+		PipelineId:   p.PipelineID,
+		CopiedAt:     timestamppb.New(p.CopiedAt),
+	}
+}
+
 // Checks if the requesting user can read the given repo via RBAC
 func (s *RepositoryService) canReadRepo(ctx context.Context, repo *storage.Repository) bool {
 	if portal.ForeignRef(ctx, repo.Namespace) {
 		return false
 	}
+	user := auth.UserFromContext(ctx)
 	if !repo.IsPrivate {
-		return true
+		return !auth.IsAnonymous(user) || s.settings.AnonymousReadable(ctx, repo.Namespace, repo.Name)
 	}
-	user := auth.UserFromContext(ctx)
 	if user == nil {
 		return false
 	}
 	objectID := repo.Namespace + "/" + repo.Name
-	allowed, _ := s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionRead, objectID)
-	return allowed
+	if allowed, _ := s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionRead, objectID); allowed {
+		return true
+	}
+	return s.aclAllows(ctx, user, repo.Namespace, repo.Name, rbac.ActionRead)
+}
+
+// Repository ACL grant to the user or one of its roles
+func (s *RepositoryService) aclAllows(ctx context.Context, user *auth.AuthenticatedUser, namespace, name, action string) bool {
+	levels, err := s.store.RepositoryPermissionLevels(ctx, namespace, name, user.ID, user.Roles)
+	if err != nil {
+		s.log.Error("failed to look up grants on %s/%s: %v", namespace, name, err)
+		return false
+	}
+	return rbac.LevelsAllow(levels, action)
 }
 
 func (s *RepositoryService) GetRepository(ctx context.Context, req *connect.Request[v1.GetRepositoryRequest]) (*connect.Response[v1.GetRepositoryResponse], error) {
@@ -182,16 +624,26 @@ func (s *RepositoryService) ListRepositories(ctx context.Context, req *connect.R
 		canManage, _ = s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionManage, "*")
 		if !canManage {
 			grantedRepos = s.enforcer.GetGrantedObjects(user.Roles, rbac.ResourceRepositories, rbac.ActionRead)
+			permitted, err := s.store.ListPermittedRepositories(ctx, user.ID, user.Roles)
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInternal, err)
+			}
+			grantedRepos = append(grantedRepos, permitted...)
 		}
 	}
 
-	namespace := portal.ScopeNamespace(ctx, req.Msg.Namespace)
 	q := pages.ParseQuery(req.Msg.Page)
 	if err := stores.ReposQuery.Validate(q); err != nil {
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
+	filter, err := repoFilter(req.Msg)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	filter.Namespace = portal.ScopeNamespace(ctx, req.Msg.Namespace)
 
-	repos, total, err := s.store.ListRepositories(ctx, namespace, q, userID, canManage, grantedRepos, pageSize, offset)
+	orderBy := pages.OrderBy(req.Msg.Page, stores.RepoSortColumns, "updated_at DESC")
+	repos, total, err := s.store.ListRepositories(ctx, filter, q, orderBy, userID, canManage, grantedRepos, pageSize, offset)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -208,6 +660,29 @@ func (s *RepositoryService) ListRepositories(ctx context.Context, req *connect.R
 	}), nil
 }
 
+// Visibility, label and recency narrowing of a listing request
+func repoFilter(msg *v1.ListRepositoriesRequest) (stores.RepoFilter, error) {
+	var f stores.RepoFilter
+	switch msg.Visibility {
+	case v1.Visibility_VISIBILITY_PUBLIC:
+		f.Private = proto.Bool(false)
+	case v1.Visibility_VISIBILITY_PRIVATE:
+		f.Private = proto.Bool(true)
+	}
+	for _, raw := range msg.Labels {
+		key, value, _ := strings.Cut(raw, "=")
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, `"\`) {
+			return f, fmt.Errorf("invalid label filter %q, want key or key=value", raw)
+		}
+		f.Labels = append(f.Labels, stores.RepoLabel{Key: key, Value: value})
+	}
+	if msg.UpdatedSince != nil {
+		f.UpdatedSince = msg.UpdatedSince.AsTime()
+	}
+	return f, nil
+}
+
 func (s *RepositoryService) DeleteRepository(ctx context.Context, req *connect.Request[v1.DeleteRepositoryRequest]) (*connect.Response[v1.DeleteRepositoryResponse], error) {
 	if req.Msg.Namespace == "" || req.Msg.Name == "" {
 		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
@@ -229,20 +704,49 @@ func (s *RepositoryService) DeleteRepository(ctx context.Context, req *connect.R
 		return nil, connect.NewError(connect.CodeNotFound, nil)
 	}
 
+	if !s.canManageRepo(ctx, user, repo) {
+		return nil, connect.NewError(connect.CodePermissionDenied, nil)
+	}
 	objectID := repo.Namespace + "/" + repo.Name
-	canManage, _ := s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionManage, objectID)
-	if !canManage {
-		if user.Username != repo.Namespace {
-			isMember, role, _ := s.store.IsOrgMember(ctx, repo.Namespace, user.ID)
-			if !isMember || (role != storage.OrgRoleOwner && role != storage.OrgRoleAdmin) {
-				return nil, connect.NewError(connect.CodePermissionDenied, nil)
-			}
+
+	expires, err := s.approvals.check(ctx, user, rbac.ResourceRepositories, objectID, req.Msg.Reason, func() (int64, error) {
+		blobs, err := s.registry.BlobSharing(ctx, repo.Namespace, repo.Name, "")
+		var total int64
+		for _, b := range blobs {
+			total += b.Size
 		}
+		return total, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expires != nil {
+		s.log.Info("Delete of repository %s by %s awaits a second admin", objectID, user.Username)
+		return connect.NewResponse(&v1.DeleteRepositoryResponse{
+			PendingApproval:   true,
+			ApprovalExpiresAt: timestamppb.New(*expires),
+		}), nil
 	}
 
+	// Replay finishes a delete cut short between the row and the storage
+	opID, err := s.journal.Begin(registry.OpRepoDelete, registry.RepoOp{Namespace: repo.Namespace, Name: repo.Name})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	defer s.journal.Done(opID)
+
 	if err := s.store.DeleteRepository(ctx, req.Msg.Namespace, req.Msg.Name); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
+	if err := s.registry.DeleteRepository(repo.Namespace, repo.Name); err != nil {
+		s.log.Error("Removing storage of %s: %v", objectID, err)
+	}
+	// The repo tier outlives the repo while an artifact repo shares the path
+	if art, err := s.store.GetArtifactRepository(ctx, repo.Namespace, repo.Name); err == nil && (art == nil || art.Namespace+"/"+art.Name != objectID) {
+		if err := s.settings.DeleteScope(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, objectID); err != nil {
+			s.log.Error("Dropping repo settings of %s: %v", objectID, err)
+		}
+	}
 
 	return connect.NewResponse(&v1.DeleteRepositoryResponse{}), nil
 }
@@ -269,6 +773,33 @@ func (s *RepositoryService) ListTags(ctx context.Context, req *connect.Request[v
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
+	// Storage keeps no pusher, recorded pushes fill it in while the tag still points at what was pushed
+	pushes, err := s.store.ListTagPushes(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	expiries, err := s.store.ListTagExpiries(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	pulls, err := s.store.ListTagPulls(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	for _, t := range tags {
+		if p := pulls[t.Name]; p != nil {
+			t.PulledAt = timestamppb.New(p.PulledAt)
+			t.PullCount = p.PullCount
+		}
+		if p := pushes[t.Name]; p != nil && p.Digest == t.Digest {
+			t.PushedAt = timestamppb.New(p.PushedAt)
+			t.PushedBy = p.PushedBy
+		}
+		if e := expiries[t.Name]; e != nil {
+			t.ExpiresAt = timestamppb.New(e.ExpiresAt)
+		}
+	}
+
 	page := req.Msg.Page
 	if page == nil {
 		page = &v1.PageRequest{}
@@ -279,10 +810,12 @@ func (s *RepositoryService) ListTags(ctx context.Context, req *connect.Request[v
 
 	byVersion := natsort.TagVersionComparator(tags)
 	pages.Sort(page, tags, map[string]func(a, b *v1.Tag) int{
-		"name":      byVersion,
-		"version":   byVersion,
-		"size":      func(a, b *v1.Tag) int { return cmp.Compare(a.SizeBytes, b.SizeBytes) },
-		"pushed_at": func(a, b *v1.Tag) int { return a.GetPushedAt().AsTime().Compare(b.GetPushedAt().AsTime()) },
+		"name":       byVersion,
+		"version":    byVersion,
+		"size":       func(a, b *v1.Tag) int { return cmp.Compare(a.SizeBytes, b.SizeBytes) },
+		"pushed_at":  func(a, b *v1.Tag) int { return a.GetPushedAt().AsTime().Compare(b.GetPushedAt().AsTime()) },
+		"created_at": func(a, b *v1.Tag) int { return a.GetCreatedAt().AsTime().Compare(b.GetCreatedAt().AsTime()) },
+		"pulled_at":  func(a, b *v1.Tag) int { return a.GetPulledAt().AsTime().Compare(b.GetPulledAt().AsTime()) },
 	})
 
 	pageSize, offset := pages.Parse(page)
@@ -324,6 +857,137 @@ func (s *RepositoryService) ResolveTag(ctx context.Context, req *connect.Request
 	}), nil
 }
 
+func (s *RepositoryService) GetLayerSharing(ctx context.Context, req *connect.Request[v1.GetLayerSharingRequest]) (*connect.Response[v1.GetLayerSharingResponse], error) {
+	if req.Msg.Namespace == "" || req.Msg.Name == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
+	}
+
+	repo, err := s.store.GetRepository(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if repo == nil || !s.canReadRepo(ctx, repo) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+
+	blobs, err := s.registry.BlobSharing(ctx, req.Msg.Namespace, req.Msg.Name, req.Msg.Tag)
+	if err != nil {
+		if req.Msg.Tag != "" {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("tag %q: %w", req.Msg.Tag, err))
+		}
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	// Other repos are named only when the caller could see them anyway
+	visible := map[string]bool{}
+	canSee := func(full string) bool {
+		if seen, ok := visible[full]; ok {
+			return seen
+		}
+		ns, name, _ := strings.Cut(full, "/")
+		other, err := s.store.GetRepository(ctx, ns, name)
+		visible[full] = err == nil && other != nil && s.canReadRepo(ctx, other)
+		return visible[full]
+	}
+
+	resp := &v1.GetLayerSharingResponse{Layers: make([]*v1.LayerSharing, 0, len(blobs))}
+	for _, b := range blobs {
+		layer := &v1.LayerSharing{
+			Digest:     b.Digest,
+			SizeBytes:  b.Size,
+			MediaType:  b.MediaType,
+			SharedTags: b.Tags,
+			Shared:     len(b.Repos) > 0 || len(b.Tags) > 0,
+		}
+		for _, other := range b.Repos {
+			if canSee(other) {
+				layer.SharedRepos = append(layer.SharedRepos, other)
+			}
+		}
+		layer.SharedRepoCount = int32(len(layer.SharedRepos))
+		resp.TotalBytes += b.Size
+		if layer.Shared {
+			resp.SharedBytes += b.Size
+		} else {
+			resp.UniqueBytes += b.Size
+		}
+		resp.Layers = append(resp.Layers, layer)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// Deploy pipelines call this ahead of a rollout so the pulls it triggers
+// never wait on upstream. Read access suffices, only gaps in a tag already
+// here are fetched
+func (s *RepositoryService) PrewarmTag(ctx context.Context, req *connect.Request[v1.PrewarmTagRequest]) (*connect.Response[v1.PrewarmTagResponse], error) {
+	if req.Msg.Namespace == "" || req.Msg.Name == "" || req.Msg.Tag == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
+	}
+	repo, err := s.store.GetRepository(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if repo == nil || !s.canReadRepo(ctx, repo) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+
+	dgst, blobs, err := s.registry.TagBlobs(ctx, repo.Namespace, repo.Name, req.Msg.Tag)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("tag %q: %w", req.Msg.Tag, err))
+	}
+	missing := countMissing(blobs)
+	fetched := 0
+	if missing > 0 {
+		if s.mirrors == nil || repo.Type != v1.RepositoryType_REPOSITORY_TYPE_MIRROR {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("%d blobs of tag %q are missing and the repository is not a mirror to fetch them from", missing, req.Msg.Tag))
+		}
+		if err := s.mirrors.PrewarmImage(ctx, repo, dgst.String()); err != nil {
+			return nil, mapSyncErr(err)
+		}
+		before := len(blobs)
+		if _, blobs, err = s.registry.TagBlobs(ctx, repo.Namespace, repo.Name, req.Msg.Tag); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		// Children of manifests that were missing only show up now
+		fetched = missing + len(blobs) - before
+		if left := countMissing(blobs); left > 0 {
+			return nil, connect.NewError(connect.CodeUnavailable, fmt.Errorf("%d blobs of tag %q still missing after fetching from upstream", left, req.Msg.Tag))
+		}
+		s.log.Info("prewarm fetched %d blobs of %s/%s:%s from upstream", fetched, repo.Namespace, repo.Name, req.Msg.Tag)
+	}
+
+	resp := &v1.PrewarmTagResponse{Digest: dgst.String(), Blobs: int32(len(blobs)), Fetched: int32(fetched)}
+	for _, b := range blobs {
+		resp.TotalBytes += b.Size
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func countMissing(blobs []registry.TagBlob) int {
+	n := 0
+	for _, b := range blobs {
+		if !b.Present {
+			n++
+		}
+	}
+	return n
+}
+
+// Manage grant, the namespace owner, an org owner or admin, or an admin ACL grant
+func (s *RepositoryService) canManageRepo(ctx context.Context, user *auth.AuthenticatedUser, repo *storage.Repository) bool {
+	objectID := repo.Namespace + "/" + repo.Name
+	if canManage, _ := s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionManage, objectID); canManage {
+		return true
+	}
+	if user.Username == repo.Namespace {
+		return true
+	}
+	if isMember, role, _ := s.store.IsOrgMember(ctx, repo.Namespace, user.ID); isMember && (role == storage.OrgRoleOwner || role == storage.OrgRoleAdmin) {
+		return true
+	}
+	return s.aclAllows(ctx, user, repo.Namespace, repo.Name, rbac.ActionManage)
+}
+
 func (s *RepositoryService) UpdateRepository(ctx context.Context, req *connect.Request[v1.UpdateRepositoryRequest]) (*connect.Response[v1.UpdateRepositoryResponse], error) {
 	if req.Msg.Namespace == "" || req.Msg.Name == "" {
 		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
@@ -345,22 +1009,29 @@ func (s *RepositoryService) UpdateRepository(ctx context.Context, req *connect.R
 		return nil, connect.NewError(connect.CodeNotFound, nil)
 	}
 
-	objectID := repo.Namespace + "/" + repo.Name
-	canManage, _ := s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionManage, objectID)
-	if !canManage {
-		if user.Username != repo.Namespace {
-			isMember, role, _ := s.store.IsOrgMember(ctx, repo.Namespace, user.ID)
-			if !isMember || (role != storage.OrgRoleOwner && role != storage.OrgRoleAdmin) {
-				return nil, connect.NewError(connect.CodePermissionDenied, nil)
-			}
-		}
+	if !s.canManageRepo(ctx, user, repo) {
+		return nil, connect.NewError(connect.CodePermissionDenied, nil)
 	}
 
 	if req.Msg.Description != nil {
 		repo.Description = *req.Msg.Description
 	}
 	if req.Msg.Visibility != nil {
-		repo.IsPrivate = *req.Msg.Visibility == v1.Visibility_VISIBILITY_PRIVATE
+		private := *req.Msg.Visibility == v1.Visibility_VISIBILITY_PRIVATE
+		if err := s.visibility.CheckChange(ctx, user.Roles, repo.IsPrivate, private); err != nil {
+			return nil, connect.NewError(connect.CodePermissionDenied, err)
+		}
+		if !private && repo.IsPrivate && repo.ForkedFrom != "" {
+			srcNS, srcName, _ := strings.Cut(repo.ForkedFrom, "/")
+			src, err := s.store.GetRepository(ctx, srcNS, srcName)
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInternal, err)
+			}
+			if src != nil && s.sourceLocked(ctx, user, src) {
+				return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("forks of %s stay private unless you manage it", repo.ForkedFrom))
+			}
+		}
+		repo.IsPrivate = private
 	}
 	if req.Msg.Mirror != nil {
 		if repo.Type != v1.RepositoryType_REPOSITORY_TYPE_MIRROR {
@@ -374,13 +1045,20 @@ func (s *RepositoryService) UpdateRepository(ctx context.Context, req *connect.R
 		if err != nil {
 			return nil, mapMirrorErr(err)
 		}
-		if err := s.mirrors.ValidateRegistryMirror(ctx, full); err != nil {
+		if err := s.mirrors.ValidateRegistryMirror(ctx, repo.Namespace, full); err != nil {
 			return nil, mapMirrorErr(err)
 		}
 		repo.MirrorConfig = merged
 		// Fresh config invalidates the conditional request cursor
 		repo.MirrorState = ""
 	}
+	if req.Msg.PullRestriction != nil {
+		raw, err := registry.EncodePullRestriction(req.Msg.PullRestriction)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+		repo.PullRestriction = raw
+	}
 
 	if err := s.store.UpdateRepository(ctx, repo); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
@@ -404,15 +1082,8 @@ func (s *RepositoryService) SyncRepository(ctx context.Context, req *connect.Req
 		return nil, connect.NewError(connect.CodeNotFound, nil)
 	}
 
-	objectID := repo.Namespace + "/" + repo.Name
-	canManage, _ := s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionManage, objectID)
-	if !canManage {
-		if user.Username != repo.Namespace {
-			isMember, role, _ := s.store.IsOrgMember(ctx, repo.Namespace, user.ID)
-			if !isMember || (role != storage.OrgRoleOwner && role != storage.OrgRoleAdmin) {
-				return nil, connect.NewError(connect.CodePermissionDenied, nil)
-			}
-		}
+	if !s.canManageRepo(ctx, user, repo) {
+		return nil, connect.NewError(connect.CodePermissionDenied, nil)
 	}
 
 	if err := s.mirrors.SyncImageRepoNow(repo); err != nil {
@@ -434,15 +1105,8 @@ func (s *RepositoryService) StopRepositorySync(ctx context.Context, req *connect
 		return nil, connect.NewError(connect.CodeNotFound, nil)
 	}
 
-	objectID := repo.Namespace + "/" + repo.Name
-	canManage, _ := s.enforcer.Enforce(user.Roles, rbac.ResourceRepositories, rbac.ActionManage, objectID)
-	if !canManage {
-		if user.Username != repo.Namespace {
-			isMember, role, _ := s.store.IsOrgMember(ctx, repo.Namespace, user.ID)
-			if !isMember || (role != storage.OrgRoleOwner && role != storage.OrgRoleAdmin) {
-				return nil, connect.NewError(connect.CodePermissionDenied, nil)
-			}
-		}
+	if !s.canManageRepo(ctx, user, repo) {
+		return nil, connect.NewError(connect.CodePermissionDenied, nil)
 	}
 
 	if err := s.mirrors.StopImageSync(repo); err != nil {
@@ -594,6 +1258,8 @@ func (s *RepositoryService) repoToProto(r *storage.Repository) *v1.Repository {
 		Type:            r.Type,
 		Mirror:          mirror.Redacted(r.MirrorConfig),
 		MirrorLastError: r.MirrorLastError,
+		ForkedFrom:      r.ForkedFrom,
+		PullRestriction: registry.ParsePullRestriction(r.PullRestriction),
 	}
 
 	if r.LastPush != nil {
@@ -611,3 +1277,273 @@ func (s *RepositoryService) repoToProto(r *storage.Repository) *v1.Repository {
 
 	return repo
 }
+
+// Readable image repo or a not found error, hides private repos from outsiders
+func (s *RepositoryService) readableRepo(ctx context.Context, namespace, name string) (*storage.Repository, error) {
+	if namespace == "" || name == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
+	}
+	repo, err := s.store.GetRepository(ctx, namespace, name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if repo == nil || !s.canReadRepo(ctx, repo) {
+		return nil, connect.NewError(connect.CodeNotFound, nil)
+	}
+	return repo, nil
+}
+
+func (s *RepositoryService) VerifyImageSignature(ctx context.Context, req *connect.Request[v1.VerifyImageSignatureRequest]) (*connect.Response[v1.VerifyImageSignatureResponse], error) {
+	if req.Msg.Reference == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("reference is required"))
+	}
+	repo, err := s.readableRepo(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.signer.Verify(ctx, repo.Namespace, repo.Name, req.Msg.Reference)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("reference %q: %w", req.Msg.Reference, err))
+	}
+
+	resp := &v1.VerifyImageSignatureResponse{Digest: result.Digest, Verified: result.Verified}
+	for _, sig := range result.Signatures {
+		resp.Signatures = append(resp.Signatures, &v1.ImageSignature{
+			Digest:     sig.Digest,
+			KeyId:      sig.KeyID,
+			Valid:      sig.Valid,
+			Error:      sig.Error,
+			KeyRetired: sig.KeyRetired,
+			CreatedAt:  timestamppb.New(sig.CreatedAt),
+		})
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (s *RepositoryService) ListSigningKeys(ctx context.Context, req *connect.Request[v1.ListSigningKeysRequest]) (*connect.Response[v1.ListSigningKeysResponse], error) {
+	repo, err := s.readableRepo(ctx, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.signer.Keys(ctx, repo.Namespace, repo.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.ListSigningKeysResponse{}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, signingKeyToProto(k))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// No namespace rotates the instance key and needs settings update, a repo
+// key needs the same grant as editing the repo
+func (s *RepositoryService) RotateSigningKey(ctx context.Context, req *connect.Request[v1.RotateSigningKeyRequest]) (*connect.Response[v1.RotateSigningKeyResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+
+	namespace, name := req.Msg.Namespace, req.Msg.Name
+	if namespace == "" && name == "" {
+		if !s.enforcer.HasPermission(user.Roles, rbac.ResourceSettings, rbac.ActionUpdate) {
+			return nil, connect.NewError(connect.CodePermissionDenied, nil)
+		}
+	} else {
+		if portal.ForeignRef(ctx, namespace) {
+			return nil, connect.NewError(connect.CodeNotFound, nil)
+		}
+		repo, err := s.readableRepo(ctx, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if !s.canManageRepo(ctx, user, repo) {
+			return nil, connect.NewError(connect.CodePermissionDenied, nil)
+		}
+		namespace, name = repo.Namespace, repo.Name
+	}
+
+	key, err := s.signer.Rotate(ctx, namespace, name)
+	if errors.Is(err, signing.ErrRepoKeysDisabled) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, err)
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	s.log.Info("Signing key rotated by %s for scope %q", user.Username, key.Scope)
+	return connect.NewResponse(&v1.RotateSigningKeyResponse{Key: signingKeyToProto(key)}), nil
+}
+
+func signingKeyToProto(k *storage.SigningKey) *v1.SigningKey {
+	out := &v1.SigningKey{
+		Id:           k.ID,
+		Scope:        k.Scope,
+		PublicKeyPem: k.PublicKey,
+		Active:       k.Active,
+		CreatedAt:    timestamppb.New(k.CreatedAt),
+	}
+	if k.RetiredAt != nil {
+		out.RetiredAt = timestamppb.New(*k.RetiredAt)
+	}
+	return out
+}
+
+// ── Repository ACLs ──────────────────────────────────────────────────────
+
+// Canonical path of the image or artifact repo the caller administers,
+// visible repos the caller cannot manage are denied, the rest not found
+func (s *RepositoryService) permissionPath(ctx context.Context, user *auth.AuthenticatedUser, namespace, name string) (string, string, error) {
+	if namespace == "" || name == "" {
+		return "", "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("namespace and name are required"))
+	}
+	if portal.ForeignRef(ctx, namespace) {
+		return "", "", connect.NewError(connect.CodeNotFound, nil)
+	}
+	seen := false
+	repo, err := s.store.GetRepository(ctx, namespace, name)
+	if err != nil {
+		return "", "", connect.NewError(connect.CodeInternal, err)
+	}
+	if repo != nil && s.canReadRepo(ctx, repo) {
+		if s.canManageRepo(ctx, user, repo) {
+			return repo.Namespace, repo.Name, nil
+		}
+		seen = true
+	}
+	arepo, err := s.store.GetArtifactRepository(ctx, namespace, name)
+	if err != nil {
+		return "", "", connect.NewError(connect.CodeInternal, err)
+	}
+	if arepo != nil && s.artifacts.CanSee(ctx, user, arepo) {
+		if s.artifacts.HasRepoAccess(ctx, user, arepo, rbac.ActionManage) {
+			return arepo.Namespace, arepo.Name, nil
+		}
+		seen = true
+	}
+	if seen {
+		return "", "", connect.NewError(connect.CodePermissionDenied, nil)
+	}
+	return "", "", connect.NewError(connect.CodeNotFound, nil)
+}
+
+// User id or role name a subject names, exactly one is set
+func (s *RepositoryService) permissionSubject(ctx context.Context, subjectType, subject string) (string, string, error) {
+	if subject == "" {
+		return "", "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("subject is required"))
+	}
+	switch subjectType {
+	case "user":
+		u, err := s.store.GetUserByUsername(ctx, subject)
+		if err != nil {
+			return "", "", connect.NewError(connect.CodeInternal, err)
+		}
+		if u == nil {
+			return "", "", connect.NewError(connect.CodeNotFound, fmt.Errorf("user %q not found", subject))
+		}
+		return u.ID, "", nil
+	case "role":
+		r, err := s.store.GetRoleByName(ctx, subject)
+		if err != nil {
+			return "", "", connect.NewError(connect.CodeInternal, err)
+		}
+		if r == nil {
+			return "", "", connect.NewError(connect.CodeNotFound, fmt.Errorf("role %q not found", subject))
+		}
+		return "", r.Name, nil
+	}
+	return "", "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("subject type must be user or role, got %q", subjectType))
+}
+
+func (s *RepositoryService) ListRepositoryPermissions(ctx context.Context, req *connect.Request[v1.ListRepositoryPermissionsRequest]) (*connect.Response[v1.ListRepositoryPermissionsResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	namespace, name, err := s.permissionPath(ctx, user, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.store.ListRepositoryPermissions(ctx, namespace, name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.ListRepositoryPermissionsResponse{Permissions: make([]*v1.RepositoryPermission, 0, len(rows))}
+	for _, p := range rows {
+		resp.Permissions = append(resp.Permissions, s.permissionToProto(ctx, p))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (s *RepositoryService) SetRepositoryPermission(ctx context.Context, req *connect.Request[v1.SetRepositoryPermissionRequest]) (*connect.Response[v1.SetRepositoryPermissionResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	if !rbac.ValidLevel(req.Msg.Level) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("level must be read, write or admin, got %q", req.Msg.Level))
+	}
+	namespace, name, err := s.permissionPath(ctx, user, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	userID, roleName, err := s.permissionSubject(ctx, req.Msg.SubjectType, req.Msg.Subject)
+	if err != nil {
+		return nil, err
+	}
+	p := &storage.RepositoryPermission{
+		Namespace: namespace,
+		Name:      name,
+		UserID:    userID,
+		RoleName:  roleName,
+		Level:     req.Msg.Level,
+		GrantedBy: user.Username,
+	}
+	if err := s.store.SetRepositoryPermission(ctx, p); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	s.log.Info("%s granted %s %s %s on %s/%s", user.Username, req.Msg.SubjectType, req.Msg.Subject, p.Level, namespace, name)
+	return connect.NewResponse(&v1.SetRepositoryPermissionResponse{Permission: s.permissionToProto(ctx, p)}), nil
+}
+
+func (s *RepositoryService) RemoveRepositoryPermission(ctx context.Context, req *connect.Request[v1.RemoveRepositoryPermissionRequest]) (*connect.Response[v1.RemoveRepositoryPermissionResponse], error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	namespace, name, err := s.permissionPath(ctx, user, req.Msg.Namespace, req.Msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	userID, roleName, err := s.permissionSubject(ctx, req.Msg.SubjectType, req.Msg.Subject)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := s.store.DeleteRepositoryPermission(ctx, namespace, name, userID, roleName)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	if !removed {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("%s %q has no grant on %s/%s", req.Msg.SubjectType, req.Msg.Subject, namespace, name))
+	}
+	s.log.Info("%s revoked the grant of %s %s on %s/%s", user.Username, req.Msg.SubjectType, req.Msg.Subject, namespace, name)
+	return connect.NewResponse(&v1.RemoveRepositoryPermissionResponse{}), nil
+}
+
+// Users show by username, a deleted user's leftover row by id
+func (s *RepositoryService) permissionToProto(ctx context.Context, p *storage.RepositoryPermission) *v1.RepositoryPermission {
+	out := &v1.RepositoryPermission{
+		SubjectType: "role",
+		Subject:     p.RoleName,
+		Level:       p.Level,
+		GrantedBy:   p.GrantedBy,
+		CreatedAt:   timestamppb.New(p.CreatedAt),
+		UpdatedAt:   timestamppb.New(p.UpdatedAt),
+	}
+	if p.UserID != "" {
+		out.SubjectType, out.Subject = "user", p.UserID
+		if u, _ := s.store.GetUserByID(ctx, p.UserID); u != nil {
+			out.Subject = u.Username
+		}
+	}
+	return out
+}
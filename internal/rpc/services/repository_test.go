@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/journal"
+	"github.com/nickheyer/distroface/internal/policy"
+	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/registry"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+)
+
+type testEnv struct {
+	t        *testing.T
+	root     string // Registry storage
+	store    *stores.Store
+	enforcer *rbac.Enforcer
+	res      *settings.Resolver
+	registry *registry.RegistryAccess
+	repos    *RepositoryService
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	enforcer, err := rbac.NewEnforcer(store.DB())
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+	if err := enforcer.SeedDefaultPolicies(false); err != nil {
+		t.Fatalf("SeedDefaultPolicies: %v", err)
+	}
+	res := settings.NewResolver(store, nil)
+	root := t.TempDir()
+	reg, err := registry.NewRegistryAccess(root, nil)
+	if err != nil {
+		t.Fatalf("NewRegistryAccess: %v", err)
+	}
+	return &testEnv{
+		t:        t,
+		root:     root,
+		store:    store,
+		enforcer: enforcer,
+		res:      res,
+		registry: reg,
+		repos:    NewRepositoryService(store, res, reg, enforcer, nil, nil, logger.New()),
+	}
+}
+
+// Creates a user and returns a context signed in as them
+func (e *testEnv) user(name string, roles ...string) context.Context {
+	e.t.Helper()
+	u := &db.User{ID: uuid.New().String(), Username: name, AuthProvider: "local", IsActive: true}
+	if err := e.store.CreateUser(context.Background(), u); err != nil {
+		e.t.Fatalf("CreateUser: %v", err)
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	return auth.WithUser(context.Background(), &auth.AuthenticatedUser{ID: u.ID, Username: name, Roles: roles})
+}
+
+func (e *testEnv) repo(namespace, name string, private bool) *db.Repository {
+	e.t.Helper()
+	r := &db.Repository{ID: uuid.New().String(), Namespace: namespace, Name: name, IsPrivate: private}
+	if err := e.store.CreateRepository(context.Background(), r); err != nil {
+		e.t.Fatalf("CreateRepository: %v", err)
+	}
+	return r
+}
+
+// Grants a user an ACL level on a repository
+func (e *testEnv) grant(ctx context.Context, namespace, name, level string) {
+	e.t.Helper()
+	err := e.store.SetRepositoryPermission(context.Background(), &db.RepositoryPermission{
+		ID: uuid.New().String(), Namespace: namespace, Name: name, UserID: auth.UserFromContext(ctx).ID, Level: level,
+	})
+	if err != nil {
+		e.t.Fatalf("SetRepositoryPermission: %v", err)
+	}
+}
+
+// Links a blob into a repository's storage like a push does
+func (e *testEnv) link(repo, sub, hex string) {
+	e.t.Helper()
+	dir := filepath.Join(e.root, "docker", "registry", "v2", "repositories", filepath.FromSlash(repo), sub, "sha256", hex)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "link"), []byte("sha256:"+hex), 0644); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func connectCode(err error) connect.Code {
+	if err == nil {
+		return 0
+	}
+	return connect.CodeOf(err)
+}
+
+// Repos the caller cannot read are neither named nor counted, yet their
+// layers still count as shared so unique bytes stay what a delete frees
+func TestGetLayerSharingHidesUnreadableRepos(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.user("alice")
+	e.repo("alice", "app", false)
+	e.repo("alice", "tool", false)
+	e.repo("bob", "secret", true)
+
+	own, withTool, withSecret := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	e.link("alice/app", "_layers", own)
+	e.link("alice/app", "_layers", withTool)
+	e.link("alice/app", "_layers", withSecret)
+	e.link("alice/tool", "_layers", withTool)
+	e.link("bob/secret", "_layers", withSecret)
+
+	resp, err := e.repos.GetLayerSharing(alice, connect.NewRequest(&v1.GetLayerSharingRequest{Namespace: "alice", Name: "app"}))
+	if err != nil {
+		t.Fatalf("GetLayerSharing: %v", err)
+	}
+	layers := map[string]*v1.LayerSharing{}
+	for _, l := range resp.Msg.Layers {
+		layers[strings.TrimPrefix(l.Digest, "sha256:")] = l
+	}
+	if l := layers[own]; l == nil || l.Shared || l.SharedRepoCount != 0 {
+		t.Errorf("own layer = %v, want unshared", l)
+	}
+	if l := layers[withTool]; l == nil || !l.Shared || l.SharedRepoCount != 1 || len(l.SharedRepos) != 1 || l.SharedRepos[0] != "alice/tool" {
+		t.Errorf("layer shared with alice/tool = %v", l)
+	}
+	if l := layers[withSecret]; l == nil || !l.Shared || l.SharedRepoCount != 0 || len(l.SharedRepos) != 0 {
+		t.Errorf("layer shared with a private repo = %v, want shared without a name or count", l)
+	}
+}
+
+// Layer sharing walks storage, anonymous callers never get that far
+func TestGetLayerSharingRequiresAuth(t *testing.T) {
+	proc := distrofacev1connect.RepositoryServiceGetLayerSharingProcedure
+	if rbac.PublicProcedures[proc] {
+		t.Fatalf("%s is public", proc)
+	}
+	if !rbac.AuthenticatedOnlyProcedures[proc] {
+		t.Fatalf("%s is not authenticated only", proc)
+	}
+}
+
+// A read grant on a private repo lets a fork happen but never publishes it
+func TestForkOfPrivateRepoStaysPrivate(t *testing.T) {
+	e := newTestEnv(t)
+	bob := e.user("bob", "user")
+	carol := e.user("carol")
+	e.repo("bob", "secret", true)
+	e.link("bob/secret", "_manifests/revisions", strings.Repeat("d", 64))
+	e.grant(carol, "bob", "secret", "read")
+
+	fork := func(ctx context.Context, name string, vis v1.Visibility) (*v1.Repository, error) {
+		resp, err := e.repos.ForkRepository(ctx, connect.NewRequest(&v1.ForkRepositoryRequest{
+			SourceNamespace: "bob", SourceName: "secret", Name: name, Visibility: vis,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.Msg.Repository, nil
+	}
+
+	if _, err := fork(carol, "leak", v1.Visibility_VISIBILITY_PUBLIC); connectCode(err) != connect.CodePermissionDenied {
+		t.Fatalf("public fork by a read grantee: %v, want permission denied", err)
+	}
+	if r, _ := e.store.GetRepository(context.Background(), "carol", "leak"); r != nil {
+		t.Fatal("refused fork left a repository behind")
+	}
+	r, err := fork(carol, "copy", v1.Visibility_VISIBILITY_UNSPECIFIED)
+	if err != nil {
+		t.Fatalf("fork by a read grantee: %v", err)
+	}
+	if r.Visibility != v1.Visibility_VISIBILITY_PRIVATE {
+		t.Fatalf("fork visibility = %v, want private", r.Visibility)
+	}
+
+	// Nor can the fork be published afterwards
+	public := v1.Visibility_VISIBILITY_PUBLIC
+	_, err = e.repos.UpdateRepository(carol, connect.NewRequest(&v1.UpdateRepositoryRequest{Namespace: "carol", Name: "copy", Visibility: &public}))
+	if connectCode(err) != connect.CodePermissionDenied {
+		t.Fatalf("publishing the fork: %v, want permission denied", err)
+	}
+
+	// The owner decides what their content may become
+	if r, err := fork(bob, "open", v1.Visibility_VISIBILITY_PUBLIC); err != nil || r.Visibility != v1.Visibility_VISIBILITY_PUBLIC {
+		t.Fatalf("public fork by the owner = %v, %v", r, err)
+	}
+}
+
+// Untags are held by freeze windows like pushes and journaled until done
+func TestDeleteTagHonorsFreezes(t *testing.T) {
+	e := newTestEnv(t)
+	e.repos.SetFreezes(policy.NewFreezes(e.store, e.enforcer, logger.New()))
+	j, err := journal.Open(t.TempDir(), logger.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.repos.SetJournal(j)
+	alice, root := e.user("alice", "user"), e.user("root", "admin")
+	e.repo("alice", "app", false)
+	e.image("alice", "app", "1.0", []byte("one"))
+	e.image("alice", "app", "2.0", []byte("two"))
+	if err := e.store.CreateFreezeWindow(context.Background(), &db.FreezeWindow{
+		Pattern: "alice/*", Reason: "release", StartsAt: time.Now().Add(-time.Minute), CreatedBy: "root",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	untag := func(ctx context.Context, tag string) error {
+		_, err := e.repos.DeleteTag(ctx, connect.NewRequest(&v1.DeleteTagRequest{Namespace: "alice", Name: "app", Tag: tag, Reason: "cleanup"}))
+		return err
+	}
+	if err := untag(alice, "1.0"); connectCode(err) != connect.CodeFailedPrecondition || !strings.Contains(err.Error(), "release") {
+		t.Fatalf("untag during a freeze: %v, want failed precondition naming the freeze", err)
+	}
+	if _, err := e.registry.ResolveManifest(context.Background(), "alice", "app", "1.0"); err != nil {
+		t.Fatalf("refused untag removed the tag: %v", err)
+	}
+	// Admins hold the freeze override
+	if err := untag(root, "2.0"); err != nil {
+		t.Fatalf("untag through the freeze: %v", err)
+	}
+	if pending, err := j.Pending(); err != nil || len(pending) != 0 {
+		t.Fatalf("journal after untag = %v, %v, want empty", pending, err)
+	}
+}
@@ -33,7 +33,8 @@ func (s *RoleService) ListRoles(ctx context.Context, req *connect.Request[v1.Lis
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	roles, total, err := s.store.ListRoles(ctx, q, limit, offset)
+	orderBy := pages.OrderBy(req.Msg.Page, stores.RoleSortColumns, "is_system DESC, name ASC")
+	roles, total, err := s.store.ListRoles(ctx, q, orderBy, limit, offset)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -78,7 +79,7 @@ func (s *RoleService) CreateRole(ctx context.Context, req *connect.Request[v1.Cr
 
 	existing, _ := s.store.GetRoleByName(ctx, msg.Name)
 	if existing != nil {
-		return nil, connect.NewError(connect.CodeAlreadyExists, nil)
+		return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("role %q already exists", msg.Name))
 	}
 
 	role := &storage.Role{
@@ -88,6 +89,9 @@ func (s *RoleService) CreateRole(ctx context.Context, req *connect.Request[v1.Cr
 	}
 
 	if err := s.store.CreateRole(ctx, role); err != nil {
+		if stores.IsUniqueViolation(err) {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("role %q already exists", msg.Name))
+		}
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
@@ -200,7 +204,7 @@ func (s *RoleService) GetPermissionMatrix(ctx context.Context, req *connect.Requ
 	}
 
 	// Casbin subjects are role names, the wire keys by role id
-	allRoles, _, err := s.store.ListRoles(ctx, pages.Query{}, 0, 0)
+	allRoles, _, err := s.store.ListRoles(ctx, pages.Query{}, "", 0, 0)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -243,7 +247,7 @@ func (s *RoleService) ListScopeableObjects(ctx context.Context, req *connect.Req
 
 	switch req.Msg.Resource {
 	case rbac.ResourceRepositories:
-		repos, t, err := s.store.ListRepositories(ctx, "", query, "", true, nil, limit, offset)
+		repos, t, err := s.store.ListRepositories(ctx, stores.RepoFilter{}, query, "", "", true, nil, limit, offset)
 		if err != nil {
 			return nil, connect.NewError(connect.CodeInternal, err)
 		}
@@ -378,6 +382,36 @@ func (s *RoleService) GetUserRoles(ctx context.Context, req *connect.Request[v1.
 	}), nil
 }
 
+func (s *RoleService) ListRoleMembers(ctx context.Context, req *connect.Request[v1.ListRoleMembersRequest]) (*connect.Response[v1.ListRoleMembersResponse], error) {
+	role, err := s.requireRole(ctx, req.Msg.RoleId)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, offset := pages.Parse(req.Msg.Page)
+	q := pages.ParseQuery(req.Msg.Page)
+	if err := stores.UsersQuery.Validate(q); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	orderBy := pages.OrderBy(req.Msg.Page, stores.UserSortColumns, "username ASC")
+	users, total, err := s.store.ListRoleMembers(ctx, role.Name, q, orderBy, limit, offset)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	protoUsers := make([]*v1.User, len(users))
+	for i, u := range users {
+		roles, _ := s.store.GetUserRoles(ctx, u.ID)
+		protoUsers[i] = userToProto(u, roles)
+	}
+
+	return connect.NewResponse(&v1.ListRoleMembersResponse{
+		Users: protoUsers,
+		Page:  pages.Info(offset, limit, total),
+	}), nil
+}
+
 func roleToProto(r *storage.Role, perms []rbac.Permission) *v1.Role {
 	protoPerms := make([]*v1.Permission, len(perms))
 	for i, p := range perms {
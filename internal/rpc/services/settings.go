@@ -4,15 +4,20 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/mail"
 	"net/url"
 	"strconv"
 	"strings"
 
 	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/artifacts"
 	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/notify/channel"
+	"github.com/nickheyer/distroface/internal/portal"
 	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/scan"
 	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
@@ -42,18 +47,19 @@ func (s *SettingsService) isSystemAdmin(ctx context.Context) bool {
 	return allowed
 }
 
-// Write access, org and portal scopes need an org admin
+// Write access, org and portal scopes need an org admin, repo scopes
+// follow their namespace
 func (s *SettingsService) requireScopeAdmin(ctx context.Context, scope *v1.SettingsScope) error {
 	user := auth.UserFromContext(ctx)
 	if user == nil {
 		return connect.NewError(connect.CodeUnauthenticated, nil)
 	}
-	orgID, err := s.scopeOrgID(ctx, scope)
+	orgID, owner, err := s.scopeOwner(ctx, scope)
 	if err != nil {
 		return err
 	}
 	if orgID == "" {
-		if !s.isSystemAdmin(ctx) {
+		if owner != user.Username && !s.isSystemAdmin(ctx) {
 			return connect.NewError(connect.CodePermissionDenied, nil)
 		}
 		return nil
@@ -68,17 +74,24 @@ func (s *SettingsService) requireScopeAdmin(ctx context.Context, scope *v1.Setti
 	return nil
 }
 
-// Read access, org and portal scopes need membership
+// Read access, org, portal and org repo scopes need membership, user
+// namespace repo scopes need the owner
 func (s *SettingsService) requireScopeRead(ctx context.Context, scope *v1.SettingsScope) error {
 	user := auth.UserFromContext(ctx)
 	if user == nil {
 		return connect.NewError(connect.CodeUnauthenticated, nil)
 	}
-	orgID, err := s.scopeOrgID(ctx, scope)
+	orgID, owner, err := s.scopeOwner(ctx, scope)
 	if err != nil {
 		return err
 	}
-	if orgID == "" || s.isSystemAdmin(ctx) {
+	if s.isSystemAdmin(ctx) {
+		return nil
+	}
+	if orgID == "" {
+		if owner != "" && owner != user.Username {
+			return connect.NewError(connect.CodePermissionDenied, nil)
+		}
 		return nil
 	}
 	member, _ := s.store.GetOrgMember(ctx, orgID, user.ID)
@@ -88,30 +101,58 @@ func (s *SettingsService) requireScopeRead(ctx context.Context, scope *v1.Settin
 	return nil
 }
 
-// Empty for system scope, owning org otherwise
-func (s *SettingsService) scopeOrgID(ctx context.Context, scope *v1.SettingsScope) (string, error) {
+// Owning org for org, portal and org repo scopes. Repos in a user
+// namespace report the username instead, system scope reports neither
+func (s *SettingsService) scopeOwner(ctx context.Context, scope *v1.SettingsScope) (orgID, username string, err error) {
 	switch scope.GetType() {
 	case v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM:
-		return "", nil
+		return "", "", nil
 	case v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_ORG:
 		if scope.GetScopeId() == "" {
-			return "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("scope_id required"))
+			return "", "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("scope_id required"))
 		}
-		return scope.GetScopeId(), nil
+		return scope.GetScopeId(), "", nil
 	case v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_PORTAL:
 		if scope.GetScopeId() == "" {
-			return "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("scope_id required"))
+			return "", "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("scope_id required"))
 		}
 		orgID, err := s.store.GetPortalOrgID(ctx, scope.GetScopeId())
 		if err != nil {
-			return "", connect.NewError(connect.CodeNotFound, fmt.Errorf("portal not found"))
+			return "", "", connect.NewError(connect.CodeNotFound, fmt.Errorf("portal not found"))
+		}
+		return orgID, "", nil
+	case v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO:
+		namespace, err := settings.RepoNamespace(scope.GetScopeId())
+		if err != nil {
+			return "", "", connect.NewError(connect.CodeInvalidArgument, err)
+		}
+		if !s.repoExists(ctx, scope.GetScopeId()) {
+			return "", "", connect.NewError(connect.CodeNotFound, fmt.Errorf("repository not found"))
+		}
+		orgID, err := s.store.GetNamespaceOrgID(ctx, namespace)
+		if err != nil {
+			return "", "", connect.NewError(connect.CodeInternal, err)
+		}
+		if orgID != "" {
+			return orgID, "", nil
 		}
-		return orgID, nil
+		return "", namespace, nil
 	default:
-		return "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("scope type required"))
+		return "", "", connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("scope type required"))
 	}
 }
 
+// An image or artifact repo lives at exactly the namespace/name path
+func (s *SettingsService) repoExists(ctx context.Context, path string) bool {
+	namespace, name, _ := strings.Cut(path, "/")
+	if repo, err := s.store.GetRepository(ctx, namespace, name); err == nil && repo != nil {
+		return true
+	}
+	// Artifact lookups fold case, the tier is keyed by the stored spelling
+	repo, err := s.store.GetArtifactRepository(ctx, namespace, name)
+	return err == nil && repo != nil && repo.Namespace+"/"+repo.Name == path
+}
+
 // Fields anonymous callers may read from system effective settings
 func publicSubset(eff *v1.Settings) *v1.Settings {
 	return &v1.Settings{
@@ -126,6 +167,15 @@ func publicSubset(eff *v1.Settings) *v1.Settings {
 	}
 }
 
+// Branding of the portal the request arrived on, the instance's otherwise
+func (s *SettingsService) GetBranding(ctx context.Context, req *connect.Request[v1.GetBrandingRequest]) (*connect.Response[v1.GetBrandingResponse], error) {
+	eff := s.resolver.System(ctx)
+	if p := portal.FromContext(ctx); p != nil {
+		eff = s.resolver.Portal(ctx, p.ID)
+	}
+	return connect.NewResponse(&v1.GetBrandingResponse{Branding: eff.GetBranding()}), nil
+}
+
 func (s *SettingsService) GetSettings(ctx context.Context, req *connect.Request[v1.GetSettingsRequest]) (*connect.Response[v1.GetSettingsResponse], error) {
 	if err := s.requireScopeRead(ctx, req.Msg.GetScope()); err != nil {
 		return nil, err
@@ -234,6 +284,12 @@ func (s *SettingsService) checkDirectoryNotSelf(ctx context.Context, patch *v1.S
 
 // Cross field sanity on values present in a patch
 func validateSettingsPatch(patch *v1.Settings) error {
+	if ext := patch.GetServer().GetExternalUrl(); ext != "" {
+		u, err := url.Parse(ext)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("external url must be an absolute http(s) url without query")
+		}
+	}
 	if a := patch.GetAuth(); a != nil {
 		if a.SessionTimeoutSeconds != nil && *a.SessionTimeoutSeconds < 300 {
 			return fmt.Errorf("session timeout must be at least 300 seconds")
@@ -241,6 +297,9 @@ func validateSettingsPatch(patch *v1.Settings) error {
 		if a.TokenExpirySeconds != nil && *a.TokenExpirySeconds < 60 {
 			return fmt.Errorf("token expiry must be at least 60 seconds")
 		}
+		if err := auth.ValidatePasswordHashSettings(a.GetPasswordHash()); err != nil {
+			return err
+		}
 	}
 	if acme := patch.GetAcme(); acme != nil && acme.ChallengePort != nil && *acme.ChallengePort != "" {
 		port, err := strconv.Atoi(*acme.ChallengePort)
@@ -253,5 +312,203 @@ func validateSettingsPatch(patch *v1.Settings) error {
 			return fmt.Errorf("empty hostname blacklist pattern")
 		}
 	}
+	for _, name := range patch.GetNamespaces().GetReservedNames() {
+		if strings.TrimSpace(strings.TrimSuffix(name, "*")) == "" || strings.Contains(strings.TrimSuffix(name, "*"), "*") {
+			return fmt.Errorf("invalid reserved name %q, use an exact name or a prefix*", name)
+		}
+	}
+	if l := patch.GetLogging(); l != nil {
+		for _, level := range []*string{l.Level, l.Auth, l.Registry, l.Artifacts, l.Migration} {
+			if level == nil || *level == "" {
+				continue
+			}
+			if _, err := logger.ParseLevel(*level); err != nil {
+				return err
+			}
+		}
+	}
+	if d := patch.GetSecurity().GetDeletion(); d != nil {
+		if d.DualApprovalBytes != nil && *d.DualApprovalBytes < 0 {
+			return fmt.Errorf("dual approval size cannot be negative")
+		}
+		if d.ApprovalTtlMinutes != nil && *d.ApprovalTtlMinutes < 1 {
+			return fmt.Errorf("approval window must be at least one minute")
+		}
+	}
+	for _, rule := range patch.GetArtifacts().GetRetention().GetKeepRules() {
+		if strings.TrimSpace(rule.Key) == "" {
+			return fmt.Errorf("retention keep rule key is required")
+		}
+	}
+	for k := range patch.GetArtifacts().GetLatest().GetProperties() {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("latest property filter key is required")
+		}
+	}
+	if q := patch.GetArtifacts().GetQuota(); q.GetRepoMb() < 0 || q.GetOwnerMb() < 0 {
+		return fmt.Errorf("artifact quotas must not be negative")
+	}
+	if q := patch.GetArtifacts().GetQuery(); q != nil {
+		if err := artifacts.ValidateQueryDefaults(q); err != nil {
+			return err
+		}
+	}
+	if p := patch.GetPushPolicy(); p != nil {
+		if p.Url != nil && *p.Url != "" {
+			u, err := url.Parse(*p.Url)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("push policy url must be an absolute http(s) url")
+			}
+		}
+		if p.Format != nil && *p.Format != "webhook" && *p.Format != "opa" {
+			return fmt.Errorf("push policy format must be webhook or opa")
+		}
+		if p.TimeoutMs != nil && (*p.TimeoutMs < 100 || *p.TimeoutMs > 60000) {
+			return fmt.Errorf("push policy timeout must be between 100 and 60000 ms")
+		}
+	}
+	if n := patch.GetNotifications(); n != nil {
+		if n.SmtpAddr != nil && *n.SmtpAddr != "" {
+			if _, port, err := net.SplitHostPort(*n.SmtpAddr); err != nil || port == "" {
+				return fmt.Errorf("notification smtp address must be host:port")
+			}
+		}
+		if n.From != nil && *n.From != "" {
+			if _, err := mail.ParseAddress(*n.From); err != nil {
+				return fmt.Errorf("notification from address is invalid")
+			}
+		}
+		if n.RetentionDays != nil && (*n.RetentionDays < 1 || *n.RetentionDays > 3650) {
+			return fmt.Errorf("notification retention must be between 1 and 3650 days")
+		}
+		names := map[string]bool{}
+		for _, c := range n.GetChannels() {
+			if err := channel.Validate(c); err != nil {
+				return err
+			}
+			if names[c.GetName()] {
+				return fmt.Errorf("notification channel %q is listed twice", c.GetName())
+			}
+			names[c.GetName()] = true
+		}
+	}
+	if sc := patch.GetScan(); sc != nil {
+		if err := validateScanSettings(sc); err != nil {
+			return err
+		}
+	}
+	if l := patch.GetArtifacts().GetProperties(); l != nil {
+		if err := artifacts.ValidatePropertyLimits(l); err != nil {
+			return err
+		}
+	}
+	if a := patch.GetAlerts(); a != nil {
+		if err := validateAlertSettings(a); err != nil {
+			return err
+		}
+	}
+	if b := patch.GetBranding(); b != nil {
+		if err := validateBrandingSettings(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateBrandingSettings(b *v1.BrandingSettings) error {
+	if len(b.GetInstanceName()) > 64 {
+		return fmt.Errorf("instance name must be at most 64 characters")
+	}
+	if len(b.GetLoginBanner()) > 4096 {
+		return fmt.Errorf("login banner must be at most 4096 characters")
+	}
+	if len(b.GetSupportContact()) > 256 {
+		return fmt.Errorf("support contact must be at most 256 characters")
+	}
+	if logo := b.GetLogoUrl(); logo != "" {
+		u, err := url.Parse(logo)
+		absolute := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		local := err == nil && u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(logo, "//")
+		if !absolute && !local {
+			return fmt.Errorf("logo url must be an absolute http(s) url or a path starting with /")
+		}
+	}
+	return nil
+}
+
+func validateScanSettings(sc *v1.ScanSettings) error {
+	engine := sc.GetEngine()
+	if sc.Engine != nil && engine != scan.EngineClamAV && engine != scan.EngineICAP {
+		return fmt.Errorf("scan engine must be clamav or icap")
+	}
+	if sc.Address != nil && *sc.Address != "" {
+		if strings.HasPrefix(*sc.Address, "icap://") {
+			if engine == scan.EngineClamAV {
+				return fmt.Errorf("clamav scan address must be host:port or unix:/path")
+			}
+			if u, err := url.Parse(*sc.Address); err != nil || u.Host == "" {
+				return fmt.Errorf("icap scan address must look like icap://host[:port]/service")
+			}
+		} else if engine == scan.EngineICAP {
+			return fmt.Errorf("icap scan address must look like icap://host[:port]/service")
+		} else if !strings.HasPrefix(*sc.Address, "unix:") {
+			if _, port, err := net.SplitHostPort(*sc.Address); err != nil || port == "" {
+				return fmt.Errorf("clamav scan address must be host:port or unix:/path")
+			}
+		}
+	}
+	if sc.TimeoutMs != nil && (*sc.TimeoutMs < 1000 || *sc.TimeoutMs > 3600000) {
+		return fmt.Errorf("scan timeout must be between 1000 and 3600000 ms")
+	}
+	if sc.MaxSizeMb != nil && *sc.MaxSizeMb < 0 {
+		return fmt.Errorf("scan size limit cannot be negative")
+	}
+	return nil
+}
+
+func validateAlertSettings(a *v1.AlertSettings) error {
+	if a.IntervalSeconds != nil && (*a.IntervalSeconds < 10 || *a.IntervalSeconds > 86400) {
+		return fmt.Errorf("alert interval must be between 10 and 86400 seconds")
+	}
+	if a.StoragePercent != nil && (*a.StoragePercent < 0 || *a.StoragePercent > 100) {
+		return fmt.Errorf("storage alert threshold must be between 0 and 100 percent")
+	}
+	if a.UploadFailurePercent != nil && (*a.UploadFailurePercent < 0 || *a.UploadFailurePercent > 100) {
+		return fmt.Errorf("upload failure alert threshold must be between 0 and 100 percent")
+	}
+	if a.FailedLoginsPerMinute != nil && *a.FailedLoginsPerMinute < 0 {
+		return fmt.Errorf("failed login alert threshold cannot be negative")
+	}
+	if a.UploadMinSamples != nil && *a.UploadMinSamples < 0 {
+		return fmt.Errorf("upload sample minimum cannot be negative")
+	}
+	if a.EgressBytesPerSecond != nil && *a.EgressBytesPerSecond < 0 {
+		return fmt.Errorf("egress alert threshold cannot be negative")
+	}
+	if a.WebhookUrl != nil && *a.WebhookUrl != "" {
+		u, err := url.Parse(*a.WebhookUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("alert webhook url must be an absolute http(s) url")
+		}
+	}
+	e := a.GetEmail()
+	if e == nil {
+		return nil
+	}
+	if e.SmtpAddr != nil && *e.SmtpAddr != "" {
+		if _, port, err := net.SplitHostPort(*e.SmtpAddr); err != nil || port == "" {
+			return fmt.Errorf("alert smtp address must be host:port")
+		}
+	}
+	if e.From != nil && *e.From != "" {
+		if _, err := mail.ParseAddress(*e.From); err != nil {
+			return fmt.Errorf("invalid alert sender address %q", *e.From)
+		}
+	}
+	for _, to := range e.GetTo() {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid alert recipient %q", to)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/mirror"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Repository service whose copies go through a distribution app on the
+// test storage, wired like the container minus auth and middleware
+func (e *testEnv) copyingRepos() *RepositoryService {
+	e.t.Helper()
+	cfg := &configuration.Configuration{Storage: configuration.Storage{
+		"filesystem": configuration.Parameters{"rootdirectory": e.root},
+	}}
+	cfg.HTTP.Secret = "test"
+	cfg.Log.Level = "error"
+	cfg.Log.AccessLog.Disabled = true
+	app := handlers.NewApp(context.Background(), cfg)
+	tokens, err := auth.NewTokenService(filepath.Join(e.t.TempDir(), "tokens"), "distroface", []string{auth.RegistryService}, e.res)
+	if err != nil {
+		e.t.Fatalf("NewTokenService: %v", err)
+	}
+	mirrors := mirror.NewMonitor(e.store, e.res, nil, mirror.NewOCISyncer(app, tokens), nil, logger.New())
+	return NewRepositoryService(e.store, e.res, e.registry, e.enforcer, mirrors, nil, logger.New())
+}
+
+// Copies land in the target repo and record the source, the first origin
+// along a chain and the pipeline run
+func TestCopyTagRecordsProvenance(t *testing.T) {
+	e := newTestEnv(t)
+	repos := e.copyingRepos()
+	alice := e.user("alice", "user")
+	e.org("acme")
+	e.repo("acme", "app", false)
+	e.repo("alice", "app", false)
+	e.image("acme", "app", "1.0", []byte("release layer"))
+	src, err := e.registry.ResolveManifest(context.Background(), "acme", "app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copyTag := func(ctx context.Context, msg *v1.CopyTagRequest, pipeline string) (*v1.TagProvenance, error) {
+		req := connect.NewRequest(msg)
+		if pipeline != "" {
+			req.Header().Set(pipelineIDHeader, pipeline)
+		}
+		resp, err := repos.CopyTag(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Msg.Provenance, nil
+	}
+
+	prov, err := copyTag(alice, &v1.CopyTagRequest{SourceNamespace: "acme", SourceName: "app", SourceTag: "1.0", Namespace: "alice", Tag: "stable"}, "run-42")
+	if err != nil {
+		t.Fatalf("CopyTag: %v", err)
+	}
+	if prov.Namespace != "alice" || prov.Name != "app" || prov.Tag != "stable" || prov.Digest != src.Digest.String() ||
+		prov.SourceRepo != "acme/app" || prov.SourceTag != "1.0" || prov.OriginRepo != "acme/app" ||
+		prov.CopiedBy != "alice" || prov.PipelineId != "run-42" {
+		t.Fatalf("provenance = %+v", prov)
+	}
+	if got, err := e.registry.ResolveManifest(context.Background(), "alice", "app", "stable"); err != nil || got.Digest != src.Digest {
+		t.Fatalf("copied tag = %v, %v, want %s", got.Digest, err, src.Digest)
+	}
+
+	// A copy of a copy still leads back to the first source
+	prov, err = copyTag(alice, &v1.CopyTagRequest{SourceNamespace: "alice", SourceName: "app", SourceTag: "stable", Tag: "prod"}, "")
+	if err != nil {
+		t.Fatalf("chained CopyTag: %v", err)
+	}
+	if prov.SourceRepo != "alice/app" || prov.SourceTag != "stable" || prov.OriginRepo != "acme/app" || prov.OriginTag != "1.0" || prov.PipelineId != "" {
+		t.Fatalf("chained provenance = %+v", prov)
+	}
+
+	lookup := func() *v1.TagProvenance {
+		t.Helper()
+		resp, err := repos.GetTagProvenance(alice, connect.NewRequest(&v1.GetTagProvenanceRequest{Namespace: "alice", Name: "app", Tag: "prod"}))
+		if err != nil {
+			t.Fatalf("GetTagProvenance: %v", err)
+		}
+		return resp.Msg.Provenance
+	}
+	if got := lookup(); got == nil || got.OriginRepo != "acme/app" {
+		t.Fatalf("stored provenance = %+v", got)
+	}
+	// Pushed over since, the record no longer describes the tag
+	e.image("alice", "app", "prod", []byte("hotfix layer"))
+	if got := lookup(); got != nil {
+		t.Fatalf("provenance after a push over the tag = %+v", got)
+	}
+}
+
+func TestCopyTagChecksAccess(t *testing.T) {
+	e := newTestEnv(t)
+	repos := e.copyingRepos()
+	// Roleless, so only ownership and grants open repos to them
+	alice, bob := e.user("alice"), e.user("bob")
+	e.org("acme")
+	e.repo("acme", "app", false)
+	e.repo("acme", "secret", true)
+	e.image("acme", "app", "1.0", []byte("public layer"))
+	e.image("acme", "secret", "1.0", []byte("private layer"))
+
+	for _, c := range []struct {
+		name string
+		msg  *v1.CopyTagRequest
+		want connect.Code
+	}{
+		{"unreadable source", &v1.CopyTagRequest{SourceNamespace: "acme", SourceName: "secret", SourceTag: "1.0", Namespace: "alice"}, connect.CodeNotFound},
+		{"missing tag", &v1.CopyTagRequest{SourceNamespace: "acme", SourceName: "app", SourceTag: "2.0", Namespace: "alice"}, connect.CodeNotFound},
+		{"no push on target", &v1.CopyTagRequest{SourceNamespace: "acme", SourceName: "app", SourceTag: "1.0", Tag: "copy"}, connect.CodePermissionDenied},
+		{"same tag", &v1.CopyTagRequest{SourceNamespace: "acme", SourceName: "app", SourceTag: "1.0"}, connect.CodeInvalidArgument},
+		{"bad tag", &v1.CopyTagRequest{SourceNamespace: "acme", SourceName: "app", SourceTag: "1.0", Namespace: "alice", Tag: "-bad"}, connect.CodeInvalidArgument},
+	} {
+		if _, err := repos.CopyTag(alice, connect.NewRequest(c.msg)); connectCode(err) != c.want {
+			t.Errorf("%s: %v, want %v", c.name, err, c.want)
+		}
+	}
+	if _, err := repos.CopyTag(bob, connect.NewRequest(&v1.CopyTagRequest{SourceNamespace: "acme", SourceName: "app", SourceTag: "1.0", Namespace: "alice"})); connectCode(err) != connect.CodePermissionDenied {
+		t.Fatalf("copy into someone else's namespace: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"connectrpc.com/connect"
 	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/rbac"
 	"github.com/nickheyer/distroface/pkg/logger"
@@ -36,24 +37,18 @@ func (s *TokenService) CreateAPIToken(ctx context.Context, req *connect.Request[
 		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
 	}
 
-	plaintext, token, err := s.authManager.GenerateAPIToken(ctx, user.ID, req.Msg.Name, req.Msg.ExpiresInDays)
-	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+	if _, err := auth.NormalizeScopes(req.Msg.Scopes); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	protoToken := &v1.APIToken{
-		Id:        token.ID,
-		Name:      token.Name,
-		CreatedBy: token.UserID,
-		CreatedAt: timestamppb.New(token.CreatedAt),
-	}
-	if token.ExpiresAt != nil {
-		protoToken.ExpiresAt = timestamppb.New(*token.ExpiresAt)
+	plaintext, token, err := s.authManager.GenerateAPIToken(ctx, user.ID, req.Msg.Name, req.Msg.ExpiresInDays, req.Msg.Scopes)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
 	return connect.NewResponse(&v1.CreateAPITokenResponse{
 		PlaintextToken: plaintext,
-		Token:          protoToken,
+		Token:          apiTokenToProto(token),
 	}), nil
 }
 
@@ -84,18 +79,8 @@ func (s *TokenService) ListAPITokens(ctx context.Context, req *connect.Request[v
 
 	protoTokens := make([]*v1.APIToken, len(tokens))
 	for i, t := range tokens {
-		protoTokens[i] = &v1.APIToken{
-			Id:        t.ID,
-			Name:      t.Name,
-			CreatedBy: t.UserID,
-			CreatedAt: timestamppb.New(t.CreatedAt),
-		}
-		if t.ExpiresAt != nil {
-			protoTokens[i].ExpiresAt = timestamppb.New(*t.ExpiresAt)
-		}
-		if t.LastUsedAt != nil {
-			protoTokens[i].LastUsedAt = timestamppb.New(*t.LastUsedAt)
-		}
+		protoTokens[i] = apiTokenToProto(t)
+		protoTokens[i].Current = t.ID == user.TokenID
 	}
 
 	return connect.NewResponse(&v1.ListAPITokensResponse{
@@ -127,3 +112,20 @@ func (s *TokenService) DeleteAPIToken(ctx context.Context, req *connect.Request[
 
 	return connect.NewResponse(&v1.DeleteAPITokenResponse{}), nil
 }
+
+func apiTokenToProto(t *db.APIToken) *v1.APIToken {
+	out := &v1.APIToken{
+		Id:        t.ID,
+		Name:      t.Name,
+		CreatedBy: t.UserID,
+		CreatedAt: timestamppb.New(t.CreatedAt),
+		Scopes:    auth.SplitScopes(t.Scopes),
+	}
+	if t.ExpiresAt != nil {
+		out.ExpiresAt = timestamppb.New(*t.ExpiresAt)
+	}
+	if t.LastUsedAt != nil {
+		out.LastUsedAt = timestamppb.New(*t.LastUsedAt)
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
+
+	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Usage counts what the caller owns and reads the limiters without
+// spending from them
+func TestGetMyUsage(t *testing.T) {
+	e := newTestEnv(t)
+	pulls, failures := admin.NewLimiter(10, time.Minute), admin.NewLimiter(5, time.Minute)
+	users := NewUserService(e.store, nil, e.enforcer, logger.New())
+	users.SetUsageSources(e.root, e.res, pulls, failures)
+
+	alice := e.user("alice")
+	aliceID := auth.UserFromContext(alice).ID
+	for _, r := range []*db.Repository{
+		{Namespace: "alice", Name: "app", OwnerID: aliceID},
+		{Namespace: "alice", Name: "secret", OwnerID: aliceID, IsPrivate: true},
+	} {
+		r.ID = uuid.New().String()
+		if err := e.store.CreateRepository(context.Background(), r); err != nil {
+			t.Fatalf("CreateRepository: %v", err)
+		}
+	}
+	e.image("alice", "app", "1.0", []byte("alice layer"))
+	for range 3 {
+		pulls.Take("user:alice")
+	}
+	// Unit requests carry no peer, so the handler keys on the empty address
+	failures.Record(admin.ClientIP("", nil))
+
+	usage := func() *v1.GetMyUsageResponse {
+		t.Helper()
+		resp, err := users.GetMyUsage(alice, connect.NewRequest(&v1.GetMyUsageRequest{}))
+		if err != nil {
+			t.Fatalf("GetMyUsage: %v", err)
+		}
+		return resp.Msg
+	}
+	got := usage()
+	if got.Username != "alice" || got.Repositories != 2 || got.PrivateRepositories != 1 || got.RegistryBytes <= 0 {
+		t.Fatalf("usage = %+v", got)
+	}
+	limits := map[string]*v1.UsageLimit{}
+	for _, l := range got.Limits {
+		limits[l.Name] = l
+	}
+	if l := limits["pull_rate"]; l == nil || l.Limit != 10 || l.Used != 3 || l.Remaining != 7 || l.ResetAt == nil {
+		t.Errorf("pull_rate = %+v", l)
+	}
+	if l := limits["auth_failures"]; l == nil || l.Limit != 5 || l.Used != 1 || l.Remaining != 4 {
+		t.Errorf("auth_failures = %+v", l)
+	}
+	if limits["artifact_max_file_size"] == nil || limits["artifact_storage"] == nil {
+		t.Errorf("settings limits missing from %v", got.Limits)
+	}
+
+	// Another namespace's images are not the caller's bytes, and reading
+	// usage twice spends nothing
+	e.image("acme", "app", "1.0", []byte("someone else's layer"))
+	again := usage()
+	if again.RegistryBytes != got.RegistryBytes {
+		t.Errorf("registry bytes moved from %d to %d", got.RegistryBytes, again.RegistryBytes)
+	}
+	if used, _ := pulls.Peek("user:alice"); used != 3 {
+		t.Errorf("pulls counted after reading usage = %d, want 3", used)
+	}
+
+	if _, err := users.GetMyUsage(context.Background(), connect.NewRequest(&v1.GetMyUsageRequest{})); connectCode(err) != connect.CodeUnauthenticated {
+		t.Fatalf("anonymous usage: %v", err)
+	}
+}
@@ -3,31 +3,45 @@ package services
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"connectrpc.com/connect"
+	"github.com/nickheyer/distroface/internal/admin"
+	"github.com/nickheyer/distroface/internal/artifacts"
 	"github.com/nickheyer/distroface/internal/auth"
 	storage "github.com/nickheyer/distroface/internal/db"
 	"github.com/nickheyer/distroface/internal/db/stores"
 	"github.com/nickheyer/distroface/internal/rbac"
+	"github.com/nickheyer/distroface/internal/settings"
 	"github.com/nickheyer/distroface/pkg/logger"
 	"github.com/nickheyer/distroface/pkg/pages"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var _ distrofacev1connect.UserServiceHandler = (*UserService)(nil)
 
 type UserService struct {
-	store       *stores.Store
-	authManager *auth.Manager
-	enforcer    *rbac.Enforcer
-	log         *logger.Logger
+	store        *stores.Store
+	authManager  *auth.Manager
+	enforcer     *rbac.Enforcer
+	log          *logger.Logger
+	registryPath string             // Empty leaves registry bytes out of usage
+	res          *settings.Resolver // Nil leaves settings limits out of usage
+	pullLimiter  *admin.Limiter
+	authLimiter  *admin.Limiter
 }
 
 func NewUserService(store *stores.Store, manager *auth.Manager, enforcer *rbac.Enforcer, log *logger.Logger) *UserService {
 	return &UserService{store: store, authManager: manager, enforcer: enforcer, log: log}
 }
 
+// Where GetMyUsage reads storage and limits, nil limiters are left out
+func (s *UserService) SetUsageSources(registryPath string, res *settings.Resolver, pulls, authFailures *admin.Limiter) {
+	s.registryPath, s.res, s.pullLimiter, s.authLimiter = registryPath, res, pulls, authFailures
+}
+
 func (s *UserService) GetUser(ctx context.Context, req *connect.Request[v1.GetUserRequest]) (*connect.Response[v1.GetUserResponse], error) {
 	if req.Msg.Username == "" {
 		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
@@ -92,6 +106,22 @@ func (s *UserService) UpdateUser(ctx context.Context, req *connect.Request[v1.Up
 		}
 		user.Email = req.Msg.Email
 	}
+	if req.Msg.DefaultNamespace != nil {
+		namespace := strings.TrimSpace(*req.Msg.DefaultNamespace)
+		if namespace == user.Username {
+			namespace = ""
+		}
+		if namespace != "" {
+			isMember, _, err := s.store.IsOrgMember(ctx, namespace, user.ID)
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInternal, err)
+			}
+			if !isMember {
+				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("default namespace must be your own or an organization you belong to"))
+			}
+		}
+		user.DefaultNamespace = namespace
+	}
 
 	if err := s.store.UpdateUser(ctx, user); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
@@ -132,7 +162,8 @@ func (s *UserService) ListUsers(ctx context.Context, req *connect.Request[v1.Lis
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	users, total, err := s.store.ListUsers(ctx, q, limit, offset)
+	orderBy := pages.OrderBy(req.Msg.Page, stores.UserSortColumns, "created_at DESC")
+	users, total, err := s.store.ListUsers(ctx, q, orderBy, limit, offset)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -177,6 +208,16 @@ func (s *UserService) AdminUpdateUser(ctx context.Context, req *connect.Request[
 	}
 	if msg.IsActive != nil {
 		user.IsActive = *msg.IsActive
+		if user.IsActive {
+			user.PendingApproval = false
+		}
+	}
+	if msg.ArtifactQuotaMb != nil {
+		if *msg.ArtifactQuotaMb < 0 {
+			user.ArtifactQuotaMB = nil
+		} else {
+			user.ArtifactQuotaMB = msg.ArtifactQuotaMb
+		}
 	}
 
 	// Validate the requested role set before mutating anything
@@ -271,6 +312,11 @@ func (s *UserService) AdminCreateUser(ctx context.Context, req *connect.Request[
 	if len(msg.Password) < 8 {
 		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("password must be at least 8 characters"))
 	}
+	if !msg.AllowReserved {
+		if err := checkUsernameClaimable(ctx, s.store, s.authManager.Settings(), msg.Username); err != nil {
+			return nil, err
+		}
+	}
 
 	existing, err := s.store.GetUserByUsername(ctx, msg.Username)
 	if err != nil {
@@ -414,3 +460,78 @@ func (s *UserService) AdminBulkDeleteUsers(ctx context.Context, req *connect.Req
 	resp.DeletedCount = int32(len(targets))
 	return connect.NewResponse(resp), nil
 }
+
+func (s *UserService) GetMyUsage(ctx context.Context, req *connect.Request[v1.GetMyUsageRequest]) (*connect.Response[v1.GetMyUsageResponse], error) {
+	caller := auth.UserFromContext(ctx)
+	if auth.IsAnonymous(caller) {
+		return nil, connect.NewError(connect.CodeUnauthenticated, fmt.Errorf("usage is reported for signed in users"))
+	}
+
+	owned, err := s.store.CountOwned(ctx, caller.ID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	resp := &v1.GetMyUsageResponse{
+		Username:             caller.Username,
+		Repositories:         int32(owned.Repositories),
+		PrivateRepositories:  int32(owned.PrivateRepositories),
+		ArtifactBytes:        owned.ArtifactBytes,
+		ArtifactRepositories: int32(owned.ArtifactRepositories),
+		Artifacts:            owned.Artifacts,
+	}
+	if s.registryPath != "" {
+		// Registry namespaces are lowercase, a user's is their name
+		resp.RegistryBytes, err = namespaceUsage(ctx, s.registryPath, s.store, strings.ToLower(caller.Username))
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("scanning registry storage: %w", err))
+		}
+	}
+
+	// Keyed the way the limiters count, pulls by registry token subject
+	if s.pullLimiter != nil {
+		resp.Limits = append(resp.Limits, limiterUsage("pull_rate", s.pullLimiter, "user:"+caller.Username, "Manifest pulls per minute"))
+	}
+	if s.authLimiter != nil {
+		clientIP := admin.ClientIP(req.Peer().Addr, req.Header())
+		resp.Limits = append(resp.Limits, limiterUsage("auth_failures", s.authLimiter, clientIP, "Failed sign ins from your address before a lockout"))
+	}
+	if s.res != nil {
+		sys := s.res.System(ctx)
+		resp.Limits = append(resp.Limits, &v1.UsageLimit{
+			Name:   "artifact_max_file_size",
+			Limit:  sys.GetArtifacts().GetMaxFileSizeMb() << 20,
+			Detail: "Largest artifact upload in bytes, orgs and repos may set their own",
+		})
+		u, err := s.store.GetUserByID(ctx, caller.ID)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		quota := &v1.UsageLimit{
+			Name:   "artifact_storage",
+			Limit:  artifacts.OwnerQuotaBytes(u, sys.GetArtifacts()),
+			Used:   owned.ArtifactBytes,
+			Detail: "Artifact bytes across the repos you own, repos may carry their own quota",
+		}
+		if quota.Limit > 0 {
+			quota.Remaining = max(quota.Limit-quota.Used, 0)
+		}
+		resp.Limits = append(resp.Limits, quota)
+		resp.PushPolicy = sys.GetPushPolicy().GetEnabled()
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// Window state of one limiter key without counting an event
+func limiterUsage(name string, l *admin.Limiter, key, detail string) *v1.UsageLimit {
+	u := &v1.UsageLimit{Name: name, Limit: int64(l.Limit()), Detail: detail}
+	if u.Limit <= 0 {
+		u.Limit = 0
+		return u
+	}
+	used, resetAt := l.Peek(key)
+	u.Used, u.Remaining = int64(used), max(u.Limit-int64(used), 0)
+	if !resetAt.IsZero() {
+		u.ResetAt = timestamppb.New(resetAt)
+	}
+	return u
+}
@@ -488,6 +488,8 @@ func eventsToStrings(events []v1.WebhookEvent) []string {
 			result = append(result, "pull")
 		case v1.WebhookEvent_WEBHOOK_EVENT_DELETE:
 			result = append(result, "delete")
+		case v1.WebhookEvent_WEBHOOK_EVENT_COMMENT:
+			result = append(result, "comment")
 		}
 	}
 	return result
@@ -509,6 +511,8 @@ func stringToEvent(s string) v1.WebhookEvent {
 		return v1.WebhookEvent_WEBHOOK_EVENT_PULL
 	case "delete":
 		return v1.WebhookEvent_WEBHOOK_EVENT_DELETE
+	case "comment":
+		return v1.WebhookEvent_WEBHOOK_EVENT_COMMENT
 	default:
 		return v1.WebhookEvent_WEBHOOK_EVENT_UNSPECIFIED
 	}
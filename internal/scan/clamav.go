@@ -0,0 +1,73 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Streams r to clamd with INSTREAM and returns the signature it found,
+// empty when clean. Addresses are host:port or unix:/path/to/clamd.sock
+func clamdScan(ctx context.Context, addr string, r io.Reader) (string, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return "", fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", fmt.Errorf("sending to clamd: %w", err)
+	}
+	buf := make([]byte, chunkSize)
+	var size [4]byte
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			w.Write(size[:])
+			if _, err := w.Write(buf[:n]); err != nil {
+				return "", fmt.Errorf("sending to clamd: %w", err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", fmt.Errorf("reading content: %w", rerr)
+		}
+	}
+	// A zero length chunk ends the stream
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("sending to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return clamdVerdict(strings.TrimRight(reply, "\x00\n"))
+}
+
+// Replies look like "stream: OK", "stream: Eicar-Test-Signature FOUND"
+// or "INSTREAM size limit exceeded. ERROR"
+func clamdVerdict(reply string) (string, error) {
+	_, result, _ := strings.Cut(reply, ": ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
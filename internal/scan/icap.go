@@ -0,0 +1,124 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const icapDefaultPort = "1344"
+
+// Sends r as the body of an ICAP RESPMOD and returns the threat the
+// service reported, empty when it answered 204 or passed the content on
+func icapScan(ctx context.Context, addr string, r io.Reader, size int64) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "icap" || u.Host == "" {
+		return "", fmt.Errorf("icap address must look like icap://host[:port]/service")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), icapDefaultPort)
+	}
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil {
+		return "", fmt.Errorf("connecting to icap service: %w", err)
+	}
+	defer conn.Close()
+
+	reqHdr := "GET /artifact HTTP/1.1\r\nHost: distroface\r\n\r\n"
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: " + strconv.FormatInt(size, 10) + "\r\n\r\n"
+
+	w := bufio.NewWriterSize(conn, chunkSize+16)
+	fmt.Fprintf(w, "RESPMOD icap://%s%s ICAP/1.0\r\n", host, u.EscapedPath())
+	fmt.Fprintf(w, "Host: %s\r\n", host)
+	w.WriteString("Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHdr), len(reqHdr)+len(resHdr))
+	w.WriteString(reqHdr)
+	w.WriteString(resHdr)
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			if _, err := w.WriteString("\r\n"); err != nil {
+				return "", fmt.Errorf("sending to icap service: %w", err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", fmt.Errorf("reading content: %w", rerr)
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("sending to icap service: %w", err)
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	line, err := tp.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("reading icap reply: %w", err)
+	}
+	proto, rest, _ := strings.Cut(line, " ")
+	codeText, _, _ := strings.Cut(rest, " ")
+	code, err := strconv.Atoi(codeText)
+	if !strings.HasPrefix(proto, "ICAP/") || err != nil {
+		return "", fmt.Errorf("malformed icap reply %q", line)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return "", fmt.Errorf("reading icap reply: %w", err)
+	}
+
+	switch {
+	case code == 204:
+		return "", nil
+	case code != 200:
+		return "", fmt.Errorf("icap service returned %s", rest)
+	}
+	if threat := icapThreat(header); threat != "" {
+		return threat, nil
+	}
+	// A 200 without infection headers either echoes the content or swaps
+	// in a block page, the encapsulated status tells them apart
+	if strings.Contains(header.Get("Encapsulated"), "res-hdr") {
+		status, err := tp.ReadLine()
+		if err != nil {
+			return "", fmt.Errorf("reading icap reply: %w", err)
+		}
+		_, rest, _ := strings.Cut(status, " ")
+		if !strings.HasPrefix(rest, "2") {
+			return "blocked by icap service", nil
+		}
+	}
+	return "", nil
+}
+
+// Threat name from the headers common icap servers set on a detection
+func icapThreat(h textproto.MIMEHeader) string {
+	if found := h.Get("X-Infection-Found"); found != "" {
+		for _, part := range strings.Split(found, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(part), "Threat="); ok && v != "" {
+				return v
+			}
+		}
+		return "infection found"
+	}
+	if id := strings.TrimSpace(h.Get("X-Virus-ID")); id != "" {
+		return id
+	}
+	if v := strings.TrimSpace(h.Get("X-Violations-Found")); v != "" {
+		return "violations found"
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+// Malware scanning of uploaded content through clamd or an ICAP service
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+// Recorded scan states, empty means the upload predates scanning
+const (
+	StatusPending  = "pending"
+	StatusClean    = "clean"
+	StatusInfected = "infected"
+	StatusSkipped  = "skipped"
+	StatusError    = "error"
+)
+
+const (
+	EngineClamAV = "clamav"
+	EngineICAP   = "icap"
+
+	// Scans running at once, the rest wait for a slot
+	maxConcurrent = 4
+	chunkSize     = 64 * 1024
+)
+
+// Outcome of one scan, Result holds the signature of infected content
+// and the reason a scan was skipped or failed
+type Verdict struct {
+	Status string
+	Engine string
+	Result string
+}
+
+// Streams content to the configured engine
+type Scanner struct {
+	res   *settings.Resolver
+	log   *logger.Logger
+	slots chan struct{}
+}
+
+func NewScanner(res *settings.Resolver, log *logger.Logger) *Scanner {
+	return &Scanner{res: res, log: log, slots: make(chan struct{}, maxConcurrent)}
+}
+
+// Whether new uploads get scanned
+func (s *Scanner) Enabled(ctx context.Context) bool {
+	if s == nil {
+		return false
+	}
+	cfg := s.res.System(ctx).GetScan()
+	return cfg.GetEnabled() && cfg.GetAddress() != ""
+}
+
+// Whether downloads of content in this state are refused. Infected
+// content stays quarantined even after scanning is turned off
+func (s *Scanner) Blocks(ctx context.Context, status string) bool {
+	if status == StatusInfected {
+		return true
+	}
+	if s == nil || (status != StatusPending && status != StatusSkipped && status != StatusError) {
+		return false
+	}
+	cfg := s.res.System(ctx).GetScan()
+	return cfg.GetEnabled() && cfg.GetBlockUnscanned()
+}
+
+// Scans size bytes of r. Engine failures come back as an error verdict so
+// the caller records them like any other outcome
+func (s *Scanner) Scan(ctx context.Context, r io.Reader, size int64) Verdict {
+	cfg := s.res.System(ctx).GetScan()
+	engine := strings.ToLower(cfg.GetEngine())
+	if engine == "" {
+		engine = EngineClamAV
+	}
+	if limit := cfg.GetMaxSizeMb() * 1024 * 1024; limit > 0 && size > limit {
+		return Verdict{Status: StatusSkipped, Engine: engine, Result: fmt.Sprintf("larger than the %dMB scan limit", cfg.GetMaxSizeMb())}
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		return Verdict{Status: StatusError, Engine: engine, Result: ctx.Err().Error()}
+	}
+
+	timeout := time.Duration(cfg.GetTimeoutMs()) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		signature string
+		err       error
+	)
+	switch engine {
+	case EngineClamAV:
+		signature, err = clamdScan(ctx, cfg.GetAddress(), r)
+	case EngineICAP:
+		signature, err = icapScan(ctx, cfg.GetAddress(), r, size)
+	default:
+		err = fmt.Errorf("unknown scan engine %q", engine)
+	}
+	if err != nil {
+		return Verdict{Status: StatusError, Engine: engine, Result: err.Error()}
+	}
+	if signature != "" {
+		return Verdict{Status: StatusInfected, Engine: engine, Result: signature}
+	}
+	return Verdict{Status: StatusClean, Engine: engine}
+}
+
+// Dials with the scan deadline applied to every read and write
+func dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Cancellation mid stream unblocks the pending read or write
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	return &ctxConn{Conn: conn, stop: stop}, nil
+}
+
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
@@ -0,0 +1,190 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+func newTestScanner(t *testing.T, cfg *v1.ScanSettings) *Scanner {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	res := settings.NewResolver(store, nil)
+	if err := res.SeedSystem(context.Background(), &v1.Settings{Scan: cfg}); err != nil {
+		t.Fatalf("SeedSystem: %v", err)
+	}
+	return NewScanner(res, logger.NewWithConfig(&logger.Config{Enabled: false}))
+}
+
+// Accepts connections until the test ends, one handler call each
+func serve(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// Speaks enough INSTREAM to flag the EICAR string
+func fakeClamd(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+		return
+	}
+	var body bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&body, r, int64(size)); err != nil {
+			return
+		}
+	}
+	if strings.Contains(body.String(), "EICAR") {
+		conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		return
+	}
+	conn.Write([]byte("stream: OK\x00"))
+}
+
+// Reads a RESPMOD and answers 204, or 200 with an infection header
+func fakeICAP(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	// ICAP headers, then the encapsulated request and response headers
+	for blocks := 0; blocks < 3; {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line == "\r\n" {
+			blocks++
+		}
+	}
+	body, err := io.ReadAll(httputil.NewChunkedReader(r))
+	if err != nil {
+		return
+	}
+	if bytes.Contains(body, []byte("EICAR")) {
+		fmt.Fprint(conn, "ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR_Test_File;\r\nEncapsulated: res-hdr=0, res-body=30\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n")
+		return
+	}
+	fmt.Fprint(conn, "ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n")
+}
+
+func scanString(s *Scanner, content string) Verdict {
+	return s.Scan(context.Background(), strings.NewReader(content), int64(len(content)))
+}
+
+func TestScanEngines(t *testing.T) {
+	clamd := serve(t, fakeClamd)
+	icap := serve(t, fakeICAP)
+	// Bigger than one chunk so the framing is exercised
+	clean := strings.Repeat("harmless ", 20000)
+
+	for _, tc := range []struct {
+		engine, addr, signature string
+	}{
+		{EngineClamAV, clamd, "Eicar-Test-Signature"},
+		{EngineICAP, "icap://" + icap + "/avscan", "EICAR_Test_File"},
+	} {
+		s := newTestScanner(t, &v1.ScanSettings{Enabled: proto.Bool(true), Engine: proto.String(tc.engine), Address: proto.String(tc.addr)})
+		if v := scanString(s, clean); v.Status != StatusClean || v.Engine != tc.engine {
+			t.Errorf("%s clean content = %+v", tc.engine, v)
+		}
+		if v := scanString(s, clean+eicar); v.Status != StatusInfected || v.Result != tc.signature {
+			t.Errorf("%s infected content = %+v", tc.engine, v)
+		}
+	}
+}
+
+func TestScanFailures(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+
+	s := newTestScanner(t, &v1.ScanSettings{Enabled: proto.Bool(true), Address: proto.String(dead)})
+	if v := scanString(s, "x"); v.Status != StatusError || v.Result == "" {
+		t.Errorf("unreachable clamd = %+v", v)
+	}
+
+	s = newTestScanner(t, &v1.ScanSettings{Enabled: proto.Bool(true), Address: proto.String(dead), MaxSizeMb: proto.Int64(1)})
+	big := bytes.NewReader(make([]byte, 2<<20))
+	if v := s.Scan(context.Background(), big, big.Size()); v.Status != StatusSkipped {
+		t.Errorf("oversized content = %+v", v)
+	}
+
+	if got, err := clamdVerdict("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Errorf("clamd error reply = %q", got)
+	}
+}
+
+// Infected content stays blocked whatever the settings, the rest only
+// while block_unscanned is on
+func TestBlocks(t *testing.T) {
+	ctx := context.Background()
+	var off *Scanner
+	if !off.Blocks(ctx, StatusInfected) || off.Blocks(ctx, StatusPending) || off.Enabled(ctx) {
+		t.Fatal("nil scanner must block only infected content")
+	}
+
+	s := newTestScanner(t, &v1.ScanSettings{Enabled: proto.Bool(true), Address: proto.String("127.0.0.1:3310"), BlockUnscanned: proto.Bool(true)})
+	for status, want := range map[string]bool{
+		"":             false,
+		StatusClean:    false,
+		StatusPending:  true,
+		StatusSkipped:  true,
+		StatusError:    true,
+		StatusInfected: true,
+	} {
+		if got := s.Blocks(ctx, status); got != want {
+			t.Errorf("Blocks(%q) = %v, want %v", status, got, want)
+		}
+	}
+
+	s = newTestScanner(t, &v1.ScanSettings{Enabled: proto.Bool(true), Address: proto.String("127.0.0.1:3310")})
+	if s.Blocks(ctx, StatusPending) || !s.Blocks(ctx, StatusInfected) {
+		t.Error("without block_unscanned only infected content is blocked")
+	}
+}
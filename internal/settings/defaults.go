@@ -12,11 +12,13 @@ func Defaults() *v1.Settings {
 			PublicHostname: proto.String("localhost:8080"),
 		},
 		Auth: &v1.AuthSettings{
-			SessionTimeoutSeconds:  proto.Int32(86400),
-			TokenExpirySeconds:     proto.Int32(900),
-			AnonymousAccess:        proto.Bool(false),
-			LocalEnabled:           proto.Bool(true),
-			LocalAllowRegistration: proto.Bool(true),
+			SessionTimeoutSeconds:        proto.Int32(86400),
+			TokenExpirySeconds:           proto.Int32(900),
+			AnonymousAccess:              proto.Bool(false),
+			LocalEnabled:                 proto.Bool(true),
+			LocalAllowRegistration:       proto.Bool(true),
+			RegistrationRequiresApproval: proto.Bool(false),
+			RegistrationHookUrl:          proto.String(""),
 			Oidc: &v1.OIDCSettings{
 				Enabled:       proto.Bool(false),
 				IssuerUri:     proto.String(""),
@@ -26,6 +28,17 @@ func Defaults() *v1.Settings {
 				GroupClaim:    proto.String("groups"),
 				SkipTlsVerify: proto.Bool(false),
 			},
+			ClientCert: &v1.ClientCertAuthSettings{
+				Enabled:        proto.Bool(false),
+				MatchUsernames: proto.Bool(false),
+			},
+			PasswordHash: &v1.PasswordHashSettings{
+				Scheme:            proto.String("bcrypt"),
+				BcryptCost:        proto.Int32(10),
+				Argon2MemoryKib:   proto.Int32(19456),
+				Argon2Iterations:  proto.Int32(2),
+				Argon2Parallelism: proto.Int32(1),
+			},
 		},
 		Tls: &v1.TLSSettings{
 			Mode:          v1.TLSMode_TLS_MODE_DUAL.Enum(),
@@ -61,6 +74,29 @@ func Defaults() *v1.Settings {
 				Enabled:       proto.Bool(false),
 				IntervalHours: proto.Int32(24),
 			},
+			Tiering: &v1.ArtifactTieringSettings{
+				Enabled:       proto.Bool(false),
+				ColdAfterDays: proto.Int32(90),
+			},
+			Properties: &v1.ArtifactPropertyLimits{
+				MaxProperties:  proto.Int32(64),
+				MaxKeyLength:   proto.Int32(128),
+				MaxValueLength: proto.Int32(1024),
+				KeyPattern:     proto.String(`^[A-Za-z0-9][A-Za-z0-9._:/-]*$`),
+			},
+			Query: &v1.ArtifactQuerySettings{
+				Num:   proto.Int32(1),
+				Sort:  proto.String("created_at"),
+				Order: proto.String("desc"),
+				Flat:  proto.Bool(false),
+			},
+			Latest: &v1.ArtifactLatestSettings{
+				Enabled: proto.Bool(true),
+			},
+			Quota: &v1.ArtifactQuotaSettings{
+				RepoMb:  proto.Int64(0),
+				OwnerMb: proto.Int64(0),
+			},
 		},
 		Gc: &v1.GCSettings{
 			Enabled:        proto.Bool(false),
@@ -89,6 +125,10 @@ func Defaults() *v1.Settings {
 			FailureBackoffMinutes:    proto.Int32(5),
 			MaxSyncDepth:             proto.Int32(0),
 		},
+		Namespaces: &v1.NamespaceSettings{
+			// Route segments a namespace would shadow
+			ReservedNames: []string{"api", "auth", "healthz", "static", "v1", "v2"},
+		},
 		Security: &v1.SecuritySettings{
 			Headers: &v1.SecurityHeadersSettings{
 				Enabled:               proto.Bool(true),
@@ -100,6 +140,61 @@ func Defaults() *v1.Settings {
 				Enabled:       proto.Bool(true),
 				RetentionDays: proto.Int32(90),
 			},
+			Deletion: &v1.DeletionSettings{
+				RequireReason:      proto.Bool(false),
+				DualApprovalBytes:  proto.Int64(0),
+				ApprovalTtlMinutes: proto.Int32(1440),
+			},
+		},
+		Logging: &v1.LoggingSettings{
+			Level: proto.String("info"),
+		},
+		Signing: &v1.SigningSettings{
+			Enabled:     proto.Bool(false),
+			PerRepoKeys: proto.Bool(false),
+		},
+		PushPolicy: &v1.PushPolicySettings{
+			Enabled:   proto.Bool(false),
+			Url:       proto.String(""),
+			Format:    proto.String("webhook"),
+			TimeoutMs: proto.Int32(5000),
+			FailOpen:  proto.Bool(false),
+		},
+		Scan: &v1.ScanSettings{
+			Enabled:        proto.Bool(false),
+			Engine:         proto.String("clamav"),
+			Address:        proto.String(""),
+			TimeoutMs:      proto.Int32(60000),
+			MaxSizeMb:      proto.Int64(0),
+			BlockUnscanned: proto.Bool(false),
+		},
+		Notifications: &v1.NotificationSettings{
+			Enabled:       proto.Bool(true),
+			SmtpAddr:      proto.String(""),
+			Username:      proto.String(""),
+			From:          proto.String(""),
+			RetentionDays: proto.Int32(30),
+		},
+		Alerts: &v1.AlertSettings{
+			Enabled:               proto.Bool(false),
+			IntervalSeconds:       proto.Int32(60),
+			StoragePercent:        proto.Int32(90),
+			FailedLoginsPerMinute: proto.Int32(30),
+			UploadFailurePercent:  proto.Int32(20),
+			UploadMinSamples:      proto.Int32(10),
+			EgressBytesPerSecond:  proto.Int64(0),
+			WebhookUrl:            proto.String(""),
+			Email: &v1.AlertEmailSettings{
+				SmtpAddr: proto.String(""),
+				Username: proto.String(""),
+				From:     proto.String(""),
+			},
+		},
+		Branding: &v1.BrandingSettings{
+			InstanceName:   proto.String(""),
+			LogoUrl:        proto.String(""),
+			LoginBanner:    proto.String(""),
+			SupportContact: proto.String(""),
 		},
 	}
 }
@@ -13,6 +13,11 @@ import (
 // Output only fields no tier may write
 var readOnlyPaths = []string{
 	"auth.oidc.client_secret_set",
+	"auth.registration_hook_secret_set",
+	"push_policy.secret_set",
+	"alerts.webhook_secret_set",
+	"alerts.email.password_set",
+	"notifications.password_set",
 }
 
 // Paths each non system scope may store, prefixes cover subtrees
@@ -24,11 +29,28 @@ var scopeAllowed = map[v1.SettingsScopeType][]string{
 		"artifacts.stale_upload_cleanup_hours",
 		"artifacts.private_by_default",
 		"artifacts.retention",
+		"artifacts.query",
+		"artifacts.properties",
+		"artifacts.latest",
+		"artifacts.quota.repo_mb",
+		"auth.anonymous_access",
+		"branding",
 		"portals.isolated",
+		"signing.enabled",
+	},
+	v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO: {
+		"artifacts.max_file_size_mb",
+		"artifacts.retention",
+		"artifacts.query",
+		"artifacts.properties",
+		"artifacts.latest",
+		"artifacts.quota.repo_mb",
+		"auth.anonymous_access",
 	},
 	v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_PORTAL: {
 		"acme.email",
 		"acme.directory_url",
+		"branding",
 		"tls.mtls_mode",
 	},
 }
@@ -179,6 +201,47 @@ func Redact(s *v1.Settings) {
 		oidc.ClientSecretSet = oidc.ClientSecret != nil && *oidc.ClientSecret != ""
 		oidc.ClientSecret = nil
 	}
+	if a := s.GetAuth(); a != nil {
+		a.RegistrationHookSecretSet = a.RegistrationHookSecret != nil && *a.RegistrationHookSecret != ""
+		a.RegistrationHookSecret = nil
+	}
+	if p := s.GetPushPolicy(); p != nil {
+		p.SecretSet = p.Secret != nil && *p.Secret != ""
+		p.Secret = nil
+	}
+	if a := s.GetAlerts(); a != nil {
+		a.WebhookSecretSet = a.WebhookSecret != nil && *a.WebhookSecret != ""
+		a.WebhookSecret = nil
+	}
+	if e := s.GetAlerts().GetEmail(); e != nil {
+		e.PasswordSet = e.Password != nil && *e.Password != ""
+		e.Password = nil
+	}
+	if n := s.GetNotifications(); n != nil {
+		n.PasswordSet = n.Password != nil && *n.Password != ""
+		n.Password = nil
+		for _, c := range n.Channels {
+			c.SecretSet = c.Secret != nil && *c.Secret != ""
+			c.Secret = nil
+		}
+	}
+}
+
+// Channel lists are replaced whole, a channel sent back without its
+// secret keeps the one stored under the same name
+func keepChannelSecrets(stored, patch *v1.Settings) {
+	for _, c := range patch.GetNotifications().GetChannels() {
+		c.SecretSet = false
+		if c.Secret != nil {
+			continue
+		}
+		for _, old := range stored.GetNotifications().GetChannels() {
+			if old.GetName() == c.GetName() && old.Secret != nil {
+				c.Secret = proto.String(old.GetSecret())
+				break
+			}
+		}
+	}
 }
 
 // Provenance lists the supplying tier for every leaf of the schema
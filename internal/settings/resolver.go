@@ -2,7 +2,9 @@ package settings
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
@@ -16,6 +18,8 @@ type Store interface {
 	SetSettingsValue(ctx context.Context, scope v1.SettingsScopeType, scopeID, value string) error
 	DeleteSettingsValue(ctx context.Context, scope v1.SettingsScopeType, scopeID string) error
 	GetPortalOrgID(ctx context.Context, portalID string) (string, error)
+	// Empty when the namespace is a user rather than an org
+	GetNamespaceOrgID(ctx context.Context, namespace string) (string, error)
 }
 
 type scopeKey struct {
@@ -32,6 +36,7 @@ type Resolver struct {
 	mu        sync.RWMutex
 	rows      map[scopeKey]*v1.Settings
 	portalOrg map[string]string
+	nsOrg     map[string]string
 	subs      []func()
 }
 
@@ -41,6 +46,7 @@ func NewResolver(store Store, fileOverrides *v1.Settings) *Resolver {
 		overrides: fileOverrides,
 		rows:      map[scopeKey]*v1.Settings{},
 		portalOrg: map[string]string{},
+		nsOrg:     map[string]string{},
 	}
 	if fileOverrides != nil {
 		r.locked = setLeafPaths(fileOverrides)
@@ -65,6 +71,7 @@ func (r *Resolver) Invalidate() {
 	r.mu.Lock()
 	r.rows = map[scopeKey]*v1.Settings{}
 	r.portalOrg = map[string]string{}
+	r.nsOrg = map[string]string{}
 	r.mu.Unlock()
 }
 
@@ -92,6 +99,25 @@ func (r *Resolver) SeedSystem(ctx context.Context, seed *v1.Settings) error {
 	return r.save(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", seed)
 }
 
+// ApplySystem writes every field set in doc over the stored system row on
+// each call. Paths the config file pins are skipped, they win regardless
+func (r *Resolver) ApplySystem(ctx context.Context, doc *v1.Settings) error {
+	if doc == nil {
+		return nil
+	}
+	var paths []string
+	for _, path := range setLeafPaths(doc) {
+		if !pathCovered(path, r.locked) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	_, err := r.Update(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", doc, paths)
+	return err
+}
+
 // Stored returns the raw row for one scope, empty when absent
 func (r *Resolver) Stored(ctx context.Context, scope v1.SettingsScopeType, scopeID string) (*v1.Settings, error) {
 	key := scopeKey{scope, scopeID}
@@ -135,6 +161,34 @@ func (r *Resolver) orgForPortal(ctx context.Context, portalID string) (string, e
 	return orgID, nil
 }
 
+// User namespaces are not cached so an org created later under a
+// freed name is picked up
+func (r *Resolver) orgForNamespace(ctx context.Context, namespace string) (string, error) {
+	r.mu.RLock()
+	orgID, ok := r.nsOrg[namespace]
+	r.mu.RUnlock()
+	if ok {
+		return orgID, nil
+	}
+	orgID, err := r.store.GetNamespaceOrgID(ctx, namespace)
+	if err != nil || orgID == "" {
+		return "", err
+	}
+	r.mu.Lock()
+	r.nsOrg[namespace] = orgID
+	r.mu.Unlock()
+	return orgID, nil
+}
+
+// Namespace half of a namespace/name repo scope id
+func RepoNamespace(scopeID string) (string, error) {
+	ns, name, ok := strings.Cut(scopeID, "/")
+	if !ok || ns == "" || name == "" {
+		return "", fmt.Errorf("repo scope id %q must be namespace/name", scopeID)
+	}
+	return ns, nil
+}
+
 // Effective resolves the full chain for a scope with provenance
 func (r *Resolver) Effective(ctx context.Context, scope v1.SettingsScopeType, scopeID string) (*v1.Settings, []*v1.FieldProvenance, error) {
 	type tierRow struct {
@@ -172,6 +226,27 @@ func (r *Resolver) Effective(ctx context.Context, scope v1.SettingsScopeType, sc
 		chain = append(chain,
 			tierRow{org, v1.SettingsTier_SETTINGS_TIER_ORG},
 			tierRow{portal, v1.SettingsTier_SETTINGS_TIER_PORTAL})
+	case v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO:
+		namespace, err := RepoNamespace(scopeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		orgID, err := r.orgForNamespace(ctx, namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		if orgID != "" {
+			org, err := r.Stored(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_ORG, orgID)
+			if err != nil {
+				return nil, nil, err
+			}
+			chain = append(chain, tierRow{org, v1.SettingsTier_SETTINGS_TIER_ORG})
+		}
+		repo, err := r.Stored(ctx, scope, scopeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, tierRow{repo, v1.SettingsTier_SETTINGS_TIER_REPO})
 	}
 
 	eff := proto.Clone(Defaults()).(*v1.Settings)
@@ -212,6 +287,78 @@ func (r *Resolver) Portal(ctx context.Context, portalID string) *v1.Settings {
 	return eff
 }
 
+// Repo resolves repo over its org falling back to system on lookup failure
+func (r *Resolver) Repo(ctx context.Context, namespace, name string) *v1.Settings {
+	eff, _, err := r.Effective(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, namespace+"/"+name)
+	if err != nil {
+		return r.System(ctx)
+	}
+	return eff
+}
+
+// Whether anonymous callers may read a repo. Org and repo tiers only
+// withdraw what the instance grants, instances without auth serve everyone
+func (r *Resolver) AnonymousReadable(ctx context.Context, namespace, name string) bool {
+	a := r.System(ctx).GetAuth()
+	if !a.GetLocalEnabled() && !a.GetOidc().GetEnabled() {
+		return true
+	}
+	return a.GetAnonymousAccess() && r.Repo(ctx, namespace, name).GetAuth().GetAnonymousAccess()
+}
+
+// Names matching namespaces.reserved_names
+var ErrReservedName = errors.New("reserved name")
+
+// Shared reserved name check for everything that claims a namespace,
+// users, orgs, new repos and accounts an identity provider creates
+func (r *Resolver) CheckNamespace(ctx context.Context, name string) error {
+	if pattern := reservedBy(r.System(ctx).GetNamespaces().GetReservedNames(), name); pattern != "" {
+		return fmt.Errorf("%w: %q matches %q", ErrReservedName, name, pattern)
+	}
+	return nil
+}
+
+// First pattern reserving name, exact or prefix* and case insensitive
+func reservedBy(patterns []string, name string) string {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		lp := strings.ToLower(strings.TrimSpace(p))
+		if prefix, ok := strings.CutSuffix(lp, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return p
+			}
+		} else if lp == name {
+			return p
+		}
+	}
+	return ""
+}
+
+// Repo quota in bytes, zero when unlimited. Org and repo tiers can only
+// tighten the system cap since repo owners write their own tier
+func (r *Resolver) RepoQuotaBytes(ctx context.Context, namespace, name string) int64 {
+	system := r.System(ctx).GetArtifacts().GetQuota().GetRepoMb()
+	return tighter(system, r.Repo(ctx, namespace, name).GetArtifacts().GetQuota().GetRepoMb()) << 20
+}
+
+// Upload size cap in bytes, zero when unlimited. Like the repo quota it
+// only tightens going down the tiers
+func (r *Resolver) MaxFileSizeBytes(ctx context.Context, namespace, name string) int64 {
+	system := r.System(ctx).GetArtifacts().GetMaxFileSizeMb()
+	return tighter(system, r.Repo(ctx, namespace, name).GetArtifacts().GetMaxFileSizeMb()) << 20
+}
+
+// The stricter of two caps where zero or less means unlimited
+func tighter(system, scoped int64) int64 {
+	switch {
+	case system <= 0:
+		return max(scoped, 0)
+	case scoped <= 0:
+		return system
+	}
+	return min(system, scoped)
+}
+
 // Update applies a field masked patch to one scope and persists it
 func (r *Resolver) Update(ctx context.Context, scope v1.SettingsScopeType, scopeID string, patch *v1.Settings, paths []string) (*v1.Settings, error) {
 	if len(paths) == 0 {
@@ -234,6 +381,8 @@ func (r *Resolver) Update(ctx context.Context, scope v1.SettingsScopeType, scope
 		return nil, err
 	}
 	stored := proto.Clone(current).(*v1.Settings)
+	patch = proto.Clone(patch).(*v1.Settings)
+	keepChannelSecrets(stored, patch)
 	if err := applyMask(stored, patch, paths); err != nil {
 		return nil, err
 	}
@@ -2,6 +2,8 @@ package settings
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
@@ -11,10 +13,11 @@ import (
 type memStore struct {
 	rows      map[string]string
 	portalOrg map[string]string
+	nsOrg     map[string]string
 }
 
 func newMemStore() *memStore {
-	return &memStore{rows: map[string]string{}, portalOrg: map[string]string{}}
+	return &memStore{rows: map[string]string{}, portalOrg: map[string]string{}, nsOrg: map[string]string{}}
 }
 
 func key(scope v1.SettingsScopeType, id string) string {
@@ -40,6 +43,10 @@ func (m *memStore) GetPortalOrgID(_ context.Context, portalID string) (string, e
 	return m.portalOrg[portalID], nil
 }
 
+func (m *memStore) GetNamespaceOrgID(_ context.Context, namespace string) (string, error) {
+	return m.nsOrg[namespace], nil
+}
+
 func provFor(list []*v1.FieldProvenance, path string) v1.SettingsTier {
 	for _, p := range list {
 		if p.Path == path {
@@ -113,6 +120,130 @@ func TestTierPrecedence(t *testing.T) {
 	}
 }
 
+// Repos resolve over their org, user namespace repos straight over system
+func TestRepoTier(t *testing.T) {
+	store := newMemStore()
+	store.nsOrg["acme"] = "o1"
+	r := NewResolver(store, nil)
+	ctx := t.Context()
+	sys := v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM
+	org := v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_ORG
+	repo := v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO
+	retention := func(n int32) *v1.Settings {
+		return &v1.Settings{Artifacts: &v1.ArtifactSettings{Retention: &v1.ArtifactRetentionSettings{MaxVersions: proto.Int32(n)}}}
+	}
+	path := []string{"artifacts.retention.max_versions"}
+
+	for scope, id := range map[v1.SettingsScopeType]string{sys: "", org: "o1"} {
+		n := int32(5)
+		if scope == org {
+			n = 10
+		}
+		if _, err := r.Update(ctx, scope, id, retention(n), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eff, prov, err := r.Effective(ctx, repo, "acme/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eff.GetArtifacts().GetRetention().GetMaxVersions() != 10 || provFor(prov, path[0]) != v1.SettingsTier_SETTINGS_TIER_ORG {
+		t.Fatalf("expected org value, got %d from %v", eff.GetArtifacts().GetRetention().GetMaxVersions(), provFor(prov, path[0]))
+	}
+
+	if _, err := r.Update(ctx, repo, "acme/app", retention(2), path); err != nil {
+		t.Fatal(err)
+	}
+	eff, prov, _ = r.Effective(ctx, repo, "acme/app")
+	if eff.GetArtifacts().GetRetention().GetMaxVersions() != 2 || provFor(prov, path[0]) != v1.SettingsTier_SETTINGS_TIER_REPO {
+		t.Fatalf("expected repo value, got %d", eff.GetArtifacts().GetRetention().GetMaxVersions())
+	}
+	if got := r.Repo(ctx, "alice", "app").GetArtifacts().GetRetention().GetMaxVersions(); got != 5 {
+		t.Fatalf("user namespace repo expected system value, got %d", got)
+	}
+
+	if _, err := r.Update(ctx, repo, "acme/app", &v1.Settings{Gc: &v1.GCSettings{Enabled: proto.Bool(true)}}, []string{"gc.enabled"}); err == nil {
+		t.Fatal("expected repo scope to reject gc settings")
+	}
+	if _, _, err := r.Effective(ctx, repo, "noslash"); err == nil {
+		t.Fatal("expected malformed repo scope id rejection")
+	}
+}
+
+// Lower tiers can withdraw anonymous access but never grant it
+func TestAnonymousReadable(t *testing.T) {
+	store := newMemStore()
+	store.nsOrg["acme"] = "o1"
+	r := NewResolver(store, nil)
+	ctx := t.Context()
+	anon := func(on bool) *v1.Settings {
+		return &v1.Settings{Auth: &v1.AuthSettings{AnonymousAccess: proto.Bool(on), LocalEnabled: proto.Bool(true)}}
+	}
+
+	r.Update(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", anon(true), []string{"auth.anonymous_access", "auth.local_enabled"})
+	r.Update(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_ORG, "o1", anon(false), []string{"auth.anonymous_access"})
+	r.Update(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, "acme/public", anon(true), []string{"auth.anonymous_access"})
+	if !r.AnonymousReadable(ctx, "alice", "app") || r.AnonymousReadable(ctx, "acme", "app") || !r.AnonymousReadable(ctx, "acme", "public") {
+		t.Fatal("org withdrawal or repo restore not honored")
+	}
+
+	r.Update(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", anon(false), []string{"auth.anonymous_access"})
+	if r.AnonymousReadable(ctx, "acme", "public") {
+		t.Fatal("repo tier granted anonymous access the instance refuses")
+	}
+}
+
+// Repo owners write their own tier, so it can only tighten the instance cap
+func TestRepoQuotaBytes(t *testing.T) {
+	store := newMemStore()
+	store.nsOrg["acme"] = "o1"
+	r := NewResolver(store, nil)
+	ctx := t.Context()
+	quota := func(mb int64) *v1.Settings {
+		return &v1.Settings{Artifacts: &v1.ArtifactSettings{Quota: &v1.ArtifactQuotaSettings{RepoMb: proto.Int64(mb)}}}
+	}
+	set := func(scope v1.SettingsScopeType, id string, mb int64) {
+		t.Helper()
+		if _, err := r.Update(ctx, scope, id, quota(mb), []string{"artifacts.quota.repo_mb"}); err != nil {
+			t.Fatalf("Update(%s %s): %v", scope, id, err)
+		}
+	}
+
+	set(v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, "alice/app", 5)
+	if got := r.RepoQuotaBytes(ctx, "alice", "app"); got != 5<<20 {
+		t.Fatalf("repo cap under an unlimited instance = %d", got)
+	}
+
+	set(v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", 10)
+	set(v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, "alice/big", 100)
+	set(v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_REPO, "alice/open", 0)
+	set(v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_ORG, "o1", 50)
+	for repo, want := range map[string]int64{"alice/app": 5, "alice/big": 10, "alice/open": 10, "acme/app": 10, "bob/app": 10} {
+		ns, name, _ := strings.Cut(repo, "/")
+		if got := r.RepoQuotaBytes(ctx, ns, name); got != want<<20 {
+			t.Errorf("%s quota = %d MiB, want %d", repo, got>>20, want)
+		}
+	}
+}
+
+func TestCheckNamespace(t *testing.T) {
+	r := NewResolver(newMemStore(), nil)
+	ctx := t.Context()
+	if err := r.CheckNamespace(ctx, "V2"); !errors.Is(err, ErrReservedName) {
+		t.Fatalf("default reserved name = %v", err)
+	}
+	if _, err := r.Update(ctx, v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", &v1.Settings{
+		Namespaces: &v1.NamespaceSettings{ReservedNames: []string{"v2", " Team-* "}},
+	}, []string{"namespaces.reserved_names"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	for name, reserved := range map[string]bool{"v2": true, "team-a": true, "TEAM-": true, "team": false, "api": false, "myteam-a": false} {
+		if err := r.CheckNamespace(ctx, name); errors.Is(err, ErrReservedName) != reserved {
+			t.Errorf("CheckNamespace(%q) = %v, want reserved %v", name, err, reserved)
+		}
+	}
+}
+
 func TestFilePins(t *testing.T) {
 	pins := &v1.Settings{Acme: &v1.ACMESettings{DirectoryUrl: proto.String("https://internal-ca/dir")}}
 	r := NewResolver(newMemStore(), pins)
@@ -137,6 +268,39 @@ func TestFilePins(t *testing.T) {
 	}
 }
 
+// Bootstrap settings land on every call, over edits but under file pins
+func TestApplySystem(t *testing.T) {
+	pins := &v1.Settings{Acme: &v1.ACMESettings{DirectoryUrl: proto.String("https://internal-ca/dir")}}
+	r := NewResolver(newMemStore(), pins)
+	ctx := t.Context()
+	sys := v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM
+
+	if _, err := r.Update(ctx, sys, "", &v1.Settings{
+		Auth:   &v1.AuthSettings{AnonymousAccess: proto.Bool(true)},
+		Server: &v1.ServerSettings{PublicHostname: proto.String("edited.example.com")},
+	}, []string{"auth.anonymous_access", "server.public_hostname"}); err != nil {
+		t.Fatal(err)
+	}
+	doc := &v1.Settings{
+		Server: &v1.ServerSettings{PublicHostname: proto.String("registry.example.com")},
+		Acme:   &v1.ACMESettings{DirectoryUrl: proto.String("https://other/dir")},
+	}
+	if err := r.ApplySystem(ctx, doc); err != nil {
+		t.Fatalf("ApplySystem: %v", err)
+	}
+
+	stored, _ := r.Stored(ctx, sys, "")
+	if stored.GetServer().GetPublicHostname() != "registry.example.com" {
+		t.Fatalf("hostname = %q", stored.GetServer().GetPublicHostname())
+	}
+	if !stored.GetAuth().GetAnonymousAccess() {
+		t.Fatal("field the document leaves out was reset")
+	}
+	if stored.GetAcme().GetDirectoryUrl() != "" {
+		t.Fatal("pinned path written to the row")
+	}
+}
+
 func TestScopeRules(t *testing.T) {
 	r := NewResolver(newMemStore(), nil)
 	ctx := t.Context()
@@ -156,6 +320,19 @@ func TestScopeRules(t *testing.T) {
 		&v1.Settings{}, []string{"bogus.path"}); err == nil {
 		t.Fatal("expected unknown path rejection")
 	}
+
+	portal := v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_PORTAL
+	if _, err := r.Update(ctx, portal, "p1",
+		&v1.Settings{Branding: &v1.BrandingSettings{LoginBanner: proto.String("Authorized use only")}},
+		[]string{"branding.login_banner"}); err != nil {
+		t.Fatalf("expected portal branding write to pass: %v", err)
+	}
+	if got := r.Portal(ctx, "p1").GetBranding().GetLoginBanner(); got != "Authorized use only" {
+		t.Fatalf("expected portal banner, got %q", got)
+	}
+	if got := r.System(ctx).GetBranding().GetLoginBanner(); got != "" {
+		t.Fatalf("expected empty system banner, got %q", got)
+	}
 }
 
 func TestSubtreeMaskAndPrune(t *testing.T) {
@@ -195,6 +372,39 @@ func TestRedact(t *testing.T) {
 	}
 }
 
+// Channels come back redacted, saving them unchanged must not drop secrets
+func TestChannelSecretsKept(t *testing.T) {
+	r := NewResolver(newMemStore(), nil)
+	ctx := t.Context()
+	sys := v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM
+	hook := &v1.NotificationChannel{Name: "ops", Type: "webhook", Url: "https://hooks.example.com/x", Secret: proto.String("s3cret"), Events: []string{"alert.*"}}
+	patch := &v1.Settings{Notifications: &v1.NotificationSettings{Channels: []*v1.NotificationChannel{hook}}}
+	if _, err := r.Update(ctx, sys, "", patch, []string{"notifications.channels"}); err != nil {
+		t.Fatal(err)
+	}
+
+	redacted := proto.Clone(r.System(ctx)).(*v1.Settings)
+	Redact(redacted)
+	got := redacted.GetNotifications().GetChannels()[0]
+	if got.Secret != nil || !got.GetSecretSet() {
+		t.Fatalf("channel not redacted: %v", got)
+	}
+
+	got.Events = []string{"alert.*", "push"}
+	added := &v1.NotificationChannel{Name: "chat", Type: "slack", Url: "https://hooks.slack.com/x", Events: []string{"push"}}
+	patch = &v1.Settings{Notifications: &v1.NotificationSettings{Channels: []*v1.NotificationChannel{got, added}}}
+	if _, err := r.Update(ctx, sys, "", patch, []string{"notifications.channels"}); err != nil {
+		t.Fatal(err)
+	}
+	channels := r.System(ctx).GetNotifications().GetChannels()
+	if len(channels) != 2 || channels[0].GetSecret() != "s3cret" || len(channels[0].GetEvents()) != 2 || channels[1].Secret != nil {
+		t.Fatalf("channels after update = %v", channels)
+	}
+	if channels[0].GetSecretSet() {
+		t.Fatal("secret_set stored")
+	}
+}
+
 func TestSeedSystemOnce(t *testing.T) {
 	store := newMemStore()
 	r := NewResolver(store, nil)
@@ -0,0 +1,143 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+)
+
+// Package repository keys live beside image keys under their own scope
+func PackageScope(namespace, name string) string {
+	return "packages:" + RepoScope(namespace, name)
+}
+
+var pgpConfig = &packet.Config{RSABits: 3072, DefaultHash: crypto.SHA256}
+
+// Armored public key apt and yum clients import to trust a package repo
+func (s *Signer) PackageKey(ctx context.Context, namespace, name string) (string, error) {
+	key, err := s.activePackageKey(ctx, namespace, name)
+	if err != nil {
+		return "", err
+	}
+	return key.PublicKey, nil
+}
+
+// Signs a package index, returning the inline clearsigned document apt
+// reads as InRelease and the armored detached signature of Release.gpg
+// and repomd.xml.asc
+func (s *Signer) SignIndex(ctx context.Context, namespace, name string, content []byte) (clearSigned, detached []byte, err error) {
+	key, err := s.activePackageKey(ctx, namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	entity, err := s.pgpEntity(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var inline bytes.Buffer
+	w, err := clearsign.Encode(&inline, entity.PrivateKey, pgpConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, entity, bytes.NewReader(content), pgpConfig); err != nil {
+		return nil, nil, err
+	}
+	sig.WriteByte('\n')
+	return inline.Bytes(), sig.Bytes(), nil
+}
+
+func (s *Signer) activePackageKey(ctx context.Context, namespace, name string) (*storage.SigningKey, error) {
+	scope := PackageScope(namespace, name)
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	key, err := s.store.GetActiveSigningKey(ctx, scope)
+	if err != nil || key != nil {
+		return key, err
+	}
+	return s.createPackageKey(ctx, namespace, name, scope)
+}
+
+// Caller holds keyMu. Rsa rather than ecdsa, older apt and rpm verify nothing else
+func (s *Signer) createPackageKey(ctx context.Context, namespace, name, scope string) (*storage.SigningKey, error) {
+	entity, err := openpgp.NewEntity("DistroFace "+RepoScope(namespace, name), "package repository signing key", "", pgpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("generating package signing key: %w", err)
+	}
+	var priv bytes.Buffer
+	if err := armorTo(&priv, openpgp.PrivateKeyType, func(w *bytes.Buffer) error { return entity.SerializePrivate(w, pgpConfig) }); err != nil {
+		return nil, err
+	}
+	var pub bytes.Buffer
+	if err := armorTo(&pub, openpgp.PublicKeyType, func(w *bytes.Buffer) error { return entity.Serialize(w) }); err != nil {
+		return nil, err
+	}
+
+	key := &storage.SigningKey{
+		ID:        uuid.New().String(),
+		Scope:     scope,
+		PublicKey: pub.String(),
+	}
+	key.PrivateKey, err = s.vault.Seal(key.ID, priv.String())
+	if err != nil {
+		return nil, fmt.Errorf("sealing package signing key: %w", err)
+	}
+	if err := s.store.ActivateSigningKey(ctx, key); err != nil {
+		return nil, err
+	}
+	s.log.Info("signing: created package key %s for scope %q", key.ID, scope)
+	return key, nil
+}
+
+func (s *Signer) pgpEntity(key *storage.SigningKey) (*openpgp.Entity, error) {
+	if e, ok := s.pgpKeys.Load(key.ID); ok {
+		return e.(*openpgp.Entity), nil
+	}
+	plain, err := s.vault.Open(key.ID, key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	list, err := openpgp.ReadArmoredKeyRing(strings.NewReader(plain))
+	if err != nil || len(list) != 1 || list[0].PrivateKey == nil {
+		return nil, fmt.Errorf("package signing key %s is not an openpgp private key", key.ID)
+	}
+	s.pgpKeys.Store(key.ID, list[0])
+	return list[0], nil
+}
+
+func armorTo(out *bytes.Buffer, blockType string, write func(*bytes.Buffer) error) error {
+	var raw bytes.Buffer
+	if err := write(&raw); err != nil {
+		return err
+	}
+	w, err := armor.Encode(out, blockType, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	return nil
+}
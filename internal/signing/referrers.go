@@ -0,0 +1,109 @@
+package signing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The embedded distribution has no referrers api, this route is served here
+var referrersRoutePattern = regexp.MustCompile(`^/v2/(.+)/referrers/(sha256:[a-f0-9]{64})$`)
+
+// Checks a registry bearer token
+type TokenVerifier interface {
+	VerifyToken(raw string) (*auth.ClaimSet, error)
+}
+
+// Serves the OCI referrers api for server made signatures
+type Referrers struct {
+	signer   *Signer
+	verifier TokenVerifier
+}
+
+func NewReferrers(signer *Signer, verifier TokenVerifier) *Referrers {
+	return &Referrers{signer: signer, verifier: verifier}
+}
+
+// Wrap answers referrers requests and hands everything else to next
+func (h *Referrers) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := referrersRoutePattern.FindStringSubmatch(r.URL.Path)
+		if m == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, subject := m[1], m[2]
+		namespace, repo := utils.SplitRepoName(name)
+		if namespace == "" || repo == "" {
+			ociError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+			return
+		}
+		if !h.authorized(r, name) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="/auth/token",service="distroface-registry",scope="repository:%s:pull"`, name))
+			ociError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+			return
+		}
+
+		sigs, err := h.signer.store.ListImageSignatures(r.Context(), namespace, repo, subject)
+		if err != nil {
+			ociError(w, http.StatusInternalServerError, "UNKNOWN", "listing referrers failed")
+			return
+		}
+		filter := r.URL.Query().Get("artifactType")
+		index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: []ocispec.Descriptor{}}
+		index.SchemaVersion = 2
+		if filter == "" || filter == ArtifactType {
+			for _, sig := range sigs {
+				index.Manifests = append(index.Manifests, ocispec.Descriptor{
+					MediaType:    ocispec.MediaTypeImageManifest,
+					ArtifactType: ArtifactType,
+					Digest:       digest.Digest(sig.Digest),
+					Size:         sig.Size,
+					Annotations:  map[string]string{KeyIDAnnotation: sig.KeyID},
+				})
+			}
+		}
+		if filter != "" {
+			w.Header().Set("OCI-Filters-Applied", "artifactType")
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode(index)
+		}
+	})
+}
+
+// Bearer token granting pull on exactly this name
+func (h *Referrers) authorized(r *http.Request, name string) bool {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || h.verifier == nil {
+		return false
+	}
+	claims, err := h.verifier.VerifyToken(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	for _, ra := range claims.Access {
+		if ra.Type == "repository" && ra.Name == name && slices.Contains(ra.Actions, "pull") {
+			return true
+		}
+	}
+	return false
+}
+
+func ociError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,446 @@
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/vault"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Cosign compatible media types, so cosign verify works against the same objects
+const (
+	ArtifactType        = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	PayloadMediaType    = "application/vnd.dev.cosign.simplesigning.v1+json"
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+	KeyIDAnnotation     = "io.distroface.signing.key-id"
+	payloadType         = "cosign container image signature"
+)
+
+var ErrRepoKeysDisabled = errors.New("per repository signing keys are disabled")
+
+// Signs pushed manifests with server managed keys and checks those
+// signatures. Private keys are sealed by the vault, only public halves
+// ever leave the server
+type Signer struct {
+	store    *stores.Store
+	vault    *vault.Vault
+	res      *settings.Resolver
+	registry *registry.RegistryAccess
+	log      *logger.Logger
+
+	// Serializes key creation so concurrent first pushes share one key
+	keyMu sync.Mutex
+	// Decoded openpgp package keys by key id, rsa parsing is not free
+	pgpKeys sync.Map
+}
+
+func NewSigner(store *stores.Store, v *vault.Vault, res *settings.Resolver, reg *registry.RegistryAccess, log *logger.Logger) *Signer {
+	return &Signer{store: store, vault: v, res: res, registry: reg, log: log}
+}
+
+// Signature as recorded against a subject manifest
+type Result struct {
+	Digest     string
+	KeyID      string
+	Valid      bool
+	Error      string
+	KeyRetired bool
+	CreatedAt  time.Time
+}
+
+type Verification struct {
+	Digest     string
+	Verified   bool
+	Signatures []Result
+}
+
+// simple signing payload, the document the signature covers
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional,omitempty"`
+}
+
+// Org settings win for org namespaces, everyone else gets system settings
+func (s *Signer) settings(ctx context.Context, namespace string) *v1.SigningSettings {
+	if org, err := s.store.GetOrganization(ctx, namespace); err == nil && org != nil {
+		return s.res.Org(ctx, org.ID).GetSigning()
+	}
+	return s.res.System(ctx).GetSigning()
+}
+
+func (s *Signer) Enabled(ctx context.Context, namespace string) bool {
+	return s.settings(ctx, namespace).GetEnabled()
+}
+
+func (s *Signer) PerRepoKeys(ctx context.Context) bool {
+	return s.res.System(ctx).GetSigning().GetPerRepoKeys()
+}
+
+// Key scope for a repository, empty is the instance key
+func (s *Signer) scope(ctx context.Context, namespace, name string) string {
+	if s.PerRepoKeys(ctx) {
+		return RepoScope(namespace, name)
+	}
+	return ""
+}
+
+func RepoScope(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// Listener hook, runs after a manifest push was accepted. Failures are
+// logged and never fail the push
+func (s *Signer) SignPush(ctx context.Context, namespace, name, tag, mediaType string, payload []byte) {
+	if strings.HasPrefix(tag, "sha256-") || !s.Enabled(ctx, namespace) {
+		return
+	}
+	// Referrers (signatures, sboms, attestations) are not signed themselves
+	var probe struct {
+		Subject *ocispec.Descriptor `json:"subject,omitempty"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil || probe.Subject != nil {
+		return
+	}
+	subject := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(payload), Size: int64(len(payload))}
+	if _, err := s.Sign(ctx, namespace, name, subject); err != nil {
+		s.log.Error("signing: %s/%s@%s: %v", namespace, name, subject.Digest, err)
+	}
+}
+
+// Signs subject with the active key of the repo's scope, a subject already
+// signed by that key is left alone
+func (s *Signer) Sign(ctx context.Context, namespace, name string, subject ocispec.Descriptor) (*storage.ImageSignature, error) {
+	key, err := s.activeKey(ctx, s.scope(ctx, namespace, name))
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.store.ListImageSignatures(ctx, namespace, name, subject.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+	for _, sig := range existing {
+		if sig.KeyID == key.ID {
+			return sig, nil
+		}
+	}
+
+	priv, err := s.privateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var doc simpleSigning
+	doc.Critical.Identity.DockerReference = s.res.System(ctx).GetServer().GetPublicHostname() + "/" + namespace + "/" + name
+	doc.Critical.Image.DockerManifestDigest = subject.Digest.String()
+	doc.Critical.Type = payloadType
+	doc.Optional = map[string]string{"key-id": key.ID, "signer": "distroface"}
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(payload)
+	raw, err := ecdsa.SignASN1(rand.Reader, priv, sum[:])
+	if err != nil {
+		return nil, fmt.Errorf("signing payload: %w", err)
+	}
+
+	manifest := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: ArtifactType,
+		Config:       ocispec.DescriptorEmptyJSON,
+		Layers: []ocispec.Descriptor{{
+			MediaType: PayloadMediaType,
+			Digest:    digest.FromBytes(payload),
+			Size:      int64(len(payload)),
+			Annotations: map[string]string{
+				SignatureAnnotation: base64.StdEncoding.EncodeToString(raw),
+				KeyIDAnnotation:     key.ID,
+			},
+		}},
+		Subject: &ocispec.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+	}
+	manifest.SchemaVersion = 2
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	blobs := map[string][]byte{
+		PayloadMediaType:                      payload,
+		ocispec.DescriptorEmptyJSON.MediaType: ocispec.DescriptorEmptyJSON.Data,
+	}
+	// Every signature keeps its own tag so untagged gc never drops it, the
+	// plain .sig tag follows the newest one for cosign's tag lookup
+	tag := sigTag(subject.Digest)
+	desc, err := s.registry.PutManifest(ctx, namespace, name, blobs, body, strings.TrimSuffix(tag, ".sig")+"."+shortID(key.ID)+".sig")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.registry.TagManifest(ctx, namespace, name, tag, desc); err != nil {
+		return nil, err
+	}
+
+	sig := &storage.ImageSignature{
+		Namespace:     namespace,
+		Name:          name,
+		SubjectDigest: subject.Digest.String(),
+		Digest:        desc.Digest.String(),
+		Size:          desc.Size,
+		KeyID:         key.ID,
+	}
+	if err := s.store.CreateImageSignature(ctx, sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Detached signature over a document with the instance key, ASN.1 ECDSA
+// over its SHA-256. Verifiers pin the key from the returned public half
+func (s *Signer) SignDocument(ctx context.Context, payload []byte) (*storage.SigningKey, []byte, error) {
+	key, err := s.activeKey(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err := s.privateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256(payload)
+	raw, err := ecdsa.SignASN1(rand.Reader, priv, sum[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("signing document: %w", err)
+	}
+	return key, raw, nil
+}
+
+// Checks every recorded signature of reference, a tag or digest. Signatures
+// from retired keys still verify, rotation is not revocation
+func (s *Signer) Verify(ctx context.Context, namespace, name, reference string) (*Verification, error) {
+	subject, err := s.registry.ResolveManifest(ctx, namespace, name, reference)
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := s.store.ListImageSignatures(ctx, namespace, name, subject.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+	out := &Verification{Digest: subject.Digest.String()}
+	for _, sig := range sigs {
+		r := Result{Digest: sig.Digest, KeyID: sig.KeyID, CreatedAt: sig.CreatedAt}
+		key, err := s.check(ctx, namespace, name, subject.Digest, sig)
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Valid = true
+			out.Verified = true
+		}
+		if key != nil {
+			r.KeyRetired = !key.Active
+		}
+		out.Signatures = append(out.Signatures, r)
+	}
+	return out, nil
+}
+
+func (s *Signer) check(ctx context.Context, namespace, name string, subject digest.Digest, sig *storage.ImageSignature) (*storage.SigningKey, error) {
+	key, err := s.store.GetSigningKey(ctx, sig.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("signing key %s no longer exists", sig.KeyID)
+	}
+	if key.Scope != "" && key.Scope != RepoScope(namespace, name) {
+		return key, fmt.Errorf("signing key %s belongs to another repository", key.ID)
+	}
+	pub, err := parsePublicKey(key.PublicKey)
+	if err != nil {
+		return key, err
+	}
+
+	_, body, err := s.registry.GetManifest(ctx, namespace, name, digest.Digest(sig.Digest))
+	if err != nil {
+		return key, fmt.Errorf("signature manifest missing: %w", err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return key, fmt.Errorf("signature manifest unreadable: %w", err)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != subject {
+		return key, fmt.Errorf("signature manifest does not reference %s", subject)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != PayloadMediaType || layer.Annotations[KeyIDAnnotation] != key.ID {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(layer.Annotations[SignatureAnnotation])
+		if err != nil {
+			return key, fmt.Errorf("signature annotation unreadable: %w", err)
+		}
+		payload, err := s.registry.GetBlob(ctx, namespace, name, layer.Digest)
+		if err != nil {
+			return key, fmt.Errorf("signature payload missing: %w", err)
+		}
+		if digest.FromBytes(payload) != layer.Digest {
+			return key, fmt.Errorf("signature payload digest mismatch")
+		}
+		var doc simpleSigning
+		if err := json.Unmarshal(payload, &doc); err != nil {
+			return key, fmt.Errorf("signature payload unreadable: %w", err)
+		}
+		if doc.Critical.Image.DockerManifestDigest != subject.String() {
+			return key, fmt.Errorf("signature payload covers %s", doc.Critical.Image.DockerManifestDigest)
+		}
+		sum := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(pub, sum[:], raw) {
+			return key, fmt.Errorf("signature does not match key %s", key.ID)
+		}
+		return key, nil
+	}
+	return key, fmt.Errorf("signature manifest has no payload signed by %s", key.ID)
+}
+
+// Keys that can have signed this repo, its own first, then the instance's
+func (s *Signer) Keys(ctx context.Context, namespace, name string) ([]*storage.SigningKey, error) {
+	repoKeys, err := s.store.ListSigningKeys(ctx, RepoScope(namespace, name))
+	if err != nil {
+		return nil, err
+	}
+	instanceKeys, err := s.store.ListSigningKeys(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return append(repoKeys, instanceKeys...), nil
+}
+
+// Replaces the active key of a scope, old signatures keep verifying
+// against the retired key. An empty namespace rotates the instance key
+func (s *Signer) Rotate(ctx context.Context, namespace, name string) (*storage.SigningKey, error) {
+	scope := ""
+	if namespace != "" {
+		if !s.PerRepoKeys(ctx) {
+			return nil, ErrRepoKeysDisabled
+		}
+		scope = RepoScope(namespace, name)
+	}
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	return s.createKey(ctx, scope)
+}
+
+func (s *Signer) activeKey(ctx context.Context, scope string) (*storage.SigningKey, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	key, err := s.store.GetActiveSigningKey(ctx, scope)
+	if err != nil || key != nil {
+		return key, err
+	}
+	return s.createKey(ctx, scope)
+}
+
+// Caller holds keyMu
+func (s *Signer) createKey(ctx context.Context, scope string) (*storage.SigningKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating signing key: %w", err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	key := &storage.SigningKey{
+		ID:        uuid.New().String(),
+		Scope:     scope,
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})),
+	}
+	key.PrivateKey, err = s.vault.Seal(key.ID, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})))
+	if err != nil {
+		return nil, fmt.Errorf("sealing signing key: %w", err)
+	}
+	if err := s.store.ActivateSigningKey(ctx, key); err != nil {
+		return nil, err
+	}
+	s.log.Info("signing: created key %s for scope %q", key.ID, scope)
+	return key, nil
+}
+
+func (s *Signer) privateKey(key *storage.SigningKey) (*ecdsa.PrivateKey, error) {
+	plain, err := s.vault.Open(key.ID, key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(plain))
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not pem", key.ID)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not ecdsa", key.ID)
+	}
+	return priv, nil
+}
+
+func parsePublicKey(p string) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(p))
+	if block == nil {
+		return nil, fmt.Errorf("public key is not pem")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not ecdsa")
+	}
+	return pub, nil
+}
+
+// Cosign's tag for the signatures of dgst
+func sigTag(dgst digest.Digest) string {
+	return string(dgst.Algorithm()) + "-" + dgst.Encoded() + ".sig"
+}
+
+func shortID(id string) string {
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return id
+}
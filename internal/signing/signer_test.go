@@ -0,0 +1,309 @@
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/nickheyer/distroface/internal/auth"
+	"github.com/nickheyer/distroface/internal/db/stores"
+	"github.com/nickheyer/distroface/internal/registry"
+	"github.com/nickheyer/distroface/internal/settings"
+	"github.com/nickheyer/distroface/internal/vault"
+	"github.com/nickheyer/distroface/pkg/logger"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+// Accepts "pull:<name>" as a token granting pull on name
+type fakeVerifier struct{}
+
+func (fakeVerifier) VerifyToken(raw string) (*auth.ClaimSet, error) {
+	name, ok := strings.CutPrefix(raw, "pull:")
+	if !ok {
+		return nil, errors.New("bad token")
+	}
+	return &auth.ClaimSet{Access: []*auth.ResourceActions{{Type: "repository", Name: name, Actions: []string{"pull"}}}}, nil
+}
+
+type testEnv struct {
+	store  *stores.Store
+	res    *settings.Resolver
+	reg    *registry.RegistryAccess
+	signer *Signer
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	v, err := vault.Open(t.TempDir(), store)
+	if err != nil {
+		t.Fatalf("vault: %v", err)
+	}
+	reg, err := registry.NewRegistryAccess(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewRegistryAccess: %v", err)
+	}
+	res := settings.NewResolver(store, nil)
+	log := logger.NewWithConfig(&logger.Config{Enabled: false})
+	return &testEnv{store: store, res: res, reg: reg, signer: NewSigner(store, v, res, reg, log)}
+}
+
+func (e *testEnv) setSigning(t *testing.T, enabled, perRepo bool) {
+	t.Helper()
+	patch := &v1.Settings{Signing: &v1.SigningSettings{Enabled: proto.Bool(enabled), PerRepoKeys: proto.Bool(perRepo)}}
+	if _, err := e.res.Update(context.Background(), v1.SettingsScopeType_SETTINGS_SCOPE_TYPE_SYSTEM, "", patch,
+		[]string{"signing.enabled", "signing.per_repo_keys"}); err != nil {
+		t.Fatalf("update settings: %v", err)
+	}
+}
+
+// Pushes a one layer image straight to storage, returns its manifest
+func (e *testEnv) pushImage(t *testing.T, namespace, name, tag, content string) []byte {
+	t.Helper()
+	layer := []byte(content)
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    ocispec.DescriptorEmptyJSON,
+		Layers: []ocispec.Descriptor{{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromBytes(layer),
+			Size:      int64(len(layer)),
+		}},
+	}
+	manifest.SchemaVersion = 2
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := map[string][]byte{
+		ocispec.MediaTypeImageLayer:           layer,
+		ocispec.DescriptorEmptyJSON.MediaType: ocispec.DescriptorEmptyJSON.Data,
+	}
+	if _, err := e.reg.PutManifest(context.Background(), namespace, name, blobs, body, tag); err != nil {
+		t.Fatalf("push image: %v", err)
+	}
+	return body
+}
+
+func TestSignPushDisabledByDefault(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	body := e.pushImage(t, "alice", "app", "v1", "layer one")
+	e.signer.SignPush(ctx, "alice", "app", "v1", ocispec.MediaTypeImageManifest, body)
+
+	result, err := e.signer.Verify(ctx, "alice", "app", "v1")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if result.Verified || len(result.Signatures) != 0 {
+		t.Fatalf("unsigned image verified: %+v", result)
+	}
+}
+
+func TestSignVerifyAndRotate(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	e.setSigning(t, true, true)
+
+	body := e.pushImage(t, "alice", "app", "v1", "layer one")
+	e.signer.SignPush(ctx, "alice", "app", "v1", ocispec.MediaTypeImageManifest, body)
+	// A repeat push of the same manifest is not signed twice
+	e.signer.SignPush(ctx, "alice", "app", "v1", ocispec.MediaTypeImageManifest, body)
+
+	result, err := e.signer.Verify(ctx, "alice", "app", "v1")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Verified || len(result.Signatures) != 1 || !result.Signatures[0].Valid {
+		t.Fatalf("signature did not verify: %+v", result)
+	}
+	if result.Digest != digest.FromBytes(body).String() {
+		t.Fatalf("verified digest = %s", result.Digest)
+	}
+	firstKey := result.Signatures[0].KeyID
+
+	// The cosign tag points at the signature manifest
+	sigDesc, err := e.reg.ResolveManifest(ctx, "alice", "app", sigTag(digest.FromBytes(body)))
+	if err != nil || sigDesc.Digest.String() != result.Signatures[0].Digest {
+		t.Fatalf("cosign tag = %v, %v", sigDesc.Digest, err)
+	}
+
+	rotated, err := e.signer.Rotate(ctx, "alice", "app")
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if rotated.ID == firstKey || rotated.Scope != "alice/app" {
+		t.Fatalf("rotated key = %+v", rotated)
+	}
+	e.signer.SignPush(ctx, "alice", "app", "v1", ocispec.MediaTypeImageManifest, body)
+
+	result, err = e.signer.Verify(ctx, "alice", "app", digest.FromBytes(body).String())
+	if err != nil {
+		t.Fatalf("Verify by digest: %v", err)
+	}
+	if len(result.Signatures) != 2 {
+		t.Fatalf("signatures after rotation = %d", len(result.Signatures))
+	}
+	for _, sig := range result.Signatures {
+		if !sig.Valid {
+			t.Fatalf("signature %s invalid: %s", sig.Digest, sig.Error)
+		}
+		if retired := sig.KeyID == firstKey; sig.KeyRetired != retired {
+			t.Fatalf("signature %s key_retired = %v", sig.Digest, sig.KeyRetired)
+		}
+	}
+
+	keys, err := e.signer.Keys(ctx, "alice", "app")
+	if err != nil || len(keys) != 2 || !keys[0].Active || keys[1].Active || keys[1].RetiredAt == nil {
+		t.Fatalf("Keys = %+v, %v", keys, err)
+	}
+	for _, k := range keys {
+		if strings.Contains(k.PrivateKey, "PRIVATE KEY") {
+			t.Fatalf("private key stored unsealed")
+		}
+	}
+}
+
+// Documents always use the instance key, per repo keys or not
+func TestSignDocument(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	e.setSigning(t, false, true)
+
+	doc := []byte(`{"version":1}`)
+	key, sig, err := e.signer.SignDocument(ctx, doc)
+	if err != nil {
+		t.Fatalf("SignDocument: %v", err)
+	}
+	if key.Scope != "" {
+		t.Fatalf("document signed with %q scope key", key.Scope)
+	}
+	pub, err := parsePublicKey(key.PublicKey)
+	if err != nil {
+		t.Fatalf("parsePublicKey: %v", err)
+	}
+	sum := sha256.Sum256(doc)
+	if !ecdsa.VerifyASN1(pub, sum[:], sig) {
+		t.Fatal("document signature does not verify")
+	}
+	tampered := sha256.Sum256([]byte(`{"version":2}`))
+	if ecdsa.VerifyASN1(pub, tampered[:], sig) {
+		t.Fatal("signature verifies a different document")
+	}
+}
+
+func TestVerifyRejectsForeignKey(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	e.setSigning(t, true, true)
+
+	body := e.pushImage(t, "alice", "app", "v1", "layer one")
+	e.signer.SignPush(ctx, "alice", "app", "v1", ocispec.MediaTypeImageManifest, body)
+	other := e.pushImage(t, "alice", "other", "v1", "layer two")
+	e.signer.SignPush(ctx, "alice", "other", "v1", ocispec.MediaTypeImageManifest, other)
+
+	sigs, err := e.store.ListImageSignatures(ctx, "alice", "app", digest.FromBytes(body).String())
+	if err != nil || len(sigs) != 1 {
+		t.Fatalf("signatures = %v, %v", sigs, err)
+	}
+	otherKey, err := e.store.GetActiveSigningKey(ctx, "alice/other")
+	if err != nil || otherKey == nil {
+		t.Fatalf("other key = %v, %v", otherKey, err)
+	}
+	sigs[0].KeyID = otherKey.ID
+	if _, err := e.signer.check(ctx, "alice", "app", digest.FromBytes(body), sigs[0]); err == nil {
+		t.Fatal("signature checked against another repository's key")
+	}
+}
+
+func TestSignPushSkipsReferrers(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	e.setSigning(t, true, false)
+
+	body := e.pushImage(t, "alice", "app", "v1", "layer one")
+	e.signer.SignPush(ctx, "alice", "app", "v1", ocispec.MediaTypeImageManifest, body)
+	sigs, err := e.store.ListImageSignatures(ctx, "alice", "app", digest.FromBytes(body).String())
+	if err != nil || len(sigs) != 1 {
+		t.Fatalf("signatures = %v, %v", sigs, err)
+	}
+	if key, _ := e.signer.store.GetSigningKey(ctx, sigs[0].KeyID); key == nil || key.Scope != "" {
+		t.Fatalf("expected the instance key, got %+v", key)
+	}
+	if _, err := e.signer.Rotate(ctx, "alice", "app"); !errors.Is(err, ErrRepoKeysDisabled) {
+		t.Fatalf("repo rotation with per repo keys off: %v", err)
+	}
+
+	_, sigBody, err := e.reg.GetManifest(ctx, "alice", "app", digest.Digest(sigs[0].Digest))
+	if err != nil {
+		t.Fatalf("GetManifest: %v", err)
+	}
+	e.signer.SignPush(ctx, "alice", "app", "", ocispec.MediaTypeImageManifest, sigBody)
+	if again, _ := e.store.ListImageSignatures(ctx, "alice", "app", sigs[0].Digest); len(again) != 0 {
+		t.Fatalf("signature manifest was signed: %+v", again)
+	}
+}
+
+func TestReferrers(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	e.setSigning(t, true, false)
+
+	body := e.pushImage(t, "alice", "app", "v1", "layer one")
+	e.signer.SignPush(ctx, "alice", "app", "v1", ocispec.MediaTypeImageManifest, body)
+	subject := digest.FromBytes(body).String()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	handler := NewReferrers(e.signer, fakeVerifier{}).Wrap(next)
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/v2/alice/app/manifests/v1", ""); rec.Code != http.StatusTeapot {
+		t.Fatalf("non referrers route not passed through: %d", rec.Code)
+	}
+	if rec := get("/v2/alice/app/referrers/"+subject, ""); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("anonymous referrers: %d", rec.Code)
+	}
+
+	rec := get("/v2/alice/app/referrers/"+subject, "pull:alice/app")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ocispec.MediaTypeImageIndex {
+		t.Fatalf("referrers: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var index ocispec.Index
+	if err := json.NewDecoder(rec.Body).Decode(&index); err != nil {
+		t.Fatalf("decode index: %v", err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].ArtifactType != ArtifactType {
+		t.Fatalf("index = %+v", index)
+	}
+
+	rec = get("/v2/alice/app/referrers/"+subject+"?artifactType=application/vnd.example.sbom", "pull:alice/app")
+	if err := json.NewDecoder(rec.Body).Decode(&index); err != nil {
+		t.Fatalf("decode filtered index: %v", err)
+	}
+	if len(index.Manifests) != 0 || rec.Header().Get("OCI-Filters-Applied") != "artifactType" {
+		t.Fatalf("filtered index = %+v", index)
+	}
+}
@@ -0,0 +1,130 @@
+package vault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+)
+
+const sealPrefix = "v1:"
+
+var (
+	ErrNotFound = errors.New("credential not found")
+	// Credential belongs to another namespace than the one asking
+	ErrForeign = errors.New("credential belongs to another namespace")
+)
+
+// Vault seals upstream secrets with a per instance key kept beside the
+// token signing keys, the database alone never reveals them
+type Vault struct {
+	aead  cipher.AEAD
+	store *stores.Store
+}
+
+// Loads or creates the vault key under dataDir/keys
+func Open(dataDir string, store *stores.Store) (*Vault, error) {
+	keysDir := filepath.Join(dataDir, "keys")
+	if err := os.MkdirAll(keysDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create keys directory: %w", err)
+	}
+	keyPath := filepath.Join(keysDir, "vault.key")
+
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate vault key: %w", err)
+		}
+		if err := os.WriteFile(keyPath, key, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write vault key: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read vault key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("vault key %s must be 32 bytes, got %d", keyPath, len(key))
+	}
+	return newVault(key, store)
+}
+
+func newVault(key []byte, store *stores.Store) (*Vault, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{aead: aead, store: store}, nil
+}
+
+// AES-GCM with a random nonce, the credential id is bound as additional
+// data so sealed values cannot be swapped between rows
+func (v *Vault) Seal(id, plain string) (string, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := v.aead.Seal(nonce, nonce, []byte(plain), []byte(id))
+	return sealPrefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+func (v *Vault) Open(id, sealed string) (string, error) {
+	raw, ok := strings.CutPrefix(sealed, sealPrefix)
+	if !ok {
+		return "", fmt.Errorf("unknown sealed value format")
+	}
+	b, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return "", err
+	}
+	n := v.aead.NonceSize()
+	if len(b) < n {
+		return "", fmt.Errorf("sealed value too short")
+	}
+	plain, err := v.aead.Open(nil, b[:n], b[n:], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("unsealing credential %s: %w", id, err)
+	}
+	return string(plain), nil
+}
+
+// Username and secret for a credential the namespace owns
+func (v *Vault) Resolve(ctx context.Context, namespace, id string) (string, string, error) {
+	cred, err := v.store.GetCredential(ctx, id)
+	if err != nil {
+		return "", "", err
+	}
+	if cred == nil {
+		return "", "", ErrNotFound
+	}
+	if cred.Namespace != namespace {
+		return "", "", ErrForeign
+	}
+	secret, err := v.Open(cred.ID, cred.Secret)
+	if err != nil {
+		return "", "", err
+	}
+	return cred.Username, secret, nil
+}
+
+// Seals secret into the row, the id must be final
+func (v *Vault) SetSecret(cred *storage.Credential, secret string) error {
+	sealed, err := v.Seal(cred.ID, secret)
+	if err != nil {
+		return err
+	}
+	cred.Secret = sealed
+	return nil
+}
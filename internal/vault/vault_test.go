@@ -0,0 +1,76 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	storage "github.com/nickheyer/distroface/internal/db"
+	"github.com/nickheyer/distroface/internal/db/stores"
+)
+
+func TestSealRoundTripAndBinding(t *testing.T) {
+	dir := t.TempDir()
+	v, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sealed, err := v.Seal("cred-a", "hunter2")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if strings.Contains(sealed, "hunter2") {
+		t.Fatalf("sealed value leaks the plaintext: %s", sealed)
+	}
+
+	// Reopening reuses the persisted key
+	again, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got, err := again.Open("cred-a", sealed); err != nil || got != "hunter2" {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+	if _, err := again.Open("cred-b", sealed); err == nil {
+		t.Fatal("sealed value opened under another credential id")
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "keys", "vault.key")); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("vault key mode: %v %v", info, err)
+	}
+}
+
+func TestResolveScopesToNamespace(t *testing.T) {
+	store, err := stores.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	v, err := Open(t.TempDir(), store)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	ctx := context.Background()
+
+	cred := &storage.Credential{ID: "c1", Namespace: "platform", Name: "hub", Username: "bot", CreatedBy: "u1"}
+	if err := v.SetSecret(cred, "s3cret"); err != nil {
+		t.Fatalf("SetSecret: %v", err)
+	}
+	if err := store.CreateCredential(ctx, cred); err != nil {
+		t.Fatalf("CreateCredential: %v", err)
+	}
+
+	user, secret, err := v.Resolve(ctx, "platform", "c1")
+	if err != nil || user != "bot" || secret != "s3cret" {
+		t.Fatalf("Resolve = %q %q %v", user, secret, err)
+	}
+	if _, _, err := v.Resolve(ctx, "other", "c1"); !errors.Is(err, ErrForeign) {
+		t.Fatalf("foreign namespace resolved: %v", err)
+	}
+	if _, _, err := v.Resolve(ctx, "platform", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing credential: %v", err)
+	}
+}
@@ -49,6 +49,15 @@ type WebhookPayload struct {
 	Repository RepositoryPayload `json:"repository"`
 	Tag        string            `json:"tag,omitempty"`
 	Digest     string            `json:"digest,omitempty"`
+	Comment    CommentPayload    `json:"comment,omitzero"` // Comment events only
+}
+
+// CommentPayload is the comment section of a comment event.
+type CommentPayload struct {
+	ID       string `json:"id"`
+	Author   string `json:"author"`
+	AuthorID string `json:"-"` // For observers, usernames repeat across providers
+	Body     string `json:"body"`
 }
 
 // RepositoryPayload is the repository section of a webhook payload.
@@ -60,39 +69,41 @@ type RepositoryPayload struct {
 
 // Dispatcher handles async webhook delivery with retries.
 type Dispatcher struct {
-	store  *stores.Store
-	log    *logger.Logger
-	client *http.Client
+	store     *stores.Store
+	log       *logger.Logger
+	client    *http.Client
+	observers []func(context.Context, WebhookPayload)
 }
 
 // NewDispatcher creates a new webhook dispatcher.
 func NewDispatcher(store *stores.Store, log *logger.Logger, res *settings.Resolver) *Dispatcher {
-	allowPrivate := func() bool {
-		return res.System(context.Background()).GetWebhooks().GetAllowPrivateNetworks()
-	}
 	return &Dispatcher{
-		store: store,
-		log:   log,
-		client: &http.Client{
-			Timeout:   requestTimeout,
-			Transport: newSafeTransport(allowPrivate),
-		},
+		store:  store,
+		log:    log,
+		client: NewSafeClient(res),
 	}
 }
 
 // Dispatch finds all active webhooks for a repo and delivers the payload asynchronously.
 func (d *Dispatcher) Dispatch(ctx context.Context, event, namespace, name string, tag, digest string) {
-	webhooks, err := d.store.GetActiveWebhooksForRepo(ctx, namespace, name)
-	if err != nil {
-		d.log.Error("webhook: failed to get webhooks for %s/%s: %v", namespace, name, err)
-		return
-	}
+	d.send(ctx, d.payload(event, namespace, name, tag, digest))
+}
 
-	if len(webhooks) == 0 {
-		return
-	}
+// Delivers a new tag comment to the repo's comment subscribers
+func (d *Dispatcher) DispatchComment(ctx context.Context, namespace, name, tag, digest string, comment CommentPayload) {
+	payload := d.payload("comment", namespace, name, tag, digest)
+	payload.Comment = comment
+	d.send(ctx, payload)
+}
+
+// Observe registers fn to see every repository event before webhooks
+// are matched, wire observers up before the server starts
+func (d *Dispatcher) Observe(fn func(context.Context, WebhookPayload)) {
+	d.observers = append(d.observers, fn)
+}
 
-	payload := WebhookPayload{
+func (d *Dispatcher) payload(event, namespace, name, tag, digest string) WebhookPayload {
+	return WebhookPayload{
 		Event:     event,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Repository: RepositoryPayload{
@@ -103,6 +114,23 @@ func (d *Dispatcher) Dispatch(ctx context.Context, event, namespace, name string
 		Tag:    tag,
 		Digest: digest,
 	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, payload WebhookPayload) {
+	for _, fn := range d.observers {
+		fn(ctx, payload)
+	}
+
+	namespace, name, event := payload.Repository.Namespace, payload.Repository.Name, payload.Event
+	webhooks, err := d.store.GetActiveWebhooksForRepo(ctx, namespace, name)
+	if err != nil {
+		d.log.Error("webhook: failed to get webhooks for %s/%s: %v", namespace, name, err)
+		return
+	}
+
+	if len(webhooks) == 0 {
+		return
+	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -127,6 +155,32 @@ func (d *Dispatcher) Dispatch(ctx context.Context, event, namespace, name string
 	}
 }
 
+// Notify posts a system event to an admin configured url with the usual
+// retries and signing. No webhook row backs it so no deliveries are kept.
+func (d *Dispatcher) Notify(url, secret, event string, payload any) {
+	if url == "" {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.log.Error("webhook: failed to marshal %s payload: %v", event, err)
+		return
+	}
+	wh := &db.Webhook{URL: url, Secret: secret}
+	go func() {
+		for attempt := 0; attempt < maxRetries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(retryDelay(attempt))
+			}
+			delivery := d.deliver(wh, body, event)
+			if delivery.Success {
+				return
+			}
+			d.log.Warn("webhook: %s notify attempt %d/%d failed for %s (status %d)", event, attempt+1, maxRetries, url, delivery.StatusCode)
+		}
+	}()
+}
+
 // Redeliver re-sends a past delivery's payload.
 func (d *Dispatcher) Redeliver(ctx context.Context, deliveryID string) (*db.WebhookDelivery, error) {
 	delivery, err := d.store.GetWebhookDelivery(ctx, deliveryID)
@@ -219,6 +273,11 @@ func (d *Dispatcher) deliver(wh *db.Webhook, body []byte, event string) *db.Webh
 	return delivery
 }
 
+// Signature header name and value for a body signed outside the dispatcher
+func Signature(secret string, body []byte) (string, string) {
+	return signatureHeader, "sha256=" + computeHMAC(secret, body)
+}
+
 func computeHMAC(secret string, body []byte) string {
 	mac := hmac.New(sha256.New, []byte(secret))
 	mac.Write(body)
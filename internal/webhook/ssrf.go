@@ -1,11 +1,14 @@
 package webhook
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"syscall"
 	"time"
+
+	"github.com/nickheyer/distroface/internal/settings"
 )
 
 // Vet ips at dial time so dns tricks fail
@@ -36,6 +39,15 @@ func newSafeTransport(allowPrivate func() bool) http.RoundTripper {
 	}
 }
 
+// Client for other admin configured endpoints, held to the same ip
+// rules as webhooks
+func NewSafeClient(res *settings.Resolver) *http.Client {
+	allowPrivate := func() bool {
+		return res.System(context.Background()).GetWebhooks().GetAllowPrivateNetworks()
+	}
+	return &http.Client{Timeout: requestTimeout, Transport: newSafeTransport(allowPrivate)}
+}
+
 // Reject ips in forbidden ranges
 func checkWebhookIP(ip net.IP, allowPrivate bool) error {
 	switch {
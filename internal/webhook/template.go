@@ -8,7 +8,8 @@ import (
 	"text/template"
 )
 
-var templateFuncs = template.FuncMap{
+// Helpers available to payload and notification channel templates
+var TemplateFuncs = template.FuncMap{
 	"toJSON": func(v any) (string, error) {
 		b, err := json.Marshal(v)
 		if err != nil {
@@ -28,7 +29,7 @@ func ValidateTemplate(tmplStr string) error {
 		return nil
 	}
 
-	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(tmplStr)
+	tmpl, err := template.New("webhook").Funcs(TemplateFuncs).Parse(tmplStr)
 	if err != nil {
 		return fmt.Errorf("invalid template syntax: %w", err)
 	}
@@ -60,7 +61,7 @@ func RenderTemplate(tmplStr string, payload WebhookPayload) ([]byte, error) {
 		return nil, nil
 	}
 
-	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(tmplStr)
+	tmpl, err := template.New("webhook").Funcs(TemplateFuncs).Parse(tmplStr)
 	if err != nil {
 		return nil, fmt.Errorf("template parse error: %w", err)
 	}
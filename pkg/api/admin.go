@@ -0,0 +1,454 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+func newAdminCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Instance status and storage maintenance for administrators",
+	}
+	cmd.AddCommand(
+		newAdminStatusCmd(),
+		newAdminUsageCmd(),
+		newAdminForecastCmd(),
+		newAdminGCCmd(),
+		newAdminPruneUploadsCmd(),
+		newAdminVerifyStorageCmd(),
+		newAdminAlertsCmd(),
+		newAdminJobsCmd(),
+	)
+	return cmd
+}
+
+func newAdminStatusCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show instance counts, storage, uploads, and recent errors",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.GC().GetAdminSummary(cmd.Context(), connect.NewRequest(&v1.GetAdminSummaryRequest{}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			sum := resp.Msg
+			if asJSON {
+				return printProtoJSON([]proto.Message{sum})
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "Version:\t%s\n", sum.Version)
+			fmt.Fprintf(w, "Users:\t%d\n", sum.Users)
+			fmt.Fprintf(w, "Organizations:\t%d\n", sum.Organizations)
+			fmt.Fprintf(w, "Image repositories:\t%d\n", sum.Repositories)
+			fmt.Fprintf(w, "Artifact repositories:\t%d (%d artifacts)\n", sum.ArtifactRepositories, sum.Artifacts)
+			fmt.Fprintf(w, "Storage:\tregistry %s, artifacts %s\n", formatSize(sum.RegistryBytes), formatSize(sum.ArtifactBytes))
+			fmt.Fprintf(w, "Active uploads:\tregistry %d, artifacts %d\n", sum.RegistryUploads, sum.ArtifactUploads)
+
+			gc := sum.GetGc()
+			gcLine := "idle"
+			if gc.GetRunning() {
+				gcLine = "running"
+			}
+			if last := gc.GetLastRun(); last != nil {
+				gcLine += fmt.Sprintf(", last run %s", last.GetFinishedAt().AsTime().Local().Format(time.RFC3339))
+				if last.Error != "" {
+					gcLine += " failed: " + last.Error
+				}
+			}
+			if gc.GetScheduled() {
+				gcLine += fmt.Sprintf(", every %dh", gc.GetIntervalHours())
+			}
+			fmt.Fprintf(w, "GC:\t%s\n", gcLine)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if len(sum.Alerts) > 0 {
+				fmt.Println("\nFiring alerts:")
+				w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				for _, a := range sum.Alerts {
+					fmt.Fprintf(w, "  %s\t%s\tsince %s\n", a.Rule, a.Message, a.GetSince().AsTime().Local().Format(time.RFC3339))
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+
+			if len(sum.RecentErrors) > 0 {
+				fmt.Println("\nRecent errors:")
+				w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				for _, ev := range sum.RecentErrors {
+					fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", ev.GetCreatedAt().AsTime().Local().Format(time.RFC3339), ev.Actor, ev.Action, ev.Detail)
+				}
+				return w.Flush()
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newAdminUsageCmd() *cobra.Command {
+	var owner string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Show artifact storage per owner and repository against quotas",
+		Long: `List the artifact bytes each owner and repository holds next to its quota,
+largest first. Owners count each file once across their repositories,
+repositories count every artifact. Quotas come from the artifacts.quota
+settings, a user's own quota is set with AdminUpdateUser.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.GC().GetArtifactUsage(cmd.Context(), connect.NewRequest(&v1.GetArtifactUsageRequest{Owner: owner}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg})
+			}
+			quota := func(bytes, limit int64) string {
+				if limit == 0 {
+					return "unlimited"
+				}
+				return fmt.Sprintf("%s (%d%%)", formatSize(limit), bytes*100/limit)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "OWNER\tREPOS\tUSED\tQUOTA")
+			for _, o := range resp.Msg.Owners {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", o.Owner, o.Repositories, formatSize(o.Bytes), quota(o.Bytes, o.QuotaBytes))
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "REPOSITORY\tARTIFACTS\tUSED\tQUOTA")
+			for _, r := range resp.Msg.Repos {
+				fmt.Fprintf(w, "%s/%s\t%d\t%s\t%s\n", r.Namespace, r.Name, r.Artifacts, formatSize(r.Bytes), quota(r.Bytes, r.QuotaBytes))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&owner, "owner", "", "Only this user's repositories")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newAdminForecastCmd() *cobra.Command {
+	var namespace string
+	var days []int32
+	var window int32
+	var asJSON, asCSV bool
+
+	cmd := &cobra.Command{
+		Use:   "forecast",
+		Short: "Simulate artifact retention and forecast storage per repository",
+		Long: `Show what the next retention run would delete from each artifact
+repository and the storage each is expected to hold after 30 and 90 days.
+Forecasts repeat the uploads of the last --window days on the same paths
+until each horizon, then apply the repository's retention policy and
+artifact expiries to the result. Sizes count every artifact, files shared
+between versions or repositories are counted each time.
+
+  dfcli admin forecast --days 30,90,180 --csv > forecast.csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if asJSON && asCSV {
+				return fmt.Errorf("--json and --csv are mutually exclusive")
+			}
+			resp, err := client.GC().GetRetentionForecast(cmd.Context(), connect.NewRequest(&v1.GetRetentionForecastRequest{
+				Namespace:   namespace,
+				HorizonDays: days,
+				WindowDays:  window,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			f := resp.Msg
+			if asJSON {
+				return printProtoJSON([]proto.Message{f})
+			}
+			if asCSV {
+				return writeForecastCSV(f)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			header := "REPOSITORY\tRETENTION\tARTIFACTS\tUSED\tNEXT RUN FREES\tUPLOADED/DAY"
+			for _, d := range f.HorizonDays {
+				header += fmt.Sprintf("\tIN %dD", d)
+			}
+			fmt.Fprintln(w, header)
+			for _, r := range f.Repos {
+				retention := "off"
+				if r.RetentionEnabled {
+					retention = "on"
+				}
+				fmt.Fprintf(w, "%s/%s\t%s\t%d\t%s\t%s\t%s", r.Namespace, r.Name, retention, r.Artifacts, formatSize(r.Bytes),
+					formatSize(r.NextRunBytes), formatSize(int64(r.DailyUploadBytes)))
+				for _, p := range r.Forecasts {
+					cell := formatSize(p.Bytes)
+					if p.OverQuota {
+						cell += " (over quota)"
+					}
+					fmt.Fprintf(w, "\t%s", cell)
+				}
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "TOTAL\t\t\t%s\t%s\t", formatSize(f.Bytes), formatSize(f.NextRunBytes))
+			for _, t := range f.Totals {
+				fmt.Fprintf(w, "\t%s", formatSize(t.Bytes))
+			}
+			fmt.Fprintln(w)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if f.FreeBytes > 0 {
+				fmt.Printf("\n%s free on the artifact volume, upload rates from the last %d days\n", formatSize(f.FreeBytes), f.WindowDays)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Only this namespace's repositories")
+	cmd.Flags().Int32SliceVar(&days, "days", nil, "Forecast horizons in days (default 30,90)")
+	cmd.Flags().Int32Var(&window, "window", 0, "Days of upload history the rates come from (default 30)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	cmd.Flags().BoolVar(&asCSV, "csv", false, "Output one CSV row per repository with raw byte counts")
+	return cmd
+}
+
+// One row per repo, horizon columns follow the fixed ones
+func writeForecastCSV(f *v1.GetRetentionForecastResponse) error {
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"namespace", "repository", "retention", "artifacts", "bytes", "quota_bytes",
+		"next_run_artifacts", "next_run_bytes", "daily_uploads", "daily_upload_bytes"}
+	for _, d := range f.HorizonDays {
+		header = append(header, fmt.Sprintf("artifacts_%dd", d), fmt.Sprintf("bytes_%dd", d), fmt.Sprintf("over_quota_%dd", d))
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	i64 := func(n int64) string { return strconv.FormatInt(n, 10) }
+	for _, r := range f.Repos {
+		row := []string{r.Namespace, r.Name, strconv.FormatBool(r.RetentionEnabled), i64(r.Artifacts), i64(r.Bytes), i64(r.QuotaBytes),
+			i64(r.NextRunArtifacts), i64(r.NextRunBytes),
+			strconv.FormatFloat(r.DailyUploads, 'f', 2, 64), strconv.FormatFloat(r.DailyUploadBytes, 'f', 0, 64)}
+		for _, p := range r.Forecasts {
+			row = append(row, i64(p.Artifacts), i64(p.Bytes), strconv.FormatBool(p.OverQuota))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func newAdminAlertsCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "alerts",
+		Short: "Show threshold alert rules and which are firing",
+		Long: `List every enabled alert rule with its last measurement. Rules are
+configured under the alerts settings and checked by the server on an
+interval, firing and resolved changes go to the alert webhook and mail.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.GC().ListAlerts(cmd.Context(), connect.NewRequest(&v1.ListAlertsRequest{}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg})
+			}
+			if len(resp.Msg.Alerts) == 0 {
+				fmt.Println("No alert rules evaluated, enable them with the alerts settings")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "RULE\tSTATE\tVALUE\tTHRESHOLD\tSINCE\tDETAIL")
+			for _, a := range resp.Msg.Alerts {
+				state := "ok"
+				if a.Firing {
+					state = "FIRING"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\t%s\t%s\n", a.Rule, state, a.Value, a.Threshold,
+					a.GetSince().AsTime().Local().Format(time.RFC3339), a.Message)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newAdminGCCmd() *cobra.Command {
+	var dryRun, removeUntagged, noWait bool
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Run registry garbage collection",
+		Long: `Start a registry garbage collection run and wait for it to finish.
+--dry-run marks and reports without deleting anything.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			gc := client.GC()
+			before, err := gc.GetGCStatus(ctx, connect.NewRequest(&v1.GetGCStatusRequest{}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			prevStart := before.Msg.GetLastRun().GetStartedAt().AsTime()
+
+			if _, err := gc.RunGC(ctx, connect.NewRequest(&v1.RunGCRequest{DryRun: dryRun, RemoveUntagged: removeUntagged})); err != nil {
+				return rpcErr(err)
+			}
+			if noWait {
+				fmt.Println("Garbage collection started")
+				return nil
+			}
+
+			fmt.Fprint(os.Stderr, "Collecting")
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(2 * time.Second):
+				}
+				status, err := gc.GetGCStatus(ctx, connect.NewRequest(&v1.GetGCStatusRequest{}))
+				if err != nil {
+					return rpcErr(err)
+				}
+				last := status.Msg.GetLastRun()
+				if status.Msg.Running || last == nil || !last.GetStartedAt().AsTime().After(prevStart) {
+					fmt.Fprint(os.Stderr, ".")
+					continue
+				}
+				fmt.Fprintln(os.Stderr)
+
+				if last.Error != "" {
+					return fmt.Errorf("garbage collection failed: %s", last.Error)
+				}
+				verb := "Deleted"
+				if last.DryRun {
+					verb = "Would delete"
+				}
+				took := last.GetFinishedAt().AsTime().Sub(last.GetStartedAt().AsTime()).Round(time.Millisecond)
+				fmt.Printf("%s %d blobs, %s freed in %s\n", verb, last.BlobsDeleted, formatSize(last.BytesFreed), took)
+				return nil
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be deleted without deleting")
+	cmd.Flags().BoolVar(&removeUntagged, "remove-untagged", false, "Also delete manifests no tag references")
+	cmd.Flags().BoolVar(&noWait, "no-wait", false, "Return once the run has started")
+	return cmd
+}
+
+func newAdminPruneUploadsCmd() *cobra.Command {
+	var dryRun bool
+	var olderThan time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "prune-uploads",
+		Short: "Remove abandoned registry and artifact upload sessions",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if olderThan < 0 || (olderThan > 0 && olderThan < time.Hour) {
+				return fmt.Errorf("--older-than must be at least 1h")
+			}
+			resp, err := client.GC().PruneUploads(cmd.Context(), connect.NewRequest(&v1.PruneUploadsRequest{
+				DryRun:         dryRun,
+				OlderThanHours: int32(olderThan / time.Hour),
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			verb := "Removed"
+			if dryRun {
+				verb = "Would remove"
+			}
+			fmt.Printf("%s %d registry and %d artifact upload sessions, %s\n",
+				verb, resp.Msg.RegistrySessions, resp.Msg.ArtifactSessions, formatSize(resp.Msg.BytesFreed))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report without removing")
+	cmd.Flags().DurationVar(&olderThan, "older-than", 0, "Idle age cutoff (default the server's stale upload setting)")
+	return cmd
+}
+
+func newAdminVerifyStorageCmd() *cobra.Command {
+	var registry, artifacts bool
+
+	cmd := &cobra.Command{
+		Use:   "verify-storage",
+		Short: "Rehash stored blobs and report corrupt or missing ones",
+		Long: `Rehash every registry blob and every artifact blob still referenced,
+reporting corrupt, missing, and unreadable blobs as they are found.
+Checks both stores unless --registry or --artifacts narrows it. Exits
+non-zero when any problem is found.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stream, err := client.GC().VerifyStorage(cmd.Context(), connect.NewRequest(&v1.VerifyStorageRequest{
+				Registry:  registry,
+				Artifacts: artifacts,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			defer stream.Close()
+
+			problems := map[string]int{}
+			failed := 0
+			for stream.Receive() {
+				ev := stream.Msg()
+				switch {
+				case ev.Problem != nil:
+					p := ev.Problem
+					line := fmt.Sprintf("%s %s %s", strings.ToUpper(p.Kind), ev.Scope, p.Digest)
+					if p.Detail != "" {
+						line += " (" + p.Detail + ")"
+					}
+					fmt.Fprintf(os.Stderr, "\r\033[K")
+					fmt.Println(line)
+					problems[ev.Scope]++
+					failed++
+				case ev.Done:
+					fmt.Fprintf(os.Stderr, "\r\033[K")
+					fmt.Printf("%s: %d blobs, %s checked, %d problems\n",
+						ev.Scope, ev.Checked, formatSize(ev.BytesChecked), problems[ev.Scope])
+				default:
+					fmt.Fprintf(os.Stderr, "\r\033[K%s: %d/%d blobs, %s", ev.Scope, ev.Checked, ev.Total, formatSize(ev.BytesChecked))
+				}
+			}
+			if err := stream.Err(); err != nil {
+				return rpcErr(err)
+			}
+			if failed > 0 {
+				return fmt.Errorf("storage verification found %d problems", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&registry, "registry", false, "Verify registry blobs")
+	cmd.Flags().BoolVar(&artifacts, "artifacts", false, "Verify artifact blobs")
+	return cmd
+}
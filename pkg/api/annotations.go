@@ -0,0 +1,156 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// CI systems --ci-annotations speaks to
+const (
+	ciOff    = "off"
+	ciAuto   = "auto"
+	ciGitHub = "github"
+	ciGitLab = "gitlab"
+)
+
+func validateCIAnnotations(v string) error {
+	switch v {
+	case ciOff, ciAuto, ciGitHub, ciGitLab:
+		return nil
+	}
+	return fmt.Errorf("want off, auto, github or gitlab")
+}
+
+// Writes pipeline native markers around and about transfers. Github gets
+// workflow commands, gitlab collapsible log sections and colored lines.
+// They go to stderr so piped output stays clean, both runners read it
+type annotator struct {
+	kind  string
+	w     io.Writer
+	group string // Open group or section, empty when none
+}
+
+// Set per run from --ci-annotations, nil writes nothing
+var ci *annotator
+
+// Auto picks the system from the variables each runner sets
+func newAnnotator(mode string) *annotator {
+	if mode == ciAuto {
+		switch {
+		case os.Getenv("GITHUB_ACTIONS") == "true":
+			mode = ciGitHub
+		case os.Getenv("GITLAB_CI") == "true":
+			mode = ciGitLab
+		}
+	}
+	if mode != ciGitHub && mode != ciGitLab {
+		return nil
+	}
+	return &annotator{kind: mode, w: os.Stderr}
+}
+
+// Opens a collapsible group, the returned func closes it. Annotations
+// belong after it so they stay visible
+func (a *annotator) begin(name, title string) func() {
+	if a == nil {
+		return func() {}
+	}
+	a.end()
+	a.group = gitlabSectionName(name)
+	if a.kind == ciGitHub {
+		fmt.Fprintf(a.w, "::group::%s\n", githubData(title))
+	} else {
+		fmt.Fprintf(a.w, "\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", time.Now().Unix(), a.group, title)
+	}
+	return a.end
+}
+
+func (a *annotator) end() {
+	if a == nil || a.group == "" {
+		return
+	}
+	if a.kind == ciGitHub {
+		fmt.Fprintln(a.w, "::endgroup::")
+	} else {
+		fmt.Fprintf(a.w, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", time.Now().Unix(), a.group)
+	}
+	a.group = ""
+}
+
+func (a *annotator) notice(title, msg string) { a.annotate("notice", title, msg) }
+
+func (a *annotator) warning(title, msg string) { a.annotate("warning", title, msg) }
+
+func (a *annotator) error(title, msg string) { a.annotate("error", title, msg) }
+
+func (a *annotator) annotate(level, title, msg string) {
+	if a == nil {
+		return
+	}
+	if a.kind == ciGitHub {
+		fmt.Fprintf(a.w, "::%s title=%s::%s\n", level, githubProperty(title), githubData(msg))
+		return
+	}
+	switch level {
+	case "error":
+		fmt.Fprintf(a.w, "\x1b[31;1m%s: %s\x1b[0m\n", title, msg)
+	case "warning":
+		fmt.Fprintf(a.w, "\x1b[33;1m%s: %s\x1b[0m\n", title, msg)
+	default:
+		fmt.Fprintf(a.w, "\x1b[32;1m%s:\x1b[0m %s\n", title, msg)
+	}
+}
+
+// Workflow command escaping, messages keep their line breaks
+func githubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func githubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// Gitlab takes lowercase letters, digits, dots, dashes and underscores
+func gitlabSectionName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, name)
+}
+
+// Title an error is filed under, policy denials and scan verdicts get
+// their own so pipelines can tell them from transport failures
+func errorTitle(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "denied by policy"):
+		return "Policy denied"
+	case strings.Contains(msg, "quarantined"):
+		return "Malware scan"
+	}
+	return "Error"
+}
+
+// Prints a failed command's error, annotated when --ci-annotations is on
+func ReportError(err error) {
+	if ci == nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	ci.end()
+	title := errorTitle(err)
+	if ci.kind == ciGitLab {
+		ci.error(title, err.Error())
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	ci.error(title, err.Error())
+}
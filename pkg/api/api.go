@@ -1,14 +1,21 @@
 package api
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +32,7 @@ const maxPageSize = 500
 type Artifact struct {
 	ID         string            `json:"id"`
 	RepoID     int64             `json:"repo_id"`
+	Repository string            `json:"repository,omitempty"`
 	Name       string            `json:"name"`
 	Path       string            `json:"path"`
 	UploadID   string            `json:"upload_id"`
@@ -33,8 +41,11 @@ type Artifact struct {
 	MimeType   string            `json:"mime_type"`
 	Metadata   string            `json:"metadata"`
 	Properties map[string]string `json:"properties"`
+	ScanStatus string            `json:"scan_status,omitempty"`
 	CreatedAt  time.Time         `json:"created_at"`
 	UpdatedAt  time.Time         `json:"updated_at"`
+
+	digest string // Checksum the server recorded, outside the v1 shape
 }
 
 // Keeps the v1 cli repo JSON shape
@@ -46,6 +57,7 @@ type ArtifactRepository struct {
 	Description string    `json:"description"`
 	Owner       string    `json:"owner"`
 	Private     bool      `json:"private"`
+	OCIName     string    `json:"oci_name,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -75,6 +87,7 @@ func artifactFromProto(a *v1.Artifact) Artifact {
 	return Artifact{
 		ID:         a.GetId(),
 		RepoID:     a.GetRepoId(),
+		Repository: a.GetRepoFullName(),
 		Name:       a.GetName(),
 		Path:       a.GetPath(),
 		UploadID:   a.GetUploadId(),
@@ -83,8 +96,10 @@ func artifactFromProto(a *v1.Artifact) Artifact {
 		MimeType:   a.GetMimeType(),
 		Metadata:   a.GetMetadata(),
 		Properties: props,
+		ScanStatus: a.GetScan().GetStatus(),
 		CreatedAt:  protoTime(a.GetCreatedAt()),
 		UpdatedAt:  protoTime(a.GetUpdatedAt()),
+		digest:     a.GetDigest(),
 	}
 }
 
@@ -97,6 +112,7 @@ func repoFromProto(r *v1.ArtifactRepository) ArtifactRepository {
 		Description: r.GetDescription(),
 		Owner:       r.GetOwner(),
 		Private:     r.GetIsPrivate(),
+		OCIName:     r.GetOciName(),
 		CreatedAt:   protoTime(r.GetCreatedAt()),
 		UpdatedAt:   protoTime(r.GetUpdatedAt()),
 	}
@@ -134,12 +150,14 @@ func (r RepoRef) basePath() string {
 
 // ── Repositories ─────────────────────────────────────────────────────────
 
-func (c *Client) createArtifactRepo(ctx context.Context, ref RepoRef, description string, private bool) (ArtifactRepository, error) {
+func (c *Client) createArtifactRepo(ctx context.Context, ref RepoRef, repoType v1.ArtifactRepoType, description string, private, ociExport bool) (ArtifactRepository, error) {
 	resp, err := c.Artifacts().CreateArtifactRepository(ctx, connect.NewRequest(&v1.CreateArtifactRepositoryRequest{
 		Name:        ref.Name,
 		Namespace:   ref.Namespace,
+		Type:        repoType,
 		Description: description,
 		IsPrivate:   private,
+		OciExport:   ociExport,
 	}))
 	if err != nil {
 		return ArtifactRepository{}, rpcErr(err)
@@ -171,50 +189,119 @@ func (c *Client) listArtifactRepos(ctx context.Context, namespace string) ([]Art
 
 // ── Artifacts ────────────────────────────────────────────────────────────
 
-// Rpc bookends the transfer, bytes stream over http
-func (c *Client) uploadArtifact(ctx context.Context, ref RepoRef, filePath, version, artifactPath string, properties map[string]string) error {
+// Rpc bookends the transfer, bytes stream over http from src, which may
+// be a pipe of unknown length. A known size is reserved up front so a full
+// server refuses before any bytes move, zero for a pipe
+func (c *Client) uploadArtifact(ctx context.Context, ref RepoRef, src io.Reader, size int64, version, artifactPath string, properties map[string]string, ifNotExists, overwrite bool, expiresIn time.Duration) (*v1.CompleteArtifactUploadResponse, error) {
+	if err := c.requireCapability(ctx, "artifacts.resumable_uploads", "artifact upload"); err != nil {
+		return nil, err
+	}
 	rpc := c.Artifacts()
 
 	initResp, err := rpc.InitiateArtifactUpload(ctx, connect.NewRequest(&v1.InitiateArtifactUploadRequest{
 		RepoName:  ref.Name,
 		Namespace: ref.Namespace,
+		Size:      size,
 	}))
 	if err != nil {
-		return rpcErr(err)
+		return nil, rpcErr(err)
 	}
 	uploadURL := initResp.Msg.GetUploadUrl()
 	if uploadURL == "" {
-		return fmt.Errorf("server did not return an upload location")
-	}
-
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
+		return nil, fmt.Errorf("server did not return an upload location")
 	}
-	defer file.Close()
 
-	resp, err := c.doData(ctx, http.MethodPatch, uploadURL, file)
+	// The server checks what landed against what was read here
+	sum := sha256.New()
+	resp, err := c.doData(ctx, http.MethodPatch, uploadURL, io.TeeReader(src, sum))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	resp.Body.Close()
 
-	_, err = rpc.CompleteArtifactUpload(ctx, connect.NewRequest(&v1.CompleteArtifactUploadRequest{
-		RepoName:   ref.Name,
-		Namespace:  ref.Namespace,
-		UploadId:   initResp.Msg.GetUploadId(),
-		Version:    version,
-		Path:       artifactPath,
-		Properties: properties,
+	done, err := rpc.CompleteArtifactUpload(ctx, connect.NewRequest(&v1.CompleteArtifactUploadRequest{
+		RepoName:         ref.Name,
+		Namespace:        ref.Namespace,
+		UploadId:         initResp.Msg.GetUploadId(),
+		Version:          version,
+		Path:             artifactPath,
+		Properties:       properties,
+		IfNotExists:      ifNotExists,
+		Overwrite:        overwrite,
+		Sha256:           hex.EncodeToString(sum.Sum(nil)),
+		ExpiresInSeconds: int64(expiresIn / time.Second),
 	}))
 	if err != nil {
-		return rpcErr(err)
+		return nil, rpcErr(err)
 	}
-	return nil
+	return done.Msg, nil
 }
 
 // Archive streaming has no rpc, the v1 query route is the data plane
+// Streams the repo changelog after the cursor into w, returns the lines written
+func (c *Client) exportChangelog(ctx context.Context, ref RepoRef, after int64, limit int, w io.Writer) (int, error) {
+	q := url.Values{}
+	q.Set("after", strconv.FormatInt(after, 10))
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	resp, err := c.doData(ctx, http.MethodGet, ref.basePath()+"/changelog?"+q.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Whole lines only, a cut off stream leaves the file resumable
+	n := 0
+	br := bufio.NewReader(resp.Body)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if _, err := w.Write(line); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Cursor of the last line in an export file, zero when it has none yet
+func lastChangelogCursor(path string) (int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var cursor int64
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var line struct {
+			Cursor int64 `json:"cursor"`
+		}
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			return 0, fmt.Errorf("%s is not a changelog export: %w", path, err)
+		}
+		cursor = line.Cursor
+	}
+	return cursor, sc.Err()
+}
+
 func (c *Client) downloadArtifacts(ctx context.Context, ref RepoRef, q url.Values, outputPath string, unpack, flat bool, format string) error {
+	if err := c.requireArchiveFormat(ctx, format); err != nil {
+		return err
+	}
 	endpoint := ref.basePath() + "/query"
 	if len(q) > 0 {
 		endpoint += "?" + q.Encode()
@@ -226,18 +313,33 @@ func (c *Client) downloadArtifacts(ctx context.Context, ref RepoRef, q url.Value
 	}
 	defer resp.Body.Close()
 
-	tempFile, err := os.CreateTemp("", "dfcli-download-*")
+	// "-" pipes the archive straight through, nothing touches disk
+	if outputPath == "-" {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return err
+	}
+	scratch, cleanup, err := newScratchDir(filepath.Dir(filepath.Clean(outputPath)))
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tempFile.Name())
+	defer cleanup()
+	if err := ensureFree(scratch, resp.ContentLength); err != nil {
+		return err
+	}
 
+	tempFile, err := os.CreateTemp(scratch, "download-*")
+	if err != nil {
+		return err
+	}
 	if _, err := io.Copy(tempFile, resp.Body); err != nil {
+		tempFile.Close()
 		return err
 	}
-	tempFile.Close()
-
-	if err := os.MkdirAll(outputPath, 0755); err != nil {
+	if err := tempFile.Close(); err != nil {
 		return err
 	}
 
@@ -255,12 +357,13 @@ func (c *Client) downloadArtifacts(ctx context.Context, ref RepoRef, q url.Value
 	return nil
 }
 
-func (c *Client) deleteArtifact(ctx context.Context, ref RepoRef, version, path string) error {
+func (c *Client) deleteArtifact(ctx context.Context, ref RepoRef, version, path, reason string) error {
 	_, err := c.Artifacts().DeleteArtifact(ctx, connect.NewRequest(&v1.DeleteArtifactRequest{
 		RepoName:  ref.Name,
 		Namespace: ref.Namespace,
 		Version:   version,
 		Path:      path,
+		Reason:    reason,
 	}))
 	if err != nil {
 		return rpcErr(err)
@@ -281,14 +384,15 @@ type SearchOptions struct {
 	Version    string
 	Path       string
 	Properties map[string]string
-	Num        int // Zero fetches everything
+	Filters    []*v1.FieldFilter // Extra predicates beside the contains filters above
+	Num        int               // Zero fetches everything
 	Offset     int
 	Sort       string
 	Order      string
 }
 
 func (o SearchOptions) query() *v1.Query {
-	q := &v1.Query{}
+	q := &v1.Query{Filters: slices.Clone(o.Filters)}
 	for _, f := range []struct{ field, value string }{
 		{"name", o.Name}, {"version", o.Version}, {"path", o.Path},
 	} {
@@ -365,3 +469,60 @@ func (c *Client) searchArtifacts(ctx context.Context, opts SearchOptions) (*Sear
 		Order:   order,
 	}, nil
 }
+
+// Filters for a delete by search, globs use path.Match syntax
+type deleteCriteria struct {
+	name      string
+	version   string
+	path      string
+	props     map[string]string
+	olderThan time.Duration
+}
+
+// Artifacts of one repository matching every criterion, oldest first. The
+// literal head of each glob narrows the search server side
+func (c *Client) matchArtifacts(ctx context.Context, ref RepoRef, crit deleteCriteria) ([]Artifact, error) {
+	globs := []struct{ field, pattern string }{
+		{"name", crit.name}, {"version", crit.version}, {"path", crit.path},
+	}
+	opts := SearchOptions{Ref: ref, Properties: crit.props, Sort: "created_at", Order: "ASC"}
+	for _, g := range globs {
+		if g.pattern == "" {
+			continue
+		}
+		if _, err := path.Match(g.pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q", g.field, g.pattern)
+		}
+		head := g.pattern[:strings.IndexAny(g.pattern+"*", "*?[\\")]
+		switch {
+		case head == g.pattern:
+			opts.Filters = append(opts.Filters, &v1.FieldFilter{Field: g.field, Match: v1.MatchKind_MATCH_KIND_EQUALS, Value: head})
+		case head != "":
+			opts.Filters = append(opts.Filters, &v1.FieldFilter{Field: g.field, Match: v1.MatchKind_MATCH_KIND_PREFIX, Value: head})
+		}
+	}
+	search, err := c.searchArtifacts(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-crit.olderThan)
+	var out []Artifact
+	for _, a := range search.Results {
+		if crit.olderThan > 0 && !a.CreatedAt.Before(cutoff) {
+			continue
+		}
+		match := true
+		for _, g := range []struct{ pattern, value string }{
+			{crit.name, a.Name}, {crit.version, a.Version}, {crit.path, a.Path},
+		} {
+			if ok, _ := path.Match(g.pattern, g.value); g.pattern != "" && !ok {
+				match = false
+			}
+		}
+		if match {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
@@ -153,7 +153,13 @@ func recursivelyUnpack(archivePath, destPath string, flat bool) error {
 
 	var files []string
 	err := filepath.Walk(destPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != destPath && strings.HasPrefix(info.Name(), scratchPrefix) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		files = append(files, path)
@@ -169,12 +175,12 @@ func recursivelyUnpack(archivePath, destPath string, flat bool) error {
 			continue
 		}
 
-		tempDir, err := os.MkdirTemp("", "dfcli-nested-*")
+		tempDir, cleanup, err := newScratchDir(filepath.Dir(filepath.Clean(destPath)))
 		if err != nil {
 			continue
 		}
 		if err := recursivelyUnpack(path, tempDir, flat); err != nil {
-			os.RemoveAll(tempDir)
+			cleanup()
 			continue
 		}
 
@@ -194,7 +200,7 @@ func recursivelyUnpack(archivePath, destPath string, flat bool) error {
 			}
 			return moveFile(srcPath, targetPath)
 		})
-		os.RemoveAll(tempDir)
+		cleanup()
 		if err != nil {
 			continue
 		}
@@ -1,15 +1,23 @@
 package api
 
 import (
+	"cmp"
 	"fmt"
+	"io"
+	"maps"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
 )
 
 func newArtifactCmd() *cobra.Command {
@@ -19,8 +27,8 @@ func newArtifactCmd() *cobra.Command {
 		Long: `Manage artifacts and artifact repositories.
 
 Repositories are addressed as [namespace/]name. Bare names resolve on
-the server, your own namespace first, then the unique visible match,
-qualify the name when it is ambiguous.`,
+the server to your default namespace, your own unless changed with
+'dfcli user default-namespace'.`,
 	}
 	cmd.AddCommand(
 		newArtifactRepoCreateCmd(),
@@ -28,7 +36,14 @@ qualify the name when it is ambiguous.`,
 		newArtifactUploadCmd(),
 		newArtifactDownloadCmd(),
 		newArtifactDeleteCmd(),
+		newArtifactExpireCmd(),
 		newArtifactSearchCmd(),
+		newArtifactPropsCmd(),
+		newArtifactVerifyCmd(),
+		newArtifactLatestCmd(),
+		newArtifactChangelogCmd(),
+		newArtifactCacheCmd(),
+		newPermissionsCmd(),
 	)
 	return cmd
 }
@@ -42,32 +57,65 @@ func repoArg(arg, namespace string) RepoRef {
 	return ref
 }
 
+// Repo type presets by the names the create command takes
+var artifactRepoTypes = map[string]v1.ArtifactRepoType{
+	"generic": v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_FILE,
+	"file":    v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_FILE,
+	"debian":  v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN,
+	"deb":     v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN,
+	"rpm":     v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_RPM,
+}
+
 func newArtifactRepoCreateCmd() *cobra.Command {
-	var description, namespace string
-	var private bool
+	var description, namespace, typeName string
+	var private, ociExport bool
 
 	cmd := &cobra.Command{
 		Use:   "create [repo]",
 		Short: "Create a new artifact repository",
 		Long: `Create an artifact repository. Bare names land in your personal
-namespace, use org/name or --namespace to target an organization.`,
+namespace, use org/name or --namespace to target an organization.
+With --oci-export each version can also be pulled read only by OCI clients
+such as oras as namespace/artifacts/name:version.
+
+--type picks a preset. generic takes any file. debian takes .deb packages
+only and serves them as a signed apt source, rpm takes .rpm packages only
+and serves them as a signed yum repository. Package uploads must use the
+package's own version, the server files them under the standard layout.`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			repoType, ok := artifactRepoTypes[strings.ToLower(typeName)]
+			if !ok {
+				return fmt.Errorf("unknown repository type %q, use generic, debian or rpm", typeName)
+			}
 			ref := parseRepoRef(args[0])
 			if ref.Namespace == "" {
 				ref.Namespace = namespace
 			}
-			repo, err := client.createArtifactRepo(cmd.Context(), ref, description, private)
+			repo, err := client.createArtifactRepo(cmd.Context(), ref, repoType, description, private, ociExport)
 			if err != nil {
 				return fmt.Errorf("failed to create repository: %w", err)
 			}
 			fmt.Printf("Created repository %s\n", repo.FullName)
+			if repo.OCIName != "" {
+				fmt.Printf("OCI name: %s\n", repo.OCIName)
+			}
+			switch repoType {
+			case v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_DEBIAN:
+				fmt.Printf("APT source: deb [signed-by=/etc/apt/keyrings/%s.asc] %s/apt/%s stable main\n", repo.Name, client.BaseURL, repo.FullName)
+				fmt.Printf("Signing key: %s/apt/%s/key.asc\n", client.BaseURL, repo.FullName)
+			case v1.ArtifactRepoType_ARTIFACT_REPO_TYPE_RPM:
+				fmt.Printf("Yum baseurl: %s/yum/%s\n", client.BaseURL, repo.FullName)
+				fmt.Printf("Signing key: %s/yum/%s/repodata/repomd.xml.key\n", client.BaseURL, repo.FullName)
+			}
 			return nil
 		},
 	}
 
+	cmd.Flags().StringVar(&typeName, "type", "generic", "Repository type: generic, debian or rpm")
 	cmd.Flags().StringVarP(&description, "description", "d", "", "Repository description")
 	cmd.Flags().BoolVarP(&private, "private", "p", false, "Make repository private")
+	cmd.Flags().BoolVar(&ociExport, "oci-export", false, "Serve versions read only under /v2 as OCI artifacts")
 	cmd.Flags().StringVar(&namespace, "namespace", "", "Target namespace (user or organization)")
 	return cmd
 }
@@ -92,31 +140,106 @@ func newArtifactRepoListCmd() *cobra.Command {
 }
 
 func newArtifactUploadCmd() *cobra.Command {
-	var version, path, namespace string
+	var version, path, namespace, expires string
 	var properties map[string]string
+	var ifNotExists, overwrite bool
 
 	cmd := &cobra.Command{
-		Use:   "upload [repo] [file]",
+		Use:   "upload [repo] file",
 		Short: "Upload an artifact",
-		Args:  cobra.ExactArgs(2),
+		Long: `Upload a file as an artifact. A file of - reads the content from stdin,
+which needs --path since there is no file name to default to:
+
+  pg_dump mydb | dfcli artifact upload backups - --path mydb.sql
+
+The repo may be left out when a default is set with dfcli config set
+artifact.repo NAME.`,
+		Args:        cobra.RangeArgs(1, 2),
+		Annotations: map[string]string{repoDefaultAnnotation: "true"},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			args, err := withDefaultRepo(cmd, args, 2)
+			if err != nil {
+				return err
+			}
 			ref := repoArg(args[0], namespace)
 			file := args[1]
+			stdin := file == "-"
+			ttl, err := parseAge(expires)
+			if err != nil {
+				return err
+			}
 
+			name := filepath.Base(file)
+			if stdin {
+				if path == "" {
+					return fmt.Errorf("--path is required when uploading from stdin")
+				}
+				name = filepath.Base(path)
+			}
 			if version == "" {
-				version = filepath.Base(file)
+				version = name
 			}
 			version = sanitizeVersion(version)
 			if path == "" {
-				path = filepath.Base(file)
+				path = name
 			}
 			path = sanitizeFilePath(path)
 
+			// Matching content already there skips the transfer entirely,
+			// the server rechecks on complete in case of a concurrent push.
+			// Stdin can only be read once, so that case leaves it to the server
+			if ifNotExists && !stdin {
+				exists, same, err := client.artifactAtPath(cmd.Context(), ref, version, path, file)
+				if err != nil {
+					return err
+				}
+				if same {
+					fmt.Printf("%s %s already exists with the same checksum, skipping\n", version, path)
+					return nil
+				}
+				if exists && !overwrite {
+					return fmt.Errorf("%s %s already exists with different content (use --overwrite to replace it)", version, path)
+				}
+			}
+
+			var src io.Reader = os.Stdin
+			var size int64
+			if stdin {
+				file = "stdin"
+			} else {
+				f, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				info, err := f.Stat()
+				if err != nil {
+					return err
+				}
+				src, size = f, info.Size()
+			}
+
+			endGroup := ci.begin("dfcli_upload", fmt.Sprintf("Upload %s to %s", file, ref))
 			fmt.Printf("Uploading %s to %s (version: %s, path: %s)\n", file, ref, version, path)
-			if err := client.uploadArtifact(cmd.Context(), ref, file, version, path, properties); err != nil {
+			resp, err := client.uploadArtifact(cmd.Context(), ref, src, size, version, path, properties, ifNotExists, overwrite, ttl)
+			if err != nil {
 				return fmt.Errorf("upload failed: %w", err)
 			}
+			endGroup()
+			a := resp.GetArtifact()
+			if resp.Skipped {
+				fmt.Printf("%s %s already exists with the same checksum, skipping\n", version, path)
+				ci.notice("Upload skipped", fmt.Sprintf("%s %s %s already holds the same checksum", ref, version, path))
+				return nil
+			}
 			fmt.Println("Upload successful")
+			if a.GetExpiresAt() != nil {
+				fmt.Printf("Expires %s\n", formatTimestamp(a.GetExpiresAt()))
+			}
+			ci.notice("Uploaded", fmt.Sprintf("%s to %s %s %s (%s)", file, ref, a.GetVersion(), a.GetPath(), shortDigest(a.GetDigest())))
+			if a.GetScan().GetStatus() == "infected" {
+				ci.warning("Malware scan", fmt.Sprintf("%s %s %s matched %s", ref, a.GetVersion(), a.GetPath(), a.GetScan().GetSignature()))
+			}
 			return nil
 		},
 	}
@@ -124,6 +247,9 @@ func newArtifactUploadCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&version, "version", "v", "", "Artifact version")
 	cmd.Flags().StringVarP(&path, "path", "p", "", "Artifact path in repository")
 	cmd.Flags().StringToStringVar(&properties, "property", nil, "Properties (key=value,key=value,...)")
+	cmd.Flags().BoolVar(&ifNotExists, "if-not-exists", false, "Succeed without uploading when version and path already hold the same checksum")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "Replace every artifact at version and path, whatever its properties")
+	cmd.Flags().StringVar(&expires, "expires", "", "Delete the artifact after this long, e.g. 14d or 36h")
 	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
 	return cmd
 }
@@ -141,14 +267,60 @@ func newArtifactDownloadCmd() *cobra.Command {
 		format    string
 		unpack    bool
 		flat      bool
+		parallel  int
 	)
 
 	cmd := &cobra.Command{
 		Use:   "download [repo]",
 		Short: "Download artifacts via query",
-		Args:  cobra.ExactArgs(1),
+		Long: `Download the artifacts matching a query. By default the server builds
+one archive of the whole result set. --parallel N searches first and then
+fetches every matching file separately with N workers, writing them to
+version/path under the output directory (or by file name with --flat).
+In that mode --num defaults to every match and --path selects a file or
+directory. An output of - writes the archive to stdout. The repo may be
+left out when a default is set with dfcli config set artifact.repo NAME.
+
+With a download cache (--download-cache DIR or dfcli config set
+download_cache DIR) --parallel keeps every file it fetched by checksum.
+Later runs ask the server whether the checksum still holds and copy the
+file from the cache when it does, matrix builds on one host then pull
+each artifact once.`,
+		Args:        cobra.RangeArgs(0, 1),
+		Annotations: map[string]string{repoDefaultAnnotation: "true"},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			args, err := withDefaultRepo(cmd, args, 1)
+			if err != nil {
+				return err
+			}
 			ref := repoArg(args[0], namespace)
+			if output == "" {
+				output = "."
+			}
+			if output == "-" && (parallel > 0 || unpack) {
+				return fmt.Errorf("-o - streams the archive to stdout, --parallel and --unpack do not apply")
+			}
+			endGroup := ci.begin("dfcli_download", fmt.Sprintf("Download %s", ref))
+			if parallel > 0 {
+				if unpack || cmd.Flags().Changed("format") {
+					return fmt.Errorf("--parallel downloads plain files, --format and --unpack do not apply")
+				}
+				err := client.downloadParallel(cmd.Context(), SearchOptions{
+					Ref:        ref,
+					Version:    version,
+					Path:       artPath,
+					Properties: props,
+					Num:        num,
+					Sort:       sortBy,
+					Order:      order,
+				}, output, flat, parallel)
+				if err != nil {
+					return err
+				}
+				endGroup()
+				ci.notice("Downloaded", fmt.Sprintf("%s to %s", ref, output))
+				return nil
+			}
 
 			q := make(url.Values)
 			for key, value := range props {
@@ -172,49 +344,150 @@ func newArtifactDownloadCmd() *cobra.Command {
 			if format != "" {
 				q.Set("format", format)
 			}
-			if flat {
-				q.Set("flat", "1")
+			// Unset flags leave the choice to the repo's query defaults
+			if cmd.Flags().Changed("flat") {
+				q.Set("flat", "0")
+				if flat {
+					q.Set("flat", "1")
+				}
 			}
 
-			if output == "" {
-				output = "."
+			if err := client.downloadArtifacts(cmd.Context(), ref, q, output, unpack, flat, format); err != nil {
+				return err
 			}
-			return client.downloadArtifacts(cmd.Context(), ref, q, output, unpack, flat, format)
+			endGroup()
+			ci.notice("Downloaded", fmt.Sprintf("%s to %s", ref, output))
+			return nil
 		},
 	}
 
 	cmd.Flags().StringVarP(&version, "version", "v", "", "Artifact version filter")
 	cmd.Flags().StringVarP(&artPath, "path", "p", "", "Path inside artifact version")
 	cmd.Flags().StringToStringVar(&props, "property", nil, "Properties (key=value)")
-	cmd.Flags().StringVarP(&output, "output", "o", "", "Output path (file or directory)")
-	cmd.Flags().IntVar(&num, "num", 1, "Number of matching artifacts")
-	cmd.Flags().StringVar(&sortBy, "sort", "", "Sort field")
-	cmd.Flags().StringVar(&order, "order", "", "Sort order (ASC/DESC)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output path (file or directory, - for stdout)")
+	cmd.Flags().IntVar(&num, "num", 0, "Number of matching artifacts (default: the repository's, normally 1)")
+	cmd.Flags().StringVar(&sortBy, "sort", "", "Sort field (default: the repository's, normally created_at)")
+	cmd.Flags().StringVar(&order, "order", "", "Sort order ASC/DESC (default: the repository's, normally DESC)")
 	cmd.Flags().StringVar(&format, "format", "zip", "Archive format (zip/tar.gz)")
 	cmd.Flags().BoolVar(&unpack, "unpack", false, "Unpack downloaded archives")
-	cmd.Flags().BoolVar(&flat, "flat", false, "Flatten directory structure")
+	cmd.Flags().BoolVar(&flat, "flat", false, "Flatten directory structure (default: the repository's)")
+	cmd.Flags().IntVar(&parallel, "parallel", 0, "Fetch matching files individually with this many workers instead of one archive")
 	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
 	return cmd
 }
 
 func newArtifactDeleteCmd() *cobra.Command {
-	var namespace string
+	var (
+		namespace string
+		reason    string
+		name      string
+		version   string
+		artPath   string
+		props     map[string]string
+		olderThan string
+		dryRun    bool
+		yes       bool
+	)
 
 	cmd := &cobra.Command{
 		Use:   "delete [repo] [version] [path]",
-		Short: "Delete an artifact",
-		Args:  cobra.ExactArgs(3),
+		Short: "Delete an artifact, or every artifact matching filters",
+		Long: `With a version and path deletes that one artifact. Given only the
+repository, deletes every artifact matching the filters. Name, version
+and path accept * and ? globs, --older-than takes durations or days.
+
+  dfcli artifact delete builds --version '1.*' --property branch=feature-x --older-than 30d --dry-run`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 && len(args) != 3 {
+				return fmt.Errorf("accepts a repository, optionally followed by version and path")
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ref := repoArg(args[0], namespace)
-			if err := client.deleteArtifact(cmd.Context(), ref, args[1], args[2]); err != nil {
-				return fmt.Errorf("failed to delete artifact: %w", err)
+			filtered := name != "" || version != "" || artPath != "" || len(props) > 0 || olderThan != ""
+			if len(args) == 3 {
+				if filtered || dryRun {
+					return fmt.Errorf("filters and --dry-run only apply when deleting by search, drop the version and path")
+				}
+				if err := client.deleteArtifact(cmd.Context(), ref, args[1], args[2], reason); err != nil {
+					return fmt.Errorf("failed to delete artifact: %w", err)
+				}
+				fmt.Println("Artifact deleted successfully")
+				return nil
+			}
+			if !filtered {
+				return fmt.Errorf("give at least one of --name, --version, --path, --property or --older-than")
+			}
+
+			age, err := parseAge(olderThan)
+			if err != nil {
+				return err
+			}
+			crit := deleteCriteria{name: name, version: version, path: artPath, props: props, olderThan: age}
+			matches, err := client.matchArtifacts(cmd.Context(), ref, crit)
+			if err != nil {
+				return fmt.Errorf("failed to search artifacts: %w", err)
+			}
+			if len(matches) == 0 {
+				fmt.Println("No artifacts match")
+				return nil
+			}
+
+			var total int64
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tPATH\tSIZE\tCREATED")
+			for _, a := range matches {
+				total += a.Size
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Version, a.Path, formatSize(a.Size), a.CreatedAt.Format(time.RFC3339))
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if dryRun {
+				fmt.Printf("Would delete %d artifacts (%s)\n", len(matches), formatSize(total))
+				return nil
+			}
+			if !yes {
+				ok, err := confirm(fmt.Sprintf("Delete %d artifacts (%s) from %s?", len(matches), formatSize(total), ref))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("aborted")
+				}
+			}
+
+			failed := 0
+			for _, a := range matches {
+				_, err := client.Artifacts().DeleteArtifact(cmd.Context(), connect.NewRequest(&v1.DeleteArtifactRequest{
+					RepoName:  ref.Name,
+					Namespace: ref.Namespace,
+					Id:        a.ID,
+					Reason:    reason,
+				}))
+				if err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "Failed to delete %s %s: %v\n", a.Version, a.Path, rpcErr(err))
+				}
+			}
+			fmt.Printf("Deleted %d of %d artifacts\n", len(matches)-failed, len(matches))
+			if failed > 0 {
+				return fmt.Errorf("%d deletes failed", failed)
 			}
-			fmt.Println("Artifact deleted successfully")
 			return nil
 		},
 	}
 
+	cmd.Flags().StringVar(&name, "name", "", "Artifact name glob")
+	cmd.Flags().StringVarP(&version, "version", "v", "", "Version glob, e.g. 1.*")
+	cmd.Flags().StringVarP(&artPath, "path", "p", "", "Path glob inside the version")
+	cmd.Flags().StringToStringVar(&props, "property", nil, "Match properties (key=value,key=value,...)")
+	cmd.Flags().StringVar(&olderThan, "older-than", "", "Only artifacts created longer ago, e.g. 30d or 12h")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List what would be deleted without deleting")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
 	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
+	cmd.Flags().StringVar(&reason, "reason", "", "Why, recorded in the audit trail")
 	return cmd
 }
 
@@ -266,10 +539,14 @@ narrow the results.`,
 			if table {
 				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 				fmt.Fprintln(w, "Total Matches:", search.Total)
-				fmt.Fprintln(w, "\nREPOSITORY\tNAME\tVERSION\tSIZE\tUPDATED")
+				fmt.Fprintln(w, "\nREPOSITORY\tNAME\tVERSION\tSIZE\tSCAN\tUPDATED")
 				for _, a := range search.Results {
-					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
-						a.RepoID, a.Name, a.Version, formatSize(a.Size), a.UpdatedAt.Format(time.RFC3339))
+					repo := a.Repository
+					if repo == "" {
+						repo = strconv.FormatInt(a.RepoID, 10)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+						repo, a.Name, a.Version, formatSize(a.Size), cmp.Or(a.ScanStatus, "-"), a.UpdatedAt.Format(time.RFC3339))
 				}
 				return w.Flush()
 			}
@@ -290,3 +567,242 @@ narrow the results.`,
 	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
 	return cmd
 }
+
+func newArtifactPropsCmd() *cobra.Command {
+	var (
+		repo      string
+		name      string
+		version   string
+		artPath   string
+		namespace string
+		match     map[string]string
+		set       map[string]string
+		unset     []string
+		dryRun    bool
+		asJSON    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "props",
+		Short: "Set or unset properties on every matching artifact",
+		Long: `Applies property changes to every artifact matching the filters in one
+all or nothing operation. Name, version and path match exactly.
+
+  dfcli artifact props --property branch=release/1.4 --set supported=false --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req := &v1.BulkEditArtifactPropertiesRequest{
+				Namespace:       namespace,
+				MatchProperties: match,
+				Set:             set,
+				Unset:           unset,
+				Preview:         dryRun,
+			}
+			if repo != "" {
+				ref := repoArg(repo, namespace)
+				req.Namespace, req.RepoName = ref.Namespace, ref.Name
+			}
+			q := &v1.Query{}
+			for _, f := range []struct{ field, value string }{
+				{"name", name}, {"version", version}, {"path", artPath},
+			} {
+				if f.value != "" {
+					q.Filters = append(q.Filters, &v1.FieldFilter{Field: f.field, Match: v1.MatchKind_MATCH_KIND_EQUALS, Value: f.value})
+				}
+			}
+			if len(q.Filters) > 0 {
+				req.Query = q
+			}
+
+			resp, err := client.Artifacts().BulkEditArtifactProperties(cmd.Context(), connect.NewRequest(req))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg})
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "REPOSITORY\tNAME\tVERSION\tPATH\tPROPERTIES")
+			for _, c := range resp.Msg.Changes {
+				a := c.Artifact
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.RepoFullName, a.Name, a.Version, a.Path, formatProps(c.Properties))
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			verb := "Changed"
+			if !resp.Msg.Applied {
+				verb = "Would change"
+			}
+			fmt.Printf("%s %d of %d matching artifacts\n", verb, len(resp.Msg.Changes), resp.Msg.Matched)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&repo, "repo", "r", "", "Repository, optionally qualified as namespace/name")
+	cmd.Flags().StringVar(&name, "name", "", "Exact artifact name")
+	cmd.Flags().StringVarP(&version, "version", "v", "", "Exact artifact version")
+	cmd.Flags().StringVarP(&artPath, "path", "p", "", "Exact path inside the version")
+	cmd.Flags().StringToStringVar(&match, "property", nil, "Match properties (key=value,key=value,...)")
+	cmd.Flags().StringToStringVar(&set, "set", nil, "Properties to add or overwrite (key=value,...)")
+	cmd.Flags().StringSliceVar(&unset, "unset", nil, "Property keys to remove")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would change without writing")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
+	return cmd
+}
+
+// Sorted key=value list
+func formatProps(props map[string]string) string {
+	keys := slices.Sorted(maps.Keys(props))
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + props[k]
+	}
+	return strings.Join(parts, ",")
+}
+
+func newArtifactLatestCmd() *cobra.Command {
+	var namespace string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "latest [repo]",
+		Short: "Show where each path's latest pointer stands",
+		Long: `List the latest pointer of every path in a repository with its stable
+download URL. Uploads move a pointer when they carry the properties set
+in the artifacts.latest settings of the repo, a download through the URL
+always gets one complete artifact even while an upload replaces it.`,
+		Example:     `  curl -fLo app.tar -H "$(dfcli auth header)" "$SERVER/api/v1/artifacts/builds/_latest/app.tar"`,
+		Args:        cobra.RangeArgs(0, 1),
+		Annotations: map[string]string{repoDefaultAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			args, err := withDefaultRepo(cmd, args, 1)
+			if err != nil {
+				return err
+			}
+			ref := repoArg(args[0], namespace)
+			resp, err := client.Artifacts().ListLatestArtifacts(cmd.Context(), connect.NewRequest(&v1.ListLatestArtifactsRequest{
+				Namespace: ref.Namespace,
+				RepoName:  ref.Name,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg})
+			}
+			if len(resp.Msg.Latest) == 0 {
+				fmt.Fprintf(os.Stderr, "No latest pointers in %s yet\n", ref)
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PATH\tVERSION\tUPDATED\tFILTER\tURL")
+			for _, l := range resp.Msg.Latest {
+				filter := formatProps(l.Filter)
+				if filter == "" {
+					filter = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s%s/_latest/%s\n", l.Path, l.Version, formatTimestamp(l.UpdatedAt), filter,
+					client.BaseURL, ref.basePath(), l.Path)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newArtifactChangelogCmd() *cobra.Command {
+	var namespace, output string
+	var after int64
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "changelog [repo]",
+		Short: "Export a repository's change history as NDJSON",
+		Long: `Stream every upload, delete, rename, property and metadata change of a
+repository, oldest first, one JSON object per line. Each line carries a
+cursor, pass the last one seen as --after to fetch only what came since.
+
+With --output the lines are appended to the file and the export resumes
+after the last cursor already in it, so repeated runs feed a warehouse
+or compliance archive incrementally.`,
+		Example: `  dfcli artifact changelog builds > builds.ndjson
+  dfcli artifact changelog acme/builds --output /archive/builds.ndjson`,
+		Args:        cobra.RangeArgs(0, 1),
+		Annotations: map[string]string{repoDefaultAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			args, err := withDefaultRepo(cmd, args, 1)
+			if err != nil {
+				return err
+			}
+			ref := repoArg(args[0], namespace)
+
+			out := io.Writer(os.Stdout)
+			toFile := output != "" && output != "-"
+			if toFile {
+				if !cmd.Flags().Changed("after") {
+					if after, err = lastChangelogCursor(output); err != nil {
+						return err
+					}
+				}
+				f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			n, err := client.exportChangelog(cmd.Context(), ref, after, limit, out)
+			if err != nil {
+				return err
+			}
+			if toFile {
+				fmt.Fprintf(os.Stderr, "Appended %d changes of %s to %s\n", n, ref, output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
+	cmd.Flags().Int64Var(&after, "after", 0, "Only changes after this cursor")
+	cmd.Flags().IntVar(&limit, "limit", 0, "Stop after this many changes, 0 for all")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Append to this file, resuming after its last cursor")
+	return cmd
+}
+
+func newArtifactCacheCmd() *cobra.Command {
+	var clear bool
+
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Show or clear the local download cache",
+		Long: `Print where the download cache lives, how many files it holds and their
+size against the limit. --clear empties it. Set it up with
+dfcli config set download_cache DIR.`,
+		Args: cobra.NoArgs,
+		// The cache is local, no server needed
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache := openBlobCache()
+			if cache == nil {
+				return fmt.Errorf("no download cache, set one with dfcli config set download_cache DIR")
+			}
+			if clear {
+				if err := os.RemoveAll(filepath.Join(cache.dir, "sha256")); err != nil {
+					return err
+				}
+				fmt.Printf("Cleared %s\n", cache.dir)
+				return nil
+			}
+			entries, total := cache.entries()
+			fmt.Printf("Directory: %s\nFiles:     %d\nSize:      %s of %s\n", cache.dir, len(entries), formatSize(total), formatSize(cache.max))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&clear, "clear", false, "Remove every cached file")
+	return cmd
+}
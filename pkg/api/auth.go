@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"connectrpc.com/connect"
@@ -13,6 +17,7 @@ import (
 	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
+	"google.golang.org/protobuf/proto"
 )
 
 // Stored tokens must not leak into login, use a bare client
@@ -51,6 +56,17 @@ func login(ctx context.Context, username, password string) (token string, canoni
 	return refResp.Msg.GetSessionToken(), canonical, time.Unix(refResp.Msg.GetExpiresAt(), 0), nil
 }
 
+// Instance branding, nil when the server is older or unreachable
+func fetchBranding(ctx context.Context) *v1.BrandingSettings {
+	settings := distrofacev1connect.NewSettingsServiceClient(client.HTTPClient, client.BaseURL)
+	resp, err := settings.GetBranding(ctx, connect.NewRequest(&v1.GetBrandingRequest{}))
+	if err != nil {
+		debugf("Fetching branding failed: %v", err)
+		return nil
+	}
+	return resp.Msg.GetBranding()
+}
+
 // Validates the pat and returns its owner
 func whoami(ctx context.Context, token string) (string, error) {
 	req := connect.NewRequest(&v1.GetCurrentUserRequest{})
@@ -62,8 +78,57 @@ func whoami(ctx context.Context, token string) (string, error) {
 	return resp.Msg.GetUser().GetUsername(), nil
 }
 
+// Pats are never exchanged, their expiry lives server side
+func patExpiry() time.Time {
+	return time.Now().Add(24 * 365 * 10 * time.Hour)
+}
+
+// Secret from a robot token file, warns when others can read it
+func readTokenFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %w", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: token file %s is accessible by other users\n", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %w", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// Client credentials exchange for a robot account. The file holds the
+// account's password or one of its personal access tokens and is read
+// on every exchange so rotated secrets are picked up
+func robotLogin(ctx context.Context, robot, path string) (AuthConfig, error) {
+	secret, err := readTokenFile(path)
+	if err != nil {
+		return AuthConfig{}, err
+	}
+	config := AuthConfig{Robot: robot, TokenFile: path}
+	if strings.HasPrefix(secret, patPrefix) {
+		owner, err := whoami(ctx, secret)
+		if err != nil {
+			return AuthConfig{}, fmt.Errorf("token was rejected: %w", err)
+		}
+		if !strings.EqualFold(owner, robot) {
+			return AuthConfig{}, fmt.Errorf("token in %s belongs to %s, not %s", path, owner, robot)
+		}
+		config.Token, config.Username, config.ExpiresAt = secret, owner, patExpiry()
+		return config, nil
+	}
+	config.Token, config.Username, config.ExpiresAt, err = login(ctx, robot, secret)
+	return config, err
+}
+
 func newLoginCmd() *cobra.Command {
-	var username, password, patToken string
+	var username, password, patToken, robot, tokenFile string
 
 	cmd := &cobra.Command{
 		Use:   "login",
@@ -74,10 +139,41 @@ func newLoginCmd() *cobra.Command {
 
 Personal access tokens never require refreshing and are the recommended
 credential for CI. The DFCLI_TOKEN environment variable overrides the
-stored token without touching the config file.`,
+stored token without touching the config file.
+
+Robot accounts log in without prompting, from a file holding the
+account's password or personal access token:
+
+  dfcli login --robot ci-bot --token-file /run/secrets/dfcli
+
+The file is read again whenever the session lapses, so long running
+jobs keep working and a rotated secret takes effect on the next login.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			server := client.BaseURL
 
+			if (robot == "") != (tokenFile == "") {
+				return fmt.Errorf("--robot and --token-file go together")
+			}
+			if robot != "" {
+				if patToken != "" || username != "" || password != "" {
+					return fmt.Errorf("--robot takes no other credentials")
+				}
+				path, err := filepath.Abs(tokenFile)
+				if err != nil {
+					return err
+				}
+				config, err := robotLogin(cmd.Context(), robot, path)
+				if err != nil {
+					return fmt.Errorf("robot login failed: %v", err)
+				}
+				config.Server = server
+				if err := saveLogin(config); err != nil {
+					return fmt.Errorf("failed to save config: %v", err)
+				}
+				fmt.Printf("Logged in as robot %s on %s\n", config.Username, server)
+				return nil
+			}
+
 			// PAT login verifies and stores, no session dance
 			if patToken != "" {
 				if !strings.HasPrefix(patToken, patPrefix) {
@@ -90,16 +186,26 @@ stored token without touching the config file.`,
 				config := AuthConfig{
 					Token:     patToken,
 					Username:  owner,
-					ExpiresAt: time.Now().Add(24 * 365 * 10 * time.Hour), // PATs carry their own expiry server-side
+					ExpiresAt: patExpiry(),
 					Server:    server,
 				}
-				if err := saveConfig(config); err != nil {
+				if err := saveLogin(config); err != nil {
 					return fmt.Errorf("failed to save config: %v", err)
 				}
 				fmt.Printf("Personal access token for %s stored\n", owner)
 				return nil
 			}
 
+			// Legal notices come before credentials, on stderr so scripts keep clean output
+			branding := fetchBranding(cmd.Context())
+			if banner := strings.TrimSpace(branding.GetLoginBanner()); banner != "" {
+				fmt.Fprintf(os.Stderr, "%s\n\n", banner)
+			}
+
+			// Containers and CI runners have nothing to prompt on
+			if (username == "" || password == "") && !term.IsTerminal(int(syscall.Stdin)) {
+				return fmt.Errorf("no terminal to prompt on - pass --username and --password, --token, or --robot with --token-file")
+			}
 			if username == "" {
 				fmt.Print("Username: ")
 				fmt.Scanln(&username)
@@ -116,6 +222,9 @@ stored token without touching the config file.`,
 
 			token, canonical, expiry, err := login(cmd.Context(), username, password)
 			if err != nil {
+				if contact := branding.GetSupportContact(); contact != "" {
+					return fmt.Errorf("login failed: %v (support: %s)", err, contact)
+				}
 				return fmt.Errorf("login failed: %v", err)
 			}
 
@@ -125,10 +234,14 @@ stored token without touching the config file.`,
 				Server:    server,
 				ExpiresAt: expiry,
 			}
-			if err := saveConfig(config); err != nil {
+			if err := saveLogin(config); err != nil {
 				return fmt.Errorf("failed to save config: %v", err)
 			}
 
+			if name := branding.GetInstanceName(); name != "" {
+				fmt.Printf("Successfully logged in as %s on %s (%s)\n", canonical, name, server)
+				return nil
+			}
 			fmt.Printf("Successfully logged in as %s on %s\n", canonical, server)
 			return nil
 		},
@@ -137,6 +250,8 @@ stored token without touching the config file.`,
 	cmd.Flags().StringVarP(&username, "username", "u", "", "Username (optional, will prompt if not provided)")
 	cmd.Flags().StringVarP(&password, "password", "p", "", "Password (optional, will prompt if not provided)")
 	cmd.Flags().StringVar(&patToken, "token", "", "Personal access token (df_...) to store instead of a session")
+	cmd.Flags().StringVar(&robot, "robot", "", "Robot account to log in as without prompting, needs --token-file")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "File holding the robot account's password or personal access token")
 
 	return cmd
 }
@@ -155,11 +270,160 @@ func newLogoutCmd() *cobra.Command {
 				}
 			}
 
-			if err := os.Remove(configPath()); err != nil && !os.IsNotExist(err) {
-				return fmt.Errorf("failed to remove config: %v", err)
+			if err := clearAuthConfig(); err != nil {
+				return err
+			}
+			if err := clearResponseCache(); err != nil {
+				debugf("Clearing response cache failed: %v", err)
 			}
 			fmt.Println("Successfully logged out")
 			return nil
 		},
 	}
 }
+
+func newWhoamiCmd() *cobra.Command {
+	var usage, asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "whoami",
+		Short: "Show who you are logged in as",
+		Long: `Print the account behind the current login and its roles. --usage adds
+your storage, repository counts and the limits you run into, the first
+stop when a push or pull is rejected.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if usage {
+				resp, err := client.Users().GetMyUsage(cmd.Context(), connect.NewRequest(&v1.GetMyUsageRequest{}))
+				if err != nil {
+					return rpcErr(err)
+				}
+				if asJSON {
+					return printProtoJSON([]proto.Message{resp.Msg})
+				}
+				return printUsage(resp.Msg)
+			}
+
+			resp, err := client.Auth().GetCurrentUser(cmd.Context(), connect.NewRequest(&v1.GetCurrentUserRequest{}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg.User})
+			}
+			user := resp.Msg.User
+			roles := make([]string, len(user.Roles))
+			for i, r := range user.Roles {
+				roles[i] = r.Name
+			}
+			fmt.Printf("Username: %s\nProvider: %s\nRoles:    %s\n", user.Username, user.AuthProvider, strings.Join(roles, ", "))
+			if user.DefaultNamespace != "" {
+				fmt.Printf("Default namespace: %s\n", user.DefaultNamespace)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&usage, "usage", false, "Show storage, repository counts and limits")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func printUsage(u *v1.GetMyUsageResponse) error {
+	fmt.Printf("Username:     %s\n", u.Username)
+	fmt.Printf("Images:       %d repositories (%d private), %s in %s/\n", u.Repositories, u.PrivateRepositories, formatSize(u.RegistryBytes), strings.ToLower(u.Username))
+	fmt.Printf("Artifacts:    %d in %d repositories, %s\n", u.Artifacts, u.ArtifactRepositories, formatSize(u.ArtifactBytes))
+	if u.PushPolicy {
+		fmt.Println("Push policy:  pushes also need the approval of an external policy hook")
+	}
+	if len(u.Limits) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LIMIT\tUSED\tOF\tRESETS\tDETAIL")
+	for _, l := range u.Limits {
+		used, of, resets := "-", "unlimited", "-"
+		switch {
+		case l.Limit == 0:
+		case l.Name == "artifact_max_file_size":
+			of = formatSize(l.Limit)
+		case l.Name == "artifact_storage":
+			used, of = formatSize(l.Used), formatSize(l.Limit)
+		default:
+			used, of = strconv.FormatInt(l.Used, 10), strconv.FormatInt(l.Limit, 10)
+			if l.ResetAt != nil {
+				resets = l.ResetAt.AsTime().Local().Format("15:04:05")
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.Name, used, of, resets, l.Detail)
+	}
+	return w.Flush()
+}
+
+func newAuthCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Hand the stored credential to scripts",
+		Long: `Print the bearer token of the current login so shell scripts and curl can
+reuse it. Sessions close to expiry are refreshed first, personal access
+tokens and DFCLI_TOKEN are printed as is.
+
+  curl -H "$(dfcli auth header)" https://registry.example.com/v2/_catalog`,
+	}
+	cmd.PersistentFlags().Duration("min-valid", 5*time.Minute, "Refresh a session expiring sooner than this")
+	cmd.AddCommand(newAuthTokenCmd(), newAuthHeaderCmd())
+	return cmd
+}
+
+// Stored token, refreshed when it would lapse within the --min-valid window
+func scriptToken(cmd *cobra.Command) (string, error) {
+	minValid, err := cmd.Flags().GetDuration("min-valid")
+	if err != nil {
+		return "", err
+	}
+	if client.Tokens.GetToken() == "" {
+		return "", fmt.Errorf("not logged in - run 'dfcli login'")
+	}
+	if client.Tokens.ExpiresWithin(minValid) {
+		if err := client.refreshToken(cmd.Context()); err != nil {
+			return "", err
+		}
+	}
+	return client.Tokens.GetToken(), nil
+}
+
+func newAuthTokenCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "token",
+		Short:   "Print the current bearer token",
+		Example: `  TOKEN=$(dfcli auth token)`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := scriptToken(cmd)
+			if err != nil {
+				return err
+			}
+			fmt.Println(token)
+			return nil
+		},
+	}
+}
+
+func newAuthHeaderCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "header",
+		Short:   "Print a ready to use Authorization header",
+		Example: `  curl -H "$(dfcli auth header)" "$SERVER/v2/_catalog"`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := scriptToken(cmd)
+			if err != nil {
+				return err
+			}
+			fmt.Println("Authorization: Bearer " + token)
+			return nil
+		},
+	}
+}
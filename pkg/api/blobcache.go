@@ -0,0 +1,191 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultDownloadCacheMB = 10240
+
+// Artifact files by sha256, shared by every dfcli run pointed at the same
+// directory. Entries only go in after their hash checked out and only come
+// out after the server confirmed the digest, so a stale or tampered file
+// is never served
+type blobCache struct {
+	dir string
+	max int64
+}
+
+// Nil unless download_cache is set
+func openBlobCache() *blobCache {
+	dir := viper.GetString("download_cache")
+	if dir == "" {
+		return nil
+	}
+	mb := viper.GetInt64("download_cache_max_mb")
+	if mb <= 0 {
+		mb = defaultDownloadCacheMB
+	}
+	return &blobCache{dir: dir, max: mb << 20}
+}
+
+func validateCacheSize(v string) error {
+	if mb, err := strconv.ParseInt(v, 10, 64); err != nil || mb <= 0 {
+		return fmt.Errorf("want a size in megabytes above zero")
+	}
+	return nil
+}
+
+func (b *blobCache) path(digest string) (string, bool) {
+	sum, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(sum) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", false
+	}
+	return filepath.Join(b.dir, "sha256", sum[:2], sum), true
+}
+
+// Reports whether a digest is worth asking the server to confirm
+func (b *blobCache) has(digest string) bool {
+	if b == nil {
+		return false
+	}
+	p, ok := b.path(digest)
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// Copies an entry to dest through a temp sibling, rehashing on the way.
+// A corrupt entry is dropped and reported so the caller downloads instead
+func (b *blobCache) copyTo(digest, dest string) (int64, error) {
+	p, ok := b.path(digest)
+	if !ok {
+		return 0, fmt.Errorf("not a sha256 digest: %s", digest)
+	}
+	src, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".dfcli-fetch-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		os.Remove(p)
+		return 0, fmt.Errorf("cached copy of %s is corrupt, dropped it", digest)
+	}
+	// Recently used entries survive trimming longest
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return n, os.Rename(tmp.Name(), dest)
+}
+
+// Adds a verified download as a private copy, a later edit of the
+// downloaded file never reaches the cache. Failures only cost a download
+func (b *blobCache) add(digest, src string) {
+	if b == nil {
+		return
+	}
+	p, ok := b.path(digest)
+	if !ok {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		debugf("Download cache unavailable: %v", err)
+		return
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", p, os.Getpid())
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		debugf("Caching %s failed: %v", digest, err)
+		return
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	b.trim()
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+type cachedBlob struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// Entries with their size, least recently used first
+func (b *blobCache) entries() ([]cachedBlob, int64) {
+	var out []cachedBlob
+	var total int64
+	_ = filepath.WalkDir(filepath.Join(b.dir, "sha256"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		out = append(out, cachedBlob{path: p, size: info.Size(), used: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].used.Before(out[j].used) })
+	return out, total
+}
+
+// Evicts least recently used entries until the cache fits its limit
+func (b *blobCache) trim() {
+	entries, total := b.entries()
+	for _, e := range entries {
+		if total <= b.max {
+			return
+		}
+		if err := os.Remove(e.path); err == nil {
+			total -= e.size
+		}
+	}
+}
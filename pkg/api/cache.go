@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+)
+
+// Entries unused for the TTL expire, past the cap the least recently used go
+const (
+	responseCacheMaxEntries = 500
+	responseCacheTTL        = 7 * 24 * time.Hour
+)
+
+// List and search procedures the server answers with an etag
+var cachedProcedures = map[string]bool{
+	distrofacev1connect.ArtifactServiceListArtifactRepositoriesProcedure: true,
+	distrofacev1connect.ArtifactServiceGetArtifactRepositoryProcedure:    true,
+	distrofacev1connect.ArtifactServiceListArtifactsProcedure:            true,
+	distrofacev1connect.ArtifactServiceListArtifactVersionsProcedure:     true,
+	distrofacev1connect.ArtifactServiceSearchArtifactsProcedure:          true,
+	distrofacev1connect.RepositoryServiceListRepositoriesProcedure:       true,
+	distrofacev1connect.RepositoryServiceListTagsProcedure:               true,
+	distrofacev1connect.RoleServiceListRolesProcedure:                    true,
+	distrofacev1connect.RoleServiceListRoleMembersProcedure:              true,
+	distrofacev1connect.UserServiceListUsersProcedure:                    true,
+}
+
+// One stored response, replayed when the server confirms it with 304
+type cacheEntry struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// Revalidates cached rpc responses with If-None-Match
+type cacheTransport struct {
+	base http.RoundTripper
+	dir  string
+}
+
+func responseCacheDir() string {
+	return filepath.Join(filepath.Dir(configPath()), "cache", "responses")
+}
+
+func newCacheTransport(base http.RoundTripper) http.RoundTripper {
+	return &cacheTransport{base: base, dir: responseCacheDir()}
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !cachedProcedures[req.URL.Path] || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	// Keyed by caller too, visibility differs per user and token scope
+	h := sha256.New()
+	for _, part := range []string{req.URL.String(), cacheIdentity(req.Header.Get("Authorization")), req.Header.Get("Content-Type")} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	path := filepath.Join(t.dir, hex.EncodeToString(h.Sum(nil))+".json")
+
+	entry := readCacheEntry(path)
+	if entry != nil {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		resp.Body.Close()
+		debugf("Cache hit (304) for %s", req.URL.Path)
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        entry.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+			ContentLength: int64(len(entry.Body)),
+			Request:       req,
+		}, nil
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		writeCacheEntry(path, &cacheEntry{ETag: resp.Header.Get("ETag"), Header: resp.Header.Clone(), Body: data})
+		trimResponseCache(t.dir)
+	}
+	return resp, nil
+}
+
+// Who cached responses belong to. Sessions name their user, so entries
+// outlive token refreshes. An api token is one user and scope set for its
+// whole life, its fingerprint stands for both
+func cacheIdentity(authorization string) string {
+	raw, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || raw == "" {
+		return "anonymous"
+	}
+	if !strings.HasPrefix(raw, patPrefix) {
+		if id := sessionUserID(raw); id != "" {
+			return "user:" + id
+		}
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return "token:" + hex.EncodeToString(sum[:8])
+}
+
+// User id claim of a session jwt, unverified since it only picks a cache
+// entry and the server still confirms every hit
+func sessionUserID(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		UserID string `json:"user_id"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	return claims.UserID
+}
+
+func readCacheEntry(path string) *cacheEntry {
+	if info, err := os.Stat(path); err != nil || time.Since(info.ModTime()) > responseCacheTTL {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.ETag == "" {
+		return nil
+	}
+	return &entry
+}
+
+// Best effort, a failed write only costs the next round trip
+func writeCacheEntry(path string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		debugf("Response cache unavailable: %v", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// Removes expired entries, then the least recently used past the cap
+func trimResponseCache(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type cached struct {
+		path string
+		used time.Time
+	}
+	var live []cached
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if time.Since(info.ModTime()) > responseCacheTTL {
+			os.Remove(path)
+			continue
+		}
+		live = append(live, cached{path: path, used: info.ModTime()})
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].used.Before(live[j].used) })
+	for i := 0; i < len(live)-responseCacheMaxEntries; i++ {
+		os.Remove(live[i].path)
+	}
+}
+
+// Drops every cached response, the next login may be someone else
+func clearResponseCache() error {
+	return os.RemoveAll(responseCacheDir())
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/nickheyer/distroface/pkg/proto/distroface/v1/distrofacev1connect"
+	"github.com/spf13/cobra"
+)
+
+func newCapabilitiesCmd() *cobra.Command {
+	var describe bool
+	cmd := &cobra.Command{
+		Use:   "capabilities",
+		Short: "List the optional protocols the server offers",
+		Long: `List what the server supports beyond the core api, such as resumable
+uploads, range downloads, archive formats and the referrers api. The same
+list is served without signing in at GET /api/v1/capabilities.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.Health().GetCapabilities(cmd.Context(), connect.NewRequest(&v1.GetCapabilitiesRequest{}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			caps := resp.Msg.Capabilities
+			if describe {
+				for i, c := range caps {
+					if i > 0 {
+						fmt.Println()
+					}
+					state := "enabled"
+					if !c.Enabled {
+						state = "disabled"
+					}
+					fmt.Printf("%s (%s)\n", c.Name, state)
+					if c.Endpoint != "" {
+						fmt.Printf("  Endpoint: %s\n", c.Endpoint)
+					}
+					if len(c.Options) > 0 {
+						fmt.Printf("  Options:  %s\n", strings.Join(c.Options, ", "))
+					}
+					fmt.Printf("  %s\n", c.Description)
+				}
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tENABLED\tENDPOINT\tOPTIONS")
+			for _, c := range caps {
+				fmt.Fprintf(w, "%s\t%v\t%s\t%s\n", c.Name, c.Enabled, cmp.Or(c.Endpoint, "-"), cmp.Or(strings.Join(c.Options, ", "), "-"))
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&describe, "describe", false, "Show each protocol with its description instead of a table")
+	return cmd
+}
+
+// Server capabilities by name, fetched once. Nil when the server predates
+// discovery, callers then just try the request
+func (c *Client) capabilities(ctx context.Context) map[string]*v1.Capability {
+	c.capsOnce.Do(func() {
+		resp, err := c.Health().GetCapabilities(ctx, connect.NewRequest(&v1.GetCapabilitiesRequest{}))
+		if err != nil {
+			debugf("Capability discovery failed: %v", err)
+			return
+		}
+		c.caps = make(map[string]*v1.Capability, len(resp.Msg.Capabilities))
+		for _, cp := range resp.Msg.Capabilities {
+			c.caps[cp.Name] = cp
+		}
+	})
+	return c.caps
+}
+
+// Fails early when the server reports the capability off, unknown ones
+// pass so newer dfcli still talks to older servers
+func (c *Client) requireCapability(ctx context.Context, name, what string) error {
+	if cp, ok := c.capabilities(ctx)[name]; ok && !cp.Enabled {
+		return fmt.Errorf("%s is unavailable, %s has %s turned off", what, c.BaseURL, name)
+	}
+	return nil
+}
+
+// Archive formats must be one the server offers, it would quietly send zip
+func (c *Client) requireArchiveFormat(ctx context.Context, format string) error {
+	if err := c.requireCapability(ctx, "artifacts.archives", "archive download"); err != nil {
+		return err
+	}
+	cp, ok := c.capabilities(ctx)["artifacts.archives"]
+	if ok && format != "" && len(cp.Options) > 0 && !slices.Contains(cp.Options, format) {
+		return fmt.Errorf("the server offers %s archives, not %s", strings.Join(cp.Options, " and "), format)
+	}
+	return nil
+}
+
+// Capabilities are public, no auth interceptor
+func (c *Client) Health() distrofacev1connect.HealthServiceClient {
+	return distrofacev1connect.NewHealthServiceClient(c.HTTPClient, c.BaseURL)
+}
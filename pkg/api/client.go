@@ -53,6 +53,16 @@ func (tm *TokenManager) IsExpired() bool {
 	return time.Now().After(tm.expiresAt)
 }
 
+// Like IsExpired but true once less than d remains
+func (tm *TokenManager) ExpiresWithin(d time.Duration) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.token == "" || strings.HasPrefix(tm.token, patPrefix) {
+		return false
+	}
+	return time.Until(tm.expiresAt) < d
+}
+
 func (tm *TokenManager) IsPAT() bool {
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
@@ -63,8 +73,18 @@ func (tm *TokenManager) IsPAT() bool {
 type Client struct {
 	BaseURL    string
 	Username   string
+	Robot      string // Set with TokenFile for robot logins
+	TokenFile  string
 	Tokens     *TokenManager
 	HTTPClient *http.Client
+	// Data plane client without a total deadline, the watchdog bounds it
+	DataClient      *http.Client
+	IdleTimeout     time.Duration
+	TransferTimeout time.Duration
+	Downloads       *blobCache // Nil unless download_cache is set
+
+	capsOnce sync.Once
+	caps     map[string]*v1.Capability
 }
 
 var client *Client
@@ -79,6 +99,7 @@ func initClient() error {
 	if envToken := os.Getenv("DFCLI_TOKEN"); envToken != "" {
 		config.Token = envToken
 		config.ExpiresAt = time.Now().Add(24 * 365 * time.Hour)
+		config.Robot, config.TokenFile = "", ""
 	}
 
 	serverURL := viper.GetString("server")
@@ -95,11 +116,27 @@ func initClient() error {
 		timeout = 5 * time.Minute
 	}
 
+	base, err := newTransport()
+	if err != nil {
+		return err
+	}
+	var transport http.RoundTripper = base
+	if !viper.GetBool("no_cache") {
+		transport = newCacheTransport(transport)
+	}
+
 	client = &Client{
 		BaseURL:    strings.TrimRight(serverURL, "/"),
 		Username:   config.Username,
+		Robot:      config.Robot,
+		TokenFile:  config.TokenFile,
 		Tokens:     NewTokenManager(config.Token, config.ExpiresAt),
-		HTTPClient: &http.Client{Timeout: timeout, Transport: newTransport()},
+		HTTPClient: &http.Client{Timeout: timeout, Transport: transport},
+		DataClient: &http.Client{Transport: transport},
+
+		IdleTimeout:     viper.GetDuration("idle_timeout"),
+		TransferTimeout: viper.GetDuration("transfer_timeout"),
+		Downloads:       openBlobCache(),
 	}
 	return nil
 }
@@ -119,22 +156,37 @@ func detectScheme(host string) string {
 	return "http"
 }
 
-// Installed instance CA joins the system roots
-func newTransport() *http.Transport {
+// Installed instance CA joins the system roots, a configured client
+// certificate rides every handshake
+func newTransport() (*http.Transport, error) {
 	t := http.DefaultTransport.(*http.Transport).Clone()
-	pem, err := os.ReadFile(caPath())
-	if err != nil {
-		return t
+	cfg := &tls.Config{}
+	if pem, err := os.ReadFile(caPath()); err == nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if pool.AppendCertsFromPEM(pem) {
+			debugf("Trusting instance CA from %s", caPath())
+			cfg.RootCAs = pool
+		}
 	}
-	pool, err := x509.SystemCertPool()
-	if err != nil || pool == nil {
-		pool = x509.NewCertPool()
+	if certFile := viper.GetString("client_cert"); certFile != "" {
+		keyFile := viper.GetString("client_key")
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		debugf("Presenting client certificate from %s", certFile)
+		cfg.Certificates = []tls.Certificate{pair}
 	}
-	if pool.AppendCertsFromPEM(pem) {
-		debugf("Trusting instance CA from %s", caPath())
-		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	if cfg.RootCAs != nil || len(cfg.Certificates) > 0 {
+		t.TLSClientConfig = cfg
 	}
-	return t
+	return t, nil
 }
 
 // ── RPC plumbing ─────────────────────────────────────────────────────────
@@ -143,14 +195,54 @@ func (c *Client) Artifacts() distrofacev1connect.ArtifactServiceClient {
 	return distrofacev1connect.NewArtifactServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
 }
 
+func (c *Client) APITokens() distrofacev1connect.TokenServiceClient {
+	return distrofacev1connect.NewTokenServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
 func (c *Client) Auth() distrofacev1connect.AuthServiceClient {
 	return distrofacev1connect.NewAuthServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
 }
 
+func (c *Client) Credentials() distrofacev1connect.CredentialServiceClient {
+	return distrofacev1connect.NewCredentialServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
+func (c *Client) Export() distrofacev1connect.ExportServiceClient {
+	return distrofacev1connect.NewExportServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
+func (c *Client) Freezes() distrofacev1connect.FreezeServiceClient {
+	return distrofacev1connect.NewFreezeServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
+func (c *Client) GC() distrofacev1connect.GCServiceClient {
+	return distrofacev1connect.NewGCServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
+func (c *Client) Jobs() distrofacev1connect.JobServiceClient {
+	return distrofacev1connect.NewJobServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
+func (c *Client) Notifications() distrofacev1connect.NotificationServiceClient {
+	return distrofacev1connect.NewNotificationServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
+func (c *Client) Replication() distrofacev1connect.ReplicationServiceClient {
+	return distrofacev1connect.NewReplicationServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
 func (c *Client) Repositories() distrofacev1connect.RepositoryServiceClient {
 	return distrofacev1connect.NewRepositoryServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
 }
 
+func (c *Client) Roles() distrofacev1connect.RoleServiceClient {
+	return distrofacev1connect.NewRoleServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
+func (c *Client) Users() distrofacev1connect.UserServiceClient {
+	return distrofacev1connect.NewUserServiceClient(c.HTTPClient, c.BaseURL, c.rpcOpts()...)
+}
+
 func (c *Client) rpcOpts() []connect.ClientOption {
 	return []connect.ClientOption{connect.WithInterceptors(c.authInterceptor())}
 }
@@ -184,14 +276,75 @@ func (c *Client) authInterceptor() connect.UnaryInterceptorFunc {
 	}
 }
 
-// Trades the current session for a fresh one
+// Trades the current session for a fresh one. Parallel runs sharing the
+// config take turns, and a run finding a session another already renewed
+// adopts it rather than refreshing again
 func (c *Client) refreshToken(ctx context.Context) error {
+	if !sharedTokens() {
+		_, err := c.renewToken(ctx)
+		return err
+	}
+	return withConfigLock(func() error {
+		if c.adoptStoredToken() {
+			debugf("Using the session another dfcli process refreshed")
+			return nil
+		}
+		config, err := c.renewToken(ctx)
+		if err != nil {
+			return err
+		}
+		// The lock is already held, saveConfig would wait on it
+		file, err := readConfigFile()
+		if err != nil {
+			return err
+		}
+		setAuthFields(file, config)
+		return writeConfigFile(file)
+	})
+}
+
+// Whether refreshed sessions are written back for other runs, token_cache
+// memory and DFCLI_TOKEN keep them in this process
+func sharedTokens() bool {
+	return viper.GetString("token_cache") != "memory" && os.Getenv("DFCLI_TOKEN") == ""
+}
+
+// Takes the stored session when it is for the same account and server but
+// newer than ours
+func (c *Client) adoptStoredToken() bool {
+	stored, err := readAuthConfig()
+	if err != nil || stored.Token == "" || stored.Token == c.Tokens.GetToken() {
+		return false
+	}
+	if stored.Server != c.BaseURL || stored.Robot != c.Robot || stored.Username != c.Username {
+		return false
+	}
+	if strings.HasPrefix(stored.Token, patPrefix) || time.Until(stored.ExpiresAt) < time.Minute {
+		return false
+	}
+	c.Tokens.SetToken(stored.Token, stored.ExpiresAt)
+	return true
+}
+
+// Fresh session for this client, robots log in again from their token file
+func (c *Client) renewToken(ctx context.Context) (AuthConfig, error) {
+	// Robots exchange their stored credential again instead
+	if c.TokenFile != "" {
+		debugf("Logging in robot %s again from %s...", c.Robot, c.TokenFile)
+		config, err := robotLogin(ctx, c.Robot, c.TokenFile)
+		if err != nil {
+			return AuthConfig{}, fmt.Errorf("robot login failed: %v", err)
+		}
+		c.Tokens.SetToken(config.Token, config.ExpiresAt)
+		config.Server = c.BaseURL
+		return config, nil
+	}
 	if c.Tokens.IsPAT() {
-		return fmt.Errorf("personal access token was rejected - it may be expired or revoked (create a new one and run 'dfcli login --token ...')")
+		return AuthConfig{}, fmt.Errorf("personal access token was rejected - it may be expired or revoked (create a new one and run 'dfcli login --token ...')")
 	}
 	token := c.Tokens.GetToken()
 	if token == "" {
-		return fmt.Errorf("not logged in - run 'dfcli login'")
+		return AuthConfig{}, fmt.Errorf("not logged in - run 'dfcli login'")
 	}
 
 	debugf("Refreshing session token...")
@@ -201,12 +354,12 @@ func (c *Client) refreshToken(ctx context.Context) error {
 	req.Header().Set("Authorization", "Bearer "+token)
 	resp, err := auth.RefreshSession(ctx, req)
 	if err != nil {
-		return fmt.Errorf("session refresh failed: %v - run 'dfcli login'", rpcErr(err))
+		return AuthConfig{}, fmt.Errorf("session refresh failed: %v - run 'dfcli login'", rpcErr(err))
 	}
 
 	expiresAt := time.Unix(resp.Msg.ExpiresAt, 0)
 	c.Tokens.SetToken(resp.Msg.SessionToken, expiresAt)
-	return saveConfig(AuthConfig{Token: resp.Msg.SessionToken, ExpiresAt: expiresAt, Server: c.BaseURL})
+	return AuthConfig{Token: resp.Msg.SessionToken, ExpiresAt: expiresAt, Server: c.BaseURL}, nil
 }
 
 // Friendly text for surfaced connect errors
@@ -228,29 +381,50 @@ func hintTLS(err error) error {
 
 // ── HTTP data plane ──────────────────────────────────────────────────────
 
-// Raw byte transfers, streams never retry on auth
+// Raw byte transfers, streams never retry on auth. The idle watchdog
+// replaces the api timeout so long uploads only fail when they stall
 func (c *Client) doData(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	return c.doDataHeader(ctx, method, path, body, nil)
+}
+
+// doData with extra request headers
+func (c *Client) doDataHeader(ctx context.Context, method, path string, body io.Reader, header http.Header) (*http.Response, error) {
 	retriable := body == nil
 
+	ctx, dog := newWatchdog(ctx, c.IdleTimeout, c.TransferTimeout)
+	if body != nil {
+		body = &kickReader{r: body, w: dog}
+	}
+	fail := func(err error) (*http.Response, error) {
+		if cause := context.Cause(ctx); errors.Is(cause, ErrTransferTimeout) {
+			err = cause
+		}
+		dog.stop()
+		return nil, err
+	}
+
 	var resp *http.Response
 	for attempt := range 2 {
 		if attempt == 0 && c.Tokens.IsExpired() {
 			if err := c.refreshToken(ctx); err != nil {
-				return nil, err
+				return fail(err)
 			}
 		}
 
 		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
+			return fail(fmt.Errorf("failed to create request: %w", err))
+		}
+		for k, v := range header {
+			req.Header[k] = v
 		}
 		if token := c.Tokens.GetToken(); token != "" {
 			req.Header.Set("Authorization", "Bearer "+token)
 		}
 
-		resp, err = c.HTTPClient.Do(req)
+		resp, err = c.DataClient.Do(req)
 		if err != nil {
-			return nil, hintTLS(fmt.Errorf("request failed: %w", err))
+			return fail(hintTLS(fmt.Errorf("request failed: %w", err)))
 		}
 		if resp.StatusCode != http.StatusUnauthorized || !retriable || attempt > 0 {
 			break
@@ -259,14 +433,16 @@ func (c *Client) doData(ctx context.Context, method, path string, body io.Reader
 		resp.Body.Close()
 		debugf("Received 401 Unauthorized, refreshing token and retrying...")
 		if err := c.refreshToken(ctx); err != nil {
-			return nil, err
+			return fail(err)
 		}
 	}
 
 	if resp.StatusCode >= 400 {
 		respBody, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		return fail(fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody))))
 	}
+	dog.kick()
+	resp.Body = &watchedBody{ReadCloser: resp.Body, ctx: ctx, w: dog}
 	return resp, nil
 }
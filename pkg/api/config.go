@@ -25,6 +25,8 @@ type AuthConfig struct {
 	ExpiresAt time.Time `json:"expires_at"`
 	Username  string    `json:"username"`
 	Server    string    `json:"server"`
+	Robot     string    `json:"robot,omitempty"`      // Account logged in from TokenFile
+	TokenFile string    `json:"token_file,omitempty"` // Re-read to log in again once the session lapses
 }
 
 func initConfig() {
@@ -85,20 +87,137 @@ func readAuthConfig() (AuthConfig, error) {
 	return config, nil
 }
 
+// Writes the auth fields, settings and command defaults in the file stay
 func saveConfig(config AuthConfig) error {
+	return updateConfigFile(func(file map[string]any) error {
+		setAuthFields(file, config)
+		return nil
+	})
+}
+
+// Saves a fresh login, dropping any robot credentials of the last one
+func saveLogin(config AuthConfig) error {
+	return updateConfigFile(func(file map[string]any) error {
+		if config.Robot == "" {
+			delete(file, "robot")
+			delete(file, "token_file")
+		}
+		setAuthFields(file, config)
+		return nil
+	})
+}
+
+func setAuthFields(file map[string]any, config AuthConfig) {
+	file["token"] = config.Token
+	file["expires_at"] = config.ExpiresAt
+	file["server"] = config.Server
+	// Preserve fields not being overwritten
+	if config.Username != "" {
+		file["username"] = config.Username
+	}
+	if config.Robot != "" {
+		file["robot"] = config.Robot
+		file["token_file"] = config.TokenFile
+	}
+}
+
+// Forgets the session, keeps the rest of the config
+func clearAuthConfig() error {
+	return withConfigLock(func() error {
+		file, err := readConfigFile()
+		if err != nil {
+			return err
+		}
+		for _, key := range []string{"token", "expires_at", "username", "robot", "token_file"} {
+			delete(file, key)
+		}
+		if len(file) == 0 {
+			if err := os.Remove(configPath()); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove config: %v", err)
+			}
+			return nil
+		}
+		return writeConfigFile(file)
+	})
+}
+
+// Raw config file, missing reads as empty
+func readConfigFile() (map[string]any, error) {
+	file := map[string]any{}
+	data, err := os.ReadFile(configPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %v", configPath(), err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", configPath(), err)
+	}
+	return file, nil
+}
+
+// Read, change and write the config under the lock, so parallel dfcli
+// runs never drop each other's edits
+func updateConfigFile(change func(file map[string]any) error) error {
+	return withConfigLock(func() error {
+		file, err := readConfigFile()
+		if err != nil {
+			return err
+		}
+		if err := change(file); err != nil {
+			return err
+		}
+		return writeConfigFile(file)
+	})
+}
+
+// Holds the config lock file for fn, other dfcli processes wait their turn
+func withConfigLock(fn func() error) error {
 	path := configPath()
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return fmt.Errorf("failed to create config directory: %v", err)
 	}
-
-	// Preserve fields not being overwritten
-	if existing, err := readAuthConfig(); err == nil && config.Username == "" {
-		config.Username = existing.Username
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open config lock: %v", err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("failed to lock config: %v", err)
 	}
+	defer unlockFile(f)
+	return fn()
+}
 
-	data, err := json.MarshalIndent(config, "", "  ")
+// Writes beside the config and renames over it, readers see the old file
+// or the new one and never a partial write
+func writeConfigFile(file map[string]any) error {
+	path := configPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
-	return os.WriteFile(path, data, 0600)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package api
+
+import (
+	"os"
+	"syscall"
+)
+
+// Blocks until this process holds the exclusive lock on f
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"google.golang.org/protobuf/proto"
+)
+
+func newCredentialCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "credential",
+		Short: "Manage upstream credentials sealed in the server vault",
+		Long: `Credentials hold upstream logins for mirror repositories and replication
+targets. Secrets are sealed on the server and never returned, mirrors and
+replication rules reference them by id.`,
+	}
+	cmd.AddCommand(
+		newCredentialCreateCmd(),
+		newCredentialListCmd(),
+		newCredentialUpdateCmd(),
+		newCredentialDeleteCmd(),
+	)
+	return cmd
+}
+
+// Secrets never ride argv, piped stdin for scripts else a hidden prompt
+func readSecret(fromStdin bool) (string, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read secret from stdin: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Secret: ")
+	b, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %v", err)
+	}
+	return string(b), nil
+}
+
+// namespace/name or a bare name in the caller's namespace
+func (c *Client) findCredential(ctx context.Context, ref string) (*v1.Credential, error) {
+	ns, name, ok := strings.Cut(ref, "/")
+	if !ok {
+		ns, name = c.Username, ref
+	}
+	token := ""
+	for {
+		resp, err := c.Credentials().ListCredentials(ctx, connect.NewRequest(&v1.ListCredentialsRequest{
+			Namespace: ns,
+			Page:      &v1.PageRequest{PageSize: 500, PageToken: token},
+		}))
+		if err != nil {
+			return nil, rpcErr(err)
+		}
+		for _, cred := range resp.Msg.Credentials {
+			if cred.Name == name {
+				return cred, nil
+			}
+		}
+		token = resp.Msg.Page.GetNextPageToken()
+		if token == "" {
+			return nil, fmt.Errorf("credential %s/%s not found", ns, name)
+		}
+	}
+}
+
+func newCredentialCreateCmd() *cobra.Command {
+	var username, description string
+	var secretStdin bool
+
+	cmd := &cobra.Command{
+		Use:   "create [namespace/]name",
+		Short: "Seal a new upstream credential",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ns, name, ok := strings.Cut(args[0], "/")
+			if !ok {
+				ns, name = "", args[0]
+			}
+			secret, err := readSecret(secretStdin)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Credentials().CreateCredential(cmd.Context(), connect.NewRequest(&v1.CreateCredentialRequest{
+				Namespace:   ns,
+				Name:        name,
+				Description: description,
+				Username:    username,
+				Secret:      secret,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			cred := resp.Msg.Credential
+			fmt.Printf("Created credential %s/%s (id %s)\n", cred.Namespace, cred.Name, cred.Id)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&username, "username", "", "Upstream username, empty for token only logins")
+	cmd.Flags().StringVar(&description, "description", "", "Free text note")
+	cmd.Flags().BoolVar(&secretStdin, "secret-stdin", false, "Read the secret from stdin instead of prompting")
+	return cmd
+}
+
+func newCredentialListCmd() *cobra.Command {
+	var namespace string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List credentials in namespaces you manage",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var creds []*v1.Credential
+			token := ""
+			for {
+				resp, err := client.Credentials().ListCredentials(cmd.Context(), connect.NewRequest(&v1.ListCredentialsRequest{
+					Namespace: namespace,
+					Page:      &v1.PageRequest{PageSize: 500, PageToken: token},
+				}))
+				if err != nil {
+					return rpcErr(err)
+				}
+				creds = append(creds, resp.Msg.Credentials...)
+				if token = resp.Msg.Page.GetNextPageToken(); token == "" {
+					break
+				}
+			}
+
+			if asJSON {
+				msgs := make([]proto.Message, len(creds))
+				for i, c := range creds {
+					msgs[i] = c
+				}
+				return printProtoJSON(msgs)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "CREDENTIAL\tID\tUSERNAME\tUPDATED\tDESCRIPTION")
+			for _, c := range creds {
+				fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\t%s\n", c.Namespace, c.Name, c.Id, c.Username,
+					c.UpdatedAt.AsTime().Local().Format("2006-01-02 15:04"), c.Description)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Only this namespace")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newCredentialUpdateCmd() *cobra.Command {
+	var username, description string
+	var rotate, secretStdin bool
+
+	cmd := &cobra.Command{
+		Use:   "update [namespace/]name",
+		Short: "Change a credential or rotate its secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cred, err := client.findCredential(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			req := &v1.UpdateCredentialRequest{Id: cred.Id}
+			if cmd.Flags().Changed("username") {
+				req.Username = proto.String(username)
+			}
+			if cmd.Flags().Changed("description") {
+				req.Description = proto.String(description)
+			}
+			if rotate || secretStdin {
+				secret, err := readSecret(secretStdin)
+				if err != nil {
+					return err
+				}
+				req.Secret = proto.String(secret)
+			}
+			if _, err := client.Credentials().UpdateCredential(cmd.Context(), connect.NewRequest(req)); err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Updated credential %s/%s\n", cred.Namespace, cred.Name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&username, "username", "", "New upstream username")
+	cmd.Flags().StringVar(&description, "description", "", "New description")
+	cmd.Flags().BoolVar(&rotate, "rotate", false, "Prompt for a new secret")
+	cmd.Flags().BoolVar(&secretStdin, "secret-stdin", false, "Read a new secret from stdin")
+	return cmd
+}
+
+func newCredentialDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete [namespace/]name",
+		Short: "Delete a credential no mirror or replication rule references",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cred, err := client.findCredential(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			if _, err := client.Credentials().DeleteCredential(cmd.Context(), connect.NewRequest(&v1.DeleteCredentialRequest{Id: cred.Id})); err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Deleted credential %s/%s\n", cred.Namespace, cred.Name)
+			return nil
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,454 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Top level config keys, every other key is a command default
+type cliSetting struct {
+	key      string
+	validate func(string) error
+}
+
+var cliSettings = []cliSetting{
+	{"server", nil},
+	{"timeout", validateDuration},
+	{"idle_timeout", validateDuration},
+	{"transfer_timeout", validateDuration},
+	{"debug", validateBool},
+	{"no_cache", validateBool},
+	{"output", validateOutput},    // json or table, turns on --json or --table
+	{"temp_dir", validateTempDir}, // Scratch for archive downloads, empty stages beside the destination
+	{"client_cert", nil},          // PEM certificate presented for mtls sign-in
+	{"client_key", nil},           // Its key, empty reads it from the certificate file
+	{"token_cache", validateTokenCache},
+	{"download_cache", validateTempDir}, // Content addressed store for --parallel downloads, empty turns it off
+	{"download_cache_max_mb", validateCacheSize},
+	{"ci_annotations", validateCIAnnotations}, // off, auto, github or gitlab
+}
+
+// Commands taking the repo as an argument read a repo default too
+const repoDefaultAnnotation = "dfcli.repo-default"
+
+func validateDuration(v string) error {
+	_, err := time.ParseDuration(v)
+	return err
+}
+
+func validateBool(v string) error {
+	_, err := strconv.ParseBool(v)
+	return err
+}
+
+func validateOutput(v string) error {
+	if v != "json" && v != "table" {
+		return fmt.Errorf("want json or table")
+	}
+	return nil
+}
+
+// file shares refreshed sessions through the config, memory keeps them
+// to the process
+func validateTokenCache(v string) error {
+	if v != "file" && v != "memory" {
+		return fmt.Errorf("want file or memory")
+	}
+	return nil
+}
+
+func lookupSetting(key string) *cliSetting {
+	for i := range cliSettings {
+		if cliSettings[i].key == key {
+			return &cliSettings[i]
+		}
+	}
+	return nil
+}
+
+// DFCLI_<KEY>, the bare name still works for the older settings
+func bindSettingEnv() {
+	for _, s := range cliSettings {
+		_ = viper.BindEnv(s.key, envName(s.key), strings.ToUpper(s.key))
+	}
+}
+
+func envName(parts ...string) string {
+	name := strings.ToUpper(strings.Join(parts, "_"))
+	return "DFCLI_" + strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(name)
+}
+
+// Command path below the root, "artifact upload" for dfcli artifact upload
+func commandKey(cmd *cobra.Command) string {
+	path := strings.Fields(cmd.CommandPath())
+	return strings.Join(path[1:], " ")
+}
+
+// Resolves artifact.upload.property to the artifact upload command and the
+// property flag. The flag must exist on the command or below it
+func parseDefaultKey(root *cobra.Command, key string) (string, string, error) {
+	parts := strings.Split(key, ".")
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("unknown setting %q, command defaults look like artifact.upload.property", key)
+	}
+	cmd := root
+	for _, part := range parts[:len(parts)-1] {
+		next, _, err := cmd.Find([]string{part})
+		if err != nil || next == cmd {
+			return "", "", fmt.Errorf("unknown command %q in %q", part, key)
+		}
+		cmd = next
+	}
+	flag := parts[len(parts)-1]
+	if !takesDefault(cmd, flag) {
+		return "", "", fmt.Errorf("%q has no --%s flag", commandKey(cmd), flag)
+	}
+	return commandKey(cmd), flag, nil
+}
+
+func takesDefault(cmd *cobra.Command, flag string) bool {
+	if findFlag(cmd, flag) != nil {
+		return true
+	}
+	if flag != "repo" {
+		return false
+	}
+	var annotated func(c *cobra.Command) bool
+	annotated = func(c *cobra.Command) bool {
+		if c.Annotations[repoDefaultAnnotation] != "" {
+			return true
+		}
+		for _, sub := range c.Commands() {
+			if annotated(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	return annotated(cmd)
+}
+
+func fileDefaults(file map[string]any) map[string]map[string]string {
+	out := map[string]map[string]string{}
+	raw, _ := file["defaults"].(map[string]any)
+	for path, flags := range raw {
+		m, _ := flags.(map[string]any)
+		for flag, v := range m {
+			if out[path] == nil {
+				out[path] = map[string]string{}
+			}
+			out[path][flag] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+// Default for flag on cmd, the most specific command wins and the
+// environment beats the file at the same level
+func commandDefault(cmd *cobra.Command, defaults map[string]map[string]string, flag string) (string, string, bool) {
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		path := commandKey(c)
+		if v, ok := os.LookupEnv(envName(path, flag)); ok {
+			return v, "env " + envName(path, flag), true
+		}
+		if v, ok := defaults[path][flag]; ok {
+			return v, "config " + path, true
+		}
+	}
+	return "", "", false
+}
+
+// Fills unset flags from the environment and config file before a command runs
+func applyCommandDefaults(cmd *cobra.Command) error {
+	file, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	defaults := fileDefaults(file)
+	output := viper.GetString("output")
+
+	var applyErr error
+	cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || applyErr != nil {
+			return
+		}
+		v, source, ok := commandDefault(cmd, defaults, f.Name)
+		if !ok && output != "" && f.Name == output && f.Value.Type() == "bool" {
+			v, source, ok = "true", "output", true
+		}
+		if !ok {
+			return
+		}
+		if err := f.Value.Set(v); err != nil {
+			applyErr = fmt.Errorf("invalid default %q for --%s (%s): %v", v, f.Name, source, err)
+			return
+		}
+		debugf("--%s=%s from %s", f.Name, v, source)
+	})
+	return applyErr
+}
+
+// Repo for commands taking it as an argument, empty when none is set
+func defaultRepo(cmd *cobra.Command) string {
+	file, err := readConfigFile()
+	if err != nil {
+		return ""
+	}
+	v, _, _ := commandDefault(cmd, fileDefaults(file), "repo")
+	return v
+}
+
+// Prepends the repo default when the leading repo argument was left out
+func withDefaultRepo(cmd *cobra.Command, args []string, want int) ([]string, error) {
+	if len(args) == want {
+		return args, nil
+	}
+	repo := defaultRepo(cmd)
+	if repo == "" {
+		return nil, fmt.Errorf("repository required, pass it or set one with dfcli config set artifact.repo NAME")
+	}
+	return append([]string{repo}, args...), nil
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show and change dfcli settings and command defaults",
+		Long: `Settings live in ~/.dfcli/config.json beside the stored login.
+
+Top level keys: server, timeout, idle_timeout, transfer_timeout, debug,
+no_cache, output and token_cache. Output json or table turns on --json or
+--table wherever a command has it. Token_cache file (the default) shares
+refreshed sessions with other dfcli runs through this file, memory keeps
+them to the process and never writes them back. Download_cache names a
+directory where files fetched by artifact download --parallel are kept by
+checksum, download_cache_max_mb caps it (10240 by default).
+
+Writes take a lock beside the file and replace it whole, so parallel runs
+never leave it half written.
+
+Any other key sets a flag default for a command and everything below it,
+written as the command path plus the flag name:
+
+  dfcli config set artifact.namespace platform
+  dfcli config set artifact.upload.property team=infra,ci=true
+  dfcli config set artifact.repo builds
+
+A repo default also fills in the repository argument of artifact upload
+and download. Flags on the command line always win. DFCLI_<KEY> in the
+environment beats the file, e.g. DFCLI_OUTPUT=json or
+DFCLI_ARTIFACT_UPLOAD_PROPERTY=team=infra. The most specific command
+wins over its parents.`,
+		// Editing settings needs no server
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	cmd.AddCommand(newConfigGetCmd(), newConfigSetCmd(), newConfigUnsetCmd(), newConfigListCmd())
+	return cmd
+}
+
+func newConfigGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get [key]",
+		Short: "Print the effective value of a setting or command default",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			if lookupSetting(key) != nil {
+				fmt.Println(viper.GetString(key))
+				return nil
+			}
+			path, flag, err := parseDefaultKey(cmd.Root(), key)
+			if err != nil {
+				return err
+			}
+			target, _, _ := cmd.Root().Find(strings.Fields(path))
+			file, err := readConfigFile()
+			if err != nil {
+				return err
+			}
+			v, _, ok := commandDefault(target, fileDefaults(file), flag)
+			if !ok {
+				return fmt.Errorf("%s is not set", key)
+			}
+			fmt.Println(v)
+			return nil
+		},
+	}
+}
+
+func newConfigSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set [key] [value]",
+		Short: "Store a setting or command default",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, value := args[0], args[1]
+			if s := lookupSetting(key); s != nil {
+				if s.validate != nil {
+					if err := s.validate(value); err != nil {
+						return fmt.Errorf("invalid %s %q: %v", key, value, err)
+					}
+				}
+				return updateConfigFile(func(file map[string]any) error {
+					file[key] = value
+					return nil
+				})
+			}
+
+			path, flag, err := parseDefaultKey(cmd.Root(), key)
+			if err != nil {
+				return err
+			}
+			if err := checkFlagValue(cmd.Root(), path, flag, value); err != nil {
+				return err
+			}
+			return updateConfigFile(func(file map[string]any) error {
+				defaults, _ := file["defaults"].(map[string]any)
+				if defaults == nil {
+					defaults = map[string]any{}
+				}
+				flags, _ := defaults[path].(map[string]any)
+				if flags == nil {
+					flags = map[string]any{}
+				}
+				flags[flag] = value
+				defaults[path] = flags
+				file["defaults"] = defaults
+				return nil
+			})
+		},
+	}
+}
+
+// Parses value into a scratch flag of the same type, so typos surface at
+// set time instead of on the next run
+func checkFlagValue(root *cobra.Command, path, flag, value string) error {
+	cmd, _, _ := root.Find(strings.Fields(path))
+	found := findFlag(cmd, flag)
+	if found == nil {
+		return nil
+	}
+	probe := pflag.NewFlagSet("probe", pflag.ContinueOnError)
+	switch found.Value.Type() {
+	case "bool":
+		probe.Bool(flag, false, "")
+	case "int":
+		probe.Int(flag, 0, "")
+	case "duration":
+		probe.Duration(flag, 0, "")
+	case "stringToString":
+		probe.StringToString(flag, nil, "")
+	case "stringSlice":
+		probe.StringSlice(flag, nil, "")
+	default:
+		return nil
+	}
+	if err := probe.Set(flag, value); err != nil {
+		return fmt.Errorf("invalid value %q for --%s: %v", value, flag, err)
+	}
+	return nil
+}
+
+// First command at or below cmd defining flag
+func findFlag(cmd *cobra.Command, flag string) *pflag.Flag {
+	if f := cmd.LocalFlags().Lookup(flag); f != nil {
+		return f
+	}
+	for _, sub := range cmd.Commands() {
+		if f := findFlag(sub, flag); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func newConfigUnsetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unset [key]",
+		Short: "Remove a setting or command default",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			if lookupSetting(key) != nil {
+				return updateConfigFile(func(file map[string]any) error {
+					delete(file, key)
+					return nil
+				})
+			}
+			path, flag, err := parseDefaultKey(cmd.Root(), key)
+			if err != nil {
+				return err
+			}
+			return updateConfigFile(func(file map[string]any) error {
+				defaults, _ := file["defaults"].(map[string]any)
+				if flags, _ := defaults[path].(map[string]any); flags != nil {
+					delete(flags, flag)
+					if len(flags) == 0 {
+						delete(defaults, path)
+					}
+				}
+				if len(defaults) == 0 {
+					delete(file, "defaults")
+				}
+				return nil
+			})
+		},
+	}
+}
+
+func newConfigListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List settings and command defaults with where each value comes from",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := readConfigFile()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+			for _, s := range cliSettings {
+				source := "default"
+				if _, ok := os.LookupEnv(envName(s.key)); ok {
+					source = "env " + envName(s.key)
+				} else if _, ok := file[s.key]; ok {
+					source = "config"
+				}
+				v := viper.GetString(s.key)
+				if v == "" {
+					v = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", s.key, v, source)
+			}
+
+			defaults := fileDefaults(file)
+			var keys []string
+			for path, flags := range defaults {
+				for flag := range flags {
+					keys = append(keys, strings.ReplaceAll(path, " ", ".")+"."+flag)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				i := strings.LastIndex(key, ".")
+				path, flag := strings.ReplaceAll(key[:i], ".", " "), key[i+1:]
+				v, source := defaults[path][flag], "config"
+				if env, ok := os.LookupEnv(envName(path, flag)); ok {
+					v, source = env, "env "+envName(path, flag)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", key, v, source)
+			}
+			return w.Flush()
+		},
+	}
+}
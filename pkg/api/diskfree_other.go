@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package api
+
+func freeBytes(string) (uint64, bool) {
+	return 0, false
+}
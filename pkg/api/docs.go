@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+)
+
+func newDocsCmd(version string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Generate reference documentation for dfcli",
+		Long: `Writes man pages or markdown for every dfcli command, generated from this
+binary so the pages always match its flags. Packages build them at install
+time rather than shipping copies that drift.
+
+SOURCE_DATE_EPOCH in the environment dates the man pages, for reproducible
+package builds. Without it they carry the binary's build date.`,
+		// Generating pages needs no server
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	cmd.AddCommand(newDocsManCmd(version), newDocsMarkdownCmd())
+	return cmd
+}
+
+func newDocsManCmd(version string) *cobra.Command {
+	var dir, section string
+
+	cmd := &cobra.Command{
+		Use:   "man",
+		Short: "Write a man page per command",
+		Long: `Writes dfcli.1, dfcli-image.1, dfcli-image-list.1 and so on, one page
+per command, into the output directory.
+
+  dfcli docs man --dir debian/dfcli/usr/share/man/man1`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			date, err := docsDate()
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+			header := &doc.GenManHeader{
+				Title:   "DFCLI",
+				Section: section,
+				Date:    &date,
+				Source:  "dfcli " + version,
+				Manual:  "DistroFace Manual",
+			}
+			root := docsRoot(cmd)
+			if err := doc.GenManTree(root, header, dir); err != nil {
+				return fmt.Errorf("failed to write man pages: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote man pages to %s\n", dir)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&dir, "dir", "d", ".", "Directory to write the pages to")
+	cmd.Flags().StringVar(&section, "section", "1", "Man section the pages belong to")
+	return cmd
+}
+
+func newDocsMarkdownCmd() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "markdown",
+		Short: "Write a markdown page per command",
+		Long: `Writes dfcli.md, dfcli_image.md and so on, one linked page per command,
+into the output directory.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+			if err := doc.GenMarkdownTree(docsRoot(cmd), dir); err != nil {
+				return fmt.Errorf("failed to write markdown: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote markdown to %s\n", dir)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&dir, "dir", "d", ".", "Directory to write the pages to")
+	return cmd
+}
+
+// Command tree the pages describe, stamped without a generation date so
+// the output only changes when commands do
+func docsRoot(cmd *cobra.Command) *cobra.Command {
+	root := cmd.Root()
+	root.InitDefaultHelpCmd()
+	root.InitDefaultCompletionCmd()
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		c.DisableAutoGenTag = true
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+	return root
+}
+
+// SOURCE_DATE_EPOCH when set, otherwise the binary's modification time
+func docsDate() (time.Time, error) {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", epoch, err)
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	if self, err := os.Executable(); err == nil {
+		if fi, err := os.Stat(self); err == nil {
+			return fi.ModTime().UTC(), nil
+		}
+	}
+	return time.Now().UTC(), nil
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Expiry from --in or --clear, exactly one of them. Zero clears
+func expiryFlags(in string, clear bool) (time.Duration, error) {
+	if (in == "") == !clear {
+		return 0, fmt.Errorf("give either --in or --clear")
+	}
+	if clear {
+		return 0, nil
+	}
+	ttl, err := parseAge(in)
+	if err != nil {
+		return 0, err
+	}
+	if ttl == 0 {
+		return 0, fmt.Errorf("--in must be longer than zero, use --clear to keep it")
+	}
+	return ttl, nil
+}
+
+func newArtifactExpireCmd() *cobra.Command {
+	var namespace, in string
+	var clear bool
+	cmd := &cobra.Command{
+		Use:   "expire [repo] [version] [path]",
+		Short: "Set, extend or clear when an artifact is deleted",
+		Long: `Have the server delete an artifact once a time runs out, counted from
+now, or keep it again with --clear. Uploads set one with --expires.
+
+  dfcli artifact expire builds pr-118 app.tar --in 7d
+  dfcli artifact expire builds pr-118 app.tar --clear`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ttl, err := expiryFlags(in, clear)
+			if err != nil {
+				return err
+			}
+			ref := repoArg(args[0], namespace)
+			resp, err := client.Artifacts().SetArtifactExpiry(cmd.Context(), connect.NewRequest(&v1.SetArtifactExpiryRequest{
+				RepoName:         ref.Name,
+				Namespace:        ref.Namespace,
+				Version:          args[1],
+				Path:             args[2],
+				ExpiresInSeconds: int64(ttl / time.Second),
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if at := resp.Msg.GetArtifact().GetExpiresAt(); at != nil {
+				fmt.Printf("%s %s expires %s\n", args[1], args[2], formatTimestamp(at))
+			} else {
+				fmt.Printf("%s %s no longer expires\n", args[1], args[2])
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&in, "in", "", "Delete it this long from now, e.g. 14d or 36h")
+	cmd.Flags().BoolVar(&clear, "clear", false, "Keep it, dropping the expiry")
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
+	return cmd
+}
+
+func newImageExpireCmd() *cobra.Command {
+	var in string
+	var clear bool
+	cmd := &cobra.Command{
+		Use:   "expire [namespace/image:tag]",
+		Short: "Set, extend or clear when a tag is removed",
+		Long: `Have the server remove a tag once a time runs out, counted from now, or
+keep it again with --clear. The expiry stays with the tag across pushes.
+Pushes set one with 'dfcli image push --expires' or an image label:
+
+  LABEL distroface.expires=14d
+
+  dfcli image expire myorg/app:pr-118 --in 3d
+  dfcli image expire myorg/app:pr-118 --clear`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, tag, err := parseImageTag(args[0])
+			if err != nil {
+				return err
+			}
+			ttl, err := expiryFlags(in, clear)
+			if err != nil {
+				return err
+			}
+			at, err := client.setTagExpiry(cmd.Context(), namespace, name, tag, ttl)
+			if err != nil {
+				return err
+			}
+			if at != nil {
+				fmt.Printf("%s expires %s\n", args[0], formatTimestamp(at))
+			} else {
+				fmt.Printf("%s no longer expires\n", args[0])
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&in, "in", "", "Remove it this long from now, e.g. 14d or 36h")
+	cmd.Flags().BoolVar(&clear, "clear", false, "Keep it, dropping the expiry")
+	return cmd
+}
+
+// Nil when cleared
+func (c *Client) setTagExpiry(ctx context.Context, namespace, name, tag string, ttl time.Duration) (*timestamppb.Timestamp, error) {
+	resp, err := c.Repositories().SetTagExpiry(ctx, connect.NewRequest(&v1.SetTagExpiryRequest{
+		Namespace:        namespace,
+		Name:             name,
+		Tag:              tag,
+		ExpiresInSeconds: int64(ttl / time.Second),
+	}))
+	if err != nil {
+		return nil, rpcErr(err)
+	}
+	return resp.Msg.ExpiresAt, nil
+}
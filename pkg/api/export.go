@@ -0,0 +1,221 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Signed content manifests for air-gapped sync",
+		Long: `Describe repository content as a signed JSON manifest listing every tag,
+blob and artifact file with its digest and size. Keep the manifest of what
+was last carried across the gap and diff against it next time to get
+exactly the blobs and files to transfer.
+
+Saving FILE also writes FILE.sig with the base64 signature and FILE.pem with
+the instance signing key. Pin that key on the receiving side by keeping a
+FILE.pem obtained over a trusted channel and passing it to verify --key.`,
+	}
+	cmd.AddCommand(
+		newExportManifestCmd(),
+		newExportDiffCmd(),
+		newExportVerifyCmd(),
+	)
+	return cmd
+}
+
+func exportSelectionFlags(cmd *cobra.Command, sel *v1.ExportSelection) {
+	cmd.Flags().StringArrayVar(&sel.Images, "image", nil, "Image repository as namespace/name (repeatable)")
+	cmd.Flags().StringArrayVar(&sel.Artifacts, "artifact", nil, "Artifact repository as namespace/name (repeatable)")
+}
+
+func newExportManifestCmd() *cobra.Command {
+	sel := &v1.ExportSelection{}
+	var output string
+
+	cmd := &cobra.Command{
+		Use:     "manifest",
+		Short:   "Write the signed manifest of the selected repositories",
+		Example: `  dfcli export manifest --image myorg/app --artifact myorg/builds -o export.json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.Export().GetExportManifest(cmd.Context(), connect.NewRequest(&v1.GetExportManifestRequest{Selection: sel}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			msg := resp.Msg
+			if err := verifyExport(msg.GetSigned().GetManifest(), msg.GetSigned().GetSignature(), []byte(msg.GetSigned().GetPublicKeyPem())); err != nil {
+				return err
+			}
+			if err := writeSignedExport(output, msg.GetSigned()); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%d images (%d tags, %d blobs), %d artifact files, %s, signed by key %s\n",
+				msg.Images, msg.Tags, msg.Blobs, msg.Files, formatSize(msg.TotalBytes), msg.GetSigned().GetKeyId())
+			return nil
+		},
+	}
+	exportSelectionFlags(cmd, sel)
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the manifest to this file instead of stdout")
+	return cmd
+}
+
+func newExportDiffCmd() *cobra.Command {
+	sel := &v1.ExportSelection{}
+	var next string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "diff BASE",
+		Short: "List what a side holding the BASE manifest lacks",
+		Long: `Compare current content against a manifest written earlier and list the
+blobs, tags and artifact files to carry across, plus what was removed. With
+no --image or --artifact flags the repositories in BASE are compared.
+--save writes the current signed manifest to use as the next BASE.`,
+		Example: `  dfcli export diff last-export.json --save next-export.json`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			resp, err := client.Export().DiffExportManifest(cmd.Context(), connect.NewRequest(&v1.DiffExportManifestRequest{
+				Selection: sel,
+				Base:      base,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			msg := resp.Msg
+			if next != "" {
+				if err := writeSignedExport(next, msg.GetCurrent()); err != nil {
+					return err
+				}
+			}
+			if asJSON {
+				// The signed manifest already went to --save, keep the plan readable
+				plan := proto.Clone(msg).(*v1.DiffExportManifestResponse)
+				plan.Current = nil
+				return printProtoJSON([]proto.Message{plan})
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, b := range msg.Blobs {
+				fmt.Fprintf(w, "blob\t%s\t%s\t%s\n", b.Repository, b.Digest, formatSize(b.Size))
+			}
+			for _, f := range msg.Files {
+				fmt.Fprintf(w, "file\t%s\t%s/%s\t%s\n", f.Repository, f.Version, f.Path, formatSize(f.Size))
+			}
+			for _, t := range msg.Tags {
+				fmt.Fprintf(w, "tag\t%s:%s\t%s\t\n", t.Repository, t.Tag, t.Digest)
+			}
+			for _, t := range msg.RemovedTags {
+				fmt.Fprintf(w, "untag\t%s:%s\t%s\t\n", t.Repository, t.Tag, t.Digest)
+			}
+			for _, f := range msg.RemovedFiles {
+				fmt.Fprintf(w, "remove\t%s\t%s/%s\t\n", f.Repository, f.Version, f.Path)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%d blobs and %d files to transfer (%s), %d tags to set, %d tags and %d files removed\n",
+				len(msg.Blobs), len(msg.Files), formatSize(msg.TransferBytes), len(msg.Tags), len(msg.RemovedTags), len(msg.RemovedFiles))
+			return nil
+		},
+	}
+	exportSelectionFlags(cmd, sel)
+	cmd.Flags().StringVar(&next, "save", "", "Write the current signed manifest to this file")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output the plan as JSON")
+	return cmd
+}
+
+func newExportVerifyCmd() *cobra.Command {
+	var keyFile string
+
+	cmd := &cobra.Command{
+		Use:   "verify FILE",
+		Short: "Check a saved manifest against its signature, works offline",
+		Long: `Check FILE against FILE.sig. The key is read from --key, or from FILE.pem
+which travels with the manifest and so only proves integrity, not origin.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifest, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			sig, err := os.ReadFile(args[0] + ".sig")
+			if err != nil {
+				return err
+			}
+			if keyFile == "" {
+				keyFile = args[0] + ".pem"
+				fmt.Fprintln(os.Stderr, "Warning: no --key given, trusting the key shipped next to the manifest")
+			}
+			key, err := os.ReadFile(keyFile)
+			if err != nil {
+				return err
+			}
+			if err := verifyExport(manifest, strings.TrimSpace(string(sig)), key); err != nil {
+				return err
+			}
+			fmt.Println("Signature OK")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&keyFile, "key", "", "Trusted public key PEM of the exporting instance")
+	return cmd
+}
+
+// Signature is base64 ASN.1 ECDSA over the SHA-256 of the manifest bytes
+func verifyExport(manifest []byte, signature string, keyPEM []byte) error {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("signing key is not PEM")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parsing signing key: %w", err)
+	}
+	pub, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("signing key is not ECDSA")
+	}
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	sum := sha256.Sum256(manifest)
+	if !ecdsa.VerifyASN1(pub, sum[:], raw) {
+		return fmt.Errorf("manifest signature does not verify")
+	}
+	return nil
+}
+
+// Manifest to path with .sig and .pem beside it, or the manifest alone to stdout
+func writeSignedExport(path string, signed *v1.SignedExportManifest) error {
+	if path == "" {
+		_, err := os.Stdout.Write(append(signed.GetManifest(), '\n'))
+		return err
+	}
+	if err := os.WriteFile(path, signed.GetManifest(), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".sig", []byte(signed.GetSignature()+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".pem", []byte(signed.GetPublicKeyPem()), 0644)
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// One matched artifact and where it lands locally
+type fetchJob struct {
+	artifact Artifact
+	dest     string
+}
+
+// Client side alternative to the server built archive. Runs the search,
+// then pulls every matching file on its own through the data plane with
+// workers in parallel, laid out like the archive would be. Files sharing a
+// destination keep the first match in sort order
+func (c *Client) downloadParallel(ctx context.Context, opts SearchOptions, outputPath string, flat bool, workers int) error {
+	// Path selects a directory here, so it filters client side and the
+	// limit applies after it
+	if err := c.requireCapability(ctx, "artifacts.range_downloads", "parallel download"); err != nil {
+		return err
+	}
+	num, filter := opts.Num, opts.Path
+	opts.Num, opts.Path = 0, ""
+	found, err := c.searchArtifacts(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	root, err := filepath.Abs(outputPath)
+	if err != nil {
+		return err
+	}
+	var jobs []fetchJob
+	taken := map[string]bool{}
+	skipped := 0
+	for _, a := range found.Results {
+		if !pathSelected(a.Path, filter) {
+			continue
+		}
+		if num > 0 && len(jobs) >= num {
+			break
+		}
+		rel := path.Join(a.Version, a.Path)
+		if flat {
+			rel = path.Base(a.Path)
+		}
+		dest := filepath.Join(root, filepath.FromSlash(rel))
+		if !strings.HasPrefix(dest, root+string(filepath.Separator)) {
+			return fmt.Errorf("artifact path %q escapes the output directory", a.Path)
+		}
+		// Windows and macOS disks fold case, two such paths are one file
+		key := dest
+		if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+			key = strings.ToLower(dest)
+		}
+		if taken[key] {
+			skipped++
+			continue
+		}
+		taken[key] = true
+		jobs = append(jobs, fetchJob{artifact: a, dest: dest})
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("no artifacts matched in %s", opts.Ref)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Skipping %d artifacts that share a destination with an earlier match\n", skipped)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+		bytes  atomic.Int64
+		cached atomic.Int64
+	)
+	work := make(chan fetchJob)
+	for range min(workers, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				n, hit, err := c.fetchArtifact(ctx, opts.Ref, job)
+				if err != nil {
+					mu.Lock()
+					failed = append(failed, fmt.Sprintf("%s/%s: %v", job.artifact.Version, job.artifact.Path, err))
+					mu.Unlock()
+					continue
+				}
+				bytes.Add(n)
+				if hit {
+					cached.Add(1)
+					debugf("Copied %s/%s (%s) from the download cache", job.artifact.Version, job.artifact.Path, formatSize(n))
+					continue
+				}
+				debugf("Downloaded %s/%s (%s)", job.artifact.Version, job.artifact.Path, formatSize(n))
+			}
+		}()
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- job
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, f := range failed {
+		fmt.Fprintf(os.Stderr, "Failed %s\n", f)
+	}
+	fromCache := ""
+	if n := cached.Load(); n > 0 {
+		fromCache = fmt.Sprintf(", %d from the download cache", n)
+	}
+	fmt.Printf("Downloaded %d of %d files (%s) to %s%s\n", len(jobs)-len(failed), len(jobs), formatSize(bytes.Load()), outputPath, fromCache)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d downloads failed", len(failed), len(jobs))
+	}
+	return nil
+}
+
+// Streams one file into a temp sibling and renames it into place, so an
+// interrupted run never leaves a truncated file behind. With a download
+// cache the request names the cached digest and a 304 from the server
+// confirms it, the file then comes from disk. Reports whether it did
+func (c *Client) fetchArtifact(ctx context.Context, ref RepoRef, job fetchJob) (int64, bool, error) {
+	a := job.artifact
+	endpoint := ref.basePath() + "/" + url.PathEscape(a.Version) + "/" + escapeArtifactPath(a.Path)
+	if err := os.MkdirAll(filepath.Dir(job.dest), 0755); err != nil {
+		return 0, false, err
+	}
+
+	var header http.Header
+	if c.Downloads.has(a.digest) {
+		header = http.Header{"If-None-Match": {`"` + a.digest + `"`}}
+	}
+	resp, err := c.doDataHeader(ctx, http.MethodGet, endpoint, nil, header)
+	if err != nil {
+		return 0, false, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		n, err := c.Downloads.copyTo(a.digest, job.dest)
+		if err == nil {
+			return n, true, nil
+		}
+		debugf("Download cache: %v", err)
+		if resp, err = c.doData(ctx, http.MethodGet, endpoint, nil); err != nil {
+			return 0, false, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if err := ensureFree(filepath.Dir(job.dest), a.Size); err != nil {
+		return 0, false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(job.dest), ".dfcli-fetch-*")
+	if err != nil {
+		return 0, false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if a.Size > 0 && n != a.Size {
+		return 0, false, fmt.Errorf("got %d bytes, expected %d", n, a.Size)
+	}
+	if a.digest != "" {
+		if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != a.digest {
+			return 0, false, fmt.Errorf("checksum %s, expected %s", got, a.digest)
+		}
+		c.Downloads.add(a.digest, tmp.Name())
+	}
+	return n, false, os.Rename(tmp.Name(), job.dest)
+}
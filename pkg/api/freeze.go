@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newImageFreezeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "freeze",
+		Short: "Manage windows that hold image pushes",
+		Long: `Freeze windows refuse pushes to matching repositories while they run,
+e.g. prod/* during a release. Patterns are path globs, a bare namespace
+covers every repository in it. Holders of the freeze override permission
+push through.`,
+	}
+	cmd.AddCommand(
+		newImageFreezeListCmd(),
+		newImageFreezeAddCmd(),
+		newImageFreezeRemoveCmd(),
+	)
+	return cmd
+}
+
+func newImageFreezeListCmd() *cobra.Command {
+	var repo string
+	var all, asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List running and upcoming freeze windows",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.Freezes().ListFreezeWindows(cmd.Context(), connect.NewRequest(&v1.ListFreezeWindowsRequest{
+				IncludePast: all,
+				Repository:  repo,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				msgs := make([]proto.Message, len(resp.Msg.Windows))
+				for i, w := range resp.Msg.Windows {
+					msgs[i] = w
+				}
+				return printProtoJSON(msgs)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tPATTERN\tSTARTS\tENDS\tACTIVE\tBY\tREASON")
+			for _, f := range resp.Msg.Windows {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\t%s\n", f.Id, f.Pattern, formatTimestamp(f.StartsAt),
+					formatTimestamp(f.EndsAt), f.Active, f.CreatedBy, f.Reason)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&repo, "repo", "", "Only windows covering this namespace/name")
+	cmd.Flags().BoolVar(&all, "all", false, "Include windows that already ended")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newImageFreezeAddCmd() *cobra.Command {
+	var reason, start, end string
+	var duration time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "add PATTERN",
+		Short: "Hold pushes to repositories matching a pattern",
+		Example: `  dfcli image freeze add 'prod/*' --for 2h --reason "release 4.2"
+  dfcli image freeze add prod --start 2026-05-01T18:00:00Z --end 2026-05-02T06:00:00Z`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if end != "" && duration > 0 {
+				return fmt.Errorf("--end and --for are mutually exclusive")
+			}
+			req := &v1.CreateFreezeWindowRequest{Pattern: args[0], Reason: reason}
+			startsAt := time.Now()
+			if start != "" {
+				t, err := time.Parse(time.RFC3339, start)
+				if err != nil {
+					return fmt.Errorf("invalid --start, want RFC3339: %v", err)
+				}
+				startsAt = t
+				req.StartsAt = timestamppb.New(t)
+			}
+			switch {
+			case end != "":
+				t, err := time.Parse(time.RFC3339, end)
+				if err != nil {
+					return fmt.Errorf("invalid --end, want RFC3339: %v", err)
+				}
+				req.EndsAt = timestamppb.New(t)
+			case duration > 0:
+				req.EndsAt = timestamppb.New(startsAt.Add(duration))
+			}
+
+			resp, err := client.Freezes().CreateFreezeWindow(cmd.Context(), connect.NewRequest(req))
+			if err != nil {
+				return rpcErr(err)
+			}
+			f := resp.Msg.Window
+			until := "until removed"
+			if f.EndsAt != nil {
+				until = "until " + formatTimestamp(f.EndsAt)
+			}
+			fmt.Printf("Froze %s from %s %s (id %s)\n", f.Pattern, formatTimestamp(f.StartsAt), until, f.Id)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&reason, "reason", "", "Shown to anyone whose push is refused")
+	cmd.Flags().StringVar(&start, "start", "", "Start time in RFC3339, defaults to now")
+	cmd.Flags().StringVar(&end, "end", "", "End time in RFC3339, open ended when unset")
+	cmd.Flags().DurationVar(&duration, "for", 0, "Length of the window, instead of --end")
+	return cmd
+}
+
+func newImageFreezeRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove ID",
+		Short: "Lift a freeze window",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := client.Freezes().DeleteFreezeWindow(cmd.Context(), connect.NewRequest(&v1.DeleteFreezeWindowRequest{Id: args[0]})); err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Lifted freeze %s\n", args[0])
+			return nil
+		},
+	}
+}
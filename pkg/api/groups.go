@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+func newGroupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "group",
+		Short: "Inspect groups (roles) and their members",
+	}
+	cmd.AddCommand(
+		newGroupMembersCmd(),
+	)
+	return cmd
+}
+
+func newUserCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Inspect users",
+	}
+	cmd.AddCommand(
+		newUserMembershipsCmd(),
+		newUserDefaultNamespaceCmd(),
+	)
+	return cmd
+}
+
+// Exact name lookup, the list filter is the only by-name path
+func (c *Client) findRole(ctx context.Context, name string) (*v1.Role, error) {
+	resp, err := c.Roles().ListRoles(ctx, connect.NewRequest(&v1.ListRolesRequest{
+		Page: &v1.PageRequest{
+			PageSize: 1,
+			Query: &v1.Query{Filters: []*v1.FieldFilter{
+				{Field: "name", Match: v1.MatchKind_MATCH_KIND_EQUALS, Value: name},
+			}},
+		},
+	}))
+	if err != nil {
+		return nil, rpcErr(err)
+	}
+	if len(resp.Msg.Roles) == 0 {
+		return nil, fmt.Errorf("group %q not found", name)
+	}
+	return resp.Msg.Roles[0], nil
+}
+
+// Follows page tokens until the member list is exhausted
+func (c *Client) listRoleMembers(ctx context.Context, roleID string) ([]*v1.User, error) {
+	var users []*v1.User
+	token := ""
+	for {
+		resp, err := c.Roles().ListRoleMembers(ctx, connect.NewRequest(&v1.ListRoleMembersRequest{
+			RoleId: roleID,
+			Page:   &v1.PageRequest{PageSize: 500, PageToken: token},
+		}))
+		if err != nil {
+			return nil, rpcErr(err)
+		}
+		users = append(users, resp.Msg.Users...)
+		token = resp.Msg.Page.GetNextPageToken()
+		if token == "" {
+			return users, nil
+		}
+	}
+}
+
+func newGroupMembersCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "members [group]",
+		Short: "List users in a group",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			role, err := client.findRole(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			users, err := client.listRoleMembers(cmd.Context(), role.Id)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				msgs := make([]proto.Message, len(users))
+				for i, u := range users {
+					msgs[i] = u
+				}
+				return printProtoJSON(msgs)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "USERNAME\tEMAIL\tPROVIDER\tACTIVE")
+			for _, u := range users {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", u.Username, u.Email, u.AuthProvider, u.IsActive)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+// Group to permission chain for one user
+type membership struct {
+	Group       string   `json:"group"`
+	System      bool     `json:"system"`
+	Permissions []string `json:"permissions"`
+}
+
+func formatPermission(p *v1.Permission) string {
+	s := p.Resource + ":" + p.Action
+	if p.ObjectId != "" && p.ObjectId != "*" {
+		s += ":" + p.ObjectId
+	}
+	return s
+}
+
+func newUserMembershipsCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "memberships [username]",
+		Short: "Show a user's groups and the permissions each one grants",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			resp, err := client.Users().GetUser(ctx, connect.NewRequest(&v1.GetUserRequest{Username: args[0]}))
+			if err != nil {
+				return rpcErr(err)
+			}
+
+			// Roles are omitted from the public view of other users
+			var chains []membership
+			for _, ref := range resp.Msg.User.Roles {
+				role, err := client.Roles().GetRole(ctx, connect.NewRequest(&v1.GetRoleRequest{Id: ref.Id}))
+				if err != nil {
+					return rpcErr(err)
+				}
+				m := membership{Group: role.Msg.Role.Name, System: role.Msg.Role.IsSystem, Permissions: []string{}}
+				for _, p := range role.Msg.Role.Permissions {
+					m.Permissions = append(m.Permissions, formatPermission(p))
+				}
+				chains = append(chains, m)
+			}
+
+			if asJSON {
+				return printJSON(chains)
+			}
+			if len(chains) == 0 {
+				fmt.Printf("%s is not a member of any visible group\n", args[0])
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "GROUP\tPERMISSIONS")
+			for _, m := range chains {
+				perms := "-"
+				if len(m.Permissions) > 0 {
+					perms = strings.Join(m.Permissions, ", ")
+				}
+				fmt.Fprintf(w, "%s\t%s\n", m.Group, perms)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newUserDefaultNamespaceCmd() *cobra.Command {
+	var reset bool
+
+	cmd := &cobra.Command{
+		Use:   "default-namespace [namespace]",
+		Short: "Show or set where bare repository names resolve",
+		Long: `Bare names like myapp in docker push registry.example.com/myapp:latest,
+and artifact repos given without a namespace, resolve to your default
+namespace. It is your own until you pick an organization you belong to.
+Without an argument the current one is printed, --reset goes back to
+your own.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			if len(args) == 0 && !reset {
+				resp, err := client.Auth().GetCurrentUser(ctx, connect.NewRequest(&v1.GetCurrentUserRequest{}))
+				if err != nil {
+					return rpcErr(err)
+				}
+				user := resp.Msg.User
+				if user.DefaultNamespace == "" {
+					fmt.Printf("%s (your own)\n", user.Username)
+				} else {
+					fmt.Println(user.DefaultNamespace)
+				}
+				return nil
+			}
+			if len(args) == 1 && reset {
+				return fmt.Errorf("give a namespace or --reset, not both")
+			}
+			namespace := ""
+			if len(args) == 1 {
+				namespace = args[0]
+			}
+			resp, err := client.Users().UpdateUser(ctx, connect.NewRequest(&v1.UpdateUserRequest{DefaultNamespace: &namespace}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if ns := resp.Msg.User.DefaultNamespace; ns != "" {
+				fmt.Printf("Bare repository names now resolve to %s\n", ns)
+			} else {
+				fmt.Printf("Bare repository names now resolve to %s (your own)\n", resp.Msg.User.Username)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&reset, "reset", false, "Resolve bare names to your own namespace again")
+	return cmd
+}
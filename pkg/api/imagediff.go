@@ -0,0 +1,338 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+)
+
+// Config blob media types, oci and docker schema2
+var imageConfigTypes = []string{
+	"application/vnd.oci.image.config.v1+json",
+	"application/vnd.docker.container.image.v1+json",
+}
+
+type diffLayer struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// One config value that differs, empty Old means added and empty New removed
+type diffChange struct {
+	Field string `json:"field"`
+	Key   string `json:"key,omitempty"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+type imageDiff struct {
+	From          string       `json:"from"`
+	To            string       `json:"to"`
+	FromDigest    string       `json:"from_digest"`
+	ToDigest      string       `json:"to_digest"`
+	Platform      string       `json:"platform,omitempty"`
+	AddedLayers   []diffLayer  `json:"added_layers"`
+	RemovedLayers []diffLayer  `json:"removed_layers"`
+	SharedLayers  int          `json:"shared_layers"`
+	Config        []diffChange `json:"config"`
+	FromSize      int64        `json:"from_size"`
+	ToSize        int64        `json:"to_size"`
+}
+
+func newImageDiffCmd() *cobra.Command {
+	var platform string
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "diff [namespace/image:tag] [namespace/image:tag|tag]",
+		Short: "Compare the layers and config of two image tags",
+		Long: `Show the layers added and removed between two tags, changed labels,
+env, entrypoint, cmd, working dir and ports, and the size delta. A bare tag
+as the second argument names a tag of the same image. Multi-arch tags are
+compared for --platform, the local platform by default.
+
+  dfcli image diff myorg/app:1.4.1 1.4.2`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fromNS, fromName, fromTag, err := parseImageTag(args[0])
+			if err != nil {
+				return err
+			}
+			to := args[1]
+			if !strings.Contains(to, "/") {
+				to = fromNS + "/" + fromName + ":" + strings.TrimPrefix(to, ":")
+			}
+			toNS, toName, toTag, err := parseImageTag(to)
+			if err != nil {
+				return err
+			}
+			if platform == "" {
+				platform = "linux/" + runtime.GOARCH
+			}
+
+			from, err := resolveImage(cmd.Context(), fromNS, fromName, fromTag, platform)
+			if err != nil {
+				return err
+			}
+			target, err := resolveImage(cmd.Context(), toNS, toName, toTag, platform)
+			if err != nil {
+				return err
+			}
+			d := diffImages(from, target)
+			d.From, d.To = args[0], to
+			if from.GetPlatform() != nil {
+				d.Platform = formatPlatform(from.GetPlatform())
+			}
+
+			if asJSON {
+				data, err := json.MarshalIndent(d, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+			return printImageDiff(d)
+		},
+	}
+	cmd.Flags().StringVar(&platform, "platform", "", "Platform of multi-arch tags to compare, as os/arch[/variant]")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func parseImageTag(arg string) (namespace, name, tag string, err error) {
+	ref, tag, _ := strings.Cut(arg, ":")
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" || tag == "" {
+		return "", "", "", fmt.Errorf("image must be namespace/name:tag (e.g. myorg/app:1.0), got %q", arg)
+	}
+	return namespace, name, tag, nil
+}
+
+// Image manifest of a tag, picking the platform's child of an index
+func resolveImage(ctx context.Context, namespace, name, tag, platform string) (*v1.Descriptor, error) {
+	resp, err := client.Repositories().ResolveTag(ctx, connect.NewRequest(&v1.ResolveTagRequest{
+		Namespace: namespace,
+		Name:      name,
+		Tag:       tag,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s:%s: %w", namespace, name, tag, rpcErr(err))
+	}
+	desc := resp.Msg.GetDescriptor_()
+	if desc.GetImageConfig() != nil || !slices.ContainsFunc(desc.GetChildren(), func(c *v1.Descriptor) bool { return c.GetPlatform() != nil }) {
+		return desc, nil
+	}
+
+	var available []string
+	for _, c := range desc.GetChildren() {
+		p := formatPlatform(c.GetPlatform())
+		if p == platform || strings.HasPrefix(p, platform+"/") {
+			return c, nil
+		}
+		available = append(available, p)
+	}
+	return nil, fmt.Errorf("%s/%s:%s has no %s image, pick one of %s with --platform", namespace, name, tag, platform, strings.Join(available, ", "))
+}
+
+func formatPlatform(p *v1.Platform) string {
+	s := p.GetOs() + "/" + p.GetArchitecture()
+	if p.GetVariant() != "" {
+		s += "/" + p.GetVariant()
+	}
+	return s
+}
+
+// Layers of a manifest with the build step that made each one
+func imageLayers(desc *v1.Descriptor) []diffLayer {
+	var steps []string
+	for _, h := range desc.GetImageConfig().GetHistory() {
+		if !h.GetEmptyLayer() {
+			steps = append(steps, h.GetCreatedBy())
+		}
+	}
+	var layers []diffLayer
+	for _, c := range desc.GetChildren() {
+		if slices.Contains(imageConfigTypes, c.GetMediaType()) {
+			continue
+		}
+		l := diffLayer{Digest: c.GetDigest(), Size: c.GetSizeBytes()}
+		if i := len(layers); i < len(steps) {
+			l.CreatedBy = steps[i]
+		}
+		layers = append(layers, l)
+	}
+	return layers
+}
+
+func diffImages(from, to *v1.Descriptor) *imageDiff {
+	d := &imageDiff{
+		FromDigest:    from.GetDigest(),
+		ToDigest:      to.GetDigest(),
+		FromSize:      from.GetSizeBytes(),
+		ToSize:        to.GetSizeBytes(),
+		AddedLayers:   []diffLayer{},
+		RemovedLayers: []diffLayer{},
+		Config:        []diffChange{},
+	}
+	fromLayers, toLayers := imageLayers(from), imageLayers(to)
+	inFrom, inTo := map[string]bool{}, map[string]bool{}
+	for _, l := range fromLayers {
+		inFrom[l.Digest] = true
+	}
+	for _, l := range toLayers {
+		inTo[l.Digest] = true
+		if !inFrom[l.Digest] {
+			d.AddedLayers = append(d.AddedLayers, l)
+		} else {
+			d.SharedLayers++
+		}
+	}
+	for _, l := range fromLayers {
+		if !inTo[l.Digest] {
+			d.RemovedLayers = append(d.RemovedLayers, l)
+		}
+	}
+
+	a, b := from.GetImageConfig(), to.GetImageConfig()
+	d.Config = append(d.Config, diffMaps("label", a.GetLabels(), b.GetLabels())...)
+	d.Config = append(d.Config, diffMaps("env", envMap(a.GetEnv()), envMap(b.GetEnv()))...)
+	for _, f := range []struct {
+		name     string
+		old, new []string
+	}{
+		{"entrypoint", a.GetEntrypoint(), b.GetEntrypoint()},
+		{"cmd", a.GetCmd(), b.GetCmd()},
+		{"working_dir", []string{a.GetWorkingDir()}, []string{b.GetWorkingDir()}},
+		{"exposed_ports", a.GetExposedPorts(), b.GetExposedPorts()},
+		{"volumes", a.GetVolumes(), b.GetVolumes()},
+	} {
+		if !slices.Equal(f.old, f.new) {
+			d.Config = append(d.Config, diffChange{Field: f.name, Old: formatList(f.old), New: formatList(f.new)})
+		}
+	}
+	return d
+}
+
+func diffMaps(field string, old, new map[string]string) []diffChange {
+	keys := map[string]bool{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	slices.Sort(sorted)
+
+	var changes []diffChange
+	for _, k := range sorted {
+		o, inOld := old[k]
+		n, inNew := new[k]
+		if inOld && inNew && o == n {
+			continue
+		}
+		changes = append(changes, diffChange{Field: field, Key: k, Old: o, New: n})
+	}
+	return changes
+}
+
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		m[k] = v
+	}
+	return m
+}
+
+// Exec form as json, empty for nothing
+func formatList(v []string) string {
+	if len(v) == 0 || (len(v) == 1 && v[0] == "") {
+		return ""
+	}
+	if len(v) == 1 {
+		return v[0]
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func printImageDiff(d *imageDiff) error {
+	fmt.Printf("%s (%s)\n%s (%s)\n", d.From, shortDigest(d.FromDigest), d.To, shortDigest(d.ToDigest))
+	if d.Platform != "" {
+		fmt.Printf("Platform: %s\n", d.Platform)
+	}
+	if d.FromDigest == d.ToDigest {
+		fmt.Println("\nSame image")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\nLayers (%d shared, %d added, %d removed)\n", d.SharedLayers, len(d.AddedLayers), len(d.RemovedLayers))
+	for _, l := range d.RemovedLayers {
+		fmt.Fprintf(w, "-\t%s\t%s\t%s\n", shortDigest(l.Digest), formatSize(l.Size), shortStep(l.CreatedBy))
+	}
+	for _, l := range d.AddedLayers {
+		fmt.Fprintf(w, "+\t%s\t%s\t%s\n", shortDigest(l.Digest), formatSize(l.Size), shortStep(l.CreatedBy))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(d.Config) == 0 {
+		fmt.Println("\nConfig unchanged")
+	} else {
+		fmt.Println("\nConfig")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, c := range d.Config {
+			name := c.Field
+			if c.Key != "" {
+				name += " " + c.Key
+			}
+			switch {
+			case c.Old == "":
+				fmt.Fprintf(w, "+\t%s\t%s\n", name, c.New)
+			case c.New == "":
+				fmt.Fprintf(w, "-\t%s\t%s\n", name, c.Old)
+			default:
+				fmt.Fprintf(w, "~\t%s\t%s -> %s\n", name, c.Old, c.New)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	delta := d.ToSize - d.FromSize
+	sign := "+"
+	if delta < 0 {
+		sign, delta = "-", -delta
+	}
+	fmt.Printf("\nSize: %s -> %s (%s%s)\n", formatSize(d.FromSize), formatSize(d.ToSize), sign, formatSize(delta))
+	return nil
+}
+
+// Build step without shell boilerplate, cut to fit a table row
+func shortStep(s string) string {
+	s = strings.TrimSuffix(strings.TrimSpace(s), " # buildkit")
+	s = strings.TrimPrefix(s, "/bin/sh -c #(nop) ")
+	s = strings.Replace(s, "/bin/sh -c ", "", 1)
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:57]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+)
+
+// What a prune spares and what makes a tag eligible
+type pruneCriteria struct {
+	keep        int
+	olderThan   time.Duration
+	unpulledFor time.Duration
+	match       string
+	exclude     []string
+}
+
+func newImagePruneCmd() *cobra.Command {
+	var (
+		repo        string
+		keep        int
+		olderThan   string
+		unpulledFor string
+		match       string
+		exclude     []string
+		reason      string
+		dryRun      bool
+		yes         bool
+	)
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Untag old or unused tags of an image repository",
+		Long: `Pick tags to remove from the server's push and pull records and untag
+them, for ad-hoc cleanup without a server side policy. The newest --keep
+tags by push time always stay, and so do tags matching --exclude, which
+take none of the kept slots. Of the rest, a tag goes when it passes every
+filter given: --older-than by push time, --unpulled-for by its last pull
+by tag name, --match by name glob.
+Pulls are only known since the server started recording them.
+
+  dfcli image prune --repo myorg/app --keep 5 --unpulled-for 30d --dry-run
+
+Deletes run as you, so they need delete_tag and push access. Layers are
+freed by the next garbage collection once nothing else points at them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, ok := strings.Cut(repo, "/")
+			if !ok || namespace == "" || name == "" {
+				return fmt.Errorf("--repo must be qualified as namespace/name (e.g. myorg/app)")
+			}
+			if keep < 0 {
+				return fmt.Errorf("--keep cannot be negative")
+			}
+			crit := pruneCriteria{keep: keep, match: match, exclude: exclude}
+			var err error
+			if crit.olderThan, err = parseAge(olderThan); err != nil {
+				return err
+			}
+			if crit.unpulledFor, err = parseAge(unpulledFor); err != nil {
+				return err
+			}
+			for _, glob := range append([]string{match}, exclude...) {
+				if _, err := path.Match(glob, ""); err != nil {
+					return fmt.Errorf("invalid tag glob %q", glob)
+				}
+			}
+
+			tags, err := listAllTags(cmd.Context(), namespace, name)
+			if err != nil {
+				return err
+			}
+			prune, freed := selectPrunable(tags, crit, time.Now())
+			if len(prune) == 0 {
+				fmt.Printf("Nothing to prune in %s (%d tags)\n", repo, len(tags))
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TAG\tDIGEST\tSIZE\tPUSHED\tLAST PULLED\tPULLS")
+			for _, t := range prune {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", t.Name, shortDigest(t.Digest), formatSize(t.SizeBytes),
+					formatTimestamp(t.PushedAt), formatTimestamp(t.PulledAt), t.PullCount)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			summary := fmt.Sprintf("%d of %d tags in %s, up to %s freed by the next garbage collection",
+				len(prune), len(tags), repo, formatSize(freed))
+			if dryRun {
+				fmt.Printf("Would untag %s\n", summary)
+				return nil
+			}
+			if !yes {
+				ok, err := confirm(fmt.Sprintf("Untag %s?", summary))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("aborted")
+				}
+			}
+
+			failed, pending := 0, 0
+			for _, t := range prune {
+				resp, err := client.Repositories().DeleteTag(cmd.Context(), connect.NewRequest(&v1.DeleteTagRequest{
+					Namespace: namespace,
+					Name:      name,
+					Tag:       t.Name,
+					Reason:    reason,
+				}))
+				switch {
+				case err != nil:
+					failed++
+					fmt.Fprintf(os.Stderr, "Failed to untag %s: %v\n", t.Name, rpcErr(err))
+				case resp.Msg.PendingApproval:
+					pending++
+					fmt.Printf("Untag of %s awaits a second admin\n", t.Name)
+				}
+			}
+			fmt.Printf("Untagged %d of %d tags\n", len(prune)-failed-pending, len(prune))
+			if failed > 0 {
+				return fmt.Errorf("%d untags failed", failed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&repo, "repo", "", "Image repository as namespace/name")
+	cmd.Flags().IntVar(&keep, "keep", 5, "Newest tags by push time that always stay")
+	cmd.Flags().StringVar(&olderThan, "older-than", "", "Only tags pushed longer ago, e.g. 30d or 12h")
+	cmd.Flags().StringVar(&unpulledFor, "unpulled-for", "", "Only tags not pulled by name within this age, e.g. 30d")
+	cmd.Flags().StringVar(&match, "match", "*", "Only tags whose name matches this glob")
+	cmd.Flags().StringArrayVar(&exclude, "exclude", []string{"latest"}, "Tag name glob that always stays, repeatable")
+	cmd.Flags().StringVar(&reason, "reason", "", "Why, recorded in the audit trail")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List what would be untagged without untagging")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+	_ = cmd.MarkFlagRequired("repo")
+	return cmd
+}
+
+// Every tag of a repository, newest push first
+func listAllTags(ctx context.Context, namespace, name string) ([]*v1.Tag, error) {
+	var tags []*v1.Tag
+	page := &v1.PageRequest{PageSize: 500, OrderBy: "pushed_at desc"}
+	for {
+		resp, err := client.Repositories().ListTags(ctx, connect.NewRequest(&v1.ListTagsRequest{
+			Namespace: namespace,
+			Name:      name,
+			Page:      page,
+		}))
+		if err != nil {
+			return nil, rpcErr(err)
+		}
+		tags = append(tags, resp.Msg.Tags...)
+		next := resp.Msg.GetPage().GetNextPageToken()
+		if next == "" {
+			return tags, nil
+		}
+		page.PageToken = next
+	}
+}
+
+// Tags to untag from a newest first list, and the bytes of manifests no
+// remaining tag points at
+func selectPrunable(tags []*v1.Tag, crit pruneCriteria, now time.Time) ([]*v1.Tag, int64) {
+	var prune []*v1.Tag
+	kept := map[string]bool{}
+	newest := 0
+	for _, t := range tags {
+		// Excluded tags stay without taking one of the kept slots
+		if slices.ContainsFunc(crit.exclude, func(glob string) bool { ok, _ := path.Match(glob, t.Name); return ok }) {
+			kept[t.Digest] = true
+			continue
+		}
+		if newest < crit.keep || !pruneEligible(t, crit, now) {
+			newest++
+			kept[t.Digest] = true
+			continue
+		}
+		prune = append(prune, t)
+	}
+	var freed int64
+	counted := map[string]bool{}
+	for _, t := range prune {
+		if !kept[t.Digest] && !counted[t.Digest] {
+			counted[t.Digest] = true
+			freed += t.SizeBytes
+		}
+	}
+	return prune, freed
+}
+
+func pruneEligible(t *v1.Tag, crit pruneCriteria, now time.Time) bool {
+	if ok, _ := path.Match(crit.match, t.Name); !ok {
+		return false
+	}
+	if crit.olderThan > 0 && (t.PushedAt == nil || now.Sub(t.PushedAt.AsTime()) < crit.olderThan) {
+		return false
+	}
+	if crit.unpulledFor > 0 && t.PulledAt != nil && now.Sub(t.PulledAt.AsTime()) < crit.unpulledFor {
+		return false
+	}
+	return true
+}
@@ -3,13 +3,17 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"connectrpc.com/connect"
 	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
 	"github.com/spf13/cobra"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func newImageCmd() *cobra.Command {
@@ -20,6 +24,21 @@ func newImageCmd() *cobra.Command {
 	cmd.AddCommand(
 		newImageListCmd(),
 		newImageTagsCmd(),
+		newImagePushCmd(),
+		newImagePullCmd(),
+		newImageExpireCmd(),
+		newImageSharingCmd(),
+		newImagePrewarmCmd(),
+		newImageVerifyCmd(),
+		newImageForkCmd(),
+		newImageCopyCmd(),
+		newImageProvenanceCmd(),
+		newImageDiffCmd(),
+		newImageDeleteCmd(),
+		newImageUntagCmd(),
+		newImagePruneCmd(),
+		newImageFreezeCmd(),
+		newPermissionsCmd(),
 	)
 	return cmd
 }
@@ -43,13 +62,55 @@ func printProtoJSON(messages []proto.Message) error {
 }
 
 func newImageListCmd() *cobra.Command {
-	return &cobra.Command{
+	var (
+		namespace    string
+		owner        string
+		name         string
+		labels       []string
+		visibility   string
+		updatedSince string
+	)
+	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List image repositories",
+		Long: `List image repositories as JSON. Filters run on the server: --label
+matches image config labels of any pushed tag, as key or key=value, and
+repeats must all match. --updated-since takes an age like 7d or 12h, or an
+RFC 3339 time.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			resp, err := client.Repositories().ListRepositories(cmd.Context(), connect.NewRequest(&v1.ListRepositoriesRequest{
-				Page: &v1.PageRequest{PageSize: 100},
-			}))
+			req := &v1.ListRepositoriesRequest{
+				Namespace: namespace,
+				Labels:    labels,
+				Page:      &v1.PageRequest{PageSize: 100},
+			}
+			switch visibility {
+			case "":
+			case "public":
+				req.Visibility = v1.Visibility_VISIBILITY_PUBLIC
+			case "private":
+				req.Visibility = v1.Visibility_VISIBILITY_PRIVATE
+			default:
+				return fmt.Errorf("--visibility must be public or private")
+			}
+			if updatedSince != "" {
+				since, err := parseSince(updatedSince)
+				if err != nil {
+					return err
+				}
+				req.UpdatedSince = timestamppb.New(since)
+			}
+			q := &v1.Query{}
+			if owner != "" {
+				q.Filters = append(q.Filters, &v1.FieldFilter{Field: "owner", Match: v1.MatchKind_MATCH_KIND_EQUALS, Value: owner})
+			}
+			if name != "" {
+				q.Filters = append(q.Filters, &v1.FieldFilter{Field: "name", Value: name})
+			}
+			if len(q.Filters) > 0 {
+				req.Page.Query = q
+			}
+
+			resp, err := client.Repositories().ListRepositories(cmd.Context(), connect.NewRequest(req))
 			if err != nil {
 				return rpcErr(err)
 			}
@@ -60,31 +121,481 @@ func newImageListCmd() *cobra.Command {
 			return printProtoJSON(msgs)
 		},
 	}
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Only repositories in this user or organization namespace")
+	cmd.Flags().StringVar(&owner, "owner", "", "Only repositories owned by this username")
+	cmd.Flags().StringVar(&name, "name", "", "Only repositories whose name contains this")
+	cmd.Flags().StringArrayVar(&labels, "label", nil, "Image label key or key=value, repeatable")
+	cmd.Flags().StringVar(&visibility, "visibility", "", "public or private")
+	cmd.Flags().StringVar(&updatedSince, "updated-since", "", "Only repositories updated since an age (7d, 12h) or RFC 3339 time")
+	return cmd
+}
+
+// An age back from now or an RFC 3339 time
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	age, err := parseAge(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use an age like 7d or an RFC 3339 time", s)
+	}
+	return time.Now().Add(-age), nil
 }
 
 func newImageTagsCmd() *cobra.Command {
-	return &cobra.Command{
+	var details bool
+	cmd := &cobra.Command{
 		Use:   "tags [namespace/image]",
 		Short: "List tags for an image (name must include its namespace)",
-		Args:  cobra.ExactArgs(1),
+		Long: `List the tags of an image as JSON. With --details print a table of
+digest, size, build time, push time, pusher and expiry per tag, newest
+push first.
+Tags pushed before the server recorded pushes show no pusher.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			namespace, name, ok := strings.Cut(args[0], "/")
 			if !ok {
 				return fmt.Errorf("image must be qualified as namespace/name (e.g. myorg/app)")
 			}
+			page := &v1.PageRequest{PageSize: 100}
+			if details {
+				page.OrderBy = "pushed_at desc"
+			}
 			resp, err := client.Repositories().ListTags(cmd.Context(), connect.NewRequest(&v1.ListTagsRequest{
 				Namespace: namespace,
 				Name:      name,
-				Page:      &v1.PageRequest{PageSize: 100},
+				Page:      page,
 			}))
 			if err != nil {
 				return rpcErr(err)
 			}
-			msgs := make([]proto.Message, len(resp.Msg.Tags))
-			for i, t := range resp.Msg.Tags {
-				msgs[i] = t
+			if !details {
+				msgs := make([]proto.Message, len(resp.Msg.Tags))
+				for i, t := range resp.Msg.Tags {
+					msgs[i] = t
+				}
+				return printProtoJSON(msgs)
 			}
-			return printProtoJSON(msgs)
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TAG\tDIGEST\tSIZE\tCREATED\tPUSHED\tPUSHED BY\tEXPIRES")
+			for _, t := range resp.Msg.Tags {
+				pusher := t.PushedBy
+				if pusher == "" {
+					pusher = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.Name, shortDigest(t.Digest), formatSize(t.SizeBytes),
+					formatTimestamp(t.CreatedAt), formatTimestamp(t.PushedAt), pusher, formatTimestamp(t.ExpiresAt))
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if total := resp.Msg.GetPage().GetTotalCount(); total > int64(len(resp.Msg.Tags)) {
+				fmt.Printf("\nShowing the %d most recent of %d tags\n", len(resp.Msg.Tags), total)
+			}
+			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&details, "details", false, "Show digest, size, created and pushed times, and pusher in a table")
+	return cmd
+}
+
+// Local minute precision, dash when unknown
+func formatTimestamp(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return "-"
+	}
+	return ts.AsTime().Local().Format("2006-01-02 15:04")
+}
+
+func newImageSharingCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "sharing [namespace/image[:tag]]",
+		Short: "Show which layers an image or repository shares, and what deleting it frees",
+		Long: `Break down the blobs of a repository, or of one tag when given, into
+bytes used only by it and bytes shared with other repositories or tags.
+Unique bytes are what deleting it frees once garbage collection runs.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, tag, _ := strings.Cut(args[0], ":")
+			namespace, name, ok := strings.Cut(ref, "/")
+			if !ok {
+				return fmt.Errorf("image must be qualified as namespace/name (e.g. myorg/app)")
+			}
+			resp, err := client.Repositories().GetLayerSharing(cmd.Context(), connect.NewRequest(&v1.GetLayerSharingRequest{
+				Namespace: namespace,
+				Name:      name,
+				Tag:       tag,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg})
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "DIGEST\tSIZE\tSHARED WITH")
+			for _, l := range resp.Msg.Layers {
+				shared := strings.Join(append(l.SharedRepos, tagRefs(l.SharedTags)...), ", ")
+				if shared == "" && l.Shared {
+					shared = "repositories you cannot read"
+				}
+				if shared == "" {
+					shared = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", shortDigest(l.Digest), formatSize(l.SizeBytes), shared)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			fmt.Printf("\nTotal %s, unique %s (freed on delete + gc), shared %s\n",
+				formatSize(resp.Msg.TotalBytes), formatSize(resp.Msg.UniqueBytes), formatSize(resp.Msg.SharedBytes))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newImagePrewarmCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "prewarm namespace/image:tag...",
+		Short: "Make sure every layer of an image is on the server before a deploy",
+		Long: `Check that the server holds every manifest, config and layer of each
+image, fetching what a mirror repository is missing from its upstream.
+Run it ahead of a deployment window so node pulls never wait on the
+upstream registry. Exits non zero when an image can't be made complete.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var results []proto.Message
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			if !asJSON {
+				fmt.Fprintln(w, "IMAGE	DIGEST	BLOBS	SIZE	FETCHED")
+			}
+			for _, arg := range args {
+				ref, tag, ok := strings.Cut(arg, ":")
+				if !ok || tag == "" {
+					return fmt.Errorf("image must include a tag (e.g. myorg/app:1.0)")
+				}
+				namespace, name, ok := strings.Cut(ref, "/")
+				if !ok {
+					return fmt.Errorf("image must be qualified as namespace/name (e.g. myorg/app)")
+				}
+				resp, err := client.Repositories().PrewarmTag(cmd.Context(), connect.NewRequest(&v1.PrewarmTagRequest{
+					Namespace: namespace,
+					Name:      name,
+					Tag:       tag,
+				}))
+				if err != nil {
+					if !asJSON {
+						w.Flush()
+					}
+					return fmt.Errorf("%s: %v", arg, rpcErr(err))
+				}
+				results = append(results, resp.Msg)
+				if asJSON {
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\n", arg, shortDigest(resp.Msg.Digest), resp.Msg.Blobs, formatSize(resp.Msg.TotalBytes), resp.Msg.Fetched)
+			}
+			if asJSON {
+				return printProtoJSON(results)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newImageVerifyCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "verify [namespace/image:tag|namespace/image@digest]",
+		Short: "Check the server made signatures of an image",
+		Long: `Verify the signatures the server attached when the image was pushed.
+Signatures by rotated out keys still count. Exits non zero when no
+signature checks out.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, reference, ok := strings.Cut(args[0], "@")
+			if !ok {
+				ref, reference, ok = strings.Cut(args[0], ":")
+			}
+			if !ok || reference == "" {
+				return fmt.Errorf("image must include a tag or digest (e.g. myorg/app:1.0)")
+			}
+			namespace, name, ok := strings.Cut(ref, "/")
+			if !ok {
+				return fmt.Errorf("image must be qualified as namespace/name (e.g. myorg/app)")
+			}
+			resp, err := client.Repositories().VerifyImageSignature(cmd.Context(), connect.NewRequest(&v1.VerifyImageSignatureRequest{
+				Namespace: namespace,
+				Name:      name,
+				Reference: reference,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				if err := printProtoJSON([]proto.Message{resp.Msg}); err != nil {
+					return err
+				}
+			} else {
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "SIGNATURE\tKEY\tSTATUS")
+				for _, sig := range resp.Msg.Signatures {
+					status := "valid"
+					if !sig.Valid {
+						status = "invalid: " + sig.Error
+					} else if sig.KeyRetired {
+						status = "valid (retired key)"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\n", shortDigest(sig.Digest), sig.KeyId, status)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+			if !resp.Msg.Verified {
+				return fmt.Errorf("%s has no valid signature", resp.Msg.Digest)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newImageForkCmd() *cobra.Command {
+	var description string
+	var private, public bool
+	cmd := &cobra.Command{
+		Use:   "fork [namespace/image] [namespace/name]",
+		Short: "Fork an image repository without copying its layers",
+		Long: `Create a new repository holding every tag of the source. Layers are
+shared on disk, so the fork is instant and only new pushes take space.
+Without a target the fork lands in your namespace under the same name.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			srcNS, srcName, ok := strings.Cut(args[0], "/")
+			if !ok {
+				return fmt.Errorf("image must be qualified as namespace/name (e.g. myorg/app)")
+			}
+			req := &v1.ForkRepositoryRequest{SourceNamespace: srcNS, SourceName: srcName}
+			if len(args) == 2 {
+				if ns, name, ok := strings.Cut(args[1], "/"); ok {
+					req.Namespace, req.Name = ns, name
+				} else {
+					req.Name = args[1]
+				}
+			}
+			if cmd.Flags().Changed("description") {
+				req.Description = proto.String(description)
+			}
+			switch {
+			case private && public:
+				return fmt.Errorf("--private and --public are mutually exclusive")
+			case private:
+				req.Visibility = v1.Visibility_VISIBILITY_PRIVATE
+			case public:
+				req.Visibility = v1.Visibility_VISIBILITY_PUBLIC
+			}
+			resp, err := client.Repositories().ForkRepository(cmd.Context(), connect.NewRequest(req))
+			if err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Forked %s into %s (%d links shared)\n", args[0], resp.Msg.Repository.FullName, resp.Msg.LinkedObjects)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&description, "description", "", "Description, defaults to the source's")
+	cmd.Flags().BoolVar(&private, "private", false, "Make the fork private")
+	cmd.Flags().BoolVar(&public, "public", false, "Make the fork public")
+	return cmd
+}
+
+func newImageCopyCmd() *cobra.Command {
+	var pipelineID string
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "copy [namespace/image:tag] [namespace/name][:tag]",
+		Short: "Copy or promote a tag server side, recording its provenance",
+		Long: `Copy a tag to another tag or repository without pulling it. The copy
+runs as a push, so push policies, webhooks and signing apply. The source,
+you, and --pipeline-id are recorded for 'dfcli image provenance'.
+
+  dfcli image copy myorg/app:1.4.2-rc1 myorg/app-prod:1.4.2 --pipeline-id "$CI_PIPELINE_ID"`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, srcTag, _ := strings.Cut(args[0], ":")
+			srcNS, srcName, ok := strings.Cut(ref, "/")
+			if !ok || srcTag == "" {
+				return fmt.Errorf("source must be namespace/name:tag (e.g. myorg/app:1.0)")
+			}
+			req := &v1.CopyTagRequest{SourceNamespace: srcNS, SourceName: srcName, SourceTag: srcTag}
+			target, tag, _ := strings.Cut(args[1], ":")
+			req.Tag = tag
+			if ns, name, ok := strings.Cut(target, "/"); ok {
+				req.Namespace, req.Name = ns, name
+			} else {
+				req.Name = target
+			}
+
+			creq := connect.NewRequest(req)
+			if pipelineID != "" {
+				creq.Header().Set("X-Pipeline-Id", pipelineID)
+			}
+			resp, err := client.Repositories().CopyTag(cmd.Context(), creq)
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg.Provenance})
+			}
+			p := resp.Msg.Provenance
+			fmt.Printf("Copied %s to %s/%s:%s (%s)\n", args[0], p.Namespace, p.Name, p.Tag, shortDigest(p.Digest))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&pipelineID, "pipeline-id", "", "CI run to record with the copy")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newImageProvenanceCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "provenance [namespace/image:tag]",
+		Short: "Show where a copied tag came from",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, tag, _ := strings.Cut(args[0], ":")
+			namespace, name, ok := strings.Cut(ref, "/")
+			if !ok || tag == "" {
+				return fmt.Errorf("image must be namespace/name:tag (e.g. myorg/app:1.0)")
+			}
+			resp, err := client.Repositories().GetTagProvenance(cmd.Context(), connect.NewRequest(&v1.GetTagProvenanceRequest{
+				Namespace: namespace,
+				Name:      name,
+				Tag:       tag,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			p := resp.Msg.Provenance
+			if p == nil {
+				fmt.Printf("%s was pushed, not copied\n", args[0])
+				return nil
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{p})
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "Digest:\t%s\n", p.Digest)
+			fmt.Fprintf(w, "Copied from:\t%s:%s\n", p.SourceRepo, p.SourceTag)
+			if p.OriginRepo != p.SourceRepo || p.OriginTag != p.SourceTag {
+				fmt.Fprintf(w, "Origin:\t%s:%s\n", p.OriginRepo, p.OriginTag)
+			}
+			fmt.Fprintf(w, "Copied by:\t%s\n", p.CopiedBy)
+			if p.PipelineId != "" {
+				fmt.Fprintf(w, "Pipeline:\t%s\n", p.PipelineId)
+			}
+			fmt.Fprintf(w, "Copied at:\t%s\n", formatTimestamp(p.CopiedAt))
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newImageDeleteCmd() *cobra.Command {
+	var reason string
+	cmd := &cobra.Command{
+		Use:   "delete [namespace/image]",
+		Short: "Delete an image repository",
+		Long: `Delete an image repository. Layers are freed by the next garbage
+collection. When the server requires dual approval for large repositories
+the first call parks the delete until a second admin runs the same command.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, ok := strings.Cut(args[0], "/")
+			if !ok {
+				return fmt.Errorf("image must be qualified as namespace/name (e.g. myorg/app)")
+			}
+			resp, err := client.Repositories().DeleteRepository(cmd.Context(), connect.NewRequest(&v1.DeleteRepositoryRequest{
+				Namespace: namespace,
+				Name:      name,
+				Reason:    reason,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if resp.Msg.PendingApproval {
+				fmt.Printf("Delete of %s awaits a second admin until %s\n", args[0],
+					resp.Msg.ApprovalExpiresAt.AsTime().Local().Format("2006-01-02 15:04"))
+				return nil
+			}
+			fmt.Printf("Deleted %s\n", args[0])
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&reason, "reason", "", "Why, recorded in the audit trail")
+	return cmd
+}
+
+func newImageUntagCmd() *cobra.Command {
+	var reason string
+	cmd := &cobra.Command{
+		Use:   "untag [namespace/image:tag]",
+		Short: "Remove one tag from an image repository",
+		Long: `Remove one tag, leaving the repository and its other tags alone. The
+manifest is freed by the next garbage collection once nothing else points
+at it. Needs push access to the repository and a role with delete_tag, which
+users have by default, unlike deleting the whole repository.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, tag, err := parseImageTag(args[0])
+			if err != nil {
+				return err
+			}
+			resp, err := client.Repositories().DeleteTag(cmd.Context(), connect.NewRequest(&v1.DeleteTagRequest{
+				Namespace: namespace,
+				Name:      name,
+				Tag:       tag,
+				Reason:    reason,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if resp.Msg.PendingApproval {
+				fmt.Printf("Untag of %s awaits a second admin until %s\n", args[0],
+					resp.Msg.ApprovalExpiresAt.AsTime().Local().Format("2006-01-02 15:04"))
+				return nil
+			}
+			fmt.Printf("Untagged %s\n", args[0])
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&reason, "reason", "", "Why, recorded in the audit trail")
+	return cmd
+}
+
+func tagRefs(tags []string) []string {
+	out := make([]string, len(tags))
+	for i, t := range tags {
+		out[i] = ":" + t
+	}
+	return out
+}
+
+func shortDigest(d string) string {
+	algo, hex, ok := strings.Cut(d, ":")
+	if ok && len(hex) > 12 {
+		return algo + ":" + hex[:12]
+	}
+	return d
 }
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/spf13/cobra"
+)
+
+// Annotation naming an image inside an oci layout
+const ociRefName = "org.opencontainers.image.ref.name"
+
+func newImagePushCmd() *cobra.Command {
+	var refName, expires string
+	cmd := &cobra.Command{
+		Use:   "push [image.tar|oci-dir] [namespace/image:tag]",
+		Short: "Push an image tarball or OCI layout without docker",
+		Long: `Push an image straight to the registry over /v2, for CI runners without a
+docker daemon. The source is a 'docker save' tarball or an OCI image
+layout directory. Layers the server already holds are skipped, and a
+layout holding a multi-arch index is pushed as that index. --expires
+has the server remove the tag after that long, see 'dfcli image expire'.
+
+  dfcli image push ./build/app.tar myorg/app:1.4.2
+  dfcli image push ./oci myorg/app:1.4.2 --ref 1.4.2
+  dfcli image push ./build/app.tar myorg/app:pr-118 --expires 14d`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, repoName, tag, err := parseImageTag(args[1])
+			if err != nil {
+				return err
+			}
+			ttl, err := parseAge(expires)
+			if err != nil {
+				return err
+			}
+			ref, err := client.registryTag(namespace, repoName, tag)
+			if err != nil {
+				return err
+			}
+			opts, err := client.registryOptions(cmd.Context(), ref.Context(), "push,pull")
+			if err != nil {
+				return err
+			}
+
+			endGroup := ci.begin("dfcli_image_push", fmt.Sprintf("Push %s to %s", args[0], args[1]))
+			digest, err := pushSource(ref, args[0], refName, opts)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Pushed %s (%s)\n", args[1], shortDigest(digest.String()))
+			if ttl > 0 {
+				at, err := client.setTagExpiry(cmd.Context(), namespace, repoName, tag, ttl)
+				if err != nil {
+					return fmt.Errorf("setting expiry of %s: %w", args[1], err)
+				}
+				fmt.Printf("Expires %s\n", formatTimestamp(at))
+			}
+			endGroup()
+			ci.notice("Pushed", fmt.Sprintf("%s (%s)", args[1], shortDigest(digest.String())))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&refName, "ref", "", "Image of an OCI layout to push, by its ref.name annotation")
+	cmd.Flags().StringVar(&expires, "expires", "", "Remove the tag after this long, e.g. 14d or 36h")
+	return cmd
+}
+
+// Writes a tarball or oci layout to ref, returning the pushed digest
+func pushSource(ref name.Tag, src, refName string, opts []remote.Option) (ggcrv1.Hash, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return ggcrv1.Hash{}, err
+	}
+	if !info.IsDir() {
+		img, err := tarball.ImageFromPath(src, nil)
+		if err != nil {
+			return ggcrv1.Hash{}, fmt.Errorf("reading %s: %w", src, err)
+		}
+		return pushImage(ref, img, opts)
+	}
+
+	idx, err := layout.ImageIndexFromPath(src)
+	if err != nil {
+		return ggcrv1.Hash{}, fmt.Errorf("reading oci layout %s: %w", src, err)
+	}
+	desc, err := pickLayoutManifest(idx, refName)
+	if err != nil {
+		return ggcrv1.Hash{}, err
+	}
+	if desc.MediaType.IsIndex() {
+		child, err := idx.ImageIndex(desc.Digest)
+		if err != nil {
+			return ggcrv1.Hash{}, err
+		}
+		if err := remote.WriteIndex(ref, child, opts...); err != nil {
+			return ggcrv1.Hash{}, fmt.Errorf("pushing %s: %w", ref, err)
+		}
+		return desc.Digest, nil
+	}
+	img, err := idx.Image(desc.Digest)
+	if err != nil {
+		return ggcrv1.Hash{}, err
+	}
+	return pushImage(ref, img, opts)
+}
+
+func pushImage(ref name.Tag, img ggcrv1.Image, opts []remote.Option) (ggcrv1.Hash, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return ggcrv1.Hash{}, err
+	}
+	if err := remote.Write(ref, img, opts...); err != nil {
+		return ggcrv1.Hash{}, fmt.Errorf("pushing %s: %w", ref, err)
+	}
+	return digest, nil
+}
+
+// The one manifest of a layout, or the one --ref names
+func pickLayoutManifest(idx ggcrv1.ImageIndex, refName string) (ggcrv1.Descriptor, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return ggcrv1.Descriptor{}, err
+	}
+	if refName == "" {
+		if len(manifest.Manifests) != 1 {
+			return ggcrv1.Descriptor{}, fmt.Errorf("oci layout holds %d images, pick one with --ref", len(manifest.Manifests))
+		}
+		return manifest.Manifests[0], nil
+	}
+	for _, desc := range manifest.Manifests {
+		if desc.Annotations[ociRefName] == refName {
+			return desc, nil
+		}
+	}
+	return ggcrv1.Descriptor{}, fmt.Errorf("oci layout has no image named %q", refName)
+}
+
+func newImagePullCmd() *cobra.Command {
+	var output, platform string
+	cmd := &cobra.Command{
+		Use:   "pull [namespace/image:tag]",
+		Short: "Pull an image into a tarball without docker",
+		Long: `Pull an image straight from the registry over /v2 into a tarball that
+'docker load' and 'dfcli image push' read. Multi-arch tags are pulled
+for --platform, the local platform by default.
+
+  dfcli image pull myorg/app:1.4.2 -o app.tar`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, repoName, tag, err := parseImageTag(args[0])
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				return fmt.Errorf("--output is required")
+			}
+			if platform == "" {
+				platform = "linux/" + runtime.GOARCH
+			}
+			p, err := ggcrv1.ParsePlatform(platform)
+			if err != nil {
+				return fmt.Errorf("invalid --platform: %w", err)
+			}
+			ref, err := client.registryTag(namespace, repoName, tag)
+			if err != nil {
+				return err
+			}
+			opts, err := client.registryOptions(cmd.Context(), ref.Context(), "pull")
+			if err != nil {
+				return err
+			}
+			endGroup := ci.begin("dfcli_image_pull", "Pull "+args[0])
+			img, err := remote.Image(ref, append(opts, remote.WithPlatform(*p))...)
+			if err != nil {
+				return fmt.Errorf("pulling %s: %w", args[0], err)
+			}
+			digest, err := img.Digest()
+			if err != nil {
+				return err
+			}
+
+			if output == "-" {
+				return tarball.Write(ref, img, os.Stdout)
+			}
+			// Written beside the target so a failed pull leaves no partial tarball
+			tmp := output + ".partial"
+			if err := tarball.WriteToFile(tmp, ref, img); err != nil {
+				os.Remove(tmp)
+				return fmt.Errorf("pulling %s: %w", args[0], err)
+			}
+			if err := os.Rename(tmp, output); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Pulled %s (%s) to %s\n", args[0], shortDigest(digest.String()), output)
+			endGroup()
+			ci.notice("Pulled", fmt.Sprintf("%s (%s) to %s", args[0], shortDigest(digest.String()), output))
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Tarball to write, - for stdout")
+	cmd.Flags().StringVar(&platform, "platform", "", "Platform of multi-arch tags to pull, as os/arch[/variant]")
+	return cmd
+}
+
+// Tag on this server's registry, plain http servers stay plain
+func (c *Client) registryTag(namespace, repoName, tag string) (name.Tag, error) {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return name.Tag{}, err
+	}
+	var opts []name.Option
+	if u.Scheme == "http" {
+		opts = append(opts, name.Insecure)
+	}
+	return name.NewTag(u.Host+"/"+namespace+"/"+repoName+":"+tag, append(opts, name.StrictValidation)...)
+}
+
+// Remote options that reuse the cli transport and trade the stored login
+// for registry tokens
+func (c *Client) registryOptions(ctx context.Context, repo name.Repository, actions string) ([]remote.Option, error) {
+	opts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithTransport(c.DataClient.Transport),
+		remote.WithUserAgent("dfcli"),
+	}
+	if c.Tokens.GetToken() == "" {
+		return append(opts, remote.WithAuth(authn.Anonymous)), nil
+	}
+	service, err := c.registryService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	auth := &registryAuth{ctx: ctx, c: c, service: service, scope: "repository:" + repo.RepositoryStr() + ":" + actions}
+	return append(opts, remote.WithAuth(auth)), nil
+}
+
+var challengeService = regexp.MustCompile(`service="([^"]*)"`)
+
+// Service the registry challenge names, registry tokens are minted for it
+func (c *Client) registryService(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/v2/", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.DataClient.Do(req)
+	if err != nil {
+		return "", hintTLS(fmt.Errorf("request failed: %w", err))
+	}
+	resp.Body.Close()
+	m := challengeService.FindStringSubmatch(resp.Header.Get("WWW-Authenticate"))
+	if m == nil {
+		return "", fmt.Errorf("registry at %s sent no token challenge (status %d)", c.BaseURL, resp.StatusCode)
+	}
+	return m[1], nil
+}
+
+// Hands the registry a fresh token for the stored login each time it asks,
+// so pushes outlasting one token keep going
+type registryAuth struct {
+	ctx     context.Context
+	c       *Client
+	service string
+	scope   string
+}
+
+func (a *registryAuth) Authorization() (*authn.AuthConfig, error) {
+	q := url.Values{}
+	q.Set("service", a.service)
+	q.Set("scope", a.scope)
+	resp, err := a.c.doData(a.ctx, http.MethodGet, "/auth/token?"+q.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("registry token: %w", err)
+	}
+	return &authn.AuthConfig{RegistryToken: tok.Token}, nil
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+func newAdminJobsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "Inspect and retry background jobs",
+		Long: `Completed artifact uploads hand malware scans, retention and package
+index builds to a background queue. Failed attempts retry with backoff,
+jobs that run out of attempts stay failed until retried here.`,
+	}
+	cmd.AddCommand(newAdminJobsListCmd(), newAdminJobsRetryCmd())
+	return cmd
+}
+
+func newAdminJobsListCmd() *cobra.Command {
+	var status, kind string
+	var limit int32
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List jobs, newest first",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := &v1.Query{}
+			for field, value := range map[string]string{"status": status, "kind": kind} {
+				if value != "" {
+					q.Filters = append(q.Filters, &v1.FieldFilter{Field: field, Match: v1.MatchKind_MATCH_KIND_EQUALS, Value: value})
+				}
+			}
+			resp, err := client.Jobs().ListJobs(cmd.Context(), connect.NewRequest(&v1.ListJobsRequest{
+				Page: &v1.PageRequest{PageSize: limit, Query: q},
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg})
+			}
+
+			fmt.Printf("Queue: %d pending, %d running\n", resp.Msg.Pending, resp.Msg.Running)
+			if len(resp.Msg.Jobs) == 0 {
+				fmt.Println("No jobs found")
+				return nil
+			}
+			fmt.Println()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tKIND\tSUBJECT\tSTATUS\tATTEMPTS\tCREATED\tERROR")
+			for _, j := range resp.Msg.Jobs {
+				state := j.Status
+				if j.RunAt != nil && j.Attempts > 0 {
+					state += " (retry " + j.RunAt.AsTime().Local().Format(time.TimeOnly) + ")"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%d\t%s\t%s\n", j.Id, j.Kind, j.Subject, state, j.Attempts, j.MaxAttempts,
+					j.GetCreatedAt().AsTime().Local().Format(time.RFC3339), shortStep(j.LastError))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&status, "status", "", "Only jobs in this status (pending, running, done, failed)")
+	cmd.Flags().StringVar(&kind, "kind", "", "Only jobs of this kind (e.g. artifact.scan)")
+	cmd.Flags().Int32Var(&limit, "limit", 50, "Maximum jobs to list")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newAdminJobsRetryCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "retry [job-id]",
+		Short: "Run a failed job again with a fresh set of attempts",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.Jobs().RetryJob(cmd.Context(), connect.NewRequest(&v1.RetryJobRequest{Id: args[0]}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Requeued %s %s\n", resp.Msg.Job.Kind, resp.Msg.Job.Subject)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+func newNotificationCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "notification",
+		Aliases: []string{"notifications"},
+		Short:   "Watch image repositories and read your notifications",
+		Long: `Watched repositories notify you of new tags, deletions and comments.
+@mentions in comments on repositories you can read notify you without a
+watch. Notifications collect in an inbox and are mailed or posted to your
+own webhook, immediately or batched into an hourly or daily digest.`,
+	}
+	cmd.AddCommand(
+		newNotificationWatchCmd(),
+		newNotificationUnwatchCmd(),
+		newNotificationWatchesCmd(),
+		newNotificationListCmd(),
+		newNotificationReadCmd(),
+		newNotificationPrefsCmd(),
+	)
+	return cmd
+}
+
+func splitImageRepo(arg string) (string, string, error) {
+	namespace, name, ok := strings.Cut(arg, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("image must be qualified as namespace/name (e.g. myorg/app)")
+	}
+	return namespace, name, nil
+}
+
+func newNotificationWatchCmd() *cobra.Command {
+	var events []string
+
+	cmd := &cobra.Command{
+		Use:   "watch namespace/image",
+		Short: "Watch an image repository, rerun to change the events",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, err := splitImageRepo(args[0])
+			if err != nil {
+				return err
+			}
+			resp, err := client.Notifications().WatchRepository(cmd.Context(), connect.NewRequest(&v1.WatchRepositoryRequest{
+				Namespace: namespace,
+				Name:      name,
+				Events:    events,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Watching %s/%s for %s\n", namespace, name, watchEvents(resp.Msg.Watch))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&events, "event", nil, "Only these events: tag, delete, comment (repeatable, default all)")
+	return cmd
+}
+
+func newNotificationUnwatchCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unwatch namespace/image",
+		Short: "Stop watching an image repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, err := splitImageRepo(args[0])
+			if err != nil {
+				return err
+			}
+			if _, err := client.Notifications().UnwatchRepository(cmd.Context(), connect.NewRequest(&v1.UnwatchRepositoryRequest{
+				Namespace: namespace,
+				Name:      name,
+			})); err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Stopped watching %s/%s\n", namespace, name)
+			return nil
+		},
+	}
+}
+
+func watchEvents(w *v1.Watch) string {
+	if len(w.GetEvents()) == 0 {
+		return "all events"
+	}
+	return strings.Join(w.Events, ", ")
+}
+
+func newNotificationWatchesCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "watches",
+		Short: "List the repositories you watch",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var watches []*v1.Watch
+			token := ""
+			for {
+				resp, err := client.Notifications().ListWatches(cmd.Context(), connect.NewRequest(&v1.ListWatchesRequest{
+					Page: &v1.PageRequest{PageSize: 500, PageToken: token},
+				}))
+				if err != nil {
+					return rpcErr(err)
+				}
+				watches = append(watches, resp.Msg.Watches...)
+				if token = resp.Msg.Page.GetNextPageToken(); token == "" {
+					break
+				}
+			}
+
+			if asJSON {
+				msgs := make([]proto.Message, len(watches))
+				for i, w := range watches {
+					msgs[i] = w
+				}
+				return printProtoJSON(msgs)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "REPOSITORY\tEVENTS\tSINCE")
+			for _, watch := range watches {
+				fmt.Fprintf(w, "%s/%s\t%s\t%s\n", watch.Namespace, watch.Name, watchEvents(watch), formatTimestamp(watch.CreatedAt))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newNotificationListCmd() *cobra.Command {
+	var unread, asJSON bool
+	var limit int32
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Show your notifications, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.Notifications().ListNotifications(cmd.Context(), connect.NewRequest(&v1.ListNotificationsRequest{
+				UnreadOnly: unread,
+				Page:       &v1.PageRequest{PageSize: limit},
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			entries := resp.Msg.Notifications
+
+			if asJSON {
+				msgs := make([]proto.Message, len(entries))
+				for i, n := range entries {
+					msgs[i] = n
+				}
+				return printProtoJSON(msgs)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tWHEN\tREASON\tEVENT\tTARGET\tACTOR\tUNREAD")
+			for _, n := range entries {
+				target := n.Namespace + "/" + n.Name
+				if n.Ref != "" {
+					target += ":" + n.Ref
+				} else if n.Digest != "" {
+					target += "@" + shortDigest(n.Digest)
+				}
+				actor, mark := n.Actor, ""
+				if actor == "" {
+					actor = "-"
+				}
+				if !n.Read {
+					mark = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", n.Id, formatTimestamp(n.CreatedAt), n.Reason, n.Event, target, actor, mark)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			fmt.Printf("\n%d unread\n", resp.Msg.UnreadCount)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&unread, "unread", false, "Only unread notifications")
+	cmd.Flags().Int32Var(&limit, "limit", 50, "Most notifications to show")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newNotificationReadCmd() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "read [id...]",
+		Short: "Mark notifications read",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && !all {
+				return fmt.Errorf("give notification ids or --all")
+			}
+			if len(args) > 0 && all {
+				return fmt.Errorf("--all takes no ids")
+			}
+			resp, err := client.Notifications().MarkNotificationsRead(cmd.Context(), connect.NewRequest(&v1.MarkNotificationsReadRequest{Ids: args}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Marked %d notifications read\n", resp.Msg.Marked)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Mark every notification read")
+	return cmd
+}
+
+func newNotificationPrefsCmd() *cobra.Command {
+	var email, mentions, secretStdin bool
+	var webhookURL, digest string
+
+	cmd := &cobra.Command{
+		Use:   "prefs",
+		Short: "Show or change how your notifications are delivered",
+		Long: `Without flags print the current preferences. --digest takes hourly,
+daily, or an empty string for immediate delivery. An empty --webhook-url
+stops posting.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			var prefs *v1.NotificationPreferences
+			update := false
+			for _, f := range []string{"email", "mentions", "webhook-url", "webhook-secret-stdin", "digest"} {
+				update = update || flags.Changed(f)
+			}
+			if !update {
+				resp, err := client.Notifications().GetNotificationPreferences(cmd.Context(), connect.NewRequest(&v1.GetNotificationPreferencesRequest{}))
+				if err != nil {
+					return rpcErr(err)
+				}
+				prefs = resp.Msg.Preferences
+			} else {
+				req := &v1.UpdateNotificationPreferencesRequest{}
+				if flags.Changed("email") {
+					req.Email = proto.Bool(email)
+				}
+				if flags.Changed("mentions") {
+					req.Mentions = proto.Bool(mentions)
+				}
+				if flags.Changed("webhook-url") {
+					req.WebhookUrl = proto.String(webhookURL)
+				}
+				if flags.Changed("digest") {
+					req.Digest = proto.String(digest)
+				}
+				if secretStdin {
+					secret, err := readSecret(true)
+					if err != nil {
+						return err
+					}
+					req.WebhookSecret = proto.String(secret)
+				}
+				resp, err := client.Notifications().UpdateNotificationPreferences(cmd.Context(), connect.NewRequest(req))
+				if err != nil {
+					return rpcErr(err)
+				}
+				prefs = resp.Msg.Preferences
+			}
+
+			delivery := "immediate"
+			if prefs.Digest != "" {
+				delivery = prefs.Digest + " digest"
+			}
+			hook := prefs.WebhookUrl
+			if hook == "" {
+				hook = "-"
+			} else if prefs.WebhookSecretSet {
+				hook += " (signed)"
+			}
+			fmt.Printf("Email:    %t\nMentions: %t\nWebhook:  %s\nDelivery: %s\n", prefs.Email, prefs.Mentions, hook, delivery)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&email, "email", true, "Mail notifications to your account address")
+	cmd.Flags().BoolVar(&mentions, "mentions", true, "Notify on @mentions")
+	cmd.Flags().StringVar(&webhookURL, "webhook-url", "", "Post notifications to this URL")
+	cmd.Flags().BoolVar(&secretStdin, "webhook-secret-stdin", false, "Read a webhook signing secret from stdin, empty clears it")
+	cmd.Flags().StringVar(&digest, "digest", "", "Batch delivery: hourly, daily, or empty for immediate")
+	return cmd
+}
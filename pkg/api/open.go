@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+)
+
+func newOpenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "open",
+		Short: "Open a repository page of the web UI in the browser",
+		Long: `Build the web UI address of an image or artifact repository from the
+configured server and open it in the default browser. --print only writes
+the address, which is also printed when no browser can be started.`,
+	}
+	cmd.PersistentFlags().Bool("print", false, "Print the address instead of opening a browser")
+	cmd.AddCommand(
+		newOpenRepoCmd(),
+		newOpenArtifactCmd(),
+	)
+	return cmd
+}
+
+func newOpenRepoCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "repo [namespace/image]",
+		Short: "Open the page of an image repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, ok := strings.Cut(args[0], "/")
+			if !ok {
+				return fmt.Errorf("image must be qualified as namespace/name (e.g. myorg/app)")
+			}
+			// Fail here rather than on a not found page
+			if _, err := client.Repositories().GetRepository(cmd.Context(), connect.NewRequest(&v1.GetRepositoryRequest{
+				Namespace: namespace,
+				Name:      name,
+			})); err != nil {
+				return rpcErr(err)
+			}
+			return openPage(cmd, "/"+url.PathEscape(namespace)+"/"+url.PathEscape(name))
+		},
+	}
+}
+
+func newOpenArtifactCmd() *cobra.Command {
+	var namespace string
+	cmd := &cobra.Command{
+		Use:   "artifact [repo]",
+		Short: "Open the page of an artifact repository",
+		Long: `Open the page of an artifact repository. Bare names resolve the same
+way as for the artifact commands, and the repo may be left out when a
+default is set with dfcli config set artifact.repo NAME.`,
+		Args:        cobra.RangeArgs(0, 1),
+		Annotations: map[string]string{repoDefaultAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				// Fall back on the repo the artifact commands default to
+				if art, _, err := cmd.Root().Find([]string{"artifact"}); err == nil && art != cmd.Root() {
+					if repo := defaultRepo(art); repo != "" {
+						args = []string{repo}
+					}
+				}
+			}
+			args, err := withDefaultRepo(cmd, args, 1)
+			if err != nil {
+				return err
+			}
+			ref := repoArg(args[0], namespace)
+			resp, err := client.Artifacts().GetArtifactRepository(cmd.Context(), connect.NewRequest(&v1.GetArtifactRepositoryRequest{
+				Name:      ref.Name,
+				Namespace: ref.Namespace,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			repo := resp.Msg.GetRepository()
+			return openPage(cmd, "/artifacts/"+url.PathEscape(repo.GetNamespace())+"/"+url.PathEscape(repo.GetName()))
+		},
+	}
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
+	return cmd
+}
+
+// Opens a web UI path on the configured server, printing it when asked
+// to or when no browser starts
+func openPage(cmd *cobra.Command, path string) error {
+	target := client.BaseURL + path
+	if printOnly, _ := cmd.Flags().GetBool("print"); printOnly {
+		fmt.Println(target)
+		return nil
+	}
+	if err := openBrowser(target); err != nil {
+		debugf("opening browser: %v", err)
+		fmt.Fprintln(os.Stderr, "Could not start a browser, open this address instead:")
+		fmt.Println(target)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Opening %s\n", target)
+	return nil
+}
+
+func openBrowser(target string) error {
+	var c *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		c = exec.Command("open", target)
+	case "windows":
+		c = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		c = exec.Command("xdg-open", target)
+	}
+	return c.Start()
+}
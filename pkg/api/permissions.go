@@ -0,0 +1,174 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+// Shared by the image and artifact trees, one ACL covers both repos at a path
+func newPermissionsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "permissions",
+		Aliases: []string{"acl"},
+		Short:   "Manage who else may read, write or administer a repository",
+		Long: `Grant users or roles access to one repository beyond what their namespace
+and organization membership give them. The grant covers the image and the
+artifact repository at that path alike.
+
+  read   see and pull
+  write  read plus push, upload and edit artifacts
+  admin  write plus delete, settings and these grants
+
+Managing grants takes admin over the repository.`,
+	}
+	cmd.AddCommand(
+		newPermissionsListCmd(),
+		newPermissionsGrantCmd(),
+		newPermissionsRevokeCmd(),
+	)
+	return cmd
+}
+
+func permissionsRepoArg(arg string) (string, string, error) {
+	namespace, name, ok := strings.Cut(arg, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("repository must be namespace/name (e.g. myorg/app), got %q", arg)
+	}
+	return namespace, name, nil
+}
+
+// Subject from --user or --role, exactly one of them
+func permissionSubjectFlags(user, role string) (string, string, error) {
+	if (user == "") == (role == "") {
+		return "", "", fmt.Errorf("give either --user or --role")
+	}
+	if user != "" {
+		return "user", user, nil
+	}
+	return "role", role, nil
+}
+
+func newPermissionsListCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "list [namespace/name]",
+		Short: "List the grants on a repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, err := permissionsRepoArg(args[0])
+			if err != nil {
+				return err
+			}
+			resp, err := client.Repositories().ListRepositoryPermissions(cmd.Context(), connect.NewRequest(&v1.ListRepositoryPermissionsRequest{
+				Namespace: namespace,
+				Name:      name,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				msgs := make([]proto.Message, len(resp.Msg.Permissions))
+				for i, p := range resp.Msg.Permissions {
+					msgs[i] = p
+				}
+				return printProtoJSON(msgs)
+			}
+			if len(resp.Msg.Permissions) == 0 {
+				fmt.Printf("No grants on %s\n", args[0])
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TYPE\tSUBJECT\tLEVEL\tGRANTED BY\tUPDATED")
+			for _, p := range resp.Msg.Permissions {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.SubjectType, p.Subject, p.Level, p.GrantedBy, formatTimestamp(p.UpdatedAt))
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newPermissionsGrantCmd() *cobra.Command {
+	var user, role, level string
+	cmd := &cobra.Command{
+		Use:   "grant [namespace/name]",
+		Short: "Grant a user or role a level on a repository",
+		Long: `Grant a user or role read, write or admin on a repository. Granting
+again replaces the earlier level.`,
+		Example: `  dfcli image permissions grant myorg/app --user alice --level write
+  dfcli artifact permissions grant myorg/builds --role qa --level read`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, err := permissionsRepoArg(args[0])
+			if err != nil {
+				return err
+			}
+			subjectType, subject, err := permissionSubjectFlags(user, role)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Repositories().SetRepositoryPermission(cmd.Context(), connect.NewRequest(&v1.SetRepositoryPermissionRequest{
+				Namespace:   namespace,
+				Name:        name,
+				SubjectType: subjectType,
+				Subject:     subject,
+				Level:       level,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			p := resp.Msg.Permission
+			fmt.Printf("Granted %s %s %s on %s\n", p.SubjectType, p.Subject, p.Level, args[0])
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&user, "user", "", "Username to grant")
+	cmd.Flags().StringVar(&role, "role", "", "Role to grant, every holder gets the level")
+	cmd.Flags().StringVar(&level, "level", "read", "read, write or admin")
+	return cmd
+}
+
+func newPermissionsRevokeCmd() *cobra.Command {
+	var user, role string
+	cmd := &cobra.Command{
+		Use:   "revoke [namespace/name]",
+		Short: "Remove the grant of a user or role",
+		Long: `Remove the grant of a user or role on a repository. Access through the
+namespace or organization membership stays.`,
+		Example: `  dfcli image permissions revoke myorg/app --user alice`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, err := permissionsRepoArg(args[0])
+			if err != nil {
+				return err
+			}
+			subjectType, subject, err := permissionSubjectFlags(user, role)
+			if err != nil {
+				return err
+			}
+			_, err = client.Repositories().RemoveRepositoryPermission(cmd.Context(), connect.NewRequest(&v1.RemoveRepositoryPermissionRequest{
+				Namespace:   namespace,
+				Name:        name,
+				SubjectType: subjectType,
+				Subject:     subject,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Revoked %s %s on %s\n", subjectType, subject, args[0])
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&user, "user", "", "Username to revoke")
+	cmd.Flags().StringVar(&role, "role", "", "Role to revoke")
+	return cmd
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Executables named dfcli-<name> on PATH run as dfcli <name>
+const pluginPrefix = "dfcli-"
+
+// Sessions closer to expiry than this are refreshed before a plugin starts
+const pluginTokenMinValid = 5 * time.Minute
+
+// Names cobra adds at execute time, plugins never shadow them
+var reservedCommands = []string{"help", "completion"}
+
+func isBuiltinCommand(root *cobra.Command, name string) bool {
+	for _, reserved := range reservedCommands {
+		if name == reserved {
+			return true
+		}
+	}
+	for _, c := range root.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// First positional argument and its index, skipping root flags and their values
+func firstArg(flags *pflag.FlagSet, args []string) (string, int) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			return "", -1
+		}
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			return a, i
+		}
+		if strings.Contains(a, "=") {
+			continue
+		}
+		var f *pflag.Flag
+		if name, ok := strings.CutPrefix(a, "--"); ok {
+			f = flags.Lookup(name)
+		} else if len(a) == 2 {
+			f = flags.ShorthandLookup(a[1:])
+		}
+		if f != nil && f.NoOptDefVal == "" {
+			i++
+		}
+	}
+	return "", -1
+}
+
+// Adds the plugin command args call for, when they name no builtin and a
+// dfcli-<name> executable is on PATH. Only the one plugin invoked is looked up
+func addPluginCmd(root *cobra.Command, args []string) {
+	name, at := firstArg(root.PersistentFlags(), args)
+	if name == "" || strings.ContainsAny(name, `/\`) || isBuiltinCommand(root, name) {
+		return
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return
+	}
+	leading, rest := args[:at], args[at+1:]
+	root.AddCommand(&cobra.Command{
+		Use:                name,
+		Short:              "Plugin " + path,
+		DisableFlagParsing: true,
+		// Flag parsing is off for the plugin's own flags, root flags given
+		// before its name still apply
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			if err := root.PersistentFlags().Parse(leading); err != nil {
+				return err
+			}
+			return initClient()
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runPlugin(cmd, path, rest)
+		},
+	})
+}
+
+// Session handed to plugins, dfcli run from a plugin picks the same one up
+func pluginEnv(cmd *cobra.Command) []string {
+	env := append(os.Environ(),
+		"DFCLI_SERVER="+client.BaseURL,
+		"DFCLI_CONFIG="+configPath(),
+	)
+	if client.Username != "" {
+		env = append(env, "DFCLI_USERNAME="+client.Username)
+	}
+	if client.Tokens.GetToken() != "" {
+		if client.Tokens.ExpiresWithin(pluginTokenMinValid) {
+			if err := client.refreshToken(cmd.Context()); err != nil {
+				debugf("Session refresh for plugin failed: %v", err)
+			}
+		}
+		if !client.Tokens.IsExpired() {
+			env = append(env, "DFCLI_TOKEN="+client.Tokens.GetToken())
+		}
+	}
+	if _, err := os.Stat(caPath()); err == nil {
+		env = append(env, "DFCLI_CA_FILE="+caPath())
+	}
+	if self, err := os.Executable(); err == nil {
+		env = append(env, "DFCLI_BIN="+self)
+	}
+	if viper.GetBool("debug") {
+		env = append(env, "DFCLI_DEBUG=true")
+	}
+	return env
+}
+
+// Runs the plugin in the foreground and exits with its status
+func runPlugin(cmd *cobra.Command, path string, args []string) error {
+	c := exec.Command(path, args...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	c.Env = pluginEnv(cmd)
+	debugf("Running plugin %s", path)
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("failed to start plugin %s: %v", path, err)
+	}
+
+	// Ctrl-C reaches the plugin through the terminal, dfcli waits it out.
+	// A terminate sent to dfcli alone is passed on
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for s := range signals {
+			if s != os.Interrupt {
+				_ = c.Process.Signal(s)
+			}
+		}
+	}()
+
+	err := c.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	return err
+}
+
+type pluginInfo struct {
+	name, path string
+	shadowed   string // Why it never runs, empty when it does
+}
+
+// Every dfcli-* executable on PATH, in PATH order
+func findPlugins(root *cobra.Command) []pluginInfo {
+	var found []pluginInfo
+	seen := map[string]string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, pluginPrefix+"*"))
+		sort.Strings(matches)
+		for _, p := range matches {
+			if !isExecutable(p) {
+				continue
+			}
+			name := strings.TrimPrefix(filepath.Base(p), pluginPrefix)
+			if ext := filepath.Ext(name); strings.EqualFold(ext, ".exe") {
+				name = strings.TrimSuffix(name, ext)
+			}
+			info := pluginInfo{name: name, path: p}
+			switch {
+			case isBuiltinCommand(root, name):
+				info.shadowed = "builtin command"
+			case seen[name] != "":
+				info.shadowed = "shadowed by " + seen[name]
+			default:
+				seen[name] = p
+			}
+			found = append(found, info)
+		}
+	}
+	return found
+}
+
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	if strings.EqualFold(filepath.Ext(path), ".exe") {
+		return true
+	}
+	return fi.Mode()&0111 != 0
+}
+
+func newPluginCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plugin",
+		Short: "Inspect CLI plugins",
+		Long: `Any executable named dfcli-<name> on PATH runs as dfcli <name>, with its
+arguments passed through. Plugins get the current session in the
+environment: DFCLI_SERVER, DFCLI_TOKEN, DFCLI_USERNAME, DFCLI_CONFIG,
+DFCLI_CA_FILE when an instance CA is trusted, and DFCLI_BIN, the dfcli
+binary itself. Builtin commands always win over plugins of the same name.`,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List plugins found on PATH",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			plugins := findPlugins(cmd.Root())
+			if len(plugins) == 0 {
+				fmt.Println("No dfcli-* plugins found on PATH")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "COMMAND\tPATH\tNOTE")
+			for _, p := range plugins {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", p.name, p.path, p.shadowed)
+			}
+			return w.Flush()
+		},
+	})
+	return cmd
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+func newReplicationCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replication",
+		Short: "Manage push replication to remote registries",
+		Long: `Replication rules copy images pushed to matching repositories of a
+namespace on to a remote registry, as <target>/<name>:<tag>. Copies run in
+the server's job queue and retry with backoff, the remote login comes from
+a vault credential (see dfcli credential).
+
+  dfcli replication create acme/to-ghcr --target ghcr.io/acme --tags 'v*' --credential acme/ghcr
+  dfcli replication sync acme/to-ghcr`,
+	}
+	cmd.AddCommand(
+		newReplicationCreateCmd(),
+		newReplicationListCmd(),
+		newReplicationStatusCmd(),
+		newReplicationUpdateCmd(),
+		newReplicationSyncCmd(),
+		newReplicationDeleteCmd(),
+	)
+	return cmd
+}
+
+// namespace/name or a bare name in the caller's namespace
+func (c *Client) findReplicationRule(ctx context.Context, ref string) (*v1.ReplicationRule, error) {
+	ns, name, ok := strings.Cut(ref, "/")
+	if !ok {
+		ns, name = c.Username, ref
+	}
+	resp, err := c.Replication().ListReplicationRules(ctx, connect.NewRequest(&v1.ListReplicationRulesRequest{Namespace: ns}))
+	if err != nil {
+		return nil, rpcErr(err)
+	}
+	for _, r := range resp.Msg.Rules {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("replication rule %s/%s not found", ns, name)
+}
+
+// Credential id for a [namespace/]name flag, empty stays empty
+func credentialID(ctx context.Context, ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	cred, err := client.findCredential(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return cred.Id, nil
+}
+
+func newReplicationCreateCmd() *cobra.Command {
+	var target, repos, tags, credential string
+	var disabled bool
+
+	cmd := &cobra.Command{
+		Use:   "create [namespace/]name",
+		Short: "Add a replication rule",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ns, name, ok := strings.Cut(args[0], "/")
+			if !ok {
+				ns, name = "", args[0]
+			}
+			credID, err := credentialID(cmd.Context(), credential)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Replication().CreateReplicationRule(cmd.Context(), connect.NewRequest(&v1.CreateReplicationRuleRequest{
+				Namespace:    ns,
+				Name:         name,
+				RepoPattern:  repos,
+				TagPattern:   tags,
+				Target:       target,
+				CredentialId: credID,
+				Disabled:     disabled,
+			}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			r := resp.Msg.Rule
+			fmt.Printf("Created replication rule %s/%s: %s/%s:%s to %s\n", r.Namespace, r.Name, r.Namespace, r.RepoPattern, r.TagPattern, r.Target)
+			if !disabled {
+				fmt.Printf("New pushes replicate, run dfcli replication sync %s/%s to copy existing tags\n", r.Namespace, r.Name)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&target, "target", "", "Remote registry host and path, e.g. ghcr.io/acme")
+	cmd.Flags().StringVar(&repos, "repos", "*", "Repository name glob within the namespace")
+	cmd.Flags().StringVar(&tags, "tags", "*", "Tag glob")
+	cmd.Flags().StringVar(&credential, "credential", "", "Vault credential as [namespace/]name, empty pushes anonymously")
+	cmd.Flags().BoolVar(&disabled, "disabled", false, "Create the rule without replicating yet")
+	_ = cmd.MarkFlagRequired("target")
+	return cmd
+}
+
+func newReplicationListCmd() *cobra.Command {
+	var namespace string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List replication rules in namespaces you manage",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := client.Replication().ListReplicationRules(cmd.Context(), connect.NewRequest(&v1.ListReplicationRulesRequest{Namespace: namespace}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			rules := resp.Msg.Rules
+
+			if asJSON {
+				msgs := make([]proto.Message, len(rules))
+				for i, r := range rules {
+					msgs[i] = r
+				}
+				return printProtoJSON(msgs)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "RULE\tREPOS\tTAGS\tTARGET\tENABLED\tREPLICATED\tPENDING\tFAILED\tLAST RUN")
+			for _, r := range rules {
+				st := r.GetStatus()
+				fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\t%t\t%d\t%d\t%d\t%s\n", r.Namespace, r.Name, r.RepoPattern, r.TagPattern, r.Target,
+					r.Enabled, st.GetReplicated(), st.GetPending(), st.GetFailed(), formatTimestamp(st.GetLastRunAt()))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Only this namespace")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newReplicationStatusCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "status [namespace/]name",
+		Short: "Show a rule's last copy and its queued or failed copies",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rule, err := client.findReplicationRule(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			resp, err := client.Replication().GetReplicationRule(cmd.Context(), connect.NewRequest(&v1.GetReplicationRuleRequest{Id: rule.Id}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			if asJSON {
+				return printProtoJSON([]proto.Message{resp.Msg})
+			}
+
+			r, st := resp.Msg.Rule, resp.Msg.Rule.GetStatus()
+			fmt.Printf("Rule:          %s/%s\n", r.Namespace, r.Name)
+			fmt.Printf("Copies:        %s/%s:%s to %s/<name>:<tag>\n", r.Namespace, r.RepoPattern, r.TagPattern, r.Target)
+			fmt.Printf("Enabled:       %t\n", r.Enabled)
+			fmt.Printf("Replicated:    %d (%d failed attempts)\n", st.GetReplicated(), st.GetFailures())
+			fmt.Printf("Last run:      %s %s %s\n", formatTimestamp(st.GetLastRunAt()), st.GetLastRef(), shortDigest(st.GetLastDigest()))
+			fmt.Printf("Last success:  %s\n", formatTimestamp(st.GetLastSuccessAt()))
+			if st.GetLastError() != "" {
+				fmt.Printf("Last error:    %s\n", st.GetLastError())
+			}
+			if len(resp.Msg.Jobs) == 0 {
+				return nil
+			}
+			fmt.Println()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TAG\tSTATUS\tATTEMPTS\tNEXT RUN\tERROR")
+			for _, j := range resp.Msg.Jobs {
+				_, ref, _ := strings.Cut(j.Subject, " ")
+				next := ""
+				if j.Status == "pending" {
+					next = formatTimestamp(j.RunAt)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\n", ref, j.Status, j.Attempts, j.MaxAttempts, next, j.LastError)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func newReplicationUpdateCmd() *cobra.Command {
+	var target, repos, tags, credential string
+	var enable, disable bool
+
+	cmd := &cobra.Command{
+		Use:   "update [namespace/]name",
+		Short: "Change a rule's patterns, target or credential, or pause it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if enable && disable {
+				return fmt.Errorf("--enable and --disable are mutually exclusive")
+			}
+			rule, err := client.findReplicationRule(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			req := &v1.UpdateReplicationRuleRequest{Id: rule.Id}
+			if cmd.Flags().Changed("target") {
+				req.Target = proto.String(target)
+			}
+			if cmd.Flags().Changed("repos") {
+				req.RepoPattern = proto.String(repos)
+			}
+			if cmd.Flags().Changed("tags") {
+				req.TagPattern = proto.String(tags)
+			}
+			if cmd.Flags().Changed("credential") {
+				id, err := credentialID(cmd.Context(), credential)
+				if err != nil {
+					return err
+				}
+				req.CredentialId = proto.String(id)
+			}
+			if enable || disable {
+				req.Enabled = proto.Bool(enable)
+			}
+			if _, err := client.Replication().UpdateReplicationRule(cmd.Context(), connect.NewRequest(req)); err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Updated replication rule %s/%s\n", rule.Namespace, rule.Name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&target, "target", "", "New remote registry host and path")
+	cmd.Flags().StringVar(&repos, "repos", "", "New repository name glob")
+	cmd.Flags().StringVar(&tags, "tags", "", "New tag glob")
+	cmd.Flags().StringVar(&credential, "credential", "", "New vault credential as [namespace/]name, empty to push anonymously")
+	cmd.Flags().BoolVar(&enable, "enable", false, "Resume replicating")
+	cmd.Flags().BoolVar(&disable, "disable", false, "Pause replicating, queued copies are skipped")
+	return cmd
+}
+
+func newReplicationSyncCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sync [namespace/]name",
+		Short: "Queue every tag a rule covers and retry its failed copies",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rule, err := client.findReplicationRule(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			resp, err := client.Replication().SyncReplicationRule(cmd.Context(), connect.NewRequest(&v1.SyncReplicationRuleRequest{Id: rule.Id}))
+			if err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Queued %d tags for %s/%s, remote copies already current are skipped\n", resp.Msg.Queued, rule.Namespace, rule.Name)
+			return nil
+		},
+	}
+}
+
+func newReplicationDeleteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete [namespace/]name",
+		Short: "Delete a replication rule, remote copies stay",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rule, err := client.findReplicationRule(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			if _, err := client.Replication().DeleteReplicationRule(cmd.Context(), connect.NewRequest(&v1.DeleteReplicationRuleRequest{Id: rule.Id})); err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Deleted replication rule %s/%s\n", rule.Namespace, rule.Name)
+			return nil
+		},
+	}
+}
@@ -3,6 +3,7 @@ package api
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -16,32 +17,79 @@ func NewRootCmd(version string) *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyCommandDefaults(cmd); err != nil {
+				return err
+			}
+			mode := viper.GetString("ci_annotations")
+			if err := validateCIAnnotations(mode); err != nil {
+				return fmt.Errorf("--ci-annotations: %w", err)
+			}
+			ci = newAnnotator(mode)
+			if dir := viper.GetString("temp_dir"); dir != "" {
+				cleanStaleScratch(dir)
+			}
 			return initClient()
 		},
 	}
 
 	viper.SetDefault("server", defaultServerURL)
 	viper.SetDefault("timeout", "5m")
+	viper.SetDefault("idle_timeout", "2m")
+	viper.SetDefault("ci_annotations", ciOff)
 
+	bindSettingEnv()
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ~/.dfcli/config.json)")
 	rootCmd.PersistentFlags().String("server", defaultServerURL, "DistroFace server URL")
-	rootCmd.PersistentFlags().String("timeout", "5m", "Request timeout (30s, 5m, 1h, etc.)")
+	rootCmd.PersistentFlags().String("timeout", "5m", "API request timeout (30s, 5m, 1h, etc.), file transfers use the transfer timeouts")
+	rootCmd.PersistentFlags().String("idle-timeout", "2m", "Abort a file transfer after no data moves for this long, 0 disables")
+	rootCmd.PersistentFlags().String("transfer-timeout", "0", "Total limit for one file transfer, 0 for none")
 	rootCmd.PersistentFlags().Bool("debug", false, "Enable debug output")
+	rootCmd.PersistentFlags().Bool("no-cache", false, "Bypass the local response cache for list and search calls")
+	rootCmd.PersistentFlags().String("temp-dir", "", "Scratch directory for archive downloads (default beside the destination)")
+	rootCmd.PersistentFlags().String("download-cache", "", "Directory caching files of parallel downloads by checksum")
+	rootCmd.PersistentFlags().String("client-cert", "", "PEM client certificate for servers that sign in by mTLS")
+	rootCmd.PersistentFlags().String("client-key", "", "Key for --client-cert (default read from the certificate file)")
+	rootCmd.PersistentFlags().String("ci-annotations", ciOff, "Annotate transfers and failures for the pipeline UI: off, auto, github or gitlab")
 
 	_ = viper.BindPFlag("server", rootCmd.PersistentFlags().Lookup("server"))
 	_ = viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	_ = viper.BindPFlag("idle_timeout", rootCmd.PersistentFlags().Lookup("idle-timeout"))
+	_ = viper.BindPFlag("transfer_timeout", rootCmd.PersistentFlags().Lookup("transfer-timeout"))
 	_ = viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
+	_ = viper.BindPFlag("no_cache", rootCmd.PersistentFlags().Lookup("no-cache"))
+	_ = viper.BindPFlag("temp_dir", rootCmd.PersistentFlags().Lookup("temp-dir"))
+	_ = viper.BindPFlag("download_cache", rootCmd.PersistentFlags().Lookup("download-cache"))
+	_ = viper.BindPFlag("client_cert", rootCmd.PersistentFlags().Lookup("client-cert"))
+	_ = viper.BindPFlag("client_key", rootCmd.PersistentFlags().Lookup("client-key"))
+	_ = viper.BindPFlag("ci_annotations", rootCmd.PersistentFlags().Lookup("ci-annotations"))
 
 	rootCmd.AddCommand(
 		newLoginCmd(),
 		newLogoutCmd(),
+		newWhoamiCmd(),
+		newAuthCmd(),
 		newTrustCmd(),
 		newImageCmd(),
 		newArtifactCmd(),
+		newGroupCmd(),
+		newUserCmd(),
+		newCredentialCmd(),
+		newReplicationCmd(),
+		newTokenCmd(),
+		newNotificationCmd(),
+		newSchemaCmd(),
+		newCapabilitiesCmd(),
+		newAdminCmd(),
+		newExportCmd(),
+		newConfigCmd(),
+		newOpenCmd(),
+		newPluginCmd(),
+		newDocsCmd(version),
 		newVersionCmd(version),
 	)
+	addPluginCmd(rootCmd, os.Args[1:])
 	return rootCmd
 }
 
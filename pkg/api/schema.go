@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newSchemaCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "schema [model]",
+		Short: "Print the JSON Schema of a server data model",
+		Long: `Prints the JSON Schema the server publishes for a model such as artifact,
+repository, user or role. Without a model the available names are listed.
+
+Schemas describe the JSON form of each model, matching --json output.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/api/meta/schemas"
+			if len(args) == 1 {
+				path += "/" + url.PathEscape(strings.ToLower(args[0]))
+			}
+			req, err := http.NewRequestWithContext(cmd.Context(), http.MethodGet, client.BaseURL+path, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create request: %w", err)
+			}
+			resp, err := client.HTTPClient.Do(req)
+			if err != nil {
+				return hintTLS(fmt.Errorf("request failed: %w", err))
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return fmt.Errorf("failed to read schema: %w", err)
+			}
+			if resp.StatusCode == http.StatusNotFound && len(args) == 1 {
+				return fmt.Errorf("unknown model %q, run 'dfcli schema' to list models", args[0])
+			}
+			if resp.StatusCode >= 400 {
+				return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+			}
+
+			if len(args) == 0 && output == "" {
+				var list struct {
+					Models []string `json:"models"`
+				}
+				if err := json.Unmarshal(body, &list); err != nil {
+					return fmt.Errorf("invalid schema list: %w", err)
+				}
+				for _, m := range list.Models {
+					fmt.Println(m)
+				}
+				return nil
+			}
+			if output != "" {
+				if err := os.WriteFile(output, body, 0644); err != nil {
+					return fmt.Errorf("failed to write %s: %w", output, err)
+				}
+				fmt.Fprintf(os.Stderr, "Wrote %s\n", output)
+				return nil
+			}
+			_, err = os.Stdout.Write(body)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the schema to a file")
+	return cmd
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Session scratch dirs are named so a later run can spot ones a killed
+// run left behind
+const (
+	scratchPrefix   = ".dfcli-tmp-"
+	staleScratchAge = 24 * time.Hour
+)
+
+// Scratch space for one command, under temp_dir when set, else beside
+// near so finished files rename into place on the same volume instead of
+// crossing a small tmpfs. The returned func removes it
+func newScratchDir(near string) (string, func(), error) {
+	root := viper.GetString("temp_dir")
+	if root == "" {
+		root = near
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", nil, fmt.Errorf("temp dir %s: %w", root, err)
+	}
+	cleanStaleScratch(root)
+	dir, err := os.MkdirTemp(root, scratchPrefix+"*")
+	if err != nil {
+		return "", nil, fmt.Errorf("temp dir %s: %w", root, err)
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// Drops scratch dirs of runs that never cleaned up after themselves
+func cleanStaleScratch(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-staleScratchAge)
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), scratchPrefix) {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.RemoveAll(filepath.Join(root, e.Name()))
+		}
+	}
+}
+
+// Fails before a transfer that cannot fit, unknown sizes and platforms
+// without volume stats pass
+func ensureFree(dir string, need int64) error {
+	if need <= 0 {
+		return nil
+	}
+	free, ok := freeBytes(dir)
+	if !ok || free >= uint64(need) {
+		return nil
+	}
+	return fmt.Errorf("not enough space in %s: need %s, %s free (set temp_dir or DFCLI_TEMP_DIR to a larger volume)", dir, formatSize(need), formatSize(int64(free)))
+}
+
+func validateTempDir(v string) error {
+	if v != "" && !filepath.IsAbs(v) {
+		return fmt.Errorf("want an absolute path")
+	}
+	return nil
+}
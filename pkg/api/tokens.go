@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTokenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage personal access tokens",
+		Long: `Personal access tokens are long lived credentials for ci and scripts. They
+work as the password for docker login, as a bearer token and with
+'dfcli login --token'. Scopes narrow a token below your roles:
+
+  repo:read        pull images
+  repo:write       pull and push images
+  artifact:read    list and download artifacts
+  artifact:write   upload, edit and delete artifacts
+
+A token without scopes can do everything you can. Scoped tokens cannot
+manage tokens, users or settings, nor trade themselves for a session.`,
+	}
+	cmd.AddCommand(
+		newTokenCreateCmd(),
+		newTokenListCmd(),
+		newTokenDeleteCmd(),
+	)
+	return cmd
+}
+
+func (c *Client) listAPITokens(ctx context.Context) ([]*v1.APIToken, error) {
+	var tokens []*v1.APIToken
+	page := ""
+	for {
+		resp, err := c.APITokens().ListAPITokens(ctx, connect.NewRequest(&v1.ListAPITokensRequest{
+			Page: &v1.PageRequest{PageSize: 500, PageToken: page},
+		}))
+		if err != nil {
+			return nil, rpcErr(err)
+		}
+		tokens = append(tokens, resp.Msg.Tokens...)
+		if page = resp.Msg.Page.GetNextPageToken(); page == "" {
+			return tokens, nil
+		}
+	}
+}
+
+func newTokenCreateCmd() *cobra.Command {
+	var scopes []string
+	var expiresDays int32
+
+	cmd := &cobra.Command{
+		Use:   "create name",
+		Short: "Create a token, printed once",
+		Example: `  dfcli token create ci-pull --scope repo:read --expires-days 90
+  dfcli token create release --scope repo:write --scope artifact:write`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req := &v1.CreateAPITokenRequest{Name: args[0], Scopes: scopes}
+			if expiresDays > 0 {
+				req.ExpiresInDays = proto.Int32(expiresDays)
+			}
+			resp, err := client.APITokens().CreateAPIToken(cmd.Context(), connect.NewRequest(req))
+			if err != nil {
+				return rpcErr(err)
+			}
+			// Only the token on stdout, so TOKEN=$(dfcli token create ...) works
+			fmt.Fprintf(os.Stderr, "Created token %s (id %s), it will not be shown again\n", resp.Msg.Token.Name, resp.Msg.Token.Id)
+			fmt.Println(resp.Msg.PlaintextToken)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&scopes, "scope", nil, "Limit the token, repeatable (repo:read, repo:write, artifact:read, artifact:write)")
+	cmd.Flags().Int32Var(&expiresDays, "expires-days", 0, "Days until the token expires, 0 never")
+	return cmd
+}
+
+func newTokenListCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List your tokens",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tokens, err := client.listAPITokens(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				msgs := make([]proto.Message, len(tokens))
+				for i, t := range tokens {
+					msgs[i] = t
+				}
+				return printProtoJSON(msgs)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tID\tSCOPES\tCREATED\tEXPIRES\tLAST USED")
+			for _, t := range tokens {
+				scopes := strings.Join(t.Scopes, ",")
+				if scopes == "" {
+					scopes = "all"
+				}
+				name := t.Name
+				if t.Current {
+					name += " (this token)"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, t.Id, scopes,
+					t.CreatedAt.AsTime().Local().Format("2006-01-02 15:04"),
+					tokenTime(t.ExpiresAt != nil, t.ExpiresAt.AsTime().Local().Format("2006-01-02 15:04")),
+					tokenTime(t.LastUsedAt != nil, t.LastUsedAt.AsTime().Local().Format("2006-01-02 15:04")))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	return cmd
+}
+
+func tokenTime(set bool, formatted string) string {
+	if !set {
+		return "-"
+	}
+	return formatted
+}
+
+func newTokenDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete name|id",
+		Short: "Revoke a token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tokens, err := client.listAPITokens(cmd.Context())
+			if err != nil {
+				return err
+			}
+			var match []*v1.APIToken
+			for _, t := range tokens {
+				if t.Id == args[0] {
+					match = []*v1.APIToken{t}
+					break
+				}
+				if t.Name == args[0] {
+					match = append(match, t)
+				}
+			}
+			switch len(match) {
+			case 0:
+				return fmt.Errorf("token %s not found", args[0])
+			case 1:
+			default:
+				return fmt.Errorf("%d tokens are named %s, delete by id", len(match), args[0])
+			}
+			if _, err := client.APITokens().DeleteAPIToken(cmd.Context(), connect.NewRequest(&v1.DeleteAPITokenRequest{Id: match[0].Id})); err != nil {
+				return rpcErr(err)
+			}
+			fmt.Printf("Revoked token %s (id %s)\n", match[0].Name, match[0].Id)
+			return nil
+		},
+	}
+	return cmd
+}
@@ -1,15 +1,20 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
 func debugf(format string, args ...any) {
@@ -40,6 +45,39 @@ func formatSize(size int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
+// Go durations plus whole days like 30d, empty is zero
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, use a duration like 12h or days like 30d", s)
+	}
+	return d, nil
+}
+
+// Asks on the terminal, scripts without one must pass --yes
+func confirm(prompt string) (bool, error) {
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return false, fmt.Errorf("no terminal to confirm on, pass --yes")
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return false, nil
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
+
 var (
 	nonWordPattern    = regexp.MustCompile(`[^\w\-\.]`)
 	multiUnderscore   = regexp.MustCompile(`_+`)
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"connectrpc.com/connect"
+	v1 "github.com/nickheyer/distroface/pkg/proto/distroface/v1"
+	"github.com/spf13/cobra"
+)
+
+const (
+	verifyOK       = "OK"
+	verifyMismatch = "MISMATCH"
+	verifyMissing  = "MISSING"
+	verifyError    = "ERROR"
+)
+
+// One artifact checked against its server side digest
+type verifyResult struct {
+	Path     string `json:"path"`
+	Status   string `json:"status"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Every artifact of one version, following page tokens
+func (c *Client) listVersionArtifacts(ctx context.Context, ref RepoRef, version string) ([]*v1.Artifact, error) {
+	var out []*v1.Artifact
+	token := ""
+	for {
+		resp, err := c.Artifacts().ListArtifacts(ctx, connect.NewRequest(&v1.ListArtifactsRequest{
+			RepoName:  ref.Name,
+			Namespace: ref.Namespace,
+			Version:   version,
+			Page:      &v1.PageRequest{PageSize: maxPageSize, PageToken: token},
+		}))
+		if err != nil {
+			return nil, rpcErr(err)
+		}
+		out = append(out, resp.Msg.Artifacts...)
+		token = resp.Msg.GetPage().GetNextPageToken()
+		if token == "" {
+			return out, nil
+		}
+	}
+}
+
+// Whether version and path hold anything, and whether any of it matches
+// the local file's checksum
+func (c *Client) artifactAtPath(ctx context.Context, ref RepoRef, version, artifactPath, file string) (exists, same bool, err error) {
+	artifacts, err := c.listVersionArtifacts(ctx, ref, version)
+	if err != nil {
+		return false, false, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	sum, err := sha256Hex(f)
+	if err != nil {
+		return false, false, err
+	}
+	for _, a := range artifacts {
+		if a.Path != artifactPath {
+			continue
+		}
+		exists = true
+		if strings.TrimPrefix(a.Digest, "sha256:") == sum {
+			return true, true, nil
+		}
+	}
+	return exists, false, nil
+}
+
+// Exact path or anything beneath it when path names a directory
+func pathSelected(artifactPath, filter string) bool {
+	filter = strings.Trim(filter, "/")
+	return filter == "" || artifactPath == filter || strings.HasPrefix(artifactPath, filter+"/")
+}
+
+func sha256Hex(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func checkDigest(res *verifyResult, r io.Reader) {
+	actual, err := sha256Hex(r)
+	if err != nil {
+		res.Status, res.Error = verifyError, err.Error()
+		return
+	}
+	res.Actual = actual
+	if actual == res.Expected {
+		res.Status = verifyOK
+	} else {
+		res.Status = verifyMismatch
+	}
+}
+
+func verifyLocal(a *v1.Artifact, dir string, res *verifyResult) {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(a.Path)))
+	if errors.Is(err, fs.ErrNotExist) {
+		res.Status = verifyMissing
+		return
+	}
+	if err != nil {
+		res.Status, res.Error = verifyError, err.Error()
+		return
+	}
+	defer f.Close()
+	checkDigest(res, f)
+}
+
+// Streams the stored copy back through the data plane and rehashes it
+func (c *Client) verifyRemote(ctx context.Context, ref RepoRef, a *v1.Artifact, res *verifyResult) {
+	endpoint := ref.basePath() + "/" + url.PathEscape(a.Version) + "/" + escapeArtifactPath(a.Path)
+	resp, err := c.doData(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		res.Status, res.Error = verifyError, err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	checkDigest(res, resp.Body)
+}
+
+func escapeArtifactPath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func newArtifactVerifyCmd() *cobra.Command {
+	var (
+		version   string
+		artPath   string
+		namespace string
+		dir       string
+		remote    bool
+		asJSON    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify [repo]",
+		Short: "Verify artifact checksums against the server",
+		Long: `Verify every artifact of a version against the sha256 digest the
+server recorded at upload. Local files are read from --dir using each
+artifact's path, --remote downloads and rehashes the stored copies
+instead. Exits non-zero when anything is missing or mismatched.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if version == "" {
+				return fmt.Errorf("--version is required")
+			}
+			ctx := cmd.Context()
+			ref := repoArg(args[0], namespace)
+
+			artifacts, err := client.listVersionArtifacts(ctx, ref, sanitizeVersion(version))
+			if err != nil {
+				return err
+			}
+
+			var results []verifyResult
+			failed := 0
+			for _, a := range artifacts {
+				if !pathSelected(a.Path, artPath) {
+					continue
+				}
+				res := verifyResult{Path: a.Path, Expected: strings.TrimPrefix(a.Digest, "sha256:")}
+				if remote {
+					client.verifyRemote(ctx, ref, a, &res)
+				} else {
+					verifyLocal(a, dir, &res)
+				}
+				if res.Status != verifyOK {
+					failed++
+				}
+				results = append(results, res)
+			}
+			if len(results) == 0 {
+				return fmt.Errorf("no artifacts found for %s version %s", ref, version)
+			}
+
+			if asJSON {
+				if err := printJSON(results); err != nil {
+					return err
+				}
+			} else {
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "STATUS\tPATH\tDETAIL")
+				for _, r := range results {
+					detail := ""
+					switch r.Status {
+					case verifyMismatch:
+						detail = fmt.Sprintf("expected %s, got %s", r.Expected, r.Actual)
+					case verifyError:
+						detail = r.Error
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\n", r.Status, r.Path, detail)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d artifacts failed verification", failed, len(results))
+			}
+			if !asJSON {
+				fmt.Printf("All %d artifacts verified\n", len(results))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&version, "version", "v", "", "Artifact version to verify (required)")
+	cmd.Flags().StringVarP(&artPath, "path", "p", "", "Only verify this path or directory")
+	cmd.Flags().StringVarP(&dir, "dir", "d", ".", "Local directory holding the version's files")
+	cmd.Flags().BoolVar(&remote, "remote", false, "Rehash the server's stored copies instead of local files")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output as JSON")
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace (user or organization)")
+	return cmd
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Transfer stopped by the idle or total watchdog, dfcli exits 124 like timeout(1)
+var ErrTransferTimeout = errors.New("transfer timed out")
+
+// Process exit status for a command error
+func ExitCode(err error) int {
+	if errors.Is(err, ErrTransferTimeout) {
+		return 124
+	}
+	return 1
+}
+
+// Cancels a transfer that stops moving bytes, or outlives its total budget.
+// Slow links are fine as long as data keeps flowing
+type watchdog struct {
+	idle   time.Duration
+	idleT  *time.Timer
+	totalT *time.Timer
+	cancel context.CancelCauseFunc
+}
+
+func newWatchdog(parent context.Context, idle, total time.Duration) (context.Context, *watchdog) {
+	ctx, cancel := context.WithCancelCause(parent)
+	w := &watchdog{idle: idle, cancel: cancel}
+	if idle > 0 {
+		w.idleT = time.AfterFunc(idle, func() {
+			cancel(fmt.Errorf("%w: no data moved for %s", ErrTransferTimeout, idle))
+		})
+	}
+	if total > 0 {
+		w.totalT = time.AfterFunc(total, func() {
+			cancel(fmt.Errorf("%w: exceeded %s", ErrTransferTimeout, total))
+		})
+	}
+	return ctx, w
+}
+
+func (w *watchdog) kick() {
+	if w.idleT != nil {
+		w.idleT.Reset(w.idle)
+	}
+}
+
+func (w *watchdog) stop() {
+	if w.idleT != nil {
+		w.idleT.Stop()
+	}
+	if w.totalT != nil {
+		w.totalT.Stop()
+	}
+	w.cancel(nil)
+}
+
+// Request body side, every chunk read resets the idle timer
+type kickReader struct {
+	r io.Reader
+	w *watchdog
+}
+
+func (k *kickReader) Read(p []byte) (int, error) {
+	n, err := k.r.Read(p)
+	if n > 0 {
+		k.w.kick()
+	}
+	return n, err
+}
+
+// Response body side, reports the watchdog cause instead of a bare cancel
+type watchedBody struct {
+	io.ReadCloser
+	ctx context.Context
+	w   *watchdog
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.w.kick()
+	}
+	if err != nil && err != io.EOF {
+		if cause := context.Cause(b.ctx); errors.Is(cause, ErrTransferTimeout) {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (b *watchedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.w.stop()
+	return err
+}
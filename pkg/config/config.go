@@ -35,14 +35,38 @@ type Config struct {
 }
 
 type ServerConfig struct {
-	Port           string   `mapstructure:"port"`
-	Host           string   `mapstructure:"host"`
-	ReadTimeout    int      `mapstructure:"read_timeout"`
-	WriteTimeout   int      `mapstructure:"write_timeout"`
-	IdleTimeout    int      `mapstructure:"idle_timeout"`
-	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	Port           string            `mapstructure:"port"`
+	Host           string            `mapstructure:"host"`
+	ReadTimeout    int               `mapstructure:"read_timeout"`  // Header read deadline, bodies stream unbounded
+	WriteTimeout   int               `mapstructure:"write_timeout"` // Whole response deadline, zero disables
+	IdleTimeout    int               `mapstructure:"idle_timeout"`
+	MaxHeaderBytes int               `mapstructure:"max_header_bytes"`
+	MaxConnsPerIP  int               `mapstructure:"max_conns_per_ip"` // Zero disables, trusted proxies exempt
+	TrustedProxies []string          `mapstructure:"trusted_proxies"`
+	HTTP2          HTTP2Config       `mapstructure:"http2"`
+	Compression    CompressionConfig `mapstructure:"compression"`
 }
 
+// Negotiated gzip or deflate for api responses, blob and archive streams pass through
+type CompressionConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	MinSize      int      `mapstructure:"min_size"`      // Smaller bodies go out as is
+	ContentTypes []string `mapstructure:"content_types"` // Media types worth compressing, type/* matches the whole type
+}
+
+// HTTP/2 negotiation and stream limits
+type HTTP2Config struct {
+	Enabled              bool   `mapstructure:"enabled"`
+	H2C                  string `mapstructure:"h2c"` // on, trusted or off
+	MaxConcurrentStreams uint32 `mapstructure:"max_concurrent_streams"`
+}
+
+const (
+	H2COn      = "on"
+	H2CTrusted = "trusted"
+	H2COff     = "off"
+)
+
 // On disk certificate pair served for CERT_SOURCE_CONFIG
 type TLSConfig struct {
 	CertFile string `mapstructure:"cert_file"`
@@ -59,18 +83,33 @@ type DatabaseConfig struct {
 	MaxConnections  int    `mapstructure:"max_connections"`
 	MaxIdleConns    int    `mapstructure:"max_idle_conns"`
 	ConnMaxLifetime int    `mapstructure:"conn_max_lifetime"`
+	AutoMigrate     bool   `mapstructure:"auto_migrate"`
 }
 
 type StorageConfig struct {
 	DataDir string `mapstructure:"data_dir"`
+	// Whether the registry filesystem folds case, as NTFS and default
+	// APFS do. auto probes the registry storage path at startup
+	CaseInsensitive string `mapstructure:"case_insensitive"` // auto, on or off
 }
 
+const (
+	CaseAuto = "auto"
+	CaseOn   = "on"
+	CaseOff  = "off"
+)
+
 type RegistryConfig struct {
 	StoragePath string `mapstructure:"storage_path"`
+	PackLinks   bool   `mapstructure:"pack_links"` // Link files live in the database, see 'distroface migrate pack-links'
+	// Token audiences accepted besides distroface-registry, like the
+	// internal and external hostnames clients ask tokens for
+	TokenAudiences []string `mapstructure:"token_audiences"`
 }
 
 type ArtifactsConfig struct {
-	StoragePath string `mapstructure:"storage_path"`
+	StoragePath     string `mapstructure:"storage_path"`
+	ColdStoragePath string `mapstructure:"cold_storage_path"` // Empty disables tiering
 }
 
 type LoggingConfig struct {
@@ -83,20 +122,52 @@ type LoggingConfig struct {
 	Compress      bool   `mapstructure:"compress"`
 }
 
-// Seeds entities at startup skipping ones that exist
+// Seeds entities at startup, applied again on every start so a restart
+// converges on the file. Existing rows are never deleted
 type BootstrapConfig struct {
-	Users []BootstrapUser `mapstructure:"users"`
-	Orgs  []BootstrapOrg  `mapstructure:"orgs"`
+	File          string                  `mapstructure:"file"` // Yaml or json with the same sections, merged after this block
+	Users         []BootstrapUser         `mapstructure:"users"`
+	Orgs          []BootstrapOrg          `mapstructure:"orgs"`
+	Roles         []BootstrapRole         `mapstructure:"roles"`
+	ArtifactRepos []BootstrapArtifactRepo `mapstructure:"artifact_repos"`
+
+	// System settings written over the stored row on every start, unlike
+	// the top level seed which only lands on first boot
+	Settings *v1.Settings `mapstructure:"-"`
 }
 
 type BootstrapUser struct {
-	Username string `mapstructure:"username"`
+	Username string `mapstructure:"username"` // Existing users keep their password and email
 	Password string `mapstructure:"password"`
 	Email    string `mapstructure:"email"`
-	// System roles granted default roles when empty
+	// System roles granted default roles when empty, missing ones are
+	// added to existing users too
 	Roles []string `mapstructure:"roles"`
 }
 
+type BootstrapRole struct {
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+	Default     bool   `mapstructure:"default"` // Granted to new users
+	// Replaces the role's grants on every start, omitted leaves them alone
+	Permissions []BootstrapPermission `mapstructure:"permissions"`
+}
+
+type BootstrapPermission struct {
+	Resource string `mapstructure:"resource"`
+	Action   string `mapstructure:"action"`
+	Object   string `mapstructure:"object"` // Object id like prod/app, empty grants all
+}
+
+type BootstrapArtifactRepo struct {
+	Namespace   string `mapstructure:"namespace"` // Existing user or org
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+	Type        string `mapstructure:"type"` // file (default), debian or rpm
+	// Namespace private by default setting when unset
+	Private *bool `mapstructure:"private"`
+}
+
 type BootstrapOrg struct {
 	Name        string               `mapstructure:"name"`
 	DisplayName string               `mapstructure:"display_name"`
@@ -122,6 +193,8 @@ type MigrateConfig struct {
 	LegacyNS    string // Org namespace that flat v1 names are mapped into
 	DryRun      bool   // Print planned actions without writing
 	Jobs        int    // Concurrent repo pushes
+	Retries     int    // Attempts after the first for transient registry failures
+	State       string // Per layer record of the image replay, resumed by the next run
 	Verbose     bool
 }
 
@@ -150,11 +223,15 @@ func Load(configPath string) (*Config, error) {
 	// Keys without defaults need explicit env binding
 	_ = v.BindEnv("database.path")
 	_ = v.BindEnv("registry.storage_path")
+	_ = v.BindEnv("registry.pack_links")
+	_ = v.BindEnv("registry.token_audiences")
 	_ = v.BindEnv("artifacts.storage_path")
+	_ = v.BindEnv("artifacts.cold_storage_path")
 	_ = v.BindEnv("logging.dir")
 	_ = v.BindEnv("auth.jwt_secret")
 	_ = v.BindEnv("tls.cert_file")
 	_ = v.BindEnv("tls.key_file")
+	_ = v.BindEnv("bootstrap.file")
 
 	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
@@ -175,7 +252,14 @@ func Load(configPath string) (*Config, error) {
 		return nil, err
 	}
 	applyLegacySettings(v, &cfg)
+	applyLegacyTimeouts(v, &cfg)
 
+	if cfg.Bootstrap.Settings, err = settingsBlock(v, "bootstrap.settings", ""); err != nil {
+		return nil, err
+	}
+	if err := loadBootstrapFile(&cfg); err != nil {
+		return nil, err
+	}
 	applyEnvBootstrapUser(&cfg)
 	applyDerivedPaths(&cfg)
 
@@ -189,7 +273,7 @@ func Load(configPath string) (*Config, error) {
 // Parses one yaml block or env json payload as a settings document
 func settingsBlock(v *viper.Viper, key, envKey string) (*v1.Settings, error) {
 	var data []byte
-	if env := os.Getenv(envKey); env != "" {
+	if env := os.Getenv(envKey); envKey != "" && env != "" {
 		data = []byte(env)
 	} else if raw := v.Get(key); raw != nil {
 		var err error
@@ -272,6 +356,52 @@ func applyLegacySettings(v *viper.Viper, cfg *Config) {
 	}
 }
 
+// Old example configs shipped write_timeout: 15 while the key did nothing
+const legacyWriteTimeout = 15
+
+// Drops the dormant write timeout older configs carry, enforced now it
+// would cut off every blob pull and download running past 15 seconds
+func applyLegacyTimeouts(v *viper.Viper, cfg *Config) {
+	if v.IsSet("server.write_timeout") && cfg.Server.WriteTimeout == legacyWriteTimeout {
+		fmt.Fprintf(os.Stderr,
+			"WARNING: ignoring server.write_timeout: %d from an older config, it would cut off large pulls; set 0 to silence this or another value to enforce one\n", legacyWriteTimeout)
+		cfg.Server.WriteTimeout = 0
+	}
+}
+
+// Merges the bootstrap file after the inline block, its settings win
+func loadBootstrapFile(cfg *Config) error {
+	path := cfg.Bootstrap.File
+	if path == "" {
+		return nil
+	}
+	fv := viper.New()
+	fv.SetConfigFile(path)
+	if err := fv.ReadInConfig(); err != nil {
+		return fmt.Errorf("error reading bootstrap file: %w", err)
+	}
+	var file BootstrapConfig
+	if err := fv.Unmarshal(&file); err != nil {
+		return fmt.Errorf("error unmarshaling bootstrap file %s: %w", path, err)
+	}
+	settings, err := settingsBlock(fv, "settings", "")
+	if err != nil {
+		return fmt.Errorf("bootstrap file %s: %w", path, err)
+	}
+
+	b := &cfg.Bootstrap
+	b.Users = append(b.Users, file.Users...)
+	b.Orgs = append(b.Orgs, file.Orgs...)
+	b.Roles = append(b.Roles, file.Roles...)
+	b.ArtifactRepos = append(b.ArtifactRepos, file.ArtifactRepos...)
+	if b.Settings == nil {
+		b.Settings = settings
+	} else if settings != nil {
+		proto.Merge(b.Settings, settings)
+	}
+	return nil
+}
+
 // Appends one env defined bootstrap user
 func applyEnvBootstrapUser(cfg *Config) {
 	username := os.Getenv("DISTROFACE_BOOTSTRAP_USERNAME")
@@ -302,8 +432,16 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("server.port", "8080")
 	v.SetDefault("server.host", "0.0.0.0")
 	v.SetDefault("server.read_timeout", 15)
-	v.SetDefault("server.write_timeout", 15)
+	v.SetDefault("server.write_timeout", 0)
 	v.SetDefault("server.idle_timeout", 60)
+	v.SetDefault("server.max_header_bytes", 1<<20)
+	v.SetDefault("server.max_conns_per_ip", 0)
+	v.SetDefault("server.http2.enabled", true)
+	v.SetDefault("server.http2.h2c", H2COn)
+	v.SetDefault("server.http2.max_concurrent_streams", 250)
+	v.SetDefault("server.compression.enabled", true)
+	v.SetDefault("server.compression.min_size", 1024)
+	v.SetDefault("server.compression.content_types", []string{"application/json", "application/problem+json", "text/plain"})
 	v.SetDefault("server.trusted_proxies", []string{
 		"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
 	})
@@ -311,8 +449,10 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("database.max_connections", 25)
 	v.SetDefault("database.max_idle_conns", 5)
 	v.SetDefault("database.conn_max_lifetime", 300)
+	v.SetDefault("database.auto_migrate", true)
 
 	v.SetDefault("storage.data_dir", "./data")
+	v.SetDefault("storage.case_insensitive", CaseAuto)
 
 	v.SetDefault("logging.enabled", true)
 	v.SetDefault("logging.default_module", "distroface-app")
@@ -339,6 +479,23 @@ func applyDerivedPaths(cfg *Config) {
 }
 
 func validateConfig(cfg *Config) error {
+	switch cfg.Server.HTTP2.H2C {
+	case H2COn, H2CTrusted, H2COff:
+	default:
+		return fmt.Errorf("invalid server.http2.h2c %q, want on, trusted or off", cfg.Server.HTTP2.H2C)
+	}
+	switch cfg.Storage.CaseInsensitive {
+	case CaseAuto, CaseOn, CaseOff:
+	default:
+		return fmt.Errorf("invalid storage.case_insensitive %q, want auto, on or off", cfg.Storage.CaseInsensitive)
+	}
+	if cfg.Server.MaxConnsPerIP < 0 || cfg.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server connection limits cannot be negative")
+	}
+	if cfg.Server.Compression.MinSize < 0 {
+		return fmt.Errorf("server.compression.min_size cannot be negative")
+	}
+
 	var err error
 	cfg.Database.Path, err = filepath.Abs(cfg.Database.Path)
 	if err != nil {
@@ -359,6 +516,15 @@ func validateConfig(cfg *Config) error {
 	if err != nil {
 		return fmt.Errorf("invalid artifact storage path: %w", err)
 	}
+	if cfg.Artifacts.ColdStoragePath != "" {
+		cfg.Artifacts.ColdStoragePath, err = filepath.Abs(cfg.Artifacts.ColdStoragePath)
+		if err != nil {
+			return fmt.Errorf("invalid artifact cold storage path: %w", err)
+		}
+		if cfg.Artifacts.ColdStoragePath == cfg.Artifacts.StoragePath {
+			return fmt.Errorf("artifacts.cold_storage_path must differ from artifacts.storage_path")
+		}
+	}
 
 	cfg.Logging.Dir, err = filepath.Abs(cfg.Logging.Dir)
 	if err != nil {
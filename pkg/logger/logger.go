@@ -8,12 +8,46 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pkgcfg "github.com/nickheyer/distroface/pkg/config"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// Level orders verbosity, the zero value logs everything
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (lv Level) String() string {
+	if lv >= 0 && int(lv) < len(levelNames) {
+		return levelNames[lv]
+	}
+	return fmt.Sprintf("level(%d)", int32(lv))
+}
+
+// ParseLevel accepts debug, info, warn or error in any case
+func ParseLevel(s string) (Level, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if name == "warning" {
+		name = "warn"
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", s)
+}
+
 type Logger struct {
 	*log.Logger
 	module     string
@@ -23,6 +57,8 @@ type Logger struct {
 	maxBuffer  int
 	config     *Config
 	children   []*Logger
+	scoped     map[string]*Logger
+	level      atomic.Int32
 }
 
 type Config struct {
@@ -107,7 +143,45 @@ func (l *Logger) Module(name string) *Logger {
 	return child
 }
 
-func (l *Logger) log(level, format string, args ...any) {
+// Scoped returns a child that shares this logger's writers but tags lines
+// with name and filters on its own level. Repeated names get the same child
+func (l *Logger) Scoped(name string) *Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if child, ok := l.scoped[name]; ok {
+		return child
+	}
+	child := &Logger{
+		Logger:    l.Logger,
+		module:    name,
+		buffer:    make([]string, 0, 1000),
+		maxBuffer: 1000,
+	}
+	child.level.Store(l.level.Load())
+	if l.scoped == nil {
+		l.scoped = map[string]*Logger{}
+	}
+	l.scoped[name] = child
+	return child
+}
+
+// SetLevel drops lines below lv, safe while logging
+func (l *Logger) SetLevel(lv Level) {
+	l.level.Store(int32(lv))
+}
+
+func (l *Logger) GetLevel() Level {
+	return Level(l.level.Load())
+}
+
+func (l *Logger) log(lv Level, format string, args ...any) {
+	if lv < Level(l.level.Load()) {
+		return
+	}
+	l.write(strings.ToUpper(lv.String()), format, args...)
+}
+
+func (l *Logger) write(level, format string, args ...any) {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 	message := fmt.Sprintf(format, args...)
 	logLine := fmt.Sprintf("[%s] [%s] %s: %s", timestamp, l.module, level, message)
@@ -123,23 +197,24 @@ func (l *Logger) log(level, format string, args ...any) {
 }
 
 func (l *Logger) Info(format string, args ...any) {
-	l.log("INFO", format, args...)
+	l.log(LevelInfo, format, args...)
 }
 
 func (l *Logger) Error(format string, args ...any) {
-	l.log("ERROR", format, args...)
+	l.log(LevelError, format, args...)
 }
 
 func (l *Logger) Warn(format string, args ...any) {
-	l.log("WARN", format, args...)
+	l.log(LevelWarn, format, args...)
 }
 
 func (l *Logger) Debug(format string, args ...any) {
-	l.log("DEBUG", format, args...)
+	l.log(LevelDebug, format, args...)
 }
 
+// Fatal always logs regardless of level
 func (l *Logger) Fatal(format string, args ...any) {
-	l.log("FATAL", format, args...)
+	l.write("FATAL", format, args...)
 	os.Exit(1)
 }
 
@@ -168,11 +243,12 @@ func (l *Logger) Close() error {
 	return nil
 }
 
-// Write implements io.Writer, allowing the Logger to be used as an output
+// Write implements io.Writer, allowing the Logger to be used as an output.
+// Writers such as logrus filter by their own level, so lines always land
 func (l *Logger) Write(p []byte) (int, error) {
 	msg := strings.TrimRight(string(p), "\n")
 	if msg != "" {
-		l.Info("%s", msg)
+		l.write("INFO", "%s", msg)
 	}
 	return len(p), nil
 }
@@ -2,15 +2,19 @@ package migrate
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"sort"
 	"sync"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	ggcrv1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/nickheyer/distroface/pkg/config"
 	"github.com/nickheyer/distroface/pkg/logger"
@@ -56,22 +60,48 @@ type TagResult struct {
 	Digest string
 	Status TagStatus
 	Err    error
+	// Retries per blob or manifest digest, only those that needed any
+	Retries map[string]int
+}
+
+func (t *TagResult) countRetries(digest string, n int) {
+	if n == 0 {
+		return
+	}
+	if t.Retries == nil {
+		t.Retries = map[string]int{}
+	}
+	t.Retries[digest] += n
 }
 
 type Replayer struct {
 	cfg  *config.MigrateConfig
 	v1   *V1Storage
 	opts []remote.Option
+	// Chunked uploads make their own requests with these
+	auth authn.Authenticator
+	base http.RoundTripper
+	// Per layer record, in memory unless CmdImages loaded one
+	state *State
 }
 
 func NewReplayer(ctx context.Context, cfg *config.MigrateConfig, v1s *V1Storage) *Replayer {
+	auth := &authn.Basic{Username: cfg.User, Password: cfg.Pass}
+	base := hintTransport{base: remote.DefaultTransport}
+	state, _ := LoadState("")
 	return &Replayer{
-		cfg: cfg,
-		v1:  v1s,
+		cfg:   cfg,
+		v1:    v1s,
+		auth:  auth,
+		base:  base,
+		state: state,
 		opts: []remote.Option{
-			remote.WithAuth(&authn.Basic{Username: cfg.User, Password: cfg.Pass}),
+			remote.WithAuth(auth),
 			remote.WithContext(ctx),
 			remote.WithUserAgent("migrate"),
+			remote.WithTransport(base),
+			// Retries happen per operation in retry, which honors Retry-After
+			remote.WithRetryBackoff(remote.Backoff{Steps: 1}),
 		},
 	}
 }
@@ -97,7 +127,7 @@ func (r *Replayer) PushRepo(ctx context.Context, v1Name string) ([]TagResult, er
 	}
 
 	// Tags often share digests; ensure each manifest tree only once per repo.
-	ensured := make(map[string]rawManifest)
+	ensured := make(map[string]ensuredTree)
 
 	var results []TagResult
 	for _, tag := range tags {
@@ -112,71 +142,107 @@ func (r *Replayer) PushRepo(ctx context.Context, v1Name string) ([]TagResult, er
 			continue
 		}
 
+		img := r.state.image(mapped, tag, res.Digest)
 		tagRef := repo.Tag(tag)
-		if head, err := remote.Head(tagRef, r.opts...); err == nil && head.Digest.String() == res.Digest {
+		var head *ggcrv1.Descriptor
+		n, err := r.retry(ctx, func(opts []remote.Option) (err error) {
+			head, err = remote.Head(tagRef, opts...)
+			return err
+		})
+		res.countRetries(res.Digest, n)
+		if err == nil && head.Digest.String() == res.Digest {
 			res.Status = TagUpToDate
+			r.state.finishImage(img, res.Status, nil)
 			results = append(results, res)
 			continue
 		}
+		r.state.update(func() { img.Status, img.Error = "", "" })
 
-		manifest, err := r.ensureTree(ctx, repo, v1Name, res.Digest, ensured)
+		tree, err := r.ensureTree(ctx, repo, v1Name, res.Digest, ensured, img, &res)
 		if err != nil {
 			res.Status, res.Err = TagFailed, err
+			r.state.finishImage(img, res.Status, res.Err)
 			results = append(results, res)
 			continue
 		}
-		if err := remote.Put(tagRef, manifest, r.opts...); err != nil {
+		n, err = r.retry(ctx, func(opts []remote.Option) error {
+			return remote.Put(tagRef, tree.manifest, opts...)
+		})
+		res.countRetries(res.Digest, n)
+		if err != nil {
 			res.Status, res.Err = TagFailed, fmt.Errorf("put manifest: %w", err)
+			if blobUnknown(err) {
+				// Recorded layers are gone again, likely collected meanwhile
+				r.state.resetLayers(img)
+			}
+			r.state.finishImage(img, res.Status, res.Err)
 			results = append(results, res)
 			continue
 		}
 		res.Status = TagPushed
+		r.state.finishImage(img, res.Status, nil)
 		results = append(results, res)
 		logger.Logv(r.cfg, "  pushed %s:%s (%s)", mapped, tag, res.Digest)
 	}
 	return results, nil
 }
 
+// Manifest ready to Put and the blob records behind it
+type ensuredTree struct {
+	manifest rawManifest
+	layers   []*LayerState
+}
+
 // ensureTree uploads everything a manifest needs (blobs, child manifests for
 // indexes) and returns the manifest ready to Put. Children are Put by digest;
-// the caller Puts the root at its tag.
-func (r *Replayer) ensureTree(ctx context.Context, repo name.Repository, v1Name, digest string, ensured map[string]rawManifest) (rawManifest, error) {
-	if m, ok := ensured[digest]; ok {
-		return m, nil
+// the caller Puts the root at its tag. Blobs are recorded against img and
+// retries counted against res.
+func (r *Replayer) ensureTree(ctx context.Context, repo name.Repository, v1Name, digest string, ensured map[string]ensuredTree, img *ImageState, res *TagResult) (ensuredTree, error) {
+	if t, ok := ensured[digest]; ok {
+		for _, ls := range t.layers {
+			r.state.layer(img, ls.Digest, ls.Size)
+		}
+		return t, nil
 	}
 
 	raw, err := r.v1.ManifestBytes(v1Name, digest)
 	if err != nil {
-		return rawManifest{}, fmt.Errorf("manifest %s: %w", digest, err)
+		return ensuredTree{}, fmt.Errorf("manifest %s: %w", digest, err)
 	}
 	m, err := ParseV1Manifest(raw)
 	if err != nil {
-		return rawManifest{}, fmt.Errorf("manifest %s: parse: %w", digest, err)
+		return ensuredTree{}, fmt.Errorf("manifest %s: parse: %w", digest, err)
 	}
 	if m.IsSchema1() {
-		return rawManifest{}, fmt.Errorf("manifest %s is docker schema1, which distribution v3 rejects (re-push from a modern client or skip)", digest)
+		return ensuredTree{}, fmt.Errorf("manifest %s is docker schema1, which distribution v3 rejects (re-push from a modern client or skip)", digest)
 	}
 
+	var layers []*LayerState
 	if m.IsIndex() {
 		for _, child := range m.Manifests {
-			childManifest, err := r.ensureTree(ctx, repo, v1Name, child.Digest, ensured)
+			childTree, err := r.ensureTree(ctx, repo, v1Name, child.Digest, ensured, img, res)
 			if err != nil {
-				return rawManifest{}, fmt.Errorf("index child %s: %w", child.Digest, err)
+				return ensuredTree{}, fmt.Errorf("index child %s: %w", child.Digest, err)
 			}
+			layers = append(layers, childTree.layers...)
 			childDigest := repo.Digest(child.Digest)
-			if err := remote.Put(childDigest, childManifest, r.opts...); err != nil {
-				return rawManifest{}, fmt.Errorf("put index child %s: %w", child.Digest, err)
+			n, err := r.retry(ctx, func(opts []remote.Option) error {
+				return remote.Put(childDigest, childTree.manifest, opts...)
+			})
+			res.countRetries(child.Digest, n)
+			if err != nil {
+				return ensuredTree{}, fmt.Errorf("put index child %s: %w", child.Digest, err)
 			}
 		}
 	} else {
 		for _, blob := range m.Blobs() {
 			size, err := r.v1.StatBlob(blob.Digest)
 			if err != nil {
-				return rawManifest{}, fmt.Errorf("blob %s missing in v1 storage: %w", blob.Digest, err)
+				return ensuredTree{}, fmt.Errorf("blob %s missing in v1 storage: %w", blob.Digest, err)
 			}
 			hash, err := ggcrv1.NewHash(blob.Digest)
 			if err != nil {
-				return rawManifest{}, fmt.Errorf("blob %s: %w", blob.Digest, err)
+				return ensuredTree{}, fmt.Errorf("blob %s: %w", blob.Digest, err)
 			}
 			layer := &fileLayer{
 				path:   r.v1.BlobPath(blob.Digest),
@@ -184,14 +250,18 @@ func (r *Replayer) ensureTree(ctx context.Context, repo name.Repository, v1Name,
 				size:   size,
 				mt:     types.MediaType(blob.MediaType),
 			}
-			// remote.WriteLayer HEADs the blob first and skips if present (dedup).
-			if err := remote.WriteLayer(repo, layer, r.opts...); err != nil {
-				return rawManifest{}, fmt.Errorf("upload blob %s: %w", blob.Digest, err)
+			ls := r.state.layer(img, blob.Digest, size)
+			layers = append(layers, ls)
+			if err := r.sendLayer(ctx, repo, layer, ls, res); err != nil {
+				return ensuredTree{}, fmt.Errorf("upload blob %s: %w", blob.Digest, err)
 			}
 		}
 	}
 
-	result := rawManifest{raw: raw, mt: types.MediaType(m.EffectiveMediaType())}
+	result := ensuredTree{
+		manifest: rawManifest{raw: raw, mt: types.MediaType(m.EffectiveMediaType())},
+		layers:   layers,
+	}
 	ensured[digest] = result
 	return result, nil
 }
@@ -265,6 +335,9 @@ func CmdImages(ctx context.Context, cfg *config.MigrateConfig) error {
 	}
 
 	replayer := NewReplayer(ctx, cfg, v1s)
+	if replayer.state, err = LoadState(cfg.State); err != nil {
+		return err
+	}
 	results := replayRepos(ctx, cfg, replayer, repos)
 
 	// Post-pass: apply v1 privacy to the auto-created repo rows.
@@ -352,9 +425,16 @@ func dryRunImages(cfg *config.MigrateConfig, v1s *V1Storage, repos []string, pri
 	return nil
 }
 
+// Digests that needed the most retries are listed, the rest are summed
+const maxRetryReport = 10
+
 func summarizeResults(results []TagResult) error {
 	var pushed, upToDate, failed int
+	retries := map[string]int{}
 	for _, r := range results {
+		for digest, n := range r.Retries {
+			retries[digest] += n
+		}
 		switch r.Status {
 		case TagPushed:
 			pushed++
@@ -370,12 +450,38 @@ func summarizeResults(results []TagResult) error {
 		}
 	}
 	fmt.Printf("\nreplay complete: %d pushed, %d already up-to-date, %d failed\n", pushed, upToDate, failed)
+	if len(retries) > 0 {
+		digests := sortedStringKeys(retries)
+		sort.SliceStable(digests, func(i, j int) bool { return retries[digests[i]] > retries[digests[j]] })
+		total := 0
+		for _, n := range retries {
+			total += n
+		}
+		fmt.Printf("%d transient registry failure(s) retried across %d blob(s)/manifest(s)\n", total, len(retries))
+		for _, digest := range digests[:min(len(digests), maxRetryReport)] {
+			fmt.Printf("  %-75s %d retry(s)\n", digest, retries[digest])
+		}
+	}
 	if failed > 0 {
 		return fmt.Errorf("%d tag(s) failed to replay", failed)
 	}
 	return nil
 }
 
+// Manifest refused for referencing blobs the registry lacks
+func blobUnknown(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	for _, d := range terr.Errors {
+		if d.Code == transport.BlobUnknownErrorCode || d.Code == transport.ManifestBlobUnknownErrorCode {
+			return true
+		}
+	}
+	return false
+}
+
 func anyPrivate(m map[string]bool) bool {
 	for _, private := range m {
 		if private {
@@ -0,0 +1,140 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// First backoff step, doubled per attempt up to retryMaxDelay
+var retryBase = time.Second
+
+const (
+	retryMaxDelay = 30 * time.Second
+	// Longest Retry-After honored, a server asking for more is capped
+	retryAfterCap = 2 * time.Minute
+)
+
+// Server requested delay from the last throttled response of one operation
+type retryHint struct {
+	mu    sync.Mutex
+	after time.Duration
+}
+
+type retryHintKey struct{}
+
+// Records Retry-After on throttled responses for the operation that sent them
+type hintTransport struct {
+	base http.RoundTripper
+}
+
+func (t hintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return resp, err
+	}
+	if hint, ok := req.Context().Value(retryHintKey{}).(*retryHint); ok {
+		if after, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			hint.mu.Lock()
+			hint.after = after
+			hint.mu.Unlock()
+		}
+	}
+	return resp, nil
+}
+
+// Delta seconds or an HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// Throttling, server side failures and dropped connections are worth
+// another attempt, anything else the registry said is final
+func retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		switch terr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
+
+// Exponential with jitter, a longer Retry-After wins
+func retryDelay(attempt int, hint time.Duration) time.Duration {
+	d := min(retryBase<<(attempt-1), retryMaxDelay)
+	d += time.Duration(rand.Int64N(int64(d) / 4))
+	if hint > d {
+		d = min(hint, retryAfterCap)
+	}
+	return d
+}
+
+// Runs one registry operation, retrying transient failures up to the
+// configured count. op gets options scoped to this attempt, returns how
+// many retries it took.
+func (r *Replayer) retry(ctx context.Context, op func(opts []remote.Option) error) (int, error) {
+	return r.retryCtx(ctx, func(_ context.Context, opts []remote.Option) error { return op(opts) })
+}
+
+// retry for operations making their own requests, which must carry the
+// attempt context for Retry-After to count
+func (r *Replayer) retryCtx(ctx context.Context, op func(opCtx context.Context, opts []remote.Option) error) (int, error) {
+	hint := &retryHint{}
+	opCtx := context.WithValue(ctx, retryHintKey{}, hint)
+	opts := append(append([]remote.Option{}, r.opts...), remote.WithContext(opCtx))
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			hint.mu.Lock()
+			after := hint.after
+			hint.after = 0
+			hint.mu.Unlock()
+			t := time.NewTimer(retryDelay(attempt, after))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return attempt - 1, ctx.Err()
+			case <-t.C:
+			}
+		}
+		if err = op(opCtx, opts); err == nil || attempt >= r.cfg.Retries || !retryable(err) {
+			return attempt, err
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/nickheyer/distroface/pkg/config"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"7", 7 * time.Second, true},
+		{"-1", 0, false},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{"soon", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseRetryAfter(c.in, now)
+		if got != c.want || ok != c.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestReplayerRetriesThrottledCalls(t *testing.T) {
+	retryBase = time.Millisecond
+	t.Cleanup(func() { retryBase = time.Second })
+
+	var heads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case strings.HasSuffix(r.URL.Path, "/manifests/flaky"):
+			if heads.Add(1) <= 2 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Header().Set("Docker-Content-Digest", "sha256:"+strings.Repeat("a", 64))
+			w.Header().Set("Content-Length", "2")
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &config.MigrateConfig{Registry: strings.TrimPrefix(srv.URL, "http://"), PlainHTTP: true, Retries: 3}
+	r := NewReplayer(context.Background(), cfg, nil)
+	repo, err := r.repoRef("legacy/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := r.retry(context.Background(), func(opts []remote.Option) error {
+		_, err := remote.Head(repo.Tag("flaky"), opts...)
+		return err
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("flaky head: retries=%d err=%v, want 2 retries and success", n, err)
+	}
+
+	n, err = r.retry(context.Background(), func(opts []remote.Option) error {
+		_, err := remote.Head(repo.Tag("missing"), opts...)
+		return err
+	})
+	if err == nil || n != 0 {
+		t.Fatalf("missing head: retries=%d err=%v, want an immediate 404", n, err)
+	}
+}
@@ -0,0 +1,243 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LayerStatus string
+
+const (
+	LayerPending LayerStatus = "pending"
+	LayerSending LayerStatus = "sending"
+	LayerDone    LayerStatus = "done"
+	LayerFailed  LayerStatus = "failed"
+)
+
+// One blob of one v2 repo. Sent is what the registry confirmed, Upload the
+// open session a rerun resumes instead of sending the blob again
+type LayerState struct {
+	Digest string      `json:"digest"`
+	Size   int64       `json:"size"`
+	Sent   int64       `json:"sent"`
+	Status LayerStatus `json:"status"`
+	Upload string      `json:"upload,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// One tag and the blobs its manifest tree needs, in manifest order
+type ImageState struct {
+	Repo    string    `json:"repo"` // v2 name
+	Tag     string    `json:"tag"`
+	Digest  string    `json:"digest"`
+	Status  TagStatus `json:"status,omitempty"` // Empty until the tag finished or failed
+	Error   string    `json:"error,omitempty"`
+	Layers  []string  `json:"layers"`
+	Updated time.Time `json:"updated"`
+}
+
+// Task record of an image replay, saved after every change so an
+// interrupted or failed run picks up where it stopped. A state without
+// a path only lives in memory
+type State struct {
+	mu     sync.Mutex
+	path   string
+	Images map[string]*ImageState `json:"images"` // repo:tag
+	Layers map[string]*LayerState `json:"layers"` // repo@digest
+}
+
+// Missing files start an empty record
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, Images: map[string]*ImageState{}, Layers: map[string]*LayerState{}}
+	if path == "" {
+		return s, nil
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return nil, fmt.Errorf("state %s: %w", path, err)
+	}
+	if s.Images == nil {
+		s.Images = map[string]*ImageState{}
+	}
+	if s.Layers == nil {
+		s.Layers = map[string]*LayerState{}
+	}
+	return s, nil
+}
+
+// Written through a temp sibling so a crash never leaves half a record
+func (s *State) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", s.path, os.Getpid())
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Applies fn under the lock and saves. A failed save only costs resuming
+func (s *State) update(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+	if err := s.saveLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "WARN: saving migration state: %v\n", err)
+	}
+}
+
+// Record of a tag, reset when the tag now points somewhere else
+func (s *State) image(repo, tag, digest string) *ImageState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := repo + ":" + tag
+	img := s.Images[key]
+	if img == nil || img.Digest != digest {
+		img = &ImageState{Repo: repo, Tag: tag, Digest: digest}
+		s.Images[key] = img
+	}
+	return img
+}
+
+// Record of a blob in a repo, attached to the image that needs it
+func (s *State) layer(img *ImageState, digest string, size int64) *LayerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := img.Repo + "@" + digest
+	ls := s.Layers[key]
+	if ls == nil || ls.Size != size {
+		ls = &LayerState{Digest: digest, Size: size, Status: LayerPending}
+		s.Layers[key] = ls
+	}
+	for _, d := range img.Layers {
+		if d == digest {
+			return ls
+		}
+	}
+	img.Layers = append(img.Layers, digest)
+	return ls
+}
+
+// Snapshot of a layer for reading outside the lock
+func (s *State) layerCopy(ls *LayerState) LayerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *ls
+}
+
+func (s *State) finishImage(img *ImageState, status TagStatus, err error) {
+	s.update(func() {
+		img.Status, img.Error, img.Updated = status, "", time.Now().UTC()
+		if err != nil {
+			img.Error = err.Error()
+		}
+	})
+}
+
+// Forgets finished blobs of an image the registry no longer holds, so
+// the next run sends them again
+func (s *State) resetLayers(img *ImageState) {
+	s.update(func() {
+		for _, d := range img.Layers {
+			if ls := s.Layers[img.Repo+"@"+d]; ls != nil && ls.Status == LayerDone {
+				ls.Status, ls.Sent = LayerPending, 0
+			}
+		}
+	})
+}
+
+// Layer totals of one image: finished count, confirmed bytes and size
+func (s *State) progress(img *ImageState) (done, total int, sent, size int64) {
+	for _, d := range img.Layers {
+		ls := s.Layers[img.Repo+"@"+d]
+		if ls == nil {
+			continue
+		}
+		total++
+		size += ls.Size
+		sent += ls.Sent
+		if ls.Status == LayerDone {
+			done++
+		}
+	}
+	return done, total, sent, size
+}
+
+func percent(part, whole int64) float64 {
+	if whole <= 0 {
+		return 100
+	}
+	return float64(part) * 100 / float64(whole)
+}
+
+// Prints the record image by image with every unfinished layer
+func CmdStatus(path string) error {
+	if path == "" {
+		return fmt.Errorf("-state is required")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no migration state at %s: %w", path, err)
+	}
+	s, err := LoadState(path)
+	if err != nil {
+		return err
+	}
+	abs, _ := filepath.Abs(path)
+	fmt.Printf("migration state %s: %d image(s)\n\n", abs, len(s.Images))
+
+	keys := make([]string, 0, len(s.Images))
+	for k := range s.Images {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var finished, failed int
+	for _, k := range keys {
+		img := s.Images[k]
+		done, total, sent, size := s.progress(img)
+		status := string(img.Status)
+		switch img.Status {
+		case TagPushed, TagUpToDate:
+			finished++
+		case TagFailed:
+			failed++
+		case "":
+			status = "in progress"
+		}
+		fmt.Printf("%-60s %-12s %d/%d layer(s) %s / %s (%.0f%%)\n",
+			k, status, done, total, humanBytes(sent), humanBytes(size), percent(sent, size))
+		if img.Error != "" {
+			fmt.Printf("    error: %s\n", img.Error)
+		}
+		for _, d := range img.Layers {
+			ls := s.Layers[img.Repo+"@"+d]
+			if ls == nil || ls.Status == LayerDone {
+				continue
+			}
+			fmt.Printf("    %-73s %-8s %s / %s\n", ls.Digest, ls.Status, humanBytes(ls.Sent), humanBytes(ls.Size))
+			if ls.Error != "" && !strings.Contains(img.Error, ls.Error) {
+				fmt.Printf("      %s\n", ls.Error)
+			}
+		}
+	}
+	fmt.Printf("\n%d finished, %d failed, %d unfinished\n", finished, failed, len(keys)-finished-failed)
+	return nil
+}
@@ -0,0 +1,201 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/nickheyer/distroface/pkg/logger"
+)
+
+// Blobs above this go up in chunks of it, each one recorded so a failure
+// costs at most a chunk instead of the whole blob
+var layerChunkSize int64 = 64 << 20
+
+// Sends one blob, skipping it when the record says it landed. Small blobs
+// go up whole, large ones in chunks that resume from what the registry
+// confirmed
+func (r *Replayer) sendLayer(ctx context.Context, repo name.Repository, layer *fileLayer, ls *LayerState, res *TagResult) error {
+	cur := r.state.layerCopy(ls)
+	if cur.Status == LayerDone {
+		return nil
+	}
+	digest := layer.digest.String()
+	if cur.Upload != "" {
+		fmt.Printf("  resuming %s %s at %s of %s\n", repo.RepositoryStr(), digest, humanBytes(cur.Sent), humanBytes(cur.Size))
+	}
+	r.state.update(func() { ls.Status, ls.Error = LayerSending, "" })
+
+	var n int
+	var err error
+	if layer.size <= layerChunkSize && cur.Upload == "" {
+		// remote.WriteLayer HEADs the blob first and skips if present (dedup)
+		n, err = r.retry(ctx, func(opts []remote.Option) error {
+			return remote.WriteLayer(repo, layer, opts...)
+		})
+	} else {
+		n, err = r.retryCtx(ctx, func(opCtx context.Context, _ []remote.Option) error {
+			return r.sendChunked(opCtx, repo, layer, ls)
+		})
+	}
+	res.countRetries(digest, n)
+	if err != nil {
+		r.state.update(func() { ls.Status, ls.Error = LayerFailed, err.Error() })
+		return err
+	}
+	r.state.update(func() { ls.Status, ls.Sent, ls.Upload, ls.Error = LayerDone, ls.Size, "", "" })
+	return nil
+}
+
+// Chunked upload per the distribution spec. A recorded session is asked
+// how far it got, an expired one starts over
+func (r *Replayer) sendChunked(ctx context.Context, repo name.Repository, layer *fileLayer, ls *LayerState) error {
+	tr, err := transport.NewWithContext(ctx, repo.Registry, r.auth, r.base, []string{repo.Scope(transport.PushScope)})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: tr}
+	base := &url.URL{Scheme: repo.Registry.Scheme(), Host: repo.RegistryStr()}
+	blobs := base.JoinPath("v2", repo.RepositoryStr(), "blobs")
+	digest := layer.digest.String()
+
+	cur := r.state.layerCopy(ls)
+	loc, sent := cur.Upload, int64(0)
+	if loc != "" {
+		if loc, sent, err = uploadStatus(ctx, client, loc); err != nil {
+			return err
+		}
+	}
+	if loc == "" {
+		resp, err := doUpload(ctx, client, http.MethodHead, blobs.JoinPath(digest).String(), nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		resp, err = doUpload(ctx, client, http.MethodPost, blobs.JoinPath("uploads").String()+"/", nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := transport.CheckError(resp, http.StatusAccepted); err != nil {
+			return err
+		}
+		if loc, err = location(resp); err != nil {
+			return err
+		}
+		sent = 0
+	}
+	r.state.update(func() { ls.Upload, ls.Sent = loc, sent })
+
+	f, err := os.Open(layer.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for sent < layer.size {
+		n := min(layerChunkSize, layer.size-sent)
+		header := http.Header{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {fmt.Sprintf("%d-%d", sent, sent+n-1)},
+		}
+		resp, err := doUpload(ctx, client, http.MethodPatch, loc, io.NewSectionReader(f, sent, n), n, header)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if err := transport.CheckError(resp, http.StatusAccepted); err != nil {
+			return err
+		}
+		if loc, err = location(resp); err != nil {
+			return err
+		}
+		sent += n
+		r.state.update(func() { ls.Upload, ls.Sent = loc, sent })
+		logger.Logv(r.cfg, "  %s %s %s / %s (%.0f%%)", repo.RepositoryStr(), digest, humanBytes(sent), humanBytes(layer.size), percent(sent, layer.size))
+	}
+
+	done, err := url.Parse(loc)
+	if err != nil {
+		return err
+	}
+	q := done.Query()
+	q.Set("digest", digest)
+	done.RawQuery = q.Encode()
+	resp, err := doUpload(ctx, client, http.MethodPut, done.String(), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return transport.CheckError(resp, http.StatusCreated)
+}
+
+// Session location and the bytes it holds. Empty when the registry
+// dropped the session
+func uploadStatus(ctx context.Context, client *http.Client, loc string) (string, int64, error) {
+	resp, err := doUpload(ctx, client, http.MethodGet, loc, nil, 0, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", 0, nil
+	}
+	if err := transport.CheckError(resp, http.StatusNoContent); err != nil {
+		return "", 0, err
+	}
+	if next, err := location(resp); err == nil {
+		loc = next
+	}
+	sent, _ := parseUploadRange(resp.Header.Get("Range"))
+	// Distribution says 0-0 for an empty session too, and chunks are never
+	// a single byte
+	if sent == 1 {
+		sent = 0
+	}
+	return loc, sent, nil
+}
+
+func doUpload(ctx context.Context, client *http.Client, method, target string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = size
+	return client.Do(req)
+}
+
+// Absolute upload location, registries may answer with a relative one
+func location(resp *http.Response) (string, error) {
+	loc, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("upload response without a location: %w", err)
+	}
+	return loc.String(), nil
+}
+
+// Bytes held per a "0-N" Range header, distribution leaves off the unit
+func parseUploadRange(v string) (int64, bool) {
+	_, end, ok := strings.Cut(strings.TrimPrefix(v, "bytes="), "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n + 1, true
+}
@@ -0,0 +1,167 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	ggcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/nickheyer/distroface/pkg/config"
+)
+
+// Just enough of the distribution upload api to drop a chunk on request
+type chunkRegistry struct {
+	mu      sync.Mutex
+	upload  []byte
+	blobs   map[string][]byte
+	patched int64 // Bytes accepted over every PATCH
+	patches int
+	dropAt  int // PATCH answered with a 502, zero drops none
+}
+
+func (c *chunkRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	const session = "/v2/legacy/app/blobs/uploads/u1"
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodHead:
+		if _, ok := c.blobs[filepath.Base(r.URL.Path)]; ok {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPost:
+		c.upload = nil
+		w.Header().Set("Location", session)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodGet && r.URL.Path == session:
+		w.Header().Set("Location", session)
+		w.Header().Set("Range", fmt.Sprintf("0-%d", max(len(c.upload)-1, 0)))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPatch:
+		body, _ := io.ReadAll(r.Body)
+		if c.patches++; c.patches == c.dropAt {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if want := fmt.Sprintf("%d-%d", len(c.upload), len(c.upload)+len(body)-1); r.Header.Get("Content-Range") != want {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		c.upload = append(c.upload, body...)
+		c.patched += int64(len(body))
+		w.Header().Set("Location", session)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut:
+		sum := sha256.Sum256(c.upload)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		if r.URL.Query().Get("digest") != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.blobs[digest] = c.upload
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSendLayerResumesChunks(t *testing.T) {
+	layerChunkSize = 4
+	t.Cleanup(func() { layerChunkSize = 64 << 20 })
+
+	reg := &chunkRegistry{blobs: map[string][]byte{}, dropAt: 2}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	content := []byte("0123456789")
+	blobPath := filepath.Join(dir, "blob")
+	if err := os.WriteFile(blobPath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	hash, _ := ggcrv1.NewHash("sha256:" + hex.EncodeToString(sum[:]))
+	layer := &fileLayer{path: blobPath, digest: hash, size: int64(len(content))}
+
+	statePath := filepath.Join(dir, "state.json")
+	cfg := &config.MigrateConfig{Registry: strings.TrimPrefix(srv.URL, "http://"), PlainHTTP: true, State: statePath}
+	run := func() (*ImageState, *LayerState, error) {
+		r := NewReplayer(context.Background(), cfg, nil)
+		var err error
+		if r.state, err = LoadState(statePath); err != nil {
+			t.Fatal(err)
+		}
+		repo, err := r.repoRef("legacy/app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := r.state.image("legacy/app", "v1", "sha256:"+strings.Repeat("a", 64))
+		ls := r.state.layer(img, hash.String(), layer.size)
+		return img, ls, r.sendLayer(context.Background(), repo, layer, ls, &TagResult{})
+	}
+
+	// Second chunk drops with retries off, the first stays recorded
+	_, ls, err := run()
+	if err == nil {
+		t.Fatal("dropped chunk did not fail the layer")
+	}
+	if ls.Status != LayerFailed || ls.Sent != 4 || ls.Upload == "" {
+		t.Fatalf("after failure: %+v, want failed at 4 bytes with a session", ls)
+	}
+
+	// A fresh run resumes the session instead of sending the blob again
+	img, ls, err := run()
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if ls.Status != LayerDone || ls.Sent != layer.size || ls.Upload != "" {
+		t.Fatalf("after resume: %+v, want done", ls)
+	}
+	if reg.patched != layer.size {
+		t.Fatalf("registry received %d bytes, want %d without resending", reg.patched, layer.size)
+	}
+	if _, ok := reg.blobs[hash.String()]; !ok {
+		t.Fatal("blob never committed")
+	}
+	s, _ := LoadState(statePath)
+	if done, total, sent, size := s.progress(s.Images["legacy/app:v1"]); done != 1 || total != 1 || sent != size {
+		t.Fatalf("saved progress %d/%d layers %d/%d bytes, image %+v", done, total, sent, size, img)
+	}
+
+	// Recorded layers are skipped without asking the registry
+	before := reg.patched
+	if _, _, err := run(); err != nil || reg.patched != before {
+		t.Fatalf("finished layer sent again: %v, %d bytes", err, reg.patched-before)
+	}
+}
+
+func TestParseUploadRange(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0-1023", 1024, true},
+		{"bytes=0-9", 10, true},
+		{"", 0, false},
+		{"0-x", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseUploadRange(c.in)
+		if got != c.want || ok != c.ok {
+			t.Errorf("parseUploadRange(%q) = %d, %v, want %d, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	ggcrv1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/nickheyer/distroface/internal/artifacts"
 	"github.com/nickheyer/distroface/internal/db/stores"
@@ -68,7 +69,11 @@ func CmdVerify(ctx context.Context, cfg *config.MigrateConfig) error {
 		}
 
 		remoteTags := map[string]bool{}
-		if listed, err := remote.List(repoRef, replayer.opts...); err == nil {
+		var listed []string
+		if _, err := replayer.retry(ctx, func(opts []remote.Option) (err error) {
+			listed, err = remote.List(repoRef, opts...)
+			return err
+		}); err == nil {
 			for _, t := range listed {
 				remoteTags[t] = true
 			}
@@ -81,7 +86,11 @@ func CmdVerify(ctx context.Context, cfg *config.MigrateConfig) error {
 				missing++
 				continue
 			}
-			head, err := remote.Head(repoRef.Tag(tag), replayer.opts...)
+			var head *ggcrv1.Descriptor
+			_, err = replayer.retry(ctx, func(opts []remote.Option) (err error) {
+				head, err = remote.Head(repoRef.Tag(tag), opts...)
+				return err
+			})
 			switch {
 			case err != nil:
 				fmt.Printf("MISSING  %s:%s (want %s)\n", mapped, tag, v1Digest)
@@ -68,11 +68,12 @@ func ResolveDescriptor(ctx context.Context, repo distribution.Repository, dgst d
 func ResolveManifest(ctx context.Context, repo distribution.Repository, dgst digest.Digest, manifest distribution.Manifest, blobStore distribution.BlobStore) (*v1.Descriptor, error) {
 	mt, _, _ := manifest.Payload()
 	refs := manifest.References()
+	manifestService, _ := repo.Manifests(ctx)
 
 	desc := &v1.Descriptor{
 		Digest:    dgst.String(),
 		MediaType: mt,
-		SizeBytes: ComputeManifestSize(manifest),
+		SizeBytes: ComputeImageSize(ctx, manifestService, manifest),
 	}
 	if ann := ManifestAnnotations(manifest); len(ann) > 0 {
 		desc.Annotations = ann
@@ -151,18 +152,50 @@ func IsConfigMediaType(mediaType string) bool {
 	return false
 }
 
-func ComputeManifestSize(manifest distribution.Manifest) int64 {
+// Bytes a manifest tree holds: the manifest, its blobs and, for indexes,
+// every child manifest's tree. Layers shared between platforms count once,
+// children that can't be read count as their descriptor size
+func ComputeImageSize(ctx context.Context, manifests distribution.ManifestService, manifest distribution.Manifest) int64 {
+	return imageSize(ctx, manifests, manifest, map[digest.Digest]bool{})
+}
+
+func imageSize(ctx context.Context, manifests distribution.ManifestService, manifest distribution.Manifest, seen map[digest.Digest]bool) int64 {
 	var total int64
+	if _, payload, err := manifest.Payload(); err == nil {
+		total += int64(len(payload))
+	}
 	for _, ref := range manifest.References() {
+		if seen[ref.Digest] {
+			continue
+		}
+		seen[ref.Digest] = true
+		if IsManifestMediaType(ref.MediaType) && manifests != nil {
+			if child, err := manifests.Get(ctx, ref.Digest); err == nil {
+				total += imageSize(ctx, manifests, child, seen)
+				continue
+			}
+		}
 		total += ref.Size
 	}
-	_, payload, err := manifest.Payload()
-	if err == nil {
-		total += int64(len(payload))
-	}
 	return total
 }
 
+// Digests of the manifests an index lists, nil for image manifests
+func IndexChildren(manifest distribution.Manifest) []digest.Digest {
+	switch manifest.(type) {
+	case *manifestlist.DeserializedManifestList, *ocischema.DeserializedImageIndex:
+	default:
+		return nil
+	}
+	var out []digest.Digest
+	for _, ref := range manifest.References() {
+		if IsManifestMediaType(ref.MediaType) {
+			out = append(out, ref.Digest)
+		}
+	}
+	return out
+}
+
 // isUnknownPlatform returns true for buildkit attestation manifests that have
 // "unknown" as both OS and architecture (e.g. SBOM, provenance).
 func isUnknownPlatform(p *ocispec.Platform) bool {
@@ -192,24 +225,33 @@ func EnrichTagPlatforms(ctx context.Context, t *v1.Tag, manifest distribution.Ma
 		if ref.Platform != nil && !isUnknownPlatform(ref.Platform) {
 			t.Platforms = append(t.Platforms, OciPlatformToProto(ref.Platform))
 		} else if IsConfigMediaType(ref.MediaType) {
-			if p := platformFromConfigBlob(ctx, blobStore, ref.Digest); p != nil {
-				t.Platforms = []*v1.Platform{p}
+			if img := configFromBlob(ctx, blobStore, ref.Digest); img != nil {
+				if img.Architecture != "" || img.OS != "" {
+					t.Platforms = []*v1.Platform{OciPlatformToProto(&img.Platform)}
+				}
+				if img.Created != nil && !img.Created.IsZero() {
+					t.CreatedAt = timestamppb.New(*img.Created)
+				}
 			}
 		}
 	}
 
-	if t.PushedAt == nil {
-		if ann := ManifestAnnotations(manifest); ann != nil {
-			if ts, ok := ann[ocispec.AnnotationCreated]; ok {
-				if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+	if ann := ManifestAnnotations(manifest); ann != nil {
+		if ts, ok := ann[ocispec.AnnotationCreated]; ok {
+			if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+				if t.PushedAt == nil {
 					t.PushedAt = timestamppb.New(parsed)
 				}
+				// Indexes carry no config, the annotation is all there is
+				if t.CreatedAt == nil {
+					t.CreatedAt = timestamppb.New(parsed)
+				}
 			}
 		}
 	}
 }
 
-func platformFromConfigBlob(ctx context.Context, blobStore distribution.BlobStore, configDigest digest.Digest) *v1.Platform {
+func configFromBlob(ctx context.Context, blobStore distribution.BlobStore, configDigest digest.Digest) *ocispec.Image {
 	configBlob, err := blobStore.Get(ctx, configDigest)
 	if err != nil {
 		return nil
@@ -218,10 +260,7 @@ func platformFromConfigBlob(ctx context.Context, blobStore distribution.BlobStor
 	if json.Unmarshal(configBlob, &img) != nil {
 		return nil
 	}
-	if img.Architecture == "" && img.OS == "" {
-		return nil
-	}
-	return OciPlatformToProto(&img.Platform)
+	return &img
 }
 
 // Parses raw bytes as an OCI image config
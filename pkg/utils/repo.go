@@ -1,6 +1,13 @@
 package utils
 
-import "strings"
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+)
 
 func SplitRepoName(fullName string) (namespace, name string) {
 	parts := strings.SplitN(fullName, "/", 2)
@@ -9,3 +16,82 @@ func SplitRepoName(fullName string) (namespace, name string) {
 	}
 	return parts[0], parts[1]
 }
+
+// Kind of repository a name is checked for
+type RepoKind int
+
+const (
+	ImageRepo RepoKind = iota
+	ArtifactRepo
+)
+
+// Longest single repository name either kind accepts
+const MaxRepoNameLength = 128
+
+var (
+	// Distribution path component, registry names are lowercase only
+	imageRepoComponent = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	artifactRepoName   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+)
+
+// Names that would shadow a route sharing the repository's path segment
+var reservedRepoNames = map[RepoKind][]string{
+	ArtifactRepo: {"content", "repos", "search"},
+}
+
+// Single source of the repository name policy, applied on create and push
+// and by lookups. Surrounding whitespace is dropped and image names fold
+// to lowercase since the registry cannot store anything else. Artifact
+// names keep their case, lookups and uniqueness ignore it instead
+func NormalizeRepoName(kind RepoKind, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if kind == ImageRepo {
+		name = strings.ToLower(name)
+	}
+	if err := checkRepoName(kind, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func checkRepoName(kind RepoKind, name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("repository name is required")
+	case len(name) > MaxRepoNameLength:
+		return fmt.Errorf("repository name is %d characters, the limit is %d", len(name), MaxRepoNameLength)
+	case strings.ContainsAny(name, `/\`) || name == "." || name == "..":
+		return fmt.Errorf("repository name %q must be a single path segment", name)
+	}
+	if slices.Contains(reservedRepoNames[kind], strings.ToLower(name)) {
+		return fmt.Errorf("repository name %q is reserved", name)
+	}
+	switch kind {
+	case ImageRepo:
+		if !imageRepoComponent.MatchString(name) {
+			return fmt.Errorf("repository name %q may only use lowercase letters and digits, joined by single '.', '_' or '-' separators", name)
+		}
+	case ArtifactRepo:
+		if !artifactRepoName.MatchString(name) {
+			return fmt.Errorf("repository name %q must start with a letter or digit and may only use letters, digits, '.', '_' and '-'", name)
+		}
+	}
+	return nil
+}
+
+// Full registry path as pushed, namespace then one or more components
+func ValidateImagePath(fullName string) error {
+	namespace, rest := SplitRepoName(fullName)
+	if namespace == "" || rest == "" {
+		return fmt.Errorf("image name %q needs a namespace, as namespace/name", fullName)
+	}
+	if len(fullName) > reference.NameTotalLengthMax {
+		return fmt.Errorf("image name is %d characters, the limit is %d", len(fullName), reference.NameTotalLengthMax)
+	}
+	for _, component := range strings.Split(rest, "/") {
+		if err := checkRepoName(ImageRepo, component); err != nil {
+			return err
+		}
+	}
+	return nil
+}
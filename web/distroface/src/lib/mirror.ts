@@ -34,6 +34,16 @@ export const artifactRepoTypeOptions = [
 		label: 'File repository',
 		description: 'Files uploaded by you or CI'
 	},
+	{
+		value: ArtifactRepoType.DEBIAN,
+		label: 'Debian (apt) repository',
+		description: 'Validated .deb uploads, served as a signed apt source'
+	},
+	{
+		value: ArtifactRepoType.RPM,
+		label: 'RPM (yum/dnf) repository',
+		description: 'Validated .rpm uploads, served as a signed yum repo'
+	},
 	{
 		value: ArtifactRepoType.GITHUB_RELEASES,
 		label: 'GitHub releases mirror',
@@ -76,5 +86,9 @@ export function artifactMirrorLabel(t: ArtifactRepoType): string {
 }
 
 export function isMirrorArtifactType(t: ArtifactRepoType): boolean {
-	return t !== ArtifactRepoType.FILE && t !== ArtifactRepoType.UNSPECIFIED;
+	return (
+		t === ArtifactRepoType.GITHUB_RELEASES ||
+		t === ArtifactRepoType.GITLAB_RELEASES ||
+		t === ArtifactRepoType.GITEA_RELEASES
+	);
 }
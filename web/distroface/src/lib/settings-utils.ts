@@ -29,6 +29,12 @@ export const portalScope = (portalId: string): ScopeInit => ({
 	scopeId: portalId
 });
 
+// Image and artifact repos at one namespace/name share the repo tier
+export const repoScope = (namespace: string, name: string): ScopeInit => ({
+	type: SettingsScopeType.REPO,
+	scopeId: `${namespace}/${name}`
+});
+
 // Tier that supplied one resolved field
 export function tierOf(prov: FieldProvenance[], path: string): SettingsTier {
 	return prov.find((p) => p.path === path)?.tier ?? SettingsTier.UNSPECIFIED;
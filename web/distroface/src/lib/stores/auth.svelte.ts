@@ -145,7 +145,10 @@ class AuthStore {
 			inviteCode,
 			invitePin
 		});
+		// Pending accounts get no session until an admin approves them
+		if (resp.pendingApproval) return true;
 		this.setSession(resp.sessionToken, resp.user ?? null, resp.permissions);
+		return false;
 	}
 
 	async logout() {
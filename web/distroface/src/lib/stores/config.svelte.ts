@@ -1,19 +1,29 @@
 import { rpcClient } from '$lib/api/rpc-client';
-import { TLSMode, type Settings } from '$lib/proto/distroface/v1/settings_pb';
+import { TLSMode, type BrandingSettings, type Settings } from '$lib/proto/distroface/v1/settings_pb';
 
 const FALLBACK_HOSTNAME = 'localhost:8080';
 
 // Effective system settings, public subset before sign-in
 class ConfigStore {
 	settings = $state<Settings | undefined>();
+	// Branding of the portal in use, the instance's otherwise
+	branding = $state<BrandingSettings | undefined>();
 
 	async init() {
-		try {
-			const resp = await rpcClient.settings.getEffectiveSettings({});
-			this.settings = resp.settings;
-		} catch {
-			// nada on failure
-		}
+		const [eff, brand] = await Promise.allSettled([
+			rpcClient.settings.getEffectiveSettings({}),
+			rpcClient.settings.getBranding({})
+		]);
+		if (eff.status === 'fulfilled') this.settings = eff.value.settings;
+		if (brand.status === 'fulfilled') this.branding = brand.value.branding;
+	}
+
+	get instanceName(): string {
+		return this.branding?.instanceName || 'Distroface';
+	}
+
+	get logoUrl(): string {
+		return this.branding?.logoUrl || '/adaptive-icon.png';
 	}
 
 	get publicHostname(): string {
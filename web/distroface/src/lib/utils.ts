@@ -86,7 +86,8 @@ export function toggleInArray<T>(arr: T[], item: T): T[] {
 export const webhookEventLabels: Record<number, string> = {
 	[WebhookEvent.PUSH]: 'push',
 	[WebhookEvent.PULL]: 'pull',
-	[WebhookEvent.DELETE]: 'delete'
+	[WebhookEvent.DELETE]: 'delete',
+	[WebhookEvent.COMMENT]: 'comment'
 };
 
 export function orgRoleLabel(role: number): string {